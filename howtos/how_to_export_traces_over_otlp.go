@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+	"solace.dev/go/messaging/pkg/solace/message"
+	"solace.dev/go/messaging/pkg/solace/resource"
+
+	solpropagation "solace.dev/go/messaging-trace/opentelemetry"
+
+	"SolaceSamples.com/PubSub+Go/internal/bootstrap"
+	"SolaceSamples.com/PubSub+Go/internal/tracing"
+	"SolaceSamples.com/PubSub+Go/internal/tracing/otlpstub"
+)
+
+// Code example of how to export the spans of a traced publish and receive over OTLP.
+//
+// An in-process collector stub stands in for an OpenTelemetry collector, so the OTLP
+// path can be tried without running one. The message is published and received on the
+// same connection, then the spans the stub received are printed: the receive span is a
+// child of the publish span because the trace context travelled in the message.
+//
+// Use -protocol http to export with OTLP/HTTP instead of OTLP/gRPC. To export to a real
+// collector run the otel-tracing samples with OTEL_TRACES_EXPORTER=otlp instead.
+
+var otlpProtocol = flag.String("protocol", "grpc", "OTLP protocol to export with, grpc or http")
+
+const tracedTopic = "solace/samples/go/howto/otlp"
+
+func main() {
+	// Load the broker settings from flags, environment, profile file or defaults
+	brokerConfig, err := bootstrap.Load()
+	if err != nil {
+		panic(err)
+	}
+
+	// Start the collector stub and export to it
+	collector, err := otlpstub.Start()
+	if err != nil {
+		panic(err)
+	}
+	defer collector.Close()
+
+	tracingConfig := tracing.Defaults()
+	tracingConfig.ServiceName = "otlp-howto"
+	tracingConfig.Exporter = tracing.ExporterOTLP
+	tracingConfig.Processor = tracing.ProcessorBatch
+	tracingConfig.Insecure = true
+	if *otlpProtocol == "http" {
+		tracingConfig.Protocol = tracing.ProtocolHTTP
+		tracingConfig.Endpoint = collector.HTTPEndpoint()
+	} else {
+		tracingConfig.Endpoint = collector.GRPCEndpoint()
+	}
+	traceProvider, err := tracing.Setup(context.Background(), tracingConfig)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("Tracing: ", tracingConfig)
+
+	// Connect to the messaging service
+	messagingService, err := bootstrap.Connect(brokerConfig)
+	if err != nil {
+		panic(err)
+	}
+	defer messagingService.Disconnect()
+
+	tracer := otel.GetTracerProvider().Tracer("otlpHowtoTracer", trace.WithInstrumentationVersion(solpropagation.Version()))
+
+	// Receive with a consumer span that continues the trace found in the message
+	directReceiver, err := messagingService.CreateDirectMessageReceiverBuilder().
+		WithSubscriptions(resource.TopicSubscriptionOf(tracedTopic)).
+		Build()
+	if err != nil {
+		panic(err)
+	}
+	if err := directReceiver.Start(); err != nil {
+		panic(err)
+	}
+	defer directReceiver.Terminate(1 * time.Second)
+
+	received := make(chan struct{})
+	directReceiver.ReceiveAsync(func(inboundMessage message.InboundMessage) {
+		parentContext := otel.GetTextMapPropagator().Extract(context.Background(), solpropagation.NewInboundMessageCarrier(inboundMessage))
+		_, receiveSpan := tracer.Start(parentContext, tracedTopic+" receive",
+			trace.WithSpanKind(trace.SpanKindConsumer),
+			trace.WithAttributes(messagingAttributes(semconv.MessagingOperationReceive)...))
+		receiveSpan.End()
+		close(received)
+	})
+
+	// Publish with a producer span injected into the message
+	directPublisher, err := messagingService.CreateDirectMessagePublisherBuilder().Build()
+	if err != nil {
+		panic(err)
+	}
+	if err := directPublisher.Start(); err != nil {
+		panic(err)
+	}
+	defer directPublisher.Terminate(1 * time.Second)
+
+	outMessage, err := messagingService.MessageBuilder().BuildWithStringPayload("Hello from the OTLP how-to")
+	if err != nil {
+		panic(err)
+	}
+	publishContext, publishSpan := tracer.Start(context.Background(), tracedTopic+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(messagingAttributes(semconv.MessagingOperationPublish)...))
+	otel.GetTextMapPropagator().Inject(publishContext, solpropagation.NewOutboundMessageCarrier(outMessage))
+	publishErr := directPublisher.Publish(outMessage, resource.TopicOf(tracedTopic))
+	publishSpan.End()
+	if publishErr != nil {
+		panic(publishErr)
+	}
+
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		fmt.Println("The message was not received within 5 seconds")
+	}
+
+	// Shutting the provider down flushes the batch span processor
+	if err := traceProvider.Shutdown(context.Background()); err != nil {
+		panic(err)
+	}
+	waitCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	spans, err := collector.WaitForSpans(waitCtx, 2)
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	fmt.Printf("\nThe collector stub received %d spans:\n", len(spans))
+	for _, span := range spans {
+		fmt.Printf("  %-40s kind=%s trace=%s span=%s parent=%s via %s\n",
+			span.Name, span.Kind, span.TraceID, span.SpanID, span.ParentSpanID, span.Protocol)
+	}
+}
+
+func messagingAttributes(operation attribute.KeyValue) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		semconv.MessagingSystem("PubSub+"),
+		semconv.MessagingDestinationKindTopic,
+		semconv.MessagingDestinationName(tracedTopic),
+		operation,
+	}
+}
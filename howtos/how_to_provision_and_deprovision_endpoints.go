@@ -2,20 +2,13 @@ package main
 
 import (
 	"fmt"
-	"os"
 	"time"
 
-	"solace.dev/go/messaging"
 	"solace.dev/go/messaging/pkg/solace"
 	"solace.dev/go/messaging/pkg/solace/config"
-)
 
-func getEnv(key, def string) string {
-	if val, ok := os.LookupEnv(key); ok {
-		return val
-	}
-	return def
-}
+	"SolaceSamples.com/PubSub+Go/internal/bootstrap"
+)
 
 // Code examples of how to use the Endpoint Provisioner to Provision
 // queues on a Solace broker and Deprovision queues from a Solace broker.
@@ -29,25 +22,18 @@ func getEnv(key, def string) string {
 func main() {
 	// logging.SetLogLevel(logging.LogLevelInfo)
 
-	// Configuration parameters
-	brokerConfig := config.ServicePropertyMap{
-		config.TransportLayerPropertyHost:                getEnv("SOLACE_HOST", "tcp://localhost:55555,tcp://localhost:55554"),
-		config.ServicePropertyVPNName:                    getEnv("SOLACE_VPN", "default"),
-		config.AuthenticationPropertySchemeBasicPassword: getEnv("SOLACE_PASSWORD", "default"),
-		config.AuthenticationPropertySchemeBasicUserName: getEnv("SOLACE_USERNAME", "default"),
-	}
-
-	messagingService, err := messaging.NewMessagingServiceBuilder().
-		FromConfigurationProvider(brokerConfig).
-		WithProvisionTimeoutMs(10 * time.Millisecond). // set a provision timeout on the session
-		Build()
-
+	// Load the broker settings from flags, environment, profile file or defaults
+	brokerConfig, err := bootstrap.Load()
 	if err != nil {
 		panic(err)
 	}
 
-	// Connect to the messaging serice
-	if err := messagingService.Connect(); err != nil {
+	// Connect to the messaging service
+	messagingService, err := bootstrap.Connect(brokerConfig,
+		bootstrap.WithBuilder(func(builder solace.MessagingServiceBuilder) solace.MessagingServiceBuilder {
+			return builder.WithProvisionTimeoutMs(10 * time.Millisecond) // set a provision timeout on the session
+		}))
+	if err != nil {
 		panic(err)
 	}
 
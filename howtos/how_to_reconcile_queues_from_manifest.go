@@ -0,0 +1,103 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"solace.dev/go/messaging/pkg/solace"
+
+	"SolaceSamples.com/PubSub+Go/internal/bootstrap"
+	"SolaceSamples.com/PubSub+Go/internal/provision"
+)
+
+// Code example of how to provision queues declaratively from a YAML manifest.
+//
+// Every queue in the manifest is provisioned with its properties and topic subscriptions:
+// [x] missing queues are created
+// [x] existing queues with the same properties are left alone
+// [x] queues with different properties are reported as a diff against the last applied state,
+//     the broker does not allow their properties to be changed
+// [x] queues applied before but removed from the manifest are deleted with -prune
+//
+// Run it from the repository root, where the default -manifest path is resolved:
+// go run howtos/how_to_reconcile_queues_from_manifest.go -dry-run
+//
+// Subscriptions of an exclusive queue cannot be changed while an application consumes
+// from it; those queues are reported as failed.
+//
+// -dry-run prints the plan from the manifest and the state file without connecting.
+// The exit code is 1 when a change failed and 2 when property mismatches remain.
+
+var (
+	manifestPath = flag.String("manifest", "howtos/fixtures/queues.yaml", "YAML manifest of the queues to provision, relative to the repository root")
+	statePath    = flag.String("state", "queues.state.json", "file recording the last applied manifest")
+	prune        = flag.Bool("prune", false, "deprovision queues that were applied before but are no longer in the manifest")
+	dryRun       = flag.Bool("dry-run", false, "print the planned changes without contacting the broker")
+)
+
+func main() {
+	// logging.SetLogLevel(logging.LogLevelInfo)
+
+	// Load the broker settings from flags, environment, profile file or defaults
+	brokerConfig, err := bootstrap.Load()
+	if err != nil {
+		panic(err)
+	}
+
+	manifest, err := provision.LoadManifest(*manifestPath)
+	if err != nil {
+		panic(err)
+	}
+	state, err := provision.LoadState(*statePath)
+	if err != nil {
+		panic(err)
+	}
+
+	if *dryRun {
+		fmt.Printf("Planned changes for %s (dry run, compared with %s):\n\n", *manifestPath, *statePath)
+		changes := provision.Plan(manifest, state, *prune)
+		provision.Print(os.Stdout, changes)
+		os.Exit(exitCode(changes))
+	}
+
+	// Connect to the messaging service
+	messagingService, err := bootstrap.Connect(brokerConfig,
+		bootstrap.WithBuilder(func(builder solace.MessagingServiceBuilder) solace.MessagingServiceBuilder {
+			return builder.WithProvisionTimeoutMs(10 * time.Second) // set a provision timeout on the session
+		}))
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println("Connected to the broker? ", messagingService.IsConnected())
+
+	changes, next := provision.Apply(messagingService, manifest, state, *prune)
+	fmt.Printf("\nApplied %s:\n\n", *manifestPath)
+	provision.Print(os.Stdout, changes)
+
+	if err := next.Save(*statePath); err != nil {
+		fmt.Println("Could not save the applied state: ", err)
+	}
+
+	// Disconnect the Message Service
+	messagingService.Disconnect()
+	fmt.Println("\nMessaging Service Disconnected? ", !messagingService.IsConnected())
+
+	os.Exit(exitCode(changes))
+}
+
+func exitCode(changes []provision.Change) int {
+	counts, failed := provision.Summary(changes)
+	fmt.Printf("\n%d to create, %d unchanged, %d mismatched, %d to delete, %d orphaned, %d failed\n",
+		counts[provision.ActionCreate], counts[provision.ActionUnchanged], counts[provision.ActionMismatch],
+		counts[provision.ActionDelete], counts[provision.ActionOrphan], failed)
+	switch {
+	case failed > 0:
+		return 1
+	case counts[provision.ActionMismatch] > 0:
+		return 2
+	}
+	return 0
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"solace.dev/go/messaging/pkg/solace"
+	"solace.dev/go/messaging/pkg/solace/config"
+
+	"SolaceSamples.com/PubSub+Go/internal/bootstrap"
+	"SolaceSamples.com/PubSub+Go/internal/lifecycle"
+	"SolaceSamples.com/PubSub+Go/internal/oauth"
+	"SolaceSamples.com/PubSub+Go/internal/oauth/tokenstub"
+)
+
+// Code example of how to keep the OAuth2 tokens of a MessagingService fresh.
+//
+// A token manager fetches tokens with the client credentials grant, or reads them from
+// files kept up to date by another process, and pushes them to the service with
+// UpdateProperty ahead of their expiry, see update_service_properties.go. The broker
+// disconnects a client whose token expired, and the service then reconnects with the
+// latest token it was given.
+//
+// Without -token-url or -token-file an in-process token endpoint stub issues tokens that
+// expire after 10 seconds, which a broker will not accept. Without -connect the tokens
+// are printed instead of pushed, pass it when the broker trusts the token issuer and is
+// reached over TLS.
+
+// Token settings, parsed together with the broker flags
+var (
+	tokenURL     = flag.String("token-url", "", "token endpoint to fetch tokens from with the client credentials grant")
+	clientID     = flag.String("client-id", "solace-samples", "OAuth2 client ID")
+	clientSecret = flag.String("client-secret", "secret", "OAuth2 client secret")
+	scopes       = flag.String("scopes", "openid", "space separated scopes to request")
+	tokenFile    = flag.String("token-file", "", "file holding the access token, read again when it changes")
+	idTokenFile  = flag.String("id-token-file", "", "file holding the OpenID Connect ID token, with -token-file")
+	connect      = flag.Bool("connect", false, "connect to the broker over TLS with the tokens")
+	runFor       = flag.Duration("duration", 30*time.Second, "how long to keep refreshing, 0 until interrupted")
+)
+
+func main() {
+	// Load the broker settings from flags, environment, profile file or defaults
+	brokerConfig, err := bootstrap.Load()
+	if err != nil {
+		panic(err)
+	}
+
+	// Choose where the tokens come from
+	var source oauth.Source
+	switch {
+	case *tokenFile != "":
+		source = &oauth.File{AccessTokenPath: *tokenFile, IDTokenPath: *idTokenFile}
+	case *tokenURL != "":
+		source = &oauth.ClientCredentials{TokenURL: *tokenURL, ClientID: *clientID, ClientSecret: *clientSecret, Scopes: strings.Fields(*scopes)}
+	default:
+		stub, err := tokenstub.Start(*clientID, *clientSecret, 10*time.Second)
+		if err != nil {
+			panic(err)
+		}
+		defer stub.Close()
+		fmt.Println("Token endpoint stub: ", stub.TokenURL())
+		source = &oauth.ClientCredentials{TokenURL: stub.TokenURL(), ClientID: *clientID, ClientSecret: *clientSecret, Scopes: strings.Fields(*scopes)}
+	}
+
+	// The service is built with the first token. The broker only accepts OAuth2 on TLS sessions
+	initialToken, err := source.Token(context.Background())
+	if err != nil {
+		panic(err)
+	}
+	var messagingService solace.MessagingService
+	var updater oauth.PropertyUpdater = tokenPrinter{}
+	if *connect {
+		messagingService, err = bootstrap.Connect(brokerConfig, bootstrap.WithBuilder(func(builder solace.MessagingServiceBuilder) solace.MessagingServiceBuilder {
+			return builder.WithAuthenticationStrategy(config.OAuth2Authentication(initialToken.AccessToken, initialToken.IDToken, ""))
+		}))
+		if err != nil {
+			panic(err)
+		}
+		fmt.Println("Connected to the broker? ", messagingService.IsConnected())
+		updater = messagingService
+	}
+
+	// Replace the tokens ahead of their expiry and report failed refreshes
+	tokenManager := oauth.NewManager(updater, source,
+		oauth.WithToken(initialToken),
+		oauth.WithRefreshAhead(30*time.Second),
+		oauth.OnRefresh(func(token *oauth.Token) {
+			if expiry, ok := oauth.JWTExpiry(token.AccessToken); ok {
+				fmt.Printf("Pushed a new access token, it expires at %s\n", expiry.Format(time.TimeOnly))
+			} else {
+				fmt.Println("Pushed a new access token")
+			}
+		}),
+		oauth.OnFailure(func(err error) {
+			fmt.Println("Token refresh failed: ", err)
+		}))
+
+	// Refresh in the background until interrupted or the duration has passed, then disconnect
+	runner := lifecycle.NewRunner(messagingService, lifecycle.WithGracePeriod(1*time.Second))
+	runner.Go(tokenManager.Run)
+	ctx := context.Background()
+	if *runFor > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *runFor)
+		defer cancel()
+	}
+	exitCode := runner.Run(ctx)
+
+	fmt.Println("Token refreshes: ", tokenManager.Stats())
+	if exitCode != 0 {
+		panic(fmt.Sprintf("exit code %d", exitCode))
+	}
+}
+
+// tokenPrinter stands in for the service when not connecting
+type tokenPrinter struct{}
+
+func (tokenPrinter) UpdateProperty(property config.ServiceProperty, value interface{}) error {
+	token := fmt.Sprint(value)
+	fmt.Printf("UpdateProperty(%s, %.16s...)\n", property, token)
+	return nil
+}
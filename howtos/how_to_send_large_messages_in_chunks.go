@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"flag"
+	"fmt"
+	"time"
+
+	"solace.dev/go/messaging/pkg/solace/config"
+	"solace.dev/go/messaging/pkg/solace/message"
+	"solace.dev/go/messaging/pkg/solace/resource"
+
+	"SolaceSamples.com/PubSub+Go/internal/bootstrap"
+	"SolaceSamples.com/PubSub+Go/internal/chunk"
+)
+
+// Code example of how to send a payload larger than the maximum message size of a queue.
+//
+// The payload is split into parts that are published as persistent messages with the
+// transfer ID, part index, number of parts and checksum in user properties. The receiver
+// reassembles the parts, verifies the checksum and acknowledges every part only after the
+// whole payload was handled. Transfers missing a part when the timeout passes are rejected.
+
+// Transfer settings, parsed together with the broker flags
+var (
+	payloadSize = flag.Int("size", 5000000, "size in bytes of the payload to send")
+	partSize    = flag.Int("part-size", chunk.DefaultPartSize, "maximum size in bytes of each part")
+)
+
+const chunkedTopic = "solace/samples/go/howto/chunks"
+
+func main() {
+	// Load the broker settings from flags, environment, profile file or defaults
+	brokerConfig, err := bootstrap.Load()
+	if err != nil {
+		panic(err)
+	}
+
+	// Connect to the messaging service
+	messagingService, err := bootstrap.Connect(brokerConfig)
+	if err != nil {
+		panic(err)
+	}
+	defer messagingService.Disconnect()
+
+	// Receive the parts on a queue, acknowledging them only after reassembly
+	persistentReceiver, err := messagingService.CreatePersistentMessageReceiverBuilder().
+		WithMessageClientAcknowledgement().
+		WithRequiredMessageOutcomeSupport(config.PersistentReceiverFailedOutcome, config.PersistentReceiverRejectedOutcome).
+		WithMissingResourcesCreationStrategy(config.PersistentReceiverCreateOnStartMissingResources).
+		WithSubscriptions(resource.TopicSubscriptionOf(chunkedTopic)).
+		Build(resource.QueueNonDurableExclusive("chunks-howto"))
+	if err != nil {
+		panic(err)
+	}
+	if err := persistentReceiver.Start(); err != nil {
+		panic(err)
+	}
+	defer persistentReceiver.Terminate(1 * time.Second)
+
+	chunkReceiver := chunk.NewReceiver(persistentReceiver, 10*time.Second, chunk.OnDiscard(func(err error) {
+		fmt.Println("Discarded: ", err)
+	}))
+	received := make(chan []byte, 1)
+	chunkReceiver.ReceiveAsync(func(msg message.InboundMessage) error {
+		reassembled, ok := msg.(*chunk.Message)
+		if !ok {
+			fmt.Println("Received a message that is not part of a transfer")
+			return nil
+		}
+		payload, _ := reassembled.GetPayloadAsBytes()
+		fmt.Printf("Reassembled transfer %s from %d parts, %d bytes\n", reassembled.TransferID(), len(reassembled.Parts()), len(payload))
+		received <- payload
+		return nil
+	})
+
+	// Publish the payload in parts
+	persistentPublisher, err := messagingService.CreatePersistentMessagePublisherBuilder().Build()
+	if err != nil {
+		panic(err)
+	}
+	if err := persistentPublisher.Start(); err != nil {
+		panic(err)
+	}
+	defer persistentPublisher.Terminate(1 * time.Second)
+
+	chunkPublisher := chunk.NewPublisher(persistentPublisher, messagingService.MessageBuilder, *partSize, func(receipt chunk.TransferReceipt) {
+		if receipt.Err != nil {
+			fmt.Println("Transfer failed: ", receipt.Err)
+			return
+		}
+		fmt.Printf("Transfer %s persisted in %d parts\n", receipt.TransferID, receipt.Parts)
+	})
+
+	payload := make([]byte, *payloadSize)
+	if _, err := rand.Read(payload); err != nil {
+		panic(err)
+	}
+	properties := config.MessagePropertyMap{config.MessagePropertyHTTPContentType: "application/octet-stream"}
+	transferID, err := chunkPublisher.Publish(payload, resource.TopicOf(chunkedTopic), properties, nil)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("Publishing transfer %s of %d bytes\n", transferID, len(payload))
+
+	drainCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := chunkPublisher.Drain(drainCtx); err != nil {
+		fmt.Println(err)
+	}
+
+	select {
+	case reassembled := <-received:
+		fmt.Println("Payload intact? ", bytes.Equal(reassembled, payload))
+	case <-time.After(10 * time.Second):
+		fmt.Println("The transfer was not reassembled within 10 seconds")
+	}
+	fmt.Println("Publisher: ", chunkPublisher.Stats())
+	fmt.Println("Receiver: ", chunkReceiver.Stats())
+}
@@ -2,10 +2,13 @@
 package main
 
 import (
-    "solace.dev/go/messaging"
+    "fmt"
+
     "solace.dev/go/messaging/pkg/solace/config"
     "solace.dev/go/messaging/pkg/solace"
     "solace.dev/go/messaging/pkg/solace/message"
+
+    "SolaceSamples.com/PubSub+Go/internal/bootstrap"
 )
 
 
@@ -33,14 +36,12 @@ func Run() {
     var service solace.MessagingService
     var err error
 
-    brokerConfig := config.ServicePropertyMap{
-        config.TransportLayerPropertyHost:  getEnv("SOLACE_HOST", "tcp://localhost:55555,tcp://localhost:55554"),
-        config.ServicePropertyVPNName:  getEnv("SOLACE_VPN", "default"),
-        config.AuthenticationPropertySchemeBasicPassword:   getEnv("SOLACE_PASSWORD", "default"),
-        config.AuthenticationPropertySchemeBasicUserName:   getEnv("SOLACE_USERNAME", "default"),
-        }
+    brokerConfig, err := bootstrap.Load()
+    if err != nil {
+        panic(err)
+    }
 
-    service, err = messaging.NewMessagingServiceBuilder().FromConfigurationProvider(brokerConfig).Build()
+    service, err = bootstrap.Build(brokerConfig)
 
     if err != nil {
         panic(err)
@@ -53,4 +54,7 @@ func Run() {
     messageWithPropertyPartitionKey := SetQueuePartitionKeyUsingWithProperty(partitionKeyValue, service, payload)
 
     messageWithFromConfigPartitionKey := SetQueuePartitionKeyUsingFromConfigurationProvider(partitionKeyValue, service, payload)
+
+    fmt.Println("Message with partition key set by WithProperty: ", messageWithPropertyPartitionKey)
+    fmt.Println("Message with partition key set by FromConfigurationProvider: ", messageWithFromConfigPartitionKey)
 }
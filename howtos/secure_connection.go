@@ -1,15 +1,29 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"os"
 	"os/signal"
 	"time"
 
-	"solace.dev/go/messaging"
+	"solace.dev/go/messaging/pkg/solace"
 	"solace.dev/go/messaging/pkg/solace/config"
 	"solace.dev/go/messaging/pkg/solace/message"
 	"solace.dev/go/messaging/pkg/solace/resource"
+
+	"SolaceSamples.com/PubSub+Go/internal/bootstrap"
+	"SolaceSamples.com/PubSub+Go/internal/certs"
+	"SolaceSamples.com/PubSub+Go/internal/tlsprofile"
+)
+
+// Client certificate settings, parsed together with the broker flags
+var (
+	clientCert   = flag.String("client-cert", "", "client certificate PEM file, may also hold the key, enables client certificate authentication")
+	clientKey    = flag.String("client-key", "", "private key PEM file, the -client-cert file when empty")
+	keyPassword  = flag.String("key-password", "", "password of an encrypted private key")
+	certUsername = flag.String("cert-username", "", "client username the certificate common name must match, the common name when empty")
 )
 
 // Message Handler
@@ -17,75 +31,110 @@ func MessageHandler(message message.InboundMessage) {
 	fmt.Printf("Message Dump %s \n", message)
 }
 
-func getEnv(key, def string) string {
-	if val, ok := os.LookupEnv(key); ok {
-		return val
-	}
-	return def
-}
-
 func main() {
 
 	// Define Topic Subscriptions
 	TOPIC_PREFIX := "solace/samples"
 
-	// Configuration parameters
-	brokerConfig := config.ServicePropertyMap{
-		config.TransportLayerPropertyHost:                getEnv("SOLACE_HOST", "tcp://localhost:55554"),
-		config.ServicePropertyVPNName:                    getEnv("SOLACE_VPN", "default"),
-		config.AuthenticationPropertySchemeBasicPassword: getEnv("SOLACE_PASSWORD", "default"),
-		config.AuthenticationPropertySchemeBasicUserName: getEnv("SOLACE_USERNAME", "default"),
+	// Load the broker settings from flags, environment, profile file or defaults
+	brokerConfig, err := bootstrap.Load()
+	if err != nil {
+		panic(err)
 	}
 
-	// Skip certificate validation
-	messagingService, err := messaging.NewMessagingServiceBuilder().
-		FromConfigurationProvider(brokerConfig).
-		WithTransportSecurityStrategy(config.NewTransportSecurityStrategy().WithoutCertificateValidation()).
-		Build()
-
-	// With Certificate Validation. Note: assuming ou have a /trust_store dir with the .pem file stored in it
-	// messagingService, err := messaging.NewMessagingServiceBuilder().
-	// 	FromConfigurationProvider(brokerConfig).
-	// 	WithTransportSecurityStrategy(config.NewTransportSecurityStrategy().WithCertificateValidation(false, true, "howtos/fixtures/", "")).
-	// 	Build()
-
-	// With Client Certificate Authentication (mTLS)
-	// This demonstrates mutual TLS authentication where both client and server authenticate each other
-	//
-	// Certificate Setup Steps:
-	// 1. Generate a private key and self-signed certificate in one command:
-	//    openssl req -x509 -newkey rsa:2048 -keyout private.key -out certificate.pem -days 365 -nodes && cat private.key certificate.pem > combined_cert_and_key.pem
-	//
-	// 2. Place the combined certificate file in howtos/fixtures/ directory:
-	//    cp combined_cert_and_key.pem howtos/fixtures/api-client.pem
-	//
-	// 3. Download your broker's CA certificate to howtos/fixtures/ directory:
-	//    For Solace Cloud: Download DigiCertGlobalRootG2.crt.pem from the broker's "Connect" tab
-	//    For on-premise: Get the CA certificate from your broker administrator
-	//
-	// Broker Configuration Requirements:
-	// 4. Add your client certificate to the broker's trusted certificate list
-	// 5. Add the client certificate CN (Common Name) as a client username on the broker
-	// 6. Enable client certificate authentication on your broker's Message VPN
-	//
-	// Usage with combined certificate and key in single PEM file:
-	// messagingService, err := messaging.NewMessagingServiceBuilder().
-	// 	FromConfigurationProvider(brokerConfig).
-	// 	WithTransportSecurityStrategy(config.NewTransportSecurityStrategy().WithCertificateValidation(false, true, "./howtos/fixtures/", "")).
-	// 	WithAuthenticationStrategy(config.ClientCertificateAuthentication("howtos/fixtures/api-client.pem", "howtos/fixtures/api-client.pem", "")).
-	// 	Build()
-
+	// Validate the broker certificate and host name against the trust store, with TLS 1.2 or newer.
+	// Skipping the validation, e.g. for a local broker with a self-signed certificate,
+	// needs both -tls-skip-validation and -tls-allow-insecure
+	tlsProfile, err := tlsprofile.Load()
+	if err != nil {
+		panic(err)
+	}
+	if tlsProfile.TrustStore == "" {
+		tlsProfile.TrustStore = "howtos/fixtures/"
+	}
+	transportSecurity, err := tlsProfile.Strategy()
 	if err != nil {
 		panic(err)
 	}
 
-	// Connect to the messaging serice
-	if err := messagingService.Connect(); err != nil {
+	var messagingService solace.MessagingService
+	var certManager *certs.Manager
+	if *clientCert == "" {
+		messagingService, err = bootstrap.Connect(brokerConfig,
+			bootstrap.WithBuilder(func(builder solace.MessagingServiceBuilder) solace.MessagingServiceBuilder {
+				return builder.WithTransportSecurityStrategy(transportSecurity)
+			}))
+	} else {
+		// With Client Certificate Authentication (mTLS)
+		// This demonstrates mutual TLS authentication where both client and server authenticate each other
+		//
+		// Certificate Setup Steps:
+		// 1. Generate a private key and self-signed certificate in one command:
+		//    openssl req -x509 -newkey rsa:2048 -keyout private.key -out certificate.pem -days 365 -nodes && cat private.key certificate.pem > combined_cert_and_key.pem
+		//
+		// 2. Place the combined certificate file in howtos/fixtures/ directory:
+		//    cp combined_cert_and_key.pem howtos/fixtures/api-client.pem
+		//
+		// 3. Download your broker's CA certificate to howtos/fixtures/ directory:
+		//    For Solace Cloud: Download DigiCertGlobalRootG2.crt.pem from the broker's "Connect" tab
+		//    For on-premise: Get the CA certificate from your broker administrator
+		//
+		// Broker Configuration Requirements:
+		// 4. Add your client certificate to the broker's trusted certificate list
+		// 5. Add the client certificate CN (Common Name) as a client username on the broker
+		// 6. Enable client certificate authentication on your broker's Message VPN
+		//
+		// Usage: go run secure_connection.go -client-cert howtos/fixtures/api-client.pem
+		//
+		// The certificate manager validates the certificate, key and trust store before connecting,
+		// warns ahead of their expiry and hands rotated files to the service for its next reconnect
+		paths := certs.Paths{CertFile: *clientCert, KeyFile: *clientKey, KeyPassword: *keyPassword, TrustStore: tlsProfile.TrustStore}
+		certManager = certs.NewManager(nil, paths,
+			certs.WithUsername(*certUsername),
+			certs.OnExpiring(func(status *certs.Status) {
+				fmt.Printf("WARNING: TLS material expires in %s: %s\n", time.Until(status.Expiry()).Round(time.Hour), status)
+			}),
+			certs.OnRotate(func(rotation certs.Rotation) {
+				fmt.Println("Rotated TLS material, used from the next reconnect: ", rotation.Status)
+				if !rotation.TrustStoreApplied {
+					fmt.Println("CA certificates rotated in the trust store bundle are used after a restart")
+				}
+			}),
+			certs.OnFailure(func(err error) {
+				fmt.Println("Rotated TLS material not used: ", err)
+			}))
+		status, err := certManager.Check()
+		if err != nil {
+			panic(err)
+		}
+		fmt.Println("TLS material: ", status)
+
+		messagingService, err = bootstrap.Connect(brokerConfig,
+			bootstrap.WithBuilder(func(builder solace.MessagingServiceBuilder) solace.MessagingServiceBuilder {
+				builder = builder.
+					WithTransportSecurityStrategy(transportSecurity).
+					WithAuthenticationStrategy(config.ClientCertificateAuthentication(paths.CertFile, paths.KeyPath(), paths.KeyPassword))
+				if *certUsername != "" {
+					builder = builder.FromConfigurationProvider(config.ServicePropertyMap{config.AuthenticationPropertySchemeClientCertUserName: *certUsername})
+				}
+				return builder
+			}))
+	}
+
+	if err != nil {
 		panic(err)
 	}
 
 	fmt.Println("Connected to the broker? ", messagingService.IsConnected())
 
+	// Watch the TLS material until the end
+	ctx, stopWatching := context.WithCancel(context.Background())
+	defer stopWatching()
+	if certManager != nil {
+		certManager.SetService(messagingService)
+		go certManager.Run(ctx)
+	}
+
 	// Define Topic Subscriptions
 	topics := [...]string{TOPIC_PREFIX + "/>", TOPIC_PREFIX + "/direct/sub/*"}
 	topics_sup := make([]resource.Subscription, len(topics))
@@ -131,6 +180,7 @@ func main() {
 
 	// Block until a signal is received.
 	<-c
+	stopWatching()
 
 	// Terminate the Direct Receiver
 	directReceiver.Terminate(1 * time.Second)
@@ -138,5 +188,7 @@ func main() {
 	// Disconnect the Message Service
 	messagingService.Disconnect()
 	fmt.Println("Messaging Service Disconnected? ", !messagingService.IsConnected())
+	// Remove the copy of a trust store bundle once the service no longer reconnects
+	tlsProfile.Close()
 
 }
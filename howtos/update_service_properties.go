@@ -1,10 +1,12 @@
 // This file contains an example of how to use the UpdateProperty method to update the OAuth tokens of a MessagingService.
+// how_to_refresh_oauth2_tokens.go keeps the tokens fresh in the background instead.
 package main
 
 import (
-        "solace.dev/go/messaging"
         "solace.dev/go/messaging/pkg/solace"
         "solace.dev/go/messaging/pkg/solace/config"
+
+        "SolaceSamples.com/PubSub+Go/internal/bootstrap"
 )
 
 func UpdateOAuth2Tokens(messagingService solace.MessagingService, newAccessToken string, newIDToken string) (err error) {
@@ -39,19 +41,19 @@ func Run () {
         var err error
         var messagingService solace.MessagingService
 
-        brokerConfig := config.ServicePropertyMap{
-                config.TransportLayerPropertyHost:                getEnv("SOLACE_HOST", "tcp://localhost:55555,tcp://localhost:55554"),
-                config.ServicePropertyVPNName:                    getEnv("SOLACE_VPN", "default"),
-                config.AuthenticationPropertySchemeBasicPassword: getEnv("SOLACE_PASSWORD", "default"),
-                config.AuthenticationPropertySchemeBasicUserName: getEnv("SOLACE_USERNAME", "default"),
+        brokerConfig, err := bootstrap.Load()
+        if err != nil {
+                panic(err)
         }
 
         // Initialize the messaging service with invalid tokens
-        messagingService, err = messaging.NewMessagingServiceBuilder().FromConfigurationProvider(brokerConfig).WithAuthenticationStrategy(config.OAuth2Authentication(
+        messagingService, err = bootstrap.Build(brokerConfig, bootstrap.WithBuilder(func(builder solace.MessagingServiceBuilder) solace.MessagingServiceBuilder {
+                return builder.WithAuthenticationStrategy(config.OAuth2Authentication(
                         "invalid access token",
                         "invalid id token",
                         "",
-                )).Build()
+                ))
+        }))
 
         // There should not be any errors when building the service in this way
         if err != nil {
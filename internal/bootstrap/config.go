@@ -0,0 +1,239 @@
+// Package bootstrap loads the broker connection settings shared by the samples
+// and turns them into a connected solace.MessagingService.
+//
+// Settings are resolved with the following precedence, highest first:
+//
+//  1. command line flags: -host, -vpn, -username, -password
+//  2. environment variables: SOLACE_HOST, SOLACE_VPN, SOLACE_USERNAME, SOLACE_PASSWORD
+//  3. a YAML or JSON profile file named by the -profile flag or SOLACE_PROFILE
+//  4. the values returned by Defaults
+//
+// A profile file uses the same keys as Config, for example:
+//
+//	host: tcps://mr-connection.messaging.solace.cloud:55443
+//	vpn: my-vpn
+//	username: solace-cloud-client
+//	password: secret
+package bootstrap
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	"solace.dev/go/messaging/pkg/solace/config"
+)
+
+// Environment variables read by the loader
+const (
+	EnvHost     = "SOLACE_HOST"
+	EnvVPN      = "SOLACE_VPN"
+	EnvUsername = "SOLACE_USERNAME"
+	EnvPassword = "SOLACE_PASSWORD"
+	EnvProfile  = "SOLACE_PROFILE"
+)
+
+// Config holds the settings needed to connect to a broker.
+type Config struct {
+	Host     string `yaml:"host" json:"host"`
+	VPN      string `yaml:"vpn" json:"vpn"`
+	Username string `yaml:"username" json:"username"`
+	Password string `yaml:"password" json:"password"`
+}
+
+// Defaults returns the settings used when nothing else is configured.
+// They match a local broker started with the default docker image.
+func Defaults() Config {
+	return Config{
+		Host:     "tcp://localhost:55555,tcp://localhost:55554",
+		VPN:      "default",
+		Username: "default",
+		Password: "default",
+	}
+}
+
+// Validate reports every problem found in the configuration.
+func (c Config) Validate() error {
+	var errs []error
+	if strings.TrimSpace(c.Host) == "" {
+		errs = append(errs, errors.New("host must not be empty"))
+	} else {
+		for _, host := range strings.Split(c.Host, ",") {
+			if err := validateHost(strings.TrimSpace(host)); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	if strings.TrimSpace(c.VPN) == "" {
+		errs = append(errs, errors.New("vpn must not be empty"))
+	}
+	if strings.TrimSpace(c.Username) == "" {
+		errs = append(errs, errors.New("username must not be empty"))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid broker configuration: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// validateHost checks a single entry of the comma separated host list
+func validateHost(host string) error {
+	if host == "" {
+		return errors.New("host list contains an empty entry")
+	}
+	if !strings.Contains(host, "://") {
+		// plain host[:port], the API defaults to tcp
+		return nil
+	}
+	uri, err := url.Parse(host)
+	if err != nil {
+		return fmt.Errorf("host %q: %w", host, err)
+	}
+	switch uri.Scheme {
+	case "tcp", "tcps", "ws", "wss", "http", "https":
+	default:
+		return fmt.Errorf("host %q: unsupported scheme %q", host, uri.Scheme)
+	}
+	if uri.Hostname() == "" {
+		return fmt.Errorf("host %q: missing host name", host)
+	}
+	return nil
+}
+
+// ServiceProperties converts the configuration into the property map expected by
+// MessagingServiceBuilder.FromConfigurationProvider.
+func (c Config) ServiceProperties() config.ServicePropertyMap {
+	return config.ServicePropertyMap{
+		config.TransportLayerPropertyHost:                c.Host,
+		config.ServicePropertyVPNName:                    c.VPN,
+		config.AuthenticationPropertySchemeBasicPassword: c.Password,
+		config.AuthenticationPropertySchemeBasicUserName: c.Username,
+	}
+}
+
+// String prints the configuration with the password masked.
+func (c Config) String() string {
+	password := ""
+	if c.Password != "" {
+		password = "****"
+	}
+	return fmt.Sprintf("host=%s vpn=%s username=%s password=%s", c.Host, c.VPN, c.Username, password)
+}
+
+// merge overrides the receiver with every non empty field of other
+func (c *Config) merge(other Config) {
+	if other.Host != "" {
+		c.Host = other.Host
+	}
+	if other.VPN != "" {
+		c.VPN = other.VPN
+	}
+	if other.Username != "" {
+		c.Username = other.Username
+	}
+	if other.Password != "" {
+		c.Password = other.Password
+	}
+}
+
+// LoadProfile reads a profile file. Files ending in .json are decoded as JSON,
+// everything else as YAML.
+func LoadProfile(path string) (Config, error) {
+	var profile Config
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return profile, fmt.Errorf("reading profile: %w", err)
+	}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.Unmarshal(data, &profile)
+	} else {
+		err = yaml.Unmarshal(data, &profile)
+	}
+	if err != nil {
+		return profile, fmt.Errorf("decoding profile %s: %w", path, err)
+	}
+	return profile, nil
+}
+
+// Flags holds the connection flags registered on a flag.FlagSet.
+type Flags struct {
+	fs       *flag.FlagSet
+	host     string
+	vpn      string
+	username string
+	password string
+	profile  string
+}
+
+// BindFlags registers the connection flags on fs. Call Config once fs has been parsed.
+func BindFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{fs: fs}
+	fs.StringVar(&f.host, "host", "", "broker host list, overrides "+EnvHost)
+	fs.StringVar(&f.vpn, "vpn", "", "message VPN name, overrides "+EnvVPN)
+	fs.StringVar(&f.username, "username", "", "client username, overrides "+EnvUsername)
+	fs.StringVar(&f.password, "password", "", "client password, overrides "+EnvPassword)
+	fs.StringVar(&f.profile, "profile", "", "YAML or JSON profile file, overrides "+EnvProfile)
+	return f
+}
+
+// Config resolves the final configuration from the parsed flags, the environment,
+// the profile file and the defaults, and validates the result.
+func (f *Flags) Config() (Config, error) {
+	cfg := Defaults()
+
+	profilePath := os.Getenv(EnvProfile)
+	if f.profile != "" {
+		profilePath = f.profile
+	}
+	if profilePath != "" {
+		profile, err := LoadProfile(profilePath)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.merge(profile)
+	}
+
+	// an environment variable that is set wins over the profile, even when empty
+	for name, field := range map[string]*string{
+		EnvHost:     &cfg.Host,
+		EnvVPN:      &cfg.VPN,
+		EnvUsername: &cfg.Username,
+		EnvPassword: &cfg.Password,
+	} {
+		if val, ok := os.LookupEnv(name); ok {
+			*field = val
+		}
+	}
+
+	// only flags given on the command line take part, so that an empty default
+	// does not hide the other sources
+	f.fs.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "host":
+			cfg.Host = f.host
+		case "vpn":
+			cfg.VPN = f.vpn
+		case "username":
+			cfg.Username = f.username
+		case "password":
+			cfg.Password = f.password
+		}
+	})
+
+	return cfg, cfg.Validate()
+}
+
+// Load binds the connection flags on flag.CommandLine, parses the command line and
+// resolves the configuration. Samples that define flags of their own must register
+// them before calling Load.
+func Load() (Config, error) {
+	flags := BindFlags(flag.CommandLine)
+	flag.Parse()
+	return flags.Config()
+}
@@ -0,0 +1,134 @@
+package bootstrap
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// setEnv unsets the variables read by the loader, then sets vars for the test
+func setEnv(t *testing.T, vars map[string]string) {
+	t.Helper()
+	for _, name := range []string{EnvHost, EnvVPN, EnvUsername, EnvPassword, EnvProfile} {
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+	for name, val := range vars {
+		t.Setenv(name, val)
+	}
+}
+
+func TestFlagsConfig(t *testing.T) {
+	dir := t.TempDir()
+	yamlProfile := filepath.Join(dir, "broker.yaml")
+	os.WriteFile(yamlProfile, []byte("host: tcps://profile:55443\nvpn: profile-vpn\nusername: profile-user\npassword: profile-secret\n"), 0o600)
+	jsonProfile := filepath.Join(dir, "broker.json")
+	os.WriteFile(jsonProfile, []byte(`{"host": "tcp://json-profile:55555", "vpn": "json-vpn"}`), 0o600)
+	otherProfile := filepath.Join(dir, "other.yaml")
+	os.WriteFile(otherProfile, []byte("vpn: other-vpn\n"), 0o600)
+	invalidProfile := filepath.Join(dir, "invalid.json")
+	os.WriteFile(invalidProfile, []byte(`{"host": `), 0o600)
+
+	defaults := Defaults()
+	tests := []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		want    Config
+		wantErr string
+	}{
+		{name: "defaults", want: defaults},
+		{
+			name: "profile over defaults",
+			env:  map[string]string{EnvProfile: yamlProfile},
+			want: Config{Host: "tcps://profile:55443", VPN: "profile-vpn", Username: "profile-user", Password: "profile-secret"},
+		},
+		{
+			name: "partial JSON profile keeps the other defaults",
+			args: []string{"-profile", jsonProfile},
+			want: Config{Host: "tcp://json-profile:55555", VPN: "json-vpn", Username: defaults.Username, Password: defaults.Password},
+		},
+		{
+			name: "profile flag over profile variable",
+			args: []string{"-profile", otherProfile},
+			env:  map[string]string{EnvProfile: yamlProfile},
+			want: Config{Host: defaults.Host, VPN: "other-vpn", Username: defaults.Username, Password: defaults.Password},
+		},
+		{
+			name: "environment over profile",
+			env:  map[string]string{EnvProfile: yamlProfile, EnvHost: "tcp://env:55555", EnvUsername: "env-user"},
+			want: Config{Host: "tcp://env:55555", VPN: "profile-vpn", Username: "env-user", Password: "profile-secret"},
+		},
+		{
+			name: "empty environment variable over profile",
+			env:  map[string]string{EnvProfile: yamlProfile, EnvPassword: ""},
+			want: Config{Host: "tcps://profile:55443", VPN: "profile-vpn", Username: "profile-user", Password: ""},
+		},
+		{
+			name: "flags over environment and profile",
+			args: []string{"-host", "tcp://flag:55555", "-vpn", "flag-vpn", "-password", ""},
+			env:  map[string]string{EnvProfile: yamlProfile, EnvHost: "tcp://env:55555", EnvVPN: "env-vpn", EnvUsername: "env-user"},
+			want: Config{Host: "tcp://flag:55555", VPN: "flag-vpn", Username: "env-user", Password: ""},
+		},
+		{name: "missing profile", args: []string{"-profile", filepath.Join(dir, "missing.yaml")}, wantErr: "reading profile"},
+		{name: "invalid profile", env: map[string]string{EnvProfile: invalidProfile}, wantErr: "decoding profile"},
+		{name: "empty host", args: []string{"-host", ""}, wantErr: "host must not be empty"},
+		{name: "empty vpn from the environment", env: map[string]string{EnvVPN: " "}, wantErr: "vpn must not be empty"},
+		{name: "unsupported scheme", args: []string{"-host", "tcp://a:1, ftp://b"}, wantErr: `unsupported scheme "ftp"`},
+		{name: "empty host list entry", args: []string{"-host", "tcp://a:1,,tcp://b:2"}, wantErr: "empty entry"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setEnv(t, tt.env)
+			fs := flag.NewFlagSet("sample", flag.ContinueOnError)
+			flags := BindFlags(fs)
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			got, err := flags.Config()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("error %v, want it to mention %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("config %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	setEnv(t, map[string]string{EnvHost: "tcp://env:55555", EnvVPN: "env-vpn"})
+	args, commandLine := os.Args, flag.CommandLine
+	defer func() { os.Args, flag.CommandLine = args, commandLine }()
+	flag.CommandLine = flag.NewFlagSet("sample", flag.ContinueOnError)
+	verbose := flag.Bool("verbose", false, "a flag of the sample")
+	os.Args = []string{"sample", "-verbose", "-vpn", "flag-vpn"}
+
+	got, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Defaults()
+	want.Host, want.VPN = "tcp://env:55555", "flag-vpn"
+	if got != want || !*verbose {
+		t.Errorf("config %+v, verbose %v, want %+v with the sample flag parsed", got, *verbose, want)
+	}
+}
+
+func TestString(t *testing.T) {
+	cfg := Config{Host: "tcp://a:1", VPN: "v", Username: "u", Password: "secret"}
+	if s := cfg.String(); strings.Contains(s, "secret") || !strings.Contains(s, "password=****") {
+		t.Errorf("String() = %s, want the password masked", s)
+	}
+	if props := cfg.ServiceProperties(); len(props) != 4 {
+		t.Errorf("service properties %v", props)
+	}
+}
@@ -0,0 +1,75 @@
+package bootstrap
+
+import (
+	"fmt"
+
+	"solace.dev/go/messaging"
+	"solace.dev/go/messaging/pkg/solace"
+)
+
+// Option customises how the messaging service is built and connected.
+type Option func(*options)
+
+type options struct {
+	builders      []func(solace.MessagingServiceBuilder) solace.MessagingServiceBuilder
+	beforeConnect []func(solace.MessagingService)
+}
+
+// WithBuilder applies fn to the messaging service builder after the broker
+// properties have been set, e.g. to add a transport security strategy.
+func WithBuilder(fn func(solace.MessagingServiceBuilder) solace.MessagingServiceBuilder) Option {
+	return func(o *options) {
+		o.builders = append(o.builders, fn)
+	}
+}
+
+// BeforeConnect runs fn on the built service before Connect is called,
+// which is where listeners have to be registered.
+func BeforeConnect(fn func(solace.MessagingService)) Option {
+	return func(o *options) {
+		o.beforeConnect = append(o.beforeConnect, fn)
+	}
+}
+
+// WithReconnectionListener registers listener on the service before it connects.
+func WithReconnectionListener(listener solace.ReconnectionListener) Option {
+	return BeforeConnect(func(service solace.MessagingService) {
+		service.AddReconnectionListener(listener)
+	})
+}
+
+// Build validates cfg and builds a messaging service without connecting it.
+func Build(cfg Config, opts ...Option) (solace.MessagingService, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	builder := messaging.NewMessagingServiceBuilder().FromConfigurationProvider(cfg.ServiceProperties())
+	for _, fn := range o.builders {
+		builder = fn(builder)
+	}
+	service, err := builder.Build()
+	if err != nil {
+		return nil, fmt.Errorf("building messaging service: %w", err)
+	}
+	for _, fn := range o.beforeConnect {
+		fn(service)
+	}
+	return service, nil
+}
+
+// Connect builds a messaging service from cfg and connects it to the broker.
+func Connect(cfg Config, opts ...Option) (solace.MessagingService, error) {
+	service, err := Build(cfg, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := service.Connect(); err != nil {
+		return nil, fmt.Errorf("connecting to %s: %w", cfg.Host, err)
+	}
+	return service, nil
+}
@@ -0,0 +1,260 @@
+// Package certs checks and rotates the TLS material of a messaging service:
+// the client certificate and private key used for client certificate
+// authentication, and the trust store the broker certificate is validated
+// against. Inspect validates the files before the service connects, and a
+// Manager watches them, warns ahead of their expiry and hands rotated files to
+// the service for its next reconnect.
+package certs
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Paths names the TLS material of a service, as passed to
+// config.ClientCertificateAuthentication and WithCertificateValidation.
+type Paths struct {
+	// CertFile holds the client certificate in PEM, optionally followed by
+	// its intermediates and the private key.
+	CertFile string
+	// KeyFile holds the private key in PEM, CertFile when empty.
+	KeyFile     string
+	KeyPassword string
+	// TrustStore is a directory of PEM files or a PEM bundle with the CA
+	// certificates that sign the broker certificate, optional.
+	TrustStore string
+}
+
+// KeyPath returns the file holding the private key.
+func (p Paths) KeyPath() string {
+	if p.KeyFile == "" {
+		return p.CertFile
+	}
+	return p.KeyFile
+}
+
+// Validation failures, wrapped with the file they were found in
+var (
+	ErrExpired          = errors.New("certificate expired")
+	ErrNotYetValid      = errors.New("certificate not valid yet")
+	ErrKeyMismatch      = errors.New("private key does not match the certificate")
+	ErrUsernameMismatch = errors.New("certificate common name does not match the username")
+	ErrNoCertificate    = errors.New("no certificate found")
+)
+
+// Status describes TLS material that passed validation.
+type Status struct {
+	Subject  string    // common name of the client certificate
+	Issuer   string    // common name of its issuer
+	NotAfter time.Time // expiry of the client certificate
+	// KeyChecked is false when the private key is encrypted and could not be
+	// matched against the certificate.
+	KeyChecked bool
+	TrustedCAs int       // unexpired certificates in the trust store
+	ExpiredCAs int       // expired certificates in the trust store
+	CAExpiry   time.Time // earliest expiry of the unexpired trust store certificates
+}
+
+// Expiry returns when the first certificate in use expires.
+func (s *Status) Expiry() time.Time {
+	if s.NotAfter.IsZero() || (!s.CAExpiry.IsZero() && s.CAExpiry.Before(s.NotAfter)) {
+		return s.CAExpiry
+	}
+	return s.NotAfter
+}
+
+func (s *Status) String() string {
+	var parts []string
+	if s.Subject != "" || !s.NotAfter.IsZero() {
+		parts = append(parts, fmt.Sprintf("client certificate CN=%q issued by %q expires %s", s.Subject, s.Issuer, s.NotAfter.Format(time.RFC3339)))
+	}
+	if s.TrustedCAs > 0 {
+		parts = append(parts, fmt.Sprintf("%d trusted CAs, first expires %s", s.TrustedCAs, s.CAExpiry.Format(time.RFC3339)))
+	}
+	if s.ExpiredCAs > 0 {
+		parts = append(parts, fmt.Sprintf("%d expired CAs ignored", s.ExpiredCAs))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Inspect validates the material named by paths at the time now: the client
+// certificate must be valid, the private key must match it, its common name
+// must equal username unless that is empty, and the trust store must hold at
+// least one unexpired certificate. Empty paths are skipped.
+func Inspect(paths Paths, username string, now time.Time) (*Status, error) {
+	status := &Status{}
+	if paths.CertFile != "" {
+		if err := inspectClient(paths, username, now, status); err != nil {
+			return nil, err
+		}
+	}
+	if paths.TrustStore != "" {
+		if err := inspectTrustStore(paths.TrustStore, now, status); err != nil {
+			return nil, err
+		}
+	}
+	return status, nil
+}
+
+// inspectClient validates the client certificate and its key
+func inspectClient(paths Paths, username string, now time.Time, status *Status) error {
+	certs, err := readCertificates(paths.CertFile)
+	if err != nil {
+		return err
+	}
+	if len(certs) == 0 {
+		return fmt.Errorf("%s: %w", paths.CertFile, ErrNoCertificate)
+	}
+	leaf := certs[0]
+	status.Subject, status.Issuer, status.NotAfter = leaf.Subject.CommonName, leaf.Issuer.CommonName, leaf.NotAfter
+	if now.After(leaf.NotAfter) {
+		return fmt.Errorf("%s: %w at %s", paths.CertFile, ErrExpired, leaf.NotAfter.Format(time.RFC3339))
+	}
+	if now.Before(leaf.NotBefore) {
+		return fmt.Errorf("%s: %w before %s", paths.CertFile, ErrNotYetValid, leaf.NotBefore.Format(time.RFC3339))
+	}
+	if username != "" && leaf.Subject.CommonName != username {
+		return fmt.Errorf("%s: %w: CN %q, username %q", paths.CertFile, ErrUsernameMismatch, leaf.Subject.CommonName, username)
+	}
+
+	key, err := readPrivateKey(paths.KeyPath())
+	if err != nil {
+		return err
+	}
+	if key == nil {
+		// encrypted, the library decrypts it with the password when connecting
+		return nil
+	}
+	// every key type readPrivateKey returns implements Public, and their public keys Equal
+	public := key.Public()
+	if !public.(interface{ Equal(crypto.PublicKey) bool }).Equal(leaf.PublicKey) {
+		return fmt.Errorf("%s: %w %s", paths.KeyPath(), ErrKeyMismatch, paths.CertFile)
+	}
+	status.KeyChecked = true
+	return nil
+}
+
+// inspectTrustStore counts the usable certificates of a directory or bundle
+func inspectTrustStore(path string, now time.Time, status *Status) error {
+	files, err := trustStoreFiles(path)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		certs, err := readCertificates(file)
+		if err != nil {
+			return err
+		}
+		for _, cert := range certs {
+			if now.After(cert.NotAfter) {
+				status.ExpiredCAs++
+				continue
+			}
+			status.TrustedCAs++
+			if status.CAExpiry.IsZero() || cert.NotAfter.Before(status.CAExpiry) {
+				status.CAExpiry = cert.NotAfter
+			}
+		}
+	}
+	if status.TrustedCAs == 0 {
+		if status.ExpiredCAs > 0 {
+			return fmt.Errorf("trust store %s: every certificate expired", path)
+		}
+		return fmt.Errorf("trust store %s: %w", path, ErrNoCertificate)
+	}
+	return nil
+}
+
+// trustStoreFiles lists the files of a trust store directory, or the bundle itself
+func trustStoreFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() || entry.Type()&os.ModeSymlink != 0 {
+			files = append(files, filepath.Join(path, entry.Name()))
+		}
+	}
+	return files, nil
+}
+
+// readCertificates parses the CERTIFICATE blocks of a PEM file, other
+// blocks and files without any are ignored
+func readCertificates(path string) ([]*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return certs, nil
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		certs = append(certs, cert)
+	}
+}
+
+// readPrivateKey parses the first private key of a PEM file, nil when it is
+// encrypted
+func readPrivateKey(path string) (crypto.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("%s: no private key found", path)
+		}
+		var key crypto.PrivateKey
+		switch {
+		case block.Type == "ENCRYPTED PRIVATE KEY" || block.Headers["Proc-Type"] == "4,ENCRYPTED":
+			return nil, nil
+		case block.Type == "PRIVATE KEY":
+			key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		case block.Type == "RSA PRIVATE KEY":
+			key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		case block.Type == "EC PRIVATE KEY":
+			key, err = x509.ParseECPrivateKey(block.Bytes)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		switch key := key.(type) {
+		case *rsa.PrivateKey, *ecdsa.PrivateKey, ed25519.PrivateKey:
+			return key.(crypto.Signer), nil
+		}
+		return nil, fmt.Errorf("%s: unsupported private key type %T", path, key)
+	}
+}
@@ -0,0 +1,182 @@
+package certs
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// issuer signs test certificates
+type issuer struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+}
+
+var serial int64
+
+// newKey generates a P-256 key
+func newKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// newCertificate returns a certificate for key with the common name, valid
+// from notBefore to notAfter, signed by parent or self-signed when it is nil
+func newCertificate(t *testing.T, parent *issuer, key crypto.Signer, cn string, notBefore, notAfter time.Time) *x509.Certificate {
+	t.Helper()
+	serial++
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+	signer, signerCert := key, template
+	if parent != nil {
+		signer, signerCert = parent.key, parent.cert
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signerCert, key.Public(), signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func newCA(t *testing.T, cn string, notAfter time.Time) *issuer {
+	t.Helper()
+	key := newKey(t)
+	return &issuer{cert: newCertificate(t, nil, key, cn, time.Now().Add(-time.Hour), notAfter), key: key}
+}
+
+func certPEM(certs ...*x509.Certificate) []byte {
+	var out []byte
+	for _, cert := range certs {
+		out = append(out, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+	return out
+}
+
+func keyPEM(t *testing.T, key crypto.Signer) []byte {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+// writeFile writes data to name in dir and returns its path
+func writeFile(t *testing.T, dir, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestInspect(t *testing.T) {
+	now := time.Now()
+	ca := newCA(t, "Test CA", now.Add(365*24*time.Hour))
+	expiredCA := newCA(t, "Expired CA", now.Add(-time.Minute))
+	key, otherKey := newKey(t), newKey(t)
+	valid := newCertificate(t, ca, key, "client", now.Add(-time.Hour), now.Add(30*24*time.Hour))
+	expired := newCertificate(t, ca, key, "client", now.Add(-2*time.Hour), now.Add(-time.Hour))
+	notYetValid := newCertificate(t, ca, key, "client", now.Add(time.Hour), now.Add(2*time.Hour))
+
+	dir := t.TempDir()
+	caDir := filepath.Join(dir, "cas")
+	os.Mkdir(caDir, 0o700)
+	writeFile(t, caDir, "ca.pem", certPEM(ca.cert))
+	writeFile(t, caDir, "expired.pem", certPEM(expiredCA.cert))
+	writeFile(t, caDir, "README", []byte("not a certificate"))
+	bundle := writeFile(t, dir, "bundle.pem", certPEM(ca.cert, expiredCA.cert))
+	expiredBundle := writeFile(t, dir, "expired-bundle.pem", certPEM(expiredCA.cert))
+	emptyBundle := writeFile(t, dir, "empty.pem", []byte("no certificates"))
+
+	validCert := writeFile(t, dir, "valid.pem", certPEM(valid, ca.cert))
+	validKey := writeFile(t, dir, "valid.key", keyPEM(t, key))
+	combined := writeFile(t, dir, "combined.pem", append(certPEM(valid), keyPEM(t, key)...))
+	encryptedKey := writeFile(t, dir, "encrypted.key", pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: []byte("opaque")}))
+	legacyEncryptedKey := writeFile(t, dir, "legacy.key", pem.EncodeToMemory(&pem.Block{
+		Type: "EC PRIVATE KEY", Headers: map[string]string{"Proc-Type": "4,ENCRYPTED", "DEK-Info": "AES-256-CBC,00"}, Bytes: []byte("opaque"),
+	}))
+
+	tests := []struct {
+		name       string
+		paths      Paths
+		username   string
+		wantErr    error
+		anyErr     bool
+		keyChecked bool
+		trusted    int
+	}{
+		{name: "valid", paths: Paths{CertFile: validCert, KeyFile: validKey, TrustStore: caDir}, username: "client", keyChecked: true, trusted: 1},
+		{name: "without username", paths: Paths{CertFile: validCert, KeyFile: validKey}, keyChecked: true},
+		{name: "key in the certificate file", paths: Paths{CertFile: combined}, keyChecked: true},
+		{name: "trust store bundle", paths: Paths{TrustStore: bundle}, trusted: 1},
+		{name: "encrypted key", paths: Paths{CertFile: validCert, KeyFile: encryptedKey, KeyPassword: "secret"}},
+		{name: "legacy encrypted key", paths: Paths{CertFile: validCert, KeyFile: legacyEncryptedKey}},
+		{name: "expired", paths: Paths{CertFile: writeFile(t, dir, "expired.pem", certPEM(expired)), KeyFile: validKey}, wantErr: ErrExpired},
+		{name: "not yet valid", paths: Paths{CertFile: writeFile(t, dir, "future.pem", certPEM(notYetValid)), KeyFile: validKey}, wantErr: ErrNotYetValid},
+		{name: "key mismatch", paths: Paths{CertFile: validCert, KeyFile: writeFile(t, dir, "other.key", keyPEM(t, otherKey))}, wantErr: ErrKeyMismatch},
+		{name: "common name mismatch", paths: Paths{CertFile: validCert, KeyFile: validKey}, username: "someone-else", wantErr: ErrUsernameMismatch},
+		{name: "no certificate", paths: Paths{CertFile: validKey}, wantErr: ErrNoCertificate},
+		{name: "no key", paths: Paths{CertFile: validCert}, anyErr: true},
+		{name: "missing file", paths: Paths{CertFile: filepath.Join(dir, "missing.pem")}, wantErr: os.ErrNotExist},
+		{name: "expired trust store", paths: Paths{TrustStore: expiredBundle}, anyErr: true},
+		{name: "empty trust store", paths: Paths{TrustStore: emptyBundle}, wantErr: ErrNoCertificate},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, err := Inspect(tt.paths, tt.username, now)
+			if tt.wantErr != nil || tt.anyErr {
+				if err == nil || (tt.wantErr != nil && !errors.Is(err, tt.wantErr)) {
+					t.Fatalf("error %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if status.KeyChecked != tt.keyChecked {
+				t.Errorf("key checked %v, want %v", status.KeyChecked, tt.keyChecked)
+			}
+			if status.TrustedCAs != tt.trusted {
+				t.Errorf("%d trusted CAs, want %d", status.TrustedCAs, tt.trusted)
+			}
+			if tt.paths.CertFile != "" && (status.Subject != "client" || status.Issuer != "Test CA" || !status.NotAfter.Equal(valid.NotAfter)) {
+				t.Errorf("status %s", status)
+			}
+		})
+	}
+
+	status, err := Inspect(Paths{CertFile: validCert, KeyFile: validKey, TrustStore: caDir}, "", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.ExpiredCAs != 1 || !status.CAExpiry.Equal(ca.cert.NotAfter) || !status.Expiry().Equal(valid.NotAfter) {
+		t.Errorf("status %s, expiry %s", status, status.Expiry())
+	}
+}
@@ -0,0 +1,320 @@
+package certs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"solace.dev/go/messaging/pkg/solace"
+	"solace.dev/go/messaging/pkg/solace/config"
+)
+
+// PropertyUpdater is the part of solace.MessagingService the Manager needs.
+type PropertyUpdater interface {
+	UpdateProperty(property config.ServiceProperty, value interface{}) error
+}
+
+// Manager defaults
+const (
+	DefaultPollInterval = 10 * time.Second
+	DefaultWarnBefore   = 30 * 24 * time.Hour
+	DefaultWarnInterval = 24 * time.Hour
+)
+
+// Rotation tells how rotated material was handed to the service.
+type Rotation struct {
+	Status *Status
+	// Pushed is true when the service accepted the paths with UpdateProperty.
+	// Service versions that only allow the OAuth2 tokens to be updated read
+	// the files at the unchanged paths again on their next reconnect.
+	Pushed bool
+	// TrustStoreApplied is false when the trust store is a PEM bundle. The
+	// library reads the trust store from a directory, so the service uses the
+	// copy of the bundle made when it was built, e.g. by
+	// tlsprofile.TrustStoreDir, and CA certificates rotated in the bundle only
+	// take effect once the service is built again.
+	TrustStoreApplied bool
+}
+
+// Stats is a snapshot of the counters of a Manager.
+type Stats struct {
+	Rotations uint64 // rotated material that passed validation
+	Failures  uint64 // changes that failed validation or could not be applied
+	Warnings  uint64 // expiry warnings
+}
+
+func (s Stats) String() string {
+	return fmt.Sprintf("rotations=%d failures=%d warnings=%d", s.Rotations, s.Failures, s.Warnings)
+}
+
+// Option configures a Manager.
+type Option func(*Manager)
+
+// WithUsername sets the client username the certificate common name must match.
+func WithUsername(username string) Option {
+	return func(m *Manager) {
+		m.username = username
+	}
+}
+
+// WithPollInterval sets how often the files are checked for changes.
+func WithPollInterval(d time.Duration) Option {
+	return func(m *Manager) {
+		m.pollInterval = d
+	}
+}
+
+// WithWarnBefore sets how long before an expiry the warnings start, and how
+// often they are repeated until the material is rotated.
+func WithWarnBefore(before, interval time.Duration) Option {
+	return func(m *Manager) {
+		m.warnBefore, m.warnInterval = before, interval
+	}
+}
+
+// OnExpiring registers fn to be called with the status of material that
+// expires within the warning period.
+func OnExpiring(fn func(status *Status)) Option {
+	return func(m *Manager) {
+		m.onExpiring = fn
+	}
+}
+
+// OnRotate registers fn to be called when rotated material passed validation
+// and was handed to the service.
+func OnRotate(fn func(rotation Rotation)) Option {
+	return func(m *Manager) {
+		m.onRotate = fn
+	}
+}
+
+// OnFailure registers fn to be called when changed material fails validation
+// or cannot be handed to the service.
+func OnFailure(fn func(err error)) Option {
+	return func(m *Manager) {
+		m.onFailure = fn
+	}
+}
+
+// Manager watches the TLS material of a messaging service.
+type Manager struct {
+	service PropertyUpdater
+	paths   Paths
+
+	username     string
+	pollInterval time.Duration
+	warnBefore   time.Duration
+	warnInterval time.Duration
+	onExpiring   func(status *Status)
+	onRotate     func(rotation Rotation)
+	onFailure    func(err error)
+
+	mu        sync.Mutex
+	status    *Status
+	version   string
+	warned    time.Time
+	rotations uint64
+	failures  uint64
+	warnings  uint64
+}
+
+// NewManager creates a manager for the material named by paths. service may
+// be nil until the service is built, see SetService.
+func NewManager(service PropertyUpdater, paths Paths, opts ...Option) *Manager {
+	m := &Manager{
+		service:      service,
+		paths:        paths,
+		pollInterval: DefaultPollInterval,
+		warnBefore:   DefaultWarnBefore,
+		warnInterval: DefaultWarnInterval,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// SetService sets the service rotated material is handed to.
+func (m *Manager) SetService(service PropertyUpdater) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.service = service
+}
+
+// Paths returns the watched paths.
+func (m *Manager) Paths() Paths {
+	return m.paths
+}
+
+// Status returns the status of the material in use, nil before Check.
+func (m *Manager) Status() *Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.status
+}
+
+// Stats returns the current counters.
+func (m *Manager) Stats() Stats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return Stats{Rotations: m.rotations, Failures: m.failures, Warnings: m.warnings}
+}
+
+// Check validates the material before the service is built and connected,
+// and warns when it expires soon.
+func (m *Manager) Check() (*Status, error) {
+	version := m.versions()
+	status, err := Inspect(m.paths, m.username, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	m.status, m.version = status, version
+	m.mu.Unlock()
+	m.warn(time.Now())
+	return status, nil
+}
+
+// Run checks the files every poll interval until ctx is done. Changed
+// material is validated and handed to the service, material that fails
+// validation is reported and the service keeps the paths it has.
+func (m *Manager) Run(ctx context.Context) error {
+	ticker := time.NewTicker(m.pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		m.poll(time.Now())
+	}
+}
+
+// poll rotates changed material and warns about expiring material
+func (m *Manager) poll(now time.Time) {
+	version := m.versions()
+	m.mu.Lock()
+	changed := version != m.version
+	m.version = version
+	m.mu.Unlock()
+	if changed {
+		m.rotate(now)
+	}
+	m.warn(now)
+}
+
+// rotate validates changed material and hands it to the service
+func (m *Manager) rotate(now time.Time) {
+	status, err := Inspect(m.paths, m.username, now)
+	if err != nil {
+		m.fail(fmt.Errorf("rotated TLS material rejected: %w", err))
+		return
+	}
+	pushed, err := m.push()
+	if err != nil {
+		m.fail(fmt.Errorf("applying rotated TLS material: %w", err))
+		return
+	}
+	m.mu.Lock()
+	m.status, m.warned = status, time.Time{}
+	m.rotations++
+	m.mu.Unlock()
+	if m.onRotate != nil {
+		m.onRotate(Rotation{Status: status, Pushed: pushed, TrustStoreApplied: m.paths.TrustStore == "" || isDir(m.paths.TrustStore)})
+	}
+}
+
+// push hands the paths to the service for its next connection attempt
+func (m *Manager) push() (bool, error) {
+	m.mu.Lock()
+	service := m.service
+	m.mu.Unlock()
+	if service == nil {
+		return false, nil
+	}
+	updates := config.ServicePropertyMap{
+		config.AuthenticationPropertySchemeSSLClientCertFile:       m.paths.CertFile,
+		config.AuthenticationPropertySchemeSSLClientPrivateKeyFile: m.paths.KeyPath(),
+	}
+	// the library reads the trust store from a directory, a bundle is not applied, see Rotation
+	if isDir(m.paths.TrustStore) {
+		updates[config.TransportLayerSecurityPropertyTrustStorePath] = m.paths.TrustStore
+	}
+	for property, value := range updates {
+		if value == "" {
+			continue
+		}
+		if err := service.UpdateProperty(property, value); err != nil {
+			var notModifiable *solace.IllegalArgumentError
+			if errors.As(err, &notModifiable) {
+				// the paths did not change, the files are read again on reconnect
+				return false, nil
+			}
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// isDir reports whether path is a directory
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// fail counts and reports a failed rotation
+func (m *Manager) fail(err error) {
+	m.mu.Lock()
+	m.failures++
+	m.mu.Unlock()
+	if m.onFailure != nil {
+		m.onFailure(err)
+	}
+}
+
+// warn reports material in use that expires within the warning period, once
+// per warning interval
+func (m *Manager) warn(now time.Time) {
+	m.mu.Lock()
+	status := m.status
+	due := status != nil && !status.Expiry().IsZero() && status.Expiry().Sub(now) < m.warnBefore &&
+		(m.warned.IsZero() || now.Sub(m.warned) >= m.warnInterval)
+	if due {
+		m.warned = now
+		m.warnings++
+	}
+	m.mu.Unlock()
+	if due && m.onExpiring != nil {
+		m.onExpiring(status)
+	}
+}
+
+// versions identifies the current version of the files
+func (m *Manager) versions() string {
+	paths := []string{m.paths.CertFile, m.paths.KeyPath()}
+	if m.paths.TrustStore != "" {
+		if files, err := trustStoreFiles(m.paths.TrustStore); err == nil {
+			paths = append(paths, files...)
+		} else {
+			paths = append(paths, m.paths.TrustStore)
+		}
+	}
+	var versions []string
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			versions = append(versions, err.Error())
+			continue
+		}
+		versions = append(versions, fmt.Sprint(path, info.ModTime().UnixNano(), info.Size()))
+	}
+	return strings.Join(versions, "|")
+}
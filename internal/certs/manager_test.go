@@ -0,0 +1,201 @@
+package certs
+
+import (
+	"crypto"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"solace.dev/go/messaging/pkg/solace"
+	"solace.dev/go/messaging/pkg/solace/config"
+)
+
+// recorder is a PropertyUpdater remembering the properties pushed to it
+type recorder struct {
+	mu         sync.Mutex
+	properties map[config.ServiceProperty]interface{}
+	err        error
+}
+
+func (r *recorder) UpdateProperty(property config.ServiceProperty, value interface{}) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	if r.properties == nil {
+		r.properties = map[config.ServiceProperty]interface{}{}
+	}
+	r.properties[property] = value
+	return nil
+}
+
+// material writes client certificates and keys over the same files
+type material struct {
+	t       *testing.T
+	ca      *issuer
+	paths   Paths
+	version int
+}
+
+func newMaterial(t *testing.T, bundle bool) *material {
+	dir := t.TempDir()
+	m := &material{t: t, ca: newCA(t, "Test CA", time.Now().Add(365*24*time.Hour))}
+	m.paths = Paths{CertFile: filepath.Join(dir, "client.pem"), KeyFile: filepath.Join(dir, "client.key")}
+	if bundle {
+		m.paths.TrustStore = writeFile(t, dir, "bundle.pem", certPEM(m.ca.cert))
+	} else {
+		m.paths.TrustStore = filepath.Join(dir, "cas")
+		os.Mkdir(m.paths.TrustStore, 0o700)
+		writeFile(t, m.paths.TrustStore, "ca.pem", certPEM(m.ca.cert))
+	}
+	return m
+}
+
+// write replaces the client certificate with one valid for lifetime, whose
+// private key is key or a new one when key is nil
+func (m *material) write(cn string, lifetime time.Duration, key crypto.Signer) {
+	certKey := newKey(m.t)
+	if key == nil {
+		key = certKey
+	}
+	cert := newCertificate(m.t, m.ca, certKey, cn, time.Now().Add(-time.Hour), time.Now().Add(lifetime))
+	if err := os.WriteFile(m.paths.CertFile, certPEM(cert), 0o600); err != nil {
+		m.t.Fatal(err)
+	}
+	if err := os.WriteFile(m.paths.KeyFile, keyPEM(m.t, key), 0o600); err != nil {
+		m.t.Fatal(err)
+	}
+	// the files may be rewritten within the resolution of the modification time
+	m.version++
+	mtime := time.Now().Add(time.Duration(m.version) * time.Second)
+	os.Chtimes(m.paths.CertFile, mtime, mtime)
+	os.Chtimes(m.paths.KeyFile, mtime, mtime)
+}
+
+func TestManagerRotation(t *testing.T) {
+	for _, bundle := range []bool{false, true} {
+		name := "trust store directory"
+		if bundle {
+			name = "trust store bundle"
+		}
+		t.Run(name, func(t *testing.T) {
+			files := newMaterial(t, bundle)
+			files.write("client", 90*24*time.Hour, nil)
+
+			service := &recorder{}
+			var rotations []Rotation
+			var failures []error
+			m := NewManager(nil, files.paths, WithUsername("client"),
+				OnRotate(func(rotation Rotation) { rotations = append(rotations, rotation) }),
+				OnFailure(func(err error) { failures = append(failures, err) }))
+			first, err := m.Check()
+			if err != nil {
+				t.Fatal(err)
+			}
+			m.SetService(service)
+
+			// nothing changed
+			m.poll(time.Now())
+			if len(rotations)+len(failures) != 0 {
+				t.Fatalf("%d rotations and %d failures without a change", len(rotations), len(failures))
+			}
+
+			// a rotated certificate is validated and pushed
+			files.write("client", 180*24*time.Hour, nil)
+			m.poll(time.Now())
+			if len(rotations) != 1 || len(failures) != 0 {
+				t.Fatalf("%d rotations and %d failures after a rotation: %v", len(rotations), len(failures), failures)
+			}
+			rotation := rotations[0]
+			if !rotation.Pushed || rotation.TrustStoreApplied == bundle {
+				t.Errorf("rotation pushed=%v trust store applied=%v", rotation.Pushed, rotation.TrustStoreApplied)
+			}
+			if !rotation.Status.NotAfter.After(first.NotAfter) || m.Status() != rotation.Status {
+				t.Errorf("status %s not replaced by the rotated one", m.Status())
+			}
+			if service.properties[config.AuthenticationPropertySchemeSSLClientCertFile] != files.paths.CertFile ||
+				service.properties[config.AuthenticationPropertySchemeSSLClientPrivateKeyFile] != files.paths.KeyFile {
+				t.Errorf("pushed %v", service.properties)
+			}
+			_, trustStorePushed := service.properties[config.TransportLayerSecurityPropertyTrustStorePath]
+			if trustStorePushed == bundle {
+				t.Errorf("trust store pushed %v for a bundle %v", trustStorePushed, bundle)
+			}
+
+			// invalid material is reported and the material in use is kept
+			inUse := m.Status()
+			files.write("client", 180*24*time.Hour, newKey(t))
+			m.poll(time.Now())
+			files.write("someone-else", 180*24*time.Hour, nil)
+			m.poll(time.Now())
+			if len(rotations) != 1 || len(failures) != 2 {
+				t.Fatalf("%d rotations and %d failures after invalid material", len(rotations), len(failures))
+			}
+			if !errors.Is(failures[0], ErrKeyMismatch) || !errors.Is(failures[1], ErrUsernameMismatch) {
+				t.Errorf("failures %v", failures)
+			}
+			if m.Status() != inUse {
+				t.Error("status replaced by invalid material")
+			}
+			if stats := m.Stats(); stats.Rotations != 1 || stats.Failures != 2 {
+				t.Errorf("stats %s", stats)
+			}
+		})
+	}
+}
+
+func TestManagerPushNotModifiable(t *testing.T) {
+	files := newMaterial(t, false)
+	files.write("client", 90*24*time.Hour, nil)
+	service := &recorder{err: solace.NewError(&solace.IllegalArgumentError{}, "property cannot be modified", nil)}
+	var rotations []Rotation
+	m := NewManager(service, files.paths, OnRotate(func(rotation Rotation) { rotations = append(rotations, rotation) }))
+	if _, err := m.Check(); err != nil {
+		t.Fatal(err)
+	}
+	files.write("client", 180*24*time.Hour, nil)
+	m.poll(time.Now())
+	// the files at the same paths are read again on reconnect
+	if len(rotations) != 1 || rotations[0].Pushed {
+		t.Errorf("rotations %+v, want one not pushed", rotations)
+	}
+
+	service.err = errors.New("service gone")
+	var failures []error
+	m.onFailure = func(err error) { failures = append(failures, err) }
+	files.write("client", 180*24*time.Hour, nil)
+	m.poll(time.Now())
+	if len(rotations) != 1 || len(failures) != 1 {
+		t.Errorf("%d rotations and %d failures when the push fails", len(rotations), len(failures))
+	}
+}
+
+func TestManagerWarnings(t *testing.T) {
+	files := newMaterial(t, false)
+	files.write("client", 10*24*time.Hour, nil)
+	var warnings []*Status
+	m := NewManager(&recorder{}, files.paths,
+		WithWarnBefore(30*24*time.Hour, 24*time.Hour),
+		OnExpiring(func(status *Status) { warnings = append(warnings, status) }))
+	if _, err := m.Check(); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	m.poll(now.Add(time.Hour))
+	m.poll(now.Add(25 * time.Hour))
+	if len(warnings) != 2 {
+		t.Fatalf("%d warnings, want one at Check and one a day later", len(warnings))
+	}
+
+	// a rotation to material that expires later ends the warnings
+	files.write("client", 90*24*time.Hour, nil)
+	m.poll(now.Add(50 * time.Hour))
+	m.poll(now.Add(75 * time.Hour))
+	if len(warnings) != 2 || m.Stats().Warnings != 2 {
+		t.Errorf("%d warnings after the rotation", len(warnings)-2)
+	}
+}
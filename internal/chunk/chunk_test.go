@@ -0,0 +1,383 @@
+package chunk
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"solace.dev/go/messaging/pkg/solace"
+	"solace.dev/go/messaging/pkg/solace/config"
+	"solace.dev/go/messaging/pkg/solace/message"
+	"solace.dev/go/messaging/pkg/solace/resource"
+
+	"SolaceSamples.com/PubSub+Go/internal/fake"
+)
+
+const (
+	topic    = "solace/samples/chunks"
+	partSize = 1000
+)
+
+// setup connects a service to a broker with a queue subscribed to topic and
+// starts a persistent publisher on it
+func setup(t *testing.T) (*fake.Broker, *fake.Queue, *fake.Service, solace.PersistentMessagePublisher) {
+	t.Helper()
+	broker := fake.NewBroker()
+	queue := broker.CreateQueue("chunks", topic)
+	service := broker.NewService()
+	if err := service.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { service.Disconnect() })
+	publisher, err := service.CreatePersistentMessagePublisherBuilder().Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := publisher.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { publisher.Terminate(time.Second) })
+	return broker, queue, service, publisher
+}
+
+// handled collects what a Receiver hands to its handler
+type handled struct {
+	mu       sync.Mutex
+	messages []message.InboundMessage
+	fail     int // number of calls to fail
+	arrived  chan struct{}
+	errs     []error
+	discard  chan struct{}
+}
+
+func newHandled() *handled {
+	return &handled{arrived: make(chan struct{}, 100), discard: make(chan struct{}, 100)}
+}
+
+func (h *handled) handler(msg message.InboundMessage) error {
+	h.mu.Lock()
+	defer func() {
+		h.mu.Unlock()
+		h.arrived <- struct{}{}
+	}()
+	if h.fail > 0 {
+		h.fail--
+		return errors.New("handler failed")
+	}
+	h.messages = append(h.messages, msg)
+	return nil
+}
+
+func (h *handled) onDiscard(err error) {
+	h.mu.Lock()
+	h.errs = append(h.errs, err)
+	h.mu.Unlock()
+	h.discard <- struct{}{}
+}
+
+func (h *handled) waitHandled(t *testing.T, n int) {
+	t.Helper()
+	wait(t, h.arrived, n, "handler calls")
+}
+
+func (h *handled) waitDiscarded(t *testing.T, n int) {
+	t.Helper()
+	wait(t, h.discard, n, "discarded transfers")
+}
+
+func wait(t *testing.T, ch chan struct{}, n int, what string) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-ch:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%d of %d %s", i, n, what)
+		}
+	}
+}
+
+// startReceiver reassembles the transfers on queue
+func startReceiver(t *testing.T, service *fake.Service, queue *fake.Queue, timeout time.Duration, h *handled) *Receiver {
+	t.Helper()
+	persistentReceiver, err := service.CreatePersistentMessageReceiverBuilder().
+		WithMessageClientAcknowledgement().
+		WithRequiredMessageOutcomeSupport(config.PersistentReceiverFailedOutcome, config.PersistentReceiverRejectedOutcome).
+		Build(resource.QueueDurableExclusive(queue.Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := persistentReceiver.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { persistentReceiver.Terminate(time.Second) })
+	r := NewReceiver(persistentReceiver, timeout, OnDiscard(h.onDiscard))
+	if err := r.ReceiveAsync(h.handler); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// waitSettled waits until queue has nothing pending or unacknowledged
+func waitSettled(t *testing.T, queue *fake.Queue) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for queue.Pending() > 0 || queue.Unacked() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("queue has %d pending and %d unacked messages", queue.Pending(), queue.Unacked())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSplitAndReassemble(t *testing.T) {
+	tests := []struct {
+		name      string
+		size      int
+		wantParts int
+	}{
+		{"empty", 0, 1},
+		{"one byte", 1, 1},
+		{"one part", partSize, 1},
+		{"just over one part", partSize + 1, 2},
+		{"many parts", 5*partSize + 7, 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, queue, service, publisher := setup(t)
+			receipts := make(chan TransferReceipt, 1)
+			p := NewPublisher(publisher, service.MessageBuilder, partSize, func(receipt TransferReceipt) { receipts <- receipt })
+			payload := make([]byte, tt.size)
+			rand.Read(payload)
+
+			id, err := p.Publish(payload, resource.TopicOf(topic), config.MessagePropertyMap{"origin": "test"}, "context")
+			if err != nil {
+				t.Fatal(err)
+			}
+			receipt := <-receipts
+			if receipt.TransferID != id || receipt.Parts != tt.wantParts || receipt.UserContext != "context" || receipt.Err != nil {
+				t.Errorf("receipt %+v, want %d parts of %s", receipt, tt.wantParts, id)
+			}
+			if stats := p.Stats(); stats.Transfers != 1 || stats.Parts != uint64(tt.wantParts) || stats.Completed != 1 || p.Outstanding() != 0 {
+				t.Errorf("publisher stats %s, outstanding %d", stats, p.Outstanding())
+			}
+			if queue.Pending() != tt.wantParts {
+				t.Errorf("%d parts spooled, want %d", queue.Pending(), tt.wantParts)
+			}
+
+			h := newHandled()
+			r := startReceiver(t, service, queue, time.Second, h)
+			h.waitHandled(t, 1)
+			waitSettled(t, queue)
+
+			msg := h.messages[0].(*Message)
+			if got, _ := msg.GetPayloadAsBytes(); !bytes.Equal(got, payload) {
+				t.Errorf("reassembled %d bytes, want the %d published", len(got), len(payload))
+			}
+			if msg.TransferID() != id || len(msg.Parts()) != tt.wantParts {
+				t.Errorf("transfer %s with %d parts", msg.TransferID(), len(msg.Parts()))
+			}
+			if origin, _ := msg.GetProperty("origin"); origin != "test" {
+				t.Errorf("property origin = %v, want it on every part", origin)
+			}
+			if part, _ := msg.GetProperty(PropertyPart); part != int64(0) {
+				t.Errorf("accessors of part %v, want the first", part)
+			}
+			if stats := r.Stats(); stats.Parts != uint64(tt.wantParts) || stats.Reassembled != 1 || r.Pending() != 0 {
+				t.Errorf("receiver stats %s, pending %d", stats, r.Pending())
+			}
+		})
+	}
+}
+
+func TestPublishFailure(t *testing.T) {
+	broker, _, service, publisher := setup(t)
+	errNoSpool := errors.New("spool full")
+	broker.SetPublishInterceptor(func(_ string, msg message.OutboundMessage) error {
+		if part, _ := msg.GetProperty(PropertyPart); part == int64(1) {
+			return errNoSpool
+		}
+		return nil
+	})
+	receipts := make(chan TransferReceipt, 1)
+	p := NewPublisher(publisher, service.MessageBuilder, partSize, func(receipt TransferReceipt) { receipts <- receipt })
+	if _, err := p.Publish(make([]byte, 3*partSize), resource.TopicOf(topic), nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	receipt := <-receipts
+	if !errors.Is(receipt.Err, errNoSpool) || receipt.Parts != 3 {
+		t.Errorf("receipt %+v, want the rejected part reported", receipt)
+	}
+	if stats := p.Stats(); stats.Failed != 1 || stats.Completed != 0 {
+		t.Errorf("stats %s", stats)
+	}
+}
+
+// part is a hand-made part of a transfer
+type part struct {
+	id       string
+	index    int
+	total    int
+	checksum string
+	payload  string
+}
+
+// parts splits payload into one part per string, all of transfer id
+func parts(id string, payload ...string) []part {
+	var whole []byte
+	for _, p := range payload {
+		whole = append(whole, p...)
+	}
+	sum := sha256.Sum256(whole)
+	out := make([]part, len(payload))
+	for i, p := range payload {
+		out[i] = part{id: id, index: i, total: len(payload), checksum: hex.EncodeToString(sum[:]), payload: p}
+	}
+	return out
+}
+
+func publishParts(t *testing.T, service *fake.Service, publisher solace.PersistentMessagePublisher, parts ...part) {
+	t.Helper()
+	for _, p := range parts {
+		msg, err := service.MessageBuilder().
+			WithProperty(PropertyTransferID, p.id).
+			WithProperty(PropertyPart, int64(p.index)).
+			WithProperty(PropertyTotal, int64(p.total)).
+			WithProperty(PropertyChecksum, p.checksum).
+			BuildWithByteArrayPayload([]byte(p.payload))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := publisher.PublishAwaitAcknowledgement(msg, resource.TopicOf(topic), time.Second, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReassembly(t *testing.T) {
+	abc := parts("abc", "Hello ", "chunked ", "world")
+	tampered := parts("tampered", "Hello ", "chunked ", "world")
+	tampered[1].payload = "CHUNKED "
+	inconsistent := parts("inconsistent", "Hello ", "world")
+	inconsistent[1].total = 3
+	outOfRange := parts("range", "Hello ", "world")
+	outOfRange[1].index = 2
+
+	tests := []struct {
+		name          string
+		parts         []part
+		want          string // reassembled payload, empty when discarded
+		wantDiscarded int    // parts moved to the dead message queue
+		wantStats     ReceiverStats
+	}{
+		{name: "in order", parts: abc, want: "Hello chunked world",
+			wantStats: ReceiverStats{Parts: 3, Reassembled: 1}},
+		{name: "out of order", parts: []part{abc[2], abc[0], abc[1]}, want: "Hello chunked world",
+			wantStats: ReceiverStats{Parts: 3, Reassembled: 1}},
+		{name: "duplicate part", parts: []part{abc[0], abc[1], abc[0], abc[2]}, want: "Hello chunked world",
+			wantStats: ReceiverStats{Parts: 4, Reassembled: 1}},
+		{name: "checksum mismatch", parts: tampered, wantDiscarded: 3,
+			wantStats: ReceiverStats{Parts: 3, Corrupt: 1}},
+		{name: "inconsistent total", parts: inconsistent, wantDiscarded: 2,
+			wantStats: ReceiverStats{Parts: 2, Corrupt: 1}},
+		{name: "part out of range", parts: outOfRange, wantDiscarded: 2,
+			wantStats: ReceiverStats{Parts: 2, Corrupt: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, queue, service, publisher := setup(t)
+			publishParts(t, service, publisher, tt.parts...)
+			h := newHandled()
+			r := startReceiver(t, service, queue, time.Second, h)
+			if tt.want != "" {
+				h.waitHandled(t, 1)
+				if got, _ := h.messages[0].GetPayloadAsString(); got != tt.want {
+					t.Errorf("reassembled %q, want %q", got, tt.want)
+				}
+			} else {
+				h.waitDiscarded(t, 1)
+				var transferErr *TransferError
+				if !errors.As(h.errs[0], &transferErr) || transferErr.TransferID != tt.parts[0].id {
+					t.Errorf("discarded with %v", h.errs[0])
+				}
+			}
+			waitSettled(t, queue)
+			if got := len(queue.Discarded()); got != tt.wantDiscarded {
+				t.Errorf("%d parts discarded, want %d", got, tt.wantDiscarded)
+			}
+			if stats := r.Stats(); stats != tt.wantStats {
+				t.Errorf("stats %s, want %s", stats, tt.wantStats)
+			}
+		})
+	}
+}
+
+func TestTimeoutDiscards(t *testing.T) {
+	_, queue, service, publisher := setup(t)
+	abc := parts("abc", "Hello ", "chunked ", "world")
+	// the last part never arrives
+	publishParts(t, service, publisher, abc[0], abc[1])
+	h := newHandled()
+	r := startReceiver(t, service, queue, 50*time.Millisecond, h)
+
+	h.waitDiscarded(t, 1)
+	var transferErr *TransferError
+	if !errors.As(h.errs[0], &transferErr) || !errors.Is(transferErr, ErrExpired) || transferErr.Received != 2 || transferErr.Total != 3 {
+		t.Errorf("discarded with %v", h.errs[0])
+	}
+	waitSettled(t, queue)
+	if len(queue.Discarded()) != 2 || r.Pending() != 0 {
+		t.Errorf("%d parts discarded, %d transfers pending", len(queue.Discarded()), r.Pending())
+	}
+	if stats := r.Stats(); stats.Expired != 1 || stats.Reassembled != 0 {
+		t.Errorf("stats %s", stats)
+	}
+	if len(h.messages) != 0 {
+		t.Error("an incomplete transfer was handled")
+	}
+}
+
+func TestHandlerFailureRedelivers(t *testing.T) {
+	_, queue, service, publisher := setup(t)
+	publishParts(t, service, publisher, parts("abc", "Hello ", "world")...)
+	h := newHandled()
+	h.fail = 1
+	r := startReceiver(t, service, queue, time.Second, h)
+
+	// the failed transfer is redelivered as a whole and handled again
+	h.waitHandled(t, 2)
+	waitSettled(t, queue)
+	if got, _ := h.messages[0].GetPayloadAsString(); got != "Hello world" {
+		t.Errorf("redelivered transfer reassembled as %q", got)
+	}
+	for _, msg := range h.messages[0].(*Message).Parts() {
+		if !msg.IsRedelivered() {
+			t.Error("part not flagged as redelivered")
+		}
+	}
+	if stats := r.Stats(); stats.Failed != 1 || stats.Reassembled != 1 || stats.Parts != 4 {
+		t.Errorf("stats %s", stats)
+	}
+}
+
+func TestUnchunked(t *testing.T) {
+	_, queue, service, publisher := setup(t)
+	msg, _ := service.MessageBuilder().BuildWithStringPayload("plain")
+	if err := publisher.PublishAwaitAcknowledgement(msg, resource.TopicOf(topic), time.Second, nil); err != nil {
+		t.Fatal(err)
+	}
+	h := newHandled()
+	r := startReceiver(t, service, queue, time.Second, h)
+	h.waitHandled(t, 1)
+	waitSettled(t, queue)
+	if _, ok := h.messages[0].(*Message); ok {
+		t.Error("a plain message was handed over as a transfer")
+	}
+	if stats := r.Stats(); stats.Unchunked != 1 || stats.Parts != 0 {
+		t.Errorf("stats %s", stats)
+	}
+}
@@ -0,0 +1,229 @@
+// Package chunk sends payloads larger than the maximum message size of a queue
+// as a sequence of persistent messages and reassembles them on the receiving
+// side.
+//
+// Every part of a transfer carries user properties naming the transfer, the
+// index of the part counted from 0, the number of parts and the SHA-256
+// checksum of the whole payload. The receiver acknowledges the parts only once
+// the payload has been reassembled, verified and handled, so a transfer that
+// is interrupted on either side is redelivered as a whole.
+package chunk
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"solace.dev/go/messaging/pkg/solace"
+	"solace.dev/go/messaging/pkg/solace/config"
+	"solace.dev/go/messaging/pkg/solace/resource"
+
+	"SolaceSamples.com/PubSub+Go/internal/lifecycle"
+)
+
+// User properties of a part
+const (
+	PropertyTransferID = "chunk_transfer_id"
+	PropertyPart       = "chunk_part"
+	PropertyTotal      = "chunk_total"
+	PropertyChecksum   = "chunk_checksum"
+)
+
+// DefaultPartSize leaves room for the headers and properties below the
+// maximum message size of 1000000 bytes used by the provisioning howto.
+const DefaultPartSize = 512 * 1024
+
+// TransferReceipt is the outcome of a transfer once every part has a publish receipt.
+type TransferReceipt struct {
+	TransferID  string
+	Parts       int
+	UserContext interface{} // as given to Publish
+	Err         error       // the first part that was not persisted, nil when all were
+}
+
+// TransferReceiptListener is called once per transfer.
+type TransferReceiptListener func(receipt TransferReceipt)
+
+// Stats is a snapshot of the counters of a Publisher.
+type Stats struct {
+	Transfers uint64 // transfers started
+	Parts     uint64 // parts handed to the publisher
+	Completed uint64 // transfers with every part persisted
+	Failed    uint64 // transfers with a part that was not persisted
+}
+
+func (s Stats) String() string {
+	return fmt.Sprintf("transfers=%d parts=%d completed=%d failed=%d",
+		s.Transfers, s.Parts, s.Completed, s.Failed)
+}
+
+// Publisher splits payloads into parts published through a
+// solace.PersistentMessagePublisher and owns its receipt listener.
+type Publisher struct {
+	publisher  solace.PersistentMessagePublisher
+	newBuilder func() solace.OutboundMessageBuilder
+	partSize   int
+	listener   TransferReceiptListener
+
+	transfers, parts, completed, failed atomic.Uint64
+
+	inFlight lifecycle.InFlight
+}
+
+// transfer tracks the receipts of the parts of one payload
+type transfer struct {
+	id          string
+	userContext interface{}
+
+	mu        sync.Mutex
+	published int // parts handed to the publisher
+	receipts  int
+	closed    bool // no more parts will be published
+	err       error
+}
+
+// NewPublisher installs the receipt listener on publisher. newBuilder returns
+// an empty message builder for each part, e.g. the MessageBuilder method of
+// the messaging service. listener, which may be nil, is called once per
+// transfer.
+func NewPublisher(publisher solace.PersistentMessagePublisher, newBuilder func() solace.OutboundMessageBuilder, partSize int, listener TransferReceiptListener) *Publisher {
+	if partSize <= 0 {
+		partSize = DefaultPartSize
+	}
+	p := &Publisher{publisher: publisher, newBuilder: newBuilder, partSize: partSize, listener: listener}
+	publisher.SetMessagePublishReceiptListener(p.onReceipt)
+	return p
+}
+
+// Publish publishes payload in parts of at most the part size on destination
+// and returns the ID of the transfer. properties, e.g. a content type, are set
+// on every part. When a part is rejected the parts published before it are
+// still delivered, the receivers discard them once the transfer times out.
+func (p *Publisher) Publish(payload []byte, destination *resource.Topic, properties config.MessagePropertiesConfigurationProvider, userContext interface{}) (string, error) {
+	id, err := newTransferID()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(payload)
+	checksum := hex.EncodeToString(sum[:])
+	total := (len(payload) + p.partSize - 1) / p.partSize
+	if total == 0 {
+		// an empty payload is sent as one empty part
+		total = 1
+	}
+
+	t := &transfer{id: id, userContext: userContext}
+	p.inFlight.Begin()
+	p.transfers.Add(1)
+	for part := 0; part < total; part++ {
+		end := min((part+1)*p.partSize, len(payload))
+		builder := p.newBuilder()
+		if properties != nil {
+			builder = builder.FromConfigurationProvider(properties)
+		}
+		msg, err := builder.
+			WithProperty(PropertyTransferID, id).
+			WithProperty(PropertyPart, int64(part)).
+			WithProperty(PropertyTotal, int64(total)).
+			WithProperty(PropertyChecksum, checksum).
+			BuildWithByteArrayPayload(payload[part*p.partSize : end])
+		if err == nil {
+			t.mu.Lock()
+			t.published++
+			t.mu.Unlock()
+			if err = p.publisher.Publish(msg, destination, nil, t); err != nil {
+				t.mu.Lock()
+				t.published--
+				t.mu.Unlock()
+			}
+		}
+		if err != nil {
+			err = fmt.Errorf("transfer %s part %d of %d: %w", id, part, total, err)
+			p.close(t, err)
+			return id, err
+		}
+		p.parts.Add(1)
+	}
+	p.close(t, nil)
+	return id, nil
+}
+
+// Stats returns the current counters.
+func (p *Publisher) Stats() Stats {
+	return Stats{
+		Transfers: p.transfers.Load(),
+		Parts:     p.parts.Load(),
+		Completed: p.completed.Load(),
+		Failed:    p.failed.Load(),
+	}
+}
+
+// Outstanding returns the number of transfers waiting for receipts.
+func (p *Publisher) Outstanding() int {
+	return p.inFlight.Count()
+}
+
+// Drain waits until every transfer has its receipts or ctx is done.
+func (p *Publisher) Drain(ctx context.Context) error {
+	if err := p.inFlight.Wait(ctx); err != nil {
+		return fmt.Errorf("%d transfers still waiting for receipts: %w", p.inFlight.Count(), err)
+	}
+	return nil
+}
+
+// close marks the end of the parts of t, err is why publishing stopped early
+func (p *Publisher) close(t *transfer, err error) {
+	t.mu.Lock()
+	t.closed = true
+	if t.err == nil {
+		t.err = err
+	}
+	done := t.receipts == t.published
+	t.mu.Unlock()
+	if done {
+		p.finish(t)
+	}
+}
+
+func (p *Publisher) onReceipt(receipt solace.PublishReceipt) {
+	t, ok := receipt.GetUserContext().(*transfer)
+	if !ok {
+		// not a part, the publisher is meant for transfers only
+		return
+	}
+	t.mu.Lock()
+	t.receipts++
+	if err := receipt.GetError(); err != nil && t.err == nil {
+		t.err = fmt.Errorf("transfer %s: %w", t.id, err)
+	}
+	done := t.closed && t.receipts == t.published
+	t.mu.Unlock()
+	if done {
+		p.finish(t)
+	}
+}
+
+// finish reports the outcome of t once every published part has its receipt
+func (p *Publisher) finish(t *transfer) {
+	defer p.inFlight.End()
+	if t.err != nil {
+		p.failed.Add(1)
+	} else {
+		p.completed.Add(1)
+	}
+	if p.listener != nil {
+		p.listener(TransferReceipt{TransferID: t.id, Parts: t.published, UserContext: t.userContext, Err: t.err})
+	}
+}
+
+func newTransferID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
@@ -0,0 +1,301 @@
+package chunk
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"solace.dev/go/messaging/pkg/solace"
+	"solace.dev/go/messaging/pkg/solace/config"
+	"solace.dev/go/messaging/pkg/solace/message"
+
+	"SolaceSamples.com/PubSub+Go/internal/codec"
+)
+
+// ErrExpired is reported for transfers whose next part did not arrive in time.
+var ErrExpired = errors.New("transfer incomplete")
+
+// TransferError tells why a transfer was discarded.
+type TransferError struct {
+	TransferID string
+	Received   int // distinct parts received
+	Total      int
+	Err        error
+}
+
+func (e *TransferError) Error() string {
+	return fmt.Sprintf("transfer %s with %d of %d parts: %v", e.TransferID, e.Received, e.Total, e.Err)
+}
+
+func (e *TransferError) Unwrap() error {
+	return e.Err
+}
+
+// ReceiverStats is a snapshot of the counters of a Receiver.
+type ReceiverStats struct {
+	Parts       uint64 // parts received
+	Reassembled uint64 // transfers handed to the handler successfully
+	Failed      uint64 // transfers the handler returned an error for
+	Expired     uint64 // transfers discarded after the timeout
+	Corrupt     uint64 // transfers discarded for a bad checksum or inconsistent parts
+	Unchunked   uint64 // messages that were not part of a transfer
+}
+
+func (s ReceiverStats) String() string {
+	return fmt.Sprintf("parts=%d reassembled=%d failed=%d expired=%d corrupt=%d unchunked=%d",
+		s.Parts, s.Reassembled, s.Failed, s.Expired, s.Corrupt, s.Unchunked)
+}
+
+// Option configures a Receiver.
+type Option func(*Receiver)
+
+// OnDiscard registers fn to be called for every transfer that is discarded
+// because it expired or is corrupt, with a *TransferError.
+func OnDiscard(fn func(err error)) Option {
+	return func(r *Receiver) {
+		r.onDiscard = fn
+	}
+}
+
+// Receiver wraps a persistent receiver that was built with client
+// acknowledgement and reassembles the parts of transfers. No part is
+// acknowledged before the whole payload was handled, so the receiver's flow
+// must allow as many unacknowledged messages as a transfer has parts.
+//
+// Discarded transfers are settled as rejected, so the broker moves their parts
+// to the dead message queue if the receiver was built with that outcome
+// enabled, and leaves them unacknowledged otherwise.
+type Receiver struct {
+	receiver  solace.PersistentMessageReceiver
+	timeout   time.Duration
+	onDiscard func(err error)
+
+	mu        sync.Mutex
+	transfers map[string]*pending
+
+	parts, reassembled, failed, expired, corrupt, unchunked atomic.Uint64
+}
+
+// pending collects the parts of a transfer
+type pending struct {
+	id       string
+	total    int
+	checksum string
+	payloads map[int][]byte
+	messages []message.InboundMessage // every part received, duplicates included
+	timer    *time.Timer
+}
+
+// NewReceiver wraps receiver. A transfer is discarded when timeout passes
+// without one of its parts arriving.
+func NewReceiver(receiver solace.PersistentMessageReceiver, timeout time.Duration, opts ...Option) *Receiver {
+	r := &Receiver{receiver: receiver, timeout: timeout, transfers: make(map[string]*pending)}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// ReceiveAsync registers handler on the wrapped receiver. handler is called
+// with a *Message for every reassembled transfer and with the message itself
+// for messages that are not part of one. It must not acknowledge the message
+// itself: when it returns nil every part is acknowledged, when it returns an
+// error every part is settled as failed, so the broker redelivers the transfer
+// if the receiver was built with that outcome enabled.
+func (r *Receiver) ReceiveAsync(handler func(msg message.InboundMessage) error) error {
+	return r.receiver.ReceiveAsync(func(msg message.InboundMessage) {
+		r.handle(msg, handler)
+	})
+}
+
+// Pending returns the number of transfers waiting for parts.
+func (r *Receiver) Pending() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.transfers)
+}
+
+// Stats returns the current counters.
+func (r *Receiver) Stats() ReceiverStats {
+	return ReceiverStats{
+		Parts:       r.parts.Load(),
+		Reassembled: r.reassembled.Load(),
+		Failed:      r.failed.Load(),
+		Expired:     r.expired.Load(),
+		Corrupt:     r.corrupt.Load(),
+		Unchunked:   r.unchunked.Load(),
+	}
+}
+
+func (r *Receiver) handle(msg message.InboundMessage, handler func(msg message.InboundMessage) error) {
+	val, ok := msg.GetProperty(PropertyTransferID)
+	if !ok || val == nil {
+		r.unchunked.Add(1)
+		r.settle([]message.InboundMessage{msg}, handler(msg))
+		return
+	}
+	r.parts.Add(1)
+	id := fmt.Sprint(val)
+	part, partErr := intProperty(msg, PropertyPart)
+	total, totalErr := intProperty(msg, PropertyTotal)
+	checksum, _ := msg.GetProperty(PropertyChecksum)
+	payload, _ := codec.Payload(msg)
+
+	r.mu.Lock()
+	t := r.transfers[id]
+	if t == nil {
+		t = &pending{id: id, total: total, checksum: fmt.Sprint(checksum), payloads: make(map[int][]byte)}
+		r.transfers[id] = t
+		t.timer = time.AfterFunc(r.timeout, func() {
+			r.expire(t)
+		})
+	} else {
+		t.timer.Reset(r.timeout)
+	}
+	t.messages = append(t.messages, msg)
+	var err error
+	switch {
+	case partErr != nil:
+		err = partErr
+	case totalErr != nil:
+		err = totalErr
+	case total != t.total || fmt.Sprint(checksum) != t.checksum:
+		err = fmt.Errorf("part %d disagrees on the number of parts or the checksum", part)
+	case part < 0 || part >= total:
+		err = fmt.Errorf("part %d is out of range", part)
+	}
+	if _, dup := t.payloads[part]; err == nil && !dup {
+		t.payloads[part] = payload
+	}
+	complete := err == nil && len(t.payloads) == t.total
+	if err != nil || complete {
+		t.timer.Stop()
+		delete(r.transfers, id)
+	}
+	r.mu.Unlock()
+
+	if err != nil {
+		r.corrupt.Add(1)
+		r.discard(t, err)
+		return
+	}
+	if !complete {
+		return
+	}
+
+	assembled, err := t.assemble()
+	if err != nil {
+		r.corrupt.Add(1)
+		r.discard(t, err)
+		return
+	}
+	handlerErr := handler(assembled)
+	if handlerErr != nil {
+		r.failed.Add(1)
+	} else {
+		r.reassembled.Add(1)
+	}
+	r.settle(t.messages, handlerErr)
+}
+
+// assemble joins the payloads in order and verifies the checksum
+func (t *pending) assemble() (*Message, error) {
+	var buf bytes.Buffer
+	for part := 0; part < t.total; part++ {
+		buf.Write(t.payloads[part])
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	if hex.EncodeToString(sum[:]) != t.checksum {
+		return nil, errors.New("checksum mismatch")
+	}
+	first := t.messages[0]
+	for _, msg := range t.messages {
+		if part, _ := intProperty(msg, PropertyPart); part == 0 {
+			first = msg
+			break
+		}
+	}
+	return &Message{InboundMessage: first, transferID: t.id, payload: buf.Bytes(), parts: t.messages}, nil
+}
+
+// expire discards t unless it completed in the meantime
+func (r *Receiver) expire(t *pending) {
+	r.mu.Lock()
+	if r.transfers[t.id] != t {
+		r.mu.Unlock()
+		return
+	}
+	delete(r.transfers, t.id)
+	r.mu.Unlock()
+	r.expired.Add(1)
+	r.discard(t, ErrExpired)
+}
+
+// discard rejects every part of t received so far
+func (r *Receiver) discard(t *pending, err error) {
+	for _, msg := range t.messages {
+		r.receiver.Settle(msg, config.PersistentReceiverRejectedOutcome)
+	}
+	if r.onDiscard != nil {
+		r.onDiscard(&TransferError{TransferID: t.id, Received: len(t.payloads), Total: t.total, Err: err})
+	}
+}
+
+// settle acknowledges msgs when the handler succeeded and fails them otherwise
+func (r *Receiver) settle(msgs []message.InboundMessage, err error) {
+	for _, msg := range msgs {
+		if err != nil {
+			r.receiver.Settle(msg, config.PersistentReceiverFailedOutcome)
+		} else {
+			r.receiver.Ack(msg)
+		}
+	}
+}
+
+func intProperty(msg message.InboundMessage, name string) (int, error) {
+	val, ok := msg.GetProperty(name)
+	if !ok || val == nil {
+		return 0, fmt.Errorf("no %s property", name)
+	}
+	n, err := strconv.Atoi(fmt.Sprint(val))
+	if err != nil {
+		return 0, fmt.Errorf("%s property %v is not a number", name, val)
+	}
+	return n, nil
+}
+
+// Message is a reassembled transfer. Its payload is the whole payload,
+// available both as bytes and as a string, the other accessors are those of
+// its first part.
+type Message struct {
+	message.InboundMessage
+	transferID string
+	payload    []byte
+	parts      []message.InboundMessage
+}
+
+// TransferID returns the ID the publisher gave the transfer.
+func (m *Message) TransferID() string {
+	return m.transferID
+}
+
+// Parts returns the messages the transfer was received in.
+func (m *Message) Parts() []message.InboundMessage {
+	return m.parts
+}
+
+// GetPayloadAsBytes returns the reassembled payload.
+func (m *Message) GetPayloadAsBytes() ([]byte, bool) {
+	return m.payload, true
+}
+
+// GetPayloadAsString returns the reassembled payload as a string.
+func (m *Message) GetPayloadAsString() (string, bool) {
+	return string(m.payload), true
+}
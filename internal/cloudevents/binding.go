@@ -0,0 +1,282 @@
+// Package cloudevents is a CloudEvents protocol binding for Solace messages,
+// built on the binding package of the CloudEvents Go SDK.
+//
+// In binary mode every context attribute and extension of an event is a user
+// property named after it with the "ce-" prefix, e.g. ce-id and ce-type, the
+// datacontenttype is the HTTP content type of the message and the data is the
+// payload. In structured mode the whole event is the JSON payload of the
+// message, whose content type is application/cloudevents+json.
+package cloudevents
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/cloudevents/sdk-go/v2/binding"
+	"github.com/cloudevents/sdk-go/v2/binding/format"
+	"github.com/cloudevents/sdk-go/v2/binding/spec"
+	"github.com/cloudevents/sdk-go/v2/event"
+	"github.com/cloudevents/sdk-go/v2/types"
+	"solace.dev/go/messaging/pkg/solace"
+	"solace.dev/go/messaging/pkg/solace/config"
+	"solace.dev/go/messaging/pkg/solace/message"
+
+	"SolaceSamples.com/PubSub+Go/internal/codec"
+)
+
+// Prefix of the user properties carrying the attributes in binary mode
+const Prefix = "ce-"
+
+// Mode is the content mode of an outbound message.
+type Mode int
+
+// Content modes
+const (
+	Binary Mode = iota
+	Structured
+)
+
+func (m Mode) String() string {
+	if m == Structured {
+		return "structured"
+	}
+	return "binary"
+}
+
+// ParseMode parses "binary" or "structured".
+func ParseMode(s string) (Mode, error) {
+	switch strings.ToLower(s) {
+	case "binary":
+		return Binary, nil
+	case "structured":
+		return Structured, nil
+	default:
+		return Binary, fmt.Errorf("unknown CloudEvents mode %q, binary or structured", s)
+	}
+}
+
+// ErrNotCloudEvent is returned for messages that carry no event.
+var ErrNotCloudEvent = errors.New("message is not a CloudEvent")
+
+var specs = spec.WithPrefix(Prefix)
+
+// IsCloudEvent reports whether msg carries an event in either mode.
+func IsCloudEvent(msg message.InboundMessage) bool {
+	return NewMessage(msg).ReadEncoding() != binding.EncodingUnknown
+}
+
+// ToEvent converts msg to an event. It fails with ErrNotCloudEvent for
+// messages that carry no event and with a validation error for events that
+// violate the specification, e.g. miss a required attribute.
+func ToEvent(msg message.InboundMessage) (*event.Event, error) {
+	m := NewMessage(msg)
+	if m.ReadEncoding() == binding.EncodingUnknown {
+		return nil, ErrNotCloudEvent
+	}
+	e, err := binding.ToEvent(context.Background(), m)
+	if err != nil {
+		return nil, fmt.Errorf("CloudEvent on %s: %w", msg.GetDestinationName(), err)
+	}
+	if err := e.Validate(); err != nil {
+		return nil, fmt.Errorf("CloudEvent on %s: %w", msg.GetDestinationName(), err)
+	}
+	return e, nil
+}
+
+// Build builds a message from e in the given mode. Properties already set on
+// builder are kept. The attributes of e are set on the message only, so one
+// builder can build every event.
+func Build(builder solace.OutboundMessageBuilder, e event.Event, mode Mode) (message.OutboundMessage, error) {
+	if err := e.Validate(); err != nil {
+		return nil, err
+	}
+	ctx := binding.WithForceBinary(context.Background())
+	if mode == Structured {
+		ctx = binding.WithForceStructured(context.Background())
+	}
+	w := &writer{builder: builder, properties: config.MessagePropertyMap{}}
+	if _, err := binding.Write(ctx, binding.ToMessage(&e), w, w); err != nil {
+		return nil, err
+	}
+	return w.build()
+}
+
+// Message reads a received Solace message as a binding.Message.
+type Message struct {
+	msg      message.InboundMessage
+	version  spec.Version
+	encoding binding.Encoding
+}
+
+// NewMessage wraps msg, its content mode is determined right away.
+func NewMessage(msg message.InboundMessage) *Message {
+	m := &Message{msg: msg, encoding: binding.EncodingUnknown}
+	contentType, _ := msg.GetHTTPContentType()
+	if format.IsFormat(contentType) {
+		m.encoding = binding.EncodingStructured
+	} else if val, ok := msg.GetProperty(specs.PrefixedSpecVersionName()); ok {
+		if v := specs.Version(fmt.Sprint(val)); v != nil {
+			m.version = v
+			m.encoding = binding.EncodingBinary
+		}
+	}
+	return m
+}
+
+// ReadEncoding implements binding.MessageReader.
+func (m *Message) ReadEncoding() binding.Encoding {
+	return m.encoding
+}
+
+// ReadStructured implements binding.MessageReader.
+func (m *Message) ReadStructured(ctx context.Context, w binding.StructuredWriter) error {
+	if m.encoding != binding.EncodingStructured {
+		return binding.ErrNotStructured
+	}
+	contentType, _ := m.msg.GetHTTPContentType()
+	data, _ := codec.Payload(m.msg)
+	return w.SetStructuredEvent(ctx, format.Lookup(contentType), bytes.NewReader(data))
+}
+
+// ReadBinary implements binding.MessageReader.
+func (m *Message) ReadBinary(ctx context.Context, w binding.BinaryWriter) error {
+	if m.encoding != binding.EncodingBinary {
+		return binding.ErrNotBinary
+	}
+	// the spec version decides how the other attributes are read
+	specVersion := m.version.AttributeFromKind(spec.SpecVersion)
+	if err := w.SetAttribute(specVersion, m.version.String()); err != nil {
+		return err
+	}
+	for name, val := range m.msg.GetProperties() {
+		if !strings.HasPrefix(strings.ToLower(name), Prefix) || strings.EqualFold(name, specVersion.PrefixedName()) {
+			continue
+		}
+		var err error
+		if attr := m.version.Attribute(name); attr != nil {
+			err = w.SetAttribute(attr, val)
+		} else {
+			err = w.SetExtension(strings.ToLower(strings.TrimPrefix(name, Prefix)), val)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if contentType, ok := m.msg.GetHTTPContentType(); ok && contentType != "" {
+		if err := w.SetAttribute(m.version.AttributeFromKind(spec.DataContentType), contentType); err != nil {
+			return err
+		}
+	}
+	if data, _ := codec.Payload(m.msg); len(data) > 0 {
+		return w.SetData(bytes.NewReader(data))
+	}
+	return nil
+}
+
+// GetAttribute implements binding.MessageMetadataReader for binary mode messages.
+func (m *Message) GetAttribute(kind spec.Kind) (spec.Attribute, interface{}) {
+	if m.version == nil {
+		return nil, nil
+	}
+	attr := m.version.AttributeFromKind(kind)
+	if attr == nil {
+		return nil, nil
+	}
+	if kind == spec.DataContentType {
+		if contentType, ok := m.msg.GetHTTPContentType(); ok && contentType != "" {
+			return attr, contentType
+		}
+		return attr, nil
+	}
+	if val, ok := m.msg.GetProperty(attr.PrefixedName()); ok {
+		return attr, val
+	}
+	return attr, nil
+}
+
+// GetExtension implements binding.MessageMetadataReader for binary mode messages.
+func (m *Message) GetExtension(name string) interface{} {
+	val, _ := m.msg.GetProperty(Prefix + name)
+	return val
+}
+
+// Finish implements binding.Message, settling the Solace message is left to the receiver.
+func (m *Message) Finish(error) error {
+	return nil
+}
+
+// writer collects the properties and payload of an outbound message
+type writer struct {
+	builder     solace.OutboundMessageBuilder
+	properties  config.MessagePropertyMap
+	contentType string
+	data        []byte
+}
+
+// SetStructuredEvent implements binding.StructuredWriter.
+func (w *writer) SetStructuredEvent(ctx context.Context, f format.Format, ev io.Reader) error {
+	w.contentType = f.MediaType()
+	return w.setData(ev)
+}
+
+// Start implements binding.BinaryWriter.
+func (w *writer) Start(ctx context.Context) error {
+	return nil
+}
+
+// SetAttribute implements binding.BinaryWriter.
+func (w *writer) SetAttribute(attr spec.Attribute, value interface{}) error {
+	if value == nil {
+		return nil
+	}
+	s, err := types.Format(value)
+	if err != nil {
+		return err
+	}
+	if attr.Kind() == spec.DataContentType {
+		w.contentType = s
+		return nil
+	}
+	w.properties[config.MessageProperty(Prefix+attr.Name())] = s
+	return nil
+}
+
+// SetExtension implements binding.BinaryWriter.
+func (w *writer) SetExtension(name string, value interface{}) error {
+	if value == nil {
+		return nil
+	}
+	s, err := types.Format(value)
+	if err != nil {
+		return err
+	}
+	w.properties[config.MessageProperty(Prefix+name)] = s
+	return nil
+}
+
+// SetData implements binding.BinaryWriter.
+func (w *writer) SetData(data io.Reader) error {
+	return w.setData(data)
+}
+
+// End implements binding.BinaryWriter.
+func (w *writer) End(ctx context.Context) error {
+	return nil
+}
+
+func (w *writer) setData(data io.Reader) error {
+	var err error
+	w.data, err = io.ReadAll(data)
+	return err
+}
+
+func (w *writer) build() (message.OutboundMessage, error) {
+	if w.contentType != "" {
+		w.properties[config.MessagePropertyHTTPContentType] = w.contentType
+	}
+	return w.builder.BuildWithByteArrayPayload(w.data, w.properties)
+}
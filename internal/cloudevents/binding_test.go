@@ -0,0 +1,295 @@
+package cloudevents
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cloudevents/sdk-go/v2/binding"
+	"github.com/cloudevents/sdk-go/v2/event"
+	"solace.dev/go/messaging/pkg/solace/message"
+	"solace.dev/go/messaging/pkg/solace/resource"
+
+	"SolaceSamples.com/PubSub+Go/internal/fake"
+)
+
+const topic = "solace/samples/go/cloudevents"
+
+// roundTrip publishes msg on the fake broker and returns it as received
+func roundTrip(t *testing.T, service *fake.Service, msg message.OutboundMessage) message.InboundMessage {
+	t.Helper()
+	receiver, _ := service.CreateDirectMessageReceiverBuilder().WithSubscriptions(resource.TopicSubscriptionOf(topic)).Build()
+	publisher, _ := service.CreateDirectMessagePublisherBuilder().Build()
+	if err := receiver.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer receiver.Terminate(0)
+	if err := publisher.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer publisher.Terminate(0)
+	if err := publisher.Publish(msg, resource.TopicOf(topic)); err != nil {
+		t.Fatal(err)
+	}
+	received, err := receiver.ReceiveMessage(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return received
+}
+
+func newEvent(t *testing.T, contentType string, data interface{}) event.Event {
+	t.Helper()
+	e := event.New()
+	e.SetID("1")
+	e.SetSource("/solace/samples/go")
+	e.SetType("com.solace.samples.greeting")
+	e.SetSubject("greeting")
+	e.SetTime(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	e.SetExtension("sequence", 7)
+	e.SetExtension("region", "eu")
+	if data != nil {
+		if err := e.SetData(contentType, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return e
+}
+
+func TestRoundTrip(t *testing.T) {
+	service := fake.New()
+	if err := service.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer service.Disconnect()
+
+	tests := []struct {
+		name            string
+		mode            Mode
+		contentType     string
+		data            interface{}
+		wantEncoding    binding.Encoding
+		wantContentType string
+		wantData        string
+	}{
+		{"binary JSON", Binary, event.ApplicationJSON, map[string]string{"text": "Hello"}, binding.EncodingBinary, event.ApplicationJSON, `{"text":"Hello"}`},
+		{"binary text", Binary, event.TextPlain, "Hello", binding.EncodingBinary, event.TextPlain, "Hello"},
+		{"binary without data", Binary, "", nil, binding.EncodingBinary, "", ""},
+		{"structured JSON", Structured, event.ApplicationJSON, map[string]string{"text": "Hello"}, binding.EncodingStructured, event.ApplicationCloudEventsJSON, `{"text":"Hello"}`},
+		{"structured without data", Structured, "", nil, binding.EncodingStructured, event.ApplicationCloudEventsJSON, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sent := newEvent(t, tt.contentType, tt.data)
+			msg, err := Build(service.MessageBuilder(), sent, tt.mode)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if contentType, _ := msg.GetHTTPContentType(); contentType != tt.wantContentType {
+				t.Errorf("content type %q, want %q", contentType, tt.wantContentType)
+			}
+
+			received := roundTrip(t, service, msg)
+			if encoding := NewMessage(received).ReadEncoding(); encoding != tt.wantEncoding {
+				t.Fatalf("encoding %v, want %v", encoding, tt.wantEncoding)
+			}
+			if !IsCloudEvent(received) {
+				t.Fatal("not recognized as a CloudEvent")
+			}
+			got, err := ToEvent(received)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.ID() != sent.ID() || got.Source() != sent.Source() || got.Type() != sent.Type() ||
+				got.Subject() != sent.Subject() || !got.Time().Equal(sent.Time()) || got.SpecVersion() != sent.SpecVersion() {
+				t.Errorf("attributes differ:\n got %s\nwant %s", got.Context, sent.Context)
+			}
+			if got.DataContentType() != tt.contentType {
+				t.Errorf("datacontenttype %q, want %q", got.DataContentType(), tt.contentType)
+			}
+			if tt.wantData == "" {
+				if len(got.Data()) != 0 {
+					t.Errorf("data %q, want none", got.Data())
+				}
+			} else if string(got.Data()) != tt.wantData {
+				t.Errorf("data %q, want %q", got.Data(), tt.wantData)
+			}
+			// extensions come back as strings in binary mode, as JSON values in structured mode
+			extensions := got.Extensions()
+			if extensions["region"] != "eu" {
+				t.Errorf("extension region = %v", extensions["region"])
+			}
+			if sequence, err := got.Context.GetExtension("sequence"); err != nil || (sequence != "7" && sequence != int32(7)) {
+				t.Errorf("extension sequence = %v (%T), %v", sequence, sequence, err)
+			}
+		})
+	}
+}
+
+func TestBinaryProperties(t *testing.T) {
+	service := fake.New()
+	if err := service.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer service.Disconnect()
+
+	msg, err := Build(service.MessageBuilder(), newEvent(t, event.TextPlain, "Hello"), Binary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"ce-specversion": "1.0",
+		"ce-id":          "1",
+		"ce-source":      "/solace/samples/go",
+		"ce-type":        "com.solace.samples.greeting",
+		"ce-subject":     "greeting",
+		"ce-time":        "2024-05-01T12:00:00Z",
+		"ce-sequence":    "7",
+		"ce-region":      "eu",
+	}
+	properties := msg.GetProperties()
+	for name, val := range want {
+		if got, ok := properties[name]; !ok || got != val {
+			t.Errorf("property %s = %v, want %s", name, got, val)
+		}
+	}
+	if _, ok := properties["ce-datacontenttype"]; ok {
+		t.Error("datacontenttype is a user property, want the content type of the message")
+	}
+	if len(properties) != len(want) {
+		t.Errorf("properties %v, want only %v", properties, want)
+	}
+	if body, _ := msg.GetPayloadAsBytes(); string(body) != "Hello" {
+		t.Errorf("payload %q", body)
+	}
+}
+
+func TestStructuredPayload(t *testing.T) {
+	service := fake.New()
+	if err := service.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer service.Disconnect()
+
+	msg, err := Build(service.MessageBuilder(), newEvent(t, event.ApplicationJSON, map[string]int{"seq": 1}), Structured)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msg.GetProperties()) != 0 {
+		t.Errorf("user properties %v in structured mode", msg.GetProperties())
+	}
+	body, _ := msg.GetPayloadAsBytes()
+	var envelope map[string]interface{}
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]interface{}{
+		"specversion":     "1.0",
+		"id":              "1",
+		"type":            "com.solace.samples.greeting",
+		"datacontenttype": event.ApplicationJSON,
+		"region":          "eu",
+	} {
+		if envelope[name] != want {
+			t.Errorf("%s = %v, want %v", name, envelope[name], want)
+		}
+	}
+	if data, ok := envelope["data"].(map[string]interface{}); !ok || data["seq"] != 1.0 {
+		t.Errorf("data = %v", envelope["data"])
+	}
+}
+
+func TestToEventErrors(t *testing.T) {
+	plain := fake.NewInboundMessage(topic, "Hello", nil)
+	if IsCloudEvent(plain) {
+		t.Error("plain message recognized as a CloudEvent")
+	}
+	if _, err := ToEvent(plain); !errors.Is(err, ErrNotCloudEvent) {
+		t.Errorf("error %v, want ErrNotCloudEvent", err)
+	}
+
+	// binary mode without the required id and source
+	invalid := fake.NewInboundMessage(topic, "Hello", map[string]interface{}{
+		"ce-specversion": "1.0",
+		"ce-type":        "com.solace.samples.greeting",
+	})
+	if !IsCloudEvent(invalid) {
+		t.Fatal("binary mode message not recognized")
+	}
+	if _, err := ToEvent(invalid); err == nil {
+		t.Error("event without id and source accepted")
+	}
+
+	unknownVersion := fake.NewInboundMessage(topic, "Hello", map[string]interface{}{"ce-specversion": "9.9"})
+	if IsCloudEvent(unknownVersion) {
+		t.Error("unknown spec version recognized as a CloudEvent")
+	}
+
+	service := fake.New()
+	if _, err := Build(service.MessageBuilder(), event.New(), Binary); err == nil {
+		t.Error("invalid event built")
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	for s, want := range map[string]Mode{"binary": Binary, "Structured": Structured} {
+		if got, err := ParseMode(s); err != nil || got != want {
+			t.Errorf("ParseMode(%q) = %v, %v", s, got, err)
+		}
+	}
+	if _, err := ParseMode("batched"); err == nil {
+		t.Error("unknown mode accepted")
+	}
+}
+
+func TestBuildKeepsBuilder(t *testing.T) {
+	service := fake.New()
+	if err := service.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer service.Disconnect()
+	builder := service.MessageBuilder().WithProperty("application", "samples")
+
+	// the first event has a subject and extensions, the second one neither
+	first, err := Build(builder, newEvent(t, event.ApplicationJSON, map[string]string{"text": "Hello"}), Binary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := event.New()
+	second.SetID("2")
+	second.SetSource("/solace/samples/go")
+	second.SetType("com.solace.samples.greeting")
+	secondMsg, err := Build(builder, second, Binary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text, err := builder.BuildWithStringPayload("Hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if contentType, _ := first.(*fake.Message).GetHTTPContentType(); contentType != event.ApplicationJSON {
+		t.Errorf("first event content type %q", contentType)
+	}
+	for _, msg := range []message.OutboundMessage{first, secondMsg, text} {
+		if application, _ := msg.GetProperties()["application"]; application != "samples" {
+			t.Errorf("property application = %v, want the builder's", application)
+		}
+	}
+	for _, name := range []string{"ce-subject", "ce-time", "ce-sequence", "ce-region"} {
+		if _, ok := secondMsg.GetProperties()[name]; ok {
+			t.Errorf("%s of the first event left on the second", name)
+		}
+	}
+	if id := secondMsg.GetProperties()["ce-id"]; id != "2" {
+		t.Errorf("second event ce-id = %v", id)
+	}
+	if contentType, ok := secondMsg.(*fake.Message).GetHTTPContentType(); ok && contentType != "" {
+		t.Errorf("content type %q of the first event left on the second", contentType)
+	}
+	received := roundTrip(t, service, text)
+	if IsCloudEvent(received) {
+		t.Errorf("text message built after the events is a CloudEvent: %v", received.GetProperties())
+	}
+}
@@ -0,0 +1,162 @@
+package codec
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/hamba/avro/v2"
+	"solace.dev/go/messaging/pkg/solace"
+	"solace.dev/go/messaging/pkg/solace/config"
+	"solace.dev/go/messaging/pkg/solace/message"
+	"solace.dev/go/messaging/pkg/solace/resource"
+
+	"SolaceSamples.com/PubSub+Go/internal/schema"
+)
+
+// ContentTypeAvro is the content type of Avro payloads.
+const ContentTypeAvro = "avro/binary"
+
+// PropertySchemaID is the user property carrying the registry ID of the schema
+// a payload was written with.
+const PropertySchemaID = "schema_id"
+
+// IsAvro reports whether the content header of msg announces an Avro payload.
+func IsAvro(msg message.InboundMessage) bool {
+	contentType, ok := msg.GetHTTPContentType()
+	return ok && contentType == ContentTypeAvro
+}
+
+// Avro encodes values of T with the Avro schema of a subject and decodes
+// payloads written with any version of it. T is a struct with avro field tags
+// or a map[string]interface{}.
+type Avro[T any] struct {
+	registry schema.Registry
+	schema   *schema.Schema
+	parsed   avro.Schema
+
+	mu      sync.Mutex
+	readers map[int]avro.Schema // resolved against the writer schema of the ID
+}
+
+// NewAvro registers definition as a version of subject in registry. It fails
+// with a *schema.IncompatibleError when the subject's compatibility rule
+// rejects it, so values are never published with an incompatible schema.
+func NewAvro[T any](registry schema.Registry, subject, definition string) (*Avro[T], error) {
+	parsed, err := schema.ParseAvro(definition)
+	if err != nil {
+		return nil, fmt.Errorf("avro schema of %s: %w", subject, err)
+	}
+	registered, err := registry.Register(subject, schema.Avro, definition)
+	if err != nil {
+		return nil, err
+	}
+	return &Avro[T]{registry: registry, schema: registered, parsed: parsed, readers: make(map[int]avro.Schema)}, nil
+}
+
+// Schema returns the registered version values are encoded with.
+func (a *Avro[T]) Schema() *schema.Schema {
+	return a.schema
+}
+
+// Encode builds a message with value encoded with the schema, the Avro
+// content type and the schema ID. The header is set on the message only, so
+// builder can be shared with messages of other content types.
+func (a *Avro[T]) Encode(builder solace.OutboundMessageBuilder, value T) (message.OutboundMessage, error) {
+	payload, err := avro.Marshal(a.parsed, value)
+	if err != nil {
+		return nil, fmt.Errorf("encode %T with %s: %w", value, a.schema, err)
+	}
+	return builder.BuildWithByteArrayPayload(payload, schemaHeader(ContentTypeAvro, a.schema.ID))
+}
+
+// Publish publishes value encoded with the schema on topic.
+func (a *Avro[T]) Publish(publisher *Publisher, topic *resource.Topic, value T) error {
+	msg, err := a.Encode(publisher.builder, value)
+	if err != nil {
+		return err
+	}
+	return publisher.publish(msg, topic)
+}
+
+// Decode decodes the payload of msg into a T, resolving the schema it was
+// written with against the schema of a. It fails with a DecodeError.
+func (a *Avro[T]) Decode(msg message.InboundMessage) (T, error) {
+	var value T
+	reader, err := a.reader(msg)
+	if err != nil {
+		return value, &DecodeError{Topic: msg.GetDestinationName(), Err: err}
+	}
+	payload, ok := Payload(msg)
+	if !ok {
+		return value, &DecodeError{Topic: msg.GetDestinationName(), Err: errors.New("no payload")}
+	}
+	if err := avro.Unmarshal(reader, payload, &value); err != nil {
+		return value, &DecodeError{Topic: msg.GetDestinationName(), Err: fmt.Errorf("%T: %w", value, err)}
+	}
+	return value, nil
+}
+
+// reader returns the schema to read the payload of msg with
+func (a *Avro[T]) reader(msg message.InboundMessage) (avro.Schema, error) {
+	id, err := checkSchemaHeader(msg, ContentTypeAvro)
+	if err != nil {
+		return nil, err
+	}
+	if id == a.schema.ID {
+		return a.parsed, nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if reader, ok := a.readers[id]; ok {
+		return reader, nil
+	}
+	written, err := a.registry.ByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if written.Format != schema.Avro {
+		return nil, fmt.Errorf("schema %s is %s, not Avro", written, written.Format)
+	}
+	writer, err := schema.ParseAvro(written.Definition)
+	if err != nil {
+		return nil, fmt.Errorf("schema %s: %w", written, err)
+	}
+	reader, err := avro.NewSchemaCompatibility().Resolve(a.parsed, writer)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read %s with %s: %w", written, a.schema, err)
+	}
+	a.readers[id] = reader
+	return reader, nil
+}
+
+// schemaHeader returns the content header and schema ID of a single message
+func schemaHeader(contentType string, id int) config.MessagePropertyMap {
+	return config.MessagePropertyMap{
+		config.MessagePropertyHTTPContentType:     contentType,
+		config.MessagePropertyHTTPContentEncoding: EncodingIdentity,
+		PropertySchemaID:                          int64(id),
+	}
+}
+
+// checkSchemaHeader checks the content header of msg and returns its schema ID
+func checkSchemaHeader(msg message.InboundMessage, contentType string) (int, error) {
+	if got, ok := msg.GetHTTPContentType(); ok && got != contentType {
+		encoding, _ := msg.GetHTTPContentEncoding()
+		return 0, &ContentTypeError{ContentType: got, Encoding: encoding, Want: contentType}
+	}
+	if encoding, ok := msg.GetHTTPContentEncoding(); ok && encoding != "" && encoding != EncodingIdentity {
+		return 0, &ContentTypeError{Encoding: encoding, Want: contentType}
+	}
+	val, ok := msg.GetProperty(PropertySchemaID)
+	if !ok || val == nil {
+		return 0, fmt.Errorf("no %s property", PropertySchemaID)
+	}
+	id, err := strconv.Atoi(fmt.Sprint(val))
+	if err != nil {
+		return 0, fmt.Errorf("%s property %v is not a number", PropertySchemaID, val)
+	}
+	return id, nil
+}
@@ -0,0 +1,148 @@
+package codec
+
+import (
+	"errors"
+	"testing"
+
+	"solace.dev/go/messaging/pkg/solace/message"
+
+	"SolaceSamples.com/PubSub+Go/internal/fake"
+	"SolaceSamples.com/PubSub+Go/internal/schema"
+)
+
+const greetingSubject = "solace-samples-greeting"
+
+type greeting struct {
+	Text     string `avro:"text"`
+	Sequence int    `avro:"seq"`
+	Language string `avro:"language"`
+}
+
+// oldGreeting is written with the first version of the schema, without a language
+type oldGreeting struct {
+	Text     string `avro:"text"`
+	Sequence int    `avro:"seq"`
+}
+
+const (
+	oldGreetingSchema = `{"type": "record", "name": "Greeting", "namespace": "solace.samples", "fields": [
+		{"name": "text", "type": "string"},
+		{"name": "seq", "type": "int"}
+	]}`
+	greetingSchema = `{"type": "record", "name": "Greeting", "namespace": "solace.samples", "fields": [
+		{"name": "text", "type": "string"},
+		{"name": "seq", "type": "int"},
+		{"name": "language", "type": "string", "default": "go"}
+	]}`
+)
+
+func TestAvroRoundTrip(t *testing.T) {
+	registry := schema.NewMemory()
+	writerV1, err := NewAvro[oldGreeting](registry, greetingSubject, oldGreetingSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := NewAvro[greeting](registry, greetingSubject, greetingSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if writerV1.Schema().Version != 1 || reader.Schema().Version != 2 {
+		t.Fatalf("registered %s and %s", writerV1.Schema(), reader.Schema())
+	}
+
+	builder := fake.New().MessageBuilder().WithProperty("application", "samples")
+	tests := []struct {
+		name   string
+		encode func() (message.OutboundMessage, error)
+		want   greeting
+	}{
+		{"same version", func() (message.OutboundMessage, error) {
+			return reader.Encode(builder, greeting{Text: "Hello", Sequence: 1, Language: "fr"})
+		}, greeting{Text: "Hello", Sequence: 1, Language: "fr"}},
+		{"older version resolved with the default", func() (message.OutboundMessage, error) {
+			return writerV1.Encode(builder, oldGreeting{Text: "Hello", Sequence: 2})
+		}, greeting{Text: "Hello", Sequence: 2, Language: "go"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := tt.encode()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := reader.Decode(msg.(*fake.Message))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("decoded %+v, want %+v", got, tt.want)
+			}
+			if application, _ := msg.GetProperty("application"); application != "samples" {
+				t.Errorf("property application = %v, want the builder's", application)
+			}
+		})
+	}
+
+	// the content header and schema ID are set on the Avro messages only
+	text, err := builder.BuildWithStringPayload("Hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType, ok := text.GetHTTPContentType(); ok && contentType != "" {
+		t.Errorf("content type %q left on the builder", contentType)
+	}
+	if text.HasProperty(PropertySchemaID) {
+		t.Error("schema ID left on the builder")
+	}
+}
+
+func TestAvroDecodeErrors(t *testing.T) {
+	registry := schema.NewMemory()
+	avroCodec, err := NewAvro[greeting](registry, greetingSubject, greetingSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewAvro[greeting](registry, greetingSubject, `{"type": "record"}`); err == nil {
+		t.Error("invalid schema registered")
+	}
+	// an incompatible schema under another subject, so it has an ID but cannot be resolved
+	other, err := registry.Register("other", schema.Avro, `{"type": "record", "name": "Greeting", "namespace": "solace.samples", "fields": [
+		{"name": "text", "type": "int"}
+	]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := int64(avroCodec.Schema().ID)
+	tests := []struct {
+		name       string
+		properties map[string]interface{}
+	}{
+		{"no schema ID", nil},
+		{"schema ID not a number", map[string]interface{}{PropertySchemaID: "one"}},
+		{"unknown schema ID", map[string]interface{}{PropertySchemaID: int64(99)}},
+		{"unresolvable schema", map[string]interface{}{PropertySchemaID: int64(other.ID)}},
+		{"truncated payload", map[string]interface{}{PropertySchemaID: id}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := avroCodec.Decode(fake.NewInboundMessage("a/b", "\x02", tt.properties))
+			var decodeErr *DecodeError
+			if !errors.As(err, &decodeErr) || decodeErr.Topic != "a/b" {
+				t.Errorf("error %v, want a DecodeError", err)
+			}
+		})
+	}
+
+	// a JSON message is refused on its content type
+	jsonMsg, err := Encode(fake.New().MessageBuilder(), greeting{Text: "Hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var contentTypeErr *ContentTypeError
+	if _, err := avroCodec.Decode(jsonMsg.(*fake.Message)); !errors.As(err, &contentTypeErr) || contentTypeErr.Want != ContentTypeAvro {
+		t.Errorf("error %v, want a ContentTypeError", err)
+	}
+	if IsAvro(jsonMsg.(*fake.Message)) {
+		t.Error("JSON message reported as Avro")
+	}
+}
@@ -0,0 +1,184 @@
+// Package codec publishes typed values as JSON or Avro payloads and decodes
+// them again. Avro payloads carry the ID of their schema in the schema_id user
+// property, see package schema for the registry.
+//
+// Encoded messages carry their content type and content encoding in the HTTP
+// content header of the message, so receivers written in other languages, and
+// REST or MQTT consumers of the same topics, can tell what the payload is.
+// Decode refuses messages whose header announces anything else than JSON,
+// while messages without a content type are decoded as JSON on a best effort
+// basis, as older publishers do not set one.
+package codec
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"strings"
+	"time"
+
+	"solace.dev/go/messaging/pkg/solace"
+	"solace.dev/go/messaging/pkg/solace/config"
+	"solace.dev/go/messaging/pkg/solace/message"
+	"solace.dev/go/messaging/pkg/solace/resource"
+)
+
+// Content header of encoded messages
+const (
+	ContentTypeJSON  = "application/json"
+	EncodingIdentity = "identity"
+)
+
+// ContentTypeError tells that the content header of a message announces
+// another payload than the decoder expects.
+type ContentTypeError struct {
+	ContentType string
+	Encoding    string
+	Want        string // content type expected
+}
+
+func (e *ContentTypeError) Error() string {
+	if e.Encoding != "" && e.Encoding != EncodingIdentity {
+		return fmt.Sprintf("content encoding %q must be removed before decoding the %s payload", e.Encoding, e.Want)
+	}
+	return fmt.Sprintf("content type %q instead of %s", e.ContentType, e.Want)
+}
+
+// DecodeError tells why the payload of a received message could not be decoded.
+type DecodeError struct {
+	Topic string
+	Err   error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("decode message on %s: %v", e.Topic, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// Payload returns the payload of msg, whether it was published as text or bytes.
+func Payload(msg message.InboundMessage) ([]byte, bool) {
+	if payload, ok := msg.GetPayloadAsString(); ok {
+		return []byte(payload), true
+	}
+	return msg.GetPayloadAsBytes()
+}
+
+// Text returns the payload of msg as text, empty when it has none.
+func Text(msg message.InboundMessage) string {
+	payload, _ := Payload(msg)
+	return string(payload)
+}
+
+// IsJSON reports whether the content header of msg announces a JSON payload,
+// either application/json or a type with the +json suffix.
+func IsJSON(msg message.InboundMessage) bool {
+	contentType, ok := msg.GetHTTPContentType()
+	return ok && isJSON(contentType)
+}
+
+func isJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == ContentTypeJSON || (strings.HasPrefix(mediaType, "application/") && strings.HasSuffix(mediaType, "+json"))
+}
+
+// Encode builds a message with value encoded as JSON and the JSON content
+// header. The header is set on the message only, so builder can be shared
+// with messages of other content types.
+func Encode[T any](builder solace.OutboundMessageBuilder, value T) (message.OutboundMessage, error) {
+	payload, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("encode %T: %w", value, err)
+	}
+	return builder.BuildWithByteArrayPayload(payload, config.MessagePropertyMap{
+		config.MessagePropertyHTTPContentType:     ContentTypeJSON,
+		config.MessagePropertyHTTPContentEncoding: EncodingIdentity,
+	})
+}
+
+// Decode decodes the JSON payload of msg into a T. It fails with a
+// DecodeError, which wraps a ContentTypeError when the content header
+// announces another payload.
+func Decode[T any](msg message.InboundMessage) (T, error) {
+	var value T
+	contentType, hasType := msg.GetHTTPContentType()
+	encoding, _ := msg.GetHTTPContentEncoding()
+	if (hasType && !isJSON(contentType)) || (encoding != "" && encoding != EncodingIdentity) {
+		return value, &DecodeError{Topic: msg.GetDestinationName(), Err: &ContentTypeError{ContentType: contentType, Encoding: encoding, Want: ContentTypeJSON}}
+	}
+	payload, ok := Payload(msg)
+	if !ok {
+		return value, &DecodeError{Topic: msg.GetDestinationName(), Err: errors.New("no payload")}
+	}
+	if err := json.Unmarshal(payload, &value); err != nil {
+		return value, &DecodeError{Topic: msg.GetDestinationName(), Err: fmt.Errorf("%T: %w", value, err)}
+	}
+	return value, nil
+}
+
+// PersistentPublisher is implemented by solace.PersistentMessagePublisher and
+// the wrappers around it, e.g. retry.Publisher.
+type PersistentPublisher interface {
+	Publish(msg message.OutboundMessage, destination *resource.Topic, properties config.MessagePropertiesConfigurationProvider, userContext interface{}) error
+}
+
+// Publisher publishes values with a direct or persistent publisher. Like the
+// message builder it uses, it must not be shared between goroutines.
+type Publisher struct {
+	builder solace.OutboundMessageBuilder
+	publish func(msg message.OutboundMessage, destination *resource.Topic) error
+}
+
+// Direct publishes with publisher the messages built with builder.
+func Direct(publisher solace.DirectMessagePublisher, builder solace.OutboundMessageBuilder) *Publisher {
+	return &Publisher{builder: builder, publish: publisher.Publish}
+}
+
+// Persistent publishes with publisher the messages built with builder. Their
+// publish receipts carry no user context, use Encode to publish with one.
+func Persistent(publisher PersistentPublisher, builder solace.OutboundMessageBuilder) *Publisher {
+	return &Publisher{builder: builder, publish: func(msg message.OutboundMessage, destination *resource.Topic) error {
+		return publisher.Publish(msg, destination, nil, nil)
+	}}
+}
+
+// Publish publishes value encoded as JSON on topic.
+func Publish[T any](publisher *Publisher, topic *resource.Topic, value T) error {
+	msg, err := Encode(publisher.builder, value)
+	if err != nil {
+		return err
+	}
+	return publisher.publish(msg, topic)
+}
+
+// Request publishes request encoded as JSON on topic and decodes the reply
+// into a Resp, waiting at most timeout for it. The error of a missing reply is
+// the *solace.TimeoutError of the publisher, a reply that cannot be decoded
+// fails with a DecodeError.
+func Request[Resp, Req any](publisher solace.RequestReplyMessagePublisher, builder solace.OutboundMessageBuilder, topic *resource.Topic, request Req, timeout time.Duration) (Resp, error) {
+	var response Resp
+	msg, err := Encode(builder, request)
+	if err != nil {
+		return response, err
+	}
+	reply, err := publisher.PublishAwaitResponse(msg, topic, timeout, nil)
+	if err != nil {
+		return response, err
+	}
+	return Decode[Resp](reply)
+}
+
+// Reply answers a request with value encoded as JSON.
+func Reply[T any](replier solace.Replier, builder solace.OutboundMessageBuilder, value T) error {
+	msg, err := Encode(builder, value)
+	if err != nil {
+		return err
+	}
+	return replier.Reply(msg)
+}
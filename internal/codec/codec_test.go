@@ -0,0 +1,151 @@
+package codec
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"solace.dev/go/messaging/pkg/solace"
+	"solace.dev/go/messaging/pkg/solace/message"
+	"solace.dev/go/messaging/pkg/solace/resource"
+
+	"SolaceSamples.com/PubSub+Go/internal/fake"
+)
+
+func TestEncode(t *testing.T) {
+	builder := fake.New().MessageBuilder().WithProperty("application", "samples")
+	msg, err := Encode(builder, greeting{Text: "Hello", Sequence: 1, Language: "go"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	inbound := msg.(*fake.Message)
+	if !IsJSON(inbound) {
+		t.Error("encoded message is not announced as JSON")
+	}
+	if encoding, _ := inbound.GetHTTPContentEncoding(); encoding != EncodingIdentity {
+		t.Errorf("content encoding %q", encoding)
+	}
+	if application, _ := inbound.GetProperty("application"); application != "samples" {
+		t.Errorf("property application = %v, want the builder's", application)
+	}
+	if body := Text(inbound); body != `{"Text":"Hello","Sequence":1,"Language":"go"}` {
+		t.Errorf("payload %s", body)
+	}
+
+	// the content header is set on the JSON message only
+	text, err := builder.BuildWithStringPayload("Hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType, ok := text.GetHTTPContentType(); ok && contentType != "" {
+		t.Errorf("content type %q left on the builder", contentType)
+	}
+
+	if _, err := Encode(builder, func() {}); err == nil {
+		t.Error("encoded a func")
+	}
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name            string
+		payload         string
+		contentType     string
+		encoding        string
+		want            greeting
+		wantContentType bool // fails with a ContentTypeError
+		wantErr         bool
+	}{
+		{name: "JSON", payload: `{"Text": "Hello", "Sequence": 2}`, contentType: ContentTypeJSON, want: greeting{Text: "Hello", Sequence: 2}},
+		{name: "JSON with charset", payload: `{"Text": "Hello"}`, contentType: "application/json; charset=utf-8", want: greeting{Text: "Hello"}},
+		{name: "+json suffix", payload: `{"Text": "Hello"}`, contentType: "application/greeting+json", want: greeting{Text: "Hello"}},
+		{name: "no content type", payload: `{"Text": "Hello"}`, want: greeting{Text: "Hello"}},
+		{name: "identity encoding", payload: `{"Text": "Hello"}`, contentType: ContentTypeJSON, encoding: EncodingIdentity, want: greeting{Text: "Hello"}},
+		{name: "text", payload: "Hello", contentType: "text/plain", wantContentType: true, wantErr: true},
+		{name: "gzip encoding", payload: "\x1f\x8b", contentType: ContentTypeJSON, encoding: "gzip", wantContentType: true, wantErr: true},
+		{name: "invalid JSON", payload: `{"Text": `, contentType: ContentTypeJSON, wantErr: true},
+		{name: "wrong type", payload: `{"Sequence": "two"}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			builder := fake.New().MessageBuilder()
+			if tt.contentType != "" || tt.encoding != "" {
+				builder = builder.WithHTTPContentHeader(tt.contentType, tt.encoding)
+			}
+			msg, err := builder.BuildWithByteArrayPayload([]byte(tt.payload))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := Decode[greeting](msg.(*fake.Message))
+			if tt.wantErr {
+				var decodeErr *DecodeError
+				if !errors.As(err, &decodeErr) {
+					t.Fatalf("error %v, want a DecodeError", err)
+				}
+				var contentTypeErr *ContentTypeError
+				if errors.As(err, &contentTypeErr) != tt.wantContentType {
+					t.Errorf("error %v, want a ContentTypeError: %v", err, tt.wantContentType)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("decoded %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPublishAndReply(t *testing.T) {
+	const topic = "solace/samples/go/direct/request"
+	broker := fake.NewBroker()
+	replierService := broker.NewService()
+	if err := replierService.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer replierService.Disconnect()
+	receiver, err := replierService.RequestReply().CreateRequestReplyMessageReceiverBuilder().Build(resource.TopicSubscriptionOf(topic))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := receiver.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer receiver.Terminate(0)
+
+	// a replier answering every JSON greeting with the text in upper case
+	replyBuilder := replierService.MessageBuilder()
+	if err := receiver.ReceiveAsync(func(msg message.InboundMessage, replier solace.Replier) {
+		request, err := Decode[greeting](msg)
+		if err != nil || replier == nil {
+			return
+		}
+		Reply(replier, replyBuilder, greeting{Text: "HELLO", Sequence: request.Sequence})
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	service := broker.NewService()
+	if err := service.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer service.Disconnect()
+	publisher, err := service.RequestReply().CreateRequestReplyMessagePublisherBuilder().Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := publisher.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer publisher.Terminate(0)
+
+	reply, err := Request[greeting](publisher, service.MessageBuilder(), resource.TopicOf(topic), greeting{Text: "Hello", Sequence: 3}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply != (greeting{Text: "HELLO", Sequence: 3}) {
+		t.Errorf("reply %+v", reply)
+	}
+}
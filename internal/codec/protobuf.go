@@ -0,0 +1,107 @@
+package codec
+
+import (
+	"errors"
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+	"solace.dev/go/messaging/pkg/solace"
+	"solace.dev/go/messaging/pkg/solace/message"
+	"solace.dev/go/messaging/pkg/solace/resource"
+
+	"SolaceSamples.com/PubSub+Go/internal/schema"
+)
+
+// ContentTypeProtobuf is the content type of Protobuf payloads.
+const ContentTypeProtobuf = "application/x-protobuf"
+
+// IsProtobuf reports whether the content header of msg announces a Protobuf payload.
+func IsProtobuf(msg message.InboundMessage) bool {
+	contentType, ok := msg.GetHTTPContentType()
+	return ok && contentType == ContentTypeProtobuf
+}
+
+// Protobuf encodes and decodes generated Protobuf messages of type T, whose
+// schema is registered under a subject.
+type Protobuf[T proto.Message] struct {
+	registry schema.Registry
+	schema   *schema.Schema
+}
+
+// NewProtobuf registers the schema of T as a version of subject in registry.
+// It fails with a *schema.IncompatibleError when the subject's compatibility
+// rule rejects it, so messages are never published with an incompatible schema.
+func NewProtobuf[T proto.Message](registry schema.Registry, subject string) (*Protobuf[T], error) {
+	var zero T
+	definition, err := schema.ProtobufDefinition(zero.ProtoReflect().Descriptor())
+	if err != nil {
+		return nil, fmt.Errorf("protobuf schema of %s: %w", subject, err)
+	}
+	registered, err := registry.Register(subject, schema.Protobuf, definition)
+	if err != nil {
+		return nil, err
+	}
+	return &Protobuf[T]{registry: registry, schema: registered}, nil
+}
+
+// Schema returns the registered version messages are encoded with.
+func (p *Protobuf[T]) Schema() *schema.Schema {
+	return p.schema
+}
+
+// Encode builds a message with value in the Protobuf wire format, the
+// Protobuf content type and the schema ID. The header is set on the message
+// only, so builder can be shared with messages of other content types.
+func (p *Protobuf[T]) Encode(builder solace.OutboundMessageBuilder, value T) (message.OutboundMessage, error) {
+	payload, err := proto.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("encode %T with %s: %w", value, p.schema, err)
+	}
+	return builder.BuildWithByteArrayPayload(payload, schemaHeader(ContentTypeProtobuf, p.schema.ID))
+}
+
+// Publish publishes value in the Protobuf wire format on topic.
+func (p *Protobuf[T]) Publish(publisher *Publisher, topic *resource.Topic, value T) error {
+	msg, err := p.Encode(publisher.builder, value)
+	if err != nil {
+		return err
+	}
+	return publisher.publish(msg, topic)
+}
+
+// Decode decodes the payload of msg into a new T. The schema ID of msg must be
+// a registered Protobuf schema, fields that T does not know are kept as
+// unknown fields. It fails with a DecodeError.
+func (p *Protobuf[T]) Decode(msg message.InboundMessage) (T, error) {
+	var zero T
+	if err := p.checkWriter(msg); err != nil {
+		return zero, &DecodeError{Topic: msg.GetDestinationName(), Err: err}
+	}
+	payload, ok := Payload(msg)
+	if !ok {
+		return zero, &DecodeError{Topic: msg.GetDestinationName(), Err: errors.New("no payload")}
+	}
+	value := zero.ProtoReflect().New().Interface().(T)
+	if err := proto.Unmarshal(payload, value); err != nil {
+		return zero, &DecodeError{Topic: msg.GetDestinationName(), Err: fmt.Errorf("%T: %w", value, err)}
+	}
+	return value, nil
+}
+
+func (p *Protobuf[T]) checkWriter(msg message.InboundMessage) error {
+	id, err := checkSchemaHeader(msg, ContentTypeProtobuf)
+	if err != nil {
+		return err
+	}
+	if id == p.schema.ID {
+		return nil
+	}
+	written, err := p.registry.ByID(id)
+	if err != nil {
+		return err
+	}
+	if written.Format != schema.Protobuf {
+		return fmt.Errorf("schema %s is %s, not Protobuf", written, written.Format)
+	}
+	return nil
+}
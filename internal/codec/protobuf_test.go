@@ -0,0 +1,132 @@
+package codec
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"SolaceSamples.com/PubSub+Go/internal/fake"
+	"SolaceSamples.com/PubSub+Go/internal/schema"
+)
+
+const timestampSubject = "solace-samples-timestamp"
+
+func TestProtobufRoundTrip(t *testing.T) {
+	registry := schema.NewMemory()
+	timestamps, err := NewProtobuf[*timestamppb.Timestamp](registry, timestampSubject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if timestamps.Schema().Format != schema.Protobuf || timestamps.Schema().Version != 1 {
+		t.Fatalf("registered %s", timestamps.Schema())
+	}
+	// registering the same message again reuses the version
+	again, err := NewProtobuf[*timestamppb.Timestamp](registry, timestampSubject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Schema().ID != timestamps.Schema().ID {
+		t.Errorf("registered %s again as %s", timestamps.Schema(), again.Schema())
+	}
+
+	builder := fake.New().MessageBuilder().WithProperty("application", "samples")
+	want := timestamppb.New(time.Date(2026, 10, 17, 12, 0, 0, 42, time.UTC))
+	msg, err := timestamps.Encode(builder, want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inbound := msg.(*fake.Message)
+	if !IsProtobuf(inbound) || IsAvro(inbound) || IsJSON(inbound) {
+		t.Errorf("content type of the encoded message: %v", inbound)
+	}
+	if id, ok := inbound.GetProperty(PropertySchemaID); !ok || id != int64(timestamps.Schema().ID) {
+		t.Errorf("schema ID %v, want %d", id, timestamps.Schema().ID)
+	}
+	if application, _ := inbound.GetProperty("application"); application != "samples" {
+		t.Errorf("property application = %v, want the builder's", application)
+	}
+	got, err := timestamps.Decode(inbound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(got, want) {
+		t.Errorf("decoded %v, want %v", got, want)
+	}
+
+	// the content header and schema ID are set on the Protobuf message only
+	text, err := builder.BuildWithStringPayload("Hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType, ok := text.GetHTTPContentType(); ok && contentType != "" {
+		t.Errorf("content type %q left on the builder", contentType)
+	}
+	if text.HasProperty(PropertySchemaID) {
+		t.Error("schema ID left on the builder")
+	}
+}
+
+func TestProtobufDecodeErrors(t *testing.T) {
+	registry := schema.NewMemory()
+	timestamps, err := NewProtobuf[*timestamppb.Timestamp](registry, timestampSubject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// another Protobuf message under another subject is read as far as the fields match
+	durations, err := NewProtobuf[*durationpb.Duration](registry, "solace-samples-duration")
+	if err != nil {
+		t.Fatal(err)
+	}
+	avroSchema, err := registry.Register(greetingSubject, schema.Avro, greetingSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := int64(timestamps.Schema().ID)
+	tests := []struct {
+		name       string
+		payload    string
+		properties map[string]interface{}
+	}{
+		{"no schema ID", "", nil},
+		{"schema ID not a number", "", map[string]interface{}{PropertySchemaID: "one"}},
+		{"unknown schema ID", "", map[string]interface{}{PropertySchemaID: int64(99)}},
+		{"Avro schema ID", "", map[string]interface{}{PropertySchemaID: int64(avroSchema.ID)}},
+		{"invalid wire format", "\x08", map[string]interface{}{PropertySchemaID: id}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := timestamps.Decode(fake.NewInboundMessage("a/b", tt.payload, tt.properties))
+			var decodeErr *DecodeError
+			if !errors.As(err, &decodeErr) || decodeErr.Topic != "a/b" {
+				t.Errorf("error %v, want a DecodeError", err)
+			}
+		})
+	}
+
+	msg, err := durations.Encode(fake.New().MessageBuilder(), durationpb.New(90*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := timestamps.Decode(msg.(*fake.Message))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.GetSeconds() != 90 {
+		t.Errorf("decoded %v, want the seconds of the duration", got)
+	}
+
+	// a JSON message is refused on its content type
+	jsonMsg, err := Encode(fake.New().MessageBuilder(), greeting{Text: "Hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var contentTypeErr *ContentTypeError
+	if _, err := timestamps.Decode(jsonMsg.(*fake.Message)); !errors.As(err, &contentTypeErr) || contentTypeErr.Want != ContentTypeProtobuf {
+		t.Errorf("error %v, want a ContentTypeError", err)
+	}
+}
@@ -0,0 +1,48 @@
+package connstate
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	stateDesc = prometheus.NewDesc("solace_connection_state",
+		"1 for the current connection state of the messaging service, 0 for the others.", []string{"state"}, nil)
+	secondsDesc = prometheus.NewDesc("solace_connection_state_seconds_total",
+		"Time the messaging service spent in each connection state.", []string{"state"}, nil)
+	reconnectionsDesc = prometheus.NewDesc("solace_connection_reconnections_total",
+		"Reconnections after the connection was lost.", nil, nil)
+	interruptionsDesc = prometheus.NewDesc("solace_connection_interruptions_total",
+		"Service interruptions, after which the service stays down.", nil, nil)
+)
+
+// collector reports the snapshots of a tracker
+type collector struct {
+	tracker *Tracker
+}
+
+// Collector returns a Prometheus collector reporting the state of t, e.g. to
+// register with sampleMetrics.Registry().MustRegister.
+func (t *Tracker) Collector() prometheus.Collector {
+	return collector{tracker: t}
+}
+
+func (c collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- stateDesc
+	ch <- secondsDesc
+	ch <- reconnectionsDesc
+	ch <- interruptionsDesc
+}
+
+func (c collector) Collect(ch chan<- prometheus.Metric) {
+	snapshot := c.tracker.Snapshot()
+	for _, state := range States {
+		current := 0.0
+		if state == snapshot.State {
+			current = 1
+		}
+		ch <- prometheus.MustNewConstMetric(stateDesc, prometheus.GaugeValue, current, state.String())
+		ch <- prometheus.MustNewConstMetric(secondsDesc, prometheus.CounterValue, snapshot.Durations[state].Seconds(), state.String())
+	}
+	ch <- prometheus.MustNewConstMetric(reconnectionsDesc, prometheus.CounterValue, float64(snapshot.Reconnections))
+	ch <- prometheus.MustNewConstMetric(interruptionsDesc, prometheus.CounterValue, float64(snapshot.Interruptions))
+}
@@ -0,0 +1,266 @@
+// Package connstate tracks the connection state of a messaging service. A
+// Tracker listens to the reconnection attempt, reconnected and service
+// interruption events, keeps the current state and the time spent in each,
+// and logs every transition as a structured log/slog record:
+//
+//	connectionState := connstate.Track(messagingService)
+//
+// The state is exposed to health checks with Check and Snapshot, and to
+// Prometheus with Collector.
+package connstate
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"solace.dev/go/messaging/pkg/solace"
+)
+
+// State is the connection state of a messaging service.
+type State int
+
+// Connection states
+const (
+	// Connected services publish and receive.
+	Connected State = iota
+	// Reconnecting services lost their connection and try to get it back.
+	Reconnecting
+	// Down services are not connected and do not try to reconnect.
+	Down
+)
+
+// States lists every state, e.g. to report all of them.
+var States = []State{Connected, Reconnecting, Down}
+
+func (s State) String() string {
+	switch s {
+	case Connected:
+		return "connected"
+	case Reconnecting:
+		return "reconnecting"
+	case Down:
+		return "down"
+	}
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+// Transition is a change of state.
+type Transition struct {
+	From, To  State
+	At        time.Time
+	BrokerURI string
+	Cause     error
+	// InPrevious is how long the service was in the From state.
+	InPrevious time.Duration
+}
+
+// Snapshot is the state of a Tracker at one point in time.
+type Snapshot struct {
+	State State
+	Since time.Time
+	// Attempts counts the reconnection attempts since the service was last connected.
+	Attempts      int
+	Reconnections uint64
+	Interruptions uint64
+	LastCause     error
+	// Durations is the total time spent in each state, including the current one.
+	Durations map[State]time.Duration
+}
+
+// Option configures a Tracker.
+type Option func(*Tracker)
+
+// WithLogger sets the logger of the transition records, slog.Default() when not set.
+func WithLogger(logger *slog.Logger) Option {
+	return func(t *Tracker) {
+		t.logger = logger
+	}
+}
+
+// OnChange registers fn to be called with every transition. Several
+// functions may be registered, they are called in order.
+func OnChange(fn func(transition Transition)) Option {
+	return func(t *Tracker) {
+		t.onChange = append(t.onChange, fn)
+	}
+}
+
+// Tracker follows the connection state of a messaging service.
+type Tracker struct {
+	logger   *slog.Logger
+	onChange []func(transition Transition)
+
+	mu            sync.Mutex
+	state         State
+	since         time.Time
+	attempts      int
+	reconnections uint64
+	interruptions uint64
+	lastCause     error
+	durations     map[State]time.Duration
+}
+
+// Track starts tracking service, which starts out connected or down depending
+// on IsConnected. Call it after connecting, like metrics.Enable.
+func Track(service solace.MessagingService, opts ...Option) *Tracker {
+	initial := Down
+	if service.IsConnected() {
+		initial = Connected
+	}
+	t := New(initial, opts...)
+	service.AddReconnectionAttemptListener(func(e solace.ServiceEvent) {
+		t.transition(Reconnecting, e)
+	})
+	service.AddReconnectionListener(func(e solace.ServiceEvent) {
+		t.transition(Connected, e)
+	})
+	service.AddServiceInterruptionListener(func(e solace.ServiceEvent) {
+		t.transition(Down, e)
+	})
+	return t
+}
+
+// New creates a tracker in the initial state that is not attached to a
+// service, its state changes with Set.
+func New(initial State, opts ...Option) *Tracker {
+	t := &Tracker{
+		logger:    slog.Default(),
+		state:     initial,
+		since:     time.Now(),
+		durations: map[State]time.Duration{},
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+// Set moves the tracker to state, e.g. Down after the service was disconnected.
+func (t *Tracker) Set(state State, cause error) {
+	t.apply(state, time.Now(), "", cause)
+}
+
+// State returns the current state and how long the service has been in it.
+func (t *Tracker) State() (State, time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.state, time.Since(t.since)
+}
+
+// Snapshot returns the current state and counters.
+func (t *Tracker) Snapshot() Snapshot {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	durations := make(map[State]time.Duration, len(States))
+	for _, state := range States {
+		durations[state] = t.durations[state]
+	}
+	durations[t.state] += time.Since(t.since)
+	return Snapshot{
+		State:         t.state,
+		Since:         t.since,
+		Attempts:      t.attempts,
+		Reconnections: t.reconnections,
+		Interruptions: t.interruptions,
+		LastCause:     t.lastCause,
+		Durations:     durations,
+	}
+}
+
+// Check returns nil when the service is connected, for health checks.
+func (t *Tracker) Check() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.state == Connected {
+		return nil
+	}
+	err := fmt.Errorf("messaging service %s for %s", t.state, time.Since(t.since).Round(time.Millisecond))
+	if t.lastCause != nil {
+		err = fmt.Errorf("%w: %v", err, t.lastCause)
+	}
+	return err
+}
+
+// transition applies a service event
+func (t *Tracker) transition(to State, e solace.ServiceEvent) {
+	at := e.GetTimestamp()
+	if at.IsZero() {
+		at = time.Now()
+	}
+	t.apply(to, at, e.GetBrokerURI(), e.GetCause())
+}
+
+// apply moves to a state, counts and reports the transition
+func (t *Tracker) apply(to State, at time.Time, brokerURI string, cause error) {
+	t.mu.Lock()
+	from := t.state
+	// the previous state is counted up to now, the event time may be slightly off
+	inPrevious := time.Since(t.since)
+	t.durations[from] += inPrevious
+	t.state, t.since = to, time.Now()
+	if cause != nil {
+		t.lastCause = cause
+	}
+	switch to {
+	case Reconnecting:
+		t.attempts++
+	case Connected:
+		if from == Reconnecting {
+			t.reconnections++
+		}
+	case Down:
+		if from != Down {
+			t.interruptions++
+		}
+	}
+	attempts := t.attempts
+	if to == Connected {
+		t.attempts = 0
+	}
+	t.mu.Unlock()
+
+	transition := Transition{From: from, To: to, At: at, BrokerURI: brokerURI, Cause: cause, InPrevious: inPrevious}
+	t.log(transition, attempts)
+	for _, fn := range t.onChange {
+		fn(transition)
+	}
+}
+
+// log writes the record of a transition, repeated reconnection attempts are
+// logged at info level after the first
+func (t *Tracker) log(transition Transition, attempts int) {
+	attrs := []slog.Attr{
+		slog.String("state", transition.To.String()),
+		slog.String("previous", transition.From.String()),
+		slog.Duration("in_previous", transition.InPrevious),
+		slog.Time("event_time", transition.At),
+	}
+	if transition.BrokerURI != "" {
+		attrs = append(attrs, slog.String("broker_uri", transition.BrokerURI))
+	}
+	if transition.Cause != nil {
+		attrs = append(attrs, slog.String("cause", transition.Cause.Error()))
+	}
+
+	level, msg := slog.LevelInfo, "messaging service "+transition.To.String()
+	switch transition.To {
+	case Reconnecting:
+		attrs = append(attrs, slog.Int("attempt", attempts))
+		if attempts == 1 {
+			level, msg = slog.LevelWarn, "messaging service connection lost, reconnecting"
+		} else {
+			msg = "messaging service reconnection attempt"
+		}
+	case Connected:
+		if transition.From == Reconnecting {
+			attrs = append(attrs, slog.Int("attempts", attempts))
+			msg = "messaging service reconnected"
+		}
+	case Down:
+		level, msg = slog.LevelError, "messaging service down"
+	}
+	t.logger.LogAttrs(context.Background(), level, msg, attrs...)
+}
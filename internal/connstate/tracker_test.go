@@ -0,0 +1,123 @@
+package connstate
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+var discard = WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+func TestTransitions(t *testing.T) {
+	errLost := errors.New("connection lost")
+	tests := []struct {
+		name              string
+		initial           State
+		steps             []State
+		wantState         State
+		wantAttempts      int
+		wantReconnections uint64
+		wantInterruptions uint64
+	}{
+		{name: "attempts", initial: Connected, steps: []State{Reconnecting, Reconnecting, Reconnecting}, wantState: Reconnecting, wantAttempts: 3},
+		{name: "reconnected", initial: Connected, steps: []State{Reconnecting, Reconnecting, Connected}, wantState: Connected, wantReconnections: 1},
+		{name: "attempts reset on reconnect", initial: Connected,
+			steps:     []State{Reconnecting, Reconnecting, Connected, Reconnecting},
+			wantState: Reconnecting, wantAttempts: 1, wantReconnections: 1},
+		{name: "reconnected twice", initial: Connected,
+			steps:     []State{Reconnecting, Connected, Reconnecting, Reconnecting, Connected},
+			wantState: Connected, wantReconnections: 2},
+		{name: "interrupted", initial: Connected, steps: []State{Reconnecting, Down}, wantState: Down, wantAttempts: 1, wantInterruptions: 1},
+		{name: "down twice counts once", initial: Connected, steps: []State{Down, Down}, wantState: Down, wantInterruptions: 1},
+		{name: "initially down", initial: Down, steps: []State{Down}, wantState: Down},
+		{name: "connected is no reconnection", initial: Down, steps: []State{Connected}, wantState: Connected},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var transitions []Transition
+			tracker := New(tt.initial, discard, OnChange(func(transition Transition) {
+				transitions = append(transitions, transition)
+			}))
+			for _, state := range tt.steps {
+				tracker.Set(state, errLost)
+			}
+
+			snapshot := tracker.Snapshot()
+			if snapshot.State != tt.wantState || snapshot.Attempts != tt.wantAttempts ||
+				snapshot.Reconnections != tt.wantReconnections || snapshot.Interruptions != tt.wantInterruptions {
+				t.Errorf("snapshot %s attempts=%d reconnections=%d interruptions=%d, want %s attempts=%d reconnections=%d interruptions=%d",
+					snapshot.State, snapshot.Attempts, snapshot.Reconnections, snapshot.Interruptions,
+					tt.wantState, tt.wantAttempts, tt.wantReconnections, tt.wantInterruptions)
+			}
+			if !errors.Is(snapshot.LastCause, errLost) {
+				t.Errorf("last cause %v", snapshot.LastCause)
+			}
+			if len(transitions) != len(tt.steps) {
+				t.Fatalf("%d transitions reported, want %d", len(transitions), len(tt.steps))
+			}
+			from := tt.initial
+			for i, transition := range transitions {
+				if transition.From != from || transition.To != tt.steps[i] || transition.Cause != errLost {
+					t.Errorf("transition %d from %s to %s, want from %s to %s", i, transition.From, transition.To, from, tt.steps[i])
+				}
+				from = transition.To
+			}
+		})
+	}
+}
+
+func TestDurations(t *testing.T) {
+	tracker := New(Connected, discard)
+	// as if connected for a minute, then reconnecting for ten seconds
+	tracker.since = time.Now().Add(-time.Minute)
+	tracker.Set(Reconnecting, nil)
+	tracker.since = time.Now().Add(-10 * time.Second)
+	tracker.Set(Connected, nil)
+
+	snapshot := tracker.Snapshot()
+	within := func(got, want time.Duration) bool {
+		return got >= want && got < want+time.Second
+	}
+	if !within(snapshot.Durations[Connected], time.Minute) || !within(snapshot.Durations[Reconnecting], 10*time.Second) {
+		t.Errorf("durations %v, want a minute connected and ten seconds reconnecting", snapshot.Durations)
+	}
+	if d, ok := snapshot.Durations[Down]; !ok || d != 0 {
+		t.Errorf("down for %v, %v, want every state reported", d, ok)
+	}
+
+	// the current state is counted up to the snapshot
+	tracker.since = time.Now().Add(-time.Minute)
+	if connected := tracker.Snapshot().Durations[Connected]; !within(connected, 2*time.Minute) {
+		t.Errorf("connected for %v, want two minutes", connected)
+	}
+	if state, since := tracker.State(); state != Connected || !within(since, time.Minute) {
+		t.Errorf("state %s since %v, want connected for a minute", state, since)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tracker := New(Connected, discard)
+	if err := tracker.Check(); err != nil {
+		t.Fatalf("connected tracker check: %v", err)
+	}
+
+	tracker.Set(Reconnecting, errors.New("connection reset"))
+	err := tracker.Check()
+	if err == nil || !strings.Contains(err.Error(), "messaging service reconnecting") || !strings.Contains(err.Error(), "connection reset") {
+		t.Errorf("reconnecting tracker check: %v", err)
+	}
+
+	tracker.Set(Down, nil)
+	// the cause of the last transition that had one is kept
+	if err := tracker.Check(); err == nil || !strings.Contains(err.Error(), "messaging service down") || !strings.Contains(err.Error(), "connection reset") {
+		t.Errorf("down tracker check: %v", err)
+	}
+
+	tracker.Set(Connected, nil)
+	if err := tracker.Check(); err != nil {
+		t.Errorf("reconnected tracker check: %v", err)
+	}
+}
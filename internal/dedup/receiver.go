@@ -0,0 +1,164 @@
+package dedup
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"solace.dev/go/messaging/pkg/solace"
+	"solace.dev/go/messaging/pkg/solace/config"
+	"solace.dev/go/messaging/pkg/solace/message"
+)
+
+// KeyFunc extracts the deduplication key of a message, ok is false when the
+// message has none.
+type KeyFunc func(msg message.InboundMessage) (key string, ok bool)
+
+// ByApplicationMessageID keys messages on their application message ID.
+func ByApplicationMessageID() KeyFunc {
+	return func(msg message.InboundMessage) (string, bool) {
+		id, ok := msg.GetApplicationMessageID()
+		return id, ok && id != ""
+	}
+}
+
+// ByProperty keys messages on the value of a user property.
+func ByProperty(name string) KeyFunc {
+	return func(msg message.InboundMessage) (string, bool) {
+		val, ok := msg.GetProperty(name)
+		if !ok || val == nil {
+			return "", false
+		}
+		key := fmt.Sprint(val)
+		return key, key != ""
+	}
+}
+
+// Stats is a snapshot of the counters of a Receiver.
+type Stats struct {
+	Processed   uint64 // messages handed to the handler successfully
+	Duplicates  uint64 // messages skipped because their key was seen
+	Redelivered uint64 // messages flagged as redelivered by the broker
+	Failed      uint64 // messages the handler returned an error for
+	NoKey       uint64 // messages processed without a key, they cannot be deduplicated
+	StoreErrors uint64 // keys that could not be looked up or added, see OnStoreError
+}
+
+func (s Stats) String() string {
+	return fmt.Sprintf("processed=%d duplicates=%d redelivered=%d failed=%d no-key=%d store-errors=%d",
+		s.Processed, s.Duplicates, s.Redelivered, s.Failed, s.NoKey, s.StoreErrors)
+}
+
+// Option configures a Receiver.
+type Option func(*Receiver)
+
+// WithKey replaces the default ByApplicationMessageID key function.
+func WithKey(key KeyFunc) Option {
+	return func(r *Receiver) {
+		r.key = key
+	}
+}
+
+// OnRedelivered registers fn to be called for every message the broker flags
+// as redelivered, e.g. to feed a metric.
+func OnRedelivered(fn func(msg message.InboundMessage)) Option {
+	return func(r *Receiver) {
+		r.onRedelivered = fn
+	}
+}
+
+// OnStoreError registers fn to be called when the store cannot look up or add
+// a key. A key that cannot be looked up is handled as not seen and a key that
+// cannot be added is not remembered, so in both cases a redelivery is
+// processed again rather than lost.
+func OnStoreError(fn func(key string, err error)) Option {
+	return func(r *Receiver) {
+		r.onStoreError = fn
+	}
+}
+
+// Receiver wraps a persistent receiver that was built with client
+// acknowledgement. A key is only added to the store after the handler has
+// succeeded, and the message is acknowledged after that, so a crash in between
+// leads to a redelivery that is recognised and acknowledged as a duplicate.
+type Receiver struct {
+	receiver      solace.PersistentMessageReceiver
+	store         Store
+	key           KeyFunc
+	onRedelivered func(msg message.InboundMessage)
+	onStoreError  func(key string, err error)
+
+	processed, duplicates, redelivered, failed, noKey, storeErrors atomic.Uint64
+}
+
+// NewReceiver wraps receiver with duplicate detection backed by store.
+func NewReceiver(receiver solace.PersistentMessageReceiver, store Store, opts ...Option) *Receiver {
+	r := &Receiver{receiver: receiver, store: store, key: ByApplicationMessageID()}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// ReceiveAsync registers handler on the wrapped receiver. The handler is not
+// called for duplicates and must not acknowledge the message itself. When it
+// returns an error the message is settled as failed, so the broker redelivers
+// it if the receiver was built with that outcome enabled, and left
+// unacknowledged otherwise.
+func (r *Receiver) ReceiveAsync(handler func(msg message.InboundMessage) error) error {
+	return r.receiver.ReceiveAsync(func(msg message.InboundMessage) {
+		r.handle(msg, handler)
+	})
+}
+
+func (r *Receiver) handle(msg message.InboundMessage, handler func(msg message.InboundMessage) error) {
+	if msg.IsRedelivered() {
+		r.redelivered.Add(1)
+		if r.onRedelivered != nil {
+			r.onRedelivered(msg)
+		}
+	}
+
+	key, hasKey := r.key(msg)
+	if !hasKey {
+		r.noKey.Add(1)
+	} else if seen, err := r.store.Contains(key); err != nil {
+		r.storeError(key, err)
+	} else if seen {
+		r.duplicates.Add(1)
+		r.receiver.Ack(msg)
+		return
+	}
+
+	if err := handler(msg); err != nil {
+		r.failed.Add(1)
+		r.receiver.Settle(msg, config.PersistentReceiverFailedOutcome)
+		return
+	}
+	if hasKey {
+		// if the key cannot be stored a redelivery is processed again
+		if err := r.store.Add(key); err != nil {
+			r.storeError(key, err)
+		}
+	}
+	r.processed.Add(1)
+	r.receiver.Ack(msg)
+}
+
+func (r *Receiver) storeError(key string, err error) {
+	r.storeErrors.Add(1)
+	if r.onStoreError != nil {
+		r.onStoreError(key, err)
+	}
+}
+
+// Stats returns the current counters.
+func (r *Receiver) Stats() Stats {
+	return Stats{
+		Processed:   r.processed.Load(),
+		Duplicates:  r.duplicates.Load(),
+		Redelivered: r.redelivered.Load(),
+		Failed:      r.failed.Load(),
+		NoKey:       r.noKey.Load(),
+		StoreErrors: r.storeErrors.Load(),
+	}
+}
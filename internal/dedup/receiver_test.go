@@ -0,0 +1,164 @@
+package dedup
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"solace.dev/go/messaging/pkg/solace/config"
+	"solace.dev/go/messaging/pkg/solace/message"
+	"solace.dev/go/messaging/pkg/solace/resource"
+
+	"SolaceSamples.com/PubSub+Go/internal/fake"
+)
+
+const topic = "solace/samples/go/persistent/dedup"
+
+// failingStore is a Store whose lookups and additions fail
+type failingStore struct{}
+
+func (failingStore) Contains(key string) (bool, error) { return false, errors.New("store unavailable") }
+func (failingStore) Add(key string) error              { return errors.New("store unavailable") }
+
+// start publishes a message per application message ID on a fresh broker and
+// returns the queue and a receiver deduplicating them with store
+func start(t *testing.T, store Store, ids []string, opts ...Option) (*fake.Queue, *Receiver) {
+	t.Helper()
+	broker := fake.NewBroker()
+	queue := broker.CreateQueue("dedup-queue", topic)
+	service := broker.NewService()
+	if err := service.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { service.Disconnect() })
+
+	receiver, err := service.CreatePersistentMessageReceiverBuilder().
+		WithMessageClientAcknowledgement().
+		WithRequiredMessageOutcomeSupport(config.PersistentReceiverFailedOutcome).
+		Build(resource.QueueDurableExclusive(queue.Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := receiver.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { receiver.Terminate(0) })
+
+	publisher, err := service.CreatePersistentMessagePublisherBuilder().Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := publisher.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { publisher.Terminate(0) })
+	for _, id := range ids {
+		msg, _ := service.MessageBuilder().WithApplicationMessageID(id).BuildWithStringPayload("Hello " + id)
+		if err := publisher.PublishAwaitAcknowledgement(msg, resource.TopicOf(topic), time.Second, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return queue, NewReceiver(receiver, store, opts...)
+}
+
+// waitSettled waits until every message on queue was acknowledged or discarded
+func waitSettled(t *testing.T, queue *fake.Queue) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for queue.Pending()+queue.Unacked() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("pending=%d unacked=%d", queue.Pending(), queue.Unacked())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReceiver(t *testing.T) {
+	tests := []struct {
+		name      string
+		store     Store
+		ids       []string
+		failFirst bool
+		wantCalls int
+		wantStats Stats
+	}{
+		{
+			name:      "duplicates are acked without the handler",
+			store:     NewLRU(10),
+			ids:       []string{"1", "2", "1"},
+			wantCalls: 2,
+			wantStats: Stats{Processed: 2, Duplicates: 1},
+		},
+		{
+			name:      "messages without a key are processed",
+			store:     NewLRU(10),
+			ids:       []string{"", ""},
+			wantCalls: 2,
+			wantStats: Stats{Processed: 2, NoKey: 2},
+		},
+		{
+			name:      "a failed message is redelivered and processed",
+			store:     NewLRU(10),
+			ids:       []string{"1"},
+			failFirst: true,
+			wantCalls: 2,
+			wantStats: Stats{Processed: 1, Redelivered: 1, Failed: 1},
+		},
+		{
+			name:      "store errors process the message and are counted",
+			store:     failingStore{},
+			ids:       []string{"1", "1"},
+			wantCalls: 2,
+			wantStats: Stats{Processed: 2, StoreErrors: 4},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var calls, redelivered int
+			var storeErrors []string
+			queue, receiver := start(t, tt.store, tt.ids,
+				OnRedelivered(func(msg message.InboundMessage) {
+					mu.Lock()
+					defer mu.Unlock()
+					redelivered++
+				}),
+				OnStoreError(func(key string, err error) {
+					mu.Lock()
+					defer mu.Unlock()
+					storeErrors = append(storeErrors, key)
+				}))
+			err := receiver.ReceiveAsync(func(msg message.InboundMessage) error {
+				mu.Lock()
+				defer mu.Unlock()
+				calls++
+				if tt.failFirst && calls == 1 {
+					return errors.New("handler failed")
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			waitSettled(t, queue)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if calls != tt.wantCalls {
+				t.Errorf("handler called %d times, want %d", calls, tt.wantCalls)
+			}
+			if stats := receiver.Stats(); stats != tt.wantStats {
+				t.Errorf("stats %s, want %s", stats, tt.wantStats)
+			}
+			if redelivered != int(tt.wantStats.Redelivered) || len(storeErrors) != int(tt.wantStats.StoreErrors) {
+				t.Errorf("%d redelivered and %d store error callbacks", redelivered, len(storeErrors))
+			}
+			for _, key := range storeErrors {
+				if key != "1" {
+					t.Errorf("store error reported for key %q", key)
+				}
+			}
+		})
+	}
+}
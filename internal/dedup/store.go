@@ -0,0 +1,209 @@
+// Package dedup makes a persistent message receiver idempotent: messages whose
+// key was already processed are acknowledged without calling the handler again.
+package dedup
+
+import (
+	"bufio"
+	"container/list"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// Store remembers the keys of processed messages.
+type Store interface {
+	// Contains reports whether key was added before.
+	Contains(key string) (bool, error)
+	// Add records key as processed.
+	Add(key string) error
+}
+
+// LRU is an in-memory Store that forgets the least recently used keys once it
+// holds capacity keys.
+type LRU struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List
+	items    map[string]*list.Element
+}
+
+// NewLRU creates an LRU store, a capacity below 1 is raised to 1.
+func NewLRU(capacity int) *LRU {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &LRU{capacity: capacity, order: list.New(), items: make(map[string]*list.Element)}
+}
+
+// Contains reports whether key is held and marks it as recently used.
+func (l *LRU) Contains(key string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if elem, ok := l.items[key]; ok {
+		l.order.MoveToFront(elem)
+		return true, nil
+	}
+	return false, nil
+}
+
+// Add records key, evicting the least recently used key when full.
+func (l *LRU) Add(key string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.add(key)
+	return nil
+}
+
+// Len returns the number of keys held.
+func (l *LRU) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.order.Len()
+}
+
+func (l *LRU) add(key string) {
+	if elem, ok := l.items[key]; ok {
+		l.order.MoveToFront(elem)
+		return
+	}
+	l.items[key] = l.order.PushFront(key)
+	if l.order.Len() > l.capacity {
+		oldest := l.order.Back()
+		l.order.Remove(oldest)
+		delete(l.items, oldest.Value.(string))
+	}
+}
+
+// keys returns the keys from least to most recently used
+func (l *LRU) keys() []string {
+	keys := make([]string, 0, l.order.Len())
+	for elem := l.order.Back(); elem != nil; elem = elem.Prev() {
+		keys = append(keys, elem.Value.(string))
+	}
+	return keys
+}
+
+// FileStore is a Store that survives restarts. Keys are appended to a file,
+// one quoted key per line, and synced before Add returns. Lookups are served by
+// an LRU of the given capacity that is loaded from the file on open; the file
+// is rewritten with only those keys once it has grown to twice the capacity.
+type FileStore struct {
+	path string
+
+	mu      sync.Mutex
+	lru     *LRU
+	file    *os.File
+	written int
+}
+
+// OpenFileStore opens or creates the store at path.
+func OpenFileStore(path string, capacity int) (*FileStore, error) {
+	s := &FileStore{path: path, lru: NewLRU(capacity)}
+	f, err := os.Open(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return nil, fmt.Errorf("opening dedup store: %w", err)
+	default:
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			key, err := strconv.Unquote(scanner.Text())
+			if err != nil {
+				// a torn last line from a crash, skip it
+				continue
+			}
+			s.lru.add(key)
+			s.written++
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("reading dedup store %s: %w", path, err)
+		}
+	}
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Contains reports whether key was added before, within the capacity.
+func (s *FileStore) Contains(key string) (bool, error) {
+	return s.lru.Contains(key)
+}
+
+// Add appends key to the file.
+func (s *FileStore) Add(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return errors.New("dedup store is closed")
+	}
+	if _, err := fmt.Fprintln(s.file, strconv.Quote(key)); err != nil {
+		return fmt.Errorf("writing dedup store: %w", err)
+	}
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("syncing dedup store: %w", err)
+	}
+	s.lru.Add(key)
+	s.written++
+	if s.written >= 2*s.lru.capacity {
+		return s.compact()
+	}
+	return nil
+}
+
+// Close closes the file.
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// compact rewrites the file with the keys held by the LRU and keeps it open for
+// appending. The current file is only replaced once the new one is in place,
+// so a failure leaves the store usable. s.mu must be held or s not yet shared.
+func (s *FileStore) compact() error {
+	s.lru.mu.Lock()
+	keys := s.lru.keys()
+	s.lru.mu.Unlock()
+
+	tmp := s.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("compacting dedup store: %w", err)
+	}
+	w := bufio.NewWriter(f)
+	for _, key := range keys {
+		fmt.Fprintln(w, strconv.Quote(key))
+	}
+	err = w.Flush()
+	if err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = rename(tmp, s.path)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("compacting dedup store: %w", err)
+	}
+
+	if s.file != nil {
+		s.file.Close()
+	}
+	s.file = f
+	s.written = len(keys)
+	return nil
+}
+
+// rename is replaced by the tests to fail the compaction
+var rename = os.Rename
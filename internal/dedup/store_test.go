@@ -0,0 +1,54 @@
+package dedup
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestFileStoreCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dedup.log")
+	store, err := OpenFileStore(path, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	for i := 0; i < 5; i++ {
+		if err := store.Add("key-" + strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ok, _ := store.Contains("key-0"); ok {
+		t.Error("evicted key still held")
+	}
+
+	// a failed compaction keeps the store open and its file intact
+	defer func() { rename = os.Rename }()
+	rename = func(string, string) error { return errors.New("disk full") }
+	if err := store.Add("key-5"); err == nil {
+		t.Fatal("failed compaction not reported")
+	}
+	if _, err := os.Stat(path + ".tmp"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("temporary file left: %v", err)
+	}
+	rename = os.Rename
+	if err := store.Add("key-6"); err != nil {
+		t.Fatalf("store unusable after a failed compaction: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := OpenFileStore(path, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	for key, want := range map[string]bool{"key-5": true, "key-6": true, "key-3": false} {
+		if ok, _ := reopened.Contains(key); ok != want {
+			t.Errorf("Contains(%s) = %v after reopening, want %v", key, ok, want)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+// Package fake provides an in-memory implementation of solace.MessagingService so
+// that handlers and helpers built on the PubSub+ Go API can be exercised without a
+// broker or network.
+//
+// A Broker routes messages between the services created from it. Direct messages
+// are delivered to every receiver with a matching subscription, using Solace
+// wildcard semantics. Persistent messages are spooled to every queue with a
+// matching subscription and delivered to the bound persistent receivers, which
+// must acknowledge or settle them. Request-reply publishers and receivers are
+// supported on top of direct messaging.
+//
+//	broker := fake.NewBroker()
+//	service := broker.NewService()
+//	service.Connect()
+//	receiver, _ := service.CreateDirectMessageReceiverBuilder().
+//		WithSubscriptions(resource.TopicSubscriptionOf("solace/samples/>")).
+//		Build()
+package fake
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"solace.dev/go/messaging/pkg/solace"
+	"solace.dev/go/messaging/pkg/solace/config"
+	"solace.dev/go/messaging/pkg/solace/message"
+	"solace.dev/go/messaging/pkg/solace/subcode"
+
+	"SolaceSamples.com/PubSub+Go/internal/wildcard"
+)
+
+// PublishInterceptor is called for every persistent message before it is spooled.
+// Returning an error makes the broker NAK the message with that error.
+type PublishInterceptor func(topic string, msg message.OutboundMessage) error
+
+// Broker is the in-memory message router shared by the services created from it.
+type Broker struct {
+	mu               sync.Mutex
+	directReceivers  []directSubscriber // in start order, so shared subscriptions round robin
+	queues           map[string]*Queue
+	interceptor      PublishInterceptor
+	rejectOnNoMatch  bool
+	shareRoundRobin  map[string]int
+	ids              atomic.Uint64
+	directPublished  atomic.Uint64
+	persistPublished atomic.Uint64
+}
+
+// directSubscriber is a receiver that takes direct messages
+type directSubscriber interface {
+	subscriptions() []string
+	deliver(msg *Message)
+}
+
+// NewBroker creates an empty broker.
+func NewBroker() *Broker {
+	return &Broker{
+		queues:          make(map[string]*Queue),
+		shareRoundRobin: make(map[string]int),
+	}
+}
+
+// New returns a service attached to a broker of its own.
+func New() *Service {
+	return NewBroker().NewService()
+}
+
+// SetPublishInterceptor installs fn to decide the receipt of every persistent publish.
+func (b *Broker) SetPublishInterceptor(fn PublishInterceptor) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.interceptor = fn
+}
+
+// RejectOnNoSubscriptionMatch makes persistent publishes that match no queue fail,
+// like the "Reject Message to Sender on No Subscription Match" client profile setting.
+func (b *Broker) RejectOnNoSubscriptionMatch(reject bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rejectOnNoMatch = reject
+}
+
+// CreateQueue provisions a durable exclusive queue with the given subscriptions.
+// If the queue exists the subscriptions are added to it.
+func (b *Broker) CreateQueue(name string, subscriptions ...string) *Queue {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	q, ok := b.queues[name]
+	if !ok {
+		q = newQueue(name, true, true, nil)
+		b.queues[name] = q
+	}
+	for _, sub := range subscriptions {
+		q.addSubscription(sub)
+	}
+	return q
+}
+
+// Queue returns the named queue or nil.
+func (b *Broker) Queue(name string) *Queue {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.queues[name]
+}
+
+// Published returns the number of direct and persistent messages published so far.
+func (b *Broker) Published() (direct, persistent uint64) {
+	return b.directPublished.Load(), b.persistPublished.Load()
+}
+
+func (b *Broker) nextID() uint64 {
+	return b.ids.Add(1)
+}
+
+func (b *Broker) addDirect(s directSubscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.directReceivers = append(b.directReceivers, s)
+}
+
+func (b *Broker) removeDirect(s directSubscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.directReceivers = slices.DeleteFunc(b.directReceivers, func(r directSubscriber) bool { return r == s })
+}
+
+// publishDirect delivers a copy of msg to every matching direct receiver. Receivers
+// sharing a #share subscription get the message round robin.
+func (b *Broker) publishDirect(topic string, msg *Message) {
+	b.directPublished.Add(1)
+	b.mu.Lock()
+	var targets []directSubscriber
+	shared := map[string][]directSubscriber{}
+	for _, r := range b.directReceivers {
+		for _, sub := range r.subscriptions() {
+			if !wildcard.Match(sub, topic) {
+				continue
+			}
+			// receivers of the same #share/<name>/<topic> subscription form a group
+			if prefix := strings.TrimSuffix(sub, wildcard.Strip(sub)); strings.Contains(prefix, "#share/") {
+				shared[sub] = append(shared[sub], r)
+			} else {
+				targets = append(targets, r)
+			}
+			break
+		}
+	}
+	for sub, group := range shared {
+		i := b.shareRoundRobin[sub] % len(group)
+		b.shareRoundRobin[sub]++
+		targets = append(targets, group[i])
+	}
+	b.mu.Unlock()
+
+	for _, r := range targets {
+		delivered := msg.clone()
+		delivered.destination = topic
+		delivered.timestamp = time.Now()
+		r.deliver(delivered)
+	}
+}
+
+// publishPersistent spools msg on every matching queue and returns the receipt error
+func (b *Broker) publishPersistent(topic string, msg *Message) error {
+	b.persistPublished.Add(1)
+	b.mu.Lock()
+	interceptor := b.interceptor
+	rejectOnNoMatch := b.rejectOnNoMatch
+	var targets []*Queue
+	for _, q := range b.queues {
+		if q.matches(topic) {
+			targets = append(targets, q)
+		}
+	}
+	b.mu.Unlock()
+
+	if interceptor != nil {
+		if err := interceptor(topic, msg); err != nil {
+			return err
+		}
+	}
+	if len(targets) == 0 && rejectOnNoMatch {
+		return solace.NewNativeError(fmt.Sprintf("fake: no subscription match for topic %s", topic), subcode.NoSubscriptionMatch)
+	}
+	for _, q := range targets {
+		spooled := msg.clone()
+		spooled.destination = topic
+		spooled.timestamp = time.Now()
+		if err := q.enqueue(spooled); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// provision creates or checks a queue for the endpoint provisioner
+func (b *Broker) provision(name string, properties config.EndpointPropertyMap, ignoreExists bool) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if q, ok := b.queues[name]; ok {
+		if !q.sameProperties(properties) {
+			return solace.NewNativeError(fmt.Sprintf("fake: endpoint property mismatch for queue %s", name), subcode.EndpointPropertyMismatch)
+		}
+		if ignoreExists {
+			return nil
+		}
+		return solace.NewNativeError(fmt.Sprintf("fake: queue %s already exists", name), subcode.EndpointAlreadyExists)
+	}
+	durable, _ := properties[config.EndpointPropertyDurable].(bool)
+	exclusive, _ := properties[config.EndpointPropertyExclusive].(bool)
+	b.queues[name] = newQueue(name, durable, exclusive, properties)
+	return nil
+}
+
+func (b *Broker) deprovision(name string, ignoreMissing bool) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.queues[name]; !ok {
+		if ignoreMissing {
+			return nil
+		}
+		return solace.NewNativeError(fmt.Sprintf("fake: unknown queue %s", name), subcode.UnknownQueueName)
+	}
+	delete(b.queues, name)
+	return nil
+}
+
+// bindQueue looks up the queue a persistent receiver binds to, creating it when allowed
+func (b *Broker) bindQueue(name string, durable, exclusive, create bool) (*Queue, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if name == "" {
+		name = fmt.Sprintf("#P2P/QTMP/fake/%d", b.nextID())
+	}
+	if q, ok := b.queues[name]; ok {
+		return q, nil
+	}
+	if durable && !create {
+		return nil, solace.NewNativeError(fmt.Sprintf("fake: unknown queue %s", name), subcode.UnknownQueueName)
+	}
+	q := newQueue(name, durable, exclusive, nil)
+	b.queues[name] = q
+	return q, nil
+}
+
+// releaseQueue removes a non-durable queue once its last consumer is gone
+func (b *Broker) releaseQueue(q *Queue) {
+	if q.durable || q.consumerCount() > 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.queues[q.name] == q {
+		delete(b.queues, q.name)
+	}
+}
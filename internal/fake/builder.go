@@ -0,0 +1,189 @@
+package fake
+
+import (
+	"fmt"
+	"time"
+
+	"solace.dev/go/messaging/pkg/solace"
+	"solace.dev/go/messaging/pkg/solace/config"
+	"solace.dev/go/messaging/pkg/solace/message"
+	"solace.dev/go/messaging/pkg/solace/message/sdt"
+)
+
+// messageBuilder implements solace.OutboundMessageBuilder on top of Message
+type messageBuilder struct {
+	properties config.MessagePropertyMap
+}
+
+func newMessageBuilder() *messageBuilder {
+	return &messageBuilder{properties: config.MessagePropertyMap{}}
+}
+
+func (b *messageBuilder) Build(additionalConfiguration ...config.MessagePropertiesConfigurationProvider) (message.OutboundMessage, error) {
+	return b.build(nil, false, additionalConfiguration)
+}
+
+func (b *messageBuilder) BuildWithByteArrayPayload(payload []byte, additionalConfiguration ...config.MessagePropertiesConfigurationProvider) (message.OutboundMessage, error) {
+	return b.build(append([]byte(nil), payload...), false, additionalConfiguration)
+}
+
+func (b *messageBuilder) BuildWithStringPayload(payload string, additionalConfiguration ...config.MessagePropertiesConfigurationProvider) (message.OutboundMessage, error) {
+	return b.build([]byte(payload), true, additionalConfiguration)
+}
+
+func (b *messageBuilder) BuildWithMapPayload(payload sdt.Map, additionalConfiguration ...config.MessagePropertiesConfigurationProvider) (message.OutboundMessage, error) {
+	return nil, solace.NewError(&solace.IllegalArgumentError{}, "fake: map payloads are not supported", nil)
+}
+
+func (b *messageBuilder) BuildWithStreamPayload(payload sdt.Stream, additionalConfiguration ...config.MessagePropertiesConfigurationProvider) (message.OutboundMessage, error) {
+	return nil, solace.NewError(&solace.IllegalArgumentError{}, "fake: stream payloads are not supported", nil)
+}
+
+func (b *messageBuilder) build(payload []byte, stringPayload bool, additional []config.MessagePropertiesConfigurationProvider) (message.OutboundMessage, error) {
+	msg := &Message{
+		payload:       payload,
+		stringPayload: stringPayload,
+		properties:    sdt.Map{},
+	}
+	if err := applyMessageProperties(msg, b.properties); err != nil {
+		return nil, err
+	}
+	for _, provider := range additional {
+		if provider == nil {
+			continue
+		}
+		if err := applyMessageProperties(msg, provider.GetConfiguration()); err != nil {
+			return nil, err
+		}
+	}
+	return msg, nil
+}
+
+func (b *messageBuilder) FromConfigurationProvider(properties config.MessagePropertiesConfigurationProvider) solace.OutboundMessageBuilder {
+	if properties != nil {
+		for key, val := range properties.GetConfiguration() {
+			b.properties[key] = val
+		}
+	}
+	return b
+}
+
+func (b *messageBuilder) WithProperty(propertyName config.MessageProperty, propertyValue interface{}) solace.OutboundMessageBuilder {
+	b.properties[propertyName] = propertyValue
+	return b
+}
+
+func (b *messageBuilder) WithExpiration(t time.Time) solace.OutboundMessageBuilder {
+	return b.WithProperty(config.MessagePropertyPersistentExpiration, t.Unix())
+}
+
+func (b *messageBuilder) WithHTTPContentHeader(contentType, contentEncoding string) solace.OutboundMessageBuilder {
+	b.properties[config.MessagePropertyHTTPContentType] = contentType
+	b.properties[config.MessagePropertyHTTPContentEncoding] = contentEncoding
+	return b
+}
+
+func (b *messageBuilder) WithPriority(priority int) solace.OutboundMessageBuilder {
+	return b.WithProperty(config.MessagePropertyPriority, priority)
+}
+
+func (b *messageBuilder) WithApplicationMessageID(messageID string) solace.OutboundMessageBuilder {
+	return b.WithProperty(config.MessagePropertyApplicationMessageID, messageID)
+}
+
+func (b *messageBuilder) WithApplicationMessageType(messageType string) solace.OutboundMessageBuilder {
+	return b.WithProperty(config.MessagePropertyApplicationMessageType, messageType)
+}
+
+func (b *messageBuilder) WithSequenceNumber(sequenceNumber uint64) solace.OutboundMessageBuilder {
+	return b.WithProperty(config.MessagePropertySequenceNumber, sequenceNumber)
+}
+
+func (b *messageBuilder) WithSenderID(senderID string) solace.OutboundMessageBuilder {
+	return b.WithProperty(config.MessagePropertySenderID, senderID)
+}
+
+func (b *messageBuilder) WithCorrelationID(correlationID string) solace.OutboundMessageBuilder {
+	return b.WithProperty(config.MessagePropertyCorrelationID, correlationID)
+}
+
+// applyMessageProperties copies well known properties onto the message fields and
+// everything else into the user properties
+func applyMessageProperties(msg *Message, properties config.MessagePropertyMap) error {
+	for key, val := range properties {
+		switch key {
+		case config.MessagePropertyCorrelationID:
+			msg.correlationID = fmt.Sprint(val)
+		case config.MessagePropertyApplicationMessageID:
+			msg.appMessageID = fmt.Sprint(val)
+		case config.MessagePropertyApplicationMessageType:
+			msg.appMessageType = fmt.Sprint(val)
+		case config.MessagePropertyHTTPContentType:
+			msg.contentType = fmt.Sprint(val)
+		case config.MessagePropertyHTTPContentEncoding:
+			msg.contentEncoding = fmt.Sprint(val)
+		case config.MessagePropertySenderID:
+			msg.senderID = fmt.Sprint(val)
+		case config.MessagePropertyPriority:
+			priority, err := toInt64(key, val)
+			if err != nil {
+				return err
+			}
+			p := int(priority)
+			msg.priority = &p
+		case config.MessagePropertyClassOfService:
+			cos, err := toInt64(key, val)
+			if err != nil {
+				return err
+			}
+			msg.classOfService = int(cos)
+		case config.MessagePropertySequenceNumber:
+			seq, err := toInt64(key, val)
+			if err != nil {
+				return err
+			}
+			msg.sequenceNumber = &seq
+		case config.MessagePropertyPersistentExpiration:
+			exp, err := toInt64(key, val)
+			if err != nil {
+				return err
+			}
+			msg.expiration = time.Unix(exp, 0)
+		case config.MessagePropertyPersistentTimeToLive:
+			ttl, err := toInt64(key, val)
+			if err != nil {
+				return err
+			}
+			msg.timeToLive = &ttl
+		case config.MessagePropertyPersistentDMQEligible:
+			eligible, ok := val.(bool)
+			if !ok {
+				return solace.NewError(&solace.IllegalArgumentError{}, fmt.Sprintf("fake: property %s expects a bool, got %T", key, val), nil)
+			}
+			msg.dmqEligible = &eligible
+		case config.MessagePropertyElidingEligible, config.MessagePropertyPersistentAckImmediately:
+			// accepted but without effect in the fake
+		default:
+			msg.properties[string(key)] = val
+		}
+	}
+	return nil
+}
+
+func toInt64(key config.MessageProperty, val interface{}) (int64, error) {
+	switch v := val.(type) {
+	case int:
+		return int64(v), nil
+	case int32:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	case uint:
+		return int64(v), nil
+	case uint32:
+		return int64(v), nil
+	case uint64:
+		return int64(v), nil
+	}
+	return 0, solace.NewError(&solace.IllegalArgumentError{}, fmt.Sprintf("fake: property %s expects an integer, got %T", key, val), nil)
+}
@@ -0,0 +1,357 @@
+package fake
+
+import (
+	"sync"
+	"time"
+
+	"solace.dev/go/messaging/pkg/solace"
+	"solace.dev/go/messaging/pkg/solace/config"
+	"solace.dev/go/messaging/pkg/solace/message"
+	"solace.dev/go/messaging/pkg/solace/metrics"
+	"solace.dev/go/messaging/pkg/solace/resource"
+)
+
+// defaultBufferCapacity is the receiver buffer size when no back pressure strategy is set
+const defaultBufferCapacity = 1024
+
+type directPublisherBuilder struct {
+	service *Service
+}
+
+func (b *directPublisherBuilder) Build() (solace.DirectMessagePublisher, error) {
+	p := &directPublisher{}
+	p.init(b.service, nil, nil)
+	return p, nil
+}
+
+func (b *directPublisherBuilder) OnBackPressureReject(bufferSize uint) solace.DirectMessagePublisherBuilder {
+	return b
+}
+
+func (b *directPublisherBuilder) OnBackPressureWait(bufferSize uint) solace.DirectMessagePublisherBuilder {
+	return b
+}
+
+func (b *directPublisherBuilder) FromConfigurationProvider(provider config.PublisherPropertiesConfigurationProvider) solace.DirectMessagePublisherBuilder {
+	return b
+}
+
+// directPublisher publishes straight into the broker, it never blocks
+type directPublisher struct {
+	lifecycle
+	readinessListener solace.PublisherReadinessListener
+}
+
+func (p *directPublisher) StartAsyncCallback(callback func(solace.DirectMessagePublisher, error)) {
+	err := p.Start()
+	go callback(p, err)
+}
+
+func (p *directPublisher) TerminateAsyncCallback(gracePeriod time.Duration, callback func(error)) {
+	p.terminateAsyncCallback(gracePeriod, callback)
+}
+
+func (p *directPublisher) IsReady() bool { return p.IsRunning() }
+
+func (p *directPublisher) SetPublisherReadinessListener(listener solace.PublisherReadinessListener) {
+	p.readinessListener = listener
+}
+
+func (p *directPublisher) NotifyWhenReady() {
+	if listener := p.readinessListener; listener != nil && p.IsReady() {
+		go listener()
+	}
+}
+
+// SetPublishFailureListener is accepted but never called, publishing cannot fail in the fake.
+func (p *directPublisher) SetPublishFailureListener(listener solace.PublishFailureListener) {}
+
+func (p *directPublisher) PublishBytes(payload []byte, destination *resource.Topic) error {
+	msg, _ := newMessageBuilder().BuildWithByteArrayPayload(payload)
+	return p.Publish(msg, destination)
+}
+
+func (p *directPublisher) PublishString(payload string, destination *resource.Topic) error {
+	msg, _ := newMessageBuilder().BuildWithStringPayload(payload)
+	return p.Publish(msg, destination)
+}
+
+func (p *directPublisher) Publish(msg message.OutboundMessage, destination *resource.Topic) error {
+	return p.PublishWithProperties(msg, destination, nil)
+}
+
+func (p *directPublisher) PublishWithProperties(msg message.OutboundMessage, destination *resource.Topic, properties config.MessagePropertiesConfigurationProvider) error {
+	if err := p.requireRunning(); err != nil {
+		return err
+	}
+	outbound, err := outboundWithProperties(msg, properties)
+	if err != nil {
+		return err
+	}
+	p.service.metrics.add(metrics.DirectMessagesSent, 1)
+	p.service.broker.publishDirect(destination.GetName(), outbound)
+	return nil
+}
+
+// outboundWithProperties converts msg to a fake message carrying the extra properties
+func outboundWithProperties(msg message.OutboundMessage, properties config.MessagePropertiesConfigurationProvider) (*Message, error) {
+	fakeMsg, ok := msg.(*Message)
+	if !ok {
+		return nil, solace.NewError(&solace.IllegalArgumentError{}, "fake: only messages built by the fake message builder can be published", nil)
+	}
+	if properties == nil {
+		return fakeMsg, nil
+	}
+	outbound := fakeMsg.clone()
+	if err := applyMessageProperties(outbound, properties.GetConfiguration()); err != nil {
+		return nil, err
+	}
+	return outbound, nil
+}
+
+type directReceiverBuilder struct {
+	service        *Service
+	subscriptions  []string
+	bufferCapacity uint
+	dropOldest     bool
+}
+
+func (b *directReceiverBuilder) Build() (solace.DirectMessageReceiver, error) {
+	return newDirectReceiver(b.service, b.subscriptions, b.bufferCapacity, b.dropOldest), nil
+}
+
+func (b *directReceiverBuilder) BuildWithShareName(shareName *resource.ShareName) (solace.DirectMessageReceiver, error) {
+	shared := make([]string, len(b.subscriptions))
+	for i, sub := range b.subscriptions {
+		shared[i] = "#share/" + shareName.GetName() + "/" + sub
+	}
+	return newDirectReceiver(b.service, shared, b.bufferCapacity, b.dropOldest), nil
+}
+
+func (b *directReceiverBuilder) OnBackPressureDropLatest(bufferCapacity uint) solace.DirectMessageReceiverBuilder {
+	b.bufferCapacity = bufferCapacity
+	b.dropOldest = false
+	return b
+}
+
+func (b *directReceiverBuilder) OnBackPressureDropOldest(bufferCapacity uint) solace.DirectMessageReceiverBuilder {
+	b.bufferCapacity = bufferCapacity
+	b.dropOldest = true
+	return b
+}
+
+func (b *directReceiverBuilder) WithSubscriptions(topics ...resource.Subscription) solace.DirectMessageReceiverBuilder {
+	for _, topic := range topics {
+		b.subscriptions = append(b.subscriptions, topic.GetName())
+	}
+	return b
+}
+
+func (b *directReceiverBuilder) FromConfigurationProvider(provider config.ReceiverPropertiesConfigurationProvider) solace.DirectMessageReceiverBuilder {
+	return b
+}
+
+// subscriberSet is the subscription bookkeeping shared by the direct style receivers
+type subscriberSet struct {
+	subMu sync.Mutex
+	subs  []string
+}
+
+func (s *subscriberSet) subscriptions() []string {
+	s.subMu.Lock()
+	defer s.subMu.Unlock()
+	return append([]string(nil), s.subs...)
+}
+
+func (s *subscriberSet) add(sub string) {
+	s.subMu.Lock()
+	defer s.subMu.Unlock()
+	for _, existing := range s.subs {
+		if existing == sub {
+			return
+		}
+	}
+	s.subs = append(s.subs, sub)
+}
+
+func (s *subscriberSet) remove(sub string) {
+	s.subMu.Lock()
+	defer s.subMu.Unlock()
+	for i, existing := range s.subs {
+		if existing == sub {
+			s.subs = append(s.subs[:i], s.subs[i+1:]...)
+			return
+		}
+	}
+}
+
+// inbox buffers delivered messages according to the back pressure strategy
+type inbox struct {
+	messages   chan *Message
+	dropOldest bool
+}
+
+func newInbox(capacity uint, dropOldest bool) inbox {
+	if capacity == 0 {
+		capacity = defaultBufferCapacity
+	}
+	return inbox{messages: make(chan *Message, capacity), dropOldest: dropOldest}
+}
+
+func (in inbox) put(msg *Message) {
+	for {
+		select {
+		case in.messages <- msg:
+			return
+		default:
+		}
+		if !in.dropOldest {
+			return
+		}
+		select {
+		case <-in.messages:
+		default:
+		}
+	}
+}
+
+// get waits for a message, a negative timeout waits forever
+func (in inbox) get(timeout time.Duration, done <-chan struct{}) (*Message, error) {
+	var expired <-chan time.Time
+	if timeout >= 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case msg := <-in.messages:
+		return msg, nil
+	case <-expired:
+		return nil, solace.NewError(&solace.TimeoutError{}, "fake: timed out waiting for a message", nil)
+	case <-done:
+		return nil, solace.NewError(&solace.IllegalStateError{}, "fake: receiver terminated", nil)
+	}
+}
+
+type directReceiver struct {
+	lifecycle
+	subscriberSet
+	inbox inbox
+
+	handlerMu sync.Mutex
+	handler   solace.MessageHandler
+	wake      chan struct{}
+}
+
+func newDirectReceiver(service *Service, subscriptions []string, capacity uint, dropOldest bool) *directReceiver {
+	r := &directReceiver{
+		inbox: newInbox(capacity, dropOldest),
+		wake:  make(chan struct{}, 1),
+	}
+	r.subs = subscriptions
+	r.init(service,
+		func() error {
+			service.broker.addDirect(r)
+			r.goRun(r.dispatch)
+			return nil
+		},
+		func() { service.broker.removeDirect(r) })
+	return r
+}
+
+func (r *directReceiver) deliver(msg *Message) {
+	r.service.metrics.add(metrics.DirectMessagesReceived, 1)
+	r.inbox.put(msg)
+}
+
+// dispatch hands buffered messages to the async handler once one is registered
+func (r *directReceiver) dispatch() {
+	for {
+		r.handlerMu.Lock()
+		handler := r.handler
+		r.handlerMu.Unlock()
+		if handler == nil {
+			select {
+			case <-r.wake:
+				continue
+			case <-r.done:
+				return
+			}
+		}
+		select {
+		case msg := <-r.inbox.messages:
+			handler(msg)
+		case <-r.done:
+			return
+		}
+	}
+}
+
+func (r *directReceiver) StartAsyncCallback(callback func(solace.DirectMessageReceiver, error)) {
+	err := r.Start()
+	go callback(r, err)
+}
+
+func (r *directReceiver) TerminateAsyncCallback(gracePeriod time.Duration, callback func(error)) {
+	r.terminateAsyncCallback(gracePeriod, callback)
+}
+
+func (r *directReceiver) ReceiveAsync(callback solace.MessageHandler) error {
+	r.handlerMu.Lock()
+	r.handler = callback
+	r.handlerMu.Unlock()
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (r *directReceiver) ReceiveMessage(timeout time.Duration) (message.InboundMessage, error) {
+	if err := r.requireRunning(); err != nil {
+		return nil, err
+	}
+	msg, err := r.inbox.get(timeout, r.done)
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (r *directReceiver) AddSubscription(subscription resource.Subscription) error {
+	r.add(subscription.GetName())
+	return nil
+}
+
+func (r *directReceiver) RemoveSubscription(subscription resource.Subscription) error {
+	r.remove(subscription.GetName())
+	return nil
+}
+
+func (r *directReceiver) AddSubscriptionAsync(subscription resource.Subscription, listener solace.SubscriptionChangeListener) error {
+	err := r.AddSubscription(subscription)
+	if listener != nil {
+		go listener(subscription, solace.SubscriptionAdded, err)
+	}
+	return nil
+}
+
+func (r *directReceiver) RemoveSubscriptionAsync(subscription resource.Subscription, listener solace.SubscriptionChangeListener) error {
+	err := r.RemoveSubscription(subscription)
+	if listener != nil {
+		go listener(subscription, solace.SubscriptionRemoved, err)
+	}
+	return nil
+}
+
+func (r *directReceiver) RequestCachedAsync(request resource.CachedMessageSubscriptionRequest, cacheRequestID message.CacheRequestID) (<-chan solace.CacheResponse, error) {
+	return nil, errCacheNotSupported()
+}
+
+func (r *directReceiver) RequestCachedAsyncWithCallback(request resource.CachedMessageSubscriptionRequest, cacheRequestID message.CacheRequestID, callback func(solace.CacheResponse)) error {
+	return errCacheNotSupported()
+}
+
+func errCacheNotSupported() error {
+	return solace.NewError(&solace.IllegalStateError{}, "fake: cache requests are not supported", nil)
+}
@@ -0,0 +1,414 @@
+package fake_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"solace.dev/go/messaging/pkg/solace"
+	"solace.dev/go/messaging/pkg/solace/config"
+	"solace.dev/go/messaging/pkg/solace/message"
+	"solace.dev/go/messaging/pkg/solace/resource"
+
+	"SolaceSamples.com/PubSub+Go/internal/fake"
+)
+
+// connect returns a connected service of broker that is disconnected at the end of the test
+func connect(t *testing.T, broker *fake.Broker) *fake.Service {
+	t.Helper()
+	service := broker.NewService()
+	if err := service.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { service.Disconnect() })
+	return service
+}
+
+// startDirect starts a direct receiver subscribed to subscriptions with handler as its callback
+func startDirect(t *testing.T, service solace.MessagingService, handler solace.MessageHandler, subscriptions ...string) {
+	t.Helper()
+	var subs []resource.Subscription
+	for _, sub := range subscriptions {
+		subs = append(subs, resource.TopicSubscriptionOf(sub))
+	}
+	receiver, err := service.CreateDirectMessageReceiverBuilder().WithSubscriptions(subs...).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := receiver.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { receiver.Terminate(0) })
+	if err := receiver.ReceiveAsync(handler); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// startDirectPublisher starts a direct publisher on service
+func startDirectPublisher(t *testing.T, service solace.MessagingService) solace.DirectMessagePublisher {
+	t.Helper()
+	publisher, err := service.CreateDirectMessagePublisherBuilder().Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := publisher.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { publisher.Terminate(0) })
+	return publisher
+}
+
+// receive waits for a message on messages, nil after timeout
+func receive(messages <-chan message.InboundMessage, timeout time.Duration) message.InboundMessage {
+	select {
+	case msg := <-messages:
+		return msg
+	case <-time.After(timeout):
+		return nil
+	}
+}
+
+// collect returns a message handler that hands every message to the returned channel
+func collect() (solace.MessageHandler, <-chan message.InboundMessage) {
+	messages := make(chan message.InboundMessage, 16)
+	return func(msg message.InboundMessage) { messages <- msg }, messages
+}
+
+func TestDirectReceiverMessageHandler(t *testing.T) {
+	tests := []struct {
+		name         string
+		subscription string
+		topic        string
+		delivered    bool
+	}{
+		{"exact", "solace/samples/go/direct/sub", "solace/samples/go/direct/sub", true},
+		{"trailing >", "solace/samples/go/direct/>", "solace/samples/go/direct/sub/1", true},
+		{"single level *", "solace/samples/*/direct/sub", "solace/samples/go/direct/sub", true},
+		{"prefix level", "solace/samples/go/direct/sub*", "solace/samples/go/direct/sub-1", true},
+		{"shared", "#share/group/solace/samples/>", "solace/samples/go", true},
+		{"other level", "solace/samples/go/direct/sub", "solace/samples/go/direct/other", false},
+		{"too short for >", "solace/samples/>", "solace/samples", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			broker := fake.NewBroker()
+			handler, messages := collect()
+			startDirect(t, connect(t, broker), handler, tt.subscription)
+			publisher := startDirectPublisher(t, connect(t, broker))
+
+			if err := publisher.PublishString("Hello World", resource.TopicOf(tt.topic)); err != nil {
+				t.Fatal(err)
+			}
+			timeout := time.Second
+			if !tt.delivered {
+				timeout = 50 * time.Millisecond
+			}
+			msg := receive(messages, timeout)
+			if (msg != nil) != tt.delivered {
+				t.Fatalf("delivered = %v, want %v", msg != nil, tt.delivered)
+			}
+			if msg == nil {
+				return
+			}
+			if body, ok := msg.GetPayloadAsString(); !ok || body != "Hello World" {
+				t.Errorf("payload = %q, %v", body, ok)
+			}
+			if msg.GetDestinationName() != tt.topic {
+				t.Errorf("destination = %s, want %s", msg.GetDestinationName(), tt.topic)
+			}
+		})
+	}
+}
+
+func TestPersistentSettlement(t *testing.T) {
+	tests := []struct {
+		name          string
+		outcome       config.MessageSettlementOutcome
+		enabled       bool
+		wantErr       bool
+		wantPending   int
+		wantDiscarded int
+	}{
+		{"accepted", config.PersistentReceiverAcceptedOutcome, false, false, 0, 0},
+		{"failed is redelivered", config.PersistentReceiverFailedOutcome, true, false, 1, 0},
+		{"rejected is discarded", config.PersistentReceiverRejectedOutcome, true, false, 0, 1},
+		{"outcome not enabled", config.PersistentReceiverRejectedOutcome, false, true, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			broker := fake.NewBroker()
+			queue := broker.CreateQueue("durable-queue", "solace/samples/go/persistent/>")
+			service := connect(t, broker)
+
+			builder := service.CreatePersistentMessageReceiverBuilder().WithMessageClientAcknowledgement()
+			if tt.enabled {
+				builder = builder.WithRequiredMessageOutcomeSupport(tt.outcome)
+			}
+			receiver, err := builder.Build(resource.QueueDurableExclusive(queue.Name()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := receiver.Start(); err != nil {
+				t.Fatal(err)
+			}
+			defer receiver.Terminate(0)
+
+			publisher, err := service.CreatePersistentMessagePublisherBuilder().Build()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := publisher.Start(); err != nil {
+				t.Fatal(err)
+			}
+			defer publisher.Terminate(0)
+			msg, _ := service.MessageBuilder().BuildWithStringPayload("Hello World")
+			if err := publisher.PublishAwaitAcknowledgement(msg, resource.TopicOf("solace/samples/go/persistent/1"), time.Second, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			received, err := receiver.ReceiveMessage(time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if received.IsRedelivered() {
+				t.Error("first delivery flagged as redelivered")
+			}
+			err = receiver.Settle(received, tt.outcome)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Settle error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if queue.Unacked() != 1 {
+					t.Errorf("unacked = %d, want the message left unsettled", queue.Unacked())
+				}
+				return
+			}
+			if queue.Pending() != tt.wantPending || len(queue.Discarded()) != tt.wantDiscarded || queue.Unacked() != 0 {
+				t.Errorf("pending=%d discarded=%d unacked=%d, want pending=%d discarded=%d unacked=0",
+					queue.Pending(), len(queue.Discarded()), queue.Unacked(), tt.wantPending, tt.wantDiscarded)
+			}
+			if tt.wantPending > 0 {
+				redelivered, err := receiver.ReceiveMessage(time.Second)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !redelivered.IsRedelivered() {
+					t.Error("redelivery not flagged as redelivered")
+				}
+				if err := receiver.Ack(redelivered); err != nil {
+					t.Error(err)
+				}
+			}
+			// a settled message cannot be settled again
+			if err := receiver.Ack(received); err == nil {
+				t.Error("no error settling a message twice")
+			}
+		})
+	}
+}
+
+func TestPersistentAutoAcknowledgement(t *testing.T) {
+	broker := fake.NewBroker()
+	queue := broker.CreateQueue("durable-queue", "solace/samples/go/persistent/>")
+	service := connect(t, broker)
+	receiver, err := service.CreatePersistentMessageReceiverBuilder().
+		WithMessageAutoAcknowledgement().
+		Build(resource.QueueDurableExclusive(queue.Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := receiver.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer receiver.Terminate(0)
+	handler, messages := collect()
+	if err := receiver.ReceiveAsync(handler); err != nil {
+		t.Fatal(err)
+	}
+
+	publisher, _ := service.CreatePersistentMessagePublisherBuilder().Build()
+	if err := publisher.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer publisher.Terminate(0)
+	if err := publisher.PublishString("Hello World", resource.TopicOf("solace/samples/go/persistent/1")); err != nil {
+		t.Fatal(err)
+	}
+	if receive(messages, time.Second) == nil {
+		t.Fatal("message not delivered")
+	}
+	deadline := time.Now().Add(time.Second)
+	for queue.Unacked() > 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if queue.Pending() != 0 || queue.Unacked() != 0 {
+		t.Errorf("pending=%d unacked=%d after the handler returned", queue.Pending(), queue.Unacked())
+	}
+}
+
+func TestPublishInterceptorNAK(t *testing.T) {
+	broker := fake.NewBroker()
+	broker.CreateQueue("durable-queue", "solace/samples/>")
+	nak := errors.New("queue full")
+	broker.SetPublishInterceptor(func(topic string, msg message.OutboundMessage) error { return nak })
+	service := connect(t, broker)
+
+	publisher, _ := service.CreatePersistentMessagePublisherBuilder().Build()
+	receipts := make(chan solace.PublishReceipt, 1)
+	publisher.SetMessagePublishReceiptListener(func(receipt solace.PublishReceipt) { receipts <- receipt })
+	if err := publisher.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer publisher.Terminate(0)
+	if err := publisher.PublishString("Hello World", resource.TopicOf("solace/samples/go")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case receipt := <-receipts:
+		if receipt.IsPersisted() || !errors.Is(receipt.GetError(), nak) {
+			t.Errorf("receipt persisted=%v error=%v, want the interceptor error", receipt.IsPersisted(), receipt.GetError())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no receipt")
+	}
+	if queue := broker.Queue("durable-queue"); queue.Pending() != 0 {
+		t.Errorf("NAKed message was spooled")
+	}
+}
+
+func TestRequestReply(t *testing.T) {
+	const topic = "solace/samples/go/direct/request"
+	broker := fake.NewBroker()
+
+	replierService := connect(t, broker)
+	receiver, err := replierService.RequestReply().CreateRequestReplyMessageReceiverBuilder().
+		Build(resource.TopicSubscriptionOf("solace/samples/*/direct/request"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := receiver.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer receiver.Terminate(0)
+	if err := receiver.ReceiveAsync(func(request message.InboundMessage, replier solace.Replier) {
+		if replier == nil {
+			return
+		}
+		body, _ := request.GetPayloadAsString()
+		if body == "ignore" {
+			return
+		}
+		reply, _ := replierService.MessageBuilder().BuildWithStringPayload("Reply to " + body)
+		replier.Reply(reply)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	service := connect(t, broker)
+	publisher, err := service.RequestReply().CreateRequestReplyMessagePublisherBuilder().Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := publisher.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer publisher.Terminate(0)
+
+	t.Run("await response", func(t *testing.T) {
+		request, _ := service.MessageBuilder().
+			FromConfigurationProvider(config.MessagePropertyMap{config.MessagePropertyCorrelationID: "corr-1"}).
+			BuildWithStringPayload("Hello")
+		reply, err := publisher.PublishAwaitResponse(request, resource.TopicOf(topic), time.Second, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body, _ := reply.GetPayloadAsString(); body != "Reply to Hello" {
+			t.Errorf("reply = %q", body)
+		}
+		if id, ok := reply.GetCorrelationID(); !ok || id != "corr-1" {
+			t.Errorf("reply correlation id = %q, %v, want the request's", id, ok)
+		}
+	})
+
+	t.Run("timeout without a reply", func(t *testing.T) {
+		request, _ := service.MessageBuilder().BuildWithStringPayload("ignore")
+		_, err := publisher.PublishAwaitResponse(request, resource.TopicOf(topic), 50*time.Millisecond, nil)
+		var timeout *solace.TimeoutError
+		if !errors.As(err, &timeout) {
+			t.Errorf("error %v, want a TimeoutError", err)
+		}
+	})
+
+	t.Run("timeout without a replier", func(t *testing.T) {
+		request, _ := service.MessageBuilder().BuildWithStringPayload("Hello")
+		_, err := publisher.PublishAwaitResponse(request, resource.TopicOf("solace/samples/go/nobody"), 50*time.Millisecond, nil)
+		var timeout *solace.TimeoutError
+		if !errors.As(err, &timeout) {
+			t.Errorf("error %v, want a TimeoutError", err)
+		}
+	})
+
+	t.Run("async handler", func(t *testing.T) {
+		type result struct {
+			body        string
+			userContext interface{}
+			err         error
+		}
+		results := make(chan result, 1)
+		err := publisher.PublishString("Async", func(reply message.InboundMessage, userContext interface{}, err error) {
+			var body string
+			if reply != nil {
+				body, _ = reply.GetPayloadAsString()
+			}
+			results <- result{body, userContext, err}
+		}, resource.TopicOf(topic), time.Second, 42)
+		if err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case r := <-results:
+			if r.err != nil || r.body != "Reply to Async" || r.userContext != 42 {
+				t.Errorf("handler got %+v", r)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("reply handler not called")
+		}
+	})
+
+	t.Run("nil handler", func(t *testing.T) {
+		err := publisher.PublishString("Hello", nil, resource.TopicOf(topic), time.Second, nil)
+		var illegal *solace.IllegalArgumentError
+		if !errors.As(err, &illegal) {
+			t.Errorf("error %v, want an IllegalArgumentError", err)
+		}
+	})
+}
+
+func TestSharedSubscriptionGroups(t *testing.T) {
+	broker := fake.NewBroker()
+	received := make([]<-chan message.InboundMessage, 4)
+	subscriptions := []string{
+		"#share/group1/solace/samples/>",
+		"#share/group1/solace/samples/>",
+		"#share/group2/solace/samples/>",
+		"#share/group2/solace/samples/>",
+	}
+	for i, sub := range subscriptions {
+		handler, messages := collect()
+		received[i] = messages
+		startDirect(t, connect(t, broker), handler, sub)
+	}
+	publisher := startDirectPublisher(t, connect(t, broker))
+	for i := 0; i < 4; i++ {
+		if err := publisher.PublishString("Hello", resource.TopicOf("solace/samples/go")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	// every group gets each message once, round robin between its members
+	for i, messages := range received {
+		if len(messages) != 2 {
+			t.Errorf("member %d of %s received %d messages, want 2", i, subscriptions[i], len(messages))
+		}
+	}
+}
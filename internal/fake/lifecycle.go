@@ -0,0 +1,171 @@
+package fake
+
+import (
+	"sync"
+	"time"
+
+	"solace.dev/go/messaging/pkg/solace"
+)
+
+type lifecycleState int
+
+const (
+	stateNotStarted lifecycleState = iota
+	stateRunning
+	stateTerminating
+	stateTerminated
+)
+
+// lifecycle implements solace.LifecycleControl for the fake publishers and receivers.
+// The owner plugs in onStart and onStop and runs its goroutines on wg.
+type lifecycle struct {
+	service *Service
+	onStart func() error
+	onStop  func()
+
+	mu       sync.Mutex
+	state    lifecycleState
+	listener solace.TerminationNotificationListener
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+func (l *lifecycle) init(service *Service, onStart func() error, onStop func()) {
+	l.service = service
+	l.onStart = onStart
+	l.onStop = onStop
+	l.done = make(chan struct{})
+}
+
+func (l *lifecycle) Start() error {
+	if err := l.service.requireConnected(); err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	switch l.state {
+	case stateRunning:
+		return nil
+	case stateTerminating, stateTerminated:
+		return solace.NewError(&solace.IllegalStateError{}, "fake: cannot start a terminated instance", nil)
+	}
+	if l.onStart != nil {
+		if err := l.onStart(); err != nil {
+			return err
+		}
+	}
+	l.state = stateRunning
+	l.service.onDisconnect(func(cause error) { l.terminate(0, true, cause) })
+	return nil
+}
+
+func (l *lifecycle) StartAsync() <-chan error {
+	return asyncResult(l.Start())
+}
+
+func (l *lifecycle) Terminate(gracePeriod time.Duration) error {
+	return l.terminate(gracePeriod, false, nil)
+}
+
+func (l *lifecycle) TerminateAsync(gracePeriod time.Duration) <-chan error {
+	result := make(chan error, 1)
+	go func() { result <- l.Terminate(gracePeriod) }()
+	return result
+}
+
+func (l *lifecycle) terminateAsyncCallback(gracePeriod time.Duration, callback func(error)) {
+	go func() { callback(l.Terminate(gracePeriod)) }()
+}
+
+// terminate stops the instance and waits up to gracePeriod for its goroutines.
+// A negative grace period waits forever.
+func (l *lifecycle) terminate(gracePeriod time.Duration, unsolicited bool, cause error) error {
+	l.mu.Lock()
+	if l.state == stateTerminating || l.state == stateTerminated {
+		l.mu.Unlock()
+		return nil
+	}
+	l.state = stateTerminating
+	close(l.done)
+	listener := l.listener
+	l.mu.Unlock()
+
+	if l.onStop != nil {
+		l.onStop()
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		l.wg.Wait()
+		close(finished)
+	}()
+	var err error
+	if gracePeriod < 0 {
+		<-finished
+	} else {
+		select {
+		case <-finished:
+		case <-time.After(gracePeriod):
+			err = solace.NewError(&solace.IncompleteMessageDeliveryError{}, "fake: terminated before all work completed", nil)
+		}
+	}
+
+	l.mu.Lock()
+	l.state = stateTerminated
+	l.mu.Unlock()
+
+	if unsolicited && listener != nil {
+		listener(terminationEvent{timestamp: time.Now(), message: "service disconnected", cause: cause})
+	}
+	return err
+}
+
+func (l *lifecycle) IsRunning() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.state == stateRunning
+}
+
+func (l *lifecycle) IsTerminated() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.state == stateTerminated
+}
+
+func (l *lifecycle) IsTerminating() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.state == stateTerminating
+}
+
+func (l *lifecycle) SetTerminationNotificationListener(listener solace.TerminationNotificationListener) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.listener = listener
+}
+
+func (l *lifecycle) requireRunning() error {
+	if !l.IsRunning() {
+		return solace.NewError(&solace.IllegalStateError{}, "fake: instance is not running", nil)
+	}
+	return nil
+}
+
+// go runs fn on the lifecycle wait group
+func (l *lifecycle) goRun(fn func()) {
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		fn()
+	}()
+}
+
+type terminationEvent struct {
+	timestamp time.Time
+	message   string
+	cause     error
+}
+
+func (e terminationEvent) GetTimestamp() time.Time { return e.timestamp }
+func (e terminationEvent) GetMessage() string      { return e.message }
+func (e terminationEvent) GetCause() error         { return e.cause }
@@ -0,0 +1,251 @@
+package fake
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"solace.dev/go/messaging/pkg/solace/message"
+	"solace.dev/go/messaging/pkg/solace/message/rgmid"
+	"solace.dev/go/messaging/pkg/solace/message/sdt"
+)
+
+// Message is the in-memory message type produced by the fake message builder and
+// delivered to the fake receivers. It implements both message.OutboundMessage
+// and message.InboundMessage.
+type Message struct {
+	payload       []byte
+	stringPayload bool
+	properties    sdt.Map
+
+	correlationID   string
+	appMessageID    string
+	appMessageType  string
+	contentType     string
+	contentEncoding string
+	senderID        string
+	priority        *int
+	sequenceNumber  *int64
+	expiration      time.Time
+	classOfService  int
+	timeToLive      *int64
+	dmqEligible     *bool
+
+	// distributed tracing, see tracing.go
+	creationContext  traceContext
+	transportContext traceContext
+	baggage          string
+
+	// set on delivery
+	destination string
+	timestamp   time.Time
+	redelivered bool
+	replyTo     string
+	requestID   string
+
+	disposed bool
+}
+
+var (
+	_ message.OutboundMessage = (*Message)(nil)
+	_ message.InboundMessage  = (*Message)(nil)
+)
+
+// NewInboundMessage creates a message as it would be received on topic. It is
+// handy for calling message handlers directly.
+func NewInboundMessage(topic string, payload string, properties map[string]interface{}) *Message {
+	msg := &Message{
+		payload:       []byte(payload),
+		stringPayload: true,
+		properties:    sdt.Map{},
+		destination:   topic,
+		timestamp:     time.Now(),
+	}
+	for key, val := range properties {
+		msg.properties[key] = val
+	}
+	return msg
+}
+
+// clone returns a copy that can be delivered independently of the original
+func (m *Message) clone() *Message {
+	c := *m
+	c.payload = append([]byte(nil), m.payload...)
+	c.properties = make(sdt.Map, len(m.properties))
+	for key, val := range m.properties {
+		c.properties[key] = val
+	}
+	return &c
+}
+
+// TimeToLive returns the time to live in milliseconds the message was built
+// with. The API has no getter for it, it lets tests check it was set.
+func (m *Message) TimeToLive() (int64, bool) {
+	if m.timeToLive == nil {
+		return 0, false
+	}
+	return *m.timeToLive, true
+}
+
+// DMQEligible returns the DMQ eligibility the message was built with. The API
+// has no getter for it, it lets tests check it was set.
+func (m *Message) DMQEligible() (eligible bool, ok bool) {
+	if m.dmqEligible == nil {
+		return false, false
+	}
+	return *m.dmqEligible, true
+}
+
+// Dispose marks the message as disposed.
+func (m *Message) Dispose() { m.disposed = true }
+
+// IsDisposed reports whether Dispose was called.
+func (m *Message) IsDisposed() bool { return m.disposed }
+
+// GetProperties returns a copy of the user properties.
+func (m *Message) GetProperties() sdt.Map {
+	props := make(sdt.Map, len(m.properties))
+	for key, val := range m.properties {
+		props[key] = val
+	}
+	return props
+}
+
+// GetProperty returns a user property.
+func (m *Message) GetProperty(key string) (sdt.Data, bool) {
+	val, ok := m.properties[key]
+	return val, ok
+}
+
+// HasProperty reports whether the user property is set.
+func (m *Message) HasProperty(key string) bool {
+	_, ok := m.properties[key]
+	return ok
+}
+
+// GetPayloadAsBytes returns a binary payload.
+func (m *Message) GetPayloadAsBytes() ([]byte, bool) {
+	if m.stringPayload || m.payload == nil {
+		return nil, false
+	}
+	return m.payload, true
+}
+
+// GetPayloadAsString returns a string payload.
+func (m *Message) GetPayloadAsString() (string, bool) {
+	if !m.stringPayload {
+		return "", false
+	}
+	return string(m.payload), true
+}
+
+// GetPayloadAsMap is not supported by the fake.
+func (m *Message) GetPayloadAsMap() (sdt.Map, bool) { return nil, false }
+
+// GetPayloadAsStream is not supported by the fake.
+func (m *Message) GetPayloadAsStream() (sdt.Stream, bool) { return nil, false }
+
+// GetCorrelationID returns the correlation ID.
+func (m *Message) GetCorrelationID() (string, bool) {
+	return m.correlationID, m.correlationID != ""
+}
+
+// GetExpiration returns the expiration time.
+func (m *Message) GetExpiration() time.Time { return m.expiration }
+
+// GetSequenceNumber returns the sequence number.
+func (m *Message) GetSequenceNumber() (int64, bool) {
+	if m.sequenceNumber == nil {
+		return 0, false
+	}
+	return *m.sequenceNumber, true
+}
+
+// GetPriority returns the priority.
+func (m *Message) GetPriority() (int, bool) {
+	if m.priority == nil {
+		return 0, false
+	}
+	return *m.priority, true
+}
+
+// GetHTTPContentType returns the HTTP content type.
+func (m *Message) GetHTTPContentType() (string, bool) {
+	return m.contentType, m.contentType != ""
+}
+
+// GetHTTPContentEncoding returns the HTTP content encoding.
+func (m *Message) GetHTTPContentEncoding() (string, bool) {
+	return m.contentEncoding, m.contentEncoding != ""
+}
+
+// GetApplicationMessageID returns the application message ID.
+func (m *Message) GetApplicationMessageID() (string, bool) {
+	return m.appMessageID, m.appMessageID != ""
+}
+
+// GetApplicationMessageType returns the application message type.
+func (m *Message) GetApplicationMessageType() (string, bool) {
+	return m.appMessageType, m.appMessageType != ""
+}
+
+// GetClassOfService returns the class of service.
+func (m *Message) GetClassOfService() int { return m.classOfService }
+
+// GetDestinationName returns the topic the message was published to.
+func (m *Message) GetDestinationName() string { return m.destination }
+
+// GetTimeStamp returns the time the fake broker delivered the message.
+func (m *Message) GetTimeStamp() (time.Time, bool) {
+	return m.timestamp, !m.timestamp.IsZero()
+}
+
+// GetSenderTimestamp returns the time the fake broker delivered the message.
+func (m *Message) GetSenderTimestamp() (time.Time, bool) { return m.GetTimeStamp() }
+
+// GetSenderID returns the sender ID.
+func (m *Message) GetSenderID() (string, bool) {
+	return m.senderID, m.senderID != ""
+}
+
+// GetReplicationGroupMessageID is not supported by the fake.
+func (m *Message) GetReplicationGroupMessageID() (rgmid.ReplicationGroupMessageID, bool) {
+	return nil, false
+}
+
+// GetMessageDiscardNotification reports no discards.
+func (m *Message) GetMessageDiscardNotification() message.MessageDiscardNotification {
+	return discardNotification{}
+}
+
+// IsRedelivered reports whether the fake broker delivered the message before.
+func (m *Message) IsRedelivered() bool { return m.redelivered }
+
+// GetCacheRequestID is not supported by the fake.
+func (m *Message) GetCacheRequestID() (message.CacheRequestID, bool) { return 0, false }
+
+// GetCacheStatus always returns message.Live.
+func (m *Message) GetCacheStatus() message.CacheStatus { return message.Live }
+
+// String dumps the message in a readable form.
+func (m *Message) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Destination: %s\n", m.destination)
+	if m.appMessageID != "" {
+		fmt.Fprintf(&sb, "Application Message ID: %s\n", m.appMessageID)
+	}
+	if m.correlationID != "" {
+		fmt.Fprintf(&sb, "Correlation ID: %s\n", m.correlationID)
+	}
+	for key, val := range m.properties {
+		fmt.Fprintf(&sb, "User Property %s: %v\n", key, val)
+	}
+	fmt.Fprintf(&sb, "Redelivered: %t\n", m.redelivered)
+	fmt.Fprintf(&sb, "Payload: %q", m.payload)
+	return sb.String()
+}
+
+type discardNotification struct{}
+
+func (discardNotification) HasBrokerDiscardIndication() bool   { return false }
+func (discardNotification) HasInternalDiscardIndication() bool { return false }
@@ -0,0 +1,516 @@
+package fake
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"solace.dev/go/messaging/pkg/solace"
+	"solace.dev/go/messaging/pkg/solace/config"
+	"solace.dev/go/messaging/pkg/solace/message"
+	"solace.dev/go/messaging/pkg/solace/metrics"
+	"solace.dev/go/messaging/pkg/solace/resource"
+)
+
+type persistentPublisherBuilder struct {
+	service *Service
+}
+
+func (b *persistentPublisherBuilder) Build() (solace.PersistentMessagePublisher, error) {
+	p := &persistentPublisher{receipts: make(chan receipt, defaultBufferCapacity)}
+	p.init(b.service, func() error {
+		p.goRun(p.deliverReceipts)
+		return nil
+	}, func() {
+		// wait for publishes in progress so no receipt is sent on the closed channel
+		p.publishMu.Lock()
+		close(p.receipts)
+		p.publishMu.Unlock()
+	})
+	return p, nil
+}
+
+func (b *persistentPublisherBuilder) OnBackPressureReject(bufferSize uint) solace.PersistentMessagePublisherBuilder {
+	return b
+}
+
+func (b *persistentPublisherBuilder) OnBackPressureWait(bufferSize uint) solace.PersistentMessagePublisherBuilder {
+	return b
+}
+
+func (b *persistentPublisherBuilder) FromConfigurationProvider(provider config.PublisherPropertiesConfigurationProvider) solace.PersistentMessagePublisherBuilder {
+	return b
+}
+
+// persistentPublisher spools messages synchronously and reports the outcome
+// through receipts delivered in publish order on a separate goroutine
+type persistentPublisher struct {
+	lifecycle
+	receipts chan receipt
+
+	mu                sync.Mutex
+	publishMu         sync.RWMutex
+	receiptListener   solace.MessagePublishReceiptListener
+	readinessListener solace.PublisherReadinessListener
+}
+
+func (p *persistentPublisher) deliverReceipts() {
+	for r := range p.receipts {
+		p.mu.Lock()
+		listener := p.receiptListener
+		p.mu.Unlock()
+		if listener != nil {
+			listener(r)
+		}
+	}
+}
+
+func (p *persistentPublisher) StartAsyncCallback(callback func(solace.PersistentMessagePublisher, error)) {
+	err := p.Start()
+	go callback(p, err)
+}
+
+func (p *persistentPublisher) TerminateAsyncCallback(gracePeriod time.Duration, callback func(error)) {
+	p.terminateAsyncCallback(gracePeriod, callback)
+}
+
+func (p *persistentPublisher) IsReady() bool { return p.IsRunning() }
+
+func (p *persistentPublisher) SetPublisherReadinessListener(listener solace.PublisherReadinessListener) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.readinessListener = listener
+}
+
+func (p *persistentPublisher) NotifyWhenReady() {
+	p.mu.Lock()
+	listener := p.readinessListener
+	p.mu.Unlock()
+	if listener != nil && p.IsReady() {
+		go listener()
+	}
+}
+
+func (p *persistentPublisher) SetMessagePublishReceiptListener(listener solace.MessagePublishReceiptListener) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.receiptListener = listener
+}
+
+func (p *persistentPublisher) PublishBytes(payload []byte, destination *resource.Topic) error {
+	msg, _ := newMessageBuilder().BuildWithByteArrayPayload(payload)
+	return p.Publish(msg, destination, nil, nil)
+}
+
+func (p *persistentPublisher) PublishString(payload string, destination *resource.Topic) error {
+	msg, _ := newMessageBuilder().BuildWithStringPayload(payload)
+	return p.Publish(msg, destination, nil, nil)
+}
+
+func (p *persistentPublisher) Publish(msg message.OutboundMessage, destination *resource.Topic, properties config.MessagePropertiesConfigurationProvider, userContext interface{}) error {
+	p.publishMu.RLock()
+	defer p.publishMu.RUnlock()
+	if err := p.requireRunning(); err != nil {
+		return err
+	}
+	outbound, err := outboundWithProperties(msg, properties)
+	if err != nil {
+		return err
+	}
+	p.service.metrics.add(metrics.PersistentMessagesSent, 1)
+	receiptErr := p.service.broker.publishPersistent(destination.GetName(), outbound)
+	p.receipts <- receipt{
+		userContext: userContext,
+		timestamp:   time.Now(),
+		message:     msg,
+		err:         receiptErr,
+	}
+	return nil
+}
+
+func (p *persistentPublisher) PublishAwaitAcknowledgement(msg message.OutboundMessage, destination *resource.Topic, timeout time.Duration, properties config.MessagePropertiesConfigurationProvider) error {
+	if err := p.requireRunning(); err != nil {
+		return err
+	}
+	outbound, err := outboundWithProperties(msg, properties)
+	if err != nil {
+		return err
+	}
+	p.service.metrics.add(metrics.PersistentMessagesSent, 1)
+	return p.service.broker.publishPersistent(destination.GetName(), outbound)
+}
+
+type receipt struct {
+	userContext interface{}
+	timestamp   time.Time
+	message     message.OutboundMessage
+	err         error
+}
+
+func (r receipt) GetUserContext() interface{}         { return r.userContext }
+func (r receipt) GetTimeStamp() time.Time             { return r.timestamp }
+func (r receipt) GetMessage() message.OutboundMessage { return r.message }
+func (r receipt) GetError() error                     { return r.err }
+func (r receipt) IsPersisted() bool                   { return r.err == nil }
+
+type persistentReceiverBuilder struct {
+	service        *Service
+	subscriptions  []string
+	autoAck        bool
+	createOnStart  bool
+	outcomes       map[config.MessageSettlementOutcome]bool
+	stateListener  solace.ReceiverStateChangeListener
+	messageFilters string
+}
+
+func (b *persistentReceiverBuilder) Build(queue *resource.Queue) (solace.PersistentMessageReceiver, error) {
+	if queue == nil {
+		return nil, solace.NewError(&solace.IllegalArgumentError{}, "fake: queue must not be nil", nil)
+	}
+	outcomes := map[config.MessageSettlementOutcome]bool{config.PersistentReceiverAcceptedOutcome: true}
+	for outcome := range b.outcomes {
+		outcomes[outcome] = true
+	}
+	r := &persistentReceiver{
+		queueSpec:     queue,
+		autoAck:       b.autoAck,
+		createOnStart: b.createOnStart,
+		outcomes:      outcomes,
+		stateListener: b.stateListener,
+	}
+	r.subs = append([]string(nil), b.subscriptions...)
+	r.init(b.service, r.bind, r.unbind)
+	return r, nil
+}
+
+func (b *persistentReceiverBuilder) WithActivationPassivationSupport(listener solace.ReceiverStateChangeListener) solace.PersistentMessageReceiverBuilder {
+	b.stateListener = listener
+	return b
+}
+
+func (b *persistentReceiverBuilder) WithMessageAutoAcknowledgement() solace.PersistentMessageReceiverBuilder {
+	b.autoAck = true
+	return b
+}
+
+func (b *persistentReceiverBuilder) WithMessageClientAcknowledgement() solace.PersistentMessageReceiverBuilder {
+	b.autoAck = false
+	return b
+}
+
+// WithMessageSelector is accepted but selectors are not evaluated by the fake.
+func (b *persistentReceiverBuilder) WithMessageSelector(filterSelectorExpression string) solace.PersistentMessageReceiverBuilder {
+	b.messageFilters = filterSelectorExpression
+	return b
+}
+
+func (b *persistentReceiverBuilder) WithMissingResourcesCreationStrategy(strategy config.MissingResourcesCreationStrategy) solace.PersistentMessageReceiverBuilder {
+	b.createOnStart = strategy == config.PersistentReceiverCreateOnStartMissingResources
+	return b
+}
+
+// WithMessageReplay is accepted but replay is not supported by the fake.
+func (b *persistentReceiverBuilder) WithMessageReplay(strategy config.ReplayStrategy) solace.PersistentMessageReceiverBuilder {
+	return b
+}
+
+func (b *persistentReceiverBuilder) WithSubscriptions(topics ...resource.Subscription) solace.PersistentMessageReceiverBuilder {
+	for _, topic := range topics {
+		b.subscriptions = append(b.subscriptions, topic.GetName())
+	}
+	return b
+}
+
+func (b *persistentReceiverBuilder) WithRequiredMessageOutcomeSupport(outcomes ...config.MessageSettlementOutcome) solace.PersistentMessageReceiverBuilder {
+	if b.outcomes == nil {
+		b.outcomes = make(map[config.MessageSettlementOutcome]bool)
+	}
+	for _, outcome := range outcomes {
+		b.outcomes[outcome] = true
+	}
+	return b
+}
+
+func (b *persistentReceiverBuilder) FromConfigurationProvider(provider config.ReceiverPropertiesConfigurationProvider) solace.PersistentMessageReceiverBuilder {
+	if provider == nil {
+		return b
+	}
+	for key, val := range provider.GetConfiguration() {
+		switch key {
+		case config.ReceiverPropertyPersistentMessageAckStrategy:
+			b.autoAck = val == config.PersistentReceiverAutoAck
+		case config.ReceiverPropertyPersistentMissingResourceCreationStrategy:
+			b.createOnStart = fmt.Sprint(val) == string(config.PersistentReceiverCreateOnStartMissingResources)
+		case config.ReceiverPropertyPersistentMessageRequiredOutcomeSupport:
+			for _, outcome := range splitList(fmt.Sprint(val)) {
+				b.WithRequiredMessageOutcomeSupport(config.MessageSettlementOutcome(outcome))
+			}
+		}
+	}
+	return b
+}
+
+func splitList(list string) []string {
+	var items []string
+	start := 0
+	for i := 0; i <= len(list); i++ {
+		if i == len(list) || list[i] == ',' {
+			if item := list[start:i]; item != "" {
+				items = append(items, item)
+			}
+			start = i + 1
+		}
+	}
+	return items
+}
+
+type persistentReceiver struct {
+	lifecycle
+	subscriberSet
+	queueSpec     *resource.Queue
+	autoAck       bool
+	createOnStart bool
+	outcomes      map[config.MessageSettlementOutcome]bool
+	stateListener solace.ReceiverStateChangeListener
+
+	mu      sync.Mutex
+	queue   *Queue
+	paused  bool
+	handler solace.MessageHandler
+	cancel  context.CancelFunc
+	ctx     context.Context
+}
+
+func (r *persistentReceiver) bind() error {
+	q, err := r.service.broker.bindQueue(r.queueSpec.GetName(), r.queueSpec.IsDurable(), r.queueSpec.IsExclusivelyAccessible(), r.createOnStart)
+	if err != nil {
+		return err
+	}
+	for _, sub := range r.subscriptions() {
+		q.addSubscription(sub)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r.mu.Lock()
+	r.queue = q
+	r.ctx = ctx
+	r.cancel = cancel
+	handler := r.handler
+	r.mu.Unlock()
+	if q.bind(r) {
+		r.activate()
+	}
+	if handler != nil {
+		r.goRun(r.dispatch)
+	}
+	return nil
+}
+
+// activate notifies the state listener that r became the active consumer of an exclusive queue
+func (r *persistentReceiver) activate() {
+	if r.stateListener != nil {
+		go r.stateListener(solace.ReceiverPassive, solace.ReceiverActive, time.Now())
+	}
+}
+
+func (r *persistentReceiver) unbind() {
+	r.mu.Lock()
+	q := r.queue
+	cancel := r.cancel
+	r.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+	if q != nil {
+		if takeover := q.unbind(r); takeover != nil {
+			takeover.activate()
+		}
+		r.service.broker.releaseQueue(q)
+	}
+}
+
+func (r *persistentReceiver) isPaused() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.paused
+}
+
+// dispatch pulls messages off the queue and calls the async handler
+func (r *persistentReceiver) dispatch() {
+	r.mu.Lock()
+	q, ctx := r.queue, r.ctx
+	r.mu.Unlock()
+	for {
+		msg, err := q.take(ctx, r)
+		if err != nil {
+			return
+		}
+		r.countReceived(msg)
+		r.mu.Lock()
+		handler := r.handler
+		r.mu.Unlock()
+		handler(msg)
+		if r.autoAck {
+			q.settle(r, msg, config.PersistentReceiverAcceptedOutcome)
+		}
+	}
+}
+
+func (r *persistentReceiver) countReceived(msg *Message) {
+	r.service.metrics.add(metrics.PersistentMessagesReceived, 1)
+	if msg.redelivered {
+		r.service.metrics.add(metrics.PersistentMessagesRedelivered, 1)
+	}
+}
+
+func (r *persistentReceiver) StartAsyncCallback(callback func(solace.PersistentMessageReceiver, error)) {
+	err := r.Start()
+	go callback(r, err)
+}
+
+func (r *persistentReceiver) TerminateAsyncCallback(gracePeriod time.Duration, callback func(error)) {
+	r.terminateAsyncCallback(gracePeriod, callback)
+}
+
+func (r *persistentReceiver) ReceiveAsync(callback solace.MessageHandler) error {
+	r.mu.Lock()
+	first := r.handler == nil
+	r.handler = callback
+	bound := r.queue != nil
+	r.mu.Unlock()
+	if first && bound && r.IsRunning() {
+		r.goRun(r.dispatch)
+	}
+	return nil
+}
+
+func (r *persistentReceiver) ReceiveMessage(timeout time.Duration) (message.InboundMessage, error) {
+	if err := r.requireRunning(); err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	q, parent := r.queue, r.ctx
+	r.mu.Unlock()
+	ctx := parent
+	if timeout >= 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(parent, timeout)
+		defer cancel()
+	}
+	msg, err := q.take(ctx, r)
+	if err != nil {
+		if parent.Err() != nil {
+			return nil, solace.NewError(&solace.IllegalStateError{}, "fake: receiver terminated", nil)
+		}
+		return nil, solace.NewError(&solace.TimeoutError{}, "fake: timed out waiting for a message", nil)
+	}
+	r.countReceived(msg)
+	if r.autoAck {
+		q.settle(r, msg, config.PersistentReceiverAcceptedOutcome)
+	}
+	return msg, nil
+}
+
+func (r *persistentReceiver) Ack(msg message.InboundMessage) error {
+	return r.Settle(msg, config.PersistentReceiverAcceptedOutcome)
+}
+
+func (r *persistentReceiver) Settle(msg message.InboundMessage, outcome config.MessageSettlementOutcome) error {
+	if !r.outcomes[outcome] {
+		return solace.NewError(&solace.IllegalArgumentError{}, fmt.Sprintf("fake: settlement outcome %s was not enabled with WithRequiredMessageOutcomeSupport", outcome), nil)
+	}
+	if r.autoAck {
+		// auto acknowledged messages are already settled
+		return nil
+	}
+	fakeMsg, ok := msg.(*Message)
+	if !ok {
+		return solace.NewError(&solace.IllegalArgumentError{}, "fake: message was not received from the fake", nil)
+	}
+	r.mu.Lock()
+	q := r.queue
+	r.mu.Unlock()
+	if q == nil {
+		return solace.NewError(&solace.IllegalStateError{}, "fake: receiver is not bound", nil)
+	}
+	if err := q.settle(r, fakeMsg, outcome); err != nil {
+		return err
+	}
+	r.service.metrics.add(metrics.PersistentAcknowledgeSent, 1)
+	return nil
+}
+
+func (r *persistentReceiver) Pause() error {
+	r.mu.Lock()
+	r.paused = true
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *persistentReceiver) Resume() error {
+	r.mu.Lock()
+	r.paused = false
+	q := r.queue
+	r.mu.Unlock()
+	if q != nil {
+		q.wake()
+	}
+	return nil
+}
+
+func (r *persistentReceiver) ReceiverInfo() (solace.PersistentReceiverInfo, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	name := r.queueSpec.GetName()
+	if r.queue != nil {
+		name = r.queue.name
+	}
+	return receiverInfo{name: name, durable: r.queueSpec.IsDurable()}, nil
+}
+
+func (r *persistentReceiver) AddSubscription(subscription resource.Subscription) error {
+	r.add(subscription.GetName())
+	r.mu.Lock()
+	q := r.queue
+	r.mu.Unlock()
+	if q != nil {
+		q.addSubscription(subscription.GetName())
+	}
+	return nil
+}
+
+func (r *persistentReceiver) RemoveSubscription(subscription resource.Subscription) error {
+	r.remove(subscription.GetName())
+	r.mu.Lock()
+	q := r.queue
+	r.mu.Unlock()
+	if q != nil {
+		q.removeSubscription(subscription.GetName())
+	}
+	return nil
+}
+
+func (r *persistentReceiver) AddSubscriptionAsync(subscription resource.Subscription, listener solace.SubscriptionChangeListener) error {
+	err := r.AddSubscription(subscription)
+	if listener != nil {
+		go listener(subscription, solace.SubscriptionAdded, err)
+	}
+	return nil
+}
+
+func (r *persistentReceiver) RemoveSubscriptionAsync(subscription resource.Subscription, listener solace.SubscriptionChangeListener) error {
+	err := r.RemoveSubscription(subscription)
+	if listener != nil {
+		go listener(subscription, solace.SubscriptionRemoved, err)
+	}
+	return nil
+}
+
+type receiverInfo struct {
+	name    string
+	durable bool
+}
+
+func (i receiverInfo) GetResourceInfo() solace.ResourceInfo { return i }
+func (i receiverInfo) GetName() string                      { return i.name }
+func (i receiverInfo) IsDurable() bool                      { return i.durable }
@@ -0,0 +1,118 @@
+package fake
+
+import (
+	"solace.dev/go/messaging/pkg/solace"
+	"solace.dev/go/messaging/pkg/solace/config"
+)
+
+// endpointProvisioner creates and removes queues on the fake broker. Like the
+// real provisioner, queues are durable unless configured otherwise.
+type endpointProvisioner struct {
+	service    *Service
+	properties config.EndpointPropertyMap
+}
+
+func newEndpointProvisioner(service *Service) *endpointProvisioner {
+	return &endpointProvisioner{
+		service:    service,
+		properties: config.EndpointPropertyMap{config.EndpointPropertyDurable: true},
+	}
+}
+
+func (p *endpointProvisioner) Provision(queueName string, ignoreExists bool) solace.ProvisionOutcome {
+	if err := p.service.requireConnected(); err != nil {
+		return provisionOutcome{err: err}
+	}
+	err := p.service.broker.provision(queueName, p.GetConfiguration(), ignoreExists)
+	return provisionOutcome{err: err, status: err == nil}
+}
+
+func (p *endpointProvisioner) ProvisionAsync(queueName string, ignoreExists bool) <-chan solace.ProvisionOutcome {
+	result := make(chan solace.ProvisionOutcome, 1)
+	result <- p.Provision(queueName, ignoreExists)
+	return result
+}
+
+func (p *endpointProvisioner) ProvisionAsyncWithCallback(queueName string, ignoreExists bool, callback func(solace.ProvisionOutcome)) {
+	outcome := p.Provision(queueName, ignoreExists)
+	go callback(outcome)
+}
+
+func (p *endpointProvisioner) Deprovision(queueName string, ignoreMissing bool) error {
+	if err := p.service.requireConnected(); err != nil {
+		return err
+	}
+	return p.service.broker.deprovision(queueName, ignoreMissing)
+}
+
+func (p *endpointProvisioner) DeprovisionAsync(queueName string, ignoreMissing bool) <-chan error {
+	return asyncResult(p.Deprovision(queueName, ignoreMissing))
+}
+
+func (p *endpointProvisioner) DeprovisionAsyncWithCallback(queueName string, ignoreMissing bool, callback func(err error)) {
+	err := p.Deprovision(queueName, ignoreMissing)
+	go callback(err)
+}
+
+func (p *endpointProvisioner) FromConfigurationProvider(properties config.EndpointPropertiesConfigurationProvider) solace.EndpointProvisioner {
+	if properties == nil {
+		return p
+	}
+	for key, val := range properties.GetConfiguration() {
+		p.properties[key] = val
+	}
+	return p
+}
+
+func (p *endpointProvisioner) GetConfiguration() config.EndpointPropertyMap {
+	props := config.EndpointPropertyMap{}
+	for key, val := range p.properties {
+		props[key] = val
+	}
+	return props
+}
+
+func (p *endpointProvisioner) WithProperty(propertyName config.EndpointProperty, propertyValue interface{}) solace.EndpointProvisioner {
+	p.properties[propertyName] = propertyValue
+	return p
+}
+
+func (p *endpointProvisioner) WithDurability(durable bool) solace.EndpointProvisioner {
+	return p.WithProperty(config.EndpointPropertyDurable, durable)
+}
+
+func (p *endpointProvisioner) WithExclusiveAccess(exclusive bool) solace.EndpointProvisioner {
+	return p.WithProperty(config.EndpointPropertyExclusive, exclusive)
+}
+
+func (p *endpointProvisioner) WithDiscardNotification(notifySender bool) solace.EndpointProvisioner {
+	return p.WithProperty(config.EndpointPropertyNotifySender, notifySender)
+}
+
+func (p *endpointProvisioner) WithMaxMessageRedelivery(count uint) solace.EndpointProvisioner {
+	return p.WithProperty(config.EndpointPropertyMaxMessageRedelivery, count)
+}
+
+func (p *endpointProvisioner) WithMaxMessageSize(count uint) solace.EndpointProvisioner {
+	return p.WithProperty(config.EndpointPropertyMaxMessageSize, count)
+}
+
+func (p *endpointProvisioner) WithPermission(permission config.EndpointPermission) solace.EndpointProvisioner {
+	return p.WithProperty(config.EndpointPropertyPermission, permission)
+}
+
+func (p *endpointProvisioner) WithQuotaMB(quota uint) solace.EndpointProvisioner {
+	return p.WithProperty(config.EndpointPropertyQuotaMB, quota)
+}
+
+func (p *endpointProvisioner) WithTTLPolicy(respect bool) solace.EndpointProvisioner {
+	return p.WithProperty(config.EndpointPropertyRespectsTTL, respect)
+}
+
+type provisionOutcome struct {
+	err    error
+	status bool
+}
+
+func (o provisionOutcome) GetError() error { return o.err }
+func (o provisionOutcome) GetStatus() bool { return o.status }
@@ -0,0 +1,267 @@
+package fake
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"solace.dev/go/messaging/pkg/solace"
+	"solace.dev/go/messaging/pkg/solace/config"
+
+	"SolaceSamples.com/PubSub+Go/internal/wildcard"
+)
+
+// queueTopicPrefix addresses a queue by name, persistent messages published to
+// "#P2P/QUE/<name>" are spooled on that queue whatever its subscriptions
+const queueTopicPrefix = "#P2P/QUE/"
+
+// Queue is an in-memory message spool. Messages stay on the queue until the
+// receiver they were delivered to acknowledges, rejects or exhausts them.
+type Queue struct {
+	name       string
+	durable    bool
+	exclusive  bool
+	properties config.EndpointPropertyMap
+
+	mu            sync.Mutex
+	subscriptions []string
+	pending       []*Message
+	unacked       map[*Message]*persistentReceiver
+	deliveries    map[*Message]int
+	discarded     []*Message
+	consumers     []*persistentReceiver
+	changed       chan struct{}
+}
+
+func newQueue(name string, durable, exclusive bool, properties config.EndpointPropertyMap) *Queue {
+	props := config.EndpointPropertyMap{}
+	for key, val := range properties {
+		props[key] = val
+	}
+	return &Queue{
+		name:       name,
+		durable:    durable,
+		exclusive:  exclusive,
+		properties: props,
+		unacked:    make(map[*Message]*persistentReceiver),
+		deliveries: make(map[*Message]int),
+		changed:    make(chan struct{}),
+	}
+}
+
+// Name returns the queue name.
+func (q *Queue) Name() string { return q.name }
+
+// Subscriptions returns the topic subscriptions of the queue.
+func (q *Queue) Subscriptions() []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]string(nil), q.subscriptions...)
+}
+
+// Pending returns the number of messages waiting for delivery.
+func (q *Queue) Pending() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending)
+}
+
+// Unacked returns the number of delivered messages that are not settled yet.
+func (q *Queue) Unacked() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.unacked)
+}
+
+// Discarded returns the messages that were rejected or exceeded the maximum
+// redelivery count, i.e. what would be moved to a dead message queue.
+func (q *Queue) Discarded() []*Message {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]*Message(nil), q.discarded...)
+}
+
+// Properties returns the properties the queue was provisioned with.
+func (q *Queue) Properties() config.EndpointPropertyMap {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	props := config.EndpointPropertyMap{}
+	for key, val := range q.properties {
+		props[key] = val
+	}
+	return props
+}
+
+func (q *Queue) addSubscription(sub string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, s := range q.subscriptions {
+		if s == sub {
+			return
+		}
+	}
+	q.subscriptions = append(q.subscriptions, sub)
+}
+
+func (q *Queue) removeSubscription(sub string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, s := range q.subscriptions {
+		if s == sub {
+			q.subscriptions = append(q.subscriptions[:i], q.subscriptions[i+1:]...)
+			return
+		}
+	}
+}
+
+func (q *Queue) matches(topic string) bool {
+	if topic == queueTopicPrefix+q.name {
+		return true
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, sub := range q.subscriptions {
+		if wildcard.Match(sub, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+func (q *Queue) sameProperties(properties config.EndpointPropertyMap) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return reflect.DeepEqual(q.properties, properties)
+}
+
+func (q *Queue) maxRedelivery() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	switch v := q.properties[config.EndpointPropertyMaxMessageRedelivery].(type) {
+	case int:
+		return v
+	case uint:
+		return int(v)
+	}
+	// zero means retry forever
+	return 0
+}
+
+// notifyLocked wakes every receiver waiting on the queue, q.mu must be held
+func (q *Queue) notifyLocked() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
+func (q *Queue) enqueue(msg *Message) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.pending = append(q.pending, msg)
+	q.notifyLocked()
+	return nil
+}
+
+// wake makes waiting receivers re-check the queue, e.g. after a resume
+func (q *Queue) wake() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.notifyLocked()
+}
+
+// bind attaches r as a consumer. On an exclusive queue only the first consumer is active and the others wait
+// passively; bind reports whether r is the active one.
+func (q *Queue) bind(r *persistentReceiver) (active bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.consumers = append(q.consumers, r)
+	q.notifyLocked()
+	return q.exclusive && len(q.consumers) == 1
+}
+
+// unbind detaches r and puts its unsettled messages back on the queue flagged as redelivered.
+// When r was the active consumer of an exclusive queue, the standby that takes over is returned.
+func (q *Queue) unbind(r *persistentReceiver) (takeover *persistentReceiver) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, c := range q.consumers {
+		if c == r {
+			q.consumers = append(q.consumers[:i], q.consumers[i+1:]...)
+			if q.exclusive && i == 0 && len(q.consumers) > 0 {
+				takeover = q.consumers[0]
+			}
+			break
+		}
+	}
+	var requeue []*Message
+	for msg, owner := range q.unacked {
+		if owner == r {
+			delete(q.unacked, msg)
+			msg.redelivered = true
+			requeue = append(requeue, msg)
+		}
+	}
+	q.pending = append(requeue, q.pending...)
+	q.notifyLocked()
+	return takeover
+}
+
+func (q *Queue) consumerCount() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.consumers)
+}
+
+// take blocks until a message can be delivered to r or ctx is done
+func (q *Queue) take(ctx context.Context, r *persistentReceiver) (*Message, error) {
+	for {
+		q.mu.Lock()
+		eligible := len(q.consumers) > 0 && (!q.exclusive || q.consumers[0] == r)
+		if eligible && !r.isPaused() && len(q.pending) > 0 {
+			msg := q.pending[0]
+			q.pending = q.pending[1:]
+			q.unacked[msg] = r
+			q.deliveries[msg]++
+			q.mu.Unlock()
+			return msg, nil
+		}
+		changed := q.changed
+		q.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// settle applies the outcome for a message delivered to r
+func (q *Queue) settle(r *persistentReceiver, msg *Message, outcome config.MessageSettlementOutcome) error {
+	maxRedelivery := q.maxRedelivery()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if owner, ok := q.unacked[msg]; !ok || owner != r {
+		return solace.NewError(&solace.IllegalArgumentError{}, "fake: message was not delivered to this receiver or is already settled", nil)
+	}
+	delete(q.unacked, msg)
+	switch outcome {
+	case config.PersistentReceiverAcceptedOutcome:
+		delete(q.deliveries, msg)
+	case config.PersistentReceiverRejectedOutcome:
+		delete(q.deliveries, msg)
+		q.discarded = append(q.discarded, msg)
+	case config.PersistentReceiverFailedOutcome:
+		if maxRedelivery > 0 && q.deliveries[msg] > maxRedelivery {
+			delete(q.deliveries, msg)
+			q.discarded = append(q.discarded, msg)
+			break
+		}
+		msg.redelivered = true
+		q.pending = append([]*Message{msg}, q.pending...)
+		q.notifyLocked()
+	default:
+		return solace.NewError(&solace.IllegalArgumentError{}, fmt.Sprintf("fake: unknown settlement outcome %s", outcome), nil)
+	}
+	return nil
+}
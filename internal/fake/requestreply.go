@@ -0,0 +1,294 @@
+package fake
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"solace.dev/go/messaging/pkg/solace"
+	"solace.dev/go/messaging/pkg/solace/config"
+	"solace.dev/go/messaging/pkg/solace/message"
+	"solace.dev/go/messaging/pkg/solace/metrics"
+	"solace.dev/go/messaging/pkg/solace/resource"
+)
+
+type requestReply struct {
+	service *Service
+}
+
+func (rr requestReply) CreateRequestReplyMessagePublisherBuilder() solace.RequestReplyMessagePublisherBuilder {
+	return &requestReplyPublisherBuilder{service: rr.service}
+}
+
+func (rr requestReply) CreateRequestReplyMessageReceiverBuilder() solace.RequestReplyMessageReceiverBuilder {
+	return &requestReplyReceiverBuilder{service: rr.service, bufferCapacity: defaultBufferCapacity}
+}
+
+type requestReplyPublisherBuilder struct {
+	service *Service
+}
+
+func (b *requestReplyPublisherBuilder) Build() (solace.RequestReplyMessagePublisher, error) {
+	p := &requestReplyPublisher{
+		replyTopic: fmt.Sprintf("#P2P/v:fake/%s/%d", b.service.GetApplicationID(), b.service.broker.nextID()),
+		pending:    make(map[string]*pendingRequest),
+	}
+	p.init(b.service,
+		func() error {
+			b.service.broker.addDirect(p)
+			return nil
+		},
+		func() {
+			b.service.broker.removeDirect(p)
+			p.failPending()
+		})
+	return p, nil
+}
+
+func (b *requestReplyPublisherBuilder) OnBackPressureReject(bufferSize uint) solace.RequestReplyMessagePublisherBuilder {
+	return b
+}
+
+func (b *requestReplyPublisherBuilder) OnBackPressureWait(bufferSize uint) solace.RequestReplyMessagePublisherBuilder {
+	return b
+}
+
+func (b *requestReplyPublisherBuilder) FromConfigurationProvider(provider config.PublisherPropertiesConfigurationProvider) solace.RequestReplyMessagePublisherBuilder {
+	return b
+}
+
+type pendingRequest struct {
+	handler     solace.ReplyMessageHandler
+	userContext interface{}
+	timer       *time.Timer
+}
+
+// requestReplyPublisher publishes requests direct and listens for the replies on
+// its own reply topic, matching them by request ID
+type requestReplyPublisher struct {
+	lifecycle
+	replyTopic string
+
+	mu                sync.Mutex
+	pending           map[string]*pendingRequest
+	readinessListener solace.PublisherReadinessListener
+}
+
+func (p *requestReplyPublisher) subscriptions() []string {
+	return []string{p.replyTopic}
+}
+
+func (p *requestReplyPublisher) deliver(msg *Message) {
+	p.mu.Lock()
+	req, ok := p.pending[msg.requestID]
+	delete(p.pending, msg.requestID)
+	p.mu.Unlock()
+	if !ok {
+		// late reply after a timeout
+		return
+	}
+	if req.timer != nil {
+		req.timer.Stop()
+	}
+	p.service.metrics.add(metrics.DirectMessagesReceived, 1)
+	p.goRun(func() { req.handler(msg, req.userContext, nil) })
+}
+
+// failPending completes the outstanding requests when the publisher terminates
+func (p *requestReplyPublisher) failPending() {
+	p.mu.Lock()
+	pending := p.pending
+	p.pending = make(map[string]*pendingRequest)
+	p.mu.Unlock()
+	for _, req := range pending {
+		if req.timer != nil {
+			req.timer.Stop()
+		}
+		req.handler(nil, req.userContext, solace.NewError(&solace.IllegalStateError{}, "fake: publisher terminated before a reply was received", nil))
+	}
+}
+
+func (p *requestReplyPublisher) StartAsyncCallback(callback func(solace.RequestReplyMessagePublisher, error)) {
+	err := p.Start()
+	go callback(p, err)
+}
+
+func (p *requestReplyPublisher) TerminateAsyncCallback(gracePeriod time.Duration, callback func(error)) {
+	p.terminateAsyncCallback(gracePeriod, callback)
+}
+
+func (p *requestReplyPublisher) IsReady() bool { return p.IsRunning() }
+
+func (p *requestReplyPublisher) SetPublisherReadinessListener(listener solace.PublisherReadinessListener) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.readinessListener = listener
+}
+
+func (p *requestReplyPublisher) NotifyWhenReady() {
+	p.mu.Lock()
+	listener := p.readinessListener
+	p.mu.Unlock()
+	if listener != nil && p.IsReady() {
+		go listener()
+	}
+}
+
+func (p *requestReplyPublisher) PublishBytes(payload []byte, replyMessageHandler solace.ReplyMessageHandler, destination *resource.Topic, replyTimeout time.Duration, userContext interface{}) error {
+	msg, _ := newMessageBuilder().BuildWithByteArrayPayload(payload)
+	return p.Publish(msg, replyMessageHandler, destination, replyTimeout, nil, userContext)
+}
+
+func (p *requestReplyPublisher) PublishString(payload string, replyMessageHandler solace.ReplyMessageHandler, destination *resource.Topic, replyTimeout time.Duration, userContext interface{}) error {
+	msg, _ := newMessageBuilder().BuildWithStringPayload(payload)
+	return p.Publish(msg, replyMessageHandler, destination, replyTimeout, nil, userContext)
+}
+
+func (p *requestReplyPublisher) Publish(requestMessage message.OutboundMessage, replyMessageHandler solace.ReplyMessageHandler,
+	requestsDestination *resource.Topic, replyTimeout time.Duration,
+	properties config.MessagePropertiesConfigurationProvider, userContext interface{}) error {
+	if replyMessageHandler == nil {
+		return solace.NewError(&solace.IllegalArgumentError{}, "fake: reply message handler must not be nil", nil)
+	}
+	if err := p.requireRunning(); err != nil {
+		return err
+	}
+	outbound, err := outboundWithProperties(requestMessage, properties)
+	if err != nil {
+		return err
+	}
+	request := outbound.clone()
+	request.replyTo = p.replyTopic
+	request.requestID = fmt.Sprintf("fake-request-%d", p.service.broker.nextID())
+
+	req := &pendingRequest{handler: replyMessageHandler, userContext: userContext}
+	p.mu.Lock()
+	p.pending[request.requestID] = req
+	if replyTimeout >= 0 {
+		req.timer = time.AfterFunc(replyTimeout, func() { p.expire(request.requestID) })
+	}
+	p.mu.Unlock()
+
+	p.service.metrics.add(metrics.DirectMessagesSent, 1)
+	p.service.broker.publishDirect(requestsDestination.GetName(), request)
+	return nil
+}
+
+// expire calls the handler of a request that got no reply in time
+func (p *requestReplyPublisher) expire(requestID string) {
+	p.mu.Lock()
+	req, ok := p.pending[requestID]
+	delete(p.pending, requestID)
+	p.mu.Unlock()
+	if ok {
+		req.handler(nil, req.userContext, solace.NewError(&solace.TimeoutError{}, "fake: timed out waiting for a reply", nil))
+	}
+}
+
+func (p *requestReplyPublisher) PublishAwaitResponse(requestMessage message.OutboundMessage, requestDestination *resource.Topic,
+	replyTimeout time.Duration, properties config.MessagePropertiesConfigurationProvider) (message.InboundMessage, error) {
+	type result struct {
+		msg message.InboundMessage
+		err error
+	}
+	results := make(chan result, 1)
+	err := p.Publish(requestMessage, func(msg message.InboundMessage, userContext interface{}, err error) {
+		results <- result{msg, err}
+	}, requestDestination, replyTimeout, properties, nil)
+	if err != nil {
+		return nil, err
+	}
+	r := <-results
+	return r.msg, r.err
+}
+
+type requestReplyReceiverBuilder struct {
+	service        *Service
+	bufferCapacity uint
+	dropOldest     bool
+}
+
+func (b *requestReplyReceiverBuilder) Build(requestTopicSubscription resource.Subscription) (solace.RequestReplyMessageReceiver, error) {
+	return &requestReplyReceiver{
+		directReceiver: newDirectReceiver(b.service, []string{requestTopicSubscription.GetName()}, b.bufferCapacity, b.dropOldest),
+	}, nil
+}
+
+func (b *requestReplyReceiverBuilder) BuildWithSharedSubscription(requestTopicSubscription resource.Subscription, shareName *resource.ShareName) (solace.RequestReplyMessageReceiver, error) {
+	shared := "#share/" + shareName.GetName() + "/" + requestTopicSubscription.GetName()
+	return &requestReplyReceiver{
+		directReceiver: newDirectReceiver(b.service, []string{shared}, b.bufferCapacity, b.dropOldest),
+	}, nil
+}
+
+func (b *requestReplyReceiverBuilder) OnBackPressureDropLatest(bufferCapacity uint) solace.RequestReplyMessageReceiverBuilder {
+	b.bufferCapacity = bufferCapacity
+	b.dropOldest = false
+	return b
+}
+
+func (b *requestReplyReceiverBuilder) OnBackPressureDropOldest(bufferCapacity uint) solace.RequestReplyMessageReceiverBuilder {
+	b.bufferCapacity = bufferCapacity
+	b.dropOldest = true
+	return b
+}
+
+func (b *requestReplyReceiverBuilder) FromConfigurationProvider(provider config.ReceiverPropertiesConfigurationProvider) solace.RequestReplyMessageReceiverBuilder {
+	return b
+}
+
+// requestReplyReceiver is a direct receiver that hands out a replier with every request
+type requestReplyReceiver struct {
+	*directReceiver
+}
+
+func (r *requestReplyReceiver) StartAsyncCallback(callback func(solace.RequestReplyMessageReceiver, error)) {
+	err := r.Start()
+	go callback(r, err)
+}
+
+func (r *requestReplyReceiver) ReceiveAsync(messageHandler solace.RequestMessageHandler) error {
+	return r.directReceiver.ReceiveAsync(func(msg message.InboundMessage) {
+		messageHandler(msg, r.replierFor(msg))
+	})
+}
+
+func (r *requestReplyReceiver) ReceiveMessage(timeout time.Duration) (message.InboundMessage, solace.Replier, error) {
+	msg, err := r.directReceiver.ReceiveMessage(timeout)
+	if err != nil {
+		return nil, nil, err
+	}
+	return msg, r.replierFor(msg), nil
+}
+
+// replierFor returns nil for messages that were not sent as requests
+func (r *requestReplyReceiver) replierFor(msg message.InboundMessage) solace.Replier {
+	request, ok := msg.(*Message)
+	if !ok || request.replyTo == "" {
+		return nil
+	}
+	return replier{service: r.service, request: request}
+}
+
+type replier struct {
+	service *Service
+	request *Message
+}
+
+func (rp replier) Reply(msg message.OutboundMessage) error {
+	if err := rp.service.requireConnected(); err != nil {
+		return err
+	}
+	outbound, err := outboundWithProperties(msg, nil)
+	if err != nil {
+		return err
+	}
+	reply := outbound.clone()
+	reply.requestID = rp.request.requestID
+	if reply.correlationID == "" {
+		reply.correlationID = rp.request.correlationID
+	}
+	rp.service.metrics.add(metrics.DirectMessagesSent, 1)
+	rp.service.broker.publishDirect(rp.request.replyTo, reply)
+	return nil
+}
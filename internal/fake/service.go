@@ -0,0 +1,324 @@
+package fake
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"solace.dev/go/messaging/pkg/solace"
+	"solace.dev/go/messaging/pkg/solace/config"
+	"solace.dev/go/messaging/pkg/solace/metrics"
+)
+
+// BrokerURI is reported in the service events raised by the fake.
+const BrokerURI = "tcp://fake-broker:55555"
+
+// Service is an in-memory solace.MessagingService attached to a Broker.
+type Service struct {
+	broker *Broker
+	appID  string
+
+	mu                   sync.Mutex
+	connected            bool
+	properties           map[config.ServiceProperty]interface{}
+	reconnectListeners   map[uint64]solace.ReconnectionListener
+	attemptListeners     map[uint64]solace.ReconnectionAttemptListener
+	interruptedListeners map[uint64]solace.ServiceInterruptionListener
+	terminators          []func(cause error)
+	metrics              *apiMetrics
+}
+
+var _ solace.MessagingService = (*Service)(nil)
+
+// NewService creates a disconnected service attached to the broker.
+func (b *Broker) NewService() *Service {
+	return &Service{
+		broker:               b,
+		appID:                fmt.Sprintf("fake-client/%d", b.nextID()),
+		properties:           make(map[config.ServiceProperty]interface{}),
+		reconnectListeners:   make(map[uint64]solace.ReconnectionListener),
+		attemptListeners:     make(map[uint64]solace.ReconnectionAttemptListener),
+		interruptedListeners: make(map[uint64]solace.ServiceInterruptionListener),
+		metrics:              newAPIMetrics(),
+	}
+}
+
+// Broker returns the broker the service is attached to.
+func (s *Service) Broker() *Broker { return s.broker }
+
+// Connect connects the service.
+func (s *Service) Connect() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connected = true
+	s.metrics.add(metrics.ConnectionAttempts, 1)
+	return nil
+}
+
+// ConnectAsync connects the service.
+func (s *Service) ConnectAsync() <-chan error {
+	return asyncResult(s.Connect())
+}
+
+// ConnectAsyncWithCallback connects the service and calls callback.
+func (s *Service) ConnectAsyncWithCallback(callback func(solace.MessagingService, error)) {
+	err := s.Connect()
+	go callback(s, err)
+}
+
+// CreateDirectMessagePublisherBuilder returns a direct publisher builder.
+func (s *Service) CreateDirectMessagePublisherBuilder() solace.DirectMessagePublisherBuilder {
+	return &directPublisherBuilder{service: s}
+}
+
+// CreateDirectMessageReceiverBuilder returns a direct receiver builder.
+func (s *Service) CreateDirectMessageReceiverBuilder() solace.DirectMessageReceiverBuilder {
+	return &directReceiverBuilder{service: s, bufferCapacity: defaultBufferCapacity}
+}
+
+// CreatePersistentMessagePublisherBuilder returns a persistent publisher builder.
+func (s *Service) CreatePersistentMessagePublisherBuilder() solace.PersistentMessagePublisherBuilder {
+	return &persistentPublisherBuilder{service: s}
+}
+
+// CreatePersistentMessageReceiverBuilder returns a persistent receiver builder.
+func (s *Service) CreatePersistentMessageReceiverBuilder() solace.PersistentMessageReceiverBuilder {
+	return &persistentReceiverBuilder{service: s, autoAck: true}
+}
+
+// MessageBuilder returns a builder for fake messages.
+func (s *Service) MessageBuilder() solace.OutboundMessageBuilder {
+	return newMessageBuilder()
+}
+
+// EndpointProvisioner returns a provisioner that creates queues on the fake broker.
+func (s *Service) EndpointProvisioner() solace.EndpointProvisioner {
+	return newEndpointProvisioner(s)
+}
+
+// RequestReply returns the request-reply builders.
+func (s *Service) RequestReply() solace.RequestReplyMessagingService {
+	return requestReply{service: s}
+}
+
+// Disconnect disconnects the service and terminates its publishers and receivers.
+func (s *Service) Disconnect() error {
+	s.mu.Lock()
+	s.connected = false
+	terminators := s.terminators
+	s.terminators = nil
+	s.mu.Unlock()
+	for _, terminate := range terminators {
+		terminate(nil)
+	}
+	return nil
+}
+
+// DisconnectAsync disconnects the service.
+func (s *Service) DisconnectAsync() <-chan error {
+	return asyncResult(s.Disconnect())
+}
+
+// DisconnectAsyncWithCallback disconnects the service and calls callback.
+func (s *Service) DisconnectAsyncWithCallback(callback func(error)) {
+	err := s.Disconnect()
+	go callback(err)
+}
+
+// IsConnected reports whether the service is connected.
+func (s *Service) IsConnected() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.connected
+}
+
+// AddReconnectionListener registers a listener for SimulateReconnect.
+func (s *Service) AddReconnectionListener(listener solace.ReconnectionListener) uint64 {
+	id := s.broker.nextID()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reconnectListeners[id] = listener
+	return id
+}
+
+// AddReconnectionAttemptListener registers a listener for SimulateReconnect.
+func (s *Service) AddReconnectionAttemptListener(listener solace.ReconnectionAttemptListener) uint64 {
+	id := s.broker.nextID()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attemptListeners[id] = listener
+	return id
+}
+
+// RemoveReconnectionListener removes a listener.
+func (s *Service) RemoveReconnectionListener(listenerID uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.reconnectListeners, listenerID)
+}
+
+// RemoveReconnectionAttemptListener removes a listener.
+func (s *Service) RemoveReconnectionAttemptListener(listenerID uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.attemptListeners, listenerID)
+}
+
+// AddServiceInterruptionListener registers a listener for SimulateInterruption.
+func (s *Service) AddServiceInterruptionListener(listener solace.ServiceInterruptionListener) uint64 {
+	id := s.broker.nextID()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.interruptedListeners[id] = listener
+	return id
+}
+
+// RemoveServiceInterruptionListener removes a listener.
+func (s *Service) RemoveServiceInterruptionListener(listenerID uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.interruptedListeners, listenerID)
+}
+
+// GetApplicationID returns the generated client name.
+func (s *Service) GetApplicationID() string { return s.appID }
+
+// Metrics returns the counters kept by the fake.
+func (s *Service) Metrics() metrics.APIMetrics { return s.metrics }
+
+// Info returns static API information.
+func (s *Service) Info() metrics.APIInfo { return apiInfo{} }
+
+// UpdateProperty records the property, see Property.
+func (s *Service) UpdateProperty(property config.ServiceProperty, value interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.properties[property] = value
+	return nil
+}
+
+// Property returns a value set with UpdateProperty.
+func (s *Service) Property(property config.ServiceProperty) (interface{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	val, ok := s.properties[property]
+	return val, ok
+}
+
+// SimulateReconnect raises a reconnection attempt followed by a reconnected event.
+func (s *Service) SimulateReconnect(cause error) {
+	s.mu.Lock()
+	attempts := make([]solace.ReconnectionAttemptListener, 0, len(s.attemptListeners))
+	for _, l := range s.attemptListeners {
+		attempts = append(attempts, l)
+	}
+	reconnects := make([]solace.ReconnectionListener, 0, len(s.reconnectListeners))
+	for _, l := range s.reconnectListeners {
+		reconnects = append(reconnects, l)
+	}
+	s.mu.Unlock()
+
+	for _, l := range attempts {
+		l(newServiceEvent("reconnecting", cause))
+	}
+	for _, l := range reconnects {
+		l(newServiceEvent("reconnected", nil))
+	}
+}
+
+// SimulateInterruption raises a service interruption event and disconnects the service.
+func (s *Service) SimulateInterruption(cause error) {
+	s.mu.Lock()
+	listeners := make([]solace.ServiceInterruptionListener, 0, len(s.interruptedListeners))
+	for _, l := range s.interruptedListeners {
+		listeners = append(listeners, l)
+	}
+	s.mu.Unlock()
+
+	for _, l := range listeners {
+		l(newServiceEvent("service interrupted", cause))
+	}
+	s.mu.Lock()
+	s.connected = false
+	terminators := s.terminators
+	s.terminators = nil
+	s.mu.Unlock()
+	for _, terminate := range terminators {
+		terminate(cause)
+	}
+}
+
+// onDisconnect registers a function terminating a publisher or receiver with the service
+func (s *Service) onDisconnect(fn func(cause error)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.terminators = append(s.terminators, fn)
+}
+
+func (s *Service) requireConnected() error {
+	if !s.IsConnected() {
+		return solace.NewError(&solace.IllegalStateError{}, "fake: messaging service is not connected", nil)
+	}
+	return nil
+}
+
+func asyncResult(err error) <-chan error {
+	result := make(chan error, 1)
+	result <- err
+	return result
+}
+
+type serviceEvent struct {
+	timestamp time.Time
+	message   string
+	cause     error
+}
+
+func newServiceEvent(message string, cause error) serviceEvent {
+	return serviceEvent{timestamp: time.Now(), message: message, cause: cause}
+}
+
+func (e serviceEvent) GetTimestamp() time.Time { return e.timestamp }
+func (e serviceEvent) GetBrokerURI() string    { return BrokerURI }
+func (e serviceEvent) GetMessage() string      { return e.message }
+func (e serviceEvent) GetCause() error         { return e.cause }
+
+type apiMetrics struct {
+	mu     sync.Mutex
+	values map[metrics.Metric]uint64
+}
+
+func newAPIMetrics() *apiMetrics {
+	return &apiMetrics{values: make(map[metrics.Metric]uint64)}
+}
+
+func (m *apiMetrics) add(metric metrics.Metric, delta uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.values[metric] += delta
+}
+
+func (m *apiMetrics) GetValue(metric metrics.Metric) uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.values[metric]
+}
+
+func (m *apiMetrics) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.values = make(map[metrics.Metric]uint64)
+}
+
+func (m *apiMetrics) String() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return fmt.Sprint(m.values)
+}
+
+type apiInfo struct{}
+
+func (apiInfo) GetAPIBuildDate() string            { return "fake" }
+func (apiInfo) GetAPIVersion() string              { return "fake" }
+func (apiInfo) GetAPIUserID() string               { return "fake" }
+func (apiInfo) GetAPIImplementationVendor() string { return "fake" }
@@ -0,0 +1,56 @@
+package fake
+
+// traceContext is one of the two trace contexts a Solace message carries
+type traceContext struct {
+	traceID    [16]byte
+	spanID     [8]byte
+	sampled    bool
+	traceState string
+	set        bool
+}
+
+func (c traceContext) get() ([16]byte, [8]byte, bool, string, bool) {
+	return c.traceID, c.spanID, c.sampled, c.traceState, c.set
+}
+
+func (c *traceContext) update(traceID [16]byte, spanID [8]byte, sampled bool, traceState *string) bool {
+	c.traceID, c.spanID, c.sampled, c.set = traceID, spanID, sampled, true
+	if traceState != nil {
+		c.traceState = *traceState
+	}
+	return true
+}
+
+// The methods below make Message usable with the carriers of
+// solace.dev/go/messaging-trace/opentelemetry, like the messages of the real API.
+
+// GetCreationTraceContext returns the trace context the message was created in.
+func (m *Message) GetCreationTraceContext() (traceID [16]byte, spanID [8]byte, sampled bool, traceState string, ok bool) {
+	return m.creationContext.get()
+}
+
+// SetCreationTraceContext sets the trace context the message was created in.
+func (m *Message) SetCreationTraceContext(traceID [16]byte, spanID [8]byte, sampled bool, traceState *string) (ok bool) {
+	return m.creationContext.update(traceID, spanID, sampled, traceState)
+}
+
+// GetTransportTraceContext returns the trace context of the last hop.
+func (m *Message) GetTransportTraceContext() (traceID [16]byte, spanID [8]byte, sampled bool, traceState string, ok bool) {
+	return m.transportContext.get()
+}
+
+// SetTransportTraceContext sets the trace context of the last hop.
+func (m *Message) SetTransportTraceContext(traceID [16]byte, spanID [8]byte, sampled bool, traceState *string) (ok bool) {
+	return m.transportContext.update(traceID, spanID, sampled, traceState)
+}
+
+// GetBaggage returns the W3C baggage of the message.
+func (m *Message) GetBaggage() (baggage string, ok bool) {
+	return m.baggage, m.baggage != ""
+}
+
+// SetBaggage sets the W3C baggage of the message.
+func (m *Message) SetBaggage(baggage string) error {
+	m.baggage = baggage
+	return nil
+}
@@ -0,0 +1,219 @@
+// Package flow paces publishing: a token bucket holds the publish rate to a
+// target, back-pressure from the publisher buffer either blocks or drops the
+// message instead of failing the publish loop, and the rate is lowered while
+// persistent publish acknowledgements lag behind.
+package flow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"solace.dev/go/messaging/pkg/solace"
+)
+
+// Policy decides what happens to a message the publisher has no room for.
+type Policy int
+
+const (
+	// Block retries the publish until the publisher buffer has room again.
+	Block Policy = iota
+	// Drop discards the message and counts it.
+	Drop
+)
+
+func (p Policy) String() string {
+	if p == Drop {
+		return "drop"
+	}
+	return "block"
+}
+
+const (
+	// adjustInterval is how often the rate is re-evaluated against the ack lag
+	adjustInterval = 250 * time.Millisecond
+	// maxBlockBackoff caps the wait between publish attempts while blocked
+	maxBlockBackoff = 100 * time.Millisecond
+)
+
+// Options configures a Controller.
+type Options struct {
+	// Rate is the target publish rate in messages per second, 0 publishes as fast as possible.
+	Rate float64
+	// Burst is the number of messages that may be published back to back, 1 by default.
+	Burst int
+	// Policy applies when the publisher rejects a message because its buffer is full.
+	Policy Policy
+	// Lag returns the number of persistent publishes waiting for an acknowledgement,
+	// e.g. retry.Publisher.Outstanding. Adaptive slow down is disabled when it is nil
+	// or Rate is 0.
+	Lag func() int
+	// MaxLag is the lag above which the rate is halved, 100 by default. The rate
+	// recovers towards Rate once the lag falls below half of MaxLag.
+	MaxLag int
+	// MinRate is the floor for the adaptive rate, 1 msg/s by default.
+	MinRate float64
+}
+
+// Stats is a snapshot of a Controller.
+type Stats struct {
+	Sent      uint64        // messages accepted by the publisher
+	Dropped   uint64        // messages dropped by the Drop policy
+	Overflows uint64        // publish attempts rejected because the buffer was full
+	Elapsed   time.Duration // time since the first publish
+	Achieved  float64       // Sent per second over Elapsed
+	Limit     float64       // current rate limit, 0 when unlimited
+}
+
+func (s Stats) String() string {
+	limit := "unlimited"
+	if s.Limit > 0 {
+		limit = fmt.Sprintf("%.1f msg/s", s.Limit)
+	}
+	return fmt.Sprintf("sent=%d dropped=%d overflows=%d achieved=%.1f msg/s limit=%s",
+		s.Sent, s.Dropped, s.Overflows, s.Achieved, limit)
+}
+
+// Controller paces calls to a publish function. It is safe for concurrent use.
+type Controller struct {
+	opts    Options
+	limiter *Limiter
+
+	sent, dropped, overflows atomic.Uint64
+
+	mu         sync.Mutex
+	started    time.Time
+	lastAdjust time.Time
+}
+
+// New creates a Controller.
+func New(opts Options) *Controller {
+	if opts.MaxLag <= 0 {
+		opts.MaxLag = 100
+	}
+	if opts.MinRate <= 0 {
+		opts.MinRate = 1
+	}
+	return &Controller{opts: opts, limiter: NewLimiter(opts.Rate, opts.Burst)}
+}
+
+// Publish waits for the rate limit and calls publish. A back-pressure error from
+// publish (solace.PublisherOverflowError) is retried or dropped according to
+// the policy, any other error is returned. The error is ctx.Err() when ctx ends
+// first.
+func (c *Controller) Publish(ctx context.Context, publish func() error) error {
+	c.markStart()
+	c.adjust()
+	if err := c.limiter.Wait(ctx); err != nil {
+		return err
+	}
+
+	backoff := time.Millisecond
+	for {
+		err := publish()
+		var overflow *solace.PublisherOverflowError
+		switch {
+		case err == nil:
+			c.sent.Add(1)
+			return nil
+		case !errors.As(err, &overflow):
+			return err
+		}
+		c.overflows.Add(1)
+		if c.opts.Policy == Drop {
+			c.dropped.Add(1)
+			return nil
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+		if backoff *= 2; backoff > maxBlockBackoff {
+			backoff = maxBlockBackoff
+		}
+	}
+}
+
+// Stats returns the current counters and the achieved rate.
+func (c *Controller) Stats() Stats {
+	c.mu.Lock()
+	started := c.started
+	c.mu.Unlock()
+
+	s := Stats{
+		Sent:      c.sent.Load(),
+		Dropped:   c.dropped.Load(),
+		Overflows: c.overflows.Load(),
+		Limit:     c.limiter.Rate(),
+	}
+	if !started.IsZero() {
+		s.Elapsed = time.Since(started)
+		if secs := s.Elapsed.Seconds(); secs > 0 {
+			s.Achieved = float64(s.Sent) / secs
+		}
+	}
+	return s
+}
+
+// Report calls fn with the stats every interval until ctx is done.
+func (c *Controller) Report(ctx context.Context, interval time.Duration, fn func(Stats)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			fn(c.Stats())
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (c *Controller) markStart() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.started.IsZero() {
+		c.started = time.Now()
+	}
+}
+
+// adjust halves the rate while acks lag and recovers it by a tenth of the
+// target per interval once they catch up
+func (c *Controller) adjust() {
+	if c.opts.Lag == nil || c.opts.Rate <= 0 {
+		return
+	}
+	c.mu.Lock()
+	now := time.Now()
+	if now.Sub(c.lastAdjust) < adjustInterval {
+		c.mu.Unlock()
+		return
+	}
+	c.lastAdjust = now
+	c.mu.Unlock()
+
+	lag := c.opts.Lag()
+	rate := c.limiter.Rate()
+	switch {
+	case lag > c.opts.MaxLag:
+		rate /= 2
+		if rate < c.opts.MinRate {
+			rate = c.opts.MinRate
+		}
+	case lag < c.opts.MaxLag/2 && rate < c.opts.Rate:
+		rate += c.opts.Rate / 10
+		if rate > c.opts.Rate {
+			rate = c.opts.Rate
+		}
+	default:
+		return
+	}
+	c.limiter.SetRate(rate)
+}
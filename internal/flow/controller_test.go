@@ -0,0 +1,134 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"solace.dev/go/messaging/pkg/solace"
+)
+
+// overflowing returns a publish function that fails with a full buffer the first
+// overflows times and then with err
+func overflowing(overflows int, err error) (publish func() error, calls *int) {
+	calls = new(int)
+	return func() error {
+		*calls++
+		if *calls <= overflows {
+			return solace.NewError(&solace.PublisherOverflowError{}, "buffer full", nil)
+		}
+		return err
+	}, calls
+}
+
+func TestPublishPolicy(t *testing.T) {
+	errNotReady := errors.New("not ready")
+	tests := []struct {
+		name      string
+		policy    Policy
+		overflows int
+		err       error
+		wantErr   error
+		wantCalls int
+		want      Stats
+	}{
+		{name: "sent", policy: Block, wantCalls: 1, want: Stats{Sent: 1}},
+		{name: "blocked until there is room", policy: Block, overflows: 3, wantCalls: 4, want: Stats{Sent: 1, Overflows: 3}},
+		{name: "dropped", policy: Drop, overflows: 3, wantCalls: 1, want: Stats{Dropped: 1, Overflows: 1}},
+		{name: "other errors returned", policy: Block, err: errNotReady, wantErr: errNotReady, wantCalls: 1},
+		{name: "other errors returned after blocking", policy: Block, overflows: 1, err: errNotReady, wantErr: errNotReady, wantCalls: 2, want: Stats{Overflows: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := New(Options{Policy: tt.policy})
+			publish, calls := overflowing(tt.overflows, tt.err)
+			if err := c.Publish(context.Background(), publish); !errors.Is(err, tt.wantErr) {
+				t.Errorf("error %v, want %v", err, tt.wantErr)
+			}
+			if *calls != tt.wantCalls {
+				t.Errorf("%d publish calls, want %d", *calls, tt.wantCalls)
+			}
+			got := c.Stats()
+			if got.Sent != tt.want.Sent || got.Dropped != tt.want.Dropped || got.Overflows != tt.want.Overflows {
+				t.Errorf("stats %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPublishBlockedUntilCancelled(t *testing.T) {
+	c := New(Options{Policy: Block})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	publish, calls := overflowing(1<<30, nil)
+	if err := c.Publish(ctx, publish); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error %v, want context.DeadlineExceeded", err)
+	}
+	if *calls < 2 || c.Stats().Sent != 0 {
+		t.Errorf("%d calls, stats %s", *calls, c.Stats())
+	}
+}
+
+func TestAdaptiveRate(t *testing.T) {
+	lag := 0
+	c := New(Options{Rate: 100, MaxLag: 10, MinRate: 20, Lag: func() int { return lag }})
+	// adjust runs at most once per interval, forget the last run
+	step := func(l int) float64 {
+		lag = l
+		c.mu.Lock()
+		c.lastAdjust = time.Time{}
+		c.mu.Unlock()
+		c.adjust()
+		return c.limiter.Rate()
+	}
+
+	steps := []struct {
+		lag  int
+		want float64
+	}{
+		{lag: 0, want: 100}, // at the target
+		{lag: 11, want: 50}, // halved above MaxLag
+		{lag: 11, want: 25}, // halved again
+		{lag: 50, want: 20}, // not below MinRate
+		{lag: 7, want: 20},  // between half and MaxLag, kept
+		{lag: 4, want: 30},  // recovers by a tenth of the target
+		{lag: 0, want: 40},
+		{lag: 10, want: 40}, // at MaxLag, kept
+		{lag: 0, want: 50},
+	}
+	for i, s := range steps {
+		if got := step(s.lag); got != s.want {
+			t.Fatalf("step %d with lag %d: rate %v, want %v", i, s.lag, got, s.want)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		step(0)
+	}
+	if got := c.limiter.Rate(); got != 100 {
+		t.Errorf("recovered to %v, want the target 100", got)
+	}
+
+	// within an interval the rate is not adjusted again
+	step(1000)
+	c.adjust()
+	if got := c.limiter.Rate(); got != 50 {
+		t.Errorf("rate %v after adjusting twice within an interval, want 50", got)
+	}
+	if got := c.Stats().Limit; got != 50 {
+		t.Errorf("Stats().Limit = %v", got)
+	}
+}
+
+func TestAdaptiveRateDisabled(t *testing.T) {
+	for _, opts := range []Options{
+		{Rate: 100},
+		{Rate: 0, Lag: func() int { return 1000 }},
+	} {
+		c := New(opts)
+		c.adjust()
+		if got := c.limiter.Rate(); got != opts.Rate {
+			t.Errorf("%+v: rate %v, want %v", opts, got, opts.Rate)
+		}
+	}
+}
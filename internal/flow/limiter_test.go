@@ -0,0 +1,84 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLimiterAllow(t *testing.T) {
+	tests := []struct {
+		name    string
+		rate    float64
+		burst   int
+		elapsed time.Duration // since the bucket was emptied
+		want    int           // tokens taken
+	}{
+		{name: "unlimited", rate: 0, burst: 1, want: 10},
+		{name: "burst of one", rate: 10, burst: 0, want: 0},
+		{name: "refilled", rate: 10, burst: 5, elapsed: 250 * time.Millisecond, want: 2},
+		{name: "refilled up to the burst", rate: 10, burst: 5, elapsed: 10 * time.Second, want: 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewLimiter(tt.rate, tt.burst)
+			if tt.rate > 0 {
+				// the bucket starts full
+				for i := 0; i < max(tt.burst, 1); i++ {
+					if !l.Allow() {
+						t.Fatalf("token %d of a full bucket refused", i)
+					}
+				}
+				l.mu.Lock()
+				l.last = l.last.Add(-tt.elapsed)
+				l.mu.Unlock()
+			}
+			got := 0
+			for got < 10 && l.Allow() {
+				got++
+			}
+			if got != tt.want {
+				t.Errorf("%d tokens taken, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLimiterWait(t *testing.T) {
+	l := NewLimiter(100, 1)
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		if err := l.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// one token from the full bucket, then five at 10ms each
+	if elapsed := time.Since(start); elapsed < 45*time.Millisecond {
+		t.Errorf("6 tokens at 100/s in %s", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.Wait(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("error %v on an empty bucket, want context.Canceled", err)
+	}
+	if err := NewLimiter(0, 1).Wait(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("error %v when unlimited, want context.Canceled", err)
+	}
+}
+
+func TestLimiterSetRate(t *testing.T) {
+	l := NewLimiter(1, 3)
+	l.Allow()
+	l.SetRate(1000)
+	if l.Rate() != 1000 {
+		t.Errorf("rate %v", l.Rate())
+	}
+	// the two tokens left are kept
+	for i := 0; i < 2; i++ {
+		if !l.Allow() {
+			t.Fatalf("token %d lost by SetRate", i)
+		}
+	}
+}
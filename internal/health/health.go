@@ -0,0 +1,248 @@
+// Package health serves the Kubernetes style /healthz and /readyz endpoints of
+// a sample as JSON with the status of every component.
+//
+// Readiness is derived from the messaging service being connected, the
+// publishers being ready and the receivers running. Liveness is derived from
+// Watchdogs around the message handlers, which catch handlers that are stuck.
+// A sample wires it in after connecting:
+//
+//	healthChecks, err := health.Enable()
+//	healthChecks.AddService("messaging-service", messagingService)
+//	healthChecks.AddReceiver("receiver", directReceiver)
+//	directReceiver.ReceiveAsync(healthChecks.Watchdog("handler", 30*time.Second).Handler(MessageHandler))
+//
+// The endpoints are served when the -health-addr flag or the
+// SOLACE_HEALTH_ADDR environment variable is set. Both answer 200 when every
+// component is ok and 503 otherwise.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// EnvAddr names the environment variable read by Enable when -health-addr is not given.
+const EnvAddr = "SOLACE_HEALTH_ADDR"
+
+// addrFlag is registered on flag.CommandLine so that bootstrap.Load parses it
+// together with the connection flags.
+var addrFlag = flag.String("health-addr", "", "address to serve /healthz and /readyz on, e.g. :8080, overrides "+EnvAddr)
+
+// Component statuses
+const (
+	StatusOK      = "ok"
+	StatusFailing = "failing"
+)
+
+// Component kinds
+const (
+	KindService   = "messaging-service"
+	KindPublisher = "publisher"
+	KindReceiver  = "receiver"
+	KindHandler   = "handler"
+	KindCheck     = "check"
+)
+
+// Component is the status of one part of the sample.
+type Component struct {
+	Name   string `json:"name"`
+	Kind   string `json:"kind"`
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Report is the body of /healthz and /readyz.
+type Report struct {
+	Status     string      `json:"status"`
+	Checked    time.Time   `json:"checked"`
+	Components []Component `json:"components"`
+}
+
+// OK tells whether every component is ok.
+func (r Report) OK() bool {
+	return r.Status == StatusOK
+}
+
+// check reports one component
+type check func(now time.Time) Component
+
+// Checker holds the components of the readiness and liveness checks.
+type Checker struct {
+	server *http.Server
+
+	mu        sync.Mutex
+	readiness []check
+	liveness  []check
+}
+
+// New creates a checker without components, both checks are ok.
+func New() *Checker {
+	return &Checker{}
+}
+
+// Enable creates a checker and serves its endpoints when an address is
+// configured. It must be called after bootstrap.Load has parsed the command line.
+func Enable() (*Checker, error) {
+	c := New()
+	addr := os.Getenv(EnvAddr)
+	if *addrFlag != "" {
+		addr = *addrFlag
+	}
+	if addr == "" {
+		return c, nil
+	}
+	if err := c.Serve(addr); err != nil {
+		return nil, err
+	}
+	fmt.Printf("Serving health checks on http://%s/healthz and /readyz\n", c.server.Addr)
+	return c, nil
+}
+
+// AddService makes readiness depend on service being connected.
+func (c *Checker) AddService(name string, service interface{ IsConnected() bool }) {
+	c.addReadiness(func(time.Time) Component {
+		if service.IsConnected() {
+			return Component{Name: name, Kind: KindService, Status: StatusOK, Detail: "connected"}
+		}
+		return Component{Name: name, Kind: KindService, Status: StatusFailing, Detail: "not connected"}
+	})
+}
+
+// AddPublisher makes readiness depend on publisher being ready to publish.
+func (c *Checker) AddPublisher(name string, publisher interface{ IsReady() bool }) {
+	c.addReadiness(func(time.Time) Component {
+		if publisher.IsReady() {
+			return Component{Name: name, Kind: KindPublisher, Status: StatusOK, Detail: "ready"}
+		}
+		return Component{Name: name, Kind: KindPublisher, Status: StatusFailing, Detail: "not ready"}
+	})
+}
+
+// AddReceiver makes readiness depend on receiver running.
+func (c *Checker) AddReceiver(name string, receiver interface{ IsRunning() bool }) {
+	c.addReadiness(func(time.Time) Component {
+		if receiver.IsRunning() {
+			return Component{Name: name, Kind: KindReceiver, Status: StatusOK, Detail: "running"}
+		}
+		return Component{Name: name, Kind: KindReceiver, Status: StatusFailing, Detail: "not running"}
+	})
+}
+
+// AddCheck makes readiness depend on fn returning nil, e.g. the Check method
+// of a connection state tracker.
+func (c *Checker) AddCheck(name string, fn func() error) {
+	c.addReadiness(func(time.Time) Component {
+		if err := fn(); err != nil {
+			return Component{Name: name, Kind: KindCheck, Status: StatusFailing, Detail: err.Error()}
+		}
+		return Component{Name: name, Kind: KindCheck, Status: StatusOK}
+	})
+}
+
+// Watchdog creates a watchdog that fails liveness when a handler call runs
+// longer than timeout.
+func (c *Checker) Watchdog(name string, timeout time.Duration) *Watchdog {
+	w := &Watchdog{name: name, timeout: timeout, inFlight: map[uint64]time.Time{}}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.liveness = append(c.liveness, w.component)
+	return w
+}
+
+func (c *Checker) addReadiness(fn check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readiness = append(c.readiness, fn)
+}
+
+// Readiness reports whether the sample can do its work.
+func (c *Checker) Readiness() Report {
+	c.mu.Lock()
+	checks := append([]check(nil), c.readiness...)
+	c.mu.Unlock()
+	return report(checks)
+}
+
+// Liveness reports whether the sample is stuck and should be restarted.
+func (c *Checker) Liveness() Report {
+	c.mu.Lock()
+	checks := append([]check(nil), c.liveness...)
+	c.mu.Unlock()
+	return report(checks)
+}
+
+// report runs checks
+func report(checks []check) Report {
+	now := time.Now()
+	r := Report{Status: StatusOK, Checked: now, Components: []Component{}}
+	for _, check := range checks {
+		component := check(now)
+		if component.Status != StatusOK {
+			r.Status = StatusFailing
+		}
+		r.Components = append(r.Components, component)
+	}
+	return r
+}
+
+// Handler returns the handler serving /healthz and /readyz.
+func (c *Checker) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeReport(w, c.Liveness())
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		writeReport(w, c.Readiness())
+	})
+	return mux
+}
+
+func writeReport(w http.ResponseWriter, r Report) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if !r.OK() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(r)
+}
+
+// Serve listens on addr and serves the endpoints in the background. The
+// listener is opened before Serve returns, so an address in use is reported here.
+func (c *Checker) Serve(addr string) error {
+	if c.server != nil {
+		return errors.New("health checks are already served on " + c.server.Addr)
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("serving health checks: %w", err)
+	}
+	c.server = &http.Server{Addr: listener.Addr().String(), Handler: c.Handler(), ReadHeaderTimeout: 5 * time.Second}
+	go c.server.Serve(listener)
+	return nil
+}
+
+// Addr returns the address the endpoints are served on, empty when nothing is served.
+func (c *Checker) Addr() string {
+	if c.server == nil {
+		return ""
+	}
+	return c.server.Addr
+}
+
+// Close stops serving the endpoints, it does nothing when nothing is served.
+func (c *Checker) Close() error {
+	if c.server == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	return c.server.Shutdown(ctx)
+}
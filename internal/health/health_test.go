@@ -0,0 +1,101 @@
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"SolaceSamples.com/PubSub+Go/internal/fake"
+)
+
+// get serves path from handler and decodes the report
+func get(t *testing.T, handler http.Handler, path string) (int, Report) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	if contentType := rec.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("%s content type %q", path, contentType)
+	}
+	var report Report
+	if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+		t.Fatalf("%s body: %v", path, err)
+	}
+	return rec.Code, report
+}
+
+func TestReadiness(t *testing.T) {
+	service := fake.New()
+	receiver, _ := service.CreateDirectMessageReceiverBuilder().Build()
+	publisher, _ := service.CreateDirectMessagePublisherBuilder().Build()
+	var stateErr error
+
+	c := New()
+	c.AddService("messaging-service", service)
+	c.AddReceiver("direct-receiver", receiver)
+	c.AddPublisher("direct-publisher", publisher)
+	c.AddCheck("connection-state", func() error { return stateErr })
+
+	code, report := get(t, c.Handler(), "/readyz")
+	if code != http.StatusServiceUnavailable || report.Status != StatusFailing || len(report.Components) != 4 {
+		t.Fatalf("before connecting: %d %+v", code, report)
+	}
+	want := map[string]string{
+		"messaging-service": "not connected",
+		"direct-receiver":   "not running",
+		"direct-publisher":  "not ready",
+		"connection-state":  "",
+	}
+	for _, component := range report.Components {
+		if component.Detail != want[component.Name] {
+			t.Errorf("component %+v, want detail %q", component, want[component.Name])
+		}
+	}
+
+	service.Connect()
+	receiver.Start()
+	publisher.Start()
+	if code, report := get(t, c.Handler(), "/readyz"); code != http.StatusOK || !report.OK() {
+		t.Errorf("connected and started: %d %+v", code, report)
+	}
+
+	stateErr = errors.New("reconnecting for 10s")
+	code, report = get(t, c.Handler(), "/readyz")
+	if code != http.StatusServiceUnavailable || report.Components[3] != (Component{Name: "connection-state", Kind: KindCheck, Status: StatusFailing, Detail: "reconnecting for 10s"}) {
+		t.Errorf("failing check: %d %+v", code, report)
+	}
+
+	// liveness does not depend on the readiness components
+	if code, report := get(t, c.Handler(), "/healthz"); code != http.StatusOK || len(report.Components) != 0 {
+		t.Errorf("liveness: %d %+v", code, report)
+	}
+}
+
+func TestServe(t *testing.T) {
+	c := New()
+	if c.Addr() != "" || c.Close() != nil {
+		t.Fatal("a checker that is not served has an address or fails to close")
+	}
+	if err := c.Serve("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.Serve("127.0.0.1:0"); err == nil {
+		t.Error("served twice")
+	}
+	resp, err := http.Get("http://" + c.Addr() + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Cache-Control") != "no-store" {
+		t.Errorf("status %d, cache control %q", resp.StatusCode, resp.Header.Get("Cache-Control"))
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := http.Get("http://" + c.Addr() + "/healthz"); err == nil {
+		t.Error("still served after Close")
+	}
+}
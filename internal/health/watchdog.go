@@ -0,0 +1,81 @@
+package health
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"solace.dev/go/messaging/pkg/solace"
+	"solace.dev/go/messaging/pkg/solace/message"
+)
+
+// Watchdog catches a handler that is stuck. A handler call that runs longer
+// than the timeout fails the liveness check, and so does a loop that calls
+// Beat and stopped calling it for longer than the timeout. An idle handler is
+// alive.
+type Watchdog struct {
+	name    string
+	timeout time.Duration
+
+	mu       sync.Mutex
+	next     uint64
+	inFlight map[uint64]time.Time
+	handled  uint64
+	beating  bool
+	lastBeat time.Time
+}
+
+// Begin marks the start of a handler call, the returned function its end.
+func (w *Watchdog) Begin() (end func()) {
+	w.mu.Lock()
+	id := w.next
+	w.next++
+	w.inFlight[id] = time.Now()
+	w.mu.Unlock()
+	return func() {
+		w.mu.Lock()
+		delete(w.inFlight, id)
+		w.handled++
+		w.mu.Unlock()
+	}
+}
+
+// Beat marks progress of a loop, which from then on has to beat at least
+// once per timeout.
+func (w *Watchdog) Beat() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.beating, w.lastBeat = true, time.Now()
+}
+
+// Handler wraps a direct or persistent message handler with Begin and end.
+func (w *Watchdog) Handler(handler solace.MessageHandler) solace.MessageHandler {
+	return func(msg message.InboundMessage) {
+		defer w.Begin()()
+		handler(msg)
+	}
+}
+
+// component reports the watchdog as of now
+func (w *Watchdog) component(now time.Time) Component {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	c := Component{Name: w.name, Kind: KindHandler, Status: StatusOK}
+	var oldest time.Time
+	for _, started := range w.inFlight {
+		if oldest.IsZero() || started.Before(oldest) {
+			oldest = started
+		}
+	}
+	switch {
+	case !oldest.IsZero() && now.Sub(oldest) > w.timeout:
+		c.Status = StatusFailing
+		c.Detail = fmt.Sprintf("handler call running for %s, longer than %s", now.Sub(oldest).Round(time.Millisecond), w.timeout)
+	case w.beating && now.Sub(w.lastBeat) > w.timeout:
+		c.Status = StatusFailing
+		c.Detail = fmt.Sprintf("no heartbeat for %s, longer than %s", now.Sub(w.lastBeat).Round(time.Millisecond), w.timeout)
+	default:
+		c.Detail = fmt.Sprintf("%d handled, %d in flight", w.handled, len(w.inFlight))
+	}
+	return c
+}
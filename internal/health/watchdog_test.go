@@ -0,0 +1,69 @@
+package health
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"solace.dev/go/messaging/pkg/solace/message"
+
+	"SolaceSamples.com/PubSub+Go/internal/fake"
+)
+
+func TestWatchdogTimeout(t *testing.T) {
+	c := New()
+	w := c.Watchdog("handlers", time.Minute)
+
+	// an idle handler is alive
+	if component := w.component(time.Now().Add(time.Hour)); component.Status != StatusOK {
+		t.Errorf("idle: %+v", component)
+	}
+
+	handled := make(chan struct{})
+	release := make(chan struct{})
+	handler := w.Handler(func(message.InboundMessage) {
+		close(handled)
+		<-release
+	})
+	go handler(fake.NewInboundMessage("a/b", "Hello", nil))
+	<-handled
+
+	if component := w.component(time.Now()); component.Status != StatusOK || component.Detail != "0 handled, 1 in flight" {
+		t.Errorf("within the timeout: %+v", component)
+	}
+	component := w.component(time.Now().Add(2 * time.Minute))
+	if component.Status != StatusFailing || !strings.Contains(component.Detail, "longer than 1m0s") {
+		t.Errorf("past the timeout: %+v", component)
+	}
+
+	close(release)
+	deadline := time.Now().Add(time.Second)
+	for w.component(time.Now()).Detail != "1 handled, 0 in flight" {
+		if time.Now().After(deadline) {
+			t.Fatalf("handler call not ended: %+v", w.component(time.Now()))
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if code, report := get(t, c.Handler(), "/healthz"); code != http.StatusOK || report.Components[0].Name != "handlers" {
+		t.Errorf("after the call: %d %+v", code, report)
+	}
+}
+
+func TestWatchdogHeartbeat(t *testing.T) {
+	c := New()
+	w := c.Watchdog("publish-loop", 20*time.Millisecond)
+	w.Beat()
+	if code, report := get(t, c.Handler(), "/healthz"); code != http.StatusOK {
+		t.Errorf("after a beat: %d %+v", code, report)
+	}
+	time.Sleep(40 * time.Millisecond)
+	code, report := get(t, c.Handler(), "/healthz")
+	if code != http.StatusServiceUnavailable || !strings.HasPrefix(report.Components[0].Detail, "no heartbeat for") {
+		t.Errorf("without beats: %d %+v", code, report)
+	}
+	w.Beat()
+	if code, _ := get(t, c.Handler(), "/healthz"); code != http.StatusOK {
+		t.Errorf("beating again: %d", code)
+	}
+}
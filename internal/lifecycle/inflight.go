@@ -0,0 +1,65 @@
+package lifecycle
+
+import (
+	"context"
+	"sync"
+)
+
+// InFlight counts work that has begun and not ended yet, e.g. publishes waiting
+// for their receipt, so that it can be waited for before shutting down. The
+// zero value is ready to use.
+type InFlight struct {
+	mu    sync.Mutex
+	count int
+	idle  chan struct{}
+}
+
+// Begin counts one more piece of work.
+func (f *InFlight) Begin() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.count == 0 {
+		f.idle = make(chan struct{})
+	}
+	f.count++
+}
+
+// End marks a piece of work counted by Begin as done. Calls without a
+// matching Begin are ignored.
+func (f *InFlight) End() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.count == 0 {
+		return
+	}
+	f.count--
+	if f.count == 0 {
+		close(f.idle)
+	}
+}
+
+// Count returns the work that has not ended yet.
+func (f *InFlight) Count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.count
+}
+
+// Wait waits until all work has ended and returns ctx.Err() when ctx is done
+// first.
+func (f *InFlight) Wait(ctx context.Context) error {
+	f.mu.Lock()
+	if f.count == 0 {
+		f.mu.Unlock()
+		return nil
+	}
+	idle := f.idle
+	f.mu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
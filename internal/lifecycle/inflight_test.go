@@ -0,0 +1,46 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestInFlight(t *testing.T) {
+	var f InFlight
+	if err := f.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait() = %v with nothing in flight", err)
+	}
+
+	f.Begin()
+	f.Begin()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := f.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait() = %v, want a deadline error", err)
+	}
+
+	waited := make(chan error, 1)
+	go func() { waited <- f.Wait(context.Background()) }()
+	f.End()
+	if f.Count() != 1 {
+		t.Errorf("Count() = %d, want 1", f.Count())
+	}
+	f.End()
+	select {
+	case err := <-waited:
+		if err != nil {
+			t.Errorf("Wait() = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Wait() still blocked after the last End")
+	}
+
+	// an End without Begin does not go negative
+	f.End()
+	f.Begin()
+	if f.Count() != 1 {
+		t.Errorf("Count() = %d after an unmatched End, want 1", f.Count())
+	}
+}
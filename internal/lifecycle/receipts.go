@@ -0,0 +1,73 @@
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"solace.dev/go/messaging/pkg/solace"
+	"solace.dev/go/messaging/pkg/solace/config"
+	"solace.dev/go/messaging/pkg/solace/message"
+	"solace.dev/go/messaging/pkg/solace/resource"
+)
+
+// Receipts counts persistent publishes whose publish receipt has not arrived
+// yet. Register it with Runner.Drain so the publisher is only terminated once
+// the broker has acknowledged (or rejected) everything that was sent.
+type Receipts struct {
+	publisher solace.PersistentMessagePublisher
+
+	outstanding InFlight
+	failed      atomic.Uint64
+}
+
+// TrackReceipts installs a receipt listener on publisher that calls listener
+// (which may be nil) and then marks the publish as complete. It must be called
+// instead of SetMessagePublishReceiptListener, and messages must be published
+// through Receipts.Publish to be counted.
+func TrackReceipts(publisher solace.PersistentMessagePublisher, listener solace.MessagePublishReceiptListener) *Receipts {
+	t := &Receipts{publisher: publisher}
+	publisher.SetMessagePublishReceiptListener(func(receipt solace.PublishReceipt) {
+		if listener != nil {
+			listener(receipt)
+		}
+		t.complete(receipt.GetError() != nil)
+	})
+	return t
+}
+
+// Publish publishes msg through the tracked publisher and counts it as in flight.
+func (t *Receipts) Publish(msg message.OutboundMessage, destination *resource.Topic, properties config.MessagePropertiesConfigurationProvider, userContext interface{}) error {
+	t.outstanding.Begin()
+	if err := t.publisher.Publish(msg, destination, properties, userContext); err != nil {
+		// no receipt will follow a rejected publish call
+		t.complete(false)
+		return err
+	}
+	return nil
+}
+
+func (t *Receipts) complete(failed bool) {
+	if failed {
+		t.failed.Add(1)
+	}
+	t.outstanding.End()
+}
+
+// Outstanding returns the number of publishes still waiting for a receipt.
+func (t *Receipts) Outstanding() int {
+	return t.outstanding.Count()
+}
+
+// Failed returns the number of receipts that carried an error.
+func (t *Receipts) Failed() uint64 {
+	return t.failed.Load()
+}
+
+// Drain waits until every tracked publish has its receipt or ctx is done.
+func (t *Receipts) Drain(ctx context.Context) error {
+	if err := t.outstanding.Wait(ctx); err != nil {
+		return fmt.Errorf("%d publish receipts outstanding: %w", t.Outstanding(), err)
+	}
+	return nil
+}
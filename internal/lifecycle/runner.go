@@ -0,0 +1,268 @@
+// Package lifecycle runs a sample until its context is cancelled or the process
+// receives SIGINT/SIGTERM, then shuts it down in a fixed order:
+//
+//  1. the worker goroutines started with Go are cancelled and awaited,
+//  2. the registered drainers (e.g. outstanding publish receipts) are awaited,
+//  3. the managed publishers and receivers are terminated, last registered first,
+//  4. the messaging service is disconnected.
+//
+// Run returns an exit code that tells whether that shutdown was clean.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"solace.dev/go/messaging/pkg/solace"
+)
+
+// Exit codes returned by Run.
+const (
+	// ExitClean means every step of the shutdown completed in time.
+	ExitClean = 0
+	// ExitFailure means a worker returned an error, which triggered the shutdown.
+	ExitFailure = 1
+	// ExitUnclean means the shutdown ran out of time or a terminate/disconnect failed,
+	// so messages may have been lost.
+	ExitUnclean = 2
+	// ExitForced is used when a second signal aborts the shutdown.
+	ExitForced = 130
+)
+
+// DefaultGracePeriod bounds each shutdown step unless WithGracePeriod is used.
+const DefaultGracePeriod = 5 * time.Second
+
+// Terminator is the part of solace.LifecycleControl the runner needs.
+type Terminator interface {
+	Terminate(gracePeriod time.Duration) error
+}
+
+// TerminateFunc adapts a function, e.g. the Stop of a running pipeline, to a Terminator.
+type TerminateFunc func(gracePeriod time.Duration) error
+
+// Terminate calls f.
+func (f TerminateFunc) Terminate(gracePeriod time.Duration) error {
+	return f(gracePeriod)
+}
+
+// Drainer waits for in-flight work to complete or ctx to expire.
+type Drainer interface {
+	Drain(ctx context.Context) error
+}
+
+// Option configures a Runner.
+type Option func(*Runner)
+
+// WithGracePeriod sets how long each shutdown step may take.
+func WithGracePeriod(gracePeriod time.Duration) Option {
+	return func(r *Runner) {
+		r.gracePeriod = gracePeriod
+	}
+}
+
+// WithSignals replaces the signals that trigger a shutdown, SIGINT and SIGTERM by default.
+func WithSignals(signals ...os.Signal) Option {
+	return func(r *Runner) {
+		r.signals = signals
+	}
+}
+
+// WithOutput sets where shutdown progress is printed, os.Stdout by default.
+func WithOutput(w io.Writer) Option {
+	return func(r *Runner) {
+		r.out = w
+	}
+}
+
+// Runner owns the shutdown of a messaging service and everything built on it.
+type Runner struct {
+	service     solace.MessagingService
+	gracePeriod time.Duration
+	signals     []os.Signal
+	out         io.Writer
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu        sync.Mutex
+	managed   []Terminator
+	drainers  []Drainer
+	workerErr error
+}
+
+// NewRunner creates a runner for service. service may be nil when there is
+// nothing to disconnect.
+func NewRunner(service solace.MessagingService, opts ...Option) *Runner {
+	r := &Runner{
+		service:     service,
+		gracePeriod: DefaultGracePeriod,
+		signals:     []os.Signal{os.Interrupt, syscall.SIGTERM},
+		out:         os.Stdout,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+	return r
+}
+
+// Manage registers a publisher or receiver to be terminated on shutdown.
+// Components are terminated in the reverse order they were registered.
+func (r *Runner) Manage(component Terminator) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.managed = append(r.managed, component)
+}
+
+// Drain registers a drainer that is awaited after the workers stopped and
+// before any component is terminated.
+func (r *Runner) Drain(drainer Drainer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.drainers = append(r.drainers, drainer)
+}
+
+// Go runs fn on its own goroutine. The context passed to fn is cancelled when
+// the shutdown starts and fn is expected to return promptly. A non-nil error
+// other than context.Canceled starts the shutdown and makes Run return ExitFailure.
+func (r *Runner) Go(fn func(ctx context.Context) error) {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		if err := fn(r.ctx); err != nil && !errors.Is(err, context.Canceled) {
+			r.mu.Lock()
+			if r.workerErr == nil {
+				r.workerErr = err
+			}
+			r.mu.Unlock()
+			r.cancel()
+		}
+	}()
+}
+
+// Context returns the context handed to the workers.
+func (r *Runner) Context() context.Context {
+	return r.ctx
+}
+
+// Run blocks until ctx is done, a signal arrives or a worker fails, then
+// shuts everything down and returns the exit code.
+func (r *Runner) Run(ctx context.Context) int {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, r.signals...)
+	defer signal.Stop(signals)
+	return r.run(ctx, signals)
+}
+
+// exit is replaced by the tests to observe a forced exit
+var exit = os.Exit
+
+// run is Run with the signals received on signals
+func (r *Runner) run(ctx context.Context, signals <-chan os.Signal) int {
+	select {
+	case sig := <-signals:
+		fmt.Fprintf(r.out, "\nReceived %s, shutting down\n", sig)
+	case <-ctx.Done():
+		fmt.Fprintf(r.out, "\nContext done (%v), shutting down\n", ctx.Err())
+	case <-r.ctx.Done():
+		fmt.Fprintln(r.out, "\nWorker failed, shutting down")
+	}
+
+	// a second signal gives up on the graceful shutdown
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case sig := <-signals:
+			fmt.Fprintf(r.out, "Received %s again, exiting without cleanup\n", sig)
+			exit(ExitForced)
+		case <-done:
+		}
+	}()
+
+	return r.shutdown()
+}
+
+func (r *Runner) shutdown() int {
+	clean := true
+	r.cancel()
+
+	if !r.waitWorkers() {
+		fmt.Fprintf(r.out, "Workers did not stop within %s\n", r.gracePeriod)
+		clean = false
+	}
+
+	r.mu.Lock()
+	drainers := append([]Drainer(nil), r.drainers...)
+	managed := append([]Terminator(nil), r.managed...)
+	workerErr := r.workerErr
+	r.mu.Unlock()
+
+	for _, drainer := range drainers {
+		ctx, cancel := context.WithTimeout(context.Background(), r.gracePeriod)
+		if err := drainer.Drain(ctx); err != nil {
+			fmt.Fprintln(r.out, "Drain incomplete:", err)
+			clean = false
+		}
+		cancel()
+	}
+
+	for i := len(managed) - 1; i >= 0; i-- {
+		if err := managed[i].Terminate(r.gracePeriod); err != nil {
+			fmt.Fprintln(r.out, "Terminate failed:", err)
+			clean = false
+		}
+	}
+
+	if r.service != nil {
+		if err := r.service.Disconnect(); err != nil {
+			fmt.Fprintln(r.out, "Disconnect failed:", err)
+			clean = false
+		}
+	}
+
+	switch {
+	case workerErr != nil:
+		fmt.Fprintln(r.out, "Worker error:", workerErr)
+		return ExitFailure
+	case !clean:
+		return ExitUnclean
+	}
+	return ExitClean
+}
+
+// waitWorkers waits up to the grace period for the Go workers to return
+func (r *Runner) waitWorkers() bool {
+	finished := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return true
+	case <-time.After(r.gracePeriod):
+		return false
+	}
+}
+
+// Sleep pauses for d and reports whether ctx is still live afterwards, so
+// publish loops can use it in place of time.Sleep.
+func Sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
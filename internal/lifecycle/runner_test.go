@@ -0,0 +1,152 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"SolaceSamples.com/PubSub+Go/internal/fake"
+)
+
+// component records the order it was terminated in
+type component struct {
+	name  string
+	order *[]string
+	mu    *sync.Mutex
+	err   error
+}
+
+func (c component) Terminate(time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	*c.order = append(*c.order, c.name)
+	return c.err
+}
+
+// drainer returns err, or blocks until ctx is done when block is set
+type drainer struct {
+	err   error
+	block bool
+}
+
+func (d drainer) Drain(ctx context.Context) error {
+	if d.block {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return d.err
+}
+
+func TestRunExitCodes(t *testing.T) {
+	tests := []struct {
+		name        string
+		worker      func(ctx context.Context) error
+		drainer     drainer
+		terminate   error
+		interrupted bool
+		want        int
+	}{
+		{name: "context done", want: ExitClean},
+		{name: "signal", interrupted: true, want: ExitClean},
+		{name: "worker returns on cancel", worker: func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}, want: ExitClean},
+		{name: "worker fails", worker: func(ctx context.Context) error {
+			return errors.New("publish failed")
+		}, want: ExitFailure},
+		{name: "worker does not stop", worker: func(ctx context.Context) error {
+			time.Sleep(time.Second)
+			return nil
+		}, want: ExitUnclean},
+		{name: "drain incomplete", drainer: drainer{err: errors.New("3 receipts outstanding")}, want: ExitUnclean},
+		{name: "drain times out", drainer: drainer{block: true}, want: ExitUnclean},
+		{name: "terminate fails", terminate: errors.New("terminate failed"), want: ExitUnclean},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := fake.New()
+			if err := service.Connect(); err != nil {
+				t.Fatal(err)
+			}
+			var mu sync.Mutex
+			var order []string
+			r := NewRunner(service, WithGracePeriod(50*time.Millisecond), WithOutput(io.Discard))
+			r.Manage(component{name: "publisher", order: &order, mu: &mu, err: tt.terminate})
+			r.Manage(component{name: "receiver", order: &order, mu: &mu})
+			r.Drain(tt.drainer)
+			if tt.worker != nil {
+				r.Go(tt.worker)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			signals := make(chan os.Signal, 1)
+			if tt.interrupted {
+				signals <- os.Interrupt
+			} else if tt.want != ExitFailure {
+				cancel()
+			}
+			defer cancel()
+
+			if got := r.run(ctx, signals); got != tt.want {
+				t.Errorf("exit code %d, want %d", got, tt.want)
+			}
+			if strings.Join(order, ",") != "receiver,publisher" {
+				t.Errorf("terminated %v, want the last registered first", order)
+			}
+			if service.IsConnected() {
+				t.Error("service still connected")
+			}
+			if r.Context().Err() == nil {
+				t.Error("worker context not cancelled")
+			}
+		})
+	}
+}
+
+func TestRunSecondSignal(t *testing.T) {
+	defer func() { exit = os.Exit }()
+	exited := make(chan int, 1)
+	exit = func(code int) { exited <- code }
+
+	r := NewRunner(nil, WithGracePeriod(time.Second), WithOutput(io.Discard))
+	r.Drain(drainer{block: true})
+	signals := make(chan os.Signal, 2)
+	signals <- os.Interrupt
+	result := make(chan int, 1)
+	go func() { result <- r.run(context.Background(), signals) }()
+
+	// the shutdown is blocked on the drainer until the second signal
+	select {
+	case code := <-exited:
+		t.Fatalf("exited with %d on the first signal", code)
+	case <-time.After(50 * time.Millisecond):
+	}
+	signals <- os.Interrupt
+	select {
+	case code := <-exited:
+		if code != ExitForced {
+			t.Errorf("exit code %d, want %d", code, ExitForced)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("second signal did not exit")
+	}
+	<-result
+}
+
+func TestSleep(t *testing.T) {
+	if !Sleep(context.Background(), time.Millisecond) {
+		t.Error("Sleep interrupted without cancellation")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+	if Sleep(ctx, time.Minute) || time.Since(start) > time.Second {
+		t.Error("Sleep not interrupted by the cancelled context")
+	}
+}
@@ -0,0 +1,228 @@
+// Package metrics exposes what the samples do as Prometheus metrics on an
+// optional HTTP /metrics endpoint.
+//
+// A sample wires it in with a single call after connecting:
+//
+//	sampleMetrics, err := metrics.Enable(messagingService)
+//
+// which counts reconnection events and, when the -metrics-addr flag or the
+// SOLACE_METRICS_ADDR environment variable is set, serves the metrics on that
+// address. Publishers and receivers are counted once they are passed through the
+// matching wrapper, e.g. sampleMetrics.DirectPublisher(directPublisher); the
+// wrappers implement the same interfaces, so the rest of the sample is unchanged.
+package metrics
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"solace.dev/go/messaging/pkg/solace"
+)
+
+// EnvAddr names the environment variable read by Enable when -metrics-addr is not given.
+const EnvAddr = "SOLACE_METRICS_ADDR"
+
+// Namespace prefixes every metric name.
+const Namespace = "solace"
+
+// addrFlag is registered on flag.CommandLine so that bootstrap.Load parses it
+// together with the connection flags.
+var addrFlag = flag.String("metrics-addr", "", "address to serve Prometheus metrics on, e.g. :9090, overrides "+EnvAddr)
+
+// Message kinds used for the kind label
+const (
+	KindDirect     = "direct"
+	KindPersistent = "persistent"
+	KindRequest    = "request"
+	KindReply      = "reply"
+)
+
+// Option configures Metrics.
+type Option func(*Metrics)
+
+// WithTopicLabel replaces the function that turns a topic into the value of the
+// topic label. The default replaces numeric topic levels with "*", so that
+// samples publishing on one topic per sequence number do not create a series
+// per message.
+func WithTopicLabel(fn func(topic string) string) Option {
+	return func(m *Metrics) {
+		m.topicLabel = fn
+	}
+}
+
+// Metrics holds the collectors shared by the wrappers.
+type Metrics struct {
+	registry   *prometheus.Registry
+	topicLabel func(topic string) string
+	server     *http.Server
+
+	published     *prometheus.CounterVec
+	publishErrors *prometheus.CounterVec
+	received      *prometheus.CounterVec
+	redelivered   *prometheus.CounterVec
+	receipts      *prometheus.CounterVec
+	settlements   *prometheus.CounterVec
+	connection    *prometheus.CounterVec
+	roundTrip     *prometheus.HistogramVec
+}
+
+// New creates the collectors on a registry of their own, together with the Go
+// runtime and process collectors.
+func New(opts ...Option) *Metrics {
+	m := &Metrics{
+		registry:   prometheus.NewRegistry(),
+		topicLabel: NumericLevelsAsWildcard,
+		published: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "messages_published_total",
+			Help:      "Messages handed to a publisher without an error.",
+		}, []string{"kind", "topic"}),
+		publishErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "publish_errors_total",
+			Help:      "Publish calls that returned an error.",
+		}, []string{"kind", "topic"}),
+		received: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "messages_received_total",
+			Help:      "Messages delivered to a receiver.",
+		}, []string{"kind", "topic"}),
+		redelivered: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "messages_redelivered_total",
+			Help:      "Persistent messages flagged as redelivered by the broker.",
+		}, []string{"topic"}),
+		receipts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "publish_receipts_total",
+			Help:      "Persistent publish receipts by result, persisted or failed.",
+		}, []string{"result"}),
+		settlements: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "settlements_total",
+			Help:      "Persistent messages acknowledged or settled, by outcome and result.",
+		}, []string{"outcome", "result"}),
+		connection: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "connection_events_total",
+			Help:      "Messaging service events: reconnecting, reconnected and interrupted.",
+		}, []string{"event"}),
+		roundTrip: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Name:      "request_reply_round_trip_seconds",
+			Help:      "Time from publishing a request to its reply, timeout or error.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"topic", "result"}),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.published, m.publishErrors, m.received, m.redelivered, m.receipts, m.settlements, m.connection, m.roundTrip,
+	)
+	return m
+}
+
+// Enable creates the metrics, counts the reconnection events of service and
+// serves /metrics when an address is configured. It must be called after
+// bootstrap.Load has parsed the command line.
+func Enable(service solace.MessagingService, opts ...Option) (*Metrics, error) {
+	m := New(opts...)
+	m.Instrument(service)
+
+	addr := os.Getenv(EnvAddr)
+	if *addrFlag != "" {
+		addr = *addrFlag
+	}
+	if addr == "" {
+		return m, nil
+	}
+	if err := m.Serve(addr); err != nil {
+		return nil, err
+	}
+	fmt.Printf("Serving metrics on http://%s/metrics\n", m.server.Addr)
+	return m, nil
+}
+
+// Instrument counts the reconnection attempt, reconnection and service
+// interruption events of service.
+func (m *Metrics) Instrument(service solace.MessagingService) {
+	service.AddReconnectionAttemptListener(func(solace.ServiceEvent) {
+		m.connection.WithLabelValues("reconnecting").Inc()
+	})
+	service.AddReconnectionListener(func(solace.ServiceEvent) {
+		m.connection.WithLabelValues("reconnected").Inc()
+	})
+	service.AddServiceInterruptionListener(func(solace.ServiceEvent) {
+		m.connection.WithLabelValues("interrupted").Inc()
+	})
+}
+
+// Registry returns the registry holding the collectors, e.g. to add metrics of
+// the sample itself.
+func (m *Metrics) Registry() *prometheus.Registry {
+	return m.registry
+}
+
+// Handler returns the handler serving the registry in the Prometheus text format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Serve listens on addr and serves /metrics in the background. The listener is
+// opened before Serve returns, so an address in use is reported here.
+func (m *Metrics) Serve(addr string) error {
+	if m.server != nil {
+		return errors.New("metrics are already served on " + m.server.Addr)
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("serving metrics: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m.Handler())
+	m.server = &http.Server{Addr: listener.Addr().String(), Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go m.server.Serve(listener)
+	return nil
+}
+
+// Addr returns the address /metrics is served on, empty when nothing is served.
+func (m *Metrics) Addr() string {
+	if m.server == nil {
+		return ""
+	}
+	return m.server.Addr
+}
+
+// Close stops serving /metrics, it does nothing when nothing is served.
+func (m *Metrics) Close() error {
+	if m.server == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	return m.server.Shutdown(ctx)
+}
+
+// NumericLevelsAsWildcard replaces every topic level that consists of digits only with "*".
+func NumericLevelsAsWildcard(topic string) string {
+	levels := strings.Split(topic, "/")
+	for i, level := range levels {
+		if level != "" && strings.Trim(level, "0123456789") == "" {
+			levels[i] = "*"
+		}
+	}
+	return strings.Join(levels, "/")
+}
@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"testing"
+
+	"solace.dev/go/messaging/pkg/solace/message"
+
+	"SolaceSamples.com/PubSub+Go/internal/fake"
+)
+
+// redelivery is an inbound message flagged as redelivered
+type redelivery struct {
+	message.InboundMessage
+}
+
+func (redelivery) IsRedelivered() bool { return true }
+
+// counterValue returns the value of the counter name with the given labels, 0 when absent
+func counterValue(t *testing.T, m *Metrics, name string, labels map[string]string) float64 {
+	t.Helper()
+	families, err := m.Registry().Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+	metrics:
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if labels[label.GetName()] != label.GetValue() {
+					continue metrics
+				}
+			}
+			return metric.GetCounter().GetValue()
+		}
+	}
+	return 0
+}
+
+func TestCountRedelivered(t *testing.T) {
+	m := New()
+	first := fake.NewInboundMessage("solace/samples/go/persistent/17", "Hello", nil)
+	m.CountRedelivered(first)
+	m.CountRedelivered(redelivery{first})
+	m.CountRedelivered(redelivery{fake.NewInboundMessage("solace/samples/go/persistent/18", "Hello", nil)})
+	m.CountRedelivered(nil)
+
+	got := counterValue(t, m, "solace_messages_redelivered_total", map[string]string{"topic": "solace/samples/go/persistent/*"})
+	if got != 2 {
+		t.Errorf("redelivered = %v, want 2 on the wildcard topic", got)
+	}
+}
@@ -0,0 +1,290 @@
+package metrics
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"solace.dev/go/messaging/pkg/solace"
+	"solace.dev/go/messaging/pkg/solace/config"
+	"solace.dev/go/messaging/pkg/solace/message"
+	"solace.dev/go/messaging/pkg/solace/resource"
+)
+
+// countPublish counts a publish call of the given kind by its result
+func (m *Metrics) countPublish(kind string, destination *resource.Topic, err error) error {
+	topic := ""
+	if destination != nil {
+		topic = m.topicLabel(destination.GetName())
+	}
+	if err != nil {
+		m.publishErrors.WithLabelValues(kind, topic).Inc()
+	} else {
+		m.published.WithLabelValues(kind, topic).Inc()
+	}
+	return err
+}
+
+func (m *Metrics) countReceived(kind string, msg message.InboundMessage) {
+	if msg != nil {
+		m.received.WithLabelValues(kind, m.topicLabel(msg.GetDestinationName())).Inc()
+	}
+}
+
+// CountRedelivered counts msg when the broker flagged it as redelivered. It
+// matches dedup.OnRedelivered, which only calls it for redelivered messages.
+func (m *Metrics) CountRedelivered(msg message.InboundMessage) {
+	if msg != nil && msg.IsRedelivered() {
+		m.redelivered.WithLabelValues(m.topicLabel(msg.GetDestinationName())).Inc()
+	}
+}
+
+func (m *Metrics) countSettlement(outcome config.MessageSettlementOutcome, err error) error {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	m.settlements.WithLabelValues(strings.ToLower(string(outcome)), result).Inc()
+	return err
+}
+
+// observeRoundTrip records the time since start by the result of a request
+func (m *Metrics) observeRoundTrip(destination *resource.Topic, start time.Time, err error) {
+	result := "reply"
+	var timeout *solace.TimeoutError
+	switch {
+	case errors.As(err, &timeout):
+		result = "timeout"
+	case err != nil:
+		result = "error"
+	}
+	topic := ""
+	if destination != nil {
+		topic = m.topicLabel(destination.GetName())
+	}
+	m.roundTrip.WithLabelValues(topic, result).Observe(time.Since(start).Seconds())
+}
+
+// DirectPublisher counts the messages published through publisher.
+func (m *Metrics) DirectPublisher(publisher solace.DirectMessagePublisher) solace.DirectMessagePublisher {
+	return &directPublisher{DirectMessagePublisher: publisher, metrics: m}
+}
+
+type directPublisher struct {
+	solace.DirectMessagePublisher
+	metrics *Metrics
+}
+
+func (p *directPublisher) PublishBytes(msg []byte, destination *resource.Topic) error {
+	return p.metrics.countPublish(KindDirect, destination, p.DirectMessagePublisher.PublishBytes(msg, destination))
+}
+
+func (p *directPublisher) PublishString(msg string, destination *resource.Topic) error {
+	return p.metrics.countPublish(KindDirect, destination, p.DirectMessagePublisher.PublishString(msg, destination))
+}
+
+func (p *directPublisher) Publish(msg message.OutboundMessage, destination *resource.Topic) error {
+	return p.metrics.countPublish(KindDirect, destination, p.DirectMessagePublisher.Publish(msg, destination))
+}
+
+func (p *directPublisher) PublishWithProperties(msg message.OutboundMessage, destination *resource.Topic, properties config.MessagePropertiesConfigurationProvider) error {
+	return p.metrics.countPublish(KindDirect, destination, p.DirectMessagePublisher.PublishWithProperties(msg, destination, properties))
+}
+
+// PersistentPublisher counts the messages published through publisher and the
+// results of their publish receipts. Receipts are only counted once a receipt
+// listener is set on the returned publisher.
+func (m *Metrics) PersistentPublisher(publisher solace.PersistentMessagePublisher) solace.PersistentMessagePublisher {
+	return &persistentPublisher{PersistentMessagePublisher: publisher, metrics: m}
+}
+
+type persistentPublisher struct {
+	solace.PersistentMessagePublisher
+	metrics *Metrics
+}
+
+func (p *persistentPublisher) SetMessagePublishReceiptListener(listener solace.MessagePublishReceiptListener) {
+	p.PersistentMessagePublisher.SetMessagePublishReceiptListener(func(receipt solace.PublishReceipt) {
+		if receipt.GetError() != nil {
+			p.metrics.receipts.WithLabelValues("failed").Inc()
+		} else {
+			p.metrics.receipts.WithLabelValues("persisted").Inc()
+		}
+		if listener != nil {
+			listener(receipt)
+		}
+	})
+}
+
+func (p *persistentPublisher) PublishBytes(msg []byte, destination *resource.Topic) error {
+	return p.metrics.countPublish(KindPersistent, destination, p.PersistentMessagePublisher.PublishBytes(msg, destination))
+}
+
+func (p *persistentPublisher) PublishString(msg string, destination *resource.Topic) error {
+	return p.metrics.countPublish(KindPersistent, destination, p.PersistentMessagePublisher.PublishString(msg, destination))
+}
+
+func (p *persistentPublisher) Publish(msg message.OutboundMessage, destination *resource.Topic, properties config.MessagePropertiesConfigurationProvider, context interface{}) error {
+	return p.metrics.countPublish(KindPersistent, destination, p.PersistentMessagePublisher.Publish(msg, destination, properties, context))
+}
+
+func (p *persistentPublisher) PublishAwaitAcknowledgement(msg message.OutboundMessage, destination *resource.Topic, timeout time.Duration, properties config.MessagePropertiesConfigurationProvider) error {
+	err := p.PersistentMessagePublisher.PublishAwaitAcknowledgement(msg, destination, timeout, properties)
+	if err != nil {
+		p.metrics.receipts.WithLabelValues("failed").Inc()
+	} else {
+		p.metrics.receipts.WithLabelValues("persisted").Inc()
+	}
+	return p.metrics.countPublish(KindPersistent, destination, err)
+}
+
+// DirectReceiver counts the messages received through receiver.
+func (m *Metrics) DirectReceiver(receiver solace.DirectMessageReceiver) solace.DirectMessageReceiver {
+	return &directReceiver{DirectMessageReceiver: receiver, metrics: m}
+}
+
+type directReceiver struct {
+	solace.DirectMessageReceiver
+	metrics *Metrics
+}
+
+func (r *directReceiver) ReceiveAsync(callback solace.MessageHandler) error {
+	return r.DirectMessageReceiver.ReceiveAsync(func(msg message.InboundMessage) {
+		r.metrics.countReceived(KindDirect, msg)
+		callback(msg)
+	})
+}
+
+func (r *directReceiver) ReceiveMessage(timeout time.Duration) (message.InboundMessage, error) {
+	msg, err := r.DirectMessageReceiver.ReceiveMessage(timeout)
+	r.metrics.countReceived(KindDirect, msg)
+	return msg, err
+}
+
+// PersistentReceiver counts the messages received through receiver and how
+// they are acknowledged or settled.
+func (m *Metrics) PersistentReceiver(receiver solace.PersistentMessageReceiver) solace.PersistentMessageReceiver {
+	return &persistentReceiver{PersistentMessageReceiver: receiver, metrics: m}
+}
+
+type persistentReceiver struct {
+	solace.PersistentMessageReceiver
+	metrics *Metrics
+}
+
+func (r *persistentReceiver) ReceiveAsync(callback solace.MessageHandler) error {
+	return r.PersistentMessageReceiver.ReceiveAsync(func(msg message.InboundMessage) {
+		r.metrics.countReceived(KindPersistent, msg)
+		callback(msg)
+	})
+}
+
+func (r *persistentReceiver) ReceiveMessage(timeout time.Duration) (message.InboundMessage, error) {
+	msg, err := r.PersistentMessageReceiver.ReceiveMessage(timeout)
+	r.metrics.countReceived(KindPersistent, msg)
+	return msg, err
+}
+
+func (r *persistentReceiver) Ack(msg message.InboundMessage) error {
+	return r.metrics.countSettlement(config.PersistentReceiverAcceptedOutcome, r.PersistentMessageReceiver.Ack(msg))
+}
+
+func (r *persistentReceiver) Settle(msg message.InboundMessage, outcome config.MessageSettlementOutcome) error {
+	return r.metrics.countSettlement(outcome, r.PersistentMessageReceiver.Settle(msg, outcome))
+}
+
+// RequestReplyPublisher counts the requests published through publisher and
+// records the round trip time of each until its reply, timeout or error.
+func (m *Metrics) RequestReplyPublisher(publisher solace.RequestReplyMessagePublisher) solace.RequestReplyMessagePublisher {
+	return &requestReplyPublisher{RequestReplyMessagePublisher: publisher, metrics: m}
+}
+
+type requestReplyPublisher struct {
+	solace.RequestReplyMessagePublisher
+	metrics *Metrics
+}
+
+// timed wraps handler so that it records the round trip of a request to destination
+func (p *requestReplyPublisher) timed(handler solace.ReplyMessageHandler, destination *resource.Topic) solace.ReplyMessageHandler {
+	start := time.Now()
+	return func(msg message.InboundMessage, userContext interface{}, err error) {
+		p.metrics.observeRoundTrip(destination, start, err)
+		if handler != nil {
+			handler(msg, userContext, err)
+		}
+	}
+}
+
+func (p *requestReplyPublisher) PublishBytes(msg []byte, replyMessageHandler solace.ReplyMessageHandler, destination *resource.Topic, replyTimeout time.Duration, userContext interface{}) error {
+	err := p.RequestReplyMessagePublisher.PublishBytes(msg, p.timed(replyMessageHandler, destination), destination, replyTimeout, userContext)
+	return p.metrics.countPublish(KindRequest, destination, err)
+}
+
+func (p *requestReplyPublisher) PublishString(msg string, replyMessageHandler solace.ReplyMessageHandler, destination *resource.Topic, replyTimeout time.Duration, userContext interface{}) error {
+	err := p.RequestReplyMessagePublisher.PublishString(msg, p.timed(replyMessageHandler, destination), destination, replyTimeout, userContext)
+	return p.metrics.countPublish(KindRequest, destination, err)
+}
+
+func (p *requestReplyPublisher) Publish(requestMessage message.OutboundMessage, replyMessageHandler solace.ReplyMessageHandler,
+	requestsDestination *resource.Topic, replyTimeout time.Duration,
+	properties config.MessagePropertiesConfigurationProvider, userContext interface{}) error {
+	err := p.RequestReplyMessagePublisher.Publish(requestMessage, p.timed(replyMessageHandler, requestsDestination), requestsDestination, replyTimeout, properties, userContext)
+	return p.metrics.countPublish(KindRequest, requestsDestination, err)
+}
+
+func (p *requestReplyPublisher) PublishAwaitResponse(requestMessage message.OutboundMessage, requestDestination *resource.Topic,
+	replyTimeout time.Duration, properties config.MessagePropertiesConfigurationProvider) (message.InboundMessage, error) {
+	start := time.Now()
+	reply, err := p.RequestReplyMessagePublisher.PublishAwaitResponse(requestMessage, requestDestination, replyTimeout, properties)
+	p.metrics.observeRoundTrip(requestDestination, start, err)
+	var timeout *solace.TimeoutError
+	if errors.As(err, &timeout) {
+		// the request was sent, only the reply is missing
+		p.metrics.countPublish(KindRequest, requestDestination, nil)
+	} else {
+		p.metrics.countPublish(KindRequest, requestDestination, err)
+	}
+	return reply, err
+}
+
+// RequestReplyReceiver counts the requests received through receiver and the
+// replies sent back for them.
+func (m *Metrics) RequestReplyReceiver(receiver solace.RequestReplyMessageReceiver) solace.RequestReplyMessageReceiver {
+	return &requestReplyReceiver{RequestReplyMessageReceiver: receiver, metrics: m}
+}
+
+type requestReplyReceiver struct {
+	solace.RequestReplyMessageReceiver
+	metrics *Metrics
+}
+
+func (r *requestReplyReceiver) ReceiveAsync(messageHandler solace.RequestMessageHandler) error {
+	return r.RequestReplyMessageReceiver.ReceiveAsync(func(msg message.InboundMessage, replier solace.Replier) {
+		r.metrics.countReceived(KindRequest, msg)
+		messageHandler(msg, r.replier(msg, replier))
+	})
+}
+
+func (r *requestReplyReceiver) ReceiveMessage(timeout time.Duration) (message.InboundMessage, solace.Replier, error) {
+	msg, replier, err := r.RequestReplyMessageReceiver.ReceiveMessage(timeout)
+	r.metrics.countReceived(KindRequest, msg)
+	return msg, r.replier(msg, replier), err
+}
+
+// replier counts replies under the topic the request was received on
+func (r *requestReplyReceiver) replier(request message.InboundMessage, replier solace.Replier) solace.Replier {
+	if replier == nil || request == nil {
+		return replier
+	}
+	return &countingReplier{Replier: replier, metrics: r.metrics, topic: resource.TopicOf(request.GetDestinationName())}
+}
+
+type countingReplier struct {
+	solace.Replier
+	metrics *Metrics
+	topic   *resource.Topic
+}
+
+func (r *countingReplier) Reply(msg message.OutboundMessage) error {
+	return r.metrics.countPublish(KindReply, r.topic, r.Replier.Reply(msg))
+}
@@ -0,0 +1,274 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"solace.dev/go/messaging/pkg/solace/config"
+)
+
+// PropertyUpdater is the part of solace.MessagingService the Manager needs.
+type PropertyUpdater interface {
+	UpdateProperty(property config.ServiceProperty, value interface{}) error
+}
+
+// Manager defaults
+const (
+	DefaultRefreshAhead    = time.Minute
+	DefaultRetryInterval   = 5 * time.Second
+	DefaultMaxRetry        = time.Minute
+	DefaultUnknownLifetime = 5 * time.Minute
+)
+
+// RefreshError tells why a refresh failed.
+type RefreshError struct {
+	Attempt int       // consecutive failures including this one
+	Expiry  time.Time // of the token in use, zero when unknown or there is none
+	Err     error
+}
+
+func (e *RefreshError) Error() string {
+	if e.Expiry.IsZero() {
+		return fmt.Sprintf("oauth token refresh attempt %d: %v", e.Attempt, e.Err)
+	}
+	return fmt.Sprintf("oauth token refresh attempt %d, current token expires in %s: %v",
+		e.Attempt, time.Until(e.Expiry).Round(time.Second), e.Err)
+}
+
+func (e *RefreshError) Unwrap() error {
+	return e.Err
+}
+
+// Stats is a snapshot of the counters of a Manager.
+type Stats struct {
+	Refreshes uint64 // tokens pushed to the service
+	Failures  uint64 // failed refreshes
+}
+
+func (s Stats) String() string {
+	return fmt.Sprintf("refreshes=%d failures=%d", s.Refreshes, s.Failures)
+}
+
+// Option configures a Manager.
+type Option func(*Manager)
+
+// WithRefreshAhead sets how long before its expiry a token is replaced. Tokens
+// that live shorter than twice as long are replaced half way through their
+// lifetime.
+func WithRefreshAhead(d time.Duration) Option {
+	return func(m *Manager) {
+		m.refreshAhead = d
+	}
+}
+
+// WithRetryInterval sets the delay after a failed refresh, it doubles with
+// every further failure up to max.
+func WithRetryInterval(d, max time.Duration) Option {
+	return func(m *Manager) {
+		m.retryInterval, m.maxRetry = d, max
+	}
+}
+
+// WithUnknownLifetime sets the lifetime assumed for tokens whose expiry is
+// not known, neither from the source nor from a JWT exp claim.
+func WithUnknownLifetime(d time.Duration) Option {
+	return func(m *Manager) {
+		m.unknownLifetime = d
+	}
+}
+
+// WithToken sets the token the service was built with, so Run waits for it
+// to be due instead of refreshing right away.
+func WithToken(token *Token) Option {
+	return func(m *Manager) {
+		m.current, m.fetched = token, time.Now()
+	}
+}
+
+// OnRefresh registers fn to be called with every token pushed to the service.
+func OnRefresh(fn func(token *Token)) Option {
+	return func(m *Manager) {
+		m.onRefresh = fn
+	}
+}
+
+// OnFailure registers fn to be called with a *RefreshError for every failed refresh.
+func OnFailure(fn func(err error)) Option {
+	return func(m *Manager) {
+		m.onFailure = fn
+	}
+}
+
+// Manager refreshes the OAuth2 tokens of a messaging service.
+type Manager struct {
+	service PropertyUpdater
+	source  Source
+
+	refreshAhead    time.Duration
+	retryInterval   time.Duration
+	maxRetry        time.Duration
+	unknownLifetime time.Duration
+	onRefresh       func(token *Token)
+	onFailure       func(err error)
+
+	mu        sync.Mutex
+	current   *Token
+	fetched   time.Time
+	failures  int
+	refreshes uint64
+	failed    uint64
+}
+
+// NewManager creates a manager pushing the tokens of source to service.
+func NewManager(service PropertyUpdater, source Source, opts ...Option) *Manager {
+	m := &Manager{
+		service:         service,
+		source:          source,
+		refreshAhead:    DefaultRefreshAhead,
+		retryInterval:   DefaultRetryInterval,
+		maxRetry:        DefaultMaxRetry,
+		unknownLifetime: DefaultUnknownLifetime,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// Token returns the token in use, nil before the first refresh.
+func (m *Manager) Token() *Token {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.current
+}
+
+// Stats returns the current counters.
+func (m *Manager) Stats() Stats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return Stats{Refreshes: m.refreshes, Failures: m.failed}
+}
+
+// Run refreshes the tokens until ctx is done: ahead of the expiry of the
+// current token, after a failure with backoff, and whenever a Watcher source
+// signals a change.
+func (m *Manager) Run(ctx context.Context) error {
+	var changed <-chan struct{}
+	if watcher, ok := m.source.(Watcher); ok {
+		changed = watcher.Watch(ctx)
+	}
+	for {
+		timer := time.NewTimer(m.nextRefresh())
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		case <-changed:
+			timer.Stop()
+		}
+		// failures are reported to the listener, an unchanged token is none
+		m.Refresh(ctx)
+	}
+}
+
+// Refresh fetches a token and pushes it to the service. It fails with a
+// *RefreshError, which is also passed to the OnFailure function. When the
+// source returns the token in use, e.g. a file that was not rewritten yet, it
+// returns that token and ErrUnchanged, which is no failure: the next refresh is
+// scheduled from now on as if the token had just been fetched.
+func (m *Manager) Refresh(ctx context.Context) (*Token, error) {
+	token, err := m.source.Token(ctx)
+	if err == nil {
+		err = m.check(token)
+	}
+	if errors.Is(err, ErrUnchanged) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.fetched, m.failures = time.Now(), 0
+		return m.current, ErrUnchanged
+	}
+	if err == nil {
+		err = m.push(token)
+	}
+
+	m.mu.Lock()
+	if err != nil {
+		m.failures++
+		m.failed++
+		refreshErr := &RefreshError{Attempt: m.failures, Err: err}
+		if m.current != nil {
+			refreshErr.Expiry, _ = m.current.expiry()
+		}
+		m.mu.Unlock()
+		if m.onFailure != nil {
+			m.onFailure(refreshErr)
+		}
+		return nil, refreshErr
+	}
+	m.current, m.fetched, m.failures = token, time.Now(), 0
+	m.refreshes++
+	m.mu.Unlock()
+	if m.onRefresh != nil {
+		m.onRefresh(token)
+	}
+	return token, nil
+}
+
+// check refuses tokens that are no use to the service
+func (m *Manager) check(token *Token) error {
+	if token == nil || token.AccessToken == "" {
+		return errors.New("source returned no access token")
+	}
+	if exp, ok := token.expiry(); ok && !exp.After(time.Now()) {
+		return fmt.Errorf("source returned a token that expired at %s", exp.Format(time.RFC3339))
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.current != nil && token.AccessToken == m.current.AccessToken && token.IDToken == m.current.IDToken {
+		return ErrUnchanged
+	}
+	return nil
+}
+
+// push hands the tokens to the service for its next connection attempt
+func (m *Manager) push(token *Token) error {
+	if err := m.service.UpdateProperty(config.AuthenticationPropertySchemeOAuth2AccessToken, token.AccessToken); err != nil {
+		return fmt.Errorf("updating the access token: %w", err)
+	}
+	if token.IDToken != "" {
+		if err := m.service.UpdateProperty(config.AuthenticationPropertySchemeOAuth2OIDCIDToken, token.IDToken); err != nil {
+			return fmt.Errorf("updating the ID token: %w", err)
+		}
+	}
+	return nil
+}
+
+// nextRefresh returns how long to wait before the next refresh
+func (m *Manager) nextRefresh() time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.failures > 0 {
+		backoff := m.retryInterval
+		for i := 1; i < m.failures && backoff < m.maxRetry; i++ {
+			backoff *= 2
+		}
+		return min(backoff, m.maxRetry)
+	}
+	if m.current == nil {
+		return 0
+	}
+	expiry, ok := m.current.expiry()
+	if !ok {
+		expiry = m.fetched.Add(m.unknownLifetime)
+	}
+	// half way through short lifetimes, refreshAhead before the expiry of long ones
+	due := expiry.Add(-m.refreshAhead)
+	if halfway := m.fetched.Add(expiry.Sub(m.fetched) / 2); halfway.After(due) {
+		due = halfway
+	}
+	return max(time.Until(due), 0)
+}
@@ -0,0 +1,315 @@
+package oauth
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"solace.dev/go/messaging/pkg/solace/config"
+
+	"SolaceSamples.com/PubSub+Go/internal/oauth/tokenstub"
+)
+
+// jwt returns an unsigned JWT with claims
+func jwt(t *testing.T, claims map[string]interface{}) string {
+	t.Helper()
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "eyJhbGciOiJub25lIn0." + base64.RawURLEncoding.EncodeToString(payload) + ".sig"
+}
+
+// recorder is a PropertyUpdater remembering the last value of every property
+type recorder struct {
+	mu         sync.Mutex
+	properties map[config.ServiceProperty]interface{}
+	err        error
+}
+
+func (r *recorder) UpdateProperty(property config.ServiceProperty, value interface{}) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	if r.properties == nil {
+		r.properties = map[config.ServiceProperty]interface{}{}
+	}
+	r.properties[property] = value
+	return nil
+}
+
+func (r *recorder) property(property config.ServiceProperty) interface{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.properties[property]
+}
+
+// sourceFunc adapts a function to Source
+type sourceFunc func(ctx context.Context) (*Token, error)
+
+func (f sourceFunc) Token(ctx context.Context) (*Token, error) {
+	return f(ctx)
+}
+
+func TestJWTExpiry(t *testing.T) {
+	exp := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name   string
+		token  string
+		want   time.Time
+		wantOK bool
+	}{
+		{"exp", jwt(t, map[string]interface{}{"exp": exp.Unix(), "sub": "client"}), exp, true},
+		{"fractional exp", jwt(t, map[string]interface{}{"exp": float64(exp.Unix()) + 0.5}), exp, true},
+		{"padded payload", "eyJhbGciOiJub25lIn0." + base64.URLEncoding.EncodeToString([]byte(`{"exp":1893553445}`)) + ".sig", exp, true},
+		{"no exp", jwt(t, map[string]interface{}{"sub": "client"}), time.Time{}, false},
+		{"exp not a number", jwt(t, map[string]interface{}{"exp": "tomorrow"}), time.Time{}, false},
+		{"opaque token", "2YotnFZFEjr1zCsicMWpAA", time.Time{}, false},
+		{"two parts", "a.b", time.Time{}, false},
+		{"payload not base64", "a.!!!.c", time.Time{}, false},
+		{"payload not JSON", "a." + base64.RawURLEncoding.EncodeToString([]byte("exp")) + ".c", time.Time{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := JWTExpiry(tt.token)
+		if ok != tt.wantOK || !got.Equal(tt.want) {
+			t.Errorf("%s: JWTExpiry = %s, %v, want %s, %v", tt.name, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestNextRefresh(t *testing.T) {
+	tests := []struct {
+		name     string
+		token    *Token
+		lifetime time.Duration // since fetched, zero for a token without a known expiry
+		ahead    time.Duration
+		want     time.Duration
+	}{
+		{"no token yet", nil, 0, time.Minute, 0},
+		{"long lifetime", &Token{AccessToken: "a"}, time.Hour, time.Minute, 59 * time.Minute},
+		{"short lifetime is refreshed half way", &Token{AccessToken: "a"}, 90 * time.Second, time.Minute, 45 * time.Second},
+		{"exactly twice refresh ahead", &Token{AccessToken: "a"}, 2 * time.Minute, time.Minute, time.Minute},
+		{"unknown lifetime", &Token{AccessToken: "opaque"}, 0, time.Minute, 9 * time.Minute},
+		{"unknown lifetime shorter than twice refresh ahead", &Token{AccessToken: "opaque"}, 0, 8 * time.Minute, 5 * time.Minute},
+		{"expired", &Token{AccessToken: "a"}, -time.Second, time.Minute, 0},
+	}
+	for _, tt := range tests {
+		m := NewManager(&recorder{}, nil, WithRefreshAhead(tt.ahead), WithUnknownLifetime(10*time.Minute))
+		now := time.Now()
+		if tt.token != nil {
+			if tt.lifetime != 0 {
+				tt.token.Expiry = now.Add(tt.lifetime)
+			}
+			m.current, m.fetched = tt.token, now
+		}
+		got := m.nextRefresh()
+		if diff := got - tt.want; diff > 0 || diff < -100*time.Millisecond {
+			t.Errorf("%s: next refresh in %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	m := NewManager(&recorder{}, sourceFunc(func(context.Context) (*Token, error) {
+		return nil, errors.New("token endpoint down")
+	}), WithRetryInterval(time.Second, 10*time.Second), WithToken(&Token{AccessToken: "a", Expiry: time.Now().Add(time.Hour)}))
+
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second}
+	for i, wantDelay := range want {
+		_, err := m.Refresh(context.Background())
+		var refreshErr *RefreshError
+		if !errors.As(err, &refreshErr) || refreshErr.Attempt != i+1 || refreshErr.Expiry.IsZero() {
+			t.Fatalf("attempt %d: error %#v", i+1, err)
+		}
+		if got := m.nextRefresh(); got != wantDelay {
+			t.Errorf("after %d failures next refresh in %s, want %s", i+1, got, wantDelay)
+		}
+	}
+	if stats := m.Stats(); stats.Failures != uint64(len(want)) || stats.Refreshes != 0 {
+		t.Errorf("stats %s", stats)
+	}
+}
+
+func TestRefreshFailures(t *testing.T) {
+	expired := &Token{AccessToken: "a", Expiry: time.Now().Add(-time.Minute)}
+	tests := []struct {
+		name    string
+		token   *Token
+		pushErr error
+	}{
+		{"no access token", &Token{}, nil},
+		{"expired token", expired, nil},
+		{"push fails", &Token{AccessToken: "a"}, errors.New("illegal property")},
+	}
+	for _, tt := range tests {
+		var failures []error
+		m := NewManager(&recorder{err: tt.pushErr}, sourceFunc(func(context.Context) (*Token, error) {
+			return tt.token, nil
+		}), OnFailure(func(err error) { failures = append(failures, err) }))
+		if _, err := m.Refresh(context.Background()); err == nil {
+			t.Errorf("%s: no error", tt.name)
+		}
+		if len(failures) != 1 || m.Stats().Failures != 1 || m.Token() != nil {
+			t.Errorf("%s: %d failures reported, stats %s, token %v", tt.name, len(failures), m.Stats(), m.Token())
+		}
+	}
+}
+
+func TestRefreshUnchanged(t *testing.T) {
+	dir := t.TempDir()
+	source := &File{AccessTokenPath: filepath.Join(dir, "access"), IDTokenPath: filepath.Join(dir, "id")}
+	write := func(access, id string) {
+		if err := os.WriteFile(source.AccessTokenPath, []byte(access+"\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(source.IDTokenPath, []byte(id), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("first", "id-1")
+
+	service := &recorder{}
+	var failures, refreshes int
+	m := NewManager(service, source,
+		WithRetryInterval(time.Second, time.Minute),
+		WithUnknownLifetime(10*time.Minute),
+		OnFailure(func(error) { failures++ }),
+		OnRefresh(func(*Token) { refreshes++ }))
+	if _, err := m.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// a failure followed by the same token again
+	os.Remove(source.AccessTokenPath)
+	if _, err := m.Refresh(context.Background()); err == nil {
+		t.Fatal("no error for a missing file")
+	}
+	write("first", "id-1")
+	token, err := m.Refresh(context.Background())
+	if !errors.Is(err, ErrUnchanged) {
+		t.Fatalf("error %v, want ErrUnchanged", err)
+	}
+	var refreshErr *RefreshError
+	if errors.As(err, &refreshErr) {
+		t.Error("unchanged token reported as a refresh error")
+	}
+	if token == nil || token.AccessToken != "first" {
+		t.Errorf("token %v, want the one in use", token)
+	}
+	if failures != 1 || refreshes != 1 {
+		t.Errorf("%d failures and %d refreshes reported, want 1 each", failures, refreshes)
+	}
+	if stats := m.Stats(); stats.Failures != 1 || stats.Refreshes != 1 {
+		t.Errorf("stats %s", stats)
+	}
+	// scheduled from the unchanged token on, not backing off
+	if next := m.nextRefresh(); next < 8*time.Minute {
+		t.Errorf("next refresh in %s, want the normal schedule", next)
+	}
+
+	write("second", "id-2")
+	if _, err := m.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if service.property(config.AuthenticationPropertySchemeOAuth2AccessToken) != "second" ||
+		service.property(config.AuthenticationPropertySchemeOAuth2OIDCIDToken) != "id-2" {
+		t.Errorf("pushed %v", service.properties)
+	}
+}
+
+func TestClientCredentials(t *testing.T) {
+	stub, err := tokenstub.Start("client", "s3cret:/&", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stub.Close()
+	source := &ClientCredentials{TokenURL: stub.TokenURL(), ClientID: "client", ClientSecret: "s3cret:/&", Scopes: []string{"openid", "profile"}}
+
+	before := time.Now()
+	token, err := source.Token(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken == "" || token.IDToken == "" {
+		t.Fatalf("token %+v, want an access and an ID token", token)
+	}
+	if token.Expiry.Before(before.Add(time.Hour-time.Second)) || token.Expiry.After(time.Now().Add(time.Hour)) {
+		t.Errorf("expiry %s, want in an hour", token.Expiry)
+	}
+	exp, ok := JWTExpiry(token.AccessToken)
+	if !ok || exp.Sub(token.Expiry).Abs() > 2*time.Second {
+		t.Errorf("exp claim %s, %v, want %s", exp, ok, token.Expiry)
+	}
+
+	wrong := *source
+	wrong.ClientSecret = "wrong"
+	if _, err := wrong.Token(context.Background()); err == nil {
+		t.Error("token issued for a wrong secret")
+	}
+	withoutOpenID := *source
+	withoutOpenID.Scopes = nil
+	if token, err := withoutOpenID.Token(context.Background()); err != nil || token.IDToken != "" {
+		t.Errorf("token %+v, %v, want no ID token without the openid scope", token, err)
+	}
+
+	// the manager pushes both tokens, retries after the endpoint failed and refreshes half way
+	service := &recorder{}
+	m := NewManager(service, source)
+	stub.FailNext(1)
+	if _, err := m.Refresh(context.Background()); err == nil {
+		t.Fatal("no error while the endpoint fails")
+	}
+	token, err = m.Refresh(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if service.property(config.AuthenticationPropertySchemeOAuth2AccessToken) != token.AccessToken ||
+		service.property(config.AuthenticationPropertySchemeOAuth2OIDCIDToken) != token.IDToken {
+		t.Error("tokens not pushed to the service")
+	}
+	if next := m.nextRefresh(); next < 58*time.Minute {
+		t.Errorf("next refresh in %s, want a minute before the expiry", next)
+	}
+}
+
+func TestRun(t *testing.T) {
+	stub, err := tokenstub.Start("client", "secret", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stub.Close()
+	source := &ClientCredentials{TokenURL: stub.TokenURL(), ClientID: "client", ClientSecret: "secret"}
+
+	refreshed := make(chan *Token, 8)
+	m := NewManager(&recorder{}, source, OnRefresh(func(token *Token) { refreshed <- token }))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- m.Run(ctx) }()
+
+	// the first token right away, the second half way through its second of lifetime
+	var tokens []*Token
+	timeout := time.After(3 * time.Second)
+	for len(tokens) < 2 {
+		select {
+		case token := <-refreshed:
+			tokens = append(tokens, token)
+		case <-timeout:
+			t.Fatalf("%d tokens refreshed in 3s", len(tokens))
+		}
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Run returned %v", err)
+	}
+	if tokens[0].AccessToken == tokens[1].AccessToken {
+		t.Error("the same token was refreshed twice")
+	}
+}
@@ -0,0 +1,237 @@
+// Package oauth keeps the OAuth2 tokens of a messaging service fresh. A
+// Manager fetches tokens from a Source, e.g. a client credentials flow against
+// a token endpoint or files on disk, and pushes them to the service with
+// UpdateProperty ahead of their expiry. The service uses the new tokens the
+// next time it connects, in particular when the broker disconnects it because
+// the old access token expired.
+package oauth
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Token is an access token and optionally an OpenID Connect ID token.
+type Token struct {
+	AccessToken string
+	IDToken     string
+	// Expiry is when the access token expires, zero when the source does not
+	// know. Manager then reads it from the token if it is a JWT.
+	Expiry time.Time
+}
+
+// expiry returns when the token expires, ok is false when that is unknown
+func (t *Token) expiry() (time.Time, bool) {
+	if !t.Expiry.IsZero() {
+		return t.Expiry, true
+	}
+	if exp, ok := JWTExpiry(t.AccessToken); ok {
+		return exp, true
+	}
+	return JWTExpiry(t.IDToken)
+}
+
+// JWTExpiry returns the exp claim of a JWT. The signature is not verified,
+// that is up to the broker. ok is false for tokens that are not JWTs or have
+// no exp claim.
+func JWTExpiry(token string) (time.Time, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}, false
+	}
+	var claims struct {
+		Exp *json.Number `json:"exp"`
+	}
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	if err := decoder.Decode(&claims); err != nil || claims.Exp == nil {
+		return time.Time{}, false
+	}
+	exp, err := claims.Exp.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(exp), 0), true
+}
+
+// Source fetches new tokens. Implementations must be safe for concurrent use.
+type Source interface {
+	Token(ctx context.Context) (*Token, error)
+}
+
+// Watcher is implemented by sources that can tell when a new token is
+// available, so the Manager fetches it right away.
+type Watcher interface {
+	// Watch returns a channel that receives a value whenever the token may
+	// have changed, until ctx is done.
+	Watch(ctx context.Context) <-chan struct{}
+}
+
+// ClientCredentials fetches tokens with the OAuth2 client credentials grant.
+type ClientCredentials struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	// Client sends the token requests, http.DefaultClient when nil.
+	Client *http.Client
+}
+
+// tokenResponse is the response of a token endpoint, RFC 6749 section 5
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	IDToken          string `json:"id_token"`
+	TokenType        string `json:"token_type"`
+	ExpiresIn        int64  `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// Token implements Source.
+func (c *ClientCredentials) Token(ctx context.Context) (*Token, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(c.Scopes) > 0 {
+		form.Set("scope", strings.Join(c.Scopes, " "))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(c.ClientID), url.QueryEscape(c.ClientSecret))
+
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	issued := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("token endpoint %s: %w", c.TokenURL, err)
+	}
+
+	var tr tokenResponse
+	jsonErr := json.Unmarshal(body, &tr)
+	if resp.StatusCode != http.StatusOK {
+		if jsonErr == nil && tr.Error != "" {
+			return nil, fmt.Errorf("token endpoint %s: %s: %s %s", c.TokenURL, resp.Status, tr.Error, tr.ErrorDescription)
+		}
+		return nil, fmt.Errorf("token endpoint %s: %s", c.TokenURL, resp.Status)
+	}
+	if jsonErr != nil {
+		return nil, fmt.Errorf("token endpoint %s: %w", c.TokenURL, jsonErr)
+	}
+	if tr.AccessToken == "" {
+		return nil, fmt.Errorf("token endpoint %s: no access token in the response", c.TokenURL)
+	}
+	if tr.TokenType != "" && !strings.EqualFold(tr.TokenType, "bearer") {
+		return nil, fmt.Errorf("token endpoint %s: unsupported token type %q", c.TokenURL, tr.TokenType)
+	}
+	t := &Token{AccessToken: tr.AccessToken, IDToken: tr.IDToken}
+	if tr.ExpiresIn > 0 {
+		t.Expiry = issued.Add(time.Duration(tr.ExpiresIn) * time.Second)
+	}
+	return t, nil
+}
+
+// DefaultPollInterval is how often File checks its files for changes.
+const DefaultPollInterval = 5 * time.Second
+
+// File reads the tokens from files, e.g. ones a sidecar or a Kubernetes
+// projected volume keeps up to date. Surrounding whitespace is ignored.
+type File struct {
+	AccessTokenPath string
+	// IDTokenPath is optional.
+	IDTokenPath string
+	// PollInterval is how often Watch checks the files, DefaultPollInterval when zero.
+	PollInterval time.Duration
+}
+
+// Token implements Source.
+func (f *File) Token(ctx context.Context) (*Token, error) {
+	access, err := os.ReadFile(f.AccessTokenPath)
+	if err != nil {
+		return nil, err
+	}
+	t := &Token{AccessToken: strings.TrimSpace(string(access))}
+	if t.AccessToken == "" {
+		return nil, fmt.Errorf("%s is empty", f.AccessTokenPath)
+	}
+	if f.IDTokenPath != "" {
+		id, err := os.ReadFile(f.IDTokenPath)
+		if err != nil {
+			return nil, err
+		}
+		t.IDToken = strings.TrimSpace(string(id))
+	}
+	return t, nil
+}
+
+// Watch implements Watcher by polling the modification times of the files.
+func (f *File) Watch(ctx context.Context) <-chan struct{} {
+	interval := f.PollInterval
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+	changed := make(chan struct{}, 1)
+	go func() {
+		last := f.modTimes()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if current := f.modTimes(); current != last {
+				last = current
+				select {
+				case changed <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return changed
+}
+
+// modTimes identifies the current version of the files
+func (f *File) modTimes() string {
+	var versions []string
+	for _, path := range []string{f.AccessTokenPath, f.IDTokenPath} {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			versions = append(versions, err.Error())
+			continue
+		}
+		versions = append(versions, fmt.Sprint(info.ModTime().UnixNano(), info.Size()))
+	}
+	return strings.Join(versions, "|")
+}
+
+// ErrUnchanged is reported when a refresh returns the token already in use.
+var ErrUnchanged = errors.New("source returned the current token")
@@ -0,0 +1,167 @@
+// Package tokenstub is an in-process OAuth2 token endpoint for trying out and
+// checking token refreshing without an identity provider. It implements the
+// client credentials grant and issues short-lived JWTs signed with HS256 and a
+// random key, which brokers will not accept.
+package tokenstub
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server is a token endpoint.
+type Server struct {
+	clientID     string
+	clientSecret string
+	lifetime     time.Duration
+	key          []byte
+
+	listener net.Listener
+	server   *http.Server
+
+	mu       sync.Mutex
+	issued   int
+	failNext int
+}
+
+// Start listens on a free loopback port and issues tokens that expire after
+// lifetime to the given client.
+func Start(clientID, clientSecret string, lifetime time.Duration) (*Server, error) {
+	s := &Server{clientID: clientID, clientSecret: clientSecret, lifetime: lifetime, key: make([]byte, 32)}
+	if _, err := rand.Read(s.key); err != nil {
+		return nil, err
+	}
+	var err error
+	if s.listener, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+		return nil, fmt.Errorf("starting token endpoint stub: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", s.serveToken)
+	s.server = &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go s.server.Serve(s.listener)
+	return s, nil
+}
+
+// TokenURL returns the URL of the token endpoint.
+func (s *Server) TokenURL() string {
+	return "http://" + s.listener.Addr().String() + "/token"
+}
+
+// Issued returns the number of tokens issued so far.
+func (s *Server) Issued() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.issued
+}
+
+// FailNext makes the next n token requests fail with 503 Service Unavailable.
+func (s *Server) FailNext(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failNext = n
+}
+
+// Close stops the endpoint.
+func (s *Server) Close() error {
+	return s.server.Close()
+}
+
+func (s *Server) serveToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	s.mu.Lock()
+	failing := s.failNext > 0
+	if failing {
+		s.failNext--
+	}
+	s.mu.Unlock()
+	if failing {
+		writeError(w, http.StatusServiceUnavailable, "temporarily_unavailable", "the stub was told to fail")
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	if grant := r.PostForm.Get("grant_type"); grant != "client_credentials" {
+		writeError(w, http.StatusBadRequest, "unsupported_grant_type", fmt.Sprintf("grant type %q", grant))
+		return
+	}
+	clientID, clientSecret, ok := r.BasicAuth()
+	if ok {
+		// form encoded before the base64 encoding, RFC 6749 section 2.3.1
+		clientID, _ = url.QueryUnescape(clientID)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	} else {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != s.clientID || clientSecret != s.clientSecret {
+		writeError(w, http.StatusUnauthorized, "invalid_client", "unknown client or wrong secret")
+		return
+	}
+
+	now := time.Now()
+	scope := r.PostForm.Get("scope")
+	response := map[string]interface{}{
+		"access_token": s.sign(now, scope),
+		"token_type":   "Bearer",
+		"expires_in":   int64(s.lifetime / time.Second),
+	}
+	if scope != "" {
+		response["scope"] = scope
+		for _, requested := range strings.Fields(scope) {
+			if requested == "openid" {
+				response["id_token"] = s.sign(now, "")
+			}
+		}
+	}
+	s.mu.Lock()
+	s.issued++
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(response)
+}
+
+// sign issues a JWT for the client
+func (s *Server) sign(now time.Time, scope string) string {
+	jti := make([]byte, 8)
+	rand.Read(jti)
+	claims := map[string]interface{}{
+		"iss": "http://" + s.listener.Addr().String(),
+		"sub": s.clientID,
+		"iat": now.Unix(),
+		"exp": now.Add(s.lifetime).Unix(),
+		"jti": hex.EncodeToString(jti),
+	}
+	if scope != "" {
+		claims["scope"] = scope
+	}
+	header, _ := json.Marshal(map[string]string{"alg": "HS256", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func writeError(w http.ResponseWriter, status int, code, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": code, "error_description": description})
+}
@@ -0,0 +1,579 @@
+// Package pipeline assembles consume-transform-produce processors from a
+// source, a chain of typed stages and a sink:
+//
+//	processor := pipeline.Pipeline[string, string]{
+//		Source:       pipeline.Subscription("solace/samples/direct/processor/input"),
+//		Decode:       pipeline.DecodeString,
+//		Stage:        pipeline.Map(func(s string) (string, error) { return strings.ToUpper(s), nil }),
+//		Encode:       pipeline.EncodeString,
+//		Sink:         pipeline.ToSibling("output"),
+//		FailureTopic: "solace/samples/direct/processor/failed",
+//	}
+//	running, err := processor.Start(messagingService)
+//
+// A source subscribing to topics consumes direct messages and publishes its
+// outputs as direct messages, so a record is processed at most once. A source
+// bound to a queue publishes persistent messages and acknowledges every input
+// only once its outputs were persisted, see the workers package, so a record is
+// processed at least once.
+//
+// Outputs of type string are published with a string payload, all others with
+// a binary one. Only the input user properties named in Pipeline.Properties are
+// carried over to the records.
+//
+// An input that fails to decode, in a stage, to encode or to get a topic is
+// published unchanged to the failure topic with the error in its user
+// properties. Without a failure topic it is dropped for a direct source and
+// settled as failed, i.e. redelivered, for a queue.
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"solace.dev/go/messaging/pkg/solace"
+	"solace.dev/go/messaging/pkg/solace/config"
+	"solace.dev/go/messaging/pkg/solace/message"
+	"solace.dev/go/messaging/pkg/solace/resource"
+
+	"SolaceSamples.com/PubSub+Go/internal/topics"
+	"SolaceSamples.com/PubSub+Go/internal/workers"
+)
+
+// User properties added to inputs published to the failure topic.
+const (
+	PropertyError         = "pipeline_error"
+	PropertyOriginalTopic = "pipeline_original_topic"
+)
+
+// QueueTopicPrefix addresses a queue directly when it prefixes the queue name
+// in a publish topic.
+const QueueTopicPrefix = "#P2P/QUE/"
+
+// Source is where a pipeline consumes its inputs from.
+type Source struct {
+	subscriptions []resource.Subscription
+	queue         *resource.Queue
+}
+
+// Subscription consumes the direct messages published on topics, which may contain wildcards.
+func Subscription(topics ...string) Source {
+	s := Source{}
+	for _, topic := range topics {
+		s.subscriptions = append(s.subscriptions, resource.TopicSubscriptionOf(topic))
+	}
+	return s
+}
+
+// Queue consumes the messages spooled on queue, which is created if it is
+// missing and subscribed to topics.
+func Queue(queue *resource.Queue, topics ...string) Source {
+	s := Subscription(topics...)
+	s.queue = queue
+	return s
+}
+
+func (s Source) String() string {
+	topics := make([]string, len(s.subscriptions))
+	for i, sub := range s.subscriptions {
+		topics[i] = sub.GetName()
+	}
+	if s.queue != nil {
+		return fmt.Sprintf("queue %s subscribed to %s", s.queue.GetName(), strings.Join(topics, ", "))
+	}
+	return "topics " + strings.Join(topics, ", ")
+}
+
+// Sink decides the topic every output record is published to.
+type Sink struct {
+	topic       func(topic string, properties map[string]interface{}) (string, error)
+	description string
+	err         error
+}
+
+// ToTopic publishes to the topic schema template builds from the record
+// properties named like its fields, see the topics package. The levels filled
+// in are validated.
+//
+//	ToTopic("orders/{region}/created")  region=eu  -> orders/eu/created
+func ToTopic(template string) Sink {
+	schema, err := topics.New(template)
+	if err != nil {
+		return Sink{description: "topic " + template, err: err}
+	}
+	return ToSchema(schema)
+}
+
+// ToSibling publishes to the input topic with its last level replaced by level.
+func ToSibling(level string) Sink {
+	return Sink{
+		description: "topic <input>/../" + level,
+		err:         validateLevel(level),
+		topic: func(topic string, _ map[string]interface{}) (string, error) {
+			return topic[:strings.LastIndexByte(topic, '/')+1] + level, nil
+		},
+	}
+}
+
+// Rewrite publishes to the topic the output schema builds from the fields the
+// input schema parses from the input topic. Output fields the input topic does
+// not have are taken from the record properties of the same name.
+//
+//	Rewrite("acme/{region}/orders/{id}", "acme/{region}/invoices/{id}")
+//	acme/eu/orders/42 -> acme/eu/invoices/42
+func Rewrite(input, output string) Sink {
+	description := fmt.Sprintf("topic %s -> %s", input, output)
+	in, inErr := topics.New(input)
+	out, outErr := topics.New(output)
+	if err := errors.Join(inErr, outErr); err != nil {
+		return Sink{description: description, err: err}
+	}
+	return Sink{
+		description: description,
+		topic: func(topic string, properties map[string]interface{}) (string, error) {
+			parsed, err := in.Parse(topic)
+			if err != nil {
+				return "", err
+			}
+			return out.Name(fields(out, parsed, properties))
+		},
+	}
+}
+
+// ToSchema publishes to the topic schema builds from the record properties
+// named like its fields, so the levels are validated.
+func ToSchema(schema *topics.Schema) Sink {
+	return Sink{
+		description: "topic " + schema.String(),
+		topic: func(_ string, properties map[string]interface{}) (string, error) {
+			return schema.Name(fields(schema, nil, properties))
+		},
+	}
+}
+
+// ToQueue publishes to the named queue.
+func ToQueue(name string) Sink {
+	return Sink{
+		description: "queue " + name,
+		topic: func(string, map[string]interface{}) (string, error) {
+			return QueueTopicPrefix + name, nil
+		},
+	}
+}
+
+func (s Sink) String() string {
+	return s.description
+}
+
+// fields collects the values of the fields of schema from parsed, then from properties
+func fields(schema *topics.Schema, parsed topics.Fields, properties map[string]interface{}) topics.Fields {
+	values := topics.Fields{}
+	for _, name := range schema.Fields() {
+		if val, ok := parsed[name]; ok {
+			values[name] = val
+		} else if val, ok := properties[name]; ok && val != nil {
+			values[name] = fmt.Sprint(val)
+		}
+	}
+	return values
+}
+
+func validateLevel(level string) error {
+	if err := topics.ValidateLevel(level); err != nil {
+		return fmt.Errorf("topic level %q: %w", level, err)
+	}
+	return nil
+}
+
+// Instrumentation wraps the receivers and publishers a pipeline builds, it is
+// implemented by *metrics.Metrics.
+type Instrumentation interface {
+	DirectReceiver(receiver solace.DirectMessageReceiver) solace.DirectMessageReceiver
+	DirectPublisher(publisher solace.DirectMessagePublisher) solace.DirectMessagePublisher
+	PersistentReceiver(receiver solace.PersistentMessageReceiver) solace.PersistentMessageReceiver
+	PersistentPublisher(publisher solace.PersistentMessagePublisher) solace.PersistentMessagePublisher
+}
+
+// Option configures how a pipeline is started.
+type Option func(*options)
+
+type options struct {
+	instrumentation Instrumentation
+	workers         workers.Options
+}
+
+// WithInstrumentation wraps the receivers and publishers the pipeline builds.
+func WithInstrumentation(instrumentation Instrumentation) Option {
+	return func(o *options) {
+		o.instrumentation = instrumentation
+	}
+}
+
+// WithWorkers sets the worker pool of a queue source. By default a single
+// worker processes the inputs in the order they were spooled.
+func WithWorkers(opts workers.Options) Option {
+	return func(o *options) {
+		o.workers = opts
+	}
+}
+
+// Pipeline declares a processor. Decode, Stage, Encode and Sink are required.
+type Pipeline[In, Out any] struct {
+	Source Source
+	Decode Decoder[In]
+	Stage  Stage[In, Out]
+	Encode Encoder[Out]
+	Sink   Sink
+	// FailureTopic receives the inputs that could not be processed, see the package documentation.
+	FailureTopic string
+	// Properties names the user properties of an input that are copied to its
+	// records and its failure copy. Other properties are dropped, so that e.g.
+	// the key ID of a sealed input is not published again.
+	Properties []string
+}
+
+// Stats is a snapshot of the counters of a running pipeline.
+type Stats struct {
+	Received      uint64 // inputs consumed
+	Produced      uint64 // outputs handed to the publisher
+	Filtered      uint64 // inputs that produced no output
+	Failed        uint64 // inputs that could not be processed
+	PublishErrors uint64 // outputs the publisher or the broker rejected
+}
+
+func (s Stats) String() string {
+	return fmt.Sprintf("received=%d produced=%d filtered=%d failed=%d publish-errors=%d",
+		s.Received, s.Produced, s.Filtered, s.Failed, s.PublishErrors)
+}
+
+// counters are the Stats of a pipeline
+type counters struct {
+	received, produced, filtered, failed, publishErrors atomic.Uint64
+}
+
+func (c *counters) stats() Stats {
+	return Stats{
+		Received:      c.received.Load(),
+		Produced:      c.produced.Load(),
+		Filtered:      c.filtered.Load(),
+		Failed:        c.failed.Load(),
+		PublishErrors: c.publishErrors.Load(),
+	}
+}
+
+// Running is a started pipeline.
+type Running struct {
+	counters
+
+	pool      *workers.Pool
+	receiver  interface{ IsRunning() bool }
+	publisher interface{ IsReady() bool }
+	stop      func(gracePeriod time.Duration) error
+}
+
+// IsRunning reports whether the receiver of the pipeline is running.
+func (r *Running) IsRunning() bool {
+	return r.receiver != nil && r.receiver.IsRunning()
+}
+
+// IsReady reports whether the publisher of the pipeline can publish.
+func (r *Running) IsReady() bool {
+	return r.publisher != nil && r.publisher.IsReady()
+}
+
+// Stats returns the current counters.
+func (r *Running) Stats() Stats {
+	s := r.stats()
+	if r.pool != nil {
+		s.PublishErrors += r.pool.Stats().Rejected
+	}
+	return s
+}
+
+// Stop stops consuming, lets the inputs being processed finish within
+// gracePeriod and terminates the receiver and publisher.
+func (r *Running) Stop(gracePeriod time.Duration) error {
+	return r.stop(gracePeriod)
+}
+
+// Validate reports what is missing from the declaration.
+func (p Pipeline[In, Out]) Validate() error {
+	var errs []error
+	if len(p.Source.subscriptions) == 0 && p.Source.queue == nil {
+		errs = append(errs, errors.New("no source"))
+	}
+	if p.Decode == nil {
+		errs = append(errs, errors.New("no decoder"))
+	}
+	if p.Stage == nil {
+		errs = append(errs, errors.New("no stage"))
+	}
+	if p.Encode == nil {
+		errs = append(errs, errors.New("no encoder"))
+	}
+	switch {
+	case p.Sink.err != nil:
+		errs = append(errs, p.Sink.err)
+	case p.Sink.topic == nil:
+		errs = append(errs, errors.New("no sink"))
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("invalid pipeline: %w", err)
+	}
+	return nil
+}
+
+func (p Pipeline[In, Out]) String() string {
+	s := fmt.Sprintf("%s -> %s", p.Source, p.Sink)
+	if p.FailureTopic != "" {
+		s += ", failures -> topic " + p.FailureTopic
+	}
+	return s
+}
+
+// Start builds the receiver and publisher for the source on service and starts
+// consuming.
+func (p Pipeline[In, Out]) Start(service solace.MessagingService, opts ...Option) (*Running, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	o := options{workers: workers.Options{Workers: 1}}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if p.Source.queue != nil {
+		return p.startQueue(service, o)
+	}
+	return p.startDirect(service, o)
+}
+
+func (p Pipeline[In, Out]) startDirect(service solace.MessagingService, o options) (*Running, error) {
+	publisher, err := service.CreateDirectMessagePublisherBuilder().Build()
+	if err != nil {
+		return nil, err
+	}
+	receiver, err := service.CreateDirectMessageReceiverBuilder().
+		WithSubscriptions(p.Source.subscriptions...).
+		Build()
+	if err != nil {
+		return nil, err
+	}
+	if o.instrumentation != nil {
+		publisher = o.instrumentation.DirectPublisher(publisher)
+		receiver = o.instrumentation.DirectReceiver(receiver)
+	}
+	if err := publisher.Start(); err != nil {
+		return nil, err
+	}
+	r := &Running{receiver: receiver, publisher: publisher, stop: func(gracePeriod time.Duration) error {
+		return errors.Join(receiver.Terminate(gracePeriod), publisher.Terminate(gracePeriod))
+	}}
+
+	handler := func(msg message.InboundMessage) {
+		outputs, err := p.process(service, &r.counters, msg)
+		if err != nil {
+			// dropped, a direct message cannot be redelivered
+			return
+		}
+		for _, out := range outputs {
+			if err := publisher.Publish(out.Message, out.Destination); err != nil {
+				r.publishErrors.Add(1)
+			}
+		}
+	}
+	if err := receiver.Start(); err != nil {
+		publisher.Terminate(0)
+		return nil, err
+	}
+	if err := receiver.ReceiveAsync(handler); err != nil {
+		r.stop(0)
+		return nil, err
+	}
+	return r, nil
+}
+
+func (p Pipeline[In, Out]) startQueue(service solace.MessagingService, o options) (*Running, error) {
+	publisher, err := service.CreatePersistentMessagePublisherBuilder().Build()
+	if err != nil {
+		return nil, err
+	}
+	receiver, err := service.CreatePersistentMessageReceiverBuilder().
+		WithMissingResourcesCreationStrategy(config.PersistentReceiverCreateOnStartMissingResources).
+		WithSubscriptions(p.Source.subscriptions...).
+		WithMessageClientAcknowledgement().
+		WithRequiredMessageOutcomeSupport(config.PersistentReceiverFailedOutcome).
+		Build(p.Source.queue)
+	if err != nil {
+		return nil, err
+	}
+	if o.instrumentation != nil {
+		publisher = o.instrumentation.PersistentPublisher(publisher)
+		receiver = o.instrumentation.PersistentReceiver(receiver)
+	}
+
+	pool := workers.New(receiver, o.workers)
+	publisher.SetMessagePublishReceiptListener(pool.ReceiptListener(nil))
+	if err := publisher.Start(); err != nil {
+		return nil, err
+	}
+	r := &Running{pool: pool, receiver: receiver, publisher: publisher, stop: func(gracePeriod time.Duration) error {
+		receiver.Pause()
+		ctx, cancel := context.WithTimeout(context.Background(), gracePeriod)
+		drainErr := pool.Drain(ctx)
+		cancel()
+		// the inputs still queued are redelivered
+		err := receiver.Terminate(gracePeriod)
+		pool.Close()
+		return errors.Join(drainErr, err, publisher.Terminate(gracePeriod))
+	}}
+
+	if err := receiver.Start(); err != nil {
+		publisher.Terminate(0)
+		return nil, err
+	}
+	if err := pool.Start(publisher, func(msg message.InboundMessage) ([]workers.Output, error) {
+		return p.process(service, &r.counters, msg)
+	}); err != nil {
+		r.stop(0)
+		return nil, err
+	}
+	return r, nil
+}
+
+// Handler runs the stages, sink and failure topic of a pipeline for a worker
+// pool whose receiver and publisher are built by the caller, see Pipeline.Handler.
+type Handler struct {
+	counters
+	handle workers.Handler
+}
+
+// Handle returns the messages to publish for msg, it is a workers.Handler.
+func (h *Handler) Handle(msg message.InboundMessage) ([]workers.Output, error) {
+	return h.handle(msg)
+}
+
+// Stats returns the current counters. PublishErrors stays zero, publishing is
+// up to the caller.
+func (h *Handler) Stats() Stats {
+	return h.stats()
+}
+
+// Handler returns the stages, sink and failure topic of the pipeline as a
+// worker pool handler, e.g. to publish through a retry.Publisher. The source is
+// not used.
+func (p Pipeline[In, Out]) Handler(service solace.MessagingService) *Handler {
+	h := &Handler{}
+	h.handle = func(msg message.InboundMessage) ([]workers.Output, error) {
+		return p.process(service, &h.counters, msg)
+	}
+	return h
+}
+
+// process runs the stages on msg and returns the messages to publish, which is
+// the input itself on the failure topic when it could not be processed
+func (p Pipeline[In, Out]) process(service solace.MessagingService, r *counters, msg message.InboundMessage) ([]workers.Output, error) {
+	r.received.Add(1)
+	outputs, err := p.outputs(service, msg)
+	if err != nil {
+		r.failed.Add(1)
+		if p.FailureTopic == "" {
+			return nil, err
+		}
+		failure, buildErr := p.failure(service, msg, err)
+		if buildErr != nil {
+			return nil, errors.Join(err, buildErr)
+		}
+		return []workers.Output{failure}, nil
+	}
+	if len(outputs) == 0 {
+		r.filtered.Add(1)
+	}
+	r.produced.Add(uint64(len(outputs)))
+	return outputs, nil
+}
+
+func (p Pipeline[In, Out]) outputs(service solace.MessagingService, msg message.InboundMessage) ([]workers.Output, error) {
+	if p.Sink.err != nil {
+		return nil, &StageError{Step: "topic", Err: p.Sink.err}
+	}
+	value, err := p.Decode(payload(msg))
+	if err != nil {
+		return nil, &StageError{Step: "decode", Err: err}
+	}
+	records, err := p.Stage(Record[In]{Value: value, Topic: msg.GetDestinationName(), Properties: p.properties(msg)})
+	if err != nil {
+		return nil, &StageError{Step: "stage", Err: err}
+	}
+	outputs := make([]workers.Output, 0, len(records))
+	for _, rec := range records {
+		body, err := p.Encode(rec.Value)
+		if err != nil {
+			return nil, &StageError{Step: "encode", Err: err}
+		}
+		topic, err := p.Sink.topic(rec.Topic, rec.Properties)
+		if err != nil {
+			return nil, &StageError{Step: "topic", Err: err}
+		}
+		props := config.MessagePropertyMap{}
+		for key, val := range rec.Properties {
+			props[config.MessageProperty(key)] = val
+		}
+		// string values go out as string payloads, like the samples publish them
+		_, text := any(rec.Value).(string)
+		out, err := build(service.MessageBuilder().FromConfigurationProvider(props), body, text)
+		if err != nil {
+			return nil, &StageError{Step: "encode", Err: err}
+		}
+		outputs = append(outputs, workers.Output{Message: out, Destination: resource.TopicOf(topic)})
+	}
+	return outputs, nil
+}
+
+// failure builds a copy of msg for the failure topic with the reason as user properties
+func (p Pipeline[In, Out]) failure(service solace.MessagingService, msg message.InboundMessage, cause error) (workers.Output, error) {
+	props := config.MessagePropertyMap{}
+	for key, val := range p.properties(msg) {
+		props[config.MessageProperty(key)] = val
+	}
+	props[PropertyError] = cause.Error()
+	props[PropertyOriginalTopic] = msg.GetDestinationName()
+	_, text := msg.GetPayloadAsString()
+	out, err := build(service.MessageBuilder().FromConfigurationProvider(props), payload(msg), text)
+	if err != nil {
+		return workers.Output{}, err
+	}
+	return workers.Output{Message: out, Destination: resource.TopicOf(p.FailureTopic)}, nil
+}
+
+// build builds a message with a string payload when text is set, a binary one otherwise
+func build(builder solace.OutboundMessageBuilder, body []byte, text bool) (message.OutboundMessage, error) {
+	if text {
+		return builder.BuildWithStringPayload(string(body))
+	}
+	return builder.BuildWithByteArrayPayload(body)
+}
+
+func payload(msg message.InboundMessage) []byte {
+	if body, ok := msg.GetPayloadAsBytes(); ok {
+		return body
+	}
+	if body, ok := msg.GetPayloadAsString(); ok {
+		return []byte(body)
+	}
+	return nil
+}
+
+// properties returns the user properties of msg named in p.Properties
+func (p Pipeline[In, Out]) properties(msg message.InboundMessage) map[string]interface{} {
+	props := map[string]interface{}{}
+	for _, name := range p.Properties {
+		if val, ok := msg.GetProperty(name); ok {
+			props[name] = val
+		}
+	}
+	return props
+}
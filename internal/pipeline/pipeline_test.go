@@ -0,0 +1,306 @@
+package pipeline
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"solace.dev/go/messaging/pkg/solace/message"
+	"solace.dev/go/messaging/pkg/solace/resource"
+
+	"SolaceSamples.com/PubSub+Go/internal/fake"
+	"SolaceSamples.com/PubSub+Go/internal/topics"
+)
+
+func TestSinks(t *testing.T) {
+	tests := []struct {
+		name       string
+		sink       Sink
+		topic      string
+		properties map[string]interface{}
+		want       string
+		wantErr    error
+	}{
+		{"sibling", ToSibling("output"), "solace/samples/input", nil, "solace/samples/output", nil},
+		{"sibling of a single level", ToSibling("output"), "input", nil, "output", nil},
+		{"template", ToTopic("orders/{region}/created"), "a/b", map[string]interface{}{"region": "eu"}, "orders/eu/created", nil},
+		{"template with a number", ToTopic("orders/{id}"), "a", map[string]interface{}{"id": 42}, "orders/42", nil},
+		{"fixed topic", ToTopic("fixed/topic"), "a/b", nil, "fixed/topic", nil},
+		{"missing property", ToTopic("orders/{region}"), "a", nil, "", topics.ErrMissingField},
+		{"nil property", ToTopic("orders/{region}"), "a", map[string]interface{}{"region": nil}, "", topics.ErrMissingField},
+		{"invalid level", ToTopic("orders/{region}"), "a", map[string]interface{}{"region": "eu/west"}, "", topics.ErrLevelChars},
+		{"schema", ToSchema(topics.MustNew("acme/{app}/{id}")), "a", map[string]interface{}{"app": "shop", "id": "7"}, "acme/shop/7", nil},
+		{"rewrite", Rewrite("acme/{region}/orders/{id}", "acme/{region}/invoices/{id}"), "acme/eu/orders/42", nil, "acme/eu/invoices/42", nil},
+		{"rewrite from a property", Rewrite("acme/{region}/orders", "acme/{region}/{kind}"), "acme/eu/orders",
+			map[string]interface{}{"kind": "refunds"}, "acme/eu/refunds", nil},
+		{"rewrite prefers the topic", Rewrite("acme/{region}/orders", "acme/{region}/invoices"), "acme/eu/orders",
+			map[string]interface{}{"region": "us"}, "acme/eu/invoices", nil},
+		{"queue", ToQueue("orders"), "a/b", nil, QueueTopicPrefix + "orders", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.sink.err != nil {
+				t.Fatalf("%s: %v", tt.sink, tt.sink.err)
+			}
+			got, err := tt.sink.topic(tt.topic, tt.properties)
+			if got != tt.want || tt.wantErr == nil && err != nil || tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("%s on %s = %q, %v, want %q, %v", tt.sink, tt.topic, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := Rewrite("acme/{region}/orders", "acme/{region}/invoices").topic("acme/eu/returns", nil); err == nil {
+		t.Error("rewrote a topic the input schema does not match")
+	}
+	for _, sink := range []Sink{ToTopic("a/{0"), ToTopic("a/{}/b"), ToTopic("a/{id}/{id}"), ToTopic("a/*"),
+		Rewrite("a/{", "b"), Rewrite("a", "b/{x}{y}"), ToSibling("out/put"), ToSibling("")} {
+		if sink.err == nil {
+			t.Errorf("%s accepted", sink)
+		}
+	}
+}
+
+// uppercase is the pipeline of the processor samples
+func uppercase() Pipeline[string, string] {
+	return Pipeline[string, string]{
+		Source: Subscription("solace/samples/direct/processor/input"),
+		Decode: DecodeString,
+		Stage: Steps(
+			Filter(func(s string) bool { return s != "skip" }),
+			Map(func(s string) (string, error) {
+				if s == "fail" {
+					return "", errors.New("cannot uppercase fail")
+				}
+				return strings.ToUpper(s), nil
+			}),
+		),
+		Encode:       EncodeString,
+		Sink:         ToSibling("output"),
+		FailureTopic: "solace/samples/direct/processor/failed",
+		Properties:   []string{"region"},
+	}
+}
+
+func TestHandler(t *testing.T) {
+	service := fake.New()
+	if err := service.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer service.Disconnect()
+
+	tests := []struct {
+		name      string
+		payload   string
+		wantTopic string
+		wantBody  string
+		wantError string
+	}{
+		{"processed", "hello", "solace/samples/direct/processor/output", "HELLO", ""},
+		{"filtered", "skip", "", "", ""},
+		{"failed", "fail", "solace/samples/direct/processor/failed", "fail", "pipeline stage: cannot uppercase fail"},
+	}
+	handler := uppercase().Handler(service)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := fake.NewInboundMessage("solace/samples/direct/processor/input", tt.payload, map[string]interface{}{
+				"region":         "eu",
+				"payload_key_id": "k1",
+			})
+			outputs, err := handler.Handle(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantTopic == "" {
+				if len(outputs) != 0 {
+					t.Errorf("%d outputs for a filtered input", len(outputs))
+				}
+				return
+			}
+			if len(outputs) != 1 {
+				t.Fatalf("%d outputs, want 1", len(outputs))
+			}
+			out := outputs[0]
+			if out.Destination.GetName() != tt.wantTopic {
+				t.Errorf("topic %s, want %s", out.Destination.GetName(), tt.wantTopic)
+			}
+			msg := out.Message.(*fake.Message)
+			if body, ok := msg.GetPayloadAsString(); !ok || body != tt.wantBody {
+				t.Errorf("string payload %q, %v, want %q", body, ok, tt.wantBody)
+			}
+			if region, _ := msg.GetProperty("region"); region != "eu" {
+				t.Errorf("property region = %v, want it carried over", region)
+			}
+			if msg.HasProperty("payload_key_id") {
+				t.Error("property payload_key_id carried over without being allowed")
+			}
+			errProp, _ := msg.GetProperty(PropertyError)
+			if tt.wantError == "" && errProp != nil || tt.wantError != "" && errProp != tt.wantError {
+				t.Errorf("property %s = %v, want %q", PropertyError, errProp, tt.wantError)
+			}
+			if tt.wantError != "" {
+				if original, _ := msg.GetProperty(PropertyOriginalTopic); original != "solace/samples/direct/processor/input" {
+					t.Errorf("property %s = %v", PropertyOriginalTopic, original)
+				}
+			}
+		})
+	}
+
+	if stats := handler.Stats(); stats.Received != 3 || stats.Produced != 1 || stats.Filtered != 1 || stats.Failed != 1 {
+		t.Errorf("stats %s", stats)
+	}
+
+	// without a failure topic the error is returned, so a queue input is redelivered
+	withoutFailureTopic := uppercase()
+	withoutFailureTopic.FailureTopic = ""
+	var stageErr *StageError
+	if _, err := withoutFailureTopic.Handler(service).Handle(fake.NewInboundMessage("a/input", "fail", nil)); !errors.As(err, &stageErr) || stageErr.Step != "stage" {
+		t.Errorf("error %v, want a stage error", err)
+	}
+}
+
+func TestBinaryPayloads(t *testing.T) {
+	broker := fake.NewBroker()
+	service := broker.NewService()
+	if err := service.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer service.Disconnect()
+
+	// a binary input, as received from a publisher of bytes
+	receiver, _ := service.CreateDirectMessageReceiverBuilder().WithSubscriptions(resource.TopicSubscriptionOf("a/>")).Build()
+	publisher, _ := service.CreateDirectMessagePublisherBuilder().Build()
+	if err := receiver.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer receiver.Terminate(0)
+	if err := publisher.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer publisher.Terminate(0)
+	if err := publisher.PublishBytes([]byte("fail"), resource.TopicOf("a/input")); err != nil {
+		t.Fatal(err)
+	}
+	input, err := receiver.ReceiveMessage(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// binary outputs stay binary
+	bytesPipeline := Pipeline[[]byte, []byte]{
+		Decode: DecodeBytes,
+		Stage:  Map(func(b []byte) ([]byte, error) { return append(b, '!'), nil }),
+		Encode: EncodeBytes,
+		Sink:   ToSibling("output"),
+	}
+	outputs, err := bytesPipeline.Handler(service).Handle(input)
+	if err != nil || len(outputs) != 1 {
+		t.Fatalf("outputs %v, %v", outputs, err)
+	}
+	assertBinary(t, outputs[0].Message, "fail!")
+
+	// the failure passthrough keeps the binary payload of the input
+	outputs, err = uppercase().Handler(service).Handle(input)
+	if err != nil || len(outputs) != 1 {
+		t.Fatalf("outputs %v, %v", outputs, err)
+	}
+	assertBinary(t, outputs[0].Message, "fail")
+}
+
+func assertBinary(t *testing.T, msg message.OutboundMessage, want string) {
+	t.Helper()
+	if _, ok := msg.GetPayloadAsString(); ok {
+		t.Error("binary payload published as a string")
+	}
+	if body, ok := msg.GetPayloadAsBytes(); !ok || string(body) != want {
+		t.Errorf("payload %q, %v, want %q", body, ok, want)
+	}
+}
+
+func TestStartDirect(t *testing.T) {
+	broker := fake.NewBroker()
+	processorService := broker.NewService()
+	clientService := broker.NewService()
+	for _, service := range []*fake.Service{processorService, clientService} {
+		if err := service.Connect(); err != nil {
+			t.Fatal(err)
+		}
+		defer service.Disconnect()
+	}
+
+	running, err := uppercase().Start(processorService)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer running.Stop(0)
+	if !running.IsRunning() || !running.IsReady() {
+		t.Errorf("started pipeline running=%v ready=%v", running.IsRunning(), running.IsReady())
+	}
+
+	receiver, _ := clientService.CreateDirectMessageReceiverBuilder().
+		WithSubscriptions(resource.TopicSubscriptionOf("solace/samples/direct/processor/*")).
+		Build()
+	if err := receiver.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer receiver.Terminate(0)
+	publisher, _ := clientService.CreateDirectMessagePublisherBuilder().Build()
+	if err := publisher.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer publisher.Terminate(0)
+
+	want := map[string]string{
+		"hello": "solace/samples/direct/processor/output",
+		"fail":  "solace/samples/direct/processor/failed",
+	}
+	for _, payload := range []string{"hello", "skip", "fail"} {
+		if err := publisher.PublishString(payload, resource.TopicOf("solace/samples/direct/processor/input")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the receiver also gets the inputs themselves
+	got := map[string]string{}
+	for len(got) < len(want) {
+		msg, err := receiver.ReceiveMessage(time.Second)
+		if err != nil {
+			t.Fatalf("got %v: %v", got, err)
+		}
+		if msg.GetDestinationName() == "solace/samples/direct/processor/input" {
+			continue
+		}
+		body, _ := msg.GetPayloadAsString()
+		got[strings.ToLower(body)] = msg.GetDestinationName()
+	}
+	for payload, topic := range want {
+		if got[payload] != topic {
+			t.Errorf("%s published to %q, want %s", payload, got[payload], topic)
+		}
+	}
+	if stats := running.Stats(); stats.Received != 3 || stats.Produced != 1 || stats.Filtered != 1 || stats.Failed != 1 {
+		t.Errorf("stats %s", stats)
+	}
+
+	if err := running.Stop(time.Second); err != nil || running.IsRunning() || running.IsReady() {
+		t.Errorf("stopped pipeline running=%v ready=%v: %v", running.IsRunning(), running.IsReady(), err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := uppercase().Validate(); err != nil {
+		t.Errorf("valid pipeline: %v", err)
+	}
+	empty := Pipeline[string, string]{Sink: ToTopic("a/{")}
+	err := empty.Validate()
+	if err == nil {
+		t.Fatal("empty pipeline accepted")
+	}
+	if strings.Contains(err.Error(), "no sink") {
+		t.Errorf("%q reports an invalid sink as missing", err)
+	}
+	for _, missing := range []string{"source", "decoder", "stage", "encoder", "must fill the whole level"} {
+		if !strings.Contains(err.Error(), missing) {
+			t.Errorf("%q does not mention the %s", err, missing)
+		}
+	}
+}
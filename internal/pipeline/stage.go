@@ -0,0 +1,156 @@
+package pipeline
+
+import (
+	"fmt"
+)
+
+// Record is a value flowing through the stages together with the topic its
+// input message arrived on and the user properties the output is published with.
+type Record[T any] struct {
+	Value T
+	// Topic is the topic of the input message.
+	Topic string
+	// Properties start as the user properties of the input message and are
+	// published with the output.
+	Properties map[string]interface{}
+}
+
+// WithValue returns a copy of r that carries value, sharing nothing mutable with r.
+func WithValue[In, Out any](r Record[In], value Out) Record[Out] {
+	properties := make(map[string]interface{}, len(r.Properties))
+	for key, val := range r.Properties {
+		properties[key] = val
+	}
+	return Record[Out]{Value: value, Topic: r.Topic, Properties: properties}
+}
+
+// Stage turns a record into zero or more records. Returning no records drops
+// the input, returning an error sends it to the failure topic. Stages are plain
+// functions of their input, so each one can be exercised without a broker.
+type Stage[In, Out any] func(rec Record[In]) ([]Record[Out], error)
+
+// Filter keeps the records keep returns true for.
+func Filter[T any](keep func(value T) bool) Stage[T, T] {
+	return func(rec Record[T]) ([]Record[T], error) {
+		if !keep(rec.Value) {
+			return nil, nil
+		}
+		return []Record[T]{rec}, nil
+	}
+}
+
+// Map replaces the value of every record with the result of fn.
+func Map[In, Out any](fn func(value In) (Out, error)) Stage[In, Out] {
+	return func(rec Record[In]) ([]Record[Out], error) {
+		out, err := fn(rec.Value)
+		if err != nil {
+			return nil, err
+		}
+		return []Record[Out]{WithValue(rec, out)}, nil
+	}
+}
+
+// Enrich lets fn change the value and properties of every record in place,
+// e.g. to add properties looked up from elsewhere.
+func Enrich[T any](fn func(rec *Record[T]) error) Stage[T, T] {
+	return func(rec Record[T]) ([]Record[T], error) {
+		enriched := WithValue(rec, rec.Value)
+		if err := fn(&enriched); err != nil {
+			return nil, err
+		}
+		return []Record[T]{enriched}, nil
+	}
+}
+
+// Split turns every record into one record per value returned by fn.
+func Split[In, Out any](fn func(value In) ([]Out, error)) Stage[In, Out] {
+	return func(rec Record[In]) ([]Record[Out], error) {
+		values, err := fn(rec.Value)
+		if err != nil {
+			return nil, err
+		}
+		out := make([]Record[Out], 0, len(values))
+		for _, val := range values {
+			out = append(out, WithValue(rec, val))
+		}
+		return out, nil
+	}
+}
+
+// Then runs second on every record produced by first.
+func Then[A, B, C any](first Stage[A, B], second Stage[B, C]) Stage[A, C] {
+	return func(rec Record[A]) ([]Record[C], error) {
+		mid, err := first(rec)
+		if err != nil {
+			return nil, err
+		}
+		var out []Record[C]
+		for _, m := range mid {
+			next, err := second(m)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, next...)
+		}
+		return out, nil
+	}
+}
+
+// Steps runs stages that keep the value type one after another.
+func Steps[T any](stages ...Stage[T, T]) Stage[T, T] {
+	return func(rec Record[T]) ([]Record[T], error) {
+		records := []Record[T]{rec}
+		for _, stage := range stages {
+			var next []Record[T]
+			for _, r := range records {
+				out, err := stage(r)
+				if err != nil {
+					return nil, err
+				}
+				next = append(next, out...)
+			}
+			records = next
+		}
+		return records, nil
+	}
+}
+
+// Decoder turns the payload of an input message into the value of its record.
+type Decoder[T any] func(payload []byte) (T, error)
+
+// Encoder turns the value of an output record into its payload.
+type Encoder[T any] func(value T) ([]byte, error)
+
+// DecodeString decodes the payload as text.
+func DecodeString(payload []byte) (string, error) {
+	return string(payload), nil
+}
+
+// EncodeString encodes a text payload.
+func EncodeString(value string) ([]byte, error) {
+	return []byte(value), nil
+}
+
+// DecodeBytes passes the payload on unchanged.
+func DecodeBytes(payload []byte) ([]byte, error) {
+	return payload, nil
+}
+
+// EncodeBytes passes the payload on unchanged.
+func EncodeBytes(value []byte) ([]byte, error) {
+	return value, nil
+}
+
+// StageError tells which part of a pipeline failed on a record.
+type StageError struct {
+	Step string // decode, stage, encode, topic or publish
+	Err  error
+}
+
+func (e *StageError) Error() string {
+	return fmt.Sprintf("pipeline %s: %v", e.Step, e.Err)
+}
+
+func (e *StageError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,118 @@
+package pipeline
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// values returns the values of records
+func values[T any](records []Record[T]) []T {
+	out := make([]T, 0, len(records))
+	for _, rec := range records {
+		out = append(out, rec.Value)
+	}
+	return out
+}
+
+func TestStages(t *testing.T) {
+	failing := errors.New("stage failed")
+	atoi := Map(strconv.Atoi)
+	double := Map(func(n int) (int, error) { return 2 * n, nil })
+	words := Split(func(s string) ([]string, error) { return strings.Fields(s), nil })
+	nonEmpty := Filter(func(s string) bool { return s != "" })
+	upper := Map(func(s string) (string, error) { return strings.ToUpper(s), nil })
+	fail := Map(func(string) (string, error) { return "", failing })
+	tag := Enrich(func(rec *Record[string]) error {
+		rec.Properties["length"] = len(rec.Value)
+		return nil
+	})
+
+	tests := []struct {
+		name    string
+		stage   Stage[string, string]
+		input   string
+		want    []string
+		wantErr error
+	}{
+		{"map", upper, "hello", []string{"HELLO"}, nil},
+		{"map error", fail, "hello", nil, failing},
+		{"filter keeps", nonEmpty, "hello", []string{"hello"}, nil},
+		{"filter drops", nonEmpty, "", []string{}, nil},
+		{"split", words, "a b  c", []string{"a", "b", "c"}, nil},
+		{"split nothing", words, "  ", []string{}, nil},
+		{"enrich keeps the value", tag, "hello", []string{"hello"}, nil},
+		{"then", Then(words, upper), "a b", []string{"A", "B"}, nil},
+		{"then error", Then(words, fail), "a b", nil, failing},
+		{"steps", Steps(words, nonEmpty, upper), "a b", []string{"A", "B"}, nil},
+		{"steps drop", Steps(nonEmpty, upper), "", []string{}, nil},
+		{"steps error stops", Steps(fail, upper), "a", nil, failing},
+		{"steps of none", Steps[string](), "a", []string{"a"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := tt.stage(Record[string]{Value: tt.input, Topic: "a/b", Properties: map[string]interface{}{}})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := values(records); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("values %q, want %q", got, tt.want)
+			}
+			for _, rec := range records {
+				if rec.Topic != "a/b" {
+					t.Errorf("topic %q not carried over", rec.Topic)
+				}
+			}
+		})
+	}
+
+	// stages changing the value type
+	records, err := Then(atoi, double)(Record[string]{Value: "21"})
+	if err != nil || !reflect.DeepEqual(values(records), []int{42}) {
+		t.Errorf("Then(atoi, double) = %v, %v", values(records), err)
+	}
+	if _, err := atoi(Record[string]{Value: "x"}); err == nil {
+		t.Error("no error from a failing Map")
+	}
+}
+
+func TestEnrichCopiesProperties(t *testing.T) {
+	input := Record[string]{Value: "hello", Properties: map[string]interface{}{"region": "eu"}}
+	records, err := Enrich(func(rec *Record[string]) error {
+		rec.Properties["region"] = "us"
+		rec.Properties["length"] = len(rec.Value)
+		rec.Value = "changed"
+		return nil
+	})(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := records[0]; got.Value != "changed" || got.Properties["region"] != "us" || got.Properties["length"] != 5 {
+		t.Errorf("enriched record %+v", got)
+	}
+	if input.Properties["region"] != "eu" || len(input.Properties) != 1 {
+		t.Errorf("input properties changed to %v", input.Properties)
+	}
+
+	failing := errors.New("lookup failed")
+	if _, err := Enrich(func(*Record[string]) error { return failing })(input); !errors.Is(err, failing) {
+		t.Errorf("error %v, want %v", err, failing)
+	}
+}
+
+func TestSplitCopiesProperties(t *testing.T) {
+	records, err := Split(func(s string) ([]string, error) { return strings.Split(s, ","), nil })(
+		Record[string]{Value: "a,b", Properties: map[string]interface{}{"n": 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	records[0].Properties["n"] = 2
+	if records[1].Properties["n"] != 1 {
+		t.Error("split records share their properties")
+	}
+}
@@ -0,0 +1,143 @@
+// Package provision reconciles the queues on a broker with a declarative YAML
+// manifest.
+//
+// The endpoint provisioner can only tell whether a queue was created, already
+// exists with the same properties, or exists with different ones; it cannot
+// read a queue's properties back. Diffs are therefore computed against a state
+// file recording what was last applied, and queues with mismatching properties
+// are reported rather than changed.
+package provision
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+	"solace.dev/go/messaging/pkg/solace/config"
+)
+
+// Manifest is the desired set of queues.
+type Manifest struct {
+	Queues []Queue `yaml:"queues" json:"queues"`
+}
+
+// Queue describes one queue. Optional settings left out of the manifest keep
+// the broker defaults.
+type Queue struct {
+	Name           string   `yaml:"name" json:"name"`
+	Durable        *bool    `yaml:"durable,omitempty" json:"durable,omitempty"`
+	Exclusive      bool     `yaml:"exclusive" json:"exclusive"`
+	Permission     string   `yaml:"permission,omitempty" json:"permission,omitempty"`
+	QuotaMB        *uint    `yaml:"quotaMB,omitempty" json:"quotaMB,omitempty"`
+	MaxMessageSize *uint    `yaml:"maxMessageSize,omitempty" json:"maxMessageSize,omitempty"`
+	MaxRedelivery  *uint    `yaml:"maxRedelivery,omitempty" json:"maxRedelivery,omitempty"`
+	RespectTTL     *bool    `yaml:"respectTTL,omitempty" json:"respectTTL,omitempty"`
+	NotifySender   *bool    `yaml:"notifySender,omitempty" json:"notifySender,omitempty"`
+	Subscriptions  []string `yaml:"subscriptions,omitempty" json:"subscriptions,omitempty"`
+}
+
+// permissions maps the manifest spelling to the API constant
+var permissions = map[string]config.EndpointPermission{
+	"none":         config.EndpointPermissionNone,
+	"read-only":    config.EndpointPermissionReadOnly,
+	"consume":      config.EndpointPermissionConsume,
+	"modify-topic": config.EndpointPermissionModifyTopic,
+	"delete":       config.EndpointPermissionDelete,
+}
+
+// LoadManifest reads and validates a YAML manifest.
+func LoadManifest(path string) (Manifest, error) {
+	var m Manifest
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return m, fmt.Errorf("reading manifest: %w", err)
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&m); err != nil {
+		return m, fmt.Errorf("parsing manifest %s: %w", path, err)
+	}
+	return m, m.Validate()
+}
+
+// Validate checks for missing or duplicate names and unknown permissions.
+func (m Manifest) Validate() error {
+	var errs []error
+	seen := make(map[string]bool, len(m.Queues))
+	for i, q := range m.Queues {
+		switch {
+		case q.Name == "":
+			errs = append(errs, fmt.Errorf("queue %d: name is required", i))
+		case seen[q.Name]:
+			errs = append(errs, fmt.Errorf("queue %s: defined more than once", q.Name))
+		}
+		seen[q.Name] = true
+		if _, ok := permissions[q.Permission]; q.Permission != "" && !ok {
+			errs = append(errs, fmt.Errorf("queue %s: unknown permission %q", q.Name, q.Permission))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// IsDurable reports whether the queue is durable, which is the default.
+func (q Queue) IsDurable() bool {
+	return q.Durable == nil || *q.Durable
+}
+
+// Properties returns the endpoint properties to provision the queue with.
+func (q Queue) Properties() config.EndpointPropertyMap {
+	props := config.EndpointPropertyMap{
+		config.EndpointPropertyDurable:   q.IsDurable(),
+		config.EndpointPropertyExclusive: q.Exclusive,
+	}
+	if q.Permission != "" {
+		props[config.EndpointPropertyPermission] = permissions[q.Permission]
+	}
+	if q.QuotaMB != nil {
+		props[config.EndpointPropertyQuotaMB] = *q.QuotaMB
+	}
+	if q.MaxMessageSize != nil {
+		props[config.EndpointPropertyMaxMessageSize] = *q.MaxMessageSize
+	}
+	if q.MaxRedelivery != nil {
+		props[config.EndpointPropertyMaxMessageRedelivery] = *q.MaxRedelivery
+	}
+	if q.RespectTTL != nil {
+		props[config.EndpointPropertyRespectsTTL] = *q.RespectTTL
+	}
+	if q.NotifySender != nil {
+		props[config.EndpointPropertyNotifySender] = *q.NotifySender
+	}
+	return props
+}
+
+// fields returns the properties as printable values for diffing, unset
+// optional settings are reported as "default"
+func (q Queue) fields() [][2]string {
+	return [][2]string{
+		{"durable", fmt.Sprint(q.IsDurable())},
+		{"exclusive", fmt.Sprint(q.Exclusive)},
+		{"permission", orDefault(q.Permission)},
+		{"quotaMB", optional(q.QuotaMB)},
+		{"maxMessageSize", optional(q.MaxMessageSize)},
+		{"maxRedelivery", optional(q.MaxRedelivery)},
+		{"respectTTL", optional(q.RespectTTL)},
+		{"notifySender", optional(q.NotifySender)},
+	}
+}
+
+func orDefault(s string) string {
+	if s == "" {
+		return "default"
+	}
+	return s
+}
+
+func optional[T any](v *T) string {
+	if v == nil {
+		return "default"
+	}
+	return fmt.Sprint(*v)
+}
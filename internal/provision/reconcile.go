@@ -0,0 +1,298 @@
+package provision
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"solace.dev/go/messaging/pkg/solace"
+	"solace.dev/go/messaging/pkg/solace/resource"
+	"solace.dev/go/messaging/pkg/solace/subcode"
+)
+
+// Action is what reconciling does, or would do, to a queue.
+type Action string
+
+const (
+	ActionCreate    Action = "create"
+	ActionUnchanged Action = "unchanged"
+	ActionMismatch  Action = "mismatch" // exists with other properties, left as is
+	ActionDelete    Action = "delete"
+	ActionOrphan    Action = "orphan" // applied before but no longer in the manifest, kept without prune
+)
+
+// Diff is one property that differs from the last applied state.
+type Diff struct {
+	Field string
+	From  string
+	To    string
+}
+
+// Change is the plan, and after Apply the outcome, for one queue.
+type Change struct {
+	Queue               string
+	Action              Action
+	Diffs               []Diff
+	AddSubscriptions    []string
+	RemoveSubscriptions []string
+	Note                string
+	Err                 error
+
+	spec Queue
+}
+
+// Plan compares the manifest with the last applied state without contacting
+// the broker. Queues that are in the state but not in the manifest are deleted
+// when prune is set and reported as orphans otherwise.
+func Plan(m Manifest, state State, prune bool) []Change {
+	var changes []Change
+	inManifest := make(map[string]bool, len(m.Queues))
+	for _, q := range m.Queues {
+		inManifest[q.Name] = true
+		c := Change{Queue: q.Name, spec: q}
+		applied, ok := state.Queues[q.Name]
+		if !ok {
+			c.Action = ActionCreate
+			for _, f := range q.fields() {
+				c.Diffs = append(c.Diffs, Diff{Field: f[0], To: f[1]})
+			}
+			c.AddSubscriptions = q.Subscriptions
+			changes = append(changes, c)
+			continue
+		}
+		c.Action = ActionUnchanged
+		c.Diffs = diff(applied, q)
+		if len(c.Diffs) > 0 {
+			c.Action = ActionMismatch
+			c.Note = "the broker cannot update queue properties, delete and re-create the queue to apply them"
+		}
+		c.AddSubscriptions = missing(q.Subscriptions, applied.Subscriptions)
+		c.RemoveSubscriptions = missing(applied.Subscriptions, q.Subscriptions)
+		changes = append(changes, c)
+	}
+	for _, name := range state.names() {
+		if inManifest[name] {
+			continue
+		}
+		c := Change{Queue: name, Action: ActionOrphan, Note: "not in the manifest, pass -prune to delete it", spec: state.Queues[name]}
+		if prune {
+			c.Action = ActionDelete
+			c.Note = ""
+		}
+		changes = append(changes, c)
+	}
+	return changes
+}
+
+// Apply provisions the manifest on the broker and returns the outcome of every
+// change together with the new state to save. The broker has the final say:
+// a planned create of a queue that already exists becomes unchanged or
+// mismatch, depending on whether its properties agree with the manifest.
+func Apply(service solace.MessagingService, m Manifest, state State, prune bool) ([]Change, State) {
+	changes := Plan(m, state, prune)
+	next := State{AppliedAt: time.Now().UTC(), Queues: map[string]Queue{}}
+	for name, q := range state.Queues {
+		next.Queues[name] = q
+	}
+
+	for i := range changes {
+		c := &changes[i]
+		switch c.Action {
+		case ActionDelete:
+			if c.Err = service.EndpointProvisioner().Deprovision(c.Queue, true); c.Err == nil {
+				delete(next.Queues, c.Queue)
+			}
+			continue
+		case ActionOrphan:
+			continue
+		}
+
+		outcome := service.EndpointProvisioner().FromConfigurationProvider(c.spec.Properties()).Provision(c.Queue, false)
+		err := outcome.GetError()
+		applied, known := state.Queues[c.Queue]
+		switch {
+		case err == nil:
+			c.Action = ActionCreate
+			applied = Queue{}
+		case hasSubcode(err, subcode.EndpointAlreadyExists):
+			c.Action = ActionUnchanged
+			c.Diffs = nil
+			c.Note = ""
+		case hasSubcode(err, subcode.EndpointPropertyMismatch):
+			c.Action = ActionMismatch
+			if !known || len(c.Diffs) == 0 {
+				c.Diffs = nil
+				c.Note = "the queue exists with properties that differ from the manifest, it was changed outside this tool"
+			}
+		default:
+			c.Err = err
+			continue
+		}
+
+		if c.Action == ActionCreate || c.Action == ActionUnchanged && !known {
+			// everything in the manifest may be missing on the broker
+			c.AddSubscriptions = c.spec.Subscriptions
+			c.RemoveSubscriptions = nil
+		}
+		subscriptions, subErr := applySubscriptions(service, c.spec, c.AddSubscriptions, c.RemoveSubscriptions, applied.Subscriptions)
+		c.Err = subErr
+
+		record := c.spec
+		if c.Action == ActionMismatch {
+			if !known {
+				// the broker properties are unknown, leave them out of the state
+				continue
+			}
+			// the broker still has the previously applied properties
+			record = applied
+		}
+		record.Subscriptions = subscriptions
+		next.Queues[c.Queue] = record
+	}
+	return changes, next
+}
+
+// activationTimeout is how long applySubscriptions waits to become the active
+// consumer of an exclusive queue
+var activationTimeout = 2 * time.Second
+
+// applySubscriptions binds to the queue, adds the new subscriptions and removes the
+// stale ones. It returns the subscriptions the queue is known to have afterwards.
+//
+// Only one consumer of an exclusive queue is active, the binding used here stays
+// passive while an application is consuming and no subscriptions are changed then.
+func applySubscriptions(service solace.MessagingService, q Queue, add, remove, current []string) ([]string, error) {
+	if len(add) == 0 && len(remove) == 0 {
+		return current, nil
+	}
+	if !q.IsDurable() {
+		return current, fmt.Errorf("queue %s is not durable, its subscriptions would not outlive this session", q.Name)
+	}
+
+	builder := service.CreatePersistentMessageReceiverBuilder()
+	queue := resource.QueueDurableNonExclusive(q.Name)
+	active := make(chan struct{})
+	if q.Exclusive {
+		queue = resource.QueueDurableExclusive(q.Name)
+		var once sync.Once
+		builder = builder.WithActivationPassivationSupport(func(_, newState solace.ReceiverState, _ time.Time) {
+			if newState == solace.ReceiverActive {
+				once.Do(func() { close(active) })
+			}
+		})
+	} else {
+		close(active)
+	}
+	receiver, err := builder.Build(queue)
+	if err != nil {
+		return current, err
+	}
+	if err := receiver.Start(); err != nil {
+		return current, fmt.Errorf("binding to %s: %w", q.Name, err)
+	}
+	defer receiver.Terminate(0)
+
+	select {
+	case <-active:
+	case <-time.After(activationTimeout):
+		return current, fmt.Errorf("queue %s is exclusive and already has a consumer, stop it to change the subscriptions", q.Name)
+	}
+
+	result := missing(current, remove)
+	var errs []error
+	for _, topic := range missing(add, current) {
+		if err := receiver.AddSubscription(resource.TopicSubscriptionOf(topic)); err != nil {
+			errs = append(errs, fmt.Errorf("adding subscription %s to %s: %w", topic, q.Name, err))
+			continue
+		}
+		result = append(result, topic)
+	}
+	for _, topic := range remove {
+		if err := receiver.RemoveSubscription(resource.TopicSubscriptionOf(topic)); err != nil {
+			errs = append(errs, fmt.Errorf("removing subscription %s from %s: %w", topic, q.Name, err))
+			result = append(result, topic)
+		}
+	}
+	return result, errors.Join(errs...)
+}
+
+// Summary counts the changes per action and the failed ones.
+func Summary(changes []Change) (counts map[Action]int, failed int) {
+	counts = map[Action]int{}
+	for _, c := range changes {
+		counts[c.Action]++
+		if c.Err != nil {
+			failed++
+		}
+	}
+	return counts, failed
+}
+
+// Print writes the changes as a diff: + create, - delete, ~ mismatch, = unchanged, ? orphan.
+func Print(w io.Writer, changes []Change) {
+	symbols := map[Action]string{
+		ActionCreate:    "+",
+		ActionDelete:    "-",
+		ActionMismatch:  "~",
+		ActionUnchanged: "=",
+		ActionOrphan:    "?",
+	}
+	for _, c := range changes {
+		fmt.Fprintf(w, "%s queue %s (%s)\n", symbols[c.Action], c.Queue, c.Action)
+		for _, d := range c.Diffs {
+			switch {
+			case d.From == "":
+				fmt.Fprintf(w, "    + %s: %s\n", d.Field, d.To)
+			default:
+				fmt.Fprintf(w, "    - %s: %s\n", d.Field, d.From)
+				fmt.Fprintf(w, "    + %s: %s\n", d.Field, d.To)
+			}
+		}
+		for _, topic := range c.AddSubscriptions {
+			fmt.Fprintf(w, "    + subscription %s\n", topic)
+		}
+		for _, topic := range c.RemoveSubscriptions {
+			fmt.Fprintf(w, "    - subscription %s\n", topic)
+		}
+		if c.Note != "" {
+			fmt.Fprintf(w, "    note: %s\n", c.Note)
+		}
+		if c.Err != nil {
+			fmt.Fprintf(w, "    error: %v\n", c.Err)
+		}
+	}
+}
+
+// diff lists the properties of want that differ from applied
+func diff(applied, want Queue) []Diff {
+	var diffs []Diff
+	from, to := applied.fields(), want.fields()
+	for i := range to {
+		if from[i][1] != to[i][1] {
+			diffs = append(diffs, Diff{Field: to[i][0], From: from[i][1], To: to[i][1]})
+		}
+	}
+	return diffs
+}
+
+// missing returns the entries of a that are not in b
+func missing(a, b []string) []string {
+	in := make(map[string]bool, len(b))
+	for _, s := range b {
+		in[s] = true
+	}
+	var out []string
+	for _, s := range a {
+		if !in[s] {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func hasSubcode(err error, code subcode.Code) bool {
+	var native *solace.NativeError
+	return errors.As(err, &native) && native.SubCode() == code
+}
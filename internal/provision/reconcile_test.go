@@ -0,0 +1,344 @@
+package provision
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"solace.dev/go/messaging/pkg/solace/config"
+	"solace.dev/go/messaging/pkg/solace/resource"
+
+	"SolaceSamples.com/PubSub+Go/internal/fake"
+)
+
+func ptr[T any](v T) *T { return &v }
+
+func TestLoadManifest(t *testing.T) {
+	m, err := LoadManifest(filepath.Join("..", "..", "howtos", "fixtures", "queues.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Queues) != 2 || m.Queues[0].Name != "samples.orders" || *m.Queues[0].QuotaMB != 100 {
+		t.Errorf("fixture loaded as %+v", m.Queues)
+	}
+
+	tests := []struct {
+		name    string
+		yaml    string
+		wantErr string
+	}{
+		{"valid", "queues:\n  - name: a\n    permission: consume\n", ""},
+		{"missing name", "queues:\n  - exclusive: true\n", "queue 0: name is required"},
+		{"duplicate", "queues:\n  - name: a\n  - name: a\n", "queue a: defined more than once"},
+		{"unknown permission", "queues:\n  - name: a\n    permission: owner\n", `unknown permission "owner"`},
+		{"unknown field", "queues:\n  - name: a\n    quota: 10\n", "field quota not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "queues.yaml")
+			if err := writeFile(path, tt.yaml); err != nil {
+				t.Fatal(err)
+			}
+			_, err := LoadManifest(path)
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPlan(t *testing.T) {
+	orders := Queue{Name: "orders", Exclusive: true, QuotaMB: ptr(uint(100)), Subscriptions: []string{"orders/>"}}
+	audit := Queue{Name: "audit", Subscriptions: []string{"a/created", "a/deleted"}}
+	tests := []struct {
+		name  string
+		state map[string]Queue
+		prune bool
+		want  []Change
+	}{
+		{
+			name: "new queues",
+			want: []Change{
+				{Queue: "orders", Action: ActionCreate, AddSubscriptions: []string{"orders/>"}},
+				{Queue: "audit", Action: ActionCreate, AddSubscriptions: []string{"a/created", "a/deleted"}},
+			},
+		},
+		{
+			name:  "applied",
+			state: map[string]Queue{"orders": orders, "audit": audit},
+			want: []Change{
+				{Queue: "orders", Action: ActionUnchanged},
+				{Queue: "audit", Action: ActionUnchanged},
+			},
+		},
+		{
+			name: "properties and subscriptions changed",
+			state: map[string]Queue{
+				"orders": {Name: "orders", Exclusive: false, QuotaMB: ptr(uint(50)), Subscriptions: []string{"orders/>"}},
+				"audit":  {Name: "audit", Subscriptions: []string{"a/created", "a/updated"}},
+			},
+			want: []Change{
+				{Queue: "orders", Action: ActionMismatch, Diffs: []Diff{
+					{Field: "exclusive", From: "false", To: "true"},
+					{Field: "quotaMB", From: "50", To: "100"},
+				}},
+				{Queue: "audit", Action: ActionUnchanged, AddSubscriptions: []string{"a/deleted"}, RemoveSubscriptions: []string{"a/updated"}},
+			},
+		},
+		{
+			name:  "orphan",
+			state: map[string]Queue{"orders": orders, "audit": audit, "old": {Name: "old"}},
+			want: []Change{
+				{Queue: "orders", Action: ActionUnchanged},
+				{Queue: "audit", Action: ActionUnchanged},
+				{Queue: "old", Action: ActionOrphan},
+			},
+		},
+		{
+			name:  "pruned",
+			state: map[string]Queue{"orders": orders, "audit": audit, "old": {Name: "old"}},
+			prune: true,
+			want: []Change{
+				{Queue: "orders", Action: ActionUnchanged},
+				{Queue: "audit", Action: ActionUnchanged},
+				{Queue: "old", Action: ActionDelete},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes := Plan(Manifest{Queues: []Queue{orders, audit}}, State{Queues: tt.state}, tt.prune)
+			if len(changes) != len(tt.want) {
+				t.Fatalf("%d changes, want %d", len(changes), len(tt.want))
+			}
+			for i, c := range changes {
+				want := tt.want[i]
+				if c.Queue != want.Queue || c.Action != want.Action {
+					t.Errorf("change %d is %s %s, want %s %s", i, c.Action, c.Queue, want.Action, want.Queue)
+				}
+				if c.Action != ActionCreate && !reflect.DeepEqual(c.Diffs, want.Diffs) {
+					t.Errorf("%s diffs %v, want %v", c.Queue, c.Diffs, want.Diffs)
+				}
+				if !slices.Equal(c.AddSubscriptions, want.AddSubscriptions) || !slices.Equal(c.RemoveSubscriptions, want.RemoveSubscriptions) {
+					t.Errorf("%s subscriptions +%v -%v, want +%v -%v", c.Queue,
+						c.AddSubscriptions, c.RemoveSubscriptions, want.AddSubscriptions, want.RemoveSubscriptions)
+				}
+			}
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	broker := fake.NewBroker()
+	service := broker.NewService()
+	if err := service.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer service.Disconnect()
+
+	orders := Queue{Name: "orders", Exclusive: true, QuotaMB: ptr(uint(100)), Subscriptions: []string{"orders/>"}}
+	audit := Queue{Name: "audit", Permission: "read-only", Subscriptions: []string{"a/created"}}
+	manifest := Manifest{Queues: []Queue{orders, audit}}
+
+	// the first run creates the queues with their properties and subscriptions
+	changes, state := Apply(service, manifest, State{Queues: map[string]Queue{}}, false)
+	wantActions(t, changes, ActionCreate, ActionCreate)
+	if props := broker.Queue("orders").Properties(); props[config.EndpointPropertyQuotaMB] != uint(100) || props[config.EndpointPropertyExclusive] != true {
+		t.Errorf("orders provisioned with %v", props)
+	}
+	if props := broker.Queue("audit").Properties(); props[config.EndpointPropertyPermission] != config.EndpointPermissionReadOnly {
+		t.Errorf("audit provisioned with %v", props)
+	}
+	wantSubscriptions(t, broker, "orders", "orders/>")
+	wantSubscriptions(t, broker, "audit", "a/created")
+	if !reflect.DeepEqual(state.Queues["orders"], orders) {
+		t.Errorf("state recorded %+v, want %+v", state.Queues["orders"], orders)
+	}
+
+	// applying again changes nothing
+	changes, state = Apply(service, manifest, state, false)
+	wantActions(t, changes, ActionUnchanged, ActionUnchanged)
+
+	// subscriptions follow the manifest, properties are reported and kept
+	audit.Subscriptions = []string{"a/deleted"}
+	orders.QuotaMB = ptr(uint(200))
+	changes, state = Apply(service, Manifest{Queues: []Queue{orders, audit}}, state, false)
+	wantActions(t, changes, ActionMismatch, ActionUnchanged)
+	wantSubscriptions(t, broker, "audit", "a/deleted")
+	if quota := *state.Queues["orders"].QuotaMB; quota != 100 {
+		t.Errorf("state records quota %d, want the applied 100", quota)
+	}
+	if props := broker.Queue("orders").Properties(); props[config.EndpointPropertyQuotaMB] != uint(100) {
+		t.Errorf("orders changed to %v", props)
+	}
+
+	// queues removed from the manifest are kept unless pruned
+	changes, state = Apply(service, Manifest{Queues: []Queue{orders}}, state, false)
+	wantActions(t, changes, ActionMismatch, ActionOrphan)
+	if broker.Queue("audit") == nil {
+		t.Error("orphaned queue deleted without prune")
+	}
+	changes, state = Apply(service, Manifest{Queues: []Queue{orders}}, state, true)
+	wantActions(t, changes, ActionMismatch, ActionDelete)
+	if broker.Queue("audit") != nil {
+		t.Error("pruned queue not deleted")
+	}
+	if _, ok := state.Queues["audit"]; ok {
+		t.Error("pruned queue still in the state")
+	}
+	if _, failed := Summary(changes); failed != 0 {
+		t.Errorf("%d changes failed", failed)
+	}
+}
+
+func TestApplyWithoutState(t *testing.T) {
+	broker := fake.NewBroker()
+	broker.CreateQueue("existing", "existing/old")
+	service := broker.NewService()
+	if err := service.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer service.Disconnect()
+	if outcome := service.EndpointProvisioner().WithExclusiveAccess(false).Provision("same", false); outcome.GetError() != nil {
+		t.Fatal(outcome.GetError())
+	}
+
+	manifest := Manifest{Queues: []Queue{
+		{Name: "existing", Exclusive: false, Subscriptions: []string{"existing/new"}},
+		{Name: "same", Subscriptions: []string{"same/>"}},
+	}}
+	changes, state := Apply(service, manifest, State{Queues: map[string]Queue{}}, false)
+
+	// the broker has the final say about queues missing from the state
+	wantActions(t, changes, ActionMismatch, ActionUnchanged)
+	if changes[0].Note == "" || changes[0].Diffs != nil {
+		t.Errorf("mismatch without a state reported as %+v", changes[0])
+	}
+	if _, ok := state.Queues["existing"]; ok {
+		t.Error("queue with unknown properties recorded in the state")
+	}
+	wantSubscriptions(t, broker, "same", "same/>")
+	if got := state.Queues["same"].Subscriptions; !slices.Equal(got, []string{"same/>"}) {
+		t.Errorf("state records subscriptions %v", got)
+	}
+}
+
+func TestApplyExclusiveInUse(t *testing.T) {
+	defer func(timeout time.Duration) { activationTimeout = timeout }(activationTimeout)
+	activationTimeout = 50 * time.Millisecond
+
+	broker := fake.NewBroker()
+	service := broker.NewService()
+	if err := service.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer service.Disconnect()
+
+	orders := Queue{Name: "orders", Exclusive: true, Subscriptions: []string{"orders/>"}}
+	_, state := Apply(service, Manifest{Queues: []Queue{orders}}, State{Queues: map[string]Queue{}}, false)
+
+	consumer, err := service.CreatePersistentMessageReceiverBuilder().Build(resource.QueueDurableExclusive("orders"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := consumer.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the binding is a standby while the consumer is active, nothing is changed
+	orders.Subscriptions = []string{"orders/>", "returns/>"}
+	changes, next := Apply(service, Manifest{Queues: []Queue{orders}}, state, false)
+	if changes[0].Err == nil || !strings.Contains(changes[0].Err.Error(), "already has a consumer") {
+		t.Errorf("error %v, want the exclusive queue reported", changes[0].Err)
+	}
+	wantSubscriptions(t, broker, "orders", "orders/>")
+	if got := next.Queues["orders"].Subscriptions; !slices.Equal(got, []string{"orders/>"}) {
+		t.Errorf("state records subscriptions %v", got)
+	}
+
+	// once the consumer stops the subscriptions are added
+	if err := consumer.Terminate(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	changes, next = Apply(service, Manifest{Queues: []Queue{orders}}, next, false)
+	if changes[0].Err != nil {
+		t.Fatal(changes[0].Err)
+	}
+	wantSubscriptions(t, broker, "orders", "orders/>", "returns/>")
+	if got := next.Queues["orders"].Subscriptions; !slices.Equal(got, orders.Subscriptions) {
+		t.Errorf("state records subscriptions %v", got)
+	}
+}
+
+func TestState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queues.state.json")
+	state, err := LoadState(path)
+	if err != nil || len(state.Queues) != 0 {
+		t.Fatalf("missing state loaded as %+v, %v", state, err)
+	}
+	state.Queues["orders"] = Queue{Name: "orders", Durable: ptr(true), QuotaMB: ptr(uint(100)), Subscriptions: []string{"orders/>"}}
+	state.AppliedAt = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := state.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, state) {
+		t.Errorf("loaded %+v, want %+v", loaded, state)
+	}
+}
+
+func TestPrint(t *testing.T) {
+	var out bytes.Buffer
+	Print(&out, []Change{
+		{Queue: "orders", Action: ActionMismatch, Diffs: []Diff{{Field: "quotaMB", From: "50", To: "100"}}, Note: "kept"},
+		{Queue: "audit", Action: ActionCreate, Diffs: []Diff{{Field: "exclusive", To: "false"}}, AddSubscriptions: []string{"a/>"}},
+	})
+	want := `~ queue orders (mismatch)
+    - quotaMB: 50
+    + quotaMB: 100
+    note: kept
++ queue audit (create)
+    + exclusive: false
+    + subscription a/>
+`
+	if out.String() != want {
+		t.Errorf("printed\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+// wantActions checks the action of every change in order
+func wantActions(t *testing.T, changes []Change, want ...Action) {
+	t.Helper()
+	var got []Action
+	for _, c := range changes {
+		got = append(got, c.Action)
+		if c.Err != nil {
+			t.Errorf("%s: %v", c.Queue, c.Err)
+		}
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("actions %v, want %v", got, want)
+	}
+}
+
+// wantSubscriptions checks the subscriptions of a queue on the broker
+func wantSubscriptions(t *testing.T, broker *fake.Broker, queue string, want ...string) {
+	t.Helper()
+	got := broker.Queue(queue).Subscriptions()
+	slices.Sort(got)
+	if !slices.Equal(got, want) {
+		t.Errorf("%s has subscriptions %v, want %v", queue, got, want)
+	}
+}
+
+func writeFile(path, content string) error {
+	return os.WriteFile(path, []byte(content), 0o644)
+}
@@ -0,0 +1,59 @@
+package provision
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"time"
+)
+
+// State records the queue definitions that were last applied successfully.
+type State struct {
+	AppliedAt time.Time        `json:"appliedAt"`
+	Queues    map[string]Queue `json:"queues"`
+}
+
+// LoadState reads the state file. A missing file is an empty state.
+func LoadState(path string) (State, error) {
+	state := State{Queues: map[string]Queue{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return state, fmt.Errorf("reading state: %w", err)
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, fmt.Errorf("parsing state %s: %w", path, err)
+	}
+	if state.Queues == nil {
+		state.Queues = map[string]Queue{}
+	}
+	return state, nil
+}
+
+// Save writes the state file, replacing it atomically.
+func (s State) Save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("writing state: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// names returns the queue names in a stable order
+func (s State) names() []string {
+	names := make([]string, 0, len(s.Queues))
+	for name := range s.Queues {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,82 @@
+// Package retry wraps a persistent message publisher so that messages NAKed by
+// the broker are published again with exponential backoff and, once the attempts
+// are used up, diverted to a dead-letter topic.
+package retry
+
+import (
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+// Policy controls how NAKed messages are retried.
+type Policy struct {
+	// MaxAttempts is the total number of publish attempts, including the first one.
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between retries.
+	MaxBackoff time.Duration
+	// Multiplier grows the delay after every attempt.
+	Multiplier float64
+	// Jitter randomises each delay by up to +/- this fraction, 0 disables it.
+	Jitter float64
+	// DeadLetterTopic receives the messages that failed every attempt. When it is
+	// empty those messages are dropped and only counted.
+	DeadLetterTopic string
+}
+
+// DefaultPolicy returns 5 attempts starting at 100ms, doubling up to 10s with 20% jitter.
+func DefaultPolicy() Policy {
+	return Policy{
+		MaxAttempts:    5,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     10 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+	}
+}
+
+// Backoff returns the delay before publishing attempt number attempt+1,
+// attempt starting at 1 for the first publish.
+func (p Policy) Backoff(attempt int) time.Duration {
+	delay := p.delay(attempt)
+	if p.Jitter > 0 {
+		delay *= 1 + p.Jitter*(2*rand.Float64()-1)
+	}
+	return time.Duration(delay)
+}
+
+// MaxRetryDelay returns the longest a message can wait in backoff before its
+// last attempt, with every delay at its maximum jitter. Drain timeouts sized
+// from it do not cut short the retries of messages NAKed before the shutdown.
+func (p Policy) MaxRetryDelay() time.Duration {
+	var total float64
+	for attempt := 1; attempt < p.maxAttempts(); attempt++ {
+		total += p.delay(attempt) * (1 + max(p.Jitter, 0))
+	}
+	return time.Duration(total)
+}
+
+// delay returns the backoff after attempt without jitter
+func (p Policy) delay(attempt int) float64 {
+	if attempt < 1 {
+		attempt = 1
+	}
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	delay := float64(p.InitialBackoff) * math.Pow(multiplier, float64(attempt-1))
+	if p.MaxBackoff > 0 && delay > float64(p.MaxBackoff) {
+		delay = float64(p.MaxBackoff)
+	}
+	return delay
+}
+
+func (p Policy) maxAttempts() int {
+	if p.MaxAttempts < 1 {
+		return 1
+	}
+	return p.MaxAttempts
+}
@@ -0,0 +1,69 @@
+package retry
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	policy := Policy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Multiplier: 2}
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{0, 100 * time.Millisecond},
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{4, 800 * time.Millisecond},
+		{5, time.Second},
+		{10, time.Second},
+	}
+	for _, tt := range tests {
+		if got := policy.Backoff(tt.attempt); got != tt.want {
+			t.Errorf("Backoff(%d) = %s, want %s", tt.attempt, got, tt.want)
+		}
+	}
+
+	constant := Policy{InitialBackoff: 50 * time.Millisecond}
+	if got := constant.Backoff(3); got != 50*time.Millisecond {
+		t.Errorf("Backoff(3) without a multiplier = %s, want 50ms", got)
+	}
+}
+
+func TestBackoffJitter(t *testing.T) {
+	policy := Policy{InitialBackoff: 100 * time.Millisecond, Multiplier: 2, Jitter: 0.2}
+	low, high := 160*time.Millisecond, 240*time.Millisecond
+	seen := map[time.Duration]bool{}
+	for i := 0; i < 100; i++ {
+		got := policy.Backoff(2)
+		if got < low || got > high {
+			t.Fatalf("Backoff(2) = %s, want within [%s, %s]", got, low, high)
+		}
+		seen[got] = true
+	}
+	if len(seen) < 2 {
+		t.Error("jitter did not vary the backoff")
+	}
+}
+
+func TestMaxRetryDelay(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy Policy
+		want   time.Duration
+	}{
+		{"single attempt", Policy{MaxAttempts: 1, InitialBackoff: time.Second}, 0},
+		{"no attempts", Policy{InitialBackoff: time.Second}, 0},
+		{"doubling", Policy{MaxAttempts: 4, InitialBackoff: 100 * time.Millisecond, Multiplier: 2}, 700 * time.Millisecond},
+		{"capped", Policy{MaxAttempts: 4, InitialBackoff: 100 * time.Millisecond, Multiplier: 2, MaxBackoff: 200 * time.Millisecond}, 500 * time.Millisecond},
+		{"jitter at its maximum", Policy{MaxAttempts: 3, InitialBackoff: 100 * time.Millisecond, Multiplier: 2, Jitter: 0.5}, 450 * time.Millisecond},
+		{"default", DefaultPolicy(), 1800 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.MaxRetryDelay(); got != tt.want {
+				t.Errorf("MaxRetryDelay() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"solace.dev/go/messaging/pkg/solace"
+	"solace.dev/go/messaging/pkg/solace/config"
+	"solace.dev/go/messaging/pkg/solace/message"
+	"solace.dev/go/messaging/pkg/solace/resource"
+
+	"SolaceSamples.com/PubSub+Go/internal/lifecycle"
+)
+
+// User properties added to messages published to the dead-letter topic.
+const (
+	PropertyOriginalTopic = "retry_original_topic"
+	PropertyAttempts      = "retry_attempts"
+	PropertyLastError     = "retry_last_error"
+)
+
+// ErrDeadLettered is wrapped by the error of the final receipt of a message that
+// was persisted on the dead-letter topic instead of its own topic.
+var ErrDeadLettered = errors.New("diverted to dead-letter topic")
+
+// Stats is a snapshot of the publish outcomes counted by a Publisher.
+type Stats struct {
+	Published        uint64 // messages handed to Publish
+	Acked            uint64 // messages persisted by the broker, on any attempt
+	Retried          uint64 // retry attempts made after a NAK
+	DeadLettered     uint64 // messages persisted on the dead-letter topic
+	DeadLetterFailed uint64 // messages the dead-letter topic NAKed as well
+	Dropped          uint64 // messages given up without a dead-letter topic
+}
+
+func (s Stats) String() string {
+	return fmt.Sprintf("published=%d acked=%d retried=%d dead-lettered=%d dead-letter-failed=%d dropped=%d",
+		s.Published, s.Acked, s.Retried, s.DeadLettered, s.DeadLetterFailed, s.Dropped)
+}
+
+// Publisher publishes through a solace.PersistentMessagePublisher and owns its
+// receipt listener. Every publish carries an attempt record as user context so
+// the receipt can be matched to the message, topic and attempt it belongs to.
+type Publisher struct {
+	publisher solace.PersistentMessagePublisher
+	policy    Policy
+	listener  solace.MessagePublishReceiptListener
+
+	published, acked, retried      atomic.Uint64
+	deadLettered, deadLetterFailed atomic.Uint64
+	dropped                        atomic.Uint64
+
+	inFlight lifecycle.InFlight
+}
+
+// attempt is the user context passed to the wrapped publisher
+type attempt struct {
+	msg         message.OutboundMessage
+	topic       *resource.Topic
+	properties  config.MessagePropertiesConfigurationProvider
+	userContext interface{}
+	number      int
+	deadLetter  bool
+	lastErr     error
+}
+
+// NewPublisher installs the retrying receipt listener on publisher. listener,
+// which may be nil, is called once per message with its final receipt, carrying
+// the user context given to Publish.
+func NewPublisher(publisher solace.PersistentMessagePublisher, policy Policy, listener solace.MessagePublishReceiptListener) *Publisher {
+	p := &Publisher{publisher: publisher, policy: policy, listener: listener}
+	publisher.SetMessagePublishReceiptListener(p.onReceipt)
+	return p
+}
+
+// Publish publishes msg. A NAK is handled in the background according to the policy.
+func (p *Publisher) Publish(msg message.OutboundMessage, destination *resource.Topic, properties config.MessagePropertiesConfigurationProvider, userContext interface{}) error {
+	p.inFlight.Begin()
+	a := &attempt{msg: msg, topic: destination, properties: properties, userContext: userContext, number: 1}
+	if err := p.publisher.Publish(msg, destination, properties, a); err != nil {
+		p.inFlight.End()
+		return err
+	}
+	p.published.Add(1)
+	return nil
+}
+
+// Stats returns the current counters.
+func (p *Publisher) Stats() Stats {
+	return Stats{
+		Published:        p.published.Load(),
+		Acked:            p.acked.Load(),
+		Retried:          p.retried.Load(),
+		DeadLettered:     p.deadLettered.Load(),
+		DeadLetterFailed: p.deadLetterFailed.Load(),
+		Dropped:          p.dropped.Load(),
+	}
+}
+
+// Outstanding returns the number of messages without a final outcome yet,
+// including those waiting for a receipt or a retry.
+func (p *Publisher) Outstanding() int {
+	return p.inFlight.Count()
+}
+
+// Drain waits until every message has reached a final outcome, including
+// retries still waiting for their backoff, or ctx is done.
+func (p *Publisher) Drain(ctx context.Context) error {
+	if err := p.inFlight.Wait(ctx); err != nil {
+		return fmt.Errorf("%d messages still being retried: %w", p.inFlight.Count(), err)
+	}
+	return nil
+}
+
+func (p *Publisher) onReceipt(r solace.PublishReceipt) {
+	a, ok := r.GetUserContext().(*attempt)
+	if !ok {
+		// published around the wrapper, pass it through untouched
+		if p.listener != nil {
+			p.listener(r)
+		}
+		return
+	}
+	err := r.GetError()
+	switch {
+	case err == nil && a.deadLetter:
+		p.deadLettered.Add(1)
+	case err == nil:
+		p.acked.Add(1)
+	case a.deadLetter:
+		p.deadLetterFailed.Add(1)
+	case a.number < p.policy.maxAttempts():
+		a.lastErr = err
+		p.retry(a)
+		return
+	default:
+		a.lastErr = err
+		if p.policy.DeadLetterTopic != "" {
+			p.deadLetter(a)
+			return
+		}
+		p.dropped.Add(1)
+	}
+	p.finish(a, r)
+}
+
+// retry publishes a again after the backoff for its attempt number
+func (p *Publisher) retry(a *attempt) {
+	delay := p.policy.Backoff(a.number)
+	a.number++
+	p.retried.Add(1)
+	time.AfterFunc(delay, func() {
+		if err := p.publisher.Publish(a.msg, a.topic, a.properties, a); err != nil {
+			// the publisher is gone, no receipt will follow
+			a.lastErr = err
+			p.dropped.Add(1)
+			p.finish(a, receipt{userContext: a.userContext, timestamp: time.Now(), message: a.msg, err: err})
+		}
+	})
+}
+
+// deadLetter publishes a to the dead-letter topic with the reason as user properties
+func (p *Publisher) deadLetter(a *attempt) {
+	properties := config.MessagePropertyMap{}
+	if a.properties != nil {
+		for key, val := range a.properties.GetConfiguration() {
+			properties[key] = val
+		}
+	}
+	properties[config.MessageProperty(PropertyOriginalTopic)] = a.topic.GetName()
+	properties[config.MessageProperty(PropertyAttempts)] = strconv.Itoa(a.number)
+	properties[config.MessageProperty(PropertyLastError)] = a.lastErr.Error()
+
+	a.deadLetter = true
+	if err := p.publisher.Publish(a.msg, resource.TopicOf(p.policy.DeadLetterTopic), properties, a); err != nil {
+		p.deadLetterFailed.Add(1)
+		p.finish(a, receipt{userContext: a.userContext, timestamp: time.Now(), message: a.msg, err: err})
+	}
+}
+
+// finish reports the final receipt to the listener with the caller's user context
+func (p *Publisher) finish(a *attempt, r solace.PublishReceipt) {
+	defer p.inFlight.End()
+	if p.listener == nil {
+		return
+	}
+	err := r.GetError()
+	if err == nil && a.deadLetter {
+		// persisted, but not where the caller asked for
+		err = fmt.Errorf("%w %s after %d attempts: %w", ErrDeadLettered, p.policy.DeadLetterTopic, a.number, a.lastErr)
+	}
+	p.listener(receipt{
+		userContext: a.userContext,
+		timestamp:   r.GetTimeStamp(),
+		message:     a.msg,
+		err:         err,
+		persisted:   r.IsPersisted(),
+	})
+}
+
+// receipt is handed to the caller's listener in place of the broker receipt
+type receipt struct {
+	userContext interface{}
+	timestamp   time.Time
+	message     message.OutboundMessage
+	err         error
+	persisted   bool
+}
+
+func (r receipt) GetUserContext() interface{}         { return r.userContext }
+func (r receipt) GetTimeStamp() time.Time             { return r.timestamp }
+func (r receipt) GetMessage() message.OutboundMessage { return r.message }
+func (r receipt) GetError() error                     { return r.err }
+func (r receipt) IsPersisted() bool                   { return r.persisted }
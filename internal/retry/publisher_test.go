@@ -0,0 +1,246 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"solace.dev/go/messaging/pkg/solace"
+	"solace.dev/go/messaging/pkg/solace/message"
+	"solace.dev/go/messaging/pkg/solace/resource"
+
+	"SolaceSamples.com/PubSub+Go/internal/fake"
+)
+
+const (
+	topic           = "solace/samples/persistent/publisher"
+	deadLetterTopic = "solace/samples/persistent/publisher/dlq"
+)
+
+// naks makes broker NAK the first n publishes to each of the topics in counts
+func naks(broker *fake.Broker, counts map[string]int) {
+	var mu sync.Mutex
+	broker.SetPublishInterceptor(func(topic string, msg message.OutboundMessage) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if counts[topic] == 0 {
+			return nil
+		}
+		counts[topic]--
+		return errors.New("queue full")
+	})
+}
+
+// receipts collects the receipts of a listener
+type receipts struct {
+	mu       sync.Mutex
+	receipts []solace.PublishReceipt
+}
+
+func (r *receipts) listener(receipt solace.PublishReceipt) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.receipts = append(r.receipts, receipt)
+}
+
+func (r *receipts) get() []solace.PublishReceipt {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.receipts)
+}
+
+// startPublisher returns a Publisher with policy on a started fake persistent publisher of broker
+func startPublisher(t *testing.T, broker *fake.Broker, policy Policy, listener solace.MessagePublishReceiptListener) (*fake.Service, *Publisher) {
+	t.Helper()
+	service := broker.NewService()
+	if err := service.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { service.Disconnect() })
+	publisher, err := service.CreatePersistentMessagePublisherBuilder().Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewPublisher(publisher, policy, listener)
+	if err := publisher.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { publisher.Terminate(0) })
+	return service, p
+}
+
+func TestPublisher(t *testing.T) {
+	policy := Policy{MaxAttempts: 3, InitialBackoff: time.Millisecond, Multiplier: 2}
+	withDeadLetters := policy
+	withDeadLetters.DeadLetterTopic = deadLetterTopic
+
+	tests := []struct {
+		name        string
+		policy      Policy
+		naks        map[string]int
+		wantStats   Stats
+		wantErr     bool
+		wantDivert  bool
+		wantOnTopic int
+		wantOnDLQ   int
+	}{
+		{
+			name:        "acked on the first attempt",
+			policy:      policy,
+			wantStats:   Stats{Published: 1, Acked: 1},
+			wantOnTopic: 1,
+		},
+		{
+			name:        "acked on a retry",
+			policy:      policy,
+			naks:        map[string]int{topic: 2},
+			wantStats:   Stats{Published: 1, Acked: 1, Retried: 2},
+			wantOnTopic: 1,
+		},
+		{
+			name:      "dropped without a dead-letter topic",
+			policy:    policy,
+			naks:      map[string]int{topic: 3},
+			wantStats: Stats{Published: 1, Retried: 2, Dropped: 1},
+			wantErr:   true,
+		},
+		{
+			name:       "diverted to the dead-letter topic",
+			policy:     withDeadLetters,
+			naks:       map[string]int{topic: 3},
+			wantStats:  Stats{Published: 1, Retried: 2, DeadLettered: 1},
+			wantErr:    true,
+			wantDivert: true,
+			wantOnDLQ:  1,
+		},
+		{
+			name:      "NAKed by the dead-letter topic too",
+			policy:    withDeadLetters,
+			naks:      map[string]int{topic: 3, deadLetterTopic: 1},
+			wantStats: Stats{Published: 1, Retried: 2, DeadLetterFailed: 1},
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			broker := fake.NewBroker()
+			queue := broker.CreateQueue("queue", topic)
+			dlq := broker.CreateQueue("dlq", deadLetterTopic)
+			naks(broker, tt.naks)
+			var got receipts
+			service, p := startPublisher(t, broker, tt.policy, got.listener)
+
+			msg, _ := service.MessageBuilder().BuildWithStringPayload("Hello")
+			if err := p.Publish(msg, resource.TopicOf(topic), nil, "context-1"); err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			if err := p.Drain(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			if stats := p.Stats(); stats != tt.wantStats {
+				t.Errorf("stats %s, want %s", stats, tt.wantStats)
+			}
+			if p.Outstanding() != 0 {
+				t.Errorf("%d outstanding after Drain", p.Outstanding())
+			}
+			if queue.Pending() != tt.wantOnTopic || dlq.Pending() != tt.wantOnDLQ {
+				t.Errorf("%d messages on the topic and %d on the dead-letter topic, want %d and %d",
+					queue.Pending(), dlq.Pending(), tt.wantOnTopic, tt.wantOnDLQ)
+			}
+			// one final receipt per message, correlated with the caller's message and user context
+			receipts := got.get()
+			if len(receipts) != 1 {
+				t.Fatalf("%d receipts, want 1", len(receipts))
+			}
+			receipt := receipts[0]
+			if receipt.GetUserContext() != "context-1" || receipt.GetMessage() != msg {
+				t.Errorf("receipt for %v with context %v", receipt.GetMessage(), receipt.GetUserContext())
+			}
+			if (receipt.GetError() != nil) != tt.wantErr {
+				t.Errorf("receipt error %v, want one: %v", receipt.GetError(), tt.wantErr)
+			}
+			if errors.Is(receipt.GetError(), ErrDeadLettered) != tt.wantDivert {
+				t.Errorf("receipt error %v, want ErrDeadLettered: %v", receipt.GetError(), tt.wantDivert)
+			}
+		})
+	}
+}
+
+func TestDeadLetterProperties(t *testing.T) {
+	broker := fake.NewBroker()
+	broker.CreateQueue("dlq", deadLetterTopic)
+	naks(broker, map[string]int{topic: 2})
+	policy := Policy{MaxAttempts: 2, InitialBackoff: time.Millisecond, DeadLetterTopic: deadLetterTopic}
+	service, p := startPublisher(t, broker, policy, nil)
+
+	msg, _ := service.MessageBuilder().WithProperty("language", "go").BuildWithStringPayload("Hello")
+	if err := p.Publish(msg, resource.TopicOf(topic), nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := p.Drain(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	receiver, err := service.CreatePersistentMessageReceiverBuilder().Build(resource.QueueDurableExclusive("dlq"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := receiver.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer receiver.Terminate(0)
+	deadLetter, err := receiver.ReceiveMessage(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		PropertyOriginalTopic: topic,
+		PropertyAttempts:      "2",
+		"language":            "go",
+	} {
+		if got, ok := deadLetter.GetProperty(name); !ok || got != want {
+			t.Errorf("%s = %v, want %s", name, got, want)
+		}
+	}
+	if lastErr, _ := deadLetter.GetProperty(PropertyLastError); !strings.Contains(fmt.Sprint(lastErr), "queue full") {
+		t.Errorf("%s = %v, want the NAK", PropertyLastError, lastErr)
+	}
+	if body, _ := deadLetter.GetPayloadAsString(); body != "Hello" {
+		t.Errorf("dead letter payload %q", body)
+	}
+}
+
+func TestPublisherDrainTimeout(t *testing.T) {
+	broker := fake.NewBroker()
+	broker.CreateQueue("queue", topic)
+	naks(broker, map[string]int{topic: 1})
+	policy := Policy{MaxAttempts: 2, InitialBackoff: 200 * time.Millisecond}
+	service, p := startPublisher(t, broker, policy, nil)
+
+	msg, _ := service.MessageBuilder().BuildWithStringPayload("Hello")
+	if err := p.Publish(msg, resource.TopicOf(topic), nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	// the retry waits in its backoff
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := p.Drain(ctx); !errors.Is(err, context.DeadlineExceeded) || p.Outstanding() != 1 {
+		t.Errorf("Drain() = %v with %d outstanding, want a deadline error", err, p.Outstanding())
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), policy.MaxRetryDelay()+time.Second)
+	defer cancel()
+	if err := p.Drain(ctx); err != nil {
+		t.Errorf("Drain() = %v within the policy's retry delay", err)
+	}
+}
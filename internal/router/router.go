@@ -0,0 +1,215 @@
+// Package router dispatches the messages of a direct receiver to handlers
+// registered per subscription. Every message goes to the handler of the most
+// specific subscription matching its topic, see wildcard.Compare, or to the
+// fallback handler when none matches, e.g. because its route was removed while
+// the message was on its way.
+//
+// The router owns the subscriptions of the receiver: adding a route adds its
+// subscription and removing the route removes it again, also while the receiver
+// is running.
+package router
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"solace.dev/go/messaging/pkg/solace"
+	"solace.dev/go/messaging/pkg/solace/message"
+	"solace.dev/go/messaging/pkg/solace/resource"
+
+	"SolaceSamples.com/PubSub+Go/internal/wildcard"
+)
+
+// Router routes the messages of a direct receiver.
+type Router struct {
+	receiver solace.DirectMessageReceiver
+
+	// changing serializes Handle, Remove and Start, which call the receiver
+	// without holding mu so that Dispatch is not blocked meanwhile
+	changing sync.Mutex
+	mu       sync.RWMutex
+	routes   []route
+	fallback solace.MessageHandler
+	started  bool
+
+	unrouted atomic.Uint64
+}
+
+type route struct {
+	subscription string
+	handler      solace.MessageHandler
+}
+
+// New creates a router for receiver, which should be built without
+// subscriptions and started with Start.
+func New(receiver solace.DirectMessageReceiver) *Router {
+	return &Router{receiver: receiver}
+}
+
+// Handle routes the messages matching subscription to handler. Handling a
+// subscription again replaces its handler. Once the router is started the
+// subscription is added to the receiver before Handle returns, and the route
+// is dropped again when that fails.
+func (r *Router) Handle(subscription string, handler solace.MessageHandler) error {
+	if handler == nil {
+		return fmt.Errorf("route %s: nil handler", subscription)
+	}
+	r.changing.Lock()
+	defer r.changing.Unlock()
+
+	r.mu.Lock()
+	for i := range r.routes {
+		if r.routes[i].subscription == subscription {
+			r.routes[i].handler = handler
+			r.mu.Unlock()
+			return nil
+		}
+	}
+	r.routes = append(r.routes, route{subscription: subscription, handler: handler})
+	started := r.started
+	r.mu.Unlock()
+
+	if !started {
+		return nil
+	}
+	if err := r.receiver.AddSubscription(resource.TopicSubscriptionOf(subscription)); err != nil {
+		r.drop(subscription)
+		return fmt.Errorf("route %s: %w", subscription, err)
+	}
+	return nil
+}
+
+// Remove removes the route of subscription and, once the router is started,
+// the subscription from the receiver. Messages that were already received for
+// it go to the next matching route or the fallback handler.
+func (r *Router) Remove(subscription string) error {
+	r.changing.Lock()
+	defer r.changing.Unlock()
+
+	r.mu.RLock()
+	found := slices.ContainsFunc(r.routes, func(rt route) bool { return rt.subscription == subscription })
+	started := r.started
+	r.mu.RUnlock()
+
+	if !found {
+		return fmt.Errorf("route %s: not found", subscription)
+	}
+	if started {
+		if err := r.receiver.RemoveSubscription(resource.TopicSubscriptionOf(subscription)); err != nil {
+			return fmt.Errorf("route %s: %w", subscription, err)
+		}
+	}
+	r.drop(subscription)
+	return nil
+}
+
+// drop removes the route of subscription
+func (r *Router) drop(subscription string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.routes = slices.DeleteFunc(r.routes, func(rt route) bool { return rt.subscription == subscription })
+}
+
+// Fallback sets the handler of messages no route matches. Without one they are
+// dropped and only counted, see Unrouted.
+func (r *Router) Fallback(handler solace.MessageHandler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fallback = handler
+}
+
+// Start starts the receiver, adds the subscriptions of the routes handled so
+// far and registers the router as its message handler. When that fails, the
+// subscriptions added are removed again and the receiver is terminated.
+func (r *Router) Start() error {
+	r.changing.Lock()
+	defer r.changing.Unlock()
+
+	if err := r.receiver.Start(); err != nil {
+		return err
+	}
+	subscriptions := r.Routes()
+	for i, subscription := range subscriptions {
+		if err := r.receiver.AddSubscription(resource.TopicSubscriptionOf(subscription)); err != nil {
+			return r.rollback(subscriptions[:i], fmt.Errorf("route %s: %w", subscription, err))
+		}
+	}
+	if err := r.receiver.ReceiveAsync(r.Dispatch); err != nil {
+		return r.rollback(subscriptions, err)
+	}
+	r.mu.Lock()
+	r.started = true
+	r.mu.Unlock()
+	return nil
+}
+
+// rollback undoes a failed Start, removing the subscriptions it added
+func (r *Router) rollback(added []string, cause error) error {
+	errs := []error{cause}
+	for _, subscription := range slices.Backward(added) {
+		if err := r.receiver.RemoveSubscription(resource.TopicSubscriptionOf(subscription)); err != nil {
+			errs = append(errs, fmt.Errorf("route %s: %w", subscription, err))
+		}
+	}
+	errs = append(errs, r.receiver.Terminate(0))
+	return errors.Join(errs...)
+}
+
+// Dispatch hands msg to the handler of the most specific route matching its
+// destination. The first route handled wins between equally specific ones.
+func (r *Router) Dispatch(msg message.InboundMessage) {
+	r.mu.RLock()
+	handler := r.fallback
+	if rt, ok := r.match(msg.GetDestinationName()); ok {
+		handler = rt.handler
+	}
+	r.mu.RUnlock()
+
+	if handler == nil {
+		r.unrouted.Add(1)
+		return
+	}
+	handler(msg)
+}
+
+// Match returns the subscription of the route a message published on topic
+// would be dispatched to, ok is false when it would go to the fallback handler.
+func (r *Router) Match(topic string) (subscription string, ok bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	rt, ok := r.match(topic)
+	return rt.subscription, ok
+}
+
+// match returns the most specific route matching topic, the caller holds mu
+func (r *Router) match(topic string) (best route, ok bool) {
+	for _, rt := range r.routes {
+		if !wildcard.Match(rt.subscription, topic) {
+			continue
+		}
+		if !ok || wildcard.Compare(rt.subscription, best.subscription) > 0 {
+			best, ok = rt, true
+		}
+	}
+	return best, ok
+}
+
+// Routes returns the subscriptions routed to, in the order they were handled.
+func (r *Router) Routes() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	subscriptions := make([]string, len(r.routes))
+	for i, rt := range r.routes {
+		subscriptions[i] = rt.subscription
+	}
+	return subscriptions
+}
+
+// Unrouted returns the number of messages dropped because no route matched
+// and no fallback handler was set.
+func (r *Router) Unrouted() uint64 {
+	return r.unrouted.Load()
+}
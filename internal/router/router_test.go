@@ -0,0 +1,314 @@
+package router
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"solace.dev/go/messaging/pkg/solace"
+	"solace.dev/go/messaging/pkg/solace/message"
+	"solace.dev/go/messaging/pkg/solace/resource"
+
+	"SolaceSamples.com/PubSub+Go/internal/fake"
+)
+
+// received collects the messages of a handler per route
+type received struct {
+	mu       sync.Mutex
+	messages map[string][]string
+	arrived  chan struct{}
+}
+
+func newReceived() *received {
+	return &received{messages: map[string][]string{}, arrived: make(chan struct{}, 100)}
+}
+
+func (rc *received) handler(route string) solace.MessageHandler {
+	return func(msg message.InboundMessage) {
+		rc.mu.Lock()
+		rc.messages[route] = append(rc.messages[route], msg.GetDestinationName())
+		rc.mu.Unlock()
+		rc.arrived <- struct{}{}
+	}
+}
+
+func (rc *received) get(route string) []string {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return slices.Clone(rc.messages[route])
+}
+
+func TestDispatch(t *testing.T) {
+	r := New(nil)
+	rc := newReceived()
+	for _, subscription := range []string{"orders/>", "orders/*/created", "orders/eu/created", "orders/eu/*"} {
+		if err := r.Handle(subscription, rc.handler(subscription)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		topic string
+		want  string
+	}{
+		{"orders/eu/created", "orders/eu/created"},
+		{"orders/us/created", "orders/*/created"},
+		{"orders/eu/deleted", "orders/eu/*"},
+		{"orders/us/deleted", "orders/>"},
+		{"invoices/eu/created", ""},
+	}
+	for _, tt := range tests {
+		if got, ok := r.Match(tt.topic); got != tt.want || ok != (tt.want != "") {
+			t.Errorf("Match(%s) = %s, %v, want %s", tt.topic, got, ok, tt.want)
+		}
+		r.Dispatch(fake.NewInboundMessage(tt.topic, "", nil))
+		if tt.want != "" && !slices.Contains(rc.get(tt.want), tt.topic) {
+			t.Errorf("%s not dispatched to %s", tt.topic, tt.want)
+		}
+	}
+	if r.Unrouted() != 1 {
+		t.Errorf("%d unrouted, want 1", r.Unrouted())
+	}
+
+	r.Fallback(rc.handler("fallback"))
+	r.Dispatch(fake.NewInboundMessage("invoices/eu/created", "", nil))
+	if got := rc.get("fallback"); len(got) != 1 || r.Unrouted() != 1 {
+		t.Errorf("fallback got %v, %d unrouted", got, r.Unrouted())
+	}
+
+	// handling a subscription again replaces its handler
+	if err := r.Handle("orders/>", rc.handler("replaced")); err != nil {
+		t.Fatal(err)
+	}
+	r.Dispatch(fake.NewInboundMessage("orders/us/deleted", "", nil))
+	if len(rc.get("replaced")) != 1 || !slices.Equal(r.Routes(), []string{"orders/>", "orders/*/created", "orders/eu/created", "orders/eu/*"}) {
+		t.Errorf("replaced route got %v, routes %v", rc.get("replaced"), r.Routes())
+	}
+	if err := r.Handle("a", nil); err == nil {
+		t.Error("nil handler accepted")
+	}
+	if err := r.Remove("unknown/>"); err == nil {
+		t.Error("removed an unknown route")
+	}
+}
+
+func TestSubscriptions(t *testing.T) {
+	broker := fake.NewBroker()
+	service := broker.NewService()
+	if err := service.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer service.Disconnect()
+	receiver, err := service.CreateDirectMessageReceiverBuilder().Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	publisher, err := service.CreateDirectMessagePublisherBuilder().Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := publisher.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer publisher.Terminate(time.Second)
+
+	r := New(receiver)
+	rc := newReceived()
+	if err := r.Handle("orders/>", rc.handler("orders")); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer receiver.Terminate(time.Second)
+
+	publish := func(topic string) {
+		t.Helper()
+		if err := publisher.PublishString("", resource.TopicOf(topic)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wait := func() {
+		t.Helper()
+		select {
+		case <-rc.arrived:
+		case <-time.After(time.Second):
+			t.Fatal("no message dispatched")
+		}
+	}
+
+	// routes handled before and after Start subscribe the receiver
+	publish("orders/eu/created")
+	wait()
+	if err := r.Handle("invoices/>", rc.handler("invoices")); err != nil {
+		t.Fatal(err)
+	}
+	publish("invoices/eu/created")
+	wait()
+
+	// a removed route unsubscribes, so only the orders message arrives
+	if err := r.Remove("invoices/>"); err != nil {
+		t.Fatal(err)
+	}
+	publish("invoices/eu/deleted")
+	publish("orders/eu/deleted")
+	wait()
+	if got := rc.get("orders"); !slices.Equal(got, []string{"orders/eu/created", "orders/eu/deleted"}) {
+		t.Errorf("orders got %v", got)
+	}
+	if got := rc.get("invoices"); !slices.Equal(got, []string{"invoices/eu/created"}) {
+		t.Errorf("invoices got %v", got)
+	}
+}
+
+// controlledReceiver blocks or fails the subscription changes of a direct receiver
+type controlledReceiver struct {
+	solace.DirectMessageReceiver
+
+	mu      sync.Mutex
+	block   chan struct{} // AddSubscription waits for it to be closed when set
+	adding  chan struct{}
+	fail    map[string]error
+	changes []string
+}
+
+func (c *controlledReceiver) AddSubscription(subscription resource.Subscription) error {
+	c.mu.Lock()
+	block, fail := c.block, c.fail[subscription.GetName()]
+	c.mu.Unlock()
+	if block != nil {
+		c.adding <- struct{}{}
+		<-block
+	}
+	if fail != nil {
+		return fail
+	}
+	c.record("+" + subscription.GetName())
+	return c.DirectMessageReceiver.AddSubscription(subscription)
+}
+
+func (c *controlledReceiver) RemoveSubscription(subscription resource.Subscription) error {
+	if err := c.fail[subscription.GetName()]; err != nil {
+		return err
+	}
+	c.record("-" + subscription.GetName())
+	return c.DirectMessageReceiver.RemoveSubscription(subscription)
+}
+
+func (c *controlledReceiver) record(change string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.changes = append(c.changes, change)
+}
+
+func newControlledReceiver(t *testing.T) *controlledReceiver {
+	t.Helper()
+	service := fake.New()
+	if err := service.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { service.Disconnect() })
+	receiver, err := service.CreateDirectMessageReceiverBuilder().Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &controlledReceiver{DirectMessageReceiver: receiver, adding: make(chan struct{}, 1), fail: map[string]error{}}
+}
+
+func TestHandleDoesNotBlockDispatch(t *testing.T) {
+	receiver := newControlledReceiver(t)
+	r := New(receiver)
+	if err := r.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer receiver.Terminate(time.Second)
+
+	rc := newReceived()
+	if err := r.Handle("orders/>", rc.handler("orders")); err != nil {
+		t.Fatal(err)
+	}
+	receiver.block = make(chan struct{})
+	handled := make(chan error, 1)
+	go func() { handled <- r.Handle("invoices/>", rc.handler("invoices")) }()
+	<-receiver.adding
+
+	// messages are dispatched while the subscription is being added
+	dispatched := make(chan struct{})
+	go func() {
+		r.Dispatch(fake.NewInboundMessage("orders/eu/created", "", nil))
+		r.Routes()
+		close(dispatched)
+	}()
+	select {
+	case <-dispatched:
+	case <-time.After(time.Second):
+		t.Fatal("Dispatch blocked by a subscription being added")
+	}
+	close(receiver.block)
+	if err := <-handled; err != nil {
+		t.Fatal(err)
+	}
+	if len(rc.get("orders")) != 1 {
+		t.Error("message not dispatched")
+	}
+}
+
+func TestHandleFailure(t *testing.T) {
+	receiver := newControlledReceiver(t)
+	r := New(receiver)
+	if err := r.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer receiver.Terminate(time.Second)
+
+	errRefused := errors.New("refused")
+	receiver.fail["invoices/>"] = errRefused
+	if err := r.Handle("invoices/>", func(message.InboundMessage) {}); !errors.Is(err, errRefused) {
+		t.Errorf("error %v, want the receiver's", err)
+	}
+	if routes := r.Routes(); len(routes) != 0 {
+		t.Errorf("routes %v after a failed Handle", routes)
+	}
+
+	if err := r.Handle("orders/>", func(message.InboundMessage) {}); err != nil {
+		t.Fatal(err)
+	}
+	receiver.fail["orders/>"] = errRefused
+	if err := r.Remove("orders/>"); !errors.Is(err, errRefused) {
+		t.Errorf("error %v, want the receiver's", err)
+	}
+	if routes := r.Routes(); !slices.Equal(routes, []string{"orders/>"}) {
+		t.Errorf("routes %v after a failed Remove, want it kept", routes)
+	}
+}
+
+func TestStartRollsBack(t *testing.T) {
+	receiver := newControlledReceiver(t)
+	r := New(receiver)
+	for _, subscription := range []string{"orders/>", "invoices/>", "refunds/>"} {
+		if err := r.Handle(subscription, func(message.InboundMessage) {}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	errRefused := errors.New("refused")
+	receiver.fail["refunds/>"] = errRefused
+
+	if err := r.Start(); !errors.Is(err, errRefused) {
+		t.Fatalf("error %v, want the receiver's", err)
+	}
+	want := []string{"+orders/>", "+invoices/>", "-invoices/>", "-orders/>"}
+	if !slices.Equal(receiver.changes, want) {
+		t.Errorf("subscription changes %v, want %v", receiver.changes, want)
+	}
+	if !receiver.IsTerminated() {
+		t.Error("receiver left running")
+	}
+	// the router was not started, so routes do not touch the receiver
+	if err := r.Handle("returns/>", func(message.InboundMessage) {}); err != nil {
+		t.Errorf("Handle() after a failed Start: %v", err)
+	}
+	if len(receiver.changes) != len(want) {
+		t.Errorf("subscription changes %v after a failed Start", receiver.changes)
+	}
+}
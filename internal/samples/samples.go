@@ -0,0 +1,130 @@
+// Package samples holds the message types and handlers shared by the pattern
+// samples, so that the code the samples run is the code tested against the
+// fake broker.
+package samples
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"solace.dev/go/messaging/pkg/solace"
+	"solace.dev/go/messaging/pkg/solace/message"
+
+	"SolaceSamples.com/PubSub+Go/internal/cloudevents"
+	"SolaceSamples.com/PubSub+Go/internal/codec"
+	"SolaceSamples.com/PubSub+Go/internal/dedup"
+)
+
+// Greeting is published as JSON with -json and as Avro with -schema-registry.
+type Greeting struct {
+	Text     string `json:"text" avro:"text"`
+	Sequence int    `json:"seq" avro:"seq"`
+	Language string `json:"language" avro:"language"`
+}
+
+// Avro schema of Greeting, registered under GreetingSubject. Payloads written
+// with other versions of the subject are resolved against it.
+const (
+	GreetingSubject = "solace-samples-greeting"
+	GreetingSchema  = `{"type": "record", "name": "Greeting", "namespace": "solace.samples", "fields": [
+		{"name": "text", "type": "string"},
+		{"name": "seq", "type": "int"},
+		{"name": "language", "type": "string", "default": "go"}
+	]}`
+)
+
+// NewRunID returns an ID for one run of the guaranteed publisher, so that the
+// sequence numbers it restarts from 0 do not repeat the message IDs of an
+// earlier run.
+func NewRunID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// MessageID is the application message ID of the message with sequence number
+// seq in the run runID. The guaranteed publisher sets it on every message, and
+// retries send the same ID, so the guaranteed receiver can skip duplicates.
+func MessageID(runID string, seq int) string {
+	return fmt.Sprintf("%s-%d", runID, seq)
+}
+
+// DedupKey is the key the guaranteed receiver skips duplicates on: the user
+// property given with -dedup-property, or the application message ID.
+func DedupKey(property string) dedup.KeyFunc {
+	if property != "" {
+		return dedup.ByProperty(property)
+	}
+	return dedup.ByApplicationMessageID()
+}
+
+// GreetingRequest is sent as JSON by the blocking requestor started with -json.
+type GreetingRequest struct {
+	Text     string `json:"text"`
+	Sequence int    `json:"seq"`
+}
+
+// GreetingReply is the JSON reply to a GreetingRequest.
+type GreetingReply struct {
+	Text     string `json:"text"`
+	Sequence int    `json:"seq"`
+}
+
+// ReplyText is the text of every reply of the repliers.
+const ReplyText = "Hello from Go Request-Reply Receiver Replier Sample"
+
+// Describe decodes a message received by the receiver samples and returns the
+// line they print for it. CloudEvents, Avro Greetings when greetings is not
+// nil, and JSON payloads are decoded, anything else is read as text. The error
+// tells why a message of one of those formats could not be decoded.
+func Describe(msg message.InboundMessage, greetings *codec.Avro[Greeting]) (string, error) {
+	// CloudEvents in binary or structured mode, e.g. from the direct publisher started with -cloudevents
+	if cloudevents.IsCloudEvent(msg) {
+		e, err := cloudevents.ToEvent(msg)
+		if err != nil {
+			return "", fmt.Errorf("invalid CloudEvent: %w", err)
+		}
+		return fmt.Sprintf("Received CloudEvent %s from %s with id %s: %s", e.Type(), e.Source(), e.ID(), e.Data()), nil
+	}
+
+	// Avro payloads, e.g. from the persistent publisher started with -schema-registry
+	if greetings != nil && codec.IsAvro(msg) {
+		greeting, err := greetings.Decode(msg)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Received Avro Greeting %+v", greeting), nil
+	}
+
+	// JSON payloads, e.g. from the publishers started with -json
+	if codec.IsJSON(msg) {
+		body, err := codec.Decode[map[string]interface{}](msg)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Received JSON Message Body %v", body), nil
+	}
+
+	return fmt.Sprintf("Received Message Body %s", codec.Text(msg)), nil
+}
+
+// Uppercase is the processing stage of the direct and guaranteed processors.
+func Uppercase(messageBody string) (string, error) {
+	return strings.ToUpper(messageBody), nil
+}
+
+// Reply builds the reply of the replier samples to request. A JSON
+// GreetingRequest gets a JSON GreetingReply with its sequence number, any other
+// request a text reply that quotes it.
+func Reply(builder solace.OutboundMessageBuilder, request message.InboundMessage) (message.OutboundMessage, error) {
+	if codec.IsJSON(request) {
+		greeting, err := codec.Decode[GreetingRequest](request)
+		if err != nil {
+			return nil, err
+		}
+		return codec.Encode(builder, GreetingReply{Text: ReplyText, Sequence: greeting.Sequence})
+	}
+	return builder.BuildWithStringPayload(ReplyText + "\nReply from: " + codec.Text(request))
+}
@@ -0,0 +1,368 @@
+package samples_test
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloudevents/sdk-go/v2/event"
+	"solace.dev/go/messaging/pkg/solace"
+	"solace.dev/go/messaging/pkg/solace/config"
+	"solace.dev/go/messaging/pkg/solace/message"
+	"solace.dev/go/messaging/pkg/solace/resource"
+
+	"SolaceSamples.com/PubSub+Go/internal/cloudevents"
+	"SolaceSamples.com/PubSub+Go/internal/codec"
+	"SolaceSamples.com/PubSub+Go/internal/dedup"
+	"SolaceSamples.com/PubSub+Go/internal/fake"
+	"SolaceSamples.com/PubSub+Go/internal/pipeline"
+	"SolaceSamples.com/PubSub+Go/internal/samples"
+	"SolaceSamples.com/PubSub+Go/internal/schema"
+)
+
+// connect returns a connected service of broker that is disconnected at the end of the test
+func connect(t *testing.T, broker *fake.Broker) *fake.Service {
+	t.Helper()
+	service := broker.NewService()
+	if err := service.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { service.Disconnect() })
+	return service
+}
+
+// subscribe starts a direct receiver on service and returns the channel it delivers to
+func subscribe(t *testing.T, service solace.MessagingService, subscription string) <-chan message.InboundMessage {
+	t.Helper()
+	receiver, err := service.CreateDirectMessageReceiverBuilder().
+		WithSubscriptions(resource.TopicSubscriptionOf(subscription)).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := receiver.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { receiver.Terminate(0) })
+	messages := make(chan message.InboundMessage, 16)
+	if err := receiver.ReceiveAsync(func(msg message.InboundMessage) { messages <- msg }); err != nil {
+		t.Fatal(err)
+	}
+	return messages
+}
+
+// startPublisher starts a direct publisher on service
+func startPublisher(t *testing.T, service solace.MessagingService) solace.DirectMessagePublisher {
+	t.Helper()
+	publisher, err := service.CreateDirectMessagePublisherBuilder().Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := publisher.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { publisher.Terminate(0) })
+	return publisher
+}
+
+// receive waits a second for a message on messages
+func receive(t *testing.T, messages <-chan message.InboundMessage) message.InboundMessage {
+	t.Helper()
+	select {
+	case msg := <-messages:
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("no message received")
+		return nil
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	const topic = "solace/samples/go/direct/sub"
+	greetings, err := codec.NewAvro[samples.Greeting](schema.NewMemory(), samples.GreetingSubject, samples.GreetingSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	greeting := samples.Greeting{Text: "Hello", Sequence: 7, Language: "go"}
+	cloudEvent := func(mode cloudevents.Mode) func(solace.OutboundMessageBuilder) (message.OutboundMessage, error) {
+		return func(builder solace.OutboundMessageBuilder) (message.OutboundMessage, error) {
+			e := event.New()
+			e.SetID("run-7")
+			e.SetType("com.solace.samples.greeting")
+			e.SetSource("/solace/samples/go/direct/publisher")
+			if err := e.SetData(event.ApplicationJSON, greeting); err != nil {
+				return nil, err
+			}
+			return cloudevents.Build(builder, e, mode)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		build     func(builder solace.OutboundMessageBuilder) (message.OutboundMessage, error)
+		greetings *codec.Avro[samples.Greeting]
+		want      string
+		wantErr   bool
+	}{
+		{name: "text", build: func(builder solace.OutboundMessageBuilder) (message.OutboundMessage, error) {
+			return builder.BuildWithStringPayload("Hello --> 7")
+		}, want: "Received Message Body Hello --> 7"},
+		{name: "bytes", build: func(builder solace.OutboundMessageBuilder) (message.OutboundMessage, error) {
+			return builder.BuildWithByteArrayPayload([]byte("Hello --> 7"))
+		}, want: "Received Message Body Hello --> 7"},
+		{name: "JSON", build: func(builder solace.OutboundMessageBuilder) (message.OutboundMessage, error) {
+			return codec.Encode(builder, greeting)
+		}, want: "Received JSON Message Body map[language:go seq:7 text:Hello]"},
+		{name: "invalid JSON", build: func(builder solace.OutboundMessageBuilder) (message.OutboundMessage, error) {
+			return builder.WithHTTPContentHeader(codec.ContentTypeJSON, "").BuildWithByteArrayPayload([]byte(`{"text": `))
+		}, wantErr: true},
+		{name: "binary CloudEvent", build: cloudEvent(cloudevents.Binary),
+			want: `Received CloudEvent com.solace.samples.greeting from /solace/samples/go/direct/publisher with id run-7: {"text":"Hello","seq":7,"language":"go"}`},
+		{name: "structured CloudEvent", build: cloudEvent(cloudevents.Structured),
+			want: `Received CloudEvent com.solace.samples.greeting from /solace/samples/go/direct/publisher with id run-7: {"text":"Hello","seq":7,"language":"go"}`},
+		{name: "Avro", greetings: greetings, build: func(builder solace.OutboundMessageBuilder) (message.OutboundMessage, error) {
+			return greetings.Encode(builder, greeting)
+		}, want: "Received Avro Greeting {Text:Hello Sequence:7 Language:go}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			broker := fake.NewBroker()
+			messages := subscribe(t, connect(t, broker), topic)
+			service := connect(t, broker)
+			msg, err := tt.build(service.MessageBuilder())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := startPublisher(t, service).Publish(msg, resource.TopicOf(topic)); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := samples.Describe(receive(t, messages), tt.greetings)
+			if tt.wantErr {
+				var decodeErr *codec.DecodeError
+				if !errors.As(err, &decodeErr) {
+					t.Errorf("error %v, want a DecodeError", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Describe() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUppercaseProcessor(t *testing.T) {
+	const (
+		inputTopic  = "solace/samples/direct/processor/input"
+		outputTopic = "solace/samples/direct/processor/output"
+	)
+	broker := fake.NewBroker()
+	// the processor of the direct_processor sample
+	processor := pipeline.Pipeline[string, string]{
+		Source: pipeline.Subscription(inputTopic),
+		Decode: pipeline.DecodeString,
+		Stage:  pipeline.Map(samples.Uppercase),
+		Encode: pipeline.EncodeString,
+		Sink:   pipeline.ToSibling("output"),
+	}
+	running, err := processor.Start(connect(t, broker))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer running.Stop(time.Second)
+
+	outputs := subscribe(t, connect(t, broker), outputTopic)
+	publisher := startPublisher(t, connect(t, broker))
+	for input, want := range map[string]string{
+		"hello world":       "HELLO WORLD",
+		"Already Mixed 123": "ALREADY MIXED 123",
+		"":                  "",
+	} {
+		if err := publisher.PublishString(input, resource.TopicOf(inputTopic)); err != nil {
+			t.Fatal(err)
+		}
+		if body := codec.Text(receive(t, outputs)); body != want {
+			t.Errorf("output for %q = %q, want %q", input, body, want)
+		}
+	}
+}
+
+func TestReply(t *testing.T) {
+	const topic = "solace/samples/go/direct/request"
+	broker := fake.NewBroker()
+
+	// the replier of the direct_replier samples
+	replierService := connect(t, broker)
+	receiver, err := replierService.RequestReply().CreateRequestReplyMessageReceiverBuilder().
+		Build(resource.TopicSubscriptionOf("solace/samples/*/direct/request"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := receiver.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer receiver.Terminate(0)
+	if err := receiver.ReceiveAsync(func(request message.InboundMessage, replier solace.Replier) {
+		reply, err := samples.Reply(replierService.MessageBuilder(), request)
+		if err != nil {
+			return
+		}
+		replier.Reply(reply)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	service := connect(t, broker)
+	publisher, err := service.RequestReply().CreateRequestReplyMessagePublisherBuilder().Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := publisher.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer publisher.Terminate(0)
+
+	// a text request is quoted in a text reply
+	request, _ := service.MessageBuilder().BuildWithStringPayload("Hello --> 1")
+	reply, err := publisher.PublishAwaitResponse(request, resource.TopicOf(topic), time.Second, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body := codec.Text(reply); body != samples.ReplyText+"\nReply from: Hello --> 1" {
+		t.Errorf("text reply %q", body)
+	}
+	if codec.IsJSON(reply) {
+		t.Error("text reply has the JSON content type")
+	}
+
+	// a JSON request gets a JSON reply with its sequence number, as the blocking requestor sends with -json
+	greeting, err := codec.Request[samples.GreetingReply](publisher, service.MessageBuilder(), resource.TopicOf(topic),
+		samples.GreetingRequest{Text: "Hello", Sequence: 2}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if greeting != (samples.GreetingReply{Text: samples.ReplyText, Sequence: 2}) {
+		t.Errorf("JSON reply %+v", greeting)
+	}
+
+	// an invalid JSON request is not answered
+	invalid, _ := service.MessageBuilder().WithHTTPContentHeader(codec.ContentTypeJSON, "").BuildWithByteArrayPayload([]byte("{"))
+	_, err = publisher.PublishAwaitResponse(invalid, resource.TopicOf(topic), 50*time.Millisecond, nil)
+	var timeout *solace.TimeoutError
+	if !errors.As(err, &timeout) {
+		t.Errorf("error %v, want a timeout", err)
+	}
+}
+
+func TestGuaranteedRedelivery(t *testing.T) {
+	const topic = "solace/samples/persistent/publisher"
+	tests := []struct {
+		name     string
+		property string // -dedup-property of the receiver
+	}{
+		{"application message ID", ""},
+		{"user property", "message-id"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			broker := fake.NewBroker()
+			queue := broker.CreateQueue("nondurable-queue", topic)
+
+			// the receiver of the guaranteed_receiver sample, failing the first delivery of sequence number 1
+			receiverService := connect(t, broker)
+			persistentReceiver, err := receiverService.CreatePersistentMessageReceiverBuilder().
+				WithMessageClientAcknowledgement().
+				WithRequiredMessageOutcomeSupport(config.PersistentReceiverFailedOutcome, config.PersistentReceiverRejectedOutcome).
+				Build(resource.QueueNonDurableExclusive(queue.Name()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := persistentReceiver.Start(); err != nil {
+				t.Fatal(err)
+			}
+			defer persistentReceiver.Terminate(0)
+			receiver := dedup.NewReceiver(persistentReceiver, dedup.NewLRU(10), dedup.WithKey(samples.DedupKey(tt.property)))
+			var mu sync.Mutex
+			var handled []string
+			failed := false
+			if err := receiver.ReceiveAsync(func(msg message.InboundMessage) error {
+				mu.Lock()
+				defer mu.Unlock()
+				line, err := samples.Describe(msg, nil)
+				if err != nil {
+					return err
+				}
+				if line == "Received Message Body Hello --> 1" && !failed {
+					failed = true
+					return errors.New("handler failed")
+				}
+				handled = append(handled, line)
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			// the messages of the guaranteed_publisher sample, sequence number 0 is sent again as a retry would
+			service := connect(t, broker)
+			publisher, err := service.CreatePersistentMessagePublisherBuilder().Build()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := publisher.Start(); err != nil {
+				t.Fatal(err)
+			}
+			defer publisher.Terminate(0)
+			runID := samples.NewRunID()
+			builder := service.MessageBuilder()
+			for _, seq := range []int{0, 0, 1} {
+				builder.WithApplicationMessageID(samples.MessageID(runID, seq))
+				if tt.property != "" {
+					builder.WithProperty(config.MessageProperty(tt.property), samples.MessageID(runID, seq))
+				}
+				msg, err := builder.BuildWithStringPayload(fmt.Sprintf("Hello --> %d", seq))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := publisher.PublishAwaitAcknowledgement(msg, resource.TopicOf(topic), time.Second, nil); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			deadline := time.Now().Add(2 * time.Second)
+			for queue.Pending()+queue.Unacked() > 0 {
+				if time.Now().After(deadline) {
+					t.Fatalf("pending=%d unacked=%d", queue.Pending(), queue.Unacked())
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			want := []string{"Received Message Body Hello --> 0", "Received Message Body Hello --> 1"}
+			if fmt.Sprint(handled) != fmt.Sprint(want) {
+				t.Errorf("handled %q, want %q", handled, want)
+			}
+			if stats := receiver.Stats(); stats != (dedup.Stats{Processed: 2, Duplicates: 1, Redelivered: 1, Failed: 1}) {
+				t.Errorf("stats %s", stats)
+			}
+		})
+	}
+}
+
+func TestMessageID(t *testing.T) {
+	run, other := samples.NewRunID(), samples.NewRunID()
+	if run == other {
+		t.Errorf("two runs got the ID %s", run)
+	}
+	if samples.MessageID(run, 1) != samples.MessageID(run, 1) {
+		t.Error("the message ID of a retry differs")
+	}
+	if samples.MessageID(run, 1) == samples.MessageID(other, 1) || samples.MessageID(run, 1) == samples.MessageID(run, 2) {
+		t.Error("message IDs of different messages collide")
+	}
+}
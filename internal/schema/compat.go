@@ -0,0 +1,194 @@
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hamba/avro/v2"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// Check reports why candidate does not satisfy rule against registered, both
+// must have the same format.
+func Check(rule Compatibility, registered, candidate *Schema) error {
+	if registered.Format != candidate.Format {
+		return fmt.Errorf("format %s instead of %s", candidate.Format, registered.Format)
+	}
+	var compatible func(reader, writer *Schema) error
+	switch candidate.Format {
+	case Avro:
+		compatible = avroCompatible
+	case Protobuf:
+		compatible = protobufCompatible
+	default:
+		return fmt.Errorf("unsupported schema format %q", candidate.Format)
+	}
+	switch rule {
+	case None:
+		return nil
+	case Backward:
+		return compatible(candidate, registered)
+	case Forward:
+		return compatible(registered, candidate)
+	case Full:
+		if err := compatible(candidate, registered); err != nil {
+			return err
+		}
+		return compatible(registered, candidate)
+	default:
+		return fmt.Errorf("unknown compatibility %q", rule)
+	}
+}
+
+// canonicalize returns a form of the definition that equal schemas share
+func canonicalize(s *Schema) (string, error) {
+	switch s.Format {
+	case Avro:
+		parsed, err := ParseAvro(s.Definition)
+		if err != nil {
+			return "", err
+		}
+		return parsed.String(), nil
+	case Protobuf:
+		if _, err := ParseProtobuf(s.Definition); err != nil {
+			return "", err
+		}
+		return s.Definition, nil
+	default:
+		return "", fmt.Errorf("unsupported schema format %q", s.Format)
+	}
+}
+
+// ParseAvro parses an Avro definition. Named types are resolved within the
+// definition only, so different versions of a record can be parsed side by side.
+func ParseAvro(definition string) (avro.Schema, error) {
+	return avro.ParseWithCache(definition, "", &avro.SchemaCache{})
+}
+
+func avroCompatible(reader, writer *Schema) error {
+	r, err := ParseAvro(reader.Definition)
+	if err != nil {
+		return err
+	}
+	w, err := ParseAvro(writer.Definition)
+	if err != nil {
+		return err
+	}
+	return avro.NewSchemaCompatibility().Compatible(r, w)
+}
+
+// protobufDefinition is the definition of a Protobuf schema: the message and
+// the files declaring it and its dependencies
+type protobufDefinition struct {
+	Message string          `json:"message"`
+	Files   json.RawMessage `json:"files"`
+}
+
+// ProtobufDefinition returns the definition of the Protobuf message desc, as
+// registered for generated message types.
+func ProtobufDefinition(desc protoreflect.MessageDescriptor) (string, error) {
+	set := &descriptorpb.FileDescriptorSet{}
+	seen := map[string]bool{}
+	var add func(file protoreflect.FileDescriptor)
+	add = func(file protoreflect.FileDescriptor) {
+		if seen[file.Path()] {
+			return
+		}
+		seen[file.Path()] = true
+		imports := file.Imports()
+		for i := 0; i < imports.Len(); i++ {
+			add(imports.Get(i).FileDescriptor)
+		}
+		set.File = append(set.File, protodesc.ToFileDescriptorProto(file))
+	}
+	add(desc.ParentFile())
+
+	files, err := protojson.Marshal(set)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(protobufDefinition{Message: string(desc.FullName()), Files: files})
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// ParseProtobuf returns the message described by a Protobuf definition.
+func ParseProtobuf(definition string) (protoreflect.MessageDescriptor, error) {
+	var def protobufDefinition
+	if err := json.Unmarshal([]byte(definition), &def); err != nil {
+		return nil, fmt.Errorf("protobuf definition: %w", err)
+	}
+	set := &descriptorpb.FileDescriptorSet{}
+	if err := protojson.Unmarshal(def.Files, set); err != nil {
+		return nil, fmt.Errorf("protobuf definition: %w", err)
+	}
+	files, err := protodesc.NewFiles(set)
+	if err != nil {
+		return nil, fmt.Errorf("protobuf definition: %w", err)
+	}
+	desc, err := files.FindDescriptorByName(protoreflect.FullName(def.Message))
+	if err != nil {
+		if errors.Is(err, protoregistry.NotFound) {
+			return nil, fmt.Errorf("protobuf definition: message %s not declared", def.Message)
+		}
+		return nil, fmt.Errorf("protobuf definition: %w", err)
+	}
+	msg, ok := desc.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("protobuf definition: %s is not a message", def.Message)
+	}
+	return msg, nil
+}
+
+func protobufCompatible(reader, writer *Schema) error {
+	r, err := ParseProtobuf(reader.Definition)
+	if err != nil {
+		return err
+	}
+	w, err := ParseProtobuf(writer.Definition)
+	if err != nil {
+		return err
+	}
+	return compareMessages(r, w, map[protoreflect.FullName]bool{})
+}
+
+// compareMessages checks that reader can parse what writer writes: fields that
+// share a number must share their kind and cardinality, and the reader must not
+// require a field the writer does not have. Fields only one side has are
+// skipped or left unset, which the wire format allows.
+func compareMessages(reader, writer protoreflect.MessageDescriptor, visited map[protoreflect.FullName]bool) error {
+	if visited[reader.FullName()] {
+		return nil
+	}
+	visited[reader.FullName()] = true
+	fields := reader.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		rf := fields.Get(i)
+		wf := writer.Fields().ByNumber(rf.Number())
+		if wf == nil {
+			if rf.Cardinality() == protoreflect.Required {
+				return fmt.Errorf("field %s is required but missing in %s", rf.FullName(), writer.FullName())
+			}
+			continue
+		}
+		if rf.Kind() != wf.Kind() {
+			return fmt.Errorf("field %d of %s is %s instead of %s", rf.Number(), reader.FullName(), rf.Kind(), wf.Kind())
+		}
+		if rf.IsList() != wf.IsList() || rf.IsMap() != wf.IsMap() {
+			return fmt.Errorf("field %d of %s changed between repeated and singular", rf.Number(), reader.FullName())
+		}
+		if rf.Message() != nil {
+			if err := compareMessages(rf.Message(), wf.Message(), visited); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
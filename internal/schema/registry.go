@@ -0,0 +1,301 @@
+// Package schema keeps the Avro and Protobuf schemas of message payloads in a
+// registry. Every subject, e.g. the payload of one topic hierarchy, has a list
+// of schema versions, and every version has an ID that is unique across all
+// subjects and is sent with each message so receivers can look up the schema
+// the payload was written with.
+//
+// A new version is only registered when it is compatible with the latest
+// version of its subject under the subject's compatibility rule, so producers
+// cannot publish payloads the existing consumers cannot read.
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Format is the schema language of a definition.
+type Format string
+
+// Supported formats
+const (
+	Avro     Format = "AVRO"
+	Protobuf Format = "PROTOBUF"
+)
+
+// Compatibility is the rule a new version of a subject must satisfy against
+// its latest version.
+type Compatibility string
+
+// Compatibility rules, Backward is the default
+const (
+	// Backward lets consumers using the new version read data written with the latest one.
+	Backward Compatibility = "BACKWARD"
+	// Forward lets consumers using the latest version read data written with the new one.
+	Forward Compatibility = "FORWARD"
+	// Full is both Backward and Forward.
+	Full Compatibility = "FULL"
+	// None accepts any new version.
+	None Compatibility = "NONE"
+)
+
+// Schema is a registered version of a subject.
+type Schema struct {
+	ID         int    `json:"id"`
+	Subject    string `json:"subject"`
+	Version    int    `json:"version"`
+	Format     Format `json:"format"`
+	Definition string `json:"schema"`
+}
+
+func (s *Schema) String() string {
+	return fmt.Sprintf("%s version %d (id %d)", s.Subject, s.Version, s.ID)
+}
+
+// Registry looks up and registers schemas. Implementations must be safe for
+// concurrent use.
+type Registry interface {
+	// Register returns the version of subject with definition, registering it
+	// as a new version when the subject has none with the same definition. It
+	// fails with an *IncompatibleError when the subject's compatibility rule
+	// rejects the definition.
+	Register(subject string, format Format, definition string) (*Schema, error)
+	// Latest returns the latest version of subject.
+	Latest(subject string) (*Schema, error)
+	// ByID returns the version with the given ID.
+	ByID(id int) (*Schema, error)
+}
+
+// ErrNotFound is returned for unknown subjects and IDs.
+var ErrNotFound = errors.New("schema not found")
+
+// IncompatibleError tells why a definition was not registered.
+type IncompatibleError struct {
+	Subject       string
+	Latest        int // version the definition was checked against
+	Compatibility Compatibility
+	Err           error
+}
+
+func (e *IncompatibleError) Error() string {
+	return fmt.Sprintf("schema is not %s compatible with %s version %d: %v", e.Compatibility, e.Subject, e.Latest, e.Err)
+}
+
+func (e *IncompatibleError) Unwrap() error {
+	return e.Err
+}
+
+// subject holds the versions of a subject, oldest first
+type subject struct {
+	Compatibility Compatibility `json:"compatibility,omitempty"`
+	Versions      []*Schema     `json:"versions"`
+}
+
+// Memory is a Registry holding the schemas in memory, see OpenFile for one
+// that is kept in a file.
+type Memory struct {
+	mu       sync.Mutex
+	subjects map[string]*subject
+	byID     map[int]*Schema
+	nextID   int
+	// save persists the registry after a change, the caller holds mu
+	save func() error
+}
+
+// NewMemory creates an empty in-memory registry.
+func NewMemory() *Memory {
+	return &Memory{subjects: make(map[string]*subject), byID: make(map[int]*Schema), nextID: 1}
+}
+
+// Register implements Registry.
+func (m *Memory) Register(name string, format Format, definition string) (*Schema, error) {
+	if format != Avro && format != Protobuf {
+		return nil, fmt.Errorf("subject %s: unsupported schema format %q", name, format)
+	}
+	candidate := &Schema{Subject: name, Format: format, Definition: definition}
+	canonical, err := canonicalize(candidate)
+	if err != nil {
+		return nil, fmt.Errorf("subject %s: %w", name, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	subj := m.subjects[name]
+	created := subj == nil
+	if created {
+		subj = &subject{}
+	}
+	for _, version := range subj.Versions {
+		if version.Format != format {
+			continue
+		}
+		if existing, err := canonicalize(version); err == nil && existing == canonical {
+			return version, nil
+		}
+	}
+	if n := len(subj.Versions); n > 0 {
+		latest := subj.Versions[n-1]
+		rule := subj.compatibility()
+		if err := Check(rule, latest, candidate); err != nil {
+			return nil, &IncompatibleError{Subject: name, Latest: latest.Version, Compatibility: rule, Err: err}
+		}
+	}
+
+	candidate.ID = m.nextID
+	candidate.Version = len(subj.Versions) + 1
+	subj.Versions = append(subj.Versions, candidate)
+	m.subjects[name] = subj
+	m.byID[candidate.ID] = candidate
+	if m.save != nil {
+		if err := m.save(); err != nil {
+			// forget the version, and the subject it created, the file does not have them
+			subj.Versions = subj.Versions[:len(subj.Versions)-1]
+			delete(m.byID, candidate.ID)
+			if created {
+				delete(m.subjects, name)
+			}
+			return nil, fmt.Errorf("subject %s: %w", name, err)
+		}
+	}
+	m.nextID++
+	return candidate, nil
+}
+
+// Latest implements Registry.
+func (m *Memory) Latest(name string) (*Schema, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	subj := m.subjects[name]
+	if subj == nil || len(subj.Versions) == 0 {
+		return nil, fmt.Errorf("subject %s: %w", name, ErrNotFound)
+	}
+	return subj.Versions[len(subj.Versions)-1], nil
+}
+
+// ByID implements Registry.
+func (m *Memory) ByID(id int) (*Schema, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.byID[id]
+	if !ok {
+		return nil, fmt.Errorf("schema id %d: %w", id, ErrNotFound)
+	}
+	return s, nil
+}
+
+// SetCompatibility sets the rule new versions of subject are checked with.
+func (m *Memory) SetCompatibility(name string, rule Compatibility) error {
+	switch rule {
+	case Backward, Forward, Full, None:
+	default:
+		return fmt.Errorf("subject %s: unknown compatibility %q", name, rule)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	subj := m.subjects[name]
+	created := subj == nil
+	if created {
+		subj = &subject{}
+		m.subjects[name] = subj
+	}
+	previous := subj.Compatibility
+	subj.Compatibility = rule
+	if m.save != nil {
+		if err := m.save(); err != nil {
+			// keep the rule of the file
+			subj.Compatibility = previous
+			if created {
+				delete(m.subjects, name)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Subjects returns the names of the subjects, sorted.
+func (m *Memory) Subjects() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	names := make([]string, 0, len(m.subjects))
+	for name := range m.subjects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *subject) compatibility() Compatibility {
+	if s.Compatibility == "" {
+		return Backward
+	}
+	return s.Compatibility
+}
+
+// fileContent is the layout of a registry file
+type fileContent struct {
+	Subjects map[string]*subject `json:"subjects"`
+}
+
+// OpenFile opens the registry kept in the JSON file at path, for use without a
+// registry server. The file is created on the first registration and rewritten
+// after every change, the versions of a subject can also be edited by hand
+// while the registry is not open.
+func OpenFile(path string) (*Memory, error) {
+	m := NewMemory()
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		var content fileContent
+		if err := json.Unmarshal(data, &content); err != nil {
+			return nil, fmt.Errorf("schema registry %s: %w", path, err)
+		}
+		for name, subj := range content.Subjects {
+			for i, version := range subj.Versions {
+				version.Subject = name
+				version.Version = i + 1
+				if _, dup := m.byID[version.ID]; dup || version.ID <= 0 {
+					return nil, fmt.Errorf("schema registry %s: subject %s version %d: invalid or duplicate id %d", path, name, version.Version, version.ID)
+				}
+				m.byID[version.ID] = version
+				if version.ID >= m.nextID {
+					m.nextID = version.ID + 1
+				}
+			}
+			m.subjects[name] = subj
+		}
+	}
+	m.save = func() error {
+		return writeFile(path, fileContent{Subjects: m.subjects})
+	}
+	return m, nil
+}
+
+// writeFile replaces the file at path, so a crash leaves the old or the new content
+func writeFile(path string, content fileContent) error {
+	data, err := json.MarshalIndent(content, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
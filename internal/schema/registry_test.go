@@ -0,0 +1,224 @@
+package schema
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	greetingV1 = `{"type": "record", "name": "Greeting", "fields": [
+		{"name": "text", "type": "string"},
+		{"name": "seq", "type": "int"}
+	]}`
+	// adds a field with a default, readable by v1 consumers and able to read v1 data
+	greetingV2 = `{"type": "record", "name": "Greeting", "fields": [
+		{"name": "text", "type": "string"},
+		{"name": "seq", "type": "int"},
+		{"name": "language", "type": "string", "default": "go"}
+	]}`
+	// adds a field without a default, cannot read v1 data
+	greetingRequired = `{"type": "record", "name": "Greeting", "fields": [
+		{"name": "text", "type": "string"},
+		{"name": "seq", "type": "int"},
+		{"name": "region", "type": "string"}
+	]}`
+	// drops a field without a default, v1 consumers cannot read its data
+	greetingDropped = `{"type": "record", "name": "Greeting", "fields": [
+		{"name": "text", "type": "string"}
+	]}`
+)
+
+func TestRegisterCompatibility(t *testing.T) {
+	tests := []struct {
+		rule      Compatibility
+		candidate string
+		wantErr   bool
+	}{
+		{Backward, greetingV2, false},
+		{Backward, greetingRequired, true},
+		{Backward, greetingDropped, false},
+		{Forward, greetingV2, false},
+		{Forward, greetingRequired, false},
+		{Forward, greetingDropped, true},
+		{Full, greetingV2, false},
+		{Full, greetingRequired, true},
+		{Full, greetingDropped, true},
+		{None, greetingRequired, false},
+	}
+	for _, tt := range tests {
+		m := NewMemory()
+		if err := m.SetCompatibility("greeting", tt.rule); err != nil {
+			t.Fatal(err)
+		}
+		first, err := m.Register("greeting", Avro, greetingV1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		registered, err := m.Register("greeting", Avro, tt.candidate)
+		var incompatible *IncompatibleError
+		if tt.wantErr {
+			if !errors.As(err, &incompatible) || incompatible.Latest != 1 || incompatible.Compatibility != tt.rule {
+				t.Errorf("%s: error %v, want an IncompatibleError", tt.rule, err)
+			}
+			if latest, _ := m.Latest("greeting"); latest != first {
+				t.Errorf("%s: latest %s after a rejected version", tt.rule, latest)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.rule, err)
+			continue
+		}
+		if registered.Version != 2 || registered.ID != 2 {
+			t.Errorf("%s: registered %s, want version 2 with id 2", tt.rule, registered)
+		}
+	}
+}
+
+func TestRegister(t *testing.T) {
+	m := NewMemory()
+	first, err := m.Register("greeting", Avro, greetingV1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the same definition, formatted differently, is the same version
+	again, err := m.Register("greeting", Avro, `{"type":"record","name":"Greeting","fields":[{"name":"text","type":"string"},{"name":"seq","type":"int"}]}`)
+	if err != nil || again != first {
+		t.Errorf("registered again as %v, %v, want %s", again, err, first)
+	}
+	// IDs are unique across subjects, versions are per subject
+	other, err := m.Register("other", Avro, greetingV1)
+	if err != nil || other.ID != 2 || other.Version != 1 {
+		t.Errorf("other subject registered as %v, %v", other, err)
+	}
+	if got, err := m.ByID(2); err != nil || got != other {
+		t.Errorf("ByID(2) = %v, %v", got, err)
+	}
+	if _, err := m.ByID(3); !errors.Is(err, ErrNotFound) {
+		t.Errorf("ByID(3) error %v, want ErrNotFound", err)
+	}
+	if _, err := m.Latest("unknown"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Latest error %v, want ErrNotFound", err)
+	}
+
+	for _, invalid := range []struct {
+		format     Format
+		definition string
+	}{
+		{Avro, `{"type": "record"}`},
+		{Protobuf, `{"message": "missing"}`},
+		{"JSON", `{}`},
+	} {
+		if _, err := m.Register("invalid", invalid.format, invalid.definition); err == nil {
+			t.Errorf("%s definition %s registered", invalid.format, invalid.definition)
+		}
+	}
+	if err := m.SetCompatibility("greeting", "SIDEWAYS"); err == nil {
+		t.Error("unknown compatibility accepted")
+	}
+}
+
+func TestRegisterProtobuf(t *testing.T) {
+	m := NewMemory()
+	timestamp, err := ProtobufDefinition((&timestamppb.Timestamp{}).ProtoReflect().Descriptor())
+	if err != nil {
+		t.Fatal(err)
+	}
+	duration, err := ProtobufDefinition((&durationpb.Duration{}).ProtoReflect().Descriptor())
+	if err != nil {
+		t.Fatal(err)
+	}
+	registered, err := m.Register("time", Protobuf, timestamp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	desc, err := ParseProtobuf(registered.Definition)
+	if err != nil || desc.FullName() != "google.protobuf.Timestamp" {
+		t.Errorf("parsed %v, %v", desc, err)
+	}
+	// Duration has the same field numbers and types, so it is a compatible version
+	if _, err := m.Register("time", Protobuf, duration); err != nil {
+		t.Errorf("compatible message rejected: %v", err)
+	}
+}
+
+func TestRegisterSaveFailure(t *testing.T) {
+	m := NewMemory()
+	m.save = func() error { return errors.New("disk full") }
+	if _, err := m.Register("greeting", Avro, greetingV1); err == nil {
+		t.Fatal("registered without saving")
+	}
+	if subjects := m.Subjects(); len(subjects) != 0 {
+		t.Errorf("subjects %v kept after a failed save", subjects)
+	}
+	if err := m.SetCompatibility("greeting", Full); err == nil {
+		t.Fatal("compatibility set without saving")
+	}
+	if subjects := m.Subjects(); len(subjects) != 0 {
+		t.Errorf("subjects %v kept after a failed save", subjects)
+	}
+
+	// the failed registration used no ID
+	m.save = nil
+	registered, err := m.Register("greeting", Avro, greetingV1)
+	if err != nil || registered.ID != 1 || registered.Version != 1 {
+		t.Errorf("registered %v, %v, want id 1", registered, err)
+	}
+
+	// a failed version of an existing subject keeps the subject and its rule
+	if err := m.SetCompatibility("greeting", None); err != nil {
+		t.Fatal(err)
+	}
+	m.save = func() error { return errors.New("disk full") }
+	if _, err := m.Register("greeting", Avro, greetingV2); err == nil {
+		t.Fatal("registered without saving")
+	}
+	if err := m.SetCompatibility("greeting", Full); err == nil {
+		t.Fatal("compatibility set without saving")
+	}
+	if latest, err := m.Latest("greeting"); err != nil || latest != registered {
+		t.Errorf("latest %v, %v, want %s", latest, err, registered)
+	}
+	if rule := m.subjects["greeting"].compatibility(); rule != None {
+		t.Errorf("compatibility %s, want the saved %s", rule, None)
+	}
+}
+
+func TestOpenFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.json")
+	m, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetCompatibility("greeting", Forward); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Register("greeting", Avro, greetingV1); err != nil {
+		t.Fatal(err)
+	}
+	v2, err := m.Register("greeting", Avro, greetingV2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := reopened.ByID(v2.ID)
+	if err != nil || *got != *v2 {
+		t.Errorf("ByID(%d) = %v, %v, want %s", v2.ID, got, err, v2)
+	}
+	// the rule and the ID sequence are kept
+	if _, err := reopened.Register("greeting", Avro, greetingDropped); err == nil {
+		t.Error("forward incompatible version registered")
+	}
+	next, err := reopened.Register("other", Avro, greetingV1)
+	if err != nil || next.ID != 3 {
+		t.Errorf("registered %v, %v, want id 3", next, err)
+	}
+}
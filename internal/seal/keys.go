@@ -0,0 +1,188 @@
+package seal
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// KeyProvider hands out the AES keys payloads are encrypted with.
+// Implementations must be safe for concurrent use.
+type KeyProvider interface {
+	// Current returns the key new payloads are encrypted with and its ID.
+	Current() (id string, key []byte, err error)
+	// Key returns the key with the given ID, so payloads encrypted before a
+	// key rotation can still be decrypted.
+	Key(id string) ([]byte, error)
+}
+
+// ErrUnknownKey is returned for key IDs a provider does not have.
+var ErrUnknownKey = errors.New("unknown payload key")
+
+// checkKey accepts the key sizes of AES-128, AES-192 and AES-256
+func checkKey(id string, key []byte) error {
+	switch len(key) {
+	case 16, 24, 32:
+		return nil
+	default:
+		return fmt.Errorf("payload key %s has %d bytes, AES needs 16, 24 or 32", id, len(key))
+	}
+}
+
+// EnvKeys reads base64 encoded keys from environment variables named after
+// the key ID with Prefix and an underscore, and the ID of the current key from
+// Prefix with the suffix _ID, for example:
+//
+//	SOLACE_PAYLOAD_KEY_ID=k2
+//	SOLACE_PAYLOAD_KEY_k1=<base64 of the old key>
+//	SOLACE_PAYLOAD_KEY_k2=<base64 of the current key>
+//
+// The variables are read on every lookup. Key IDs consist of letters, digits
+// and underscores and must not be "ID".
+type EnvKeys struct {
+	Prefix string
+}
+
+// Current implements KeyProvider.
+func (e EnvKeys) Current() (string, []byte, error) {
+	id := os.Getenv(e.Prefix + "_ID")
+	if id == "" {
+		return "", nil, fmt.Errorf("%s_ID is not set", e.Prefix)
+	}
+	key, err := e.Key(id)
+	return id, key, err
+}
+
+// Key implements KeyProvider.
+func (e EnvKeys) Key(id string) ([]byte, error) {
+	name := e.Prefix + "_" + id
+	val, ok := os.LookupEnv(name)
+	if !ok || id == "ID" {
+		return nil, fmt.Errorf("payload key %s: %w", id, ErrUnknownKey)
+	}
+	key, err := base64.StdEncoding.DecodeString(val)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if err := checkKey(id, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Keyring is a KeyProvider holding its keys in memory, see OpenKeyring for one
+// that is kept in a file.
+type Keyring struct {
+	mu      sync.Mutex
+	current string
+	keys    map[string][]byte
+	// refresh reloads the keys when their source changed, the caller holds mu
+	refresh func() error
+}
+
+// NewKeyring creates a keyring encrypting with the key named current.
+func NewKeyring(current string, keys map[string][]byte) (*Keyring, error) {
+	k := &Keyring{}
+	if err := k.set(current, keys); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func (k *Keyring) set(current string, keys map[string][]byte) error {
+	for id, key := range keys {
+		if err := checkKey(id, key); err != nil {
+			return err
+		}
+	}
+	if _, ok := keys[current]; !ok {
+		return fmt.Errorf("current payload key %q: %w", current, ErrUnknownKey)
+	}
+	k.current, k.keys = current, keys
+	return nil
+}
+
+// Current implements KeyProvider.
+func (k *Keyring) Current() (string, []byte, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.refresh != nil {
+		if err := k.refresh(); err != nil {
+			return "", nil, err
+		}
+	}
+	return k.current, k.keys[k.current], nil
+}
+
+// Key implements KeyProvider.
+func (k *Keyring) Key(id string) ([]byte, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.refresh != nil {
+		if err := k.refresh(); err != nil {
+			return nil, err
+		}
+	}
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("payload key %s: %w", id, ErrUnknownKey)
+	}
+	return key, nil
+}
+
+// keyringFile is the layout of a keyring file
+type keyringFile struct {
+	Current string            `json:"current"`
+	Keys    map[string]string `json:"keys"`
+}
+
+// OpenKeyring opens the keyring kept in the JSON file at path, whose keys are
+// base64 encoded:
+//
+//	{"current": "2024-06", "keys": {"2024-01": "...", "2024-06": "..."}}
+//
+// The file is read again when its modification time changes, so a key can be
+// rotated by adding it and making it current without restarting. Replace the
+// file atomically, e.g. by renaming a new one over it.
+func OpenKeyring(path string) (*Keyring, error) {
+	k := &Keyring{}
+	var modTime time.Time
+	k.refresh = func() error {
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("keyring: %w", err)
+		}
+		if info.ModTime().Equal(modTime) {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("keyring: %w", err)
+		}
+		var content keyringFile
+		if err := json.Unmarshal(data, &content); err != nil {
+			return fmt.Errorf("keyring %s: %w", path, err)
+		}
+		keys := make(map[string][]byte, len(content.Keys))
+		for id, val := range content.Keys {
+			key, err := base64.StdEncoding.DecodeString(val)
+			if err != nil {
+				return fmt.Errorf("keyring %s: key %s: %w", path, id, err)
+			}
+			keys[id] = key
+		}
+		if err := k.set(content.Current, keys); err != nil {
+			return fmt.Errorf("keyring %s: %w", path, err)
+		}
+		modTime = info.ModTime()
+		return nil
+	}
+	if err := k.refresh(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
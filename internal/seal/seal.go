@@ -0,0 +1,511 @@
+// Package seal protects message payloads end to end: publishers compress them
+// with gzip or zstd and encrypt them with AES-GCM, receivers decrypt and
+// decompress them before the handler sees the message.
+//
+// A compressed payload is announced by its HTTP content encoding, gzip or
+// zstd, the content type is kept. An encrypted payload carries the ID of its
+// key in the payload_key_id user property and the cipher in payload_cipher, and
+// is the 12 byte nonce followed by the ciphertext. The content type, content
+// encoding and key ID are authenticated along with the payload, so a receiver
+// cannot be tricked into reading a payload other than the way it was written.
+// Payloads are compressed before they are encrypted.
+package seal
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/klauspost/compress/zstd"
+	"solace.dev/go/messaging/pkg/solace"
+	"solace.dev/go/messaging/pkg/solace/config"
+	"solace.dev/go/messaging/pkg/solace/message"
+	"solace.dev/go/messaging/pkg/solace/message/sdt"
+
+	"SolaceSamples.com/PubSub+Go/internal/codec"
+)
+
+// User properties of an encrypted payload
+const (
+	PropertyKeyID  = "payload_key_id"
+	PropertyCipher = "payload_cipher"
+)
+
+// CipherAESGCM is the only supported cipher, the key size selects AES-128,
+// AES-192 or AES-256.
+const CipherAESGCM = "AES-GCM"
+
+// Compression is the content encoding payloads are compressed with.
+type Compression string
+
+// Compressions
+const (
+	NoCompression Compression = "none"
+	Gzip          Compression = "gzip"
+	Zstd          Compression = "zstd"
+)
+
+// ParseCompression parses "none", "gzip" or "zstd", an empty string is none.
+func ParseCompression(s string) (Compression, error) {
+	switch c := Compression(strings.ToLower(strings.TrimSpace(s))); c {
+	case "", NoCompression:
+		return NoCompression, nil
+	case Gzip, Zstd:
+		return c, nil
+	default:
+		return NoCompression, fmt.Errorf("unknown compression %q, none, gzip or zstd", s)
+	}
+}
+
+// DefaultThreshold is the payload size compression starts at, smaller payloads
+// rarely get smaller.
+const DefaultThreshold = 1024
+
+// maxOpened limits the size of a decompressed payload, well above the largest
+// message a broker accepts
+const maxOpened = 64 << 20
+
+// Config selects how published payloads are protected.
+type Config struct {
+	Compression Compression
+	// Threshold is the size in bytes below which payloads are sent uncompressed.
+	Threshold int
+	// Keys encrypts the payloads when set.
+	Keys KeyProvider
+	// NewBuilder returns an empty message builder, it is needed by the publisher
+	// wrappers to rebuild messages with the protected payload.
+	NewBuilder func() solace.OutboundMessageBuilder
+	// Persistent is set on the messages the publisher wrappers rebuild, e.g.
+	// config.MessagePropertyPersistentTimeToLive and
+	// config.MessagePropertyPersistentDMQEligible. A built message does not tell
+	// its time to live or DMQ eligibility, so set them here instead of on the
+	// builder of the messages to publish.
+	Persistent config.MessagePropertyMap
+}
+
+// Error tells why a received payload could not be opened.
+type Error struct {
+	Topic string
+	Err   error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("sealed payload on %s: %v", e.Topic, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Stats is a snapshot of the counters of a Sealer.
+type Stats struct {
+	Sealed     uint64 // payloads published compressed, encrypted or both
+	Compressed uint64 // payloads published compressed
+	Encrypted  uint64 // payloads published encrypted
+	Opened     uint64 // sealed payloads received and opened
+	Failed     uint64 // received payloads that could not be opened
+}
+
+func (s Stats) String() string {
+	return fmt.Sprintf("sealed=%d compressed=%d encrypted=%d opened=%d failed=%d",
+		s.Sealed, s.Compressed, s.Encrypted, s.Opened, s.Failed)
+}
+
+// Sealer protects the payloads of published messages and opens those of
+// received ones. It is safe for concurrent use.
+type Sealer struct {
+	cfg Config
+
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+	zstdErr     error
+
+	mu      sync.Mutex
+	onError func(msg message.InboundMessage, err error)
+
+	sealed, compressed, encrypted, opened, failed atomic.Uint64
+}
+
+// New creates a Sealer. A zero Config publishes payloads unchanged, received
+// payloads are opened with any Config as far as its keys allow.
+func New(cfg Config) (*Sealer, error) {
+	if cfg.Compression == "" {
+		cfg.Compression = NoCompression
+	}
+	if _, err := ParseCompression(string(cfg.Compression)); err != nil {
+		return nil, err
+	}
+	if cfg.Threshold < 0 {
+		return nil, fmt.Errorf("compression threshold %d is negative", cfg.Threshold)
+	}
+	if cfg.Keys != nil {
+		if _, _, err := cfg.Keys.Current(); err != nil {
+			return nil, err
+		}
+	}
+	return &Sealer{cfg: cfg}, nil
+}
+
+// OnError sets the function the receiver wrappers call for messages whose
+// payload cannot be opened, the message is not handed to the handler.
+func (s *Sealer) OnError(fn func(msg message.InboundMessage, err error)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onError = fn
+}
+
+// Stats returns a snapshot of the counters.
+func (s *Sealer) Stats() Stats {
+	return Stats{
+		Sealed:     s.sealed.Load(),
+		Compressed: s.compressed.Load(),
+		Encrypted:  s.encrypted.Load(),
+		Opened:     s.opened.Load(),
+		Failed:     s.failed.Load(),
+	}
+}
+
+// Seal builds a message with payload protected according to the Config, the
+// content header announcing contentType and the compression, and the key
+// properties. Properties already set on builder are kept, the header and key
+// properties are set on the message only.
+func (s *Sealer) Seal(builder solace.OutboundMessageBuilder, payload []byte, contentType string) (message.OutboundMessage, error) {
+	sealed, encoding, properties, err := s.seal(payload, contentType, "")
+	if err != nil {
+		return nil, err
+	}
+	return builder.BuildWithByteArrayPayload(sealed, withContentHeader(properties, contentType, encoding))
+}
+
+// withContentHeader adds the content header to properties unless both are empty
+func withContentHeader(properties config.MessagePropertyMap, contentType, encoding string) config.MessagePropertyMap {
+	if contentType != "" || encoding != "" {
+		properties[config.MessagePropertyHTTPContentType] = contentType
+		properties[config.MessagePropertyHTTPContentEncoding] = encoding
+	}
+	return properties
+}
+
+// seal returns the protected payload with the content encoding and the user
+// properties announcing it. Payloads with a content encoding already are not
+// compressed again.
+func (s *Sealer) seal(payload []byte, contentType, encoding string) ([]byte, string, config.MessagePropertyMap, error) {
+	properties := config.MessagePropertyMap{}
+	compressed := false
+	if s.cfg.Compression != NoCompression && len(payload) >= s.cfg.Threshold && (encoding == "" || encoding == codec.EncodingIdentity) {
+		smaller, err := s.compress(payload)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		// incompressible payloads are sent as they are
+		if len(smaller) < len(payload) {
+			payload, encoding, compressed = smaller, string(s.cfg.Compression), true
+		}
+	}
+	if s.cfg.Keys != nil {
+		id, key, err := s.cfg.Keys.Current()
+		if err != nil {
+			return nil, "", nil, err
+		}
+		aead, err := newAEAD(id, key)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(payload)+aead.Overhead())
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, "", nil, err
+		}
+		payload = aead.Seal(nonce, nonce, payload, additionalData(contentType, encoding, id))
+		properties[PropertyKeyID] = id
+		properties[PropertyCipher] = CipherAESGCM
+		s.encrypted.Add(1)
+	}
+	if compressed {
+		s.compressed.Add(1)
+	}
+	if compressed || s.cfg.Keys != nil {
+		s.sealed.Add(1)
+	}
+	return payload, encoding, properties, nil
+}
+
+// protects reports whether seal changes payloads of the given size
+func (s *Sealer) protects(size int) bool {
+	return s.cfg.Keys != nil || (s.cfg.Compression != NoCompression && size >= s.cfg.Threshold)
+}
+
+// IsSealed reports whether the payload of msg is compressed or encrypted.
+func IsSealed(msg message.InboundMessage) bool {
+	if msg.HasProperty(PropertyKeyID) {
+		return true
+	}
+	encoding, _ := msg.GetHTTPContentEncoding()
+	return encoding == string(Gzip) || encoding == string(Zstd)
+}
+
+// Open returns msg with its payload decrypted and decompressed, or msg itself
+// when it is not sealed. It fails with an *Error.
+func (s *Sealer) Open(msg message.InboundMessage) (message.InboundMessage, error) {
+	if !IsSealed(msg) {
+		return msg, nil
+	}
+	payload, err := s.open(msg)
+	if err != nil {
+		s.failed.Add(1)
+		return nil, &Error{Topic: msg.GetDestinationName(), Err: err}
+	}
+	s.opened.Add(1)
+	return &Message{InboundMessage: msg, payload: payload}, nil
+}
+
+func (s *Sealer) open(msg message.InboundMessage) ([]byte, error) {
+	payload, _ := codec.Payload(msg)
+	contentType, _ := msg.GetHTTPContentType()
+	encoding, _ := msg.GetHTTPContentEncoding()
+
+	if val, ok := msg.GetProperty(PropertyKeyID); ok {
+		id := fmt.Sprint(val)
+		if c, _ := msg.GetProperty(PropertyCipher); fmt.Sprint(c) != CipherAESGCM {
+			return nil, fmt.Errorf("unsupported cipher %v", c)
+		}
+		if s.cfg.Keys == nil {
+			return nil, fmt.Errorf("encrypted with key %s but no keys are configured", id)
+		}
+		key, err := s.cfg.Keys.Key(id)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := newAEAD(id, key)
+		if err != nil {
+			return nil, err
+		}
+		if len(payload) < aead.NonceSize() {
+			return nil, errors.New("encrypted payload is shorter than its nonce")
+		}
+		nonce, ciphertext := payload[:aead.NonceSize()], payload[aead.NonceSize():]
+		payload, err = aead.Open(nil, nonce, ciphertext, additionalData(contentType, encoding, id))
+		if err != nil {
+			return nil, fmt.Errorf("decrypt with key %s: %w", id, err)
+		}
+	}
+
+	switch Compression(encoding) {
+	case Gzip:
+		r, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, fmt.Errorf("gzip: %w", err)
+		}
+		payload, err = io.ReadAll(io.LimitReader(r, maxOpened+1))
+		if err != nil {
+			return nil, fmt.Errorf("gzip: %w", err)
+		}
+		if len(payload) > maxOpened {
+			return nil, fmt.Errorf("gzip: decompressed payload exceeds %d bytes", maxOpened)
+		}
+	case Zstd:
+		if err := s.initZstd(); err != nil {
+			return nil, err
+		}
+		var err error
+		payload, err = s.zstdDecoder.DecodeAll(payload, nil)
+		if err != nil {
+			return nil, fmt.Errorf("zstd: %w", err)
+		}
+	}
+	return payload, nil
+}
+
+func (s *Sealer) compress(payload []byte) ([]byte, error) {
+	switch s.cfg.Compression {
+	case Gzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(payload); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case Zstd:
+		if err := s.initZstd(); err != nil {
+			return nil, err
+		}
+		return s.zstdEncoder.EncodeAll(payload, nil), nil
+	default:
+		return payload, nil
+	}
+}
+
+// initZstd creates the zstd encoder and decoder, they are safe for concurrent use
+func (s *Sealer) initZstd() error {
+	s.zstdOnce.Do(func() {
+		s.zstdEncoder, s.zstdErr = zstd.NewWriter(nil)
+		if s.zstdErr != nil {
+			return
+		}
+		s.zstdDecoder, s.zstdErr = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0), zstd.WithDecoderMaxMemory(maxOpened))
+	})
+	return s.zstdErr
+}
+
+func newAEAD(id string, key []byte) (cipher.AEAD, error) {
+	if err := checkKey(id, key); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// additionalData binds the headers that tell how to read the payload to its ciphertext
+func additionalData(contentType, encoding, keyID string) []byte {
+	return []byte(contentType + "\x00" + encoding + "\x00" + keyID)
+}
+
+// Message is a received message whose payload was opened. Its content
+// encoding is empty, the key and cipher properties are hidden and its payload
+// is available both as bytes and as a string, the other accessors are those of
+// the received message.
+type Message struct {
+	message.InboundMessage
+	payload []byte
+}
+
+// Unwrap returns the received message, e.g. to settle it on the receiver it
+// came from.
+func (m *Message) Unwrap() message.InboundMessage {
+	return m.InboundMessage
+}
+
+// GetPayloadAsBytes returns the opened payload.
+func (m *Message) GetPayloadAsBytes() ([]byte, bool) {
+	return m.payload, true
+}
+
+// GetPayloadAsString returns the opened payload as a string.
+func (m *Message) GetPayloadAsString() (string, bool) {
+	return string(m.payload), true
+}
+
+// GetHTTPContentEncoding reports no content encoding, the payload is decoded.
+func (m *Message) GetHTTPContentEncoding() (string, bool) {
+	return "", false
+}
+
+// GetProperties returns the user properties without the key and cipher.
+func (m *Message) GetProperties() sdt.Map {
+	properties := m.InboundMessage.GetProperties()
+	delete(properties, PropertyKeyID)
+	delete(properties, PropertyCipher)
+	return properties
+}
+
+// GetProperty returns a user property, the key and cipher are not found.
+func (m *Message) GetProperty(name string) (sdt.Data, bool) {
+	if hidden(name) {
+		return nil, false
+	}
+	return m.InboundMessage.GetProperty(name)
+}
+
+// HasProperty reports whether a user property is set, the key and cipher are not.
+func (m *Message) HasProperty(name string) bool {
+	return !hidden(name) && m.InboundMessage.HasProperty(name)
+}
+
+// hidden reports whether a property only tells how the payload was sealed
+func hidden(name string) bool {
+	return name == PropertyKeyID || name == PropertyCipher
+}
+
+// Unwrap returns the received message behind msg, or msg itself when its
+// payload was not opened.
+func Unwrap(msg message.InboundMessage) message.InboundMessage {
+	if opened, ok := msg.(*Message); ok {
+		return opened.InboundMessage
+	}
+	return msg
+}
+
+// Environment variables read by Enable
+const (
+	EnvCompression = "SOLACE_PAYLOAD_COMPRESSION"
+	EnvThreshold   = "SOLACE_PAYLOAD_COMPRESSION_THRESHOLD"
+	EnvKeyring     = "SOLACE_PAYLOAD_KEYRING"
+	// EnvKeyPrefix is the prefix of the EnvKeys read when no keyring is given.
+	EnvKeyPrefix = "SOLACE_PAYLOAD_KEY"
+)
+
+// The flags are registered on flag.CommandLine so that bootstrap.Load parses
+// them together with the connection flags.
+var (
+	compressionFlag = flag.String("compress", "", "compress payloads with gzip or zstd, overrides "+EnvCompression)
+	thresholdFlag   = flag.Int("compress-threshold", DefaultThreshold, "payload size in bytes to start compressing at, overrides "+EnvThreshold)
+	keyringFlag     = flag.String("keyring", "", "JSON keyring file to encrypt payloads with, overrides "+EnvKeyring)
+)
+
+// Enable creates a Sealer for the publishers and receivers of service from the
+// command line flags and the environment. Payloads are encrypted with the
+// keyring given by -keyring, or with EnvKeys when SOLACE_PAYLOAD_KEY_ID is set,
+// and compressed as selected by -compress.
+func Enable(service solace.MessagingService) (*Sealer, error) {
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	cfg := Config{Threshold: *thresholdFlag, NewBuilder: service.MessageBuilder}
+	compression := os.Getenv(EnvCompression)
+	if *compressionFlag != "" {
+		compression = *compressionFlag
+	}
+	var err error
+	if cfg.Compression, err = ParseCompression(compression); err != nil {
+		return nil, err
+	}
+	if val, ok := os.LookupEnv(EnvThreshold); ok && !set["compress-threshold"] {
+		if cfg.Threshold, err = strconv.Atoi(val); err != nil {
+			return nil, fmt.Errorf("%s: %w", EnvThreshold, err)
+		}
+	}
+
+	keyring := os.Getenv(EnvKeyring)
+	if *keyringFlag != "" {
+		keyring = *keyringFlag
+	}
+	if keyring != "" {
+		if cfg.Keys, err = OpenKeyring(keyring); err != nil {
+			return nil, err
+		}
+	} else if os.Getenv(EnvKeyPrefix+"_ID") != "" {
+		cfg.Keys = EnvKeys{Prefix: EnvKeyPrefix}
+	}
+
+	s, err := New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Compression != NoCompression {
+		fmt.Printf("Compressing payloads of %d bytes and more with %s\n", cfg.Threshold, cfg.Compression)
+	}
+	if cfg.Keys != nil {
+		id, _, _ := cfg.Keys.Current()
+		fmt.Printf("Encrypting payloads with %s key %s\n", CipherAESGCM, id)
+	}
+	return s, nil
+}
@@ -0,0 +1,387 @@
+package seal
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"solace.dev/go/messaging/pkg/solace/config"
+	"solace.dev/go/messaging/pkg/solace/message"
+	"solace.dev/go/messaging/pkg/solace/resource"
+
+	"SolaceSamples.com/PubSub+Go/internal/fake"
+)
+
+var (
+	key1 = bytes.Repeat([]byte{1}, 16)
+	key2 = bytes.Repeat([]byte{2}, 32)
+)
+
+// largePayload compresses well and is above the default threshold
+var largePayload = []byte(strings.Repeat(`{"text": "Hello from Go", "seq": 1}`, 100))
+
+// keyring returns a keyring encrypting with current
+func keyring(t *testing.T, current string, keys map[string][]byte) *Keyring {
+	t.Helper()
+	k, err := NewKeyring(current, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+// newSealer returns a Sealer building its messages with the fake
+func newSealer(t *testing.T, cfg Config) *Sealer {
+	t.Helper()
+	if cfg.NewBuilder == nil {
+		cfg.NewBuilder = fake.New().MessageBuilder
+	}
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// rebuild returns a copy of msg as received, with its payload and headers changed by tamper
+func rebuild(t *testing.T, msg message.OutboundMessage, tamper func(payload []byte, properties config.MessagePropertyMap)) *fake.Message {
+	t.Helper()
+	payload, _ := msg.GetPayloadAsBytes()
+	payload = bytes.Clone(payload)
+	properties := config.MessagePropertyMap{}
+	for name, val := range msg.GetProperties() {
+		properties[config.MessageProperty(name)] = val
+	}
+	contentType, _ := msg.GetHTTPContentType()
+	encoding, _ := msg.GetHTTPContentEncoding()
+	properties[config.MessagePropertyHTTPContentType] = contentType
+	properties[config.MessagePropertyHTTPContentEncoding] = encoding
+	tamper(payload, properties)
+	rebuilt, err := fake.New().MessageBuilder().BuildWithByteArrayPayload(payload, properties)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rebuilt.(*fake.Message)
+}
+
+func TestSealAndOpen(t *testing.T) {
+	keys := keyring(t, "k1", map[string][]byte{"k1": key1})
+	tests := []struct {
+		name         string
+		cfg          Config
+		payload      []byte
+		wantEncoding string
+		wantKey      bool
+	}{
+		{name: "unchanged", payload: largePayload},
+		{name: "gzip", cfg: Config{Compression: Gzip}, payload: largePayload, wantEncoding: "gzip"},
+		{name: "zstd", cfg: Config{Compression: Zstd}, payload: largePayload, wantEncoding: "zstd"},
+		{name: "below the threshold", cfg: Config{Compression: Gzip, Threshold: DefaultThreshold}, payload: []byte("Hello")},
+		{name: "incompressible", cfg: Config{Compression: Zstd}, payload: []byte("Hi")},
+		{name: "AES-GCM", cfg: Config{Keys: keys}, payload: []byte("Hello"), wantKey: true},
+		{name: "gzip and AES-GCM", cfg: Config{Compression: Gzip, Keys: keys}, payload: largePayload, wantEncoding: "gzip", wantKey: true},
+		{name: "zstd and AES-GCM", cfg: Config{Compression: Zstd, Keys: keys}, payload: largePayload, wantEncoding: "zstd", wantKey: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newSealer(t, tt.cfg)
+			builder := fake.New().MessageBuilder().WithProperty("application", "samples")
+			sealed, err := s.Seal(builder, tt.payload, "application/json")
+			if err != nil {
+				t.Fatal(err)
+			}
+			msg := sealed.(*fake.Message)
+			if encoding, _ := msg.GetHTTPContentEncoding(); encoding != tt.wantEncoding {
+				t.Errorf("content encoding %q, want %q", encoding, tt.wantEncoding)
+			}
+			if contentType, _ := msg.GetHTTPContentType(); contentType != "application/json" {
+				t.Errorf("content type %q", contentType)
+			}
+			if msg.HasProperty(PropertyKeyID) != tt.wantKey || msg.HasProperty(PropertyCipher) != tt.wantKey {
+				t.Errorf("key properties %v, want them: %v", msg.GetProperties(), tt.wantKey)
+			}
+			if IsSealed(msg) != (tt.wantKey || tt.wantEncoding != "") {
+				t.Errorf("IsSealed() = %v", IsSealed(msg))
+			}
+			if payload, _ := msg.GetPayloadAsBytes(); tt.wantKey && bytes.Contains(payload, []byte("Hello")) {
+				t.Error("encrypted payload contains the plain text")
+			}
+
+			opened, err := s.Open(msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if payload, _ := opened.GetPayloadAsBytes(); !bytes.Equal(payload, tt.payload) {
+				t.Errorf("opened payload %q, want %q", payload, tt.payload)
+			}
+			if encoding, ok := opened.GetHTTPContentEncoding(); ok || encoding != "" {
+				t.Errorf("opened message has content encoding %q", encoding)
+			}
+			if opened.HasProperty(PropertyKeyID) || opened.HasProperty(PropertyCipher) {
+				t.Error("opened message exposes the key properties")
+			}
+			if _, ok := opened.GetProperty(PropertyKeyID); ok {
+				t.Error("opened message returns the key ID")
+			}
+			properties := opened.GetProperties()
+			if _, ok := properties[PropertyKeyID]; ok || properties["application"] != "samples" {
+				t.Errorf("opened properties %v, want the builder's without the key", properties)
+			}
+			if Unwrap(opened) != msg {
+				t.Error("Unwrap() does not return the received message")
+			}
+
+			// the header and key properties are set on the sealed message only
+			text, err := builder.BuildWithStringPayload("Hello")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if encoding, _ := text.GetHTTPContentEncoding(); encoding != "" || text.HasProperty(PropertyKeyID) {
+				t.Errorf("builder kept encoding %q and properties %v", encoding, text.GetProperties())
+			}
+		})
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	before := newSealer(t, Config{Keys: keyring(t, "k1", map[string][]byte{"k1": key1})})
+	after := newSealer(t, Config{Keys: keyring(t, "k2", map[string][]byte{"k1": key1, "k2": key2})})
+
+	old, err := before.Seal(fake.New().MessageBuilder(), []byte("Hello k1"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := after.Seal(fake.New().MessageBuilder(), []byte("Hello k2"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, _ := current.GetProperty(PropertyKeyID); id != "k2" {
+		t.Errorf("sealed with key %v after the rotation, want k2", id)
+	}
+
+	// payloads sealed before the rotation still open
+	for msg, want := range map[message.OutboundMessage]string{old: "Hello k1", current: "Hello k2"} {
+		opened, err := after.Open(msg.(*fake.Message))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if payload, _ := opened.GetPayloadAsString(); payload != want {
+			t.Errorf("opened %q, want %q", payload, want)
+		}
+	}
+	// receivers without the new key refuse the new payloads
+	_, err = before.Open(current.(*fake.Message))
+	if !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("error %v, want ErrUnknownKey", err)
+	}
+	if stats := before.Stats(); stats.Failed != 1 || stats.Encrypted != 1 {
+		t.Errorf("stats %s", stats)
+	}
+}
+
+func TestOpenRejectsTampering(t *testing.T) {
+	keys := keyring(t, "k1", map[string][]byte{"k1": key1, "k2": key2})
+	s := newSealer(t, Config{Compression: Gzip, Keys: keys})
+	sealed, err := s.Seal(fake.New().MessageBuilder(), largePayload, "application/json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		sealer *Sealer
+		tamper func(payload []byte, properties config.MessagePropertyMap)
+	}{
+		{"flipped ciphertext bit", s, func(payload []byte, _ config.MessagePropertyMap) { payload[len(payload)-1] ^= 1 }},
+		{"flipped nonce bit", s, func(payload []byte, _ config.MessagePropertyMap) { payload[0] ^= 1 }},
+		{"content type changed", s, func(_ []byte, properties config.MessagePropertyMap) {
+			properties[config.MessagePropertyHTTPContentType] = "text/plain"
+		}},
+		{"content encoding changed", s, func(_ []byte, properties config.MessagePropertyMap) {
+			properties[config.MessagePropertyHTTPContentEncoding] = "zstd"
+		}},
+		{"key ID changed", s, func(_ []byte, properties config.MessagePropertyMap) { properties[PropertyKeyID] = "k2" }},
+		{"unknown key ID", s, func(_ []byte, properties config.MessagePropertyMap) { properties[PropertyKeyID] = "k3" }},
+		{"unsupported cipher", s, func(_ []byte, properties config.MessagePropertyMap) { properties[PropertyCipher] = "ChaCha20" }},
+		{"no keys configured", newSealer(t, Config{}), func([]byte, config.MessagePropertyMap) {}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := rebuild(t, sealed, tt.tamper)
+			_, err := tt.sealer.Open(msg)
+			var sealErr *Error
+			if !errors.As(err, &sealErr) || sealErr.Topic != msg.GetDestinationName() {
+				t.Errorf("error %v, want an *Error", err)
+			}
+		})
+	}
+
+	// an untampered copy opens
+	if _, err := s.Open(rebuild(t, sealed, func([]byte, config.MessagePropertyMap) {})); err != nil {
+		t.Fatalf("untampered copy: %v", err)
+	}
+	// a payload shorter than the nonce
+	truncated, err := fake.New().MessageBuilder().BuildWithByteArrayPayload([]byte("short"), config.MessagePropertyMap{
+		PropertyKeyID:  "k1",
+		PropertyCipher: CipherAESGCM,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Open(truncated.(*fake.Message)); err == nil {
+		t.Error("opened a payload shorter than its nonce")
+	}
+}
+
+func TestReseal(t *testing.T) {
+	keys := keyring(t, "k1", map[string][]byte{"k1": key1})
+	s := newSealer(t, Config{Keys: keys, Persistent: config.MessagePropertyMap{
+		config.MessagePropertyPersistentTimeToLive:  60000,
+		config.MessagePropertyPersistentDMQEligible: true,
+	}})
+	expiration := time.Unix(time.Now().Add(time.Hour).Unix(), 0)
+	msg, err := fake.New().MessageBuilder().
+		WithProperty("application", "samples").
+		WithHTTPContentHeader("application/json", "").
+		WithApplicationMessageID("run-1").
+		WithApplicationMessageType("greeting").
+		WithCorrelationID("corr-1").
+		WithPriority(7).
+		WithExpiration(expiration).
+		BuildWithStringPayload(`{"text": "Hello"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resealed, err := s.reseal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := resealed.(*fake.Message)
+	if ttl, ok := got.TimeToLive(); !ok || ttl != 60000 {
+		t.Errorf("time to live %d, %v, want Config.Persistent's", ttl, ok)
+	}
+	if eligible, ok := got.DMQEligible(); !ok || !eligible {
+		t.Errorf("DMQ eligible %v, %v, want Config.Persistent's", eligible, ok)
+	}
+	if id, _ := got.GetApplicationMessageID(); id != "run-1" {
+		t.Errorf("application message ID %q", id)
+	}
+	if messageType, _ := got.GetApplicationMessageType(); messageType != "greeting" {
+		t.Errorf("application message type %q", messageType)
+	}
+	if id, _ := got.GetCorrelationID(); id != "corr-1" {
+		t.Errorf("correlation ID %q", id)
+	}
+	if priority, _ := got.GetPriority(); priority != 7 {
+		t.Errorf("priority %d", priority)
+	}
+	if !got.GetExpiration().Equal(expiration) {
+		t.Errorf("expiration %s, want %s", got.GetExpiration(), expiration)
+	}
+	if application, _ := got.GetProperty("application"); application != "samples" {
+		t.Errorf("property application = %v", application)
+	}
+	opened, err := s.Open(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payload, _ := opened.GetPayloadAsString(); payload != `{"text": "Hello"}` {
+		t.Errorf("opened %q", payload)
+	}
+	if contentType, _ := opened.GetHTTPContentType(); contentType != "application/json" {
+		t.Errorf("content type %q", contentType)
+	}
+
+	// encrypted messages are not sealed twice and unprotected ones are not rebuilt
+	again, err := s.reseal(got)
+	if err != nil || again != resealed {
+		t.Errorf("resealed an encrypted message: %v", err)
+	}
+	unchanged := newSealer(t, Config{Compression: Gzip, Threshold: DefaultThreshold})
+	if same, err := unchanged.reseal(msg); err != nil || same != msg {
+		t.Errorf("rebuilt a message below the threshold: %v", err)
+	}
+}
+
+func TestPersistentReceiverRejects(t *testing.T) {
+	const topic = "solace/samples/persistent/sealed"
+	broker := fake.NewBroker()
+	queue := broker.CreateQueue("sealed", topic)
+	service := broker.NewService()
+	if err := service.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer service.Disconnect()
+
+	sender := newSealer(t, Config{Keys: keyring(t, "k1", map[string][]byte{"k1": key1}), NewBuilder: service.MessageBuilder})
+	publisher, err := service.CreatePersistentMessagePublisherBuilder().Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := publisher.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer publisher.Terminate(0)
+	sealedPublisher := sender.PersistentPublisher(publisher)
+	for _, body := range []string{"Hello", "World"} {
+		if err := sealedPublisher.PublishAwaitAcknowledgement(mustBuild(t, service, body), resource.TopicOf(topic), time.Second, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a receiver with the key opens the first message, one without it rejects the second
+	receiver, err := service.CreatePersistentMessageReceiverBuilder().
+		WithMessageClientAcknowledgement().
+		WithRequiredMessageOutcomeSupport(config.PersistentReceiverRejectedOutcome).
+		Build(resource.QueueDurableExclusive(queue.Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := receiver.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer receiver.Terminate(0)
+	opened := sender.PersistentReceiver(receiver)
+	msg, err := opened.ReceiveMessage(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payload, _ := msg.GetPayloadAsString(); payload != "Hello" {
+		t.Errorf("opened %q", payload)
+	}
+	if err := opened.Ack(msg); err != nil {
+		t.Fatalf("Ack() of an opened message: %v", err)
+	}
+
+	var rejected []error
+	keyless := newSealer(t, Config{})
+	keyless.OnError(func(msg message.InboundMessage, err error) { rejected = append(rejected, err) })
+	if err := keyless.PersistentReceiver(receiver).ReceiveAsync(func(message.InboundMessage) {
+		t.Error("handler called for a payload that cannot be opened")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for len(queue.Discarded()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("message not moved to the DMQ")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if len(rejected) != 1 {
+		t.Errorf("%d errors reported, want 1", len(rejected))
+	}
+}
+
+// mustBuild builds a text message with the builder of service
+func mustBuild(t *testing.T, service *fake.Service, body string) message.OutboundMessage {
+	t.Helper()
+	msg, err := service.MessageBuilder().BuildWithStringPayload(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
@@ -0,0 +1,271 @@
+package seal
+
+import (
+	"errors"
+	"time"
+
+	"solace.dev/go/messaging/pkg/solace"
+	"solace.dev/go/messaging/pkg/solace/config"
+	"solace.dev/go/messaging/pkg/solace/message"
+	"solace.dev/go/messaging/pkg/solace/resource"
+)
+
+// reseal returns msg rebuilt with its payload protected, or msg itself when
+// the Config leaves its payload unchanged or it is encrypted already. The
+// payload, user properties, content header, application message ID and type,
+// correlation ID, priority, class of service, sequence number and expiration
+// are copied, the time to live and DMQ eligibility are those of
+// Config.Persistent. A trace context is not copied, so wrap a tracing
+// publisher with the sealing one and not the other way around.
+func (s *Sealer) reseal(msg message.OutboundMessage) (message.OutboundMessage, error) {
+	payload, ok := msg.GetPayloadAsBytes()
+	if !ok {
+		var str string
+		if str, ok = msg.GetPayloadAsString(); ok {
+			payload = []byte(str)
+		}
+	}
+	// messages encrypted already, e.g. republished ones, are not sealed twice
+	if !ok || !s.protects(len(payload)) || msg.HasProperty(PropertyKeyID) {
+		return msg, nil
+	}
+	if s.cfg.NewBuilder == nil {
+		return nil, errors.New("seal: Config.NewBuilder is needed to publish sealed messages")
+	}
+
+	contentType, _ := msg.GetHTTPContentType()
+	encoding, _ := msg.GetHTTPContentEncoding()
+	sealed, encoding, properties, err := s.seal(payload, contentType, encoding)
+	if err != nil {
+		return nil, err
+	}
+
+	builder := s.cfg.NewBuilder()
+	for name, val := range msg.GetProperties() {
+		builder = builder.WithProperty(config.MessageProperty(name), val)
+	}
+	if id, ok := msg.GetApplicationMessageID(); ok {
+		builder = builder.WithApplicationMessageID(id)
+	}
+	if messageType, ok := msg.GetApplicationMessageType(); ok {
+		builder = builder.WithApplicationMessageType(messageType)
+	}
+	if id, ok := msg.GetCorrelationID(); ok {
+		builder = builder.WithCorrelationID(id)
+	}
+	if priority, ok := msg.GetPriority(); ok {
+		builder = builder.WithPriority(priority)
+	}
+	if seq, ok := msg.GetSequenceNumber(); ok && seq >= 0 {
+		builder = builder.WithSequenceNumber(uint64(seq))
+	}
+	if expiration := msg.GetExpiration(); !expiration.IsZero() && expiration.Unix() > 0 {
+		builder = builder.WithExpiration(expiration)
+	}
+	builder = builder.WithProperty(config.MessagePropertyClassOfService, msg.GetClassOfService())
+	return builder.BuildWithByteArrayPayload(sealed, s.cfg.Persistent, withContentHeader(properties, contentType, encoding))
+}
+
+// sealBytes builds a message with payload unless the Config leaves it unchanged
+func (s *Sealer) sealBytes(payload []byte) (message.OutboundMessage, error) {
+	if !s.protects(len(payload)) {
+		return nil, nil
+	}
+	if s.cfg.NewBuilder == nil {
+		return nil, errors.New("seal: Config.NewBuilder is needed to publish sealed messages")
+	}
+	return s.Seal(s.cfg.NewBuilder(), payload, "")
+}
+
+// reject reports a message that could not be opened
+func (s *Sealer) reject(msg message.InboundMessage, err error) {
+	s.mu.Lock()
+	onError := s.onError
+	s.mu.Unlock()
+	if onError != nil {
+		onError(msg, err)
+	}
+}
+
+// DirectPublisher protects the payloads of the messages published through publisher.
+func (s *Sealer) DirectPublisher(publisher solace.DirectMessagePublisher) solace.DirectMessagePublisher {
+	return &directPublisher{DirectMessagePublisher: publisher, sealer: s}
+}
+
+type directPublisher struct {
+	solace.DirectMessagePublisher
+	sealer *Sealer
+}
+
+func (p *directPublisher) PublishBytes(msg []byte, destination *resource.Topic) error {
+	sealed, err := p.sealer.sealBytes(msg)
+	if err != nil {
+		return err
+	}
+	if sealed == nil {
+		return p.DirectMessagePublisher.PublishBytes(msg, destination)
+	}
+	return p.DirectMessagePublisher.Publish(sealed, destination)
+}
+
+func (p *directPublisher) PublishString(msg string, destination *resource.Topic) error {
+	sealed, err := p.sealer.sealBytes([]byte(msg))
+	if err != nil {
+		return err
+	}
+	if sealed == nil {
+		return p.DirectMessagePublisher.PublishString(msg, destination)
+	}
+	return p.DirectMessagePublisher.Publish(sealed, destination)
+}
+
+func (p *directPublisher) Publish(msg message.OutboundMessage, destination *resource.Topic) error {
+	sealed, err := p.sealer.reseal(msg)
+	if err != nil {
+		return err
+	}
+	return p.DirectMessagePublisher.Publish(sealed, destination)
+}
+
+func (p *directPublisher) PublishWithProperties(msg message.OutboundMessage, destination *resource.Topic, properties config.MessagePropertiesConfigurationProvider) error {
+	sealed, err := p.sealer.reseal(msg)
+	if err != nil {
+		return err
+	}
+	return p.DirectMessagePublisher.PublishWithProperties(sealed, destination, properties)
+}
+
+// PersistentPublisher protects the payloads of the messages published through
+// publisher. Publish receipts carry the sealed message.
+func (s *Sealer) PersistentPublisher(publisher solace.PersistentMessagePublisher) solace.PersistentMessagePublisher {
+	return &persistentPublisher{PersistentMessagePublisher: publisher, sealer: s}
+}
+
+type persistentPublisher struct {
+	solace.PersistentMessagePublisher
+	sealer *Sealer
+}
+
+func (p *persistentPublisher) PublishBytes(msg []byte, destination *resource.Topic) error {
+	sealed, err := p.sealer.sealBytes(msg)
+	if err != nil {
+		return err
+	}
+	if sealed == nil {
+		return p.PersistentMessagePublisher.PublishBytes(msg, destination)
+	}
+	return p.PersistentMessagePublisher.Publish(sealed, destination, nil, nil)
+}
+
+func (p *persistentPublisher) PublishString(msg string, destination *resource.Topic) error {
+	sealed, err := p.sealer.sealBytes([]byte(msg))
+	if err != nil {
+		return err
+	}
+	if sealed == nil {
+		return p.PersistentMessagePublisher.PublishString(msg, destination)
+	}
+	return p.PersistentMessagePublisher.Publish(sealed, destination, nil, nil)
+}
+
+func (p *persistentPublisher) Publish(msg message.OutboundMessage, destination *resource.Topic, properties config.MessagePropertiesConfigurationProvider, context interface{}) error {
+	sealed, err := p.sealer.reseal(msg)
+	if err != nil {
+		return err
+	}
+	return p.PersistentMessagePublisher.Publish(sealed, destination, properties, context)
+}
+
+func (p *persistentPublisher) PublishAwaitAcknowledgement(msg message.OutboundMessage, destination *resource.Topic, timeout time.Duration, properties config.MessagePropertiesConfigurationProvider) error {
+	sealed, err := p.sealer.reseal(msg)
+	if err != nil {
+		return err
+	}
+	return p.PersistentMessagePublisher.PublishAwaitAcknowledgement(sealed, destination, timeout, properties)
+}
+
+// DirectReceiver opens the payloads of the messages received through receiver.
+// Messages that cannot be opened are passed to the OnError function and dropped.
+func (s *Sealer) DirectReceiver(receiver solace.DirectMessageReceiver) solace.DirectMessageReceiver {
+	return &directReceiver{DirectMessageReceiver: receiver, sealer: s}
+}
+
+type directReceiver struct {
+	solace.DirectMessageReceiver
+	sealer *Sealer
+}
+
+func (r *directReceiver) ReceiveAsync(callback solace.MessageHandler) error {
+	return r.DirectMessageReceiver.ReceiveAsync(func(msg message.InboundMessage) {
+		opened, err := r.sealer.Open(msg)
+		if err != nil {
+			r.sealer.reject(msg, err)
+			return
+		}
+		callback(opened)
+	})
+}
+
+// ReceiveMessage returns the received message itself along with the error when
+// its payload cannot be opened.
+func (r *directReceiver) ReceiveMessage(timeout time.Duration) (message.InboundMessage, error) {
+	msg, err := r.DirectMessageReceiver.ReceiveMessage(timeout)
+	if err != nil || msg == nil {
+		return msg, err
+	}
+	opened, err := r.sealer.Open(msg)
+	if err != nil {
+		return msg, err
+	}
+	return opened, nil
+}
+
+// PersistentReceiver opens the payloads of the messages received through
+// receiver. Messages that cannot be opened are passed to the OnError function
+// and settled as rejected, so the broker moves them to the dead message queue
+// if the receiver was built with that outcome enabled, and left
+// unacknowledged otherwise. Wrap the receiver built by the service with it
+// before any other wrapper, as the messages it hands out have to be
+// acknowledged and settled through it.
+func (s *Sealer) PersistentReceiver(receiver solace.PersistentMessageReceiver) solace.PersistentMessageReceiver {
+	return &persistentReceiver{PersistentMessageReceiver: receiver, sealer: s}
+}
+
+type persistentReceiver struct {
+	solace.PersistentMessageReceiver
+	sealer *Sealer
+}
+
+func (r *persistentReceiver) ReceiveAsync(callback solace.MessageHandler) error {
+	return r.PersistentMessageReceiver.ReceiveAsync(func(msg message.InboundMessage) {
+		opened, err := r.sealer.Open(msg)
+		if err != nil {
+			r.sealer.reject(msg, err)
+			r.PersistentMessageReceiver.Settle(msg, config.PersistentReceiverRejectedOutcome)
+			return
+		}
+		callback(opened)
+	})
+}
+
+// ReceiveMessage returns the received message itself along with the error when
+// its payload cannot be opened, the caller settles it.
+func (r *persistentReceiver) ReceiveMessage(timeout time.Duration) (message.InboundMessage, error) {
+	msg, err := r.PersistentMessageReceiver.ReceiveMessage(timeout)
+	if err != nil || msg == nil {
+		return msg, err
+	}
+	opened, err := r.sealer.Open(msg)
+	if err != nil {
+		return msg, err
+	}
+	return opened, nil
+}
+
+func (r *persistentReceiver) Ack(msg message.InboundMessage) error {
+	return r.PersistentMessageReceiver.Ack(Unwrap(msg))
+}
+
+func (r *persistentReceiver) Settle(msg message.InboundMessage, outcome config.MessageSettlementOutcome) error {
+	return r.PersistentMessageReceiver.Settle(Unwrap(msg), outcome)
+}
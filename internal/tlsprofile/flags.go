@@ -0,0 +1,78 @@
+package tlsprofile
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Environment variables read by Load
+const (
+	EnvTrustStore     = "SOLACE_TLS_TRUST_STORE"
+	EnvMinVersion     = "SOLACE_TLS_MIN_VERSION"
+	EnvCipherSuites   = "SOLACE_TLS_CIPHER_SUITES"
+	EnvSkipValidation = "SOLACE_TLS_SKIP_VALIDATION"
+	EnvAllowInsecure  = "SOLACE_TLS_ALLOW_INSECURE"
+)
+
+// The flags are registered on flag.CommandLine so that bootstrap.Load parses
+// them together with the connection flags.
+var (
+	trustStoreFlag     = flag.String("trust-store", "", "directory or PEM bundle with the CA certificates that sign the broker certificate, overrides "+EnvTrustStore)
+	minVersionFlag     = flag.String("tls-min-version", "", "oldest TLS version to offer, TLSv1.2 when empty, overrides "+EnvMinVersion)
+	cipherSuitesFlag   = flag.String("tls-cipher-suites", "", "comma separated cipher suites to offer in order of preference, overrides "+EnvCipherSuites)
+	skipValidationFlag = flag.Bool("tls-skip-validation", false, "do not validate the broker certificate, INSECURE, needs -tls-allow-insecure, overrides "+EnvSkipValidation)
+	allowInsecureFlag  = flag.Bool("tls-allow-insecure", false, "allow TLS settings that weaken security, overrides "+EnvAllowInsecure)
+)
+
+// Load returns the profile given by the command line flags and the
+// environment, call it after bootstrap.Load parsed the flags.
+func Load() (Profile, error) {
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	str := func(name, env string, val *string) string {
+		if set[name] {
+			return *val
+		}
+		return os.Getenv(env)
+	}
+	boolean := func(name, env string, val *bool) (bool, error) {
+		if set[name] {
+			return *val, nil
+		}
+		s, ok := os.LookupEnv(env)
+		if !ok {
+			return false, nil
+		}
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return false, fmt.Errorf("%s: %w", env, err)
+		}
+		return b, nil
+	}
+
+	p := Profile{TrustStore: str("trust-store", EnvTrustStore, trustStoreFlag)}
+	if version := str("tls-min-version", EnvMinVersion, minVersionFlag); version != "" {
+		var err error
+		if p.MinVersion, err = ParseVersion(version); err != nil {
+			return Profile{}, err
+		}
+	}
+	for _, suite := range strings.Split(str("tls-cipher-suites", EnvCipherSuites, cipherSuitesFlag), ",") {
+		if suite = strings.TrimSpace(suite); suite != "" {
+			p.CipherSuites = append(p.CipherSuites, suite)
+		}
+	}
+	var err error
+	if p.SkipCertificateValidation, err = boolean("tls-skip-validation", EnvSkipValidation, skipValidationFlag); err != nil {
+		return Profile{}, err
+	}
+	if p.AllowInsecure, err = boolean("tls-allow-insecure", EnvAllowInsecure, allowInsecureFlag); err != nil {
+		return Profile{}, err
+	}
+	return p, nil
+}
@@ -0,0 +1,252 @@
+// Package tlsprofile builds the transport security strategy of a messaging
+// service from a Profile. The broker certificate and host name are validated
+// by default, against a trust store given as a directory or a PEM bundle, and
+// TLS 1.2 is the minimum protocol. Settings that weaken this are refused
+// unless the profile explicitly allows them, and are then logged as warnings
+// every time a strategy is built.
+//
+// Load reads a profile from the command line flags and the environment:
+//
+//	-trust-store                 SOLACE_TLS_TRUST_STORE
+//	-tls-min-version             SOLACE_TLS_MIN_VERSION
+//	-tls-cipher-suites           SOLACE_TLS_CIPHER_SUITES
+//	-tls-skip-validation         SOLACE_TLS_SKIP_VALIDATION
+//	-tls-allow-insecure          SOLACE_TLS_ALLOW_INSECURE
+package tlsprofile
+
+import (
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"solace.dev/go/messaging/pkg/solace/config"
+
+	"SolaceSamples.com/PubSub+Go/internal/certs"
+)
+
+// DefaultMinVersion is the minimum protocol of a Profile that sets none.
+const DefaultMinVersion = config.TransportSecurityProtocolTLSv1_2
+
+// ErrInsecure is returned by Strategy for a profile that weakens TLS without
+// AllowInsecure.
+var ErrInsecure = errors.New("insecure TLS profile")
+
+// versions are the protocols the library knows, oldest first
+var versions = []config.TransportSecurityProtocol{
+	config.TransportSecurityProtocolSSLv3,
+	config.TransportSecurityProtocolTLSv1,
+	config.TransportSecurityProtocolTLSv1_1,
+	config.TransportSecurityProtocolTLSv1_2,
+	config.TransportSecurityProtocolTLSv1_3,
+}
+
+// ParseVersion accepts a protocol as "TLSv1.2", "tls1.2" or "1.2".
+func ParseVersion(s string) (config.TransportSecurityProtocol, error) {
+	normalized := strings.ToLower(strings.TrimSpace(s))
+	for _, version := range versions {
+		name := strings.ToLower(string(version))
+		if normalized == name || normalized == strings.Replace(name, "v", "", 1) || normalized == strings.TrimPrefix(name, "tlsv") {
+			return version, nil
+		}
+	}
+	return "", fmt.Errorf("unknown TLS version %q", s)
+}
+
+// weakCiphers are fragments of the names of cipher suites without forward
+// secrecy or with broken algorithms
+var weakCiphers = []string{"NULL", "EXPORT", "RC4", "MD5", "DES", "ANON", "ADH", "AECDH"}
+
+// Profile describes the TLS settings of a messaging service.
+type Profile struct {
+	// MinVersion is the oldest protocol offered, DefaultMinVersion when empty.
+	MinVersion config.TransportSecurityProtocol
+	// CipherSuites restricts the offered cipher suites, in order of
+	// preference, the library defaults when empty.
+	CipherSuites []string
+	// TrustStore is a directory of PEM files or a PEM bundle with the CA
+	// certificates that sign the broker certificate.
+	TrustStore string
+
+	// The following weaken TLS and require AllowInsecure.
+	SkipCertificateValidation bool
+	SkipHostnameValidation    bool
+	IgnoreExpiration          bool
+
+	// AllowInsecure overrides the refusal to build a strategy from a profile
+	// that weakens TLS, e.g. for a local broker with a self-signed certificate.
+	AllowInsecure bool
+	// Logger receives the warnings about insecure settings, log.Default() when nil.
+	Logger *log.Logger
+
+	// bundleDir is the directory Strategy wrote the PEM bundle at path
+	// bundle to, removed by Close
+	bundle, bundleDir string
+}
+
+// Insecure lists the settings of the profile that weaken TLS.
+func (p Profile) Insecure() []string {
+	var reasons []string
+	if p.SkipCertificateValidation {
+		reasons = append(reasons, "the broker certificate is not validated")
+	} else {
+		if p.SkipHostnameValidation {
+			reasons = append(reasons, "the broker host name is not validated")
+		}
+		if p.IgnoreExpiration {
+			reasons = append(reasons, "expired broker certificates are accepted")
+		}
+	}
+	if version := p.minVersion(); version != config.TransportSecurityProtocolTLSv1_2 && version != config.TransportSecurityProtocolTLSv1_3 {
+		reasons = append(reasons, fmt.Sprintf("%s is allowed", version))
+	}
+	for _, suite := range p.CipherSuites {
+		upper := strings.ToUpper(suite)
+		for _, weak := range weakCiphers {
+			if strings.Contains(upper, weak) {
+				reasons = append(reasons, fmt.Sprintf("weak cipher suite %s is allowed", suite))
+				break
+			}
+		}
+	}
+	return reasons
+}
+
+// minVersion returns the configured or default minimum protocol
+func (p Profile) minVersion() config.TransportSecurityProtocol {
+	if p.MinVersion == "" {
+		return DefaultMinVersion
+	}
+	return p.MinVersion
+}
+
+// Strategy builds the transport security strategy of the profile. It fails
+// with ErrInsecure when the profile weakens TLS without AllowInsecure, and
+// when the trust store holds no usable certificate. A PEM bundle is written to
+// a directory once per profile, call Close to remove it after the service
+// disconnected.
+func (p *Profile) Strategy() (config.TransportSecurityStrategy, error) {
+	if _, err := ParseVersion(string(p.minVersion())); err != nil {
+		return config.TransportSecurityStrategy{}, err
+	}
+	if reasons := p.Insecure(); len(reasons) > 0 {
+		if !p.AllowInsecure {
+			return config.TransportSecurityStrategy{}, fmt.Errorf("%w: %s; allow it explicitly to connect anyway",
+				ErrInsecure, strings.Join(reasons, ", "))
+		}
+		logger := p.Logger
+		if logger == nil {
+			logger = log.Default()
+		}
+		logger.Println("**************************************************************")
+		for _, reason := range reasons {
+			logger.Printf("WARNING: INSECURE TLS: %s", reason)
+		}
+		logger.Println("WARNING: INSECURE TLS: allowed by override, do not use in production")
+		logger.Println("**************************************************************")
+	}
+
+	strategy := config.NewTransportSecurityStrategy().WithMinimumProtocol(p.minVersion())
+	if len(p.CipherSuites) > 0 {
+		strategy = strategy.WithCipherSuites(strings.Join(p.CipherSuites, ","))
+	}
+	if p.SkipCertificateValidation {
+		return strategy.WithoutCertificateValidation(), nil
+	}
+	if p.TrustStore == "" {
+		return config.TransportSecurityStrategy{}, errors.New("validating the broker certificate needs a trust store")
+	}
+	dir, err := p.trustStoreDir()
+	if err != nil {
+		return config.TransportSecurityStrategy{}, err
+	}
+	if _, err := certs.Inspect(certs.Paths{TrustStore: dir}, "", time.Now()); err != nil {
+		return config.TransportSecurityStrategy{}, err
+	}
+	return strategy.WithCertificateValidation(p.IgnoreExpiration, !p.SkipHostnameValidation, dir, ""), nil
+}
+
+// trustStoreDir returns the trust store directory, writing a PEM bundle only
+// on the first call or when TrustStore changed
+func (p *Profile) trustStoreDir() (string, error) {
+	if p.bundleDir != "" && p.bundle == p.TrustStore {
+		return p.bundleDir, nil
+	}
+	dir, err := TrustStoreDir(p.TrustStore)
+	if err != nil {
+		return "", err
+	}
+	if dir == p.TrustStore {
+		return dir, nil
+	}
+	if err := p.Close(); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	p.bundle, p.bundleDir = p.TrustStore, dir
+	return dir, nil
+}
+
+// Close removes the directory a PEM bundle was written to by Strategy. The
+// library reads the trust store again on every reconnect, so close the
+// profile after the service disconnected.
+func (p *Profile) Close() error {
+	if p.bundleDir == "" {
+		return nil
+	}
+	err := os.RemoveAll(p.bundleDir)
+	p.bundle, p.bundleDir = "", ""
+	return err
+}
+
+// TrustStoreDir returns path when it is a directory. The certificates of a
+// PEM bundle are written to a new private directory under os.TempDir, since
+// the library reads the trust store from a directory. The directory is
+// created by os.MkdirTemp with a random name and mode 0700, so no other user
+// can place certificates in it. Every call writes a new directory, which the
+// caller removes once the service disconnected, Profile.Strategy writes one
+// per profile and removes it on Close.
+func TrustStoreDir(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		return path, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	dir, err := os.MkdirTemp("", "solace-trust-store-")
+	if err != nil {
+		return "", err
+	}
+	written := 0
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		name := filepath.Join(dir, "ca-"+strconv.Itoa(written)+".pem")
+		if err := os.WriteFile(name, pem.EncodeToMemory(block), 0o600); err != nil {
+			os.RemoveAll(dir)
+			return "", err
+		}
+		written++
+	}
+	if written == 0 {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("trust store %s: %w", path, certs.ErrNoCertificate)
+	}
+	return dir, nil
+}
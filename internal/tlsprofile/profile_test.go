@@ -0,0 +1,191 @@
+package tlsprofile
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeBundle writes a PEM bundle of n self-signed CA certificates
+func writeBundle(t *testing.T, n int) string {
+	t.Helper()
+	var bundle []byte
+	for i := 0; i < n; i++ {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		template := &x509.Certificate{
+			SerialNumber:          big.NewInt(int64(i + 1)),
+			Subject:               pkix.Name{CommonName: "test CA"},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(time.Hour),
+			IsCA:                  true,
+			BasicConstraintsValid: true,
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bundle = append(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	path := filepath.Join(t.TempDir(), "bundle.pem")
+	if err := os.WriteFile(path, bundle, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestTrustStoreDirBundle(t *testing.T) {
+	bundle := writeBundle(t, 2)
+	dir, err := TrustStoreDir(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0o700 {
+		t.Errorf("mode = %o, want 700", mode)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("%d files, want 2", len(entries))
+	}
+
+	// a second call never reuses a directory another user could have prepared
+	again, err := TrustStoreDir(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(again) })
+	if again == dir {
+		t.Errorf("both calls returned %s", dir)
+	}
+}
+
+func TestTrustStoreDirDirectory(t *testing.T) {
+	dir := t.TempDir()
+	got, err := TrustStoreDir(dir)
+	if err != nil || got != dir {
+		t.Errorf("TrustStoreDir(%s) = %s, %v", dir, got, err)
+	}
+}
+
+func TestTrustStoreDirWithoutCertificates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "empty.pem")
+	if err := os.WriteFile(path, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := TrustStoreDir(path); err == nil {
+		t.Error("no error for a bundle without certificates")
+	}
+}
+
+func TestStrategy(t *testing.T) {
+	bundle := writeBundle(t, 1)
+	quiet := log.New(io.Discard, "", 0)
+	tests := []struct {
+		name    string
+		profile Profile
+		wantErr error
+	}{
+		{"secure default", Profile{TrustStore: bundle}, nil},
+		{"no trust store", Profile{}, errors.New("")},
+		{"skip validation", Profile{SkipCertificateValidation: true}, ErrInsecure},
+		{"skip validation allowed", Profile{SkipCertificateValidation: true, AllowInsecure: true, Logger: quiet}, nil},
+		{"old TLS", Profile{TrustStore: bundle, MinVersion: "TLSv1"}, ErrInsecure},
+		{"weak cipher", Profile{TrustStore: bundle, CipherSuites: []string{"RC4-SHA"}}, ErrInsecure},
+		{"unknown version", Profile{TrustStore: bundle, MinVersion: "TLSv9"}, errors.New("")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profile := tt.profile
+			_, err := profile.Strategy()
+			defer profile.Close()
+			switch {
+			case tt.wantErr == nil && err != nil:
+				t.Errorf("unexpected error %v", err)
+			case tt.wantErr != nil && err == nil:
+				t.Error("no error")
+			case tt.wantErr == ErrInsecure && !errors.Is(err, ErrInsecure):
+				t.Errorf("error %v is not ErrInsecure", err)
+			}
+		})
+	}
+}
+
+func TestStrategyWritesTheBundleOnce(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	profile := Profile{TrustStore: writeBundle(t, 1)}
+	for i := 0; i < 3; i++ {
+		if _, err := profile.Strategy(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := os.ReadDir(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("%d trust store directories after 3 strategies, want 1", len(entries))
+	}
+
+	// a new bundle replaces the directory of the previous one
+	profile.TrustStore = writeBundle(t, 2)
+	if _, err := profile.Strategy(); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ = os.ReadDir(tmp); len(entries) != 1 {
+		t.Errorf("%d trust store directories after the bundle changed, want 1", len(entries))
+	}
+
+	if err := profile.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ = os.ReadDir(tmp); len(entries) != 0 {
+		t.Errorf("%d trust store directories left after Close", len(entries))
+	}
+	if err := profile.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+
+	// a directory trust store is never removed
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "ca.pem"), mustRead(t, profile.TrustStore), 0o600)
+	profile = Profile{TrustStore: dir}
+	if _, err := profile.Strategy(); err != nil {
+		t.Fatal(err)
+	}
+	profile.Close()
+	if _, err := os.Stat(dir); err != nil {
+		t.Errorf("trust store directory removed: %v", err)
+	}
+}
+
+func mustRead(t *testing.T, path string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
@@ -0,0 +1,290 @@
+// Package topics defines topic hierarchies such as
+//
+//	acme/{app}/{region}/{entity}/{id}
+//
+// where every level is either literal text or a named field. A Schema builds
+// topics from field values, checking each level against the broker's rules,
+// parses received destination names back into their fields, and generates the
+// wildcard subscriptions that match a subset of its topics.
+package topics
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"solace.dev/go/messaging/pkg/solace/resource"
+)
+
+// Broker limits on topics
+const (
+	MaxLength = 250 // bytes of the whole topic
+	MaxLevels = 128
+)
+
+// Fields maps field names to level values.
+type Fields map[string]string
+
+// Schema is a parsed topic template.
+type Schema struct {
+	template string
+	levels   []level
+	fields   []string
+}
+
+// level is the literal text of a level or the name of the field filling it
+type level struct {
+	text  string
+	field bool
+}
+
+// New parses template. Fields are written as {name} and must fill a whole
+// level, names must be unique and literal levels must be valid topic levels.
+func New(template string) (*Schema, error) {
+	parts := strings.Split(template, "/")
+	if len(parts) > MaxLevels {
+		return nil, fmt.Errorf("topic template %q: %d levels, at most %d allowed", template, len(parts), MaxLevels)
+	}
+	s := &Schema{template: template}
+	seen := map[string]bool{}
+	for i, part := range parts {
+		if strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") {
+			name := part[1 : len(part)-1]
+			if name == "" || strings.ContainsAny(name, "{}") {
+				return nil, fmt.Errorf("topic template %q: invalid field %s", template, part)
+			}
+			if seen[name] {
+				return nil, fmt.Errorf("topic template %q: field %s used twice", template, name)
+			}
+			seen[name] = true
+			s.levels = append(s.levels, level{text: name, field: true})
+			s.fields = append(s.fields, name)
+			continue
+		}
+		if strings.ContainsAny(part, "{}") {
+			return nil, fmt.Errorf("topic template %q: field in level %q must fill the whole level", template, part)
+		}
+		if err := validateLevel(part, i == 0); err != nil {
+			return nil, fmt.Errorf("topic template %q: level %d: %w", template, i, err)
+		}
+		s.levels = append(s.levels, level{text: part})
+	}
+	return s, nil
+}
+
+// MustNew is New for templates known to be valid, it panics otherwise.
+func MustNew(template string) *Schema {
+	s, err := New(template)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func (s *Schema) String() string {
+	return s.template
+}
+
+// Fields returns the field names in the order they appear.
+func (s *Schema) Fields() []string {
+	return append([]string(nil), s.fields...)
+}
+
+// LevelError tells which field value cannot be used as a topic level.
+type LevelError struct {
+	Field string
+	Value string
+	Err   error
+}
+
+func (e *LevelError) Error() string {
+	return fmt.Sprintf("field %s=%q: %v", e.Field, e.Value, e.Err)
+}
+
+func (e *LevelError) Unwrap() error {
+	return e.Err
+}
+
+// Reasons a level is rejected, wrapped in LevelError
+var (
+	ErrEmptyLevel   = errors.New("empty level")
+	ErrLevelChars   = errors.New("contains '/', a wildcard or a control character")
+	ErrReserved     = errors.New("levels starting with '#' are reserved for the first level")
+	ErrInvalidUTF8  = errors.New("not valid UTF-8")
+	ErrTopicTooLong = fmt.Errorf("topic longer than %d bytes", MaxLength)
+	ErrMissingField = errors.New("no value")
+)
+
+// ValidateLevel checks that value can be used as a level of a published topic.
+func ValidateLevel(value string) error {
+	return validateLevel(value, false)
+}
+
+func validateLevel(value string, first bool) error {
+	if value == "" {
+		return ErrEmptyLevel
+	}
+	if !utf8.ValidString(value) {
+		return ErrInvalidUTF8
+	}
+	if strings.HasPrefix(value, "#") && !first {
+		return ErrReserved
+	}
+	for _, r := range value {
+		if r == '/' || r == '*' || r == '>' || unicode.IsControl(r) {
+			return ErrLevelChars
+		}
+	}
+	return nil
+}
+
+// Name returns the topic for fields without building a resource.Topic.
+func (s *Schema) Name(fields Fields) (string, error) {
+	levels := make([]string, len(s.levels))
+	for i, l := range s.levels {
+		if !l.field {
+			levels[i] = l.text
+			continue
+		}
+		val, ok := fields[l.text]
+		if !ok {
+			return "", &LevelError{Field: l.text, Err: ErrMissingField}
+		}
+		if err := validateLevel(val, i == 0); err != nil {
+			return "", &LevelError{Field: l.text, Value: val, Err: err}
+		}
+		levels[i] = val
+	}
+	if unknown := s.unknown(fields); len(unknown) > 0 {
+		return "", fmt.Errorf("topic %s has no field %s", s.template, strings.Join(unknown, ", "))
+	}
+	name := strings.Join(levels, "/")
+	if len(name) > MaxLength {
+		return "", fmt.Errorf("%w: %s", ErrTopicTooLong, name)
+	}
+	return name, nil
+}
+
+// Build returns the topic for fields, every field of the schema must be set.
+func (s *Schema) Build(fields Fields) (*resource.Topic, error) {
+	name, err := s.Name(fields)
+	if err != nil {
+		return nil, err
+	}
+	return resource.TopicOf(name), nil
+}
+
+// Topic is Build with the field values given in the order of Fields.
+func (s *Schema) Topic(values ...string) (*resource.Topic, error) {
+	if len(values) != len(s.fields) {
+		return nil, fmt.Errorf("topic %s has %d fields, got %d values", s.template, len(s.fields), len(values))
+	}
+	fields := make(Fields, len(values))
+	for i, val := range values {
+		fields[s.fields[i]] = val
+	}
+	return s.Build(fields)
+}
+
+// Parse splits a received destination name into the fields of the schema. It
+// fails when the topic has a different number of levels or a literal level
+// does not match.
+func (s *Schema) Parse(topic string) (Fields, error) {
+	levels := strings.Split(topic, "/")
+	if len(levels) != len(s.levels) {
+		return nil, fmt.Errorf("topic %s does not match %s: %d levels instead of %d", topic, s.template, len(levels), len(s.levels))
+	}
+	fields := make(Fields, len(s.fields))
+	for i, l := range s.levels {
+		if l.field {
+			fields[l.text] = levels[i]
+		} else if levels[i] != l.text {
+			return nil, fmt.Errorf("topic %s does not match %s: level %d is %q instead of %q", topic, s.template, i, levels[i], l.text)
+		}
+	}
+	return fields, nil
+}
+
+// Matches reports whether topic has the shape of the schema.
+func (s *Schema) Matches(topic string) bool {
+	_, err := s.Parse(topic)
+	return err == nil
+}
+
+// Subscription returns the subscription matching every topic of the schema
+// whose fields equal the given values. Fields that are not given match any
+// value with "*", and a value ending in "*" matches the values starting with
+// its prefix.
+func (s *Schema) Subscription(fields Fields) (*resource.TopicSubscription, error) {
+	name, err := s.subscriptionName(fields, false)
+	if err != nil {
+		return nil, err
+	}
+	return resource.TopicSubscriptionOf(name), nil
+}
+
+// PrefixSubscription is Subscription with the open fields after the last given
+// level collapsed into a single ">", so it also matches deeper topics that share
+// the prefix, e.g. those of a schema extended with more levels.
+func (s *Schema) PrefixSubscription(fields Fields) (*resource.TopicSubscription, error) {
+	name, err := s.subscriptionName(fields, true)
+	if err != nil {
+		return nil, err
+	}
+	return resource.TopicSubscriptionOf(name), nil
+}
+
+func (s *Schema) subscriptionName(fields Fields, collapse bool) (string, error) {
+	if unknown := s.unknown(fields); len(unknown) > 0 {
+		return "", fmt.Errorf("topic %s has no field %s", s.template, strings.Join(unknown, ", "))
+	}
+	levels := make([]string, len(s.levels))
+	lastFixed := -1
+	for i, l := range s.levels {
+		if !l.field {
+			levels[i] = l.text
+			lastFixed = i
+			continue
+		}
+		val, ok := fields[l.text]
+		if !ok || val == "*" {
+			levels[i] = "*"
+			continue
+		}
+		if err := validateLevel(strings.TrimSuffix(val, "*"), i == 0); err != nil {
+			return "", &LevelError{Field: l.text, Value: val, Err: err}
+		}
+		levels[i] = val
+		lastFixed = i
+	}
+	if collapse && lastFixed < len(levels)-1 {
+		levels = append(levels[:lastFixed+1], ">")
+	}
+	name := strings.Join(levels, "/")
+	if len(name) > MaxLength {
+		return "", fmt.Errorf("%w: %s", ErrTopicTooLong, name)
+	}
+	return name, nil
+}
+
+// unknown returns the names in fields the schema does not have, sorted
+func (s *Schema) unknown(fields Fields) []string {
+	var unknown []string
+	for name := range fields {
+		found := false
+		for _, f := range s.fields {
+			if f == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
@@ -0,0 +1,222 @@
+package topics
+
+import (
+	"errors"
+	"maps"
+	"strings"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		template   string
+		wantFields []string
+		wantErr    string
+	}{
+		{template: "acme/{app}/{region}/{entity}/{id}", wantFields: []string{"app", "region", "entity", "id"}},
+		{template: "fixed/topic"},
+		{template: "#P2P/QUE/{queue}", wantFields: []string{"queue"}},
+		{template: "{tenant}/orders", wantFields: []string{"tenant"}},
+		{template: "acme/{}/id", wantErr: "invalid field {}"},
+		{template: "acme/{a{b}}/id", wantErr: "invalid field"},
+		{template: "acme/{id}/{id}", wantErr: "field id used twice"},
+		{template: "acme/order-{id}", wantErr: "must fill the whole level"},
+		{template: "acme/{id", wantErr: "must fill the whole level"},
+		{template: "acme//orders", wantErr: "level 1: empty level"},
+		{template: "acme/*/orders", wantErr: "level 1: contains '/', a wildcard"},
+		{template: "acme/orders/>", wantErr: "level 2: contains '/', a wildcard"},
+		{template: "acme/#orders", wantErr: "reserved for the first level"},
+		{template: "acme/\xff", wantErr: "not valid UTF-8"},
+		{template: strings.Repeat("a/", MaxLevels) + "a", wantErr: "129 levels"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			s, err := New(tt.template)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.Fields(); strings.Join(got, ",") != strings.Join(tt.wantFields, ",") {
+				t.Errorf("fields %v, want %v", got, tt.wantFields)
+			}
+			if s.String() != tt.template {
+				t.Errorf("String() = %s", s)
+			}
+		})
+	}
+}
+
+func TestName(t *testing.T) {
+	s := MustNew("acme/{app}/{region}/{id}")
+	tests := []struct {
+		name    string
+		fields  Fields
+		want    string
+		wantErr error
+		field   string
+	}{
+		{name: "all fields", fields: Fields{"app": "shop", "region": "eu", "id": "42"}, want: "acme/shop/eu/42"},
+		{name: "missing", fields: Fields{"app": "shop", "id": "42"}, wantErr: ErrMissingField, field: "region"},
+		{name: "empty", fields: Fields{"app": "shop", "region": "", "id": "42"}, wantErr: ErrEmptyLevel, field: "region"},
+		{name: "slash", fields: Fields{"app": "shop", "region": "eu/west", "id": "42"}, wantErr: ErrLevelChars, field: "region"},
+		{name: "wildcard", fields: Fields{"app": "*", "region": "eu", "id": "42"}, wantErr: ErrLevelChars, field: "app"},
+		{name: "control character", fields: Fields{"app": "shop", "region": "eu", "id": "4\n2"}, wantErr: ErrLevelChars, field: "id"},
+		{name: "reserved", fields: Fields{"app": "#shop", "region": "eu", "id": "42"}, wantErr: ErrReserved, field: "app"},
+		{name: "too long", fields: Fields{"app": "shop", "region": "eu", "id": strings.Repeat("x", MaxLength)}, wantErr: ErrTopicTooLong},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.Name(tt.fields)
+			if got != tt.want || !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Name() = %q, %v, want %q, %v", got, err, tt.want, tt.wantErr)
+			}
+			var levelErr *LevelError
+			if tt.field != "" && (!errors.As(err, &levelErr) || levelErr.Field != tt.field) {
+				t.Errorf("error %v, want a LevelError for %s", err, tt.field)
+			}
+		})
+	}
+
+	if _, err := s.Name(Fields{"app": "shop", "region": "eu", "id": "42", "tenant": "t1"}); err == nil || !strings.Contains(err.Error(), "no field tenant") {
+		t.Errorf("error %v for an unknown field", err)
+	}
+	topic, err := s.Topic("shop", "eu", "42")
+	if err != nil || topic.GetName() != "acme/shop/eu/42" {
+		t.Errorf("Topic() = %v, %v", topic, err)
+	}
+	if _, err := s.Topic("shop", "eu"); err == nil {
+		t.Error("Topic() accepted too few values")
+	}
+	if topic, err := s.Build(Fields{"app": "shop", "region": "eu", "id": "42"}); err != nil || topic.GetName() != "acme/shop/eu/42" {
+		t.Errorf("Build() = %v, %v", topic, err)
+	}
+}
+
+func TestParse(t *testing.T) {
+	s := MustNew("acme/{app}/orders/{id}")
+	tests := []struct {
+		topic   string
+		want    Fields
+		wantErr string
+	}{
+		{topic: "acme/shop/orders/42", want: Fields{"app": "shop", "id": "42"}},
+		{topic: "acme/shop/invoices/42", wantErr: `level 2 is "invoices" instead of "orders"`},
+		{topic: "other/shop/orders/42", wantErr: "level 0"},
+		{topic: "acme/shop/orders", wantErr: "3 levels instead of 4"},
+		{topic: "acme/shop/orders/42/items", wantErr: "5 levels instead of 4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.topic, func(t *testing.T) {
+			got, err := s.Parse(tt.topic)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("error %v, want %q", err, tt.wantErr)
+				}
+				if s.Matches(tt.topic) {
+					t.Error("Matches() = true")
+				}
+				return
+			}
+			if err != nil || !maps.Equal(got, tt.want) {
+				t.Errorf("Parse() = %v, %v, want %v", got, err, tt.want)
+			}
+			if !s.Matches(tt.topic) {
+				t.Error("Matches() = false")
+			}
+		})
+	}
+
+	// a built topic parses back into its fields
+	fields := Fields{"app": "shop", "id": "42"}
+	name, err := s.Name(fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed, err := s.Parse(name); err != nil || !maps.Equal(parsed, fields) {
+		t.Errorf("round trip of %v = %v, %v", fields, parsed, err)
+	}
+}
+
+func TestSubscription(t *testing.T) {
+	s := MustNew("acme/{app}/{region}/{entity}/{id}")
+	tests := []struct {
+		name       string
+		fields     Fields
+		want       string
+		wantPrefix string
+		wantErr    error
+	}{
+		{name: "no fields", fields: nil, want: "acme/*/*/*/*", wantPrefix: "acme/>"},
+		{name: "leading field", fields: Fields{"app": "shop"}, want: "acme/shop/*/*/*", wantPrefix: "acme/shop/>"},
+		{name: "inner field", fields: Fields{"region": "eu"}, want: "acme/*/eu/*/*", wantPrefix: "acme/*/eu/>"},
+		{name: "last field", fields: Fields{"id": "42"}, want: "acme/*/*/*/42", wantPrefix: "acme/*/*/*/42"},
+		{name: "all fields", fields: Fields{"app": "shop", "region": "eu", "entity": "order", "id": "42"},
+			want: "acme/shop/eu/order/42", wantPrefix: "acme/shop/eu/order/42"},
+		{name: "explicit wildcard", fields: Fields{"app": "*", "region": "eu"}, want: "acme/*/eu/*/*", wantPrefix: "acme/*/eu/>"},
+		{name: "value prefix", fields: Fields{"region": "eu-*"}, want: "acme/*/eu-*/*/*", wantPrefix: "acme/*/eu-*/>"},
+		{name: "invalid value", fields: Fields{"region": "eu/west"}, wantErr: ErrLevelChars},
+		{name: "greater-than", fields: Fields{"region": ">"}, wantErr: ErrLevelChars},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub, err := s.Subscription(tt.fields)
+			prefix, prefixErr := s.PrefixSubscription(tt.fields)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) || !errors.Is(prefixErr, tt.wantErr) {
+					t.Errorf("errors %v and %v, want %v", err, prefixErr, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || prefixErr != nil {
+				t.Fatal(errors.Join(err, prefixErr))
+			}
+			if sub.GetName() != tt.want {
+				t.Errorf("Subscription() = %s, want %s", sub.GetName(), tt.want)
+			}
+			if prefix.GetName() != tt.wantPrefix {
+				t.Errorf("PrefixSubscription() = %s, want %s", prefix.GetName(), tt.wantPrefix)
+			}
+		})
+	}
+
+	if _, err := s.Subscription(Fields{"tenant": "t1"}); err == nil {
+		t.Error("Subscription() accepted an unknown field")
+	}
+	// the last literal level bounds the collapsed prefix
+	if sub, _ := MustNew("acme/{app}/orders/{id}").PrefixSubscription(nil); sub.GetName() != "acme/*/orders/>" {
+		t.Errorf("PrefixSubscription() = %s", sub.GetName())
+	}
+}
+
+func TestValidateLevel(t *testing.T) {
+	for value, want := range map[string]error{
+		"orders":    nil,
+		"eu-west_1": nil,
+		"über":      nil,
+		"":          ErrEmptyLevel,
+		"a/b":       ErrLevelChars,
+		"a*":        ErrLevelChars,
+		"a>":        ErrLevelChars,
+		"a\tb":      ErrLevelChars,
+		"#P2P":      ErrReserved,
+		"\xc3":      ErrInvalidUTF8,
+	} {
+		if err := ValidateLevel(value); !errors.Is(err, want) {
+			t.Errorf("ValidateLevel(%q) = %v, want %v", value, err, want)
+		}
+	}
+}
+
+func TestMustNewPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("MustNew() did not panic on an invalid template")
+		}
+	}()
+	MustNew("acme/{}")
+}
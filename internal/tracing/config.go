@@ -0,0 +1,233 @@
+// Package tracing sets up the OpenTelemetry tracer provider used by the
+// samples and wraps publishers and receivers so that the messages they send
+// and receive are traced.
+//
+// The exporter, span processor and sampler are chosen with the standard
+// OpenTelemetry environment variables where one exists:
+//
+//	OTEL_TRACES_EXPORTER                 console (default), otlp or none
+//	OTEL_EXPORTER_OTLP_PROTOCOL          grpc (default) or http/protobuf
+//	OTEL_EXPORTER_OTLP_TRACES_ENDPOINT   collector URL, falls back to OTEL_EXPORTER_OTLP_ENDPOINT
+//	OTEL_EXPORTER_OTLP_INSECURE          true to connect without TLS
+//	OTEL_TRACES_SAMPLER                  always_on (default), always_off, traceidratio,
+//	                                     parentbased_always_on, parentbased_always_off, parentbased_traceidratio
+//	OTEL_TRACES_SAMPLER_ARG              sampling ratio between 0 and 1 for the ratio samplers
+//	OTEL_SERVICE_NAME                    service.name resource attribute
+//
+// and SOLACE_TRACE_PROCESSOR set to simple (default) or batch. The simple
+// processor exports every span as it ends, which keeps the console output of the
+// samples in order; production deployments should use batch.
+package tracing
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Environment variables read by FromEnv
+const (
+	EnvExporter       = "OTEL_TRACES_EXPORTER"
+	EnvProtocol       = "OTEL_EXPORTER_OTLP_PROTOCOL"
+	EnvEndpoint       = "OTEL_EXPORTER_OTLP_ENDPOINT"
+	EnvTracesEndpoint = "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"
+	EnvInsecure       = "OTEL_EXPORTER_OTLP_INSECURE"
+	EnvSampler        = "OTEL_TRACES_SAMPLER"
+	EnvSamplerArg     = "OTEL_TRACES_SAMPLER_ARG"
+	EnvServiceName    = "OTEL_SERVICE_NAME"
+	EnvProcessor      = "SOLACE_TRACE_PROCESSOR"
+)
+
+// Exporters
+const (
+	ExporterConsole = "console"
+	ExporterOTLP    = "otlp"
+	ExporterNone    = "none"
+)
+
+// OTLP protocols
+const (
+	ProtocolGRPC = "grpc"
+	ProtocolHTTP = "http/protobuf"
+)
+
+// Span processors
+const (
+	ProcessorSimple = "simple"
+	ProcessorBatch  = "batch"
+)
+
+// Samplers
+const (
+	SamplerAlwaysOn        = "always_on"
+	SamplerAlwaysOff       = "always_off"
+	SamplerRatio           = "traceidratio"
+	SamplerParentAlwaysOn  = "parentbased_always_on"
+	SamplerParentAlwaysOff = "parentbased_always_off"
+	SamplerParentRatio     = "parentbased_traceidratio"
+)
+
+// Collector defaults of the OTLP specification
+const (
+	defaultGRPCEndpoint = "localhost:4317"
+	defaultHTTPEndpoint = "localhost:4318"
+	defaultHTTPPath     = "/v1/traces"
+)
+
+// Config selects how spans are sampled, processed and exported.
+type Config struct {
+	ServiceName string
+	Exporter    string
+	Protocol    string
+	// Endpoint is the collector address, either host:port or a URL. An http
+	// URL implies Insecure. Empty uses the default port of the protocol on localhost.
+	Endpoint   string
+	Insecure   bool
+	Processor  string
+	Sampler    string
+	SamplerArg float64
+}
+
+// Defaults returns the settings used when nothing else is configured: pretty
+// printed spans on the console, exported one by one, with every trace sampled.
+func Defaults() Config {
+	return Config{
+		ServiceName: "solace-samples",
+		Exporter:    ExporterConsole,
+		Protocol:    ProtocolGRPC,
+		Processor:   ProcessorSimple,
+		Sampler:     SamplerAlwaysOn,
+		SamplerArg:  1,
+	}
+}
+
+// FromEnv returns the defaults overridden by the environment variables that are set.
+func FromEnv() (Config, error) {
+	cfg := Defaults()
+	for name, field := range map[string]*string{
+		EnvServiceName: &cfg.ServiceName,
+		EnvExporter:    &cfg.Exporter,
+		EnvProtocol:    &cfg.Protocol,
+		EnvEndpoint:    &cfg.Endpoint,
+		EnvProcessor:   &cfg.Processor,
+		EnvSampler:     &cfg.Sampler,
+	} {
+		if val, ok := os.LookupEnv(name); ok {
+			*field = strings.TrimSpace(val)
+		}
+	}
+	// the signal specific endpoint is a full URL and wins over the generic one
+	if val, ok := os.LookupEnv(EnvTracesEndpoint); ok {
+		cfg.Endpoint = strings.TrimSpace(val)
+	} else if strings.Contains(cfg.Endpoint, "://") && cfg.Protocol == ProtocolHTTP {
+		// the generic endpoint is a base URL the signal path is appended to
+		cfg.Endpoint = strings.TrimSuffix(cfg.Endpoint, "/") + defaultHTTPPath
+	}
+	if val, ok := os.LookupEnv(EnvInsecure); ok {
+		insecure, err := strconv.ParseBool(val)
+		if err != nil {
+			return cfg, fmt.Errorf("%s: %w", EnvInsecure, err)
+		}
+		cfg.Insecure = insecure
+	}
+	if val, ok := os.LookupEnv(EnvSamplerArg); ok {
+		arg, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+		if err != nil {
+			return cfg, fmt.Errorf("%s: %w", EnvSamplerArg, err)
+		}
+		cfg.SamplerArg = arg
+	}
+	return cfg, cfg.Validate()
+}
+
+// Validate reports every problem found in the configuration.
+func (c Config) Validate() error {
+	var errs []error
+	switch c.Exporter {
+	case ExporterConsole, ExporterNone:
+	case ExporterOTLP:
+		switch c.Protocol {
+		case ProtocolGRPC, ProtocolHTTP:
+		default:
+			errs = append(errs, fmt.Errorf("unsupported OTLP protocol %q", c.Protocol))
+		}
+		if _, _, err := c.endpoint(); err != nil {
+			errs = append(errs, err)
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unsupported exporter %q", c.Exporter))
+	}
+	switch c.Processor {
+	case ProcessorSimple, ProcessorBatch:
+	default:
+		errs = append(errs, fmt.Errorf("unsupported span processor %q", c.Processor))
+	}
+	switch c.Sampler {
+	case SamplerAlwaysOn, SamplerAlwaysOff, SamplerParentAlwaysOn, SamplerParentAlwaysOff:
+	case SamplerRatio, SamplerParentRatio:
+		if c.SamplerArg < 0 || c.SamplerArg > 1 {
+			errs = append(errs, fmt.Errorf("sampling ratio %v is not between 0 and 1", c.SamplerArg))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unsupported sampler %q", c.Sampler))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid tracing configuration: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// endpoint splits Endpoint into the host:port and, for OTLP/HTTP, the URL path
+// the exporters take
+func (c Config) endpoint() (hostPort, path string, err error) {
+	if c.Protocol == ProtocolHTTP {
+		path = defaultHTTPPath
+	}
+	if c.Endpoint == "" {
+		if c.Protocol == ProtocolHTTP {
+			return defaultHTTPEndpoint, path, nil
+		}
+		return defaultGRPCEndpoint, path, nil
+	}
+	if !strings.Contains(c.Endpoint, "://") {
+		return c.Endpoint, path, nil
+	}
+	uri, err := url.Parse(c.Endpoint)
+	if err != nil {
+		return "", "", fmt.Errorf("OTLP endpoint %q: %w", c.Endpoint, err)
+	}
+	switch uri.Scheme {
+	case "http", "https":
+	default:
+		return "", "", fmt.Errorf("OTLP endpoint %q: unsupported scheme %q", c.Endpoint, uri.Scheme)
+	}
+	if uri.Host == "" {
+		return "", "", fmt.Errorf("OTLP endpoint %q: missing host", c.Endpoint)
+	}
+	if uri.Path != "" && uri.Path != "/" && c.Protocol == ProtocolHTTP {
+		path = uri.Path
+	}
+	return uri.Host, path, nil
+}
+
+// insecure reports whether the exporter connects without TLS
+func (c Config) insecure() bool {
+	return c.Insecure || strings.HasPrefix(c.Endpoint, "http://")
+}
+
+// String prints the configuration on one line.
+func (c Config) String() string {
+	s := fmt.Sprintf("service=%s exporter=%s", c.ServiceName, c.Exporter)
+	if c.Exporter == ExporterOTLP {
+		hostPort, path, _ := c.endpoint()
+		s += fmt.Sprintf(" protocol=%s endpoint=%s%s insecure=%t", c.Protocol, hostPort, path, c.insecure())
+	}
+	s += fmt.Sprintf(" processor=%s sampler=%s", c.Processor, c.Sampler)
+	if c.Sampler == SamplerRatio || c.Sampler == SamplerParentRatio {
+		s += fmt.Sprintf(" ratio=%v", c.SamplerArg)
+	}
+	return s
+}
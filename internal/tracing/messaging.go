@@ -0,0 +1,74 @@
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+	"solace.dev/go/messaging/pkg/solace/message"
+
+	solpropagation "solace.dev/go/messaging-trace/opentelemetry"
+)
+
+// InstrumentationName names the tracer of the wrappers in this package.
+const InstrumentationName = "SolaceSamples.com/PubSub+Go/internal/tracing"
+
+// Attributes and events recorded by the wrappers that have no semantic convention
+const (
+	RedeliveredKey  = attribute.Key("messaging.solace.redelivered")
+	OutcomeKey      = attribute.Key("messaging.solace.settlement_outcome")
+	PersistedKey    = attribute.Key("messaging.solace.persisted")
+	ReplyTimeoutKey = attribute.Key("messaging.solace.reply_timeout")
+	ReplySpanKey    = attribute.Key("messaging.solace.reply_span_id")
+
+	EventReceipt = "publish receipt"
+	EventSettle  = "settle"
+	EventReply   = "reply"
+)
+
+// tracer returns the tracer of the globally registered provider, looked up on
+// every use so that wrappers created before Setup still export
+func tracer() trace.Tracer {
+	return otel.GetTracerProvider().Tracer(InstrumentationName, trace.WithInstrumentationVersion(solpropagation.Version()))
+}
+
+// messagingAttributes are the attributes shared by every span about a message
+// sent to or received from topic
+func messagingAttributes(topic string, operation attribute.KeyValue) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		semconv.MessagingSystem("PubSub+"),
+		semconv.MessagingDestinationKindTopic,
+		semconv.MessagingDestinationName(topic),
+		operation,
+	}
+}
+
+// ContextFromMessage extracts the trace context carried by msg. For a message
+// handed out by a traced receiver this is the context of its receive span, so
+// spans started from it, e.g. for messages published while processing, become
+// children of the receive.
+func ContextFromMessage(msg message.InboundMessage) context.Context {
+	return otel.GetTextMapPropagator().Extract(context.Background(), solpropagation.NewInboundMessageCarrier(msg))
+}
+
+// injectOutbound stores the span context of ctx in msg. The first injection
+// sets the creation context of the message and the second its transport context,
+// so the receiver sees the span as its direct parent either way.
+func injectOutbound(ctx context.Context, msg message.OutboundMessage) {
+	carrier := solpropagation.NewOutboundMessageCarrier(msg)
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+}
+
+// injectInbound makes the span context of ctx the trace context of msg, so that
+// ContextFromMessage returns it to the handler
+func injectInbound(ctx context.Context, msg message.InboundMessage) {
+	otel.GetTextMapPropagator().Inject(ctx, solpropagation.NewInboundMessageCarrier(msg))
+}
+
+// outboundContext extracts the trace context msg was created in, if any
+func outboundContext(msg message.OutboundMessage) context.Context {
+	return otel.GetTextMapPropagator().Extract(context.Background(), solpropagation.NewOutboundMessageCarrier(msg))
+}
@@ -0,0 +1,212 @@
+// Package otlpstub is an in-process OTLP trace collector for trying out and
+// checking the OTLP export path without running a real collector. It accepts
+// OTLP/gRPC and OTLP/HTTP (protobuf) export requests on loopback ports and keeps
+// the received spans in memory.
+package otlpstub
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	collectortracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+// Span is the part of an exported span the stub keeps.
+type Span struct {
+	Service      string
+	Name         string
+	Kind         string
+	TraceID      string
+	SpanID       string
+	ParentSpanID string
+	Attributes   map[string]string
+	Events       []string
+	Status       string
+	// Protocol is grpc or http, depending on how the span was received.
+	Protocol string
+}
+
+// Collector receives OTLP trace exports.
+type Collector struct {
+	collectortracepb.UnimplementedTraceServiceServer
+
+	grpcListener net.Listener
+	grpcServer   *grpc.Server
+	httpListener net.Listener
+	httpServer   *http.Server
+
+	mu      sync.Mutex
+	spans   []Span
+	arrived chan struct{}
+}
+
+// Start listens for OTLP/gRPC and OTLP/HTTP on two free loopback ports.
+func Start() (*Collector, error) {
+	c := &Collector{arrived: make(chan struct{})}
+
+	var err error
+	if c.grpcListener, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+		return nil, fmt.Errorf("starting OTLP/gRPC stub: %w", err)
+	}
+	if c.httpListener, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+		c.grpcListener.Close()
+		return nil, fmt.Errorf("starting OTLP/HTTP stub: %w", err)
+	}
+
+	c.grpcServer = grpc.NewServer()
+	collectortracepb.RegisterTraceServiceServer(c.grpcServer, c)
+	go c.grpcServer.Serve(c.grpcListener)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/traces", c.serveHTTP)
+	c.httpServer = &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go c.httpServer.Serve(c.httpListener)
+
+	return c, nil
+}
+
+// GRPCEndpoint returns the host:port accepting OTLP/gRPC.
+func (c *Collector) GRPCEndpoint() string {
+	return c.grpcListener.Addr().String()
+}
+
+// HTTPEndpoint returns the URL accepting OTLP/HTTP trace exports.
+func (c *Collector) HTTPEndpoint() string {
+	return "http://" + c.httpListener.Addr().String() + "/v1/traces"
+}
+
+// Export implements the OTLP/gRPC trace service.
+func (c *Collector) Export(_ context.Context, req *collectortracepb.ExportTraceServiceRequest) (*collectortracepb.ExportTraceServiceResponse, error) {
+	c.record(req, "grpc")
+	return &collectortracepb.ExportTraceServiceResponse{}, nil
+}
+
+func (c *Collector) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	if ct := r.Header.Get("Content-Type"); ct != "application/x-protobuf" {
+		http.Error(w, "unsupported content type "+ct, http.StatusUnsupportedMediaType)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req := &collectortracepb.ExportTraceServiceRequest{}
+	if err := proto.Unmarshal(body, req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.record(req, "http")
+
+	resp, _ := proto.Marshal(&collectortracepb.ExportTraceServiceResponse{})
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.Write(resp)
+}
+
+func (c *Collector) record(req *collectortracepb.ExportTraceServiceRequest, protocol string) {
+	var received []Span
+	for _, rs := range req.GetResourceSpans() {
+		service := attributes(rs.GetResource().GetAttributes())["service.name"]
+		for _, ss := range rs.GetScopeSpans() {
+			for _, span := range ss.GetSpans() {
+				received = append(received, convert(span, service, protocol))
+			}
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.spans = append(c.spans, received...)
+	close(c.arrived)
+	c.arrived = make(chan struct{})
+}
+
+// Spans returns the spans received so far.
+func (c *Collector) Spans() []Span {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Span(nil), c.spans...)
+}
+
+// WaitForSpans blocks until at least n spans were received or ctx is done.
+func (c *Collector) WaitForSpans(ctx context.Context, n int) ([]Span, error) {
+	for {
+		c.mu.Lock()
+		if len(c.spans) >= n {
+			spans := append([]Span(nil), c.spans...)
+			c.mu.Unlock()
+			return spans, nil
+		}
+		arrived := c.arrived
+		received := len(c.spans)
+		c.mu.Unlock()
+
+		select {
+		case <-arrived:
+		case <-ctx.Done():
+			return c.Spans(), fmt.Errorf("received %d of %d spans: %w", received, n, ctx.Err())
+		}
+	}
+}
+
+// Close stops both listeners.
+func (c *Collector) Close() error {
+	c.grpcServer.Stop()
+	return c.httpServer.Close()
+}
+
+func convert(span *tracepb.Span, service, protocol string) Span {
+	s := Span{
+		Service:    service,
+		Name:       span.GetName(),
+		Kind:       span.GetKind().String(),
+		TraceID:    hex.EncodeToString(span.GetTraceId()),
+		SpanID:     hex.EncodeToString(span.GetSpanId()),
+		Attributes: attributes(span.GetAttributes()),
+		Status:     span.GetStatus().GetCode().String(),
+		Protocol:   protocol,
+	}
+	if len(span.GetParentSpanId()) > 0 {
+		s.ParentSpanID = hex.EncodeToString(span.GetParentSpanId())
+	}
+	for _, event := range span.GetEvents() {
+		s.Events = append(s.Events, event.GetName())
+	}
+	return s
+}
+
+func attributes(kvs []*commonpb.KeyValue) map[string]string {
+	attrs := make(map[string]string, len(kvs))
+	for _, kv := range kvs {
+		attrs[kv.GetKey()] = value(kv.GetValue())
+	}
+	return attrs
+}
+
+func value(v *commonpb.AnyValue) string {
+	switch val := v.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return val.StringValue
+	case *commonpb.AnyValue_BoolValue:
+		return fmt.Sprint(val.BoolValue)
+	case *commonpb.AnyValue_IntValue:
+		return fmt.Sprint(val.IntValue)
+	case *commonpb.AnyValue_DoubleValue:
+		return fmt.Sprint(val.DoubleValue)
+	}
+	return fmt.Sprint(v)
+}
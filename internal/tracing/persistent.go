@@ -0,0 +1,217 @@
+package tracing
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+	"solace.dev/go/messaging/pkg/solace"
+	"solace.dev/go/messaging/pkg/solace/config"
+	"solace.dev/go/messaging/pkg/solace/message"
+	"solace.dev/go/messaging/pkg/solace/resource"
+)
+
+// PersistentPublisher traces the messages published through a persistent
+// publisher. The publish span starts when the message is handed to the
+// publisher and ends when its publish receipt arrives, so its duration covers
+// the round trip to the broker and a failed receipt marks it as an error.
+//
+// It implements solace.PersistentMessagePublisher and can be passed to code
+// that expects one, e.g. lifecycle.TrackReceipts or retry.NewPublisher.
+// PublishBytes and PublishString cannot carry a trace context and are not traced.
+type PersistentPublisher struct {
+	solace.PersistentMessagePublisher
+
+	mu       sync.Mutex
+	listener solace.MessagePublishReceiptListener
+}
+
+// tracedPublish travels as the user context of a traced publish and is
+// replaced by the original user context before the receipt is passed on
+type tracedPublish struct {
+	span        trace.Span
+	userContext interface{}
+}
+
+// tracedReceipt hands out the user context given to Publish
+type tracedReceipt struct {
+	solace.PublishReceipt
+	userContext interface{}
+}
+
+func (r tracedReceipt) GetUserContext() interface{} {
+	return r.userContext
+}
+
+// NewPersistentPublisher wraps publisher. It installs the receipt listener that
+// ends the spans, so receipt listeners must be set on the returned publisher.
+func NewPersistentPublisher(publisher solace.PersistentMessagePublisher) *PersistentPublisher {
+	p := &PersistentPublisher{PersistentMessagePublisher: publisher}
+	publisher.SetMessagePublishReceiptListener(p.onReceipt)
+	return p
+}
+
+// SetMessagePublishReceiptListener sets the listener that receives the publish
+// receipts after the span of the message has ended.
+func (p *PersistentPublisher) SetMessagePublishReceiptListener(listener solace.MessagePublishReceiptListener) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.listener = listener
+}
+
+func (p *PersistentPublisher) onReceipt(receipt solace.PublishReceipt) {
+	if traced, ok := receipt.GetUserContext().(*tracedPublish); ok {
+		endWithReceipt(traced.span, receipt.IsPersisted(), receipt.GetError())
+		receipt = tracedReceipt{PublishReceipt: receipt, userContext: traced.userContext}
+	}
+	p.mu.Lock()
+	listener := p.listener
+	p.mu.Unlock()
+	if listener != nil {
+		listener(receipt)
+	}
+}
+
+// Publish publishes msg in a span whose parent is the trace context already
+// carried by msg, if any.
+func (p *PersistentPublisher) Publish(msg message.OutboundMessage, destination *resource.Topic, properties config.MessagePropertiesConfigurationProvider, context interface{}) error {
+	return p.PublishContext(outboundContext(msg), msg, destination, properties, context)
+}
+
+// PublishContext publishes msg in a span that is a child of the span in ctx and
+// injects the span into msg for the receivers.
+func (p *PersistentPublisher) PublishContext(ctx context.Context, msg message.OutboundMessage, destination *resource.Topic, properties config.MessagePropertiesConfigurationProvider, userContext interface{}) error {
+	span := startPublish(ctx, msg, destination)
+	err := p.PersistentMessagePublisher.Publish(msg, destination, properties, &tracedPublish{span: span, userContext: userContext})
+	if err != nil {
+		// no receipt follows a rejected publish
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		span.End()
+	}
+	return err
+}
+
+// PublishAwaitAcknowledgement publishes msg and waits for its receipt in a
+// span whose parent is the trace context already carried by msg, if any.
+func (p *PersistentPublisher) PublishAwaitAcknowledgement(msg message.OutboundMessage, destination *resource.Topic, timeout time.Duration, properties config.MessagePropertiesConfigurationProvider) error {
+	span := startPublish(outboundContext(msg), msg, destination)
+	err := p.PersistentMessagePublisher.PublishAwaitAcknowledgement(msg, destination, timeout, properties)
+	endWithReceipt(span, err == nil, err)
+	return err
+}
+
+func startPublish(ctx context.Context, msg message.OutboundMessage, destination *resource.Topic) trace.Span {
+	publishCtx, span := tracer().Start(ctx, destination.GetName()+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(messagingAttributes(destination.GetName(), semconv.MessagingOperationPublish)...))
+	injectOutbound(publishCtx, msg)
+	return span
+}
+
+func endWithReceipt(span trace.Span, persisted bool, err error) {
+	span.AddEvent(EventReceipt, trace.WithAttributes(PersistedKey.Bool(persisted)))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// PersistentReceiver traces the messages received through a persistent
+// receiver. Every message is handled in a consumer span that continues the
+// trace carried by the message and records whether it was redelivered.
+// Acknowledgements and settlements made while the handler runs are recorded as
+// events on that span, later ones in a short settle span that is its child.
+//
+// It implements solace.PersistentMessageReceiver, so the settlements have to go
+// through the wrapper to be recorded.
+type PersistentReceiver struct {
+	solace.PersistentMessageReceiver
+
+	mu     sync.Mutex
+	active map[message.InboundMessage]trace.Span
+}
+
+// NewPersistentReceiver wraps receiver.
+func NewPersistentReceiver(receiver solace.PersistentMessageReceiver) *PersistentReceiver {
+	return &PersistentReceiver{PersistentMessageReceiver: receiver, active: make(map[message.InboundMessage]trace.Span)}
+}
+
+// ReceiveAsync calls callback for every message inside its receive span.
+// ContextFromMessage returns the context of that span from within callback.
+func (r *PersistentReceiver) ReceiveAsync(callback solace.MessageHandler) error {
+	return r.PersistentMessageReceiver.ReceiveAsync(func(msg message.InboundMessage) {
+		span := r.startReceive(msg)
+		r.mu.Lock()
+		r.active[msg] = span
+		r.mu.Unlock()
+		defer func() {
+			r.mu.Lock()
+			delete(r.active, msg)
+			r.mu.Unlock()
+			span.End()
+		}()
+		callback(msg)
+	})
+}
+
+// ReceiveMessage receives a message in a span that ends before it is returned.
+func (r *PersistentReceiver) ReceiveMessage(timeout time.Duration) (message.InboundMessage, error) {
+	msg, err := r.PersistentMessageReceiver.ReceiveMessage(timeout)
+	if msg != nil {
+		r.startReceive(msg).End()
+	}
+	return msg, err
+}
+
+// startReceive starts the receive span of msg and makes it the trace context of msg
+func (r *PersistentReceiver) startReceive(msg message.InboundMessage) trace.Span {
+	attrs := append(messagingAttributes(msg.GetDestinationName(), semconv.MessagingOperationReceive),
+		RedeliveredKey.Bool(msg.IsRedelivered()))
+	if id, ok := msg.GetApplicationMessageID(); ok {
+		attrs = append(attrs, semconv.MessagingMessageID(id))
+	}
+	receiveCtx, span := tracer().Start(ContextFromMessage(msg), msg.GetDestinationName()+" receive",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(attrs...))
+	injectInbound(receiveCtx, msg)
+	return span
+}
+
+// Ack acknowledges msg and records the accepted outcome.
+func (r *PersistentReceiver) Ack(msg message.InboundMessage) error {
+	err := r.PersistentMessageReceiver.Ack(msg)
+	r.recordSettlement(msg, config.PersistentReceiverAcceptedOutcome, err)
+	return err
+}
+
+// Settle settles msg and records the outcome.
+func (r *PersistentReceiver) Settle(msg message.InboundMessage, outcome config.MessageSettlementOutcome) error {
+	err := r.PersistentMessageReceiver.Settle(msg, outcome)
+	r.recordSettlement(msg, outcome, err)
+	return err
+}
+
+func (r *PersistentReceiver) recordSettlement(msg message.InboundMessage, outcome config.MessageSettlementOutcome, err error) {
+	r.mu.Lock()
+	span, active := r.active[msg]
+	r.mu.Unlock()
+	if !active {
+		// the handler has returned, settle in a span of its own
+		_, span = tracer().Start(ContextFromMessage(msg), msg.GetDestinationName()+" settle",
+			trace.WithSpanKind(trace.SpanKindConsumer))
+		defer span.End()
+	}
+	attrs := []attribute.KeyValue{OutcomeKey.String(strings.ToLower(string(outcome)))}
+	if err != nil {
+		attrs = append(attrs, attribute.String("error", err.Error()))
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.AddEvent(EventSettle, trace.WithAttributes(attrs...))
+}
@@ -0,0 +1,319 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"solace.dev/go/messaging/pkg/solace"
+	"solace.dev/go/messaging/pkg/solace/config"
+	"solace.dev/go/messaging/pkg/solace/message"
+	"solace.dev/go/messaging/pkg/solace/resource"
+
+	"SolaceSamples.com/PubSub+Go/internal/fake"
+)
+
+// recordSpans exports the spans of the test to memory as they end
+func recordSpans(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	previous, previousPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	t.Cleanup(func() {
+		provider.Shutdown(context.Background())
+		otel.SetTracerProvider(previous)
+		otel.SetTextMapPropagator(previousPropagator)
+	})
+	return exporter
+}
+
+// spanNamed returns the only span called name
+func spanNamed(t *testing.T, exporter *tracetest.InMemoryExporter, name string) tracetest.SpanStub {
+	t.Helper()
+	var found []tracetest.SpanStub
+	for _, span := range exporter.GetSpans() {
+		if span.Name == name {
+			found = append(found, span)
+		}
+	}
+	if len(found) != 1 {
+		t.Fatalf("%d spans named %q in %v", len(found), name, spanNames(exporter))
+	}
+	return found[0]
+}
+
+func spanNames(exporter *tracetest.InMemoryExporter) []string {
+	var names []string
+	for _, span := range exporter.GetSpans() {
+		names = append(names, span.Name)
+	}
+	return names
+}
+
+// attr returns the value of key on span, or on its event called event when given
+func attr(span tracetest.SpanStub, event string, key attribute.Key) (attribute.Value, bool) {
+	attrs := span.Attributes
+	if event != "" {
+		attrs = nil
+		for _, e := range span.Events {
+			if e.Name == event {
+				attrs = e.Attributes
+			}
+		}
+	}
+	for _, kv := range attrs {
+		if kv.Key == key {
+			return kv.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+func hasEvent(span tracetest.SpanStub, name string) bool {
+	for _, e := range span.Events {
+		if e.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// heldReceipts keeps the receipts of a publisher until the test delivers them
+type heldReceipts struct {
+	solace.PersistentMessagePublisher
+	listener     solace.MessagePublishReceiptListener
+	userContexts []interface{}
+}
+
+func (h *heldReceipts) SetMessagePublishReceiptListener(listener solace.MessagePublishReceiptListener) {
+	h.listener = listener
+}
+
+func (h *heldReceipts) Publish(msg message.OutboundMessage, _ *resource.Topic, _ config.MessagePropertiesConfigurationProvider, userContext interface{}) error {
+	h.userContexts = append(h.userContexts, userContext)
+	return nil
+}
+
+// deliver hands the receipt of the publish number i to the listener
+func (h *heldReceipts) deliver(i int, err error) {
+	h.listener(heldReceipt{userContext: h.userContexts[i], err: err})
+}
+
+type heldReceipt struct {
+	userContext interface{}
+	err         error
+}
+
+func (r heldReceipt) GetUserContext() interface{}         { return r.userContext }
+func (r heldReceipt) GetTimeStamp() time.Time             { return time.Now() }
+func (r heldReceipt) GetMessage() message.OutboundMessage { return nil }
+func (r heldReceipt) GetError() error                     { return r.err }
+func (r heldReceipt) IsPersisted() bool                   { return r.err == nil }
+
+func TestPublishSpanEndsWithReceipt(t *testing.T) {
+	const topic = "solace/samples/go/traced"
+	errRejected := errors.New("rejected by the broker")
+	tests := []struct {
+		name          string
+		receiptErr    error
+		wantPersisted bool
+		wantStatus    codes.Code
+	}{
+		{name: "persisted", wantPersisted: true, wantStatus: codes.Unset},
+		{name: "failed", receiptErr: errRejected, wantStatus: codes.Error},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exporter := recordSpans(t)
+			held := &heldReceipts{}
+			publisher := NewPersistentPublisher(held)
+			var received []solace.PublishReceipt
+			publisher.SetMessagePublishReceiptListener(func(receipt solace.PublishReceipt) {
+				// the span has ended before the receipt is passed on
+				if len(exporter.GetSpans()) != 2 {
+					t.Errorf("spans %v when the receipt is passed on", spanNames(exporter))
+				}
+				received = append(received, receipt)
+			})
+
+			ctx, parent := otel.Tracer("test").Start(context.Background(), "parent")
+			msg, _ := fake.New().MessageBuilder().BuildWithStringPayload("Hello")
+			if err := publisher.PublishContext(ctx, msg, resource.TopicOf(topic), nil, "context"); err != nil {
+				t.Fatal(err)
+			}
+			parent.End()
+			if names := spanNames(exporter); len(names) != 1 {
+				t.Fatalf("spans %v before the receipt, want the publish span open", names)
+			}
+
+			held.deliver(0, tt.receiptErr)
+			span := spanNamed(t, exporter, topic+" publish")
+			if span.SpanKind != trace.SpanKindProducer || span.Parent.SpanID() != parent.SpanContext().SpanID() {
+				t.Errorf("publish span of kind %s with parent %s", span.SpanKind, span.Parent.SpanID())
+			}
+			if persisted, ok := attr(span, EventReceipt, PersistedKey); !ok || persisted.AsBool() != tt.wantPersisted {
+				t.Errorf("receipt event persisted=%v, %v, want %v", persisted.AsBool(), ok, tt.wantPersisted)
+			}
+			if span.Status.Code != tt.wantStatus {
+				t.Errorf("status %v, want %v", span.Status.Code, tt.wantStatus)
+			}
+			_, spanID, _, _, ok := msg.(*fake.Message).GetTransportTraceContext()
+			if !ok || trace.SpanID(spanID) != span.SpanContext.SpanID() {
+				t.Error("the message does not carry the publish span")
+			}
+			if len(received) != 1 || received[0].GetUserContext() != "context" || !errors.Is(received[0].GetError(), tt.receiptErr) {
+				t.Errorf("receipts %v, want the one with the original user context", received)
+			}
+		})
+	}
+}
+
+func TestPublishRejected(t *testing.T) {
+	exporter := recordSpans(t)
+	service := fake.New()
+	persistentPublisher, _ := service.CreatePersistentMessagePublisherBuilder().Build()
+	publisher := NewPersistentPublisher(persistentPublisher)
+	msg, _ := service.MessageBuilder().BuildWithStringPayload("Hello")
+
+	// not started, so the publish fails without a receipt
+	if err := publisher.Publish(msg, resource.TopicOf("a/b"), nil, nil); err == nil {
+		t.Fatal("publish on a publisher that is not started succeeded")
+	}
+	span := spanNamed(t, exporter, "a/b publish")
+	if span.Status.Code != codes.Error || hasEvent(span, EventReceipt) {
+		t.Errorf("rejected publish span with status %v and events %v", span.Status.Code, span.Events)
+	}
+}
+
+func TestReceiveAndSettle(t *testing.T) {
+	const topic = "solace/samples/go/traced"
+	exporter := recordSpans(t)
+	broker := fake.NewBroker()
+	queue := broker.CreateQueue("traced", topic)
+	service := broker.NewService()
+	if err := service.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer service.Disconnect()
+
+	persistentPublisher, _ := service.CreatePersistentMessagePublisherBuilder().Build()
+	publisher := NewPersistentPublisher(persistentPublisher)
+	if err := publisher.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer publisher.Terminate(time.Second)
+	for _, body := range []string{"ack", "settle later", "redeliver"} {
+		msg, _ := service.MessageBuilder().WithApplicationMessageID(body).BuildWithStringPayload(body)
+		if err := publisher.PublishAwaitAcknowledgement(msg, resource.TopicOf(topic), time.Second, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	persistentReceiver, _ := service.CreatePersistentMessageReceiverBuilder().
+		WithMessageClientAcknowledgement().
+		WithRequiredMessageOutcomeSupport(config.PersistentReceiverFailedOutcome, config.PersistentReceiverRejectedOutcome).
+		Build(resource.QueueDurableExclusive(queue.Name()))
+	receiver := NewPersistentReceiver(persistentReceiver)
+	if err := receiver.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer receiver.Terminate(time.Second)
+
+	later := make(chan message.InboundMessage, 1)
+	done := make(chan struct{}, 4)
+	redelivered := false
+	if err := receiver.ReceiveAsync(func(msg message.InboundMessage) {
+		defer func() { done <- struct{}{} }()
+		if trace.SpanContextFromContext(ContextFromMessage(msg)).SpanID() == (trace.SpanID{}) {
+			t.Error("no receive span in the handler")
+		}
+		switch body, _ := msg.GetPayloadAsString(); {
+		case body == "ack":
+			receiver.Ack(msg)
+		case body == "settle later":
+			later <- msg
+		case body == "redeliver" && !redelivered:
+			redelivered = true
+			receiver.Settle(msg, config.PersistentReceiverFailedOutcome)
+		default:
+			receiver.Ack(msg)
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("%d of 4 messages handled", i)
+		}
+	}
+	// settled after its handler returned
+	msg := <-later
+	if err := receiver.Settle(msg, config.PersistentReceiverRejectedOutcome); err != nil {
+		t.Fatal(err)
+	}
+
+	var receives []tracetest.SpanStub
+	for _, span := range exporter.GetSpans() {
+		if span.Name == topic+" receive" {
+			receives = append(receives, span)
+		}
+	}
+	if len(receives) != 4 {
+		t.Fatalf("%d receive spans, want 4: %v", len(receives), spanNames(exporter))
+	}
+	outcomes := map[string][]string{}
+	for _, span := range receives {
+		id, _ := attr(span, "", "messaging.message.id")
+		redeliveredAttr, ok := attr(span, "", RedeliveredKey)
+		if !ok {
+			t.Errorf("receive span of %s without the redelivered attribute", id.AsString())
+		}
+		if span.SpanKind != trace.SpanKindConsumer || span.Parent.TraceID() != span.SpanContext.TraceID() || !span.Parent.IsRemote() {
+			t.Errorf("receive span of %s does not continue the publisher's trace", id.AsString())
+		}
+		outcome, _ := attr(span, EventSettle, OutcomeKey)
+		key := id.AsString()
+		if redeliveredAttr.AsBool() {
+			key += " redelivered"
+		}
+		outcomes[key] = append(outcomes[key], outcome.AsString())
+	}
+	want := map[string][]string{
+		"ack":                   {"accepted"},
+		"settle later":          {""},
+		"redeliver":             {"failed"},
+		"redeliver redelivered": {"accepted"},
+	}
+	for key, outcome := range want {
+		if len(outcomes[key]) != 1 || outcomes[key][0] != outcome[0] {
+			t.Errorf("receive of %s settled %v, want %v", key, outcomes[key], outcome)
+		}
+	}
+
+	// a settlement after the handler returned gets a span of its own, child of the receive span
+	settle := spanNamed(t, exporter, topic+" settle")
+	if outcome, _ := attr(settle, EventSettle, OutcomeKey); outcome.AsString() != "rejected" {
+		t.Errorf("late settle span with outcome %q", outcome.AsString())
+	}
+	var parent tracetest.SpanStub
+	for _, span := range receives {
+		if id, _ := attr(span, "", "messaging.message.id"); id.AsString() == "settle later" {
+			parent = span
+		}
+	}
+	if settle.Parent.SpanID() != parent.SpanContext.SpanID() {
+		t.Errorf("late settle span is a child of %s, want the receive span %s", settle.Parent.SpanID(), parent.SpanContext.SpanID())
+	}
+}
@@ -0,0 +1,192 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+	"solace.dev/go/messaging/pkg/solace"
+	"solace.dev/go/messaging/pkg/solace/config"
+	"solace.dev/go/messaging/pkg/solace/message"
+	"solace.dev/go/messaging/pkg/solace/resource"
+)
+
+// RequestReplyPublisher traces the requests published through a request-reply
+// publisher. Every request is sent in a client span that is injected into the
+// request and ends when the reply arrives, so its duration is the round trip.
+// The span of the replier that the reply carries back is recorded on the reply
+// event, and a reply timeout sets the error status.
+//
+// PublishBytes and PublishString cannot carry a trace context and are not traced.
+type RequestReplyPublisher struct {
+	solace.RequestReplyMessagePublisher
+}
+
+// NewRequestReplyPublisher wraps publisher.
+func NewRequestReplyPublisher(publisher solace.RequestReplyMessagePublisher) *RequestReplyPublisher {
+	return &RequestReplyPublisher{RequestReplyMessagePublisher: publisher}
+}
+
+// Publish publishes requestMessage in a span whose parent is the trace context
+// already carried by the message, if any.
+func (p *RequestReplyPublisher) Publish(requestMessage message.OutboundMessage, replyMessageHandler solace.ReplyMessageHandler,
+	requestsDestination *resource.Topic, replyTimeout time.Duration,
+	properties config.MessagePropertiesConfigurationProvider, userContext interface{}) error {
+	return p.PublishContext(outboundContext(requestMessage), requestMessage, replyMessageHandler, requestsDestination, replyTimeout, properties, userContext)
+}
+
+// PublishContext publishes requestMessage in a span that is a child of the span
+// in ctx. The span ends before replyMessageHandler is called.
+func (p *RequestReplyPublisher) PublishContext(ctx context.Context, requestMessage message.OutboundMessage, replyMessageHandler solace.ReplyMessageHandler,
+	requestsDestination *resource.Topic, replyTimeout time.Duration,
+	properties config.MessagePropertiesConfigurationProvider, userContext interface{}) error {
+	span := startRequest(ctx, requestMessage, requestsDestination)
+	var once sync.Once
+	end := func(reply message.InboundMessage, err error) {
+		once.Do(func() { endWithReply(span, reply, err) })
+	}
+	handler := replyMessageHandler
+	if handler != nil {
+		// a nil handler is left for the publisher to reject
+		handler = func(reply message.InboundMessage, userContext interface{}, err error) {
+			end(reply, err)
+			replyMessageHandler(reply, userContext, err)
+		}
+	}
+	err := p.RequestReplyMessagePublisher.Publish(requestMessage, handler, requestsDestination, replyTimeout, properties, userContext)
+	if err != nil {
+		// no reply follows a rejected request
+		end(nil, err)
+	}
+	return err
+}
+
+// PublishAwaitResponse publishes requestMessage and waits for its reply in a
+// span whose parent is the trace context already carried by the message, if any.
+func (p *RequestReplyPublisher) PublishAwaitResponse(requestMessage message.OutboundMessage, requestDestination *resource.Topic,
+	replyTimeout time.Duration, properties config.MessagePropertiesConfigurationProvider) (message.InboundMessage, error) {
+	return p.PublishAwaitResponseContext(outboundContext(requestMessage), requestMessage, requestDestination, replyTimeout, properties)
+}
+
+// PublishAwaitResponseContext publishes requestMessage and waits for its reply
+// in a span that is a child of the span in ctx.
+func (p *RequestReplyPublisher) PublishAwaitResponseContext(ctx context.Context, requestMessage message.OutboundMessage, requestDestination *resource.Topic,
+	replyTimeout time.Duration, properties config.MessagePropertiesConfigurationProvider) (message.InboundMessage, error) {
+	span := startRequest(ctx, requestMessage, requestDestination)
+	reply, err := p.RequestReplyMessagePublisher.PublishAwaitResponse(requestMessage, requestDestination, replyTimeout, properties)
+	endWithReply(span, reply, err)
+	return reply, err
+}
+
+func startRequest(ctx context.Context, msg message.OutboundMessage, destination *resource.Topic) trace.Span {
+	requestCtx, span := tracer().Start(ctx, destination.GetName()+" request",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(messagingAttributes(destination.GetName(), semconv.MessagingOperationPublish)...))
+	injectOutbound(requestCtx, msg)
+	return span
+}
+
+// endWithReply ends the span of a request with its reply or error
+func endWithReply(span trace.Span, reply message.InboundMessage, err error) {
+	if reply != nil {
+		var attrs []attribute.KeyValue
+		if replier := trace.SpanContextFromContext(ContextFromMessage(reply)); replier.IsValid() {
+			attrs = append(attrs, ReplySpanKey.String(replier.SpanID().String()))
+		}
+		span.AddEvent(EventReply, trace.WithAttributes(attrs...))
+	}
+	var timeout *solace.TimeoutError
+	if errors.As(err, &timeout) {
+		span.SetAttributes(ReplyTimeoutKey.Bool(true))
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "reply timed out")
+	} else if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// RequestReplyReceiver traces the requests received through a request-reply
+// receiver. Every request is handled in a server span that is a child of the
+// requestor's span, and the reply carries the server span back to the requestor.
+type RequestReplyReceiver struct {
+	solace.RequestReplyMessageReceiver
+}
+
+// NewRequestReplyReceiver wraps receiver.
+func NewRequestReplyReceiver(receiver solace.RequestReplyMessageReceiver) *RequestReplyReceiver {
+	return &RequestReplyReceiver{RequestReplyMessageReceiver: receiver}
+}
+
+// ReceiveAsync calls messageHandler for every request inside its server span.
+// ContextFromMessage returns the context of that span from within the handler.
+func (r *RequestReplyReceiver) ReceiveAsync(messageHandler solace.RequestMessageHandler) error {
+	return r.RequestReplyMessageReceiver.ReceiveAsync(func(msg message.InboundMessage, replier solace.Replier) {
+		span := startServe(msg)
+		defer span.End()
+		if replier != nil {
+			replier = &tracedReplier{Replier: replier, request: msg, span: span}
+		}
+		messageHandler(msg, replier)
+	})
+}
+
+// ReceiveMessage receives a request in a server span that ends when the reply
+// is sent, or right away for a message that cannot be replied to.
+func (r *RequestReplyReceiver) ReceiveMessage(timeout time.Duration) (message.InboundMessage, solace.Replier, error) {
+	msg, replier, err := r.RequestReplyMessageReceiver.ReceiveMessage(timeout)
+	if msg == nil {
+		return msg, replier, err
+	}
+	span := startServe(msg)
+	if replier == nil {
+		span.End()
+		return msg, replier, err
+	}
+	return msg, &tracedReplier{Replier: replier, request: msg, span: span, endOnReply: true}, err
+}
+
+// startServe starts the server span of request and makes it the trace context of request
+func startServe(request message.InboundMessage) trace.Span {
+	attrs := messagingAttributes(request.GetDestinationName(), semconv.MessagingOperationProcess)
+	if id, ok := request.GetApplicationMessageID(); ok {
+		attrs = append(attrs, semconv.MessagingMessageID(id))
+	}
+	if id, ok := request.GetCorrelationID(); ok {
+		attrs = append(attrs, semconv.MessagingMessageConversationID(id))
+	}
+	serveCtx, span := tracer().Start(ContextFromMessage(request), request.GetDestinationName()+" process",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attrs...))
+	injectInbound(serveCtx, request)
+	return span
+}
+
+// tracedReplier injects the server span into the reply and records it
+type tracedReplier struct {
+	solace.Replier
+	request    message.InboundMessage
+	span       trace.Span
+	endOnReply bool
+}
+
+func (r *tracedReplier) Reply(msg message.OutboundMessage) error {
+	// the request carries the server span and the baggage it arrived with
+	injectOutbound(ContextFromMessage(r.request), msg)
+	err := r.Replier.Reply(msg)
+	r.span.AddEvent(EventReply)
+	if err != nil {
+		r.span.RecordError(err)
+		r.span.SetStatus(codes.Error, err.Error())
+	}
+	if r.endOnReply {
+		r.span.End()
+	}
+	return err
+}
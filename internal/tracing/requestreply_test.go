@@ -0,0 +1,181 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"solace.dev/go/messaging/pkg/solace"
+	"solace.dev/go/messaging/pkg/solace/message"
+	"solace.dev/go/messaging/pkg/solace/resource"
+
+	"SolaceSamples.com/PubSub+Go/internal/fake"
+)
+
+const requestTopic = "solace/samples/go/traced/request"
+
+// startRequestReply connects a service with a started traced request publisher
+func startRequestReply(t *testing.T) (*fake.Service, *RequestReplyPublisher) {
+	t.Helper()
+	service := fake.New()
+	if err := service.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { service.Disconnect() })
+	requestPublisher, _ := service.RequestReply().CreateRequestReplyMessagePublisherBuilder().Build()
+	publisher := NewRequestReplyPublisher(requestPublisher)
+	if err := publisher.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { publisher.Terminate(time.Second) })
+	return service, publisher
+}
+
+func TestRequestReply(t *testing.T) {
+	tests := []struct {
+		name string
+		// serve replies to the requests of receiver and returns the span IDs seen by the replier
+		serve func(t *testing.T, receiver *RequestReplyReceiver) <-chan trace.SpanID
+	}{
+		{name: "receive async", serve: func(t *testing.T, receiver *RequestReplyReceiver) <-chan trace.SpanID {
+			served := make(chan trace.SpanID, 1)
+			receiver.ReceiveAsync(func(request message.InboundMessage, replier solace.Replier) {
+				reply(t, request, replier, served)
+			})
+			return served
+		}},
+		{name: "receive message", serve: func(t *testing.T, receiver *RequestReplyReceiver) <-chan trace.SpanID {
+			served := make(chan trace.SpanID, 1)
+			go func() {
+				request, replier, err := receiver.ReceiveMessage(time.Second)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				reply(t, request, replier, served)
+			}()
+			return served
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exporter := recordSpans(t)
+			service, publisher := startRequestReply(t)
+			requestReceiver, _ := service.RequestReply().CreateRequestReplyMessageReceiverBuilder().
+				Build(resource.TopicSubscriptionOf(requestTopic))
+			receiver := NewRequestReplyReceiver(requestReceiver)
+			if err := receiver.Start(); err != nil {
+				t.Fatal(err)
+			}
+			defer receiver.Terminate(time.Second)
+			served := tt.serve(t, receiver)
+
+			ctx, parent := otel.Tracer("test").Start(context.Background(), "parent")
+			msg, _ := service.MessageBuilder().BuildWithStringPayload("ping")
+			replies := make(chan message.InboundMessage, 1)
+			err := publisher.PublishContext(ctx, msg, func(reply message.InboundMessage, _ interface{}, err error) {
+				if err != nil {
+					t.Error(err)
+				}
+				// the request span has ended before the handler is called
+				if !slices.Contains(spanNames(exporter), requestTopic+" request") {
+					t.Errorf("spans %v when the reply is handled", spanNames(exporter))
+				}
+				replies <- reply
+			}, resource.TopicOf(requestTopic), time.Second, nil, nil)
+			parent.End()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var replyMsg message.InboundMessage
+			select {
+			case replyMsg = <-replies:
+			case <-time.After(2 * time.Second):
+				t.Fatal("no reply")
+			}
+
+			request := spanNamed(t, exporter, requestTopic+" request")
+			process := spanNamed(t, exporter, requestTopic+" process")
+			if request.SpanKind != trace.SpanKindClient || request.Parent.SpanID() != parent.SpanContext().SpanID() {
+				t.Errorf("request span of kind %s with parent %s", request.SpanKind, request.Parent.SpanID())
+			}
+			if process.SpanKind != trace.SpanKindServer || process.Parent.SpanID() != request.SpanContext.SpanID() ||
+				process.SpanContext.TraceID() != request.SpanContext.TraceID() {
+				t.Errorf("process span with parent %s, want the request span %s", process.Parent.SpanID(), request.SpanContext.SpanID())
+			}
+			if seen := <-served; seen != process.SpanContext.SpanID() {
+				t.Errorf("replier saw span %s, want the process span %s", seen, process.SpanContext.SpanID())
+			}
+			if !hasEvent(process, EventReply) {
+				t.Error("no reply event on the process span")
+			}
+
+			// the reply carries the process span back to the requestor
+			if got := trace.SpanContextFromContext(ContextFromMessage(replyMsg)).SpanID(); got != process.SpanContext.SpanID() {
+				t.Errorf("reply carries span %s, want the process span %s", got, process.SpanContext.SpanID())
+			}
+			if replier, ok := attr(request, EventReply, ReplySpanKey); !ok || replier.AsString() != process.SpanContext.SpanID().String() {
+				t.Errorf("reply event names replier span %q, want %s", replier.AsString(), process.SpanContext.SpanID())
+			}
+			if _, ok := attr(request, "", ReplyTimeoutKey); ok || request.Status.Code == codes.Error {
+				t.Errorf("answered request span with status %v", request.Status.Code)
+			}
+		})
+	}
+}
+
+// reply answers request and sends the span ID the handler runs in to served
+func reply(t *testing.T, request message.InboundMessage, replier solace.Replier, served chan<- trace.SpanID) {
+	served <- trace.SpanContextFromContext(ContextFromMessage(request)).SpanID()
+	msg, _ := fake.New().MessageBuilder().BuildWithStringPayload("pong")
+	if err := replier.Reply(msg); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		request func(publisher *RequestReplyPublisher, msg message.OutboundMessage) error
+	}{
+		{name: "publish", request: func(publisher *RequestReplyPublisher, msg message.OutboundMessage) error {
+			errs := make(chan error, 1)
+			if err := publisher.Publish(msg, func(_ message.InboundMessage, _ interface{}, err error) {
+				errs <- err
+			}, resource.TopicOf(requestTopic), 10*time.Millisecond, nil, nil); err != nil {
+				return err
+			}
+			return <-errs
+		}},
+		{name: "publish await response", request: func(publisher *RequestReplyPublisher, msg message.OutboundMessage) error {
+			_, err := publisher.PublishAwaitResponse(msg, resource.TopicOf(requestTopic), 10*time.Millisecond, nil)
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exporter := recordSpans(t)
+			service, publisher := startRequestReply(t)
+			msg, _ := service.MessageBuilder().BuildWithStringPayload("ping")
+
+			// nobody replies
+			err := tt.request(publisher, msg)
+			var timeout *solace.TimeoutError
+			if !errors.As(err, &timeout) {
+				t.Fatalf("request returned %v, want a timeout", err)
+			}
+			span := spanNamed(t, exporter, requestTopic+" request")
+			if timedOut, ok := attr(span, "", ReplyTimeoutKey); !ok || !timedOut.AsBool() {
+				t.Error("timed out request span without the reply timeout attribute")
+			}
+			if span.Status.Code != codes.Error || hasEvent(span, EventReply) {
+				t.Errorf("timed out request span with status %v and events %v", span.Status.Code, span.Events)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+)
+
+// Setup builds a tracer provider from cfg and registers it globally together
+// with the W3C trace context and baggage propagators, the only formats Solace
+// carries in its messages. Call Shutdown on the returned provider before the
+// process exits so that batched spans are exported.
+func Setup(ctx context.Context, cfg Config) (*sdktrace.TracerProvider, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName(cfg.ServiceName)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("creating trace resource: %w", err)
+	}
+
+	opts := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(cfg.sampler()),
+	}
+	exporter, err := cfg.exporter(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if exporter != nil {
+		if cfg.Processor == ProcessorBatch {
+			opts = append(opts, sdktrace.WithBatcher(exporter))
+		} else {
+			opts = append(opts, sdktrace.WithSyncer(exporter))
+		}
+	}
+	provider := sdktrace.NewTracerProvider(opts...)
+
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	otel.SetTracerProvider(provider)
+	return provider, nil
+}
+
+// SetupFromEnv is Setup with the configuration returned by FromEnv.
+func SetupFromEnv(ctx context.Context) (*sdktrace.TracerProvider, error) {
+	cfg, err := FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return Setup(ctx, cfg)
+}
+
+// Enable sets up tracing with FromEnv when OTEL_TRACES_EXPORTER is set and
+// otherwise leaves the no-op provider in place, so that samples which trace on
+// request stay quiet by default. The returned function flushes and shuts the
+// provider down and is safe to call either way.
+func Enable(ctx context.Context) (func(context.Context) error, error) {
+	if os.Getenv(EnvExporter) == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+	provider, err := SetupFromEnv(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return provider.Shutdown, nil
+}
+
+// exporter creates the configured exporter, nil for ExporterNone
+func (c Config) exporter(ctx context.Context) (sdktrace.SpanExporter, error) {
+	var (
+		exporter sdktrace.SpanExporter
+		err      error
+	)
+	switch c.Exporter {
+	case ExporterNone:
+		return nil, nil
+	case ExporterConsole:
+		exporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	case ExporterOTLP:
+		hostPort, path, _ := c.endpoint()
+		if c.Protocol == ProtocolHTTP {
+			opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(hostPort), otlptracehttp.WithURLPath(path)}
+			if c.insecure() {
+				opts = append(opts, otlptracehttp.WithInsecure())
+			}
+			exporter, err = otlptracehttp.New(ctx, opts...)
+		} else {
+			opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(hostPort)}
+			if c.insecure() {
+				opts = append(opts, otlptracegrpc.WithInsecure())
+			}
+			exporter, err = otlptracegrpc.New(ctx, opts...)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("creating %s trace exporter: %w", c.Exporter, err)
+	}
+	return exporter, nil
+}
+
+func (c Config) sampler() sdktrace.Sampler {
+	switch c.Sampler {
+	case SamplerAlwaysOff:
+		return sdktrace.NeverSample()
+	case SamplerRatio:
+		return sdktrace.TraceIDRatioBased(c.SamplerArg)
+	case SamplerParentAlwaysOn:
+		return sdktrace.ParentBased(sdktrace.AlwaysSample())
+	case SamplerParentAlwaysOff:
+		return sdktrace.ParentBased(sdktrace.NeverSample())
+	case SamplerParentRatio:
+		return sdktrace.ParentBased(sdktrace.TraceIDRatioBased(c.SamplerArg))
+	}
+	return sdktrace.AlwaysSample()
+}
@@ -0,0 +1,273 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"solace.dev/go/messaging/pkg/solace/resource"
+
+	"SolaceSamples.com/PubSub+Go/internal/fake"
+	"SolaceSamples.com/PubSub+Go/internal/tracing/otlpstub"
+)
+
+// setEnv unsets every variable read by FromEnv, then sets vars for the test
+func setEnv(t *testing.T, vars map[string]string) {
+	t.Helper()
+	for _, name := range []string{EnvExporter, EnvProtocol, EnvEndpoint, EnvTracesEndpoint, EnvInsecure,
+		EnvSampler, EnvSamplerArg, EnvServiceName, EnvProcessor, "OTEL_RESOURCE_ATTRIBUTES"} {
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+	for name, val := range vars {
+		t.Setenv(name, val)
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    func(cfg *Config)
+		wantErr string
+	}{
+		{name: "defaults", want: func(cfg *Config) {}},
+		{
+			name: "otlp over grpc",
+			env:  map[string]string{EnvExporter: "otlp", EnvEndpoint: "collector:4317", EnvInsecure: "true", EnvServiceName: "publisher"},
+			want: func(cfg *Config) {
+				cfg.Exporter, cfg.Endpoint, cfg.Insecure, cfg.ServiceName = ExporterOTLP, "collector:4317", true, "publisher"
+			},
+		},
+		{
+			name: "the signal path is appended to the generic http endpoint",
+			env:  map[string]string{EnvExporter: "otlp", EnvProtocol: "http/protobuf", EnvEndpoint: "http://collector:4318/"},
+			want: func(cfg *Config) {
+				cfg.Exporter, cfg.Protocol, cfg.Endpoint = ExporterOTLP, ProtocolHTTP, "http://collector:4318/v1/traces"
+			},
+		},
+		{
+			name: "the traces endpoint wins",
+			env: map[string]string{EnvExporter: "otlp", EnvProtocol: "http/protobuf",
+				EnvEndpoint: "http://collector:4318", EnvTracesEndpoint: "https://traces:443/custom"},
+			want: func(cfg *Config) {
+				cfg.Exporter, cfg.Protocol, cfg.Endpoint = ExporterOTLP, ProtocolHTTP, "https://traces:443/custom"
+			},
+		},
+		{
+			name: "batch processor and ratio sampler",
+			env:  map[string]string{EnvProcessor: "batch", EnvSampler: "parentbased_traceidratio", EnvSamplerArg: " 0.25 "},
+			want: func(cfg *Config) {
+				cfg.Processor, cfg.Sampler, cfg.SamplerArg = ProcessorBatch, SamplerParentRatio, 0.25
+			},
+		},
+		{name: "invalid insecure flag", env: map[string]string{EnvInsecure: "maybe"}, wantErr: EnvInsecure},
+		{name: "invalid sampler argument", env: map[string]string{EnvSamplerArg: "half"}, wantErr: EnvSamplerArg},
+		{name: "ratio out of range", env: map[string]string{EnvSampler: "traceidratio", EnvSamplerArg: "2"}, wantErr: "not between 0 and 1"},
+		{name: "unknown exporter", env: map[string]string{EnvExporter: "zipkin"}, wantErr: "unsupported exporter"},
+		{name: "unknown protocol", env: map[string]string{EnvExporter: "otlp", EnvProtocol: "http/json"}, wantErr: "unsupported OTLP protocol"},
+		{name: "unknown endpoint scheme", env: map[string]string{EnvExporter: "otlp", EnvEndpoint: "ftp://collector"}, wantErr: "unsupported scheme"},
+		{name: "unknown processor", env: map[string]string{EnvProcessor: "async"}, wantErr: "unsupported span processor"},
+		{name: "unknown sampler", env: map[string]string{EnvSampler: "sometimes"}, wantErr: "unsupported sampler"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setEnv(t, tt.env)
+			cfg, err := FromEnv()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("error %v, want it to mention %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := Defaults()
+			tt.want(&want)
+			if cfg != want {
+				t.Errorf("config %s, want %s", cfg, want)
+			}
+		})
+	}
+}
+
+func TestEndpoint(t *testing.T) {
+	tests := []struct {
+		cfg          Config
+		wantHostPort string
+		wantPath     string
+		wantInsecure bool
+	}{
+		{Config{Protocol: ProtocolGRPC}, "localhost:4317", "", false},
+		{Config{Protocol: ProtocolHTTP}, "localhost:4318", "/v1/traces", false},
+		{Config{Protocol: ProtocolGRPC, Endpoint: "http://collector:4317"}, "collector:4317", "", true},
+		{Config{Protocol: ProtocolHTTP, Endpoint: "https://collector/otlp/v1/traces"}, "collector", "/otlp/v1/traces", false},
+		{Config{Protocol: ProtocolHTTP, Endpoint: "collector:4318", Insecure: true}, "collector:4318", "/v1/traces", true},
+	}
+	for _, tt := range tests {
+		hostPort, path, err := tt.cfg.endpoint()
+		if err != nil || hostPort != tt.wantHostPort || path != tt.wantPath || tt.cfg.insecure() != tt.wantInsecure {
+			t.Errorf("%+v: %s %s insecure=%v, %v", tt.cfg, hostPort, path, tt.cfg.insecure(), err)
+		}
+	}
+}
+
+func TestSampler(t *testing.T) {
+	tests := []struct {
+		sampler string
+		arg     string
+		want    string
+	}{
+		{SamplerAlwaysOn, "", "AlwaysOnSampler"},
+		{SamplerAlwaysOff, "", "AlwaysOffSampler"},
+		{SamplerRatio, "0.5", "TraceIDRatioBased{0.5}"},
+		{SamplerParentAlwaysOn, "", "ParentBased{root:AlwaysOnSampler"},
+		{SamplerParentAlwaysOff, "", "ParentBased{root:AlwaysOffSampler"},
+		{SamplerParentRatio, "0.1", "ParentBased{root:TraceIDRatioBased{0.1}"},
+	}
+	for _, tt := range tests {
+		env := map[string]string{EnvSampler: tt.sampler}
+		if tt.arg != "" {
+			env[EnvSamplerArg] = tt.arg
+		}
+		setEnv(t, env)
+		cfg, err := FromEnv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := cfg.sampler().Description(); !strings.HasPrefix(got, tt.want) {
+			t.Errorf("%s: sampler %s, want %s", tt.sampler, got, tt.want)
+		}
+	}
+}
+
+func TestSetupExportsToOTLP(t *testing.T) {
+	tests := []struct {
+		protocol  string
+		processor string
+		sampler   string
+		wantSpans int
+	}{
+		{ProtocolGRPC, ProcessorSimple, SamplerAlwaysOn, 2},
+		{ProtocolGRPC, ProcessorBatch, SamplerAlwaysOn, 2},
+		{ProtocolHTTP, ProcessorSimple, SamplerAlwaysOn, 2},
+		{ProtocolHTTP, ProcessorBatch, SamplerParentAlwaysOn, 2},
+		{ProtocolGRPC, ProcessorBatch, SamplerAlwaysOff, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.protocol+" "+tt.processor+" "+tt.sampler, func(t *testing.T) {
+			collector, err := otlpstub.Start()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer collector.Close()
+			endpoint, wantProtocol := collector.GRPCEndpoint(), "grpc"
+			if tt.protocol == ProtocolHTTP {
+				endpoint, wantProtocol = collector.HTTPEndpoint(), "http"
+			}
+			setEnv(t, map[string]string{
+				EnvExporter:       ExporterOTLP,
+				EnvProtocol:       tt.protocol,
+				EnvTracesEndpoint: endpoint,
+				EnvInsecure:       "true",
+				EnvProcessor:      tt.processor,
+				EnvSampler:        tt.sampler,
+				EnvServiceName:    "tracing-test",
+			})
+			shutdown, err := Enable(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			publishSpanID, publishTraceID := publishAndReceive(t)
+			if err := shutdown(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.wantSpans == 0 {
+				if spans := collector.Spans(); len(spans) != 0 {
+					t.Errorf("%d spans exported with %s", len(spans), tt.sampler)
+				}
+				return
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			spans, err := collector.WaitForSpans(ctx, tt.wantSpans)
+			if err != nil {
+				t.Fatal(err)
+			}
+			byKind := map[string]otlpstub.Span{}
+			for _, span := range spans {
+				byKind[span.Kind] = span
+				if span.Service != "tracing-test" || span.Protocol != wantProtocol {
+					t.Errorf("span %s of service %q received over %s", span.Name, span.Service, span.Protocol)
+				}
+			}
+			publish, receive := byKind["SPAN_KIND_PRODUCER"], byKind["SPAN_KIND_CONSUMER"]
+			if publish.Name != "solace/samples/go/traced publish" || publish.SpanID != publishSpanID || publish.TraceID != publishTraceID {
+				t.Errorf("publish span %+v", publish)
+			}
+			if publish.Attributes["messaging.destination.name"] != "solace/samples/go/traced" || len(publish.Events) != 1 || publish.Events[0] != EventReceipt {
+				t.Errorf("publish span attributes %v, events %v", publish.Attributes, publish.Events)
+			}
+			if receive.TraceID != publish.TraceID || receive.ParentSpanID != publish.SpanID {
+				t.Errorf("receive span %+v is not a child of the publish span", receive)
+			}
+		})
+	}
+}
+
+// publishAndReceive publishes a message with a traced persistent publisher on a
+// fake broker and receives it with a traced receiver. It returns the span and
+// trace IDs the message carries to the receiver.
+func publishAndReceive(t *testing.T) (spanID, traceID string) {
+	t.Helper()
+	broker := fake.NewBroker()
+	queue := broker.CreateQueue("traced", "solace/samples/go/traced")
+	service := broker.NewService()
+	if err := service.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer service.Disconnect()
+
+	persistentPublisher, _ := service.CreatePersistentMessagePublisherBuilder().Build()
+	publisher := NewPersistentPublisher(persistentPublisher)
+	if err := publisher.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer publisher.Terminate(0)
+	persistentReceiver, _ := service.CreatePersistentMessageReceiverBuilder().Build(resource.QueueDurableExclusive(queue.Name()))
+	receiver := NewPersistentReceiver(persistentReceiver)
+	if err := receiver.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer receiver.Terminate(0)
+
+	msg, _ := service.MessageBuilder().BuildWithStringPayload("Hello")
+	if err := publisher.PublishAwaitAcknowledgement(msg, resource.TopicOf("solace/samples/go/traced"), time.Second, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := receiver.ReceiveMessage(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	trace, span, _, _, ok := msg.(*fake.Message).GetTransportTraceContext()
+	if !ok {
+		t.Fatal("no trace context injected into the published message")
+	}
+	return hex.EncodeToString(span[:]), hex.EncodeToString(trace[:])
+}
+
+func TestEnableWithoutExporter(t *testing.T) {
+	setEnv(t, nil)
+	shutdown, err := Enable(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Errorf("shutdown of the no-op provider: %v", err)
+	}
+}
@@ -0,0 +1,99 @@
+// Package wildcard implements Solace topic subscription matching on the client side.
+//
+// Topics are split into levels on "/". In a subscription a level consisting of
+// "*" matches exactly one level, a level ending in "*" (e.g. "ord*") matches one
+// level starting with that prefix, and a final level of ">" matches one or more
+// remaining levels. A "*" anywhere else in a level is a literal character.
+// The "#share/<name>/" and "#noexport/" prefixes are ignored when matching.
+package wildcard
+
+import "strings"
+
+const (
+	sharePrefix    = "#share/"
+	noExportPrefix = "#noexport/"
+)
+
+// Match reports whether topic is matched by subscription.
+func Match(subscription, topic string) bool {
+	return matchLevels(strings.Split(Strip(subscription), "/"), strings.Split(topic, "/"))
+}
+
+// Strip removes the #noexport and #share/<name> prefixes from a subscription.
+func Strip(subscription string) string {
+	subscription = strings.TrimPrefix(subscription, noExportPrefix)
+	if strings.HasPrefix(subscription, sharePrefix) {
+		rest := subscription[len(sharePrefix):]
+		if i := strings.IndexByte(rest, '/'); i >= 0 {
+			return rest[i+1:]
+		}
+	}
+	return subscription
+}
+
+func matchLevels(sub, topic []string) bool {
+	for i, level := range sub {
+		if level == ">" && i == len(sub)-1 {
+			// must match at least one more level
+			return len(topic) > i
+		}
+		if i >= len(topic) {
+			return false
+		}
+		if !MatchLevel(level, topic[i]) {
+			return false
+		}
+	}
+	return len(sub) == len(topic)
+}
+
+// MatchLevel reports whether a single subscription level matches a topic level.
+func MatchLevel(sub, topic string) bool {
+	if strings.HasSuffix(sub, "*") {
+		return strings.HasPrefix(topic, sub[:len(sub)-1])
+	}
+	return sub == topic
+}
+
+// HasWildcards reports whether subscription contains any wildcard level.
+func HasWildcards(subscription string) bool {
+	levels := strings.Split(Strip(subscription), "/")
+	for i, level := range levels {
+		if strings.HasSuffix(level, "*") || (level == ">" && i == len(levels)-1) {
+			return true
+		}
+	}
+	return false
+}
+
+// Compare orders subscriptions that match the same topic by how specific they
+// are. It returns a positive number when a is more specific than b, a negative
+// one when b is, and 0 when they are equally specific. Levels are compared from
+// the left: a literal level beats a prefix level, a longer prefix beats a
+// shorter one, a prefix beats "*", and "*" beats a trailing ">".
+func Compare(a, b string) int {
+	aLevels := strings.Split(Strip(a), "/")
+	bLevels := strings.Split(Strip(b), "/")
+	for i := 0; i < len(aLevels) && i < len(bLevels); i++ {
+		if c := levelRank(aLevels[i], i == len(aLevels)-1) - levelRank(bLevels[i], i == len(bLevels)-1); c != 0 {
+			return c
+		}
+	}
+	// both matched the same topic, the longer one spelled out more levels
+	return len(aLevels) - len(bLevels)
+}
+
+// levelRank scores a subscription level, prefix levels by the length of the prefix
+func levelRank(level string, last bool) int {
+	switch {
+	case level == ">" && last:
+		return 0
+	case level == "*":
+		return 1
+	case strings.HasSuffix(level, "*"):
+		return 2 + len(level) - 1
+	default:
+		// above any prefix a topic level can have
+		return 1 << 20
+	}
+}
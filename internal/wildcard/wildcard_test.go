@@ -0,0 +1,99 @@
+package wildcard
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		subscription, topic string
+		want                bool
+	}{
+		{"a/b/c", "a/b/c", true},
+		{"a/b/c", "a/b", false},
+		{"a/b", "a/b/c", false},
+		{"a/*/c", "a/b/c", true},
+		{"a/*/c", "a/b/d", false},
+		{"a/*", "a/b/c", false},
+		{"a/b*/c", "a/bcd/c", true},
+		{"a/b*/c", "a/b/c", true},
+		{"a/b*/c", "a/cb/c", false},
+		{"a/b*c/d", "a/b*c/d", true},
+		{"a/b*c/d", "a/bxc/d", false},
+		{"a/>", "a/b", true},
+		{"a/>", "a/b/c/d", true},
+		{"a/>", "a", false},
+		{">", "a", true},
+		{"a/>/c", "a/>/c", true},
+		{"a/>/c", "a/b/c", false},
+		{"#share/group/a/*", "a/b", true},
+		{"#noexport/a/b", "a/b", true},
+		{"#noexport/#share/group/a/>", "a/b/c", true},
+	}
+	for _, tt := range tests {
+		if got := Match(tt.subscription, tt.topic); got != tt.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tt.subscription, tt.topic, got, tt.want)
+		}
+	}
+}
+
+func TestStrip(t *testing.T) {
+	tests := map[string]string{
+		"a/b":                     "a/b",
+		"#share/group/a/b":        "a/b",
+		"#noexport/a/b":           "a/b",
+		"#noexport/#share/g/a/>":  "a/>",
+		"#share/incomplete":       "#share/incomplete",
+		"prefix/#share/group/a/b": "prefix/#share/group/a/b",
+	}
+	for subscription, want := range tests {
+		if got := Strip(subscription); got != want {
+			t.Errorf("Strip(%q) = %q, want %q", subscription, got, want)
+		}
+	}
+}
+
+func TestHasWildcards(t *testing.T) {
+	tests := map[string]bool{
+		"a/b":              false,
+		"a/*":              true,
+		"a/b*":             true,
+		"a/b*c":            false,
+		"a/>":              true,
+		"a/>/b":            false,
+		"#share/group/a/b": false,
+		"#share/group/a/>": true,
+		"#noexport/a/pre*": true,
+	}
+	for subscription, want := range tests {
+		if got := HasWildcards(subscription); got != want {
+			t.Errorf("HasWildcards(%q) = %v, want %v", subscription, got, want)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	// each pair matches the same topic, a is more specific than b
+	tests := []struct{ a, b string }{
+		{"a/b/c", "a/*/c"},
+		{"a/b/c", "a/b*/c"},
+		{"a/bc*/c", "a/b*/c"},
+		{"a/b*/c", "a/*/c"},
+		{"a/*/c", "a/>"},
+		{"a/b/>", "a/*/>"},
+		{"a/b/c", "a/b/>"},
+		{"a/*/*", "a/>"},
+		{"#share/group/a/b", "a/*"},
+	}
+	for _, tt := range tests {
+		if c := Compare(tt.a, tt.b); c <= 0 {
+			t.Errorf("Compare(%q, %q) = %d, want > 0", tt.a, tt.b, c)
+		}
+		if c := Compare(tt.b, tt.a); c >= 0 {
+			t.Errorf("Compare(%q, %q) = %d, want < 0", tt.b, tt.a, c)
+		}
+	}
+	for _, sub := range []string{"a/b", "a/*", "a/>", "#share/group/a/b*"} {
+		if c := Compare(sub, sub); c != 0 {
+			t.Errorf("Compare(%q, %q) = %d, want 0", sub, sub, c)
+		}
+	}
+}
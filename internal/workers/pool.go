@@ -0,0 +1,339 @@
+// Package workers processes the messages of a persistent receiver on a pool of
+// goroutines. A handler turns every input message into the output messages to
+// publish, and the input is only acknowledged once the publish receipts of all
+// its outputs confirm they were persisted. A crash in between leads to a
+// redelivery of the input instead of a lost output.
+//
+// Messages with the same key, e.g. the queue partition key, are handled by the
+// same worker in the order they were received. The number of inputs that
+// are being handled or waiting for their receipts is bounded, the receiver's
+// callback blocks once the bound is reached.
+package workers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"solace.dev/go/messaging/pkg/solace"
+	"solace.dev/go/messaging/pkg/solace/config"
+	"solace.dev/go/messaging/pkg/solace/message"
+	"solace.dev/go/messaging/pkg/solace/resource"
+
+	"SolaceSamples.com/PubSub+Go/internal/dedup"
+	"SolaceSamples.com/PubSub+Go/internal/lifecycle"
+	"SolaceSamples.com/PubSub+Go/internal/retry"
+)
+
+// Output is a message a Handler wants published for its input.
+type Output struct {
+	Message     message.OutboundMessage
+	Destination *resource.Topic
+	Properties  config.MessagePropertiesConfigurationProvider
+	// UserContext is passed on with the receipt of the output, see ReceiptListener.
+	UserContext interface{}
+}
+
+// Handler processes an input message and returns its outputs. The input is
+// acknowledged as soon as it returns no outputs and settled as failed when it
+// returns an error. It must not settle the input itself.
+type Handler func(msg message.InboundMessage) ([]Output, error)
+
+// KeyFunc extracts the ordering key of a message, ok is false when the message
+// has none and may be handled by any worker.
+type KeyFunc func(msg message.InboundMessage) (key string, ok bool)
+
+// ByPartitionKey keys messages on the queue partition key they were published with.
+func ByPartitionKey() KeyFunc {
+	return ByProperty(config.QueuePartitionKey)
+}
+
+// ByProperty keys messages on the value of a user property.
+func ByProperty(name string) KeyFunc {
+	return KeyFunc(dedup.ByProperty(name))
+}
+
+// Publisher publishes the outputs. It is implemented by
+// solace.PersistentMessagePublisher, retry.Publisher and lifecycle.Receipts.
+type Publisher interface {
+	Publish(msg message.OutboundMessage, destination *resource.Topic, properties config.MessagePropertiesConfigurationProvider, userContext interface{}) error
+}
+
+// Options configures a Pool.
+type Options struct {
+	// Workers is the number of goroutines calling the handler, runtime.NumCPU() by default.
+	Workers int
+	// MaxInFlight bounds the inputs that are queued, being handled or waiting for
+	// the receipts of their outputs, 16 per worker by default.
+	MaxInFlight int
+	// Key orders the messages that share a key. When it is nil messages are
+	// spread over the workers without any ordering.
+	Key KeyFunc
+	// FailedOutcome settles inputs whose handler failed or whose outputs were
+	// rejected, config.PersistentReceiverFailedOutcome by default. The receiver
+	// must be built with support for it.
+	FailedOutcome config.MessageSettlementOutcome
+	// DeadLetteredOutcome settles inputs whose outputs were persisted, but some
+	// of them on the dead-letter topic of a retry.Publisher, so the input is not
+	// redelivered to be dead-lettered again. config.PersistentReceiverAcceptedOutcome
+	// by default, config.PersistentReceiverRejectedOutcome moves the input to
+	// the DMQ as well.
+	DeadLetteredOutcome config.MessageSettlementOutcome
+}
+
+// Stats is a snapshot of the counters of a Pool.
+type Stats struct {
+	Acked        uint64 // inputs acknowledged after their outputs were persisted
+	Failed       uint64 // inputs settled with the failed outcome
+	DeadLettered uint64 // inputs settled with the dead-lettered outcome
+	Published    uint64 // outputs persisted by the broker
+	Diverted     uint64 // outputs persisted on the dead-letter topic
+	Rejected     uint64 // outputs rejected by the publisher or the broker
+	InFlight     int    // inputs not settled yet
+}
+
+func (s Stats) String() string {
+	return fmt.Sprintf("acked=%d failed=%d dead-lettered=%d published=%d diverted=%d rejected=%d in-flight=%d",
+		s.Acked, s.Failed, s.DeadLettered, s.Published, s.Diverted, s.Rejected, s.InFlight)
+}
+
+// Pool hands the messages of a persistent receiver, which must be built with
+// client acknowledgement, to a fixed number of workers.
+type Pool struct {
+	receiver solace.PersistentMessageReceiver
+	opts     Options
+
+	publisher Publisher
+	handler   Handler
+
+	queues    []chan message.InboundMessage
+	slots     chan struct{}
+	next      atomic.Uint64
+	done      chan struct{}
+	closeOnce sync.Once
+	workers   sync.WaitGroup
+
+	acked, failed, deadLettered   atomic.Uint64
+	published, diverted, rejected atomic.Uint64
+
+	inFlight lifecycle.InFlight
+}
+
+// input tracks the outputs of an input message that still wait for a receipt
+type input struct {
+	msg          message.InboundMessage
+	remaining    atomic.Int32
+	failed       atomic.Bool
+	deadLettered atomic.Bool
+}
+
+// output is the user context of a published output
+type output struct {
+	input       *input
+	userContext interface{}
+}
+
+// outputReceipt hands out the user context of the Output
+type outputReceipt struct {
+	solace.PublishReceipt
+	userContext interface{}
+}
+
+func (r outputReceipt) GetUserContext() interface{} {
+	return r.userContext
+}
+
+// New creates a pool for receiver. Receipts must be routed to it with
+// ReceiptListener before Start is called.
+func New(receiver solace.PersistentMessageReceiver, opts Options) *Pool {
+	if opts.Workers <= 0 {
+		opts.Workers = runtime.NumCPU()
+	}
+	if opts.MaxInFlight <= 0 {
+		opts.MaxInFlight = 16 * opts.Workers
+	}
+	if opts.FailedOutcome == "" {
+		opts.FailedOutcome = config.PersistentReceiverFailedOutcome
+	}
+	if opts.DeadLetteredOutcome == "" {
+		opts.DeadLetteredOutcome = config.PersistentReceiverAcceptedOutcome
+	}
+	p := &Pool{
+		receiver: receiver,
+		opts:     opts,
+		queues:   make([]chan message.InboundMessage, opts.Workers),
+		slots:    make(chan struct{}, opts.MaxInFlight),
+		done:     make(chan struct{}),
+	}
+	for i := range p.queues {
+		// the slots bound the queued messages, so sends never block
+		p.queues[i] = make(chan message.InboundMessage, opts.MaxInFlight)
+	}
+	return p
+}
+
+// ReceiptListener returns the receipt listener to install on the publisher
+// passed to Start, e.g. as the listener of retry.NewPublisher. It settles the
+// inputs and passes every receipt on to next, which may be nil, with the user
+// context of the Output.
+func (p *Pool) ReceiptListener(next solace.MessagePublishReceiptListener) solace.MessagePublishReceiptListener {
+	return func(receipt solace.PublishReceipt) {
+		out, ok := receipt.GetUserContext().(*output)
+		if !ok {
+			// published around the pool
+			if next != nil {
+				next(receipt)
+			}
+			return
+		}
+		p.outputDone(out.input, receipt.GetError())
+		if next != nil {
+			next(outputReceipt{PublishReceipt: receipt, userContext: out.userContext})
+		}
+	}
+}
+
+// Start starts the workers and registers the pool on the receiver. Outputs are
+// published with publisher, whose receipts must reach ReceiptListener.
+func (p *Pool) Start(publisher Publisher, handler Handler) error {
+	p.publisher = publisher
+	p.handler = handler
+	for _, queue := range p.queues {
+		p.workers.Add(1)
+		go p.work(queue)
+	}
+	return p.receiver.ReceiveAsync(p.dispatch)
+}
+
+// dispatch waits for a free slot and queues msg on the worker of its key
+func (p *Pool) dispatch(msg message.InboundMessage) {
+	select {
+	case p.slots <- struct{}{}:
+	case <-p.done:
+		// left unsettled, the broker redelivers it
+		return
+	}
+	p.inFlight.Begin()
+
+	var worker uint64
+	if key, ok := p.key(msg); ok {
+		h := fnv.New32a()
+		h.Write([]byte(key))
+		worker = uint64(h.Sum32())
+	} else {
+		worker = p.next.Add(1)
+	}
+	p.queues[worker%uint64(len(p.queues))] <- msg
+}
+
+func (p *Pool) key(msg message.InboundMessage) (string, bool) {
+	if p.opts.Key == nil {
+		return "", false
+	}
+	return p.opts.Key(msg)
+}
+
+func (p *Pool) work(queue chan message.InboundMessage) {
+	defer p.workers.Done()
+	for {
+		select {
+		case msg := <-queue:
+			p.process(msg)
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// process calls the handler and publishes the outputs of msg
+func (p *Pool) process(msg message.InboundMessage) {
+	outputs, err := p.handler(msg)
+	if err != nil {
+		p.settle(msg, p.opts.FailedOutcome, &p.failed)
+		return
+	}
+	if len(outputs) == 0 {
+		p.settle(msg, config.PersistentReceiverAcceptedOutcome, &p.acked)
+		return
+	}
+
+	in := &input{msg: msg}
+	in.remaining.Store(int32(len(outputs)))
+	for _, o := range outputs {
+		err := p.publisher.Publish(o.Message, o.Destination, o.Properties, &output{input: in, userContext: o.UserContext})
+		if err != nil {
+			// no receipt follows a rejected publish
+			p.outputDone(in, err)
+		}
+	}
+}
+
+// outputDone records the publish error of an output and settles its input after the last one
+func (p *Pool) outputDone(in *input, err error) {
+	switch {
+	case err == nil:
+		p.published.Add(1)
+	case errors.Is(err, retry.ErrDeadLettered):
+		p.diverted.Add(1)
+		in.deadLettered.Store(true)
+	default:
+		p.rejected.Add(1)
+		in.failed.Store(true)
+	}
+	if in.remaining.Add(-1) > 0 {
+		return
+	}
+	switch {
+	case in.failed.Load():
+		p.settle(in.msg, p.opts.FailedOutcome, &p.failed)
+	case in.deadLettered.Load():
+		p.settle(in.msg, p.opts.DeadLetteredOutcome, &p.deadLettered)
+	default:
+		p.settle(in.msg, config.PersistentReceiverAcceptedOutcome, &p.acked)
+	}
+}
+
+// settle settles msg with outcome, counts it and frees its slot
+func (p *Pool) settle(msg message.InboundMessage, outcome config.MessageSettlementOutcome, count *atomic.Uint64) {
+	if outcome == config.PersistentReceiverAcceptedOutcome {
+		p.receiver.Ack(msg)
+	} else {
+		p.receiver.Settle(msg, outcome)
+	}
+	count.Add(1)
+	<-p.slots
+	p.inFlight.End()
+}
+
+// Stats returns the current counters.
+func (p *Pool) Stats() Stats {
+	return Stats{
+		Acked:        p.acked.Load(),
+		Failed:       p.failed.Load(),
+		DeadLettered: p.deadLettered.Load(),
+		Published:    p.published.Load(),
+		Diverted:     p.diverted.Load(),
+		Rejected:     p.rejected.Load(),
+		InFlight:     p.inFlight.Count(),
+	}
+}
+
+// Drain waits until every input handed to the pool has been settled or ctx is
+// done. Pause the receiver first so that no new inputs arrive.
+func (p *Pool) Drain(ctx context.Context) error {
+	if err := p.inFlight.Wait(ctx); err != nil {
+		return fmt.Errorf("%d messages still being processed: %w", p.inFlight.Count(), err)
+	}
+	return nil
+}
+
+// Close stops the workers once their current message is handled. Queued inputs
+// are left unsettled and redelivered by the broker, Drain first to avoid that.
+func (p *Pool) Close() {
+	p.closeOnce.Do(func() { close(p.done) })
+	p.workers.Wait()
+}
@@ -0,0 +1,404 @@
+package workers_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"solace.dev/go/messaging/pkg/solace/config"
+	"solace.dev/go/messaging/pkg/solace/message"
+	"solace.dev/go/messaging/pkg/solace/resource"
+
+	"SolaceSamples.com/PubSub+Go/internal/fake"
+	"SolaceSamples.com/PubSub+Go/internal/retry"
+	"SolaceSamples.com/PubSub+Go/internal/workers"
+)
+
+const (
+	inputTopic      = "solace/samples/guaranteed/processor/input"
+	outputTopic     = "solace/samples/guaranteed/processor/output"
+	deadLetterTopic = "solace/samples/guaranteed/processor/dlq"
+)
+
+// uppercase is the handler of the guaranteed_processor sample
+func uppercase(builder func(string) (message.OutboundMessage, error)) workers.Handler {
+	return func(msg message.InboundMessage) ([]workers.Output, error) {
+		body, ok := msg.GetPayloadAsString()
+		if !ok {
+			return nil, errors.New("no string payload")
+		}
+		output, err := builder(strings.ToUpper(body))
+		if err != nil {
+			return nil, err
+		}
+		return []workers.Output{{Message: output, Destination: resource.TopicOf(outputTopic)}}, nil
+	}
+}
+
+func TestPoolSettlement(t *testing.T) {
+	nak := errors.New("queue full")
+	upper := func(service *fake.Service) workers.Handler {
+		return uppercase(func(body string) (message.OutboundMessage, error) {
+			return service.MessageBuilder().BuildWithStringPayload(body)
+		})
+	}
+	tests := []struct {
+		name       string
+		handler    func(service *fake.Service) workers.Handler
+		nak        []string                        // topics the broker NAKs
+		deadLetter bool                            // publish through a retry.Publisher with a dead-letter topic
+		outcome    config.MessageSettlementOutcome // Options.DeadLetteredOutcome
+		wantStats  workers.Stats
+		wantOutput string
+		wantDMQ    bool
+	}{
+		{
+			name: "acked once the output is persisted",
+			handler: func(service *fake.Service) workers.Handler {
+				return uppercase(func(body string) (message.OutboundMessage, error) {
+					return service.MessageBuilder().BuildWithStringPayload(body)
+				})
+			},
+			wantStats:  workers.Stats{Acked: 1, Published: 1},
+			wantOutput: "HELLO WORLD",
+		},
+		{
+			name: "acked without outputs",
+			handler: func(*fake.Service) workers.Handler {
+				return func(message.InboundMessage) ([]workers.Output, error) { return nil, nil }
+			},
+			wantStats: workers.Stats{Acked: 1},
+		},
+		{
+			name: "failed when the handler fails",
+			handler: func(*fake.Service) workers.Handler {
+				return func(message.InboundMessage) ([]workers.Output, error) { return nil, errors.New("bad input") }
+			},
+			wantStats: workers.Stats{Failed: 1},
+		},
+		{
+			name: "failed when the output is rejected",
+			handler: func(service *fake.Service) workers.Handler {
+				return uppercase(func(body string) (message.OutboundMessage, error) {
+					return service.MessageBuilder().BuildWithStringPayload(body)
+				})
+			},
+			nak:       []string{outputTopic},
+			wantStats: workers.Stats{Failed: 1, Rejected: 1},
+		},
+		{
+			name:       "acked once the output is dead-lettered",
+			handler:    upper,
+			nak:        []string{outputTopic},
+			deadLetter: true,
+			wantStats:  workers.Stats{DeadLettered: 1, Diverted: 1},
+		},
+		{
+			name:       "rejected once the output is dead-lettered",
+			handler:    upper,
+			nak:        []string{outputTopic},
+			deadLetter: true,
+			outcome:    config.PersistentReceiverRejectedOutcome,
+			wantStats:  workers.Stats{DeadLettered: 1, Diverted: 1},
+			wantDMQ:    true,
+		},
+		{
+			name:       "failed when the dead-letter topic rejects the output too",
+			handler:    upper,
+			nak:        []string{outputTopic, deadLetterTopic},
+			deadLetter: true,
+			wantStats:  workers.Stats{Failed: 1, Rejected: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			broker := fake.NewBroker()
+			input := broker.CreateQueue("input", inputTopic)
+			output := broker.CreateQueue("output", outputTopic)
+			deadLetters := broker.CreateQueue("dlq", deadLetterTopic)
+			broker.SetPublishInterceptor(func(topic string, msg message.OutboundMessage) error {
+				if slices.Contains(tt.nak, topic) {
+					return nak
+				}
+				return nil
+			})
+
+			service := broker.NewService()
+			if err := service.Connect(); err != nil {
+				t.Fatal(err)
+			}
+			defer service.Disconnect()
+			receiver, err := service.CreatePersistentMessageReceiverBuilder().
+				WithMessageClientAcknowledgement().
+				WithRequiredMessageOutcomeSupport(config.PersistentReceiverFailedOutcome, config.PersistentReceiverRejectedOutcome).
+				Build(resource.QueueDurableExclusive("input"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := receiver.Start(); err != nil {
+				t.Fatal(err)
+			}
+			defer receiver.Terminate(0)
+
+			pool := workers.New(receiver, workers.Options{Workers: 2, Key: workers.ByPartitionKey(), DeadLetteredOutcome: tt.outcome})
+			defer pool.Close()
+			publisher, err := service.CreatePersistentMessagePublisherBuilder().Build()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var outputs workers.Publisher = publisher
+			if tt.deadLetter {
+				policy := retry.Policy{MaxAttempts: 2, InitialBackoff: time.Millisecond, DeadLetterTopic: deadLetterTopic}
+				outputs = retry.NewPublisher(publisher, policy, pool.ReceiptListener(nil))
+			} else {
+				publisher.SetMessagePublishReceiptListener(pool.ReceiptListener(nil))
+			}
+			if err := publisher.Start(); err != nil {
+				t.Fatal(err)
+			}
+			defer publisher.Terminate(0)
+			if err := pool.Start(outputs, tt.handler(service)); err != nil {
+				t.Fatal(err)
+			}
+
+			msg, _ := service.MessageBuilder().BuildWithStringPayload("hello world")
+			if err := publisher.PublishAwaitAcknowledgement(msg, resource.TopicOf(inputTopic), time.Second, nil); err != nil {
+				t.Fatal(err)
+			}
+			deadline := time.Now().Add(time.Second)
+			for {
+				stats := pool.Stats()
+				if stats.Acked+stats.Failed+stats.DeadLettered > 0 && stats.InFlight == 0 {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("input not settled: %s", stats)
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+			receiver.Pause()
+
+			stats := pool.Stats()
+			// a failed input is redelivered and may have failed again by now
+			if stats.Acked != tt.wantStats.Acked || (stats.Failed == 0) != (tt.wantStats.Failed == 0) ||
+				stats.DeadLettered != tt.wantStats.DeadLettered || stats.Published != tt.wantStats.Published ||
+				stats.Diverted != tt.wantStats.Diverted || (stats.Rejected == 0) != (tt.wantStats.Rejected == 0) {
+				t.Errorf("stats %s, want %s", stats, tt.wantStats)
+			}
+			if tt.wantStats.Acked+tt.wantStats.DeadLettered > 0 && (input.Pending() != 0 || input.Unacked() != 0) {
+				t.Errorf("settled input still on the queue: pending=%d unacked=%d", input.Pending(), input.Unacked())
+			}
+			if (len(input.Discarded()) > 0) != tt.wantDMQ {
+				t.Errorf("%d inputs moved to the DMQ", len(input.Discarded()))
+			}
+			if tt.wantStats.Diverted > 0 && deadLetters.Pending() != 1 {
+				t.Errorf("%d outputs on the dead-letter queue, want 1", deadLetters.Pending())
+			}
+			if tt.wantOutput == "" {
+				if output.Pending() != 0 {
+					t.Errorf("%d unexpected outputs", output.Pending())
+				}
+				return
+			}
+			outputReceiver, err := service.CreatePersistentMessageReceiverBuilder().Build(resource.QueueDurableExclusive("output"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := outputReceiver.Start(); err != nil {
+				t.Fatal(err)
+			}
+			defer outputReceiver.Terminate(0)
+			got, err := outputReceiver.ReceiveMessage(time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if body, _ := got.GetPayloadAsString(); body != tt.wantOutput {
+				t.Errorf("output %q, want %q", body, tt.wantOutput)
+			}
+		})
+	}
+}
+
+// start connects to broker and starts a pool on the input queue with handler,
+// its outputs published through a fake persistent publisher
+func start(t *testing.T, broker *fake.Broker, opts workers.Options, handler workers.Handler) (*fake.Service, *workers.Pool) {
+	t.Helper()
+	broker.CreateQueue("input", inputTopic)
+	service := broker.NewService()
+	if err := service.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { service.Disconnect() })
+	receiver, err := service.CreatePersistentMessageReceiverBuilder().
+		WithMessageClientAcknowledgement().
+		WithRequiredMessageOutcomeSupport(config.PersistentReceiverFailedOutcome).
+		Build(resource.QueueDurableExclusive("input"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := receiver.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { receiver.Terminate(0) })
+	publisher, err := service.CreatePersistentMessagePublisherBuilder().Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := workers.New(receiver, opts)
+	t.Cleanup(pool.Close)
+	publisher.SetMessagePublishReceiptListener(pool.ReceiptListener(nil))
+	if err := publisher.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { publisher.Terminate(0) })
+	if err := pool.Start(publisher, handler); err != nil {
+		t.Fatal(err)
+	}
+	return service, pool
+}
+
+// publishInputs publishes a text message per body to the input topic, with
+// the partition key of its index in keys when keys is not nil
+func publishInputs(t *testing.T, service *fake.Service, bodies []string, keys []string) {
+	t.Helper()
+	publisher, err := service.CreatePersistentMessagePublisherBuilder().Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := publisher.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer publisher.Terminate(0)
+	for i, body := range bodies {
+		builder := service.MessageBuilder()
+		if keys != nil {
+			builder = builder.WithProperty(config.QueuePartitionKey, keys[i])
+		}
+		msg, _ := builder.BuildWithStringPayload(body)
+		if err := publisher.PublishAwaitAcknowledgement(msg, resource.TopicOf(inputTopic), time.Second, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPoolOrdersByKey(t *testing.T) {
+	var mu sync.Mutex
+	handled := map[string][]string{}
+	broker := fake.NewBroker()
+	service, pool := start(t, broker, workers.Options{Workers: 4, Key: workers.ByPartitionKey()},
+		func(msg message.InboundMessage) ([]workers.Output, error) {
+			key, _ := msg.GetProperty(config.QueuePartitionKey)
+			body, _ := msg.GetPayloadAsString()
+			// give the other workers a chance to overtake
+			time.Sleep(time.Duration(len(body)%3) * time.Millisecond)
+			mu.Lock()
+			defer mu.Unlock()
+			handled[key.(string)] = append(handled[key.(string)], body)
+			return nil, nil
+		})
+
+	var bodies, keys []string
+	for i := 0; i < 30; i++ {
+		keys = append(keys, fmt.Sprintf("key-%d", i%3))
+		bodies = append(bodies, fmt.Sprint(i))
+	}
+	publishInputs(t, service, bodies, keys)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	waitAcked(t, pool, 30)
+	if err := pool.Drain(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for k := 0; k < 3; k++ {
+		key := fmt.Sprintf("key-%d", k)
+		var want []string
+		for i := k; i < 30; i += 3 {
+			want = append(want, fmt.Sprint(i))
+		}
+		if !slices.Equal(handled[key], want) {
+			t.Errorf("%s handled %v, want %v", key, handled[key], want)
+		}
+	}
+}
+
+func TestPoolBoundsInFlight(t *testing.T) {
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var calls int
+	broker := fake.NewBroker()
+	service, pool := start(t, broker, workers.Options{Workers: 2, MaxInFlight: 3},
+		func(msg message.InboundMessage) ([]workers.Output, error) {
+			mu.Lock()
+			calls++
+			mu.Unlock()
+			<-release
+			return nil, nil
+		})
+	publishInputs(t, service, []string{"1", "2", "3", "4", "5", "6"}, nil)
+
+	time.Sleep(100 * time.Millisecond)
+	if stats := pool.Stats(); stats.InFlight != 3 {
+		t.Errorf("in flight %d, want the bound of 3", stats.InFlight)
+	}
+	mu.Lock()
+	if calls != 2 {
+		t.Errorf("handler called %d times with 2 blocked workers", calls)
+	}
+	mu.Unlock()
+
+	// Drain gives up while the handlers are blocked
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := pool.Drain(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Drain() = %v, want a deadline error", err)
+	}
+
+	close(release)
+	waitAcked(t, pool, 6)
+	if err := pool.Drain(context.Background()); err != nil {
+		t.Errorf("Drain() = %v after every input was acked", err)
+	}
+}
+
+// waitAcked waits a second until pool acked n inputs
+func waitAcked(t *testing.T, pool *workers.Pool, n uint64) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for pool.Stats().Acked < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("stats %s, want %d acked", pool.Stats(), n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestByProperty(t *testing.T) {
+	tests := []struct {
+		name    string
+		props   map[string]interface{}
+		wantKey string
+		wantOK  bool
+	}{
+		{"string", map[string]interface{}{"region": "emea"}, "emea", true},
+		{"number", map[string]interface{}{"region": int64(7)}, "7", true},
+		{"empty", map[string]interface{}{"region": ""}, "", false},
+		{"missing", map[string]interface{}{"other": "emea"}, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := fake.NewInboundMessage(inputTopic, "Hello", tt.props)
+			key, ok := workers.ByProperty("region")(msg)
+			if key != tt.wantKey || ok != tt.wantOK {
+				t.Errorf("ByProperty() = %q, %v, want %q, %v", key, ok, tt.wantKey, tt.wantOK)
+			}
+		})
+	}
+}
@@ -1,151 +1,104 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
-	"os/signal"
-	"strings"
 	"time"
 
-	"solace.dev/go/messaging"
-	"solace.dev/go/messaging/pkg/solace"
-	"solace.dev/go/messaging/pkg/solace/config"
-	"solace.dev/go/messaging/pkg/solace/message"
-	"solace.dev/go/messaging/pkg/solace/resource"
+	"SolaceSamples.com/PubSub+Go/internal/bootstrap"
+	"SolaceSamples.com/PubSub+Go/internal/connstate"
+	"SolaceSamples.com/PubSub+Go/internal/health"
+	"SolaceSamples.com/PubSub+Go/internal/lifecycle"
+	"SolaceSamples.com/PubSub+Go/internal/metrics"
+	"SolaceSamples.com/PubSub+Go/internal/pipeline"
+	"SolaceSamples.com/PubSub+Go/internal/samples"
 )
 
-func ReconnectionHandler(e solace.ServiceEvent) {
-	e.GetTimestamp()
-	e.GetBrokerURI()
-	err := e.GetCause()
-	if err != nil {
-		fmt.Println(err)
-	}
-}
-
-func getEnv(key, def string) string {
-	if val, ok := os.LookupEnv(key); ok {
-		return val
-	}
-	return def
-}
-
 // Define Topic Prefix
 const TopicPrefix = "solace/samples"
 
-func main() {
-
-	// Configuration parameters
-	brokerConfig := config.ServicePropertyMap{
-		config.TransportLayerPropertyHost:                getEnv("SOLACE_HOST", "tcp://localhost:55555,tcp://localhost:55554"),
-		config.ServicePropertyVPNName:                    getEnv("SOLACE_VPN", "default"),
-		config.AuthenticationPropertySchemeBasicPassword: getEnv("SOLACE_PASSWORD", "default"),
-		config.AuthenticationPropertySchemeBasicUserName: getEnv("SOLACE_USERNAME", "default"),
-	}
+// Uppercase is the processing stage
+// For example, change the body of the message to uppercased
+func Uppercase(messageBody string) (string, error) {
+	processedMsg, err := samples.Uppercase(messageBody)
+	fmt.Printf("Received a message: %s, uppercasing to %s\n", messageBody, processedMsg)
+	return processedMsg, err
+}
 
-	messagingService, err := messaging.NewMessagingServiceBuilder().FromConfigurationProvider(brokerConfig).Build()
+func main() {
 
+	// Load the broker settings from flags, environment, profile file or defaults
+	brokerConfig, err := bootstrap.Load()
 	if err != nil {
 		panic(err)
 	}
 
-	messagingService.AddReconnectionListener(ReconnectionHandler)
-
-	// Connect to the messaging serice
-	if err := messagingService.Connect(); err != nil {
+	// Connect to the messaging service
+	messagingService, err := bootstrap.Connect(brokerConfig)
+	if err != nil {
 		panic(err)
 	}
 
 	fmt.Println("Connected to the broker? ", messagingService.IsConnected())
 
-	// Define Topic Subscriptions
-	subscriptionTopic := resource.TopicSubscriptionOf(TopicPrefix + "/direct/processor/input")
-
-	// Build a Direct message receivers with given topics
-	directReceiver, err := messagingService.CreateDirectMessageReceiverBuilder().
-		WithSubscriptions(subscriptionTopic).
-		Build()
-
-	fmt.Println("Subscribed to: ", subscriptionTopic.GetName())
-
+	// Count messages and connection events, served as Prometheus metrics on -metrics-addr when given
+	sampleMetrics, err := metrics.Enable(messagingService)
 	if err != nil {
 		panic(err)
 	}
 
-	// Start Direct Message Receiver
-	if err := directReceiver.Start(); err != nil {
+	// Follow the connection state, logged as structured records and served with the metrics
+	connectionState := connstate.Track(messagingService)
+	sampleMetrics.Registry().MustRegister(connectionState.Collector())
+
+	// Serve /readyz from the service, receiver and publisher state, and /healthz from a watchdog
+	// that catches a stage stuck for longer than 30 seconds, on -health-addr when given
+	healthChecks, err := health.Enable()
+	if err != nil {
 		panic(err)
 	}
-
-	fmt.Println("Direct Receiver running? ", directReceiver.IsRunning())
-
-	// Build a direct publish to use for publishing the processed message
-	directPublisher, builderErr := messagingService.CreateDirectMessagePublisherBuilder().Build()
-	if builderErr != nil {
-		panic(builderErr)
+	healthChecks.AddService("messaging-service", messagingService)
+	healthChecks.AddCheck("connection-state", connectionState.Check)
+	stageWatchdog := healthChecks.Watchdog("processing-stage", 30*time.Second)
+	watchedUppercase := func(messageBody string) (string, error) {
+		defer stageWatchdog.Begin()()
+		return Uppercase(messageBody)
 	}
 
-	startErr := directPublisher.Start()
-	if startErr != nil {
-		panic(startErr)
-	}
-	messageBuilder := messagingService.MessageBuilder()
-
-	// Message Handler
-	var messageHandler solace.MessageHandler = func(message message.InboundMessage) {
-		var messageBody string
-		if payload, ok := message.GetPayloadAsString(); ok {
-			messageBody = payload
-		} else if payload, ok := message.GetPayloadAsBytes(); ok {
-			messageBody = string(payload)
-		}
-
-		receivedTopic := message.GetDestinationName()
-		// Generate processed topic
-		slice := strings.Split(receivedTopic, "/")
-		processedTopic := strings.Join(slice[:len(slice)-1][:], "/") + "/output"
-
-		// Process the message
-		// For example, change the body of the message to uppercased
-		processedMsg := strings.ToUpper(messageBody)
-		fmt.Printf("Received a message: %s on topic %s\n", messageBody, receivedTopic)
-		fmt.Printf("Uppercasing to %s and publishing on %s\n\n", processedMsg, processedTopic)
-
-		outMessage, err := messageBuilder.BuildWithStringPayload(processedMsg)
-		if err != nil {
-			panic(err)
-		}
-
-		// Publish on dynamic topic with dynamic body
-		publishErr := directPublisher.Publish(outMessage, resource.TopicOf(processedTopic))
-		if publishErr != nil {
-			panic(publishErr)
-		}
+	// Declare the processor: consume the input topic, uppercase every message and publish
+	// it next to the input on the output topic; messages that fail go to the failure topic
+	processor := pipeline.Pipeline[string, string]{
+		Source:       pipeline.Subscription(TopicPrefix + "/direct/processor/input"),
+		Decode:       pipeline.DecodeString,
+		Stage:        pipeline.Map(watchedUppercase),
+		Encode:       pipeline.EncodeString,
+		Sink:         pipeline.ToSibling("output"),
+		FailureTopic: TopicPrefix + "/direct/processor/failed",
 	}
 
-	// Register Message callback handler to the Message Receiver
-	if regErr := directReceiver.ReceiveAsync(messageHandler); regErr != nil {
-		panic(regErr)
+	// Build and start the Direct Message Receiver and Publisher of the processor
+	running, err := processor.Start(messagingService, pipeline.WithInstrumentation(sampleMetrics))
+	if err != nil {
+		panic(err)
 	}
 
+	// Stop the Direct Receiver and Publisher and disconnect on SIGINT or SIGTERM
+	runner := lifecycle.NewRunner(messagingService, lifecycle.WithGracePeriod(1*time.Second))
+	runner.Manage(lifecycle.TerminateFunc(running.Stop))
+	healthChecks.AddReceiver("direct-receiver", running)
+	healthChecks.AddPublisher("direct-publisher", running)
+
+	fmt.Println("Processing: ", processor)
+
 	fmt.Println("\n===Interrupt (CTR+C) to handle graceful termination of the receiver===\n")
 
-	// cleanup after the main calling function has finished execution
-	defer func() {
-		// Terminate the Direct Receiver
-		directReceiver.Terminate(1 * time.Second)
-		fmt.Println("\nDirect Receiver Terminated? ", directReceiver.IsTerminated())
-		// Terminate the Direct Publisher
-		directPublisher.Terminate(1 * time.Second)
-		fmt.Println("\nDirect Publisher Terminated? ", directPublisher.IsTerminated())
-		// Disconnect the Message Service
-		messagingService.Disconnect()
-		fmt.Println("Messaging Service Disconnected? ", !messagingService.IsConnected())
-	}()
-
-	// Run forever until an interrupt signal is received
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
-	// Block until a interrupt signal is received.
-	<-c
+	// Block until an OS interrupt signal is received, then shut down
+	exitCode := runner.Run(context.Background())
+	healthChecks.Close()
+
+	fmt.Println("\nProcessing outcomes: ", running.Stats())
+	fmt.Println("Messaging Service Disconnected? ", !messagingService.IsConnected())
+
+	os.Exit(exitCode)
 }
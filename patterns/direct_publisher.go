@@ -1,57 +1,83 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"os"
-	"os/signal"
 	"strconv"
 	"time"
 
-	"solace.dev/go/messaging"
-	"solace.dev/go/messaging/pkg/solace/config"
-	"solace.dev/go/messaging/pkg/solace/resource"
+	"github.com/cloudevents/sdk-go/v2/event"
+
+	"SolaceSamples.com/PubSub+Go/internal/bootstrap"
+	"SolaceSamples.com/PubSub+Go/internal/cloudevents"
+	"SolaceSamples.com/PubSub+Go/internal/codec"
+	"SolaceSamples.com/PubSub+Go/internal/flow"
+	"SolaceSamples.com/PubSub+Go/internal/health"
+	"SolaceSamples.com/PubSub+Go/internal/lifecycle"
+	"SolaceSamples.com/PubSub+Go/internal/metrics"
+	"SolaceSamples.com/PubSub+Go/internal/samples"
+	"SolaceSamples.com/PubSub+Go/internal/seal"
+	"SolaceSamples.com/PubSub+Go/internal/topics"
 )
 
-func getEnv(key, def string) string {
-	if val, ok := os.LookupEnv(key); ok {
-		return val
-	}
-	return def
-}
-
 // Define Topic Prefix
 const TopicPrefix = "solace/samples"
 
-func main() {
-	// logging.SetLogLevel(logging.LogLevelInfo)
+// Topic hierarchy of the published messages, one topic per sequence number
+var PublishTopic = topics.MustNew(TopicPrefix + "/{language}/direct/publisher/{seq}")
 
-	// Configuration parameters
-	brokerConfig := config.ServicePropertyMap{
-		config.TransportLayerPropertyHost:                getEnv("SOLACE_HOST", "tcp://localhost:55555,tcp://localhost:55554"),
-		config.ServicePropertyVPNName:                    getEnv("SOLACE_VPN", "default"),
-		config.AuthenticationPropertySchemeBasicPassword: getEnv("SOLACE_PASSWORD", "default"),
-		config.AuthenticationPropertySchemeBasicUserName: getEnv("SOLACE_USERNAME", "default"),
-	}
+// Publish settings, parsed together with the broker flags
+var (
+	publishRate = flag.Float64("rate", 1, "messages per second to publish, 0 for as fast as possible")
+	publishJSON = flag.Bool("json", false, "publish a JSON encoded Greeting instead of a text payload")
+	eventMode   = flag.String("cloudevents", "", "publish each Greeting as a CloudEvent in binary or structured mode")
+)
+
+// CloudEvents attributes of the published Greetings
+const (
+	EventType   = "com.solace.samples.greeting"
+	EventSource = "/solace/samples/go/direct/publisher"
+)
 
-	messagingService, err := messaging.NewMessagingServiceBuilder().FromConfigurationProvider(brokerConfig).Build()
+func main() {
+	// logging.SetLogLevel(logging.LogLevelInfo)
 
+	// Load the broker settings from flags, environment, profile file or defaults
+	brokerConfig, err := bootstrap.Load()
 	if err != nil {
 		panic(err)
 	}
 
-	// Connect to the messaging serice
-	if err := messagingService.Connect(); err != nil {
+	// Connect to the messaging service
+	messagingService, err := bootstrap.Connect(brokerConfig)
+	if err != nil {
 		panic(err)
 	}
 
 	fmt.Println("Connected to the broker? ", messagingService.IsConnected())
 
+	// Count messages and connection events, served as Prometheus metrics on -metrics-addr when given
+	sampleMetrics, err := metrics.Enable(messagingService)
+	if err != nil {
+		panic(err)
+	}
+
 	//  Build a Direct Message Publisher
-	directPublisher, builderErr := messagingService.CreateDirectMessagePublisherBuilder().Build()
+	//  Reject instead of blocking when the buffer is full, so the flow control can drop the message
+	directPublisher, builderErr := messagingService.CreateDirectMessagePublisherBuilder().OnBackPressureReject(1000).Build()
 	if builderErr != nil {
 		panic(builderErr)
 	}
 
+	// Compress and encrypt payloads when started with -compress or -keyring
+	payloadSealer, err := seal.Enable(messagingService)
+	if err != nil {
+		panic(err)
+	}
+	directPublisher = sampleMetrics.DirectPublisher(payloadSealer.DirectPublisher(directPublisher))
+
 	startErr := directPublisher.Start()
 	if startErr != nil {
 		panic(startErr)
@@ -68,42 +94,109 @@ func main() {
 	messageBuilder := messagingService.MessageBuilder().
 		WithProperty("application", "samples").
 		WithProperty("language", "go")
+	jsonPublisher := codec.Direct(directPublisher, messageBuilder)
+
+	// Publish CloudEvents with IDs unique to this run when -cloudevents is given
+	var cloudEventsMode cloudevents.Mode
+	if *eventMode != "" {
+		cloudEventsMode, err = cloudevents.ParseMode(*eventMode)
+		if err != nil {
+			panic(err)
+		}
+	}
+	runID := strconv.FormatInt(time.Now().UnixNano(), 36)
+
+	// Serve /readyz from the service and publisher state, and /healthz from a watchdog
+	// that catches a publish loop stuck for longer than 30 seconds, on -health-addr when given
+	healthChecks, err := health.Enable()
+	if err != nil {
+		panic(err)
+	}
+	healthChecks.AddService("messaging-service", messagingService)
+	healthChecks.AddPublisher("direct-publisher", directPublisher)
+	publishWatchdog := healthChecks.Watchdog("publish-loop", 30*time.Second)
+
+	// Terminate the publisher and disconnect once the publish loop has stopped
+	runner := lifecycle.NewRunner(messagingService, lifecycle.WithGracePeriod(1*time.Second))
+	runner.Manage(directPublisher)
+
+	// Pace publishing to the target rate, dropping messages the publisher has no room for
+	flowControl := flow.New(flow.Options{Rate: *publishRate, Policy: flow.Drop})
 
 	// Run forever until an interrupt signal is received
-	go func() {
-		for directPublisher.IsReady() {
+	runner.Go(func(ctx context.Context) error {
+		for ctx.Err() == nil {
+			publishWatchdog.Beat()
 			msgSeqNum++
-			message, err := messageBuilder.BuildWithStringPayload(messageBody + " --> " + strconv.Itoa(msgSeqNum))
+			topic, err := PublishTopic.Topic("go", strconv.Itoa(msgSeqNum))
 			if err != nil {
-				panic(err)
+				return err
 			}
 
-			topic := resource.TopicOf(TopicPrefix + "/go/direct/publisher/" + strconv.Itoa(msgSeqNum))
+			var publish func() error
+			if *eventMode != "" {
+				// A CloudEvent with the Greeting as JSON data, its attributes are ce-* user properties in binary mode
+				greeting := samples.Greeting{Text: messageBody, Sequence: msgSeqNum, Language: "go"}
+				e := event.New()
+				e.SetID(runID + "-" + strconv.Itoa(msgSeqNum))
+				e.SetType(EventType)
+				e.SetSource(EventSource)
+				e.SetSubject(topic.GetName())
+				e.SetTime(time.Now())
+				if err := e.SetData(event.ApplicationJSON, greeting); err != nil {
+					return err
+				}
+				message, err := cloudevents.Build(messageBuilder, e, cloudEventsMode)
+				if err != nil {
+					return err
+				}
+				publish = func() error {
+					return directPublisher.Publish(message, topic)
+				}
+			} else if *publishJSON {
+				// Encoded as JSON with the application/json content type
+				greeting := samples.Greeting{Text: messageBody, Sequence: msgSeqNum, Language: "go"}
+				publish = func() error {
+					return codec.Publish(jsonPublisher, topic, greeting)
+				}
+			} else {
+				message, err := messageBuilder.BuildWithStringPayload(messageBody + " --> " + strconv.Itoa(msgSeqNum))
+				if err != nil {
+					return err
+				}
+				publish = func() error {
+					return directPublisher.Publish(message, topic)
+				}
+			}
 
 			// Publish on dynamic topic with dynamic body
-			publishErr := directPublisher.Publish(message, topic)
+			publishErr := flowControl.Publish(ctx, publish)
 			if publishErr != nil {
-				panic(publishErr)
+				return publishErr
 			}
 
 			fmt.Println("Message Topic: ", topic.GetName())
 			// fmt.Printf("Published message: %s\n", message)
-			time.Sleep(1 * time.Second)
 		}
-	}()
-
-	// Handle OS interrupts
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
-
-	// Block until an OS interrupt signal is received.
-	<-c
-
-	// Terminate the Direct Receiver
-	directPublisher.Terminate(1 * time.Second)
-	fmt.Println("\nDirect Publisher Terminated? ", directPublisher.IsTerminated())
-	// Disconnect the Message Service
-	messagingService.Disconnect()
+		return nil
+	})
+
+	// Report the achieved publish rate
+	runner.Go(func(ctx context.Context) error {
+		flowControl.Report(ctx, 5*time.Second, func(stats flow.Stats) {
+			fmt.Println("Publish rate: ", stats)
+		})
+		return nil
+	})
+
+	// Block until an OS interrupt signal is received, then shut down
+	exitCode := runner.Run(context.Background())
+	healthChecks.Close()
+
+	fmt.Println("\nPublish rate: ", flowControl.Stats())
+	fmt.Println("Payload protection: ", payloadSealer.Stats())
+	fmt.Println("Direct Publisher Terminated? ", directPublisher.IsTerminated())
 	fmt.Println("Messaging Service Disconnected? ", !messagingService.IsConnected())
 
+	os.Exit(exitCode)
 }
@@ -1,46 +1,44 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
-	"os/signal"
 	"time"
 
-	"solace.dev/go/messaging"
-	"solace.dev/go/messaging/pkg/solace"
-	"solace.dev/go/messaging/pkg/solace/config"
 	"solace.dev/go/messaging/pkg/solace/message"
-	"solace.dev/go/messaging/pkg/solace/resource"
+
+	"SolaceSamples.com/PubSub+Go/internal/bootstrap"
+	"SolaceSamples.com/PubSub+Go/internal/connstate"
+	"SolaceSamples.com/PubSub+Go/internal/health"
+	"SolaceSamples.com/PubSub+Go/internal/lifecycle"
+	"SolaceSamples.com/PubSub+Go/internal/metrics"
+	"SolaceSamples.com/PubSub+Go/internal/router"
+	"SolaceSamples.com/PubSub+Go/internal/samples"
+	"SolaceSamples.com/PubSub+Go/internal/seal"
 )
 
 // Message Handler
+// Decodes CloudEvents, e.g. from the direct publisher started with -cloudevents,
+// and JSON payloads, e.g. from the direct publisher started with -json
 func MessageHandler(message message.InboundMessage) {
-	var messageBody string
-
-	if payload, ok := message.GetPayloadAsString(); ok {
-		messageBody = payload
-	} else if payload, ok := message.GetPayloadAsBytes(); ok {
-		messageBody = string(payload)
+	line, err := samples.Describe(message, nil)
+	if err != nil {
+		fmt.Println("Dropped message: ", err)
+		return
 	}
-
-	fmt.Printf("Received Message Body %s \n", messageBody)
-	// fmt.Printf("Message Dump %s \n", message)
+	fmt.Println(line)
 }
 
-func ReconnectionHandler(e solace.ServiceEvent) {
-	e.GetTimestamp()
-	e.GetBrokerURI()
-	err := e.GetCause()
-	if err != nil {
-		fmt.Println(err)
-	}
+// Handler of the messages sent by the direct publisher samples
+func DirectSubHandler(message message.InboundMessage) {
+	fmt.Printf("Received Direct Message on %s \n", message.GetDestinationName())
+	MessageHandler(message)
 }
 
-func getEnv(key, def string) string {
-	if val, ok := os.LookupEnv(key); ok {
-		return val
-	}
-	return def
+// Handler of the messages no route matches
+func UnroutedHandler(message message.InboundMessage) {
+	fmt.Printf("No route for message on %s \n", message.GetDestinationName())
 }
 
 // Define Topic Prefix
@@ -50,79 +48,93 @@ func main() {
 
 	// logging.SetLogLevel(logging.LogLevelInfo)
 
-	// Configuration parameters
-	brokerConfig := config.ServicePropertyMap{
-		config.TransportLayerPropertyHost:                getEnv("SOLACE_HOST", "tcp://localhost:55555,tcp://localhost:55554"),
-		config.ServicePropertyVPNName:                    getEnv("SOLACE_VPN", "default"),
-		config.AuthenticationPropertySchemeBasicPassword: getEnv("SOLACE_PASSWORD", "default"),
-		config.AuthenticationPropertySchemeBasicUserName: getEnv("SOLACE_USERNAME", "default"),
-	}
-
-	messagingService, err := messaging.NewMessagingServiceBuilder().FromConfigurationProvider(brokerConfig).Build()
-
+	// Load the broker settings from flags, environment, profile file or defaults
+	brokerConfig, err := bootstrap.Load()
 	if err != nil {
 		panic(err)
 	}
 
-	messagingService.AddReconnectionListener(ReconnectionHandler)
-
-	// Connect to the messaging serice
-	if err := messagingService.Connect(); err != nil {
+	// Connect to the messaging service
+	messagingService, err := bootstrap.Connect(brokerConfig)
+	if err != nil {
 		panic(err)
 	}
 
 	fmt.Println("Connected to the broker? ", messagingService.IsConnected())
 
-	// Define Topic Subscriptions
-	topics := [...]string{TopicPrefix + "/>", TopicPrefix + "/*/direct/sub"}
-	topicsSub := make([]resource.Subscription, len(topics))
-
-	// Create topic objects
-	for i, topicString := range topics {
-		topicsSub[i] = resource.TopicSubscriptionOf(topicString)
+	// Count messages and connection events, served as Prometheus metrics on -metrics-addr when given
+	sampleMetrics, err := metrics.Enable(messagingService)
+	if err != nil {
+		panic(err)
 	}
 
-	// Print out list of strings to subscribe to
-	for _, ts := range topicsSub {
-		fmt.Println("Subscribed to: ", ts.GetName())
-	}
+	// Follow the connection state, logged as structured records and served with the metrics
+	connectionState := connstate.Track(messagingService)
+	sampleMetrics.Registry().MustRegister(connectionState.Collector())
 
-	// Build a Direct message receivers with given topics
+	// Build a Direct message receiver, its subscriptions are added by the router
 	directReceiver, err := messagingService.CreateDirectMessageReceiverBuilder().
-		WithSubscriptions(topicsSub...).
 		Build()
 
 	if err != nil {
 		panic(err)
 	}
 
-	// Start Direct Message Receiver
-	if err := directReceiver.Start(); err != nil {
+	// Decrypt and decompress payloads sealed by a publisher started with -compress or -keyring
+	payloadSealer, err := seal.Enable(messagingService)
+	if err != nil {
 		panic(err)
 	}
+	payloadSealer.OnError(func(message message.InboundMessage, err error) {
+		fmt.Println("Dropped message: ", err)
+	})
+	directReceiver = sampleMetrics.DirectReceiver(payloadSealer.DirectReceiver(directReceiver))
+
+	// Serve /readyz from the service and receiver state, and /healthz from a watchdog
+	// that catches handlers stuck for longer than 30 seconds, on -health-addr when given
+	healthChecks, err := health.Enable()
+	if err != nil {
+		panic(err)
+	}
+	healthChecks.AddService("messaging-service", messagingService)
+	healthChecks.AddCheck("connection-state", connectionState.Check)
+	healthChecks.AddReceiver("direct-receiver", directReceiver)
+	handlerWatchdog := healthChecks.Watchdog("message-handlers", 30*time.Second)
+
+	// Route every message to the handler of the most specific matching subscription
+	messageRouter := router.New(directReceiver)
+	if err := messageRouter.Handle(TopicPrefix+"/>", handlerWatchdog.Handler(MessageHandler)); err != nil {
+		panic(err)
+	}
+	if err := messageRouter.Handle(TopicPrefix+"/*/direct/sub", handlerWatchdog.Handler(DirectSubHandler)); err != nil {
+		panic(err)
+	}
+	messageRouter.Fallback(handlerWatchdog.Handler(UnroutedHandler))
 
-	fmt.Println("Direct Receiver running? ", directReceiver.IsRunning())
+	// Print out list of strings to subscribe to
+	for _, subscription := range messageRouter.Routes() {
+		fmt.Println("Subscribed to: ", subscription)
+	}
 
-	// Register Message callback handler to the Message Receiver
-	if regErr := directReceiver.ReceiveAsync(MessageHandler); regErr != nil {
-		panic(regErr)
+	// Terminate the receiver and disconnect on SIGINT or SIGTERM
+	runner := lifecycle.NewRunner(messagingService, lifecycle.WithGracePeriod(1*time.Second))
+	runner.Manage(directReceiver)
+
+	// Start Direct Message Receiver, add the subscriptions and register the router as message handler
+	if err := messageRouter.Start(); err != nil {
+		panic(err)
 	}
 
+	fmt.Println("Direct Receiver running? ", directReceiver.IsRunning())
+
 	fmt.Println("\n===Interrupt (CTR+C) to handle graceful termination of the receiver===\n")
 
-	// cleanup after the main calling function has finished execution
-	defer func() {
-		// Terminate the Direct Receiver
-		directReceiver.Terminate(1 * time.Second)
-		fmt.Println("\nDirect Receiver Terminated? ", directReceiver.IsTerminated())
-		// Disconnect the Message Service
-		messagingService.Disconnect()
-		fmt.Println("Messaging Service Disconnected? ", !messagingService.IsConnected())
-	}()
-
-	// Run forever until an interrupt signal is received
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
-	// Block until a interrupt signal is received.
-	<-c
+	// Block until an OS interrupt signal is received, then shut down
+	exitCode := runner.Run(context.Background())
+	healthChecks.Close()
+
+	fmt.Println("\nDirect Receiver Terminated? ", directReceiver.IsTerminated())
+	fmt.Println("Messaging Service Disconnected? ", !messagingService.IsConnected())
+
+	os.Exit(exitCode)
 }
@@ -1,78 +1,90 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"os"
-	"os/signal"
-	"strings"
+	"runtime"
 	"time"
 
-	"solace.dev/go/messaging"
 	"solace.dev/go/messaging/pkg/solace"
 	"solace.dev/go/messaging/pkg/solace/config"
 	"solace.dev/go/messaging/pkg/solace/message"
 	"solace.dev/go/messaging/pkg/solace/resource"
-)
 
-func ReconnectionHandler(e solace.ServiceEvent) {
-	e.GetTimestamp()
-	e.GetBrokerURI()
-	err := e.GetCause()
-	if err != nil {
-		fmt.Println(err)
-	}
-}
+	"SolaceSamples.com/PubSub+Go/internal/bootstrap"
+	"SolaceSamples.com/PubSub+Go/internal/connstate"
+	"SolaceSamples.com/PubSub+Go/internal/health"
+	"SolaceSamples.com/PubSub+Go/internal/lifecycle"
+	"SolaceSamples.com/PubSub+Go/internal/metrics"
+	"SolaceSamples.com/PubSub+Go/internal/pipeline"
+	"SolaceSamples.com/PubSub+Go/internal/retry"
+	"SolaceSamples.com/PubSub+Go/internal/samples"
+	"SolaceSamples.com/PubSub+Go/internal/workers"
+)
 
 // Receipt Handler
+// Called once per message with its final outcome, after the retries and the
+// dead-letter topic have been tried, and after the input message has been settled
 func PublishReceiptListener(receipt solace.PublishReceipt) {
 	fmt.Println("Received a Publish Receipt from the broker\n")
 	// fmt.Println("IsPersisted: ", receipt.IsPersisted())
 	// fmt.Println("Message : ", receipt.GetMessage())
 	if receipt.GetError() != nil {
-		fmt.Println("Gauranteed Message is NOT persisted on the broker! Received NAK")
+		fmt.Println("Gauranteed Message was NOT persisted on the requested topic")
 		fmt.Println("Error is: ", receipt.GetError())
-		// probably want to do something here.  some error handling possibilities:
-		//  - send the message again
-		//  - send it somewhere else (error handling queue?)
-		//  - log and continue
-		//  - pause and retry (backoff) - maybe set a flag to slow down the publisher
 	}
 }
 
-func getEnv(key, def string) string {
-	if val, ok := os.LookupEnv(key); ok {
-		return val
-	}
-	return def
-}
-
 // Define Topic Prefix
 const TopicPrefix = "solace/samples"
 
+// Topic that receives processed messages NAKed on every retry
+const DeadLetterTopic = TopicPrefix + "/guaranteed/processor/dlq"
+
+// Topic that receives the input messages that could not be processed
+const FailureTopic = TopicPrefix + "/guaranteed/processor/failed"
+
+// Uppercase is the processing stage
+// For example, change the body of the message to uppercased
+func Uppercase(messageBody string) (string, error) {
+	processedMsg, err := samples.Uppercase(messageBody)
+	fmt.Printf("Received a message: %s, uppercasing to %s\n", messageBody, processedMsg)
+	return processedMsg, err
+}
+
+// Worker pool size and bound on unsettled messages, parsed together with the broker flags
+var (
+	workerCount = flag.Int("workers", runtime.NumCPU(), "number of goroutines processing messages")
+	maxInFlight = flag.Int("max-in-flight", 0, "messages processed or waiting for their output receipt at once, 0 for 16 per worker")
+)
+
 func main() {
 
-	// Configuration parameters
-	brokerConfig := config.ServicePropertyMap{
-		config.TransportLayerPropertyHost:                getEnv("SOLACE_HOST", "tcp://localhost:55555,tcp://localhost:55554"),
-		config.ServicePropertyVPNName:                    getEnv("SOLACE_VPN", "default"),
-		config.AuthenticationPropertySchemeBasicPassword: getEnv("SOLACE_PASSWORD", "default"),
-		config.AuthenticationPropertySchemeBasicUserName: getEnv("SOLACE_USERNAME", "default"),
+	// Load the broker settings from flags, environment, profile file or defaults
+	brokerConfig, err := bootstrap.Load()
+	if err != nil {
+		panic(err)
 	}
 
-	messagingService, err := messaging.NewMessagingServiceBuilder().FromConfigurationProvider(brokerConfig).Build()
-
+	// Connect to the messaging service
+	messagingService, err := bootstrap.Connect(brokerConfig)
 	if err != nil {
 		panic(err)
 	}
 
-	messagingService.AddReconnectionListener(ReconnectionHandler)
+	fmt.Println("Connected to the broker? ", messagingService.IsConnected())
 
-	// Connect to the messaging serice
-	if err := messagingService.Connect(); err != nil {
+	// Count messages and connection events, served as Prometheus metrics on -metrics-addr when given
+	sampleMetrics, err := metrics.Enable(messagingService)
+	if err != nil {
 		panic(err)
 	}
 
-	fmt.Println("Connected to the broker? ", messagingService.IsConnected())
+	// Follow the connection state, logged as structured records and served with the metrics
+	connectionState := connstate.Track(messagingService)
+	sampleMetrics.Registry().MustRegister(connectionState.Collector())
 
 	// Define Queue Topic Subscriptions
 	// Note: assuming client has authorization to add subscriptions to queues
@@ -87,10 +99,17 @@ func main() {
 	// 2. Bind to the given queue, create if doesnt exist
 	// 3. Add subscription to queue, assuming client has authorization to add subscriptions to queues
 	strategy := config.MissingResourcesCreationStrategy("CREATE_ON_START")
-	persistentReceiver, err := messagingService.CreatePersistentMessageReceiverBuilder().WithMissingResourcesCreationStrategy(strategy).WithSubscriptions(queueSubscription).Build(nonDurableExclusiveQueue)
+	// 4. Acknowledge each message only once its processed output has been persisted, and settle it as failed otherwise
+	persistentReceiver, err := messagingService.CreatePersistentMessageReceiverBuilder().
+		WithMissingResourcesCreationStrategy(strategy).
+		WithSubscriptions(queueSubscription).
+		WithMessageClientAcknowledgement().
+		WithRequiredMessageOutcomeSupport(config.PersistentReceiverFailedOutcome).
+		Build(nonDurableExclusiveQueue)
 	if err != nil {
 		panic(err)
 	}
+	persistentReceiver = sampleMetrics.PersistentReceiver(persistentReceiver)
 	fmt.Printf("Bound to queue: %s, and added subscription: %s\n", queueName, queueSubscription.GetName())
 
 	// Handling a panic from a non existing queue
@@ -112,9 +131,20 @@ func main() {
 	if builderErr != nil {
 		panic(builderErr)
 	}
+	persistentPublisher = sampleMetrics.PersistentPublisher(persistentPublisher)
+
+	retryPolicy := retry.DefaultPolicy()
+	retryPolicy.DeadLetterTopic = DeadLetterTopic
+	// Process messages on a pool of workers, keeping the order of messages that share a queue partition key
+	pool := workers.New(persistentReceiver, workers.Options{
+		Workers:     *workerCount,
+		MaxInFlight: *maxInFlight,
+		Key:         workers.ByPartitionKey(),
+	})
 
-	// Set the message publisher receipt listener
-	persistentPublisher.SetMessagePublishReceiptListener(PublishReceiptListener)
+	// Retry NAKed messages with backoff, then divert them to the dead-letter topic
+	// The pool sees the final receipt of every output and settles its input message
+	retryPublisher := retry.NewPublisher(persistentPublisher, retryPolicy, pool.ReceiptListener(PublishReceiptListener))
 
 	startErr := persistentPublisher.Start()
 	if startErr != nil {
@@ -123,66 +153,69 @@ func main() {
 
 	fmt.Println("Persistent Publisher running? ", persistentPublisher.IsRunning())
 
-	messageBuilder := messagingService.MessageBuilder()
-
-	// Message Handler
-	var messageHandler solace.MessageHandler = func(message message.InboundMessage) {
-		var messageBody string
-		if payload, ok := message.GetPayloadAsString(); ok {
-			messageBody = payload
-		} else if payload, ok := message.GetPayloadAsBytes(); ok {
-			messageBody = string(payload)
-		}
-
-		receivedTopic := message.GetDestinationName()
-		// Generate processed topic
-		slice := strings.Split(receivedTopic, "/")
-		processedTopic := strings.Join(slice[:len(slice)-1][:], "/") + "/output"
-
-		// Process the message
-		// For example, change the body of the message to uppercased
-		processedMsg := strings.ToUpper(messageBody)
-		fmt.Printf("Received a message: %s on topic %s\n", messageBody, receivedTopic)
-		fmt.Printf("Uppercasing to %s and publishing on %s\n", processedMsg, processedTopic)
-
-		outMessage, err := messageBuilder.BuildWithStringPayload(processedMsg)
-		if err != nil {
-			panic(err)
-		}
-
-		// Publish on process topic with processed body
-		publishErr := persistentPublisher.Publish(outMessage, resource.TopicOf(processedTopic), nil, nil)
-		// Block until message is acknowledged
-		// publishErr := persistentPublisher.PublishAwaitAcknowledgement(message, topic, 2*time.Second, nil)
+	// Declare the processing: uppercase every message and publish it next to the input on the
+	// output topic; messages that fail are published to the failure topic instead
+	// The pool's workers call the processor's handler, which returns the messages to publish
+	processor := pipeline.Pipeline[string, string]{
+		Decode:       pipeline.DecodeString,
+		Stage:        pipeline.Map(Uppercase),
+		Encode:       pipeline.EncodeString,
+		Sink:         pipeline.ToSibling("output"),
+		FailureTopic: FailureTopic,
+	}
+	messageHandler := processor.Handler(messagingService)
 
-		if publishErr != nil {
-			panic(publishErr)
-		}
+	// Serve /readyz from the service, receiver and publisher state, and /healthz from a watchdog
+	// that catches a worker stuck on a message for longer than 30 seconds, on -health-addr when given
+	healthChecks, err := health.Enable()
+	if err != nil {
+		panic(err)
+	}
+	healthChecks.AddService("messaging-service", messagingService)
+	healthChecks.AddCheck("connection-state", connectionState.Check)
+	healthChecks.AddReceiver("persistent-receiver", persistentReceiver)
+	healthChecks.AddPublisher("persistent-publisher", persistentPublisher)
+	handlerWatchdog := healthChecks.Watchdog("workers", 30*time.Second)
+	watchedHandler := func(msg message.InboundMessage) ([]workers.Output, error) {
+		defer handlerWatchdog.Begin()()
+		return messageHandler.Handle(msg)
 	}
 
-	// Register Message callback handler to the Message Receiver
-	if regErr := persistentReceiver.ReceiveAsync(messageHandler); regErr != nil {
+	// On SIGINT or SIGTERM stop the delivery of new messages and let the workers settle the ones
+	// they have, which includes waiting for the retries of their outputs, then terminate the
+	// Persistent Receiver, unsettled messages are redelivered on the next start, and the Publisher
+	runner := lifecycle.NewRunner(messagingService, lifecycle.WithGracePeriod(retryPolicy.MaxRetryDelay()+5*time.Second))
+	runner.Go(func(ctx context.Context) error {
+		<-ctx.Done()
+		return persistentReceiver.Pause()
+	})
+	runner.Drain(pool)
+	runner.Drain(retryPublisher)
+	runner.Manage(persistentPublisher)
+	runner.Manage(lifecycle.TerminateFunc(func(time.Duration) error {
+		pool.Close()
+		return nil
+	}))
+	runner.Manage(persistentReceiver)
+
+	// Start the workers and register them as the Message Receiver callback
+	if regErr := pool.Start(retryPublisher, watchedHandler); regErr != nil {
 		panic(regErr)
 	}
+	fmt.Printf("Processing on %d workers\n", *workerCount)
 
 	fmt.Println("\n===Interrupt (CTR+C) to handle graceful termination of the receiver===\n")
 
-	// cleanup after the main calling function has finished execution
-	defer func() {
-		// Terminate the Persistent Receiver
-		persistentReceiver.Terminate(1 * time.Second)
-		fmt.Println("\nPersistent Receiver Terminated? ", persistentReceiver.IsTerminated())
-		// Terminate the Persistent Publisher
-		persistentPublisher.Terminate(1 * time.Second)
-		fmt.Println("Persistent Publisher Terminated? ", persistentPublisher.IsTerminated())
-		// Disconnect the Message Service
-		messagingService.Disconnect()
-		fmt.Println("Messaging Service Disconnected? ", !messagingService.IsConnected())
-	}()
+	// Block until an OS interrupt signal is received, then shut down
+	exitCode := runner.Run(context.Background())
+	healthChecks.Close()
+
+	fmt.Println("\nPersistent Receiver Terminated? ", persistentReceiver.IsTerminated())
+	fmt.Println("Processing outcomes: ", pool.Stats())
+	fmt.Println("Pipeline outcomes: ", messageHandler.Stats())
+	fmt.Println("Publish outcomes: ", retryPublisher.Stats())
+	fmt.Println("Persistent Publisher Terminated? ", persistentPublisher.IsTerminated())
+	fmt.Println("Messaging Service Disconnected? ", !messagingService.IsConnected())
 
-	// Run forever until an interrupt signal is received
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
-	// Block until a interrupt signal is received.
-	<-c
+	os.Exit(exitCode)
 }
@@ -1,76 +1,96 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"os"
-	"os/signal"
 	"strconv"
 	"time"
 
-	"solace.dev/go/messaging"
 	"solace.dev/go/messaging/pkg/solace"
-	"solace.dev/go/messaging/pkg/solace/config"
+	"solace.dev/go/messaging/pkg/solace/message"
 	"solace.dev/go/messaging/pkg/solace/resource"
-)
 
-func getEnv(key, def string) string {
-	if val, ok := os.LookupEnv(key); ok {
-		return val
-	}
-	return def
-}
+	"SolaceSamples.com/PubSub+Go/internal/bootstrap"
+	"SolaceSamples.com/PubSub+Go/internal/codec"
+	"SolaceSamples.com/PubSub+Go/internal/flow"
+	"SolaceSamples.com/PubSub+Go/internal/health"
+	"SolaceSamples.com/PubSub+Go/internal/lifecycle"
+	"SolaceSamples.com/PubSub+Go/internal/metrics"
+	"SolaceSamples.com/PubSub+Go/internal/retry"
+	"SolaceSamples.com/PubSub+Go/internal/samples"
+	"SolaceSamples.com/PubSub+Go/internal/schema"
+	"SolaceSamples.com/PubSub+Go/internal/seal"
+)
 
 // Receipt Handler
+// Called once per message with its final outcome, after the retries and the
+// dead-letter topic have been tried
 func PublishReceiptListener(receipt solace.PublishReceipt) {
 	// fmt.Println("Received a Publish Receipt from the broker\n")
 	// fmt.Println("IsPersisted: ", receipt.IsPersisted())
 	// fmt.Println("Message : ", receipt.GetMessage())
 	if receipt.GetError() != nil {
-		fmt.Println("Gauranteed Message is NOT persisted on the broker! Received NAK")
+		fmt.Println("Gauranteed Message was NOT persisted on the requested topic")
 		fmt.Println("Error is: ", receipt.GetError())
-		// probably want to do something here.  some error handling possibilities:
-		//  - send the message again
-		//  - send it somewhere else (error handling queue?)
-		//  - log and continue
-		//  - pause and retry (backoff) - maybe set a flag to slow down the publisher
 	}
 }
 
 // Define Topic Prefix
 const TopicPrefix = "solace/samples"
 
-func main() {
-	// logging.SetLogLevel(logging.LogLevelInfo)
+// Topic that receives messages NAKed on every retry
+const DeadLetterTopic = TopicPrefix + "/persistent/publisher/dlq"
 
-	// Configuration parameters
-	brokerConfig := config.ServicePropertyMap{
-		config.TransportLayerPropertyHost:                getEnv("SOLACE_HOST", "tcp://localhost:55555,tcp://localhost:55554"),
-		config.ServicePropertyVPNName:                    getEnv("SOLACE_VPN", "default"),
-		config.AuthenticationPropertySchemeBasicPassword: getEnv("SOLACE_PASSWORD", "default"),
-		config.AuthenticationPropertySchemeBasicUserName: getEnv("SOLACE_USERNAME", "default"),
-	}
+// Publish settings, parsed together with the broker flags
+var (
+	publishRate  = flag.Float64("rate", 1, "target messages per second, lowered while acknowledgements lag")
+	publishJSON  = flag.Bool("json", false, "publish a JSON encoded Greeting instead of a text payload")
+	registryFile = flag.String("schema-registry", "", "file of a local schema registry, publish Avro encoded Greetings registered there")
+)
 
-	messagingService, err := messaging.NewMessagingServiceBuilder().FromConfigurationProvider(brokerConfig).Build()
+func main() {
+	// logging.SetLogLevel(logging.LogLevelInfo)
 
+	// Load the broker settings from flags, environment, profile file or defaults
+	brokerConfig, err := bootstrap.Load()
 	if err != nil {
 		panic(err)
 	}
 
 	// Connect to the messaging service
-	if err := messagingService.Connect(); err != nil {
+	messagingService, err := bootstrap.Connect(brokerConfig)
+	if err != nil {
 		panic(err)
 	}
 
 	fmt.Println("Connected to the broker? ", messagingService.IsConnected())
 
+	// Count messages and connection events, served as Prometheus metrics on -metrics-addr when given
+	sampleMetrics, err := metrics.Enable(messagingService)
+	if err != nil {
+		panic(err)
+	}
+
 	//  Build a Persistent Message Publisher
-	persistentPublisher, builderErr := messagingService.CreatePersistentMessagePublisherBuilder().Build()
+	//  Reject instead of blocking when the buffer is full, so the flow control can back off and retry
+	persistentPublisher, builderErr := messagingService.CreatePersistentMessagePublisherBuilder().OnBackPressureReject(1000).Build()
 	if builderErr != nil {
 		panic(builderErr)
 	}
 
-	// Set the message publisher receipt listener
-	persistentPublisher.SetMessagePublishReceiptListener(PublishReceiptListener)
+	// Compress and encrypt payloads when started with -compress or -keyring
+	payloadSealer, err := seal.Enable(messagingService)
+	if err != nil {
+		panic(err)
+	}
+	persistentPublisher = sampleMetrics.PersistentPublisher(payloadSealer.PersistentPublisher(persistentPublisher))
+
+	// Retry NAKed messages with backoff, then divert them to the dead-letter topic
+	retryPolicy := retry.DefaultPolicy()
+	retryPolicy.DeadLetterTopic = DeadLetterTopic
+	retryPublisher := retry.NewPublisher(persistentPublisher, retryPolicy, PublishReceiptListener)
 
 	startErr := persistentPublisher.Start()
 	if startErr != nil {
@@ -82,6 +102,9 @@ func main() {
 	fmt.Println("\n===Interrupt (CTR+C) to stop publishing===\n")
 
 	msgSeqNum := 0
+	// Messages are identified by the run and their sequence number, so the receiver can skip the duplicates of retries
+	runID := samples.NewRunID()
+	fmt.Println("Publishing run: ", runID)
 
 	//  Prepare outbound message payload and body
 	messageBody := "Hello from Go Persistent Publisher Sample"
@@ -89,45 +112,105 @@ func main() {
 		WithProperty("application", "samples").
 		WithProperty("language", "go")
 
+	// Register the Greeting schema, refused when it is incompatible with the version registered before
+	var greetingCodec *codec.Avro[samples.Greeting]
+	if *registryFile != "" {
+		registry, err := schema.OpenFile(*registryFile)
+		if err != nil {
+			panic(err)
+		}
+		greetingCodec, err = codec.NewAvro[samples.Greeting](registry, samples.GreetingSubject, samples.GreetingSchema)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Println("Publishing Avro with schema: ", greetingCodec.Schema())
+	}
+
 	topic := resource.TopicOf(TopicPrefix + "/persistent/publisher")
 	fmt.Printf("Publishing on: %s, please ensure queue has matching subscription.\n", topic.GetName())
 
+	// Serve /readyz from the service and publisher state, and /healthz from a watchdog
+	// that catches a publish loop stuck for longer than 30 seconds, on -health-addr when given
+	healthChecks, err := health.Enable()
+	if err != nil {
+		panic(err)
+	}
+	healthChecks.AddService("messaging-service", messagingService)
+	healthChecks.AddPublisher("persistent-publisher", persistentPublisher)
+	publishWatchdog := healthChecks.Watchdog("publish-loop", 30*time.Second)
+
+	// Wait for outstanding receipts and retries before terminating the publisher and disconnecting,
+	// long enough for a message NAKed just before the shutdown to use up its retries, plus a second
+	// for the receipts of its last attempt and of the dead-letter topic
+	runner := lifecycle.NewRunner(messagingService, lifecycle.WithGracePeriod(retryPolicy.MaxRetryDelay()+1*time.Second))
+	runner.Manage(persistentPublisher)
+	runner.Drain(retryPublisher)
+
+	// Pace publishing to the target rate, slowing down while acknowledgements lag
+	flowControl := flow.New(flow.Options{
+		Rate:   *publishRate,
+		Policy: flow.Block,
+		Lag:    retryPublisher.Outstanding,
+	})
+
 	// Run forever until an interrupt signal is received
-	go func() {
-		for persistentPublisher.IsReady() {
-			message, err := messageBuilder.BuildWithStringPayload(messageBody + " --> " + strconv.Itoa(msgSeqNum))
+	runner.Go(func(ctx context.Context) error {
+		for ctx.Err() == nil {
+			publishWatchdog.Beat()
+			var (
+				message message.OutboundMessage
+				err     error
+			)
+			messageBuilder.WithApplicationMessageID(samples.MessageID(runID, msgSeqNum))
+			greeting := samples.Greeting{Text: messageBody, Sequence: msgSeqNum, Language: "go"}
+			if greetingCodec != nil {
+				// Encoded as Avro with the ID of its schema in the schema_id user property
+				message, err = greetingCodec.Encode(messageBuilder, greeting)
+			} else if *publishJSON {
+				// Encoded as JSON with the application/json content type
+				message, err = codec.Encode(messageBuilder, greeting)
+			} else {
+				message, err = messageBuilder.BuildWithStringPayload(messageBody + " --> " + strconv.Itoa(msgSeqNum))
+			}
 			if err != nil {
-				panic(err)
+				return err
 			}
 			// Publish on dynamic topic with dynamic body
 			// NOTE: publishing to topic, so make sure GuaranteedReceiver queue is subscribed to same topic,
 			//       or enable "Reject Message to Sender on No Subscription Match" the client-profile
-			publishErr := persistentPublisher.Publish(message, topic, nil, nil)
-			// print out the message sent
-			fmt.Printf("Published message with sequence number %d\n", msgSeqNum)
+			publishErr := flowControl.Publish(ctx, func() error {
+				return retryPublisher.Publish(message, topic, nil, msgSeqNum)
+			})
 			// Block until message is acknowledged
 			// publishErr := persistentPublisher.PublishAwaitAcknowledgement(message, topic, 2*time.Second, nil)
 
 			if publishErr != nil {
-				panic(publishErr)
+				return publishErr
 			}
-			time.Sleep(1 * time.Second)
+			// print out the message sent
+			fmt.Printf("Published message with sequence number %d\n", msgSeqNum)
 			msgSeqNum++
 		}
-	}()
-
-	// Handle OS interrupts
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
-
-	// Block until an OS interrupt signal is received.
-	<-c
-
-	// Terminate the Direct Receiver
-	persistentPublisher.Terminate(1 * time.Second)
-	fmt.Println("\nDirect Publisher Terminated? ", persistentPublisher.IsTerminated())
-	// Disconnect the Message Service
-	messagingService.Disconnect()
+		return nil
+	})
+
+	// Report the achieved publish rate
+	runner.Go(func(ctx context.Context) error {
+		flowControl.Report(ctx, 5*time.Second, func(stats flow.Stats) {
+			fmt.Println("Publish rate: ", stats)
+		})
+		return nil
+	})
+
+	// Block until an OS interrupt signal is received, then shut down
+	exitCode := runner.Run(context.Background())
+	healthChecks.Close()
+
+	fmt.Println("\nPersistent Publisher Terminated? ", persistentPublisher.IsTerminated())
+	fmt.Println("Publish outcomes: ", retryPublisher.Stats())
+	fmt.Println("Payload protection: ", payloadSealer.Stats())
+	fmt.Println("Publish rate: ", flowControl.Stats())
 	fmt.Println("Messaging Service Disconnected? ", !messagingService.IsConnected())
 
+	os.Exit(exitCode)
 }
@@ -1,66 +1,86 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"os"
-	"os/signal"
 	"time"
 
-	"solace.dev/go/messaging"
-	"solace.dev/go/messaging/pkg/solace"
 	"solace.dev/go/messaging/pkg/solace/config"
 	"solace.dev/go/messaging/pkg/solace/message"
 	"solace.dev/go/messaging/pkg/solace/resource"
+
+	"SolaceSamples.com/PubSub+Go/internal/bootstrap"
+	"SolaceSamples.com/PubSub+Go/internal/codec"
+	"SolaceSamples.com/PubSub+Go/internal/dedup"
+	"SolaceSamples.com/PubSub+Go/internal/health"
+	"SolaceSamples.com/PubSub+Go/internal/lifecycle"
+	"SolaceSamples.com/PubSub+Go/internal/metrics"
+	"SolaceSamples.com/PubSub+Go/internal/samples"
+	"SolaceSamples.com/PubSub+Go/internal/schema"
+	"SolaceSamples.com/PubSub+Go/internal/seal"
 )
 
 // Message Handler
-func MessageHandler(receiver solace.PersistentMessageReceiver, msg message.InboundMessage) {
-	var messageBody string
-
-	if payload, ok := msg.GetPayloadAsString(); ok {
-		messageBody = payload
-	} else if payload, ok := msg.GetPayloadAsBytes(); ok {
-		messageBody = string(payload)
+// The idempotent receiver acknowledges the message once this returns nil
+func MessageHandler(msg message.InboundMessage) error {
+	line, err := samples.Describe(msg, greetingCodec)
+	if err != nil {
+		// settled as failed, so the broker redelivers it or moves it to the DMQ
+		return err
 	}
-
-	receiver.Ack(msg)
-	fmt.Printf("Received Message Body %s \n", messageBody)
-	// fmt.Printf("Message Dump %s \n", message)
+	fmt.Println(line)
+	return nil
 }
 
-func getEnv(key, def string) string {
-	if val, ok := os.LookupEnv(key); ok {
-		return val
-	}
-	return def
-}
+// Decodes Avro payloads when -schema-registry is given
+var greetingCodec *codec.Avro[samples.Greeting]
 
 // Define Topic Prefix
 const TopicPrefix = "solace/samples"
 
+// Duplicate detection settings, parsed together with the broker flags
+var (
+	dedupFile     = flag.String("dedup-file", "", "file to remember processed message keys across restarts, in memory when empty")
+	dedupProperty = flag.String("dedup-property", "", "user property to deduplicate on instead of the application message ID")
+	registryFile  = flag.String("schema-registry", "", "file of a local schema registry to decode Avro encoded Greetings with")
+)
+
 func main() {
 	// logging.SetLogLevel(logging.LogLevelInfo)
 
-	// Configuration parameters
-	brokerConfig := config.ServicePropertyMap{
-		config.TransportLayerPropertyHost:                getEnv("SOLACE_HOST", "tcp://localhost:55555,tcp://localhost:55554"),
-		config.ServicePropertyVPNName:                    getEnv("SOLACE_VPN", "default"),
-		config.AuthenticationPropertySchemeBasicPassword: getEnv("SOLACE_PASSWORD", "default"),
-		config.AuthenticationPropertySchemeBasicUserName: getEnv("SOLACE_USERNAME", "default"),
+	// Load the broker settings from flags, environment, profile file or defaults
+	brokerConfig, err := bootstrap.Load()
+	if err != nil {
+		panic(err)
 	}
 
-	messagingService, err := messaging.NewMessagingServiceBuilder().FromConfigurationProvider(brokerConfig).Build()
-
+	// Connect to the messaging service
+	messagingService, err := bootstrap.Connect(brokerConfig)
 	if err != nil {
 		panic(err)
 	}
 
-	// Connect to the messaging serice
-	if err := messagingService.Connect(); err != nil {
+	fmt.Println("Connected to the broker? ", messagingService.IsConnected())
+
+	// Count messages and connection events, served as Prometheus metrics on -metrics-addr when given
+	sampleMetrics, err := metrics.Enable(messagingService)
+	if err != nil {
 		panic(err)
 	}
 
-	fmt.Println("Connected to the broker? ", messagingService.IsConnected())
+	// Look up the schemas of Avro payloads in the local schema registry
+	if *registryFile != "" {
+		registry, err := schema.OpenFile(*registryFile)
+		if err != nil {
+			panic(err)
+		}
+		greetingCodec, err = codec.NewAvro[samples.Greeting](registry, samples.GreetingSubject, samples.GreetingSchema)
+		if err != nil {
+			panic(err)
+		}
+	}
 
 	// queueName := "durable-queue"
 	// durableExclusiveQueue := resource.QueueDurableExclusive(queueName)
@@ -75,7 +95,21 @@ func main() {
 	// persistentReceiver, err := messagingService.CreatePersistentMessageReceiverBuilder().WithMessageAutoAcknowledgement().WithMissingResourcesCreationStrategy(strategy).WithSubscriptions(topic).Build(durableExclusiveQueue)
 
 	// Non-durable Queue
-	persistentReceiver, err := messagingService.CreatePersistentMessageReceiverBuilder().WithMessageClientAcknowledgement().WithMissingResourcesCreationStrategy(strategy).WithSubscriptions(topic).Build(nonDurableExclusiveQueue)
+	persistentReceiver, err := messagingService.CreatePersistentMessageReceiverBuilder().WithMessageClientAcknowledgement().WithRequiredMessageOutcomeSupport(config.PersistentReceiverFailedOutcome, config.PersistentReceiverRejectedOutcome).WithMissingResourcesCreationStrategy(strategy).WithSubscriptions(topic).Build(nonDurableExclusiveQueue)
+	if err != nil {
+		panic(err)
+	}
+
+	// Decrypt and decompress payloads sealed by a publisher started with -compress or -keyring,
+	// messages that cannot be opened are rejected to the DMQ
+	payloadSealer, err := seal.Enable(messagingService)
+	if err != nil {
+		panic(err)
+	}
+	payloadSealer.OnError(func(msg message.InboundMessage, err error) {
+		fmt.Println("Rejected message: ", err)
+	})
+	persistentReceiver = sampleMetrics.PersistentReceiver(payloadSealer.PersistentReceiver(persistentReceiver))
 
 	// Handling a panic from a non existing queue
 	defer func() {
@@ -84,6 +118,10 @@ func main() {
 		}
 	}()
 
+	// Terminate the receiver and disconnect on SIGINT or SIGTERM
+	runner := lifecycle.NewRunner(messagingService, lifecycle.WithGracePeriod(1*time.Second))
+	runner.Manage(persistentReceiver)
+
 	// Start Persistent Message Receiver
 	if err := persistentReceiver.Start(); err != nil {
 		panic(err)
@@ -91,27 +129,52 @@ func main() {
 
 	fmt.Println("Persistent Receiver running? ", persistentReceiver.IsRunning())
 
+	// Remember processed message keys so redelivered duplicates are acked without processing them again
+	var dedupStore dedup.Store = dedup.NewLRU(10000)
+	var fileStore *dedup.FileStore
+	if *dedupFile != "" {
+		fileStore, err = dedup.OpenFileStore(*dedupFile, 10000)
+		if err != nil {
+			panic(err)
+		}
+		dedupStore = fileStore
+	}
+	idempotentReceiver := dedup.NewReceiver(persistentReceiver, dedupStore, dedup.WithKey(samples.DedupKey(*dedupProperty)),
+		dedup.OnRedelivered(sampleMetrics.CountRedelivered),
+		dedup.OnStoreError(func(key string, err error) {
+			fmt.Printf("Deduplication store failed for key %s, a redelivery is processed again: %v\n", key, err)
+		}))
+
+	// Serve /readyz from the service and receiver state, and /healthz from a watchdog
+	// that catches a handler stuck for longer than 30 seconds, on -health-addr when given
+	healthChecks, err := health.Enable()
+	if err != nil {
+		panic(err)
+	}
+	healthChecks.AddService("messaging-service", messagingService)
+	healthChecks.AddReceiver("persistent-receiver", persistentReceiver)
+	handlerWatchdog := healthChecks.Watchdog("message-handler", 30*time.Second)
+
 	// Register Message callback handler to the Message Receiver
-	if regErr := persistentReceiver.ReceiveAsync(func(msg message.InboundMessage) { MessageHandler(persistentReceiver, msg) }); regErr != nil {
+	if regErr := idempotentReceiver.ReceiveAsync(func(msg message.InboundMessage) error {
+		defer handlerWatchdog.Begin()()
+		return MessageHandler(msg)
+	}); regErr != nil {
 		panic(regErr)
 	}
 	fmt.Printf("\n Bound to queue: %s\n", queueName)
 	fmt.Println("\n===Interrupt (CTR+C) to handle graceful termination of the receiver===\n")
 
-	// Run forever until an interrupt signal is received
-	// Handle interrupts
-
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
-
-	// Block until a signal is received.
-	<-c
+	// Block until an OS interrupt signal is received, then shut down
+	exitCode := runner.Run(context.Background())
+	healthChecks.Close()
+	if fileStore != nil {
+		fileStore.Close()
+	}
 
-	// Terminate the Persistent Receiver
-	persistentReceiver.Terminate(1 * time.Second)
 	fmt.Println("\nPersistent Receiver Terminated? ", persistentReceiver.IsTerminated())
-	// Disconnect the Message Service
-	messagingService.Disconnect()
+	fmt.Println("Receive outcomes: ", idempotentReceiver.Stats())
 	fmt.Println("Messaging Service Disconnected? ", !messagingService.IsConnected())
 
+	os.Exit(exitCode)
 }
@@ -1,24 +1,21 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
-	"os/signal"
 	"time"
 
-	"solace.dev/go/messaging"
 	"solace.dev/go/messaging/pkg/solace"
 	"solace.dev/go/messaging/pkg/solace/config"
 	"solace.dev/go/messaging/pkg/solace/message"
 	"solace.dev/go/messaging/pkg/solace/resource"
-)
 
-func getEnv(key, def string) string {
-	if val, ok := os.LookupEnv(key); ok {
-		return val
-	}
-	return def
-}
+	"SolaceSamples.com/PubSub+Go/internal/bootstrap"
+	"SolaceSamples.com/PubSub+Go/internal/health"
+	"SolaceSamples.com/PubSub+Go/internal/lifecycle"
+	"SolaceSamples.com/PubSub+Go/internal/metrics"
+)
 
 // BuildNackPersistentMessageReceiverWithBuilderMethod - example of how to build a Gauranteed message receiver
 // with NACK support and bind to the given queue and set the required message settlement outcome(s) on the
@@ -48,7 +45,7 @@ func BuildNackPersistentMessageReceiverWithConfigurationProvider(messagingServic
 
 // HandleMessageSettlementWithAcceptedOutcome - example of how to set up the persistent receive to
 // settle messages with the ACCEPTED message settlement outcome
-func HandleMessageSettlementWithAcceptedOutcome(persistentReceiver solace.PersistentMessageReceiver) {
+func HandleMessageSettlementWithAcceptedOutcome(persistentReceiver solace.PersistentMessageReceiver, handlerWatchdog *health.Watchdog) {
 	// Message Handler
 	messageHandler := func(message message.InboundMessage) {
 		var messageBody string
@@ -68,14 +65,14 @@ func HandleMessageSettlementWithAcceptedOutcome(persistentReceiver solace.Persis
 	}
 
 	// Register Message callback handler to the Message Receiver
-	if regErr := persistentReceiver.ReceiveAsync(messageHandler); regErr != nil {
+	if regErr := persistentReceiver.ReceiveAsync(handlerWatchdog.Handler(messageHandler)); regErr != nil {
 		panic(regErr)
 	}
 }
 
 // HandleMessageSettlementWithFailedOutcome - example of how to set up the persistent receive to
 // settle messages with the FAILED message settlement outcome
-func HandleMessageSettlementWithFailedOutcome(persistentReceiver solace.PersistentMessageReceiver) {
+func HandleMessageSettlementWithFailedOutcome(persistentReceiver solace.PersistentMessageReceiver, handlerWatchdog *health.Watchdog) {
 	// Message Handler
 	messageHandler := func(message message.InboundMessage) {
 		var messageBody string
@@ -95,14 +92,14 @@ func HandleMessageSettlementWithFailedOutcome(persistentReceiver solace.Persiste
 	}
 
 	// Register Message callback handler to the Message Receiver
-	if regErr := persistentReceiver.ReceiveAsync(messageHandler); regErr != nil {
+	if regErr := persistentReceiver.ReceiveAsync(handlerWatchdog.Handler(messageHandler)); regErr != nil {
 		panic(regErr)
 	}
 }
 
 // HandleMessageSettlementWithRejectedOutcome - example of how to set up the persistent receive to
 // settle messages with the REJECTED message settlement outcome
-func HandleMessageSettlementWithRejectedOutcome(persistentReceiver solace.PersistentMessageReceiver) {
+func HandleMessageSettlementWithRejectedOutcome(persistentReceiver solace.PersistentMessageReceiver, handlerWatchdog *health.Watchdog) {
 	// Message Handler
 	messageHandler := func(message message.InboundMessage) {
 		var messageBody string
@@ -122,7 +119,7 @@ func HandleMessageSettlementWithRejectedOutcome(persistentReceiver solace.Persis
 	}
 
 	// Register Message callback handler to the Message Receiver
-	if regErr := persistentReceiver.ReceiveAsync(messageHandler); regErr != nil {
+	if regErr := persistentReceiver.ReceiveAsync(handlerWatchdog.Handler(messageHandler)); regErr != nil {
 		panic(regErr)
 	}
 }
@@ -130,27 +127,26 @@ func HandleMessageSettlementWithRejectedOutcome(persistentReceiver solace.Persis
 func main() {
 	// logging.SetLogLevel(logging.LogLevelInfo)
 
-	// Configuration parameters
-	brokerConfig := config.ServicePropertyMap{
-		config.TransportLayerPropertyHost:                getEnv("SOLACE_HOST", "tcp://localhost:55555,tcp://localhost:55554"),
-		config.ServicePropertyVPNName:                    getEnv("SOLACE_VPN", "default"),
-		config.AuthenticationPropertySchemeBasicPassword: getEnv("SOLACE_PASSWORD", "default"),
-		config.AuthenticationPropertySchemeBasicUserName: getEnv("SOLACE_USERNAME", "default"),
-	}
-
-	messagingService, err := messaging.NewMessagingServiceBuilder().FromConfigurationProvider(brokerConfig).Build()
-
+	// Load the broker settings from flags, environment, profile file or defaults
+	brokerConfig, err := bootstrap.Load()
 	if err != nil {
 		panic(err)
 	}
 
-	// Connect to the messaging serice
-	if err := messagingService.Connect(); err != nil {
+	// Connect to the messaging service
+	messagingService, err := bootstrap.Connect(brokerConfig)
+	if err != nil {
 		panic(err)
 	}
 
 	fmt.Println("Connected to the broker? ", messagingService.IsConnected())
 
+	// Count messages and connection events, served as Prometheus metrics on -metrics-addr when given
+	sampleMetrics, err := metrics.Enable(messagingService)
+	if err != nil {
+		panic(err)
+	}
+
 	if err != nil {
 		panic(err)
 	}
@@ -164,6 +160,7 @@ func main() {
 	// 	-	using the WithRequiredMessageOutcomeSupport() builder method => BuildNackPersistentMessageReceiverWithBuilderMethod(messagingService, durableExclusiveQueue)
 	// 	-	using the configuration provider => BuildNackPersistentMessageReceiverWithConfigurationProvider(messagingService, durableExclusiveQueue)
 	persistentReceiver, err := BuildNackPersistentMessageReceiverWithBuilderMethod(messagingService, durableExclusiveQueue)
+	persistentReceiver = sampleMetrics.PersistentReceiver(persistentReceiver)
 
 	// Handling a panic from a non existing queue
 	defer func() {
@@ -172,6 +169,10 @@ func main() {
 		}
 	}()
 
+	// Terminate the receiver and disconnect on SIGINT or SIGTERM
+	runner := lifecycle.NewRunner(messagingService, lifecycle.WithGracePeriod(1*time.Second))
+	runner.Manage(persistentReceiver)
+
 	// Start Persistent Message Receiver
 	if err := persistentReceiver.Start(); err != nil {
 		panic(err)
@@ -179,30 +180,31 @@ func main() {
 
 	fmt.Println("Persistent Receiver running? ", persistentReceiver.IsRunning())
 
+	// Serve /readyz from the service and receiver state, and /healthz from a watchdog
+	// that catches a handler stuck for longer than 30 seconds, on -health-addr when given
+	healthChecks, err := health.Enable()
+	if err != nil {
+		panic(err)
+	}
+	healthChecks.AddService("messaging-service", messagingService)
+	healthChecks.AddReceiver("persistent-receiver", persistentReceiver)
+	handlerWatchdog := healthChecks.Watchdog("message-handler", 30*time.Second)
+
 	// Example snippet on how to settle a message with the ACCEPTED outcome
 	// Code example for other message settlement outcomes are implemented in these functions:
-	// 	-	FAILED Outcome 		=> HandleMessageSettlementWithFailedOutcome(persistentReceiver)
-	// 	-	REJECTED Outcome 	=> HandleMessageSettlementWithRejectedOutcome(persistentReceiver)
-	HandleMessageSettlementWithAcceptedOutcome(persistentReceiver)
+	// 	-	FAILED Outcome 		=> HandleMessageSettlementWithFailedOutcome(persistentReceiver, handlerWatchdog)
+	// 	-	REJECTED Outcome 	=> HandleMessageSettlementWithRejectedOutcome(persistentReceiver, handlerWatchdog)
+	HandleMessageSettlementWithAcceptedOutcome(persistentReceiver, handlerWatchdog)
 
 	fmt.Printf("\n Bound to queue: %s\n", queueName)
 	fmt.Println("\n===Interrupt (CTR+C) to handle graceful termination of the receiver===\n")
 
-	// Run forever until an interrupt signal is received
-	// Handle interrupts
-
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
-
-	// Block until a signal is received.
-	<-c
+	// Block until an OS interrupt signal is received, then shut down
+	exitCode := runner.Run(context.Background())
+	healthChecks.Close()
 
-	// Terminate the Persistent Receiver
-	// Graceful shutdown/termination of the persistent receiver is attempted within the specified grace period of one second
-	persistentReceiver.Terminate(1 * time.Second)
 	fmt.Println("\nPersistent Receiver Terminated? ", persistentReceiver.IsTerminated())
-	// Disconnect the Message Service
-	messagingService.Disconnect()
 	fmt.Println("Messaging Service Disconnected? ", !messagingService.IsConnected())
 
+	os.Exit(exitCode)
 }
@@ -1,61 +1,64 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
-	"os/signal"
 	"strconv"
 	"time"
 
-	"solace.dev/go/messaging"
-	"solace.dev/go/messaging/pkg/solace/config"
 	"solace.dev/go/messaging/pkg/solace/message"
-	"solace.dev/go/messaging/pkg/solace/resource"
+
+	"SolaceSamples.com/PubSub+Go/internal/bootstrap"
+	"SolaceSamples.com/PubSub+Go/internal/health"
+	"SolaceSamples.com/PubSub+Go/internal/lifecycle"
+	"SolaceSamples.com/PubSub+Go/internal/metrics"
+	"SolaceSamples.com/PubSub+Go/internal/topics"
 )
 
 // Message Handler
 func MessageHandler(message message.InboundMessage) {
-	fmt.Printf("Message Dump %s \n", message)
-}
-
-func getEnv(key, def string) string {
-	if val, ok := os.LookupEnv(key); ok {
-		return val
+	// Parse the destination back into the fields of the topic hierarchy
+	if fields, err := HelloTopic.Parse(message.GetDestinationName()); err == nil {
+		fmt.Printf("Hello #%s from %s, written in %s\n", fields["seq"], fields["name"], fields["language"])
 	}
-	return def
+	fmt.Printf("Message Dump %s \n", message)
 }
 
 // Define Topic Prefix
 const TopicPrefix = "solace/samples"
 
-func main() {
-
-	// Configuration parameters
-	brokerConfig := config.ServicePropertyMap{
-		config.TransportLayerPropertyHost:                getEnv("SOLACE_HOST", "tcp://localhost:55555,tcp://localhost:55554"),
-		config.ServicePropertyVPNName:                    getEnv("SOLACE_VPN", "default"),
-		config.AuthenticationPropertySchemeBasicPassword: getEnv("SOLACE_PASSWORD", "default"),
-		config.AuthenticationPropertySchemeBasicUserName: getEnv("SOLACE_USERNAME", "default"),
-	}
+// Topic hierarchy of the hello messages, every field fills one level
+var HelloTopic = topics.MustNew(TopicPrefix + "/{language}/hello/{name}/{seq}")
 
-	messagingService, err := messaging.NewMessagingServiceBuilder().FromConfigurationProvider(brokerConfig).Build()
+func main() {
 
+	// Load the broker settings from flags, environment, profile file or defaults
+	brokerConfig, err := bootstrap.Load()
 	if err != nil {
 		panic(err)
 	}
 
-	// Connect to the messaging serice
-	if err := messagingService.Connect(); err != nil {
+	// Connect to the messaging service
+	messagingService, err := bootstrap.Connect(brokerConfig)
+	if err != nil {
 		panic(err)
 	}
 
 	fmt.Println("Connected to the broker? ", messagingService.IsConnected())
 
+	// Count messages and connection events, served as Prometheus metrics on -metrics-addr when given
+	sampleMetrics, err := metrics.Enable(messagingService)
+	if err != nil {
+		panic(err)
+	}
+
 	//  Build a Direct Message Publisher
 	directPublisher, builderErr := messagingService.CreateDirectMessagePublisherBuilder().Build()
 	if builderErr != nil {
 		panic(builderErr)
 	}
+	directPublisher = sampleMetrics.DirectPublisher(directPublisher)
 
 	startErr := directPublisher.Start()
 	if startErr != nil {
@@ -64,14 +67,21 @@ func main() {
 
 	fmt.Println("Direct Publisher running? ", directPublisher.IsRunning())
 
+	// Subscribe to the hello messages of every language and name
+	helloSubscription, err := HelloTopic.Subscription(topics.Fields{})
+	if err != nil {
+		panic(err)
+	}
+
 	//  Build a Direct Message Receiver
 	directReceiver, err := messagingService.CreateDirectMessageReceiverBuilder().
-		WithSubscriptions(resource.TopicSubscriptionOf(TopicPrefix + "/*/hello/>")).
+		WithSubscriptions(helloSubscription).
 		Build()
 
 	if err != nil {
 		panic(err)
 	}
+	directReceiver = sampleMetrics.DirectReceiver(directReceiver)
 
 	// Start Direct Message Receiver
 	if err := directReceiver.Start(); err != nil {
@@ -80,13 +90,30 @@ func main() {
 
 	fmt.Println("Direct Receiver running? ", directReceiver.IsRunning())
 
-	if regErr := directReceiver.ReceiveAsync(MessageHandler); regErr != nil {
+	// Serve /readyz from the service, receiver and publisher state, and /healthz from watchdogs
+	// that catch a handler or the publish loop stuck for longer than 30 seconds, on -health-addr when given
+	healthChecks, err := health.Enable()
+	if err != nil {
+		panic(err)
+	}
+	healthChecks.AddService("messaging-service", messagingService)
+	healthChecks.AddReceiver("direct-receiver", directReceiver)
+	healthChecks.AddPublisher("direct-publisher", directPublisher)
+	handlerWatchdog := healthChecks.Watchdog("message-handler", 30*time.Second)
+	publishWatchdog := healthChecks.Watchdog("publish-loop", 30*time.Second)
+
+	if regErr := directReceiver.ReceiveAsync(handlerWatchdog.Handler(MessageHandler)); regErr != nil {
 		panic(regErr)
 	}
 
 	fmt.Print("\nEnter your name: ")
 	var uniqueName string
 	fmt.Scanln(&uniqueName)
+	// The name becomes a topic level, it must not be empty or contain '/' or wildcards
+	if err := topics.ValidateLevel(uniqueName); err != nil {
+		fmt.Printf("Cannot use %q as a topic level: %s\n", uniqueName, err)
+		os.Exit(1)
+	}
 
 	msgSeqNum := 0
 
@@ -96,46 +123,43 @@ func main() {
 		WithProperty("application", "samples").
 		WithProperty("language", "go")
 
-	go func() {
-		println("Subscribe to topic ", TopicPrefix+"/>")
+	// Stop the publish loop, terminate the receiver then the publisher and disconnect on interrupt
+	runner := lifecycle.NewRunner(messagingService, lifecycle.WithGracePeriod(2*time.Second))
+	runner.Manage(directPublisher)
+	runner.Manage(directReceiver)
+
+	runner.Go(func(ctx context.Context) error {
+		println("Subscribe to topic ", helloSubscription.GetName())
 
 		for directPublisher.IsReady() {
+			publishWatchdog.Beat()
 			msgSeqNum++
 			message, err := messageBuilder.BuildWithStringPayload(messageBody + " --> " + strconv.Itoa(msgSeqNum))
 			if err != nil {
-				panic(err)
+				return err
 			}
-			publishErr := directPublisher.Publish(message, resource.TopicOf(TopicPrefix+"/go/hello/"+uniqueName+"/"+strconv.Itoa(msgSeqNum)))
+			topic, err := HelloTopic.Build(topics.Fields{"language": "go", "name": uniqueName, "seq": strconv.Itoa(msgSeqNum)})
+			if err != nil {
+				return err
+			}
+			publishErr := directPublisher.Publish(message, topic)
 			if publishErr != nil {
-				panic(publishErr)
+				return publishErr
+			}
+			if !lifecycle.Sleep(ctx, 1*time.Second) {
+				return nil
 			}
-			time.Sleep(1 * time.Second)
 		}
+		return nil
+	})
 
-	}()
-
-	// Handle interrupts
+	// Block until a signal is received, then shut down
+	exitCode := runner.Run(context.Background())
+	healthChecks.Close()
 
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
-
-	// Block until a signal is received.
-	<-c
-
-	// TODO
-	// Find way to shutdown the go routine
-	// e.g use another channel, BOOl..etc
-	// TODO
-
-	// Terminate the Direct Receiver
-	directReceiver.Terminate(2 * time.Second)
 	fmt.Println("\nDirect Receiver Terminated? ", directReceiver.IsTerminated())
-	// Terminate the Direct Publisher
-	directPublisher.Terminate(2 * time.Second)
 	fmt.Println("\nDirect Publisher Terminated? ", directPublisher.IsTerminated())
-
-	// Disconnect the Message Service
-	messagingService.Disconnect()
 	fmt.Println("Messaging Service Disconnected? ", !messagingService.IsConnected())
 
+	os.Exit(exitCode)
 }
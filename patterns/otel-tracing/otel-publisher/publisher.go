@@ -2,23 +2,21 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"os"
-	"os/signal"
 	"strconv"
 	"time"
 
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/baggage"
-	"go.opentelemetry.io/otel/propagation"
 	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
 
-	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 
 	"go.opentelemetry.io/otel/trace"
-	"solace.dev/go/messaging"
+	"solace.dev/go/messaging/pkg/solace"
 	"solace.dev/go/messaging/pkg/solace/config"
 	"solace.dev/go/messaging/pkg/solace/message"
 	"solace.dev/go/messaging/pkg/solace/resource"
@@ -27,35 +25,27 @@ import (
 	solpropagation "solace.dev/go/messaging-trace/opentelemetry"
 	"solace.dev/go/messaging-trace/opentelemetry/carrier"
 	sol_otel_logging "solace.dev/go/messaging-trace/opentelemetry/logging"
+
+	"SolaceSamples.com/PubSub+Go/internal/bootstrap"
+	"SolaceSamples.com/PubSub+Go/internal/health"
+	"SolaceSamples.com/PubSub+Go/internal/lifecycle"
+	"SolaceSamples.com/PubSub+Go/internal/tracing"
 )
 
-func getEnv(key, def string) string {
-	if val, ok := os.LookupEnv(key); ok {
-		return val
+// init function for tracer provider and propagator setup
+// The exporter, span processor and sampler are taken from the OTEL_* environment variables,
+// see internal/tracing; the default pretty prints every span on stdout as it ends
+func InitTracing() *sdktrace.TracerProvider {
+	// Register the TraceContext and Baggage propagators and the TraceProvider globally; Solace supports only the TraceContext format
+	tracingConfig, err := tracing.FromEnv()
+	if err != nil {
+		panic(err)
 	}
-	return def
-}
-
-// init function for propagator setup
-func InitTracing() {
-	// common init
-	tcPropagation := propagation.TraceContext{}
-	bagPropagation := propagation.Baggage{}
-	// Register the TraceContext propagator globally; Solace supports only this format
-	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(tcPropagation, bagPropagation))
-	// otel.SetTextMapPropagator(propagation.TraceContext{}) // - optional if you only need to propagate trace context and not Baggage
-
-	// You can use any other valid exporter here
-	exporter, _ := stdouttrace.New(stdouttrace.WithPrettyPrint()) // use a stdOut trace exporter
-
-	batchspanProcessor := sdktrace.NewSimpleSpanProcessor(exporter) // You should use batch span processor in production environment
-	traceProvider := sdktrace.NewTracerProvider(
-		sdktrace.WithSampler(sdktrace.AlwaysSample()),
-		sdktrace.WithSpanProcessor(batchspanProcessor),
-	)
-
-	// Register the TraceProvider globally;
-	otel.SetTracerProvider(traceProvider)
+	traceProvider, err := tracing.Setup(context.Background(), tracingConfig)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("Tracing: ", tracingConfig)
 
 	// enable logging on the solace PubSub+ OTel integration component
 	sol_otel_logging.SetLogLevel(sol_otel_logging.LogLevelInfo)
@@ -63,6 +53,7 @@ func InitTracing() {
 	// more otel api globals can be configured
 	// ...
 
+	return traceProvider
 }
 
 // GetInitialContext - get the initial context from the background context and optionally add baggage information
@@ -116,50 +107,26 @@ func HowToInjectTraceContextInSolaceMessage(startingContext context.Context, out
 // TopicPrefix - Define Topic Prefix
 const PublishTopicName = "solace/samples/otel-tracing"
 
+// Publish persistent messages instead of direct ones, parsed together with the broker flags
+var persistent = flag.Bool("persistent", false, "publish persistent messages, each traced until its publish receipt")
+
 func main() {
 	// setting up Otel defaults
-	InitTracing()
-
-	// Configuration parameters
-	brokerConfig := config.ServicePropertyMap{
-		config.TransportLayerPropertyHost:                getEnv("SOLACE_HOST", "tcp://localhost:55555,tcp://localhost:55554"),
-		config.ServicePropertyVPNName:                    getEnv("SOLACE_VPN", "default"),
-		config.AuthenticationPropertySchemeBasicPassword: getEnv("SOLACE_PASSWORD", "default"),
-		config.AuthenticationPropertySchemeBasicUserName: getEnv("SOLACE_USERNAME", "default"),
-	}
-
-	messagingService, err := messaging.NewMessagingServiceBuilder().FromConfigurationProvider(brokerConfig).Build()
+	traceProvider := InitTracing()
 
+	// Load the broker settings from flags, environment, profile file or defaults
+	brokerConfig, err := bootstrap.Load()
 	if err != nil {
 		panic(err)
 	}
 
-	// Connect to the messaging serice
-	if err := messagingService.Connect(); err != nil {
-		panic(err)
-	}
-
-	fmt.Println("Connected to the broker? ", messagingService.IsConnected())
-
-	// Build a Persistent Message Publisher
-	// persistentPublisher, err := messagingService.CreatePersistentMessagePublisherBuilder().Build()
-
-	//  Build a Direct Message Publisher
-	directPublisher, err := messagingService.CreateDirectMessagePublisherBuilder().Build()
+	// Connect to the messaging service
+	messagingService, err := bootstrap.Connect(brokerConfig)
 	if err != nil {
 		panic(err)
 	}
 
-	// Start Direct Message Publisher
-	if err := directPublisher.Start(); err != nil {
-		panic(err)
-	}
-
-	fmt.Println("Direct Publisher running? ", directPublisher.IsRunning())
-
-	fmt.Println("\n===Interrupt (CTR+C) to stop publishing===")
-
-	msgSeqNum := 0 // set to zero
+	fmt.Println("Connected to the broker? ", messagingService.IsConnected())
 
 	//  Prepare outbound message payload and body
 	messageConfig := config.MessagePropertyMap{
@@ -173,61 +140,109 @@ func main() {
 		WithProperty("language", "go")
 
 	topic := resource.TopicOf(PublishTopicName)
+
+	// Terminate the publisher and disconnect once the publish loop has stopped
+	runner := lifecycle.NewRunner(messagingService, lifecycle.WithGracePeriod(1*time.Second))
+
+	var publisher interface {
+		solace.MessagePublisher
+		solace.MessagePublisherHealthCheck
+	}
+	var publishMessage func(startingContext context.Context, outMessage message.OutboundMessage, msgSeqNum int) error
+
+	if *persistent {
+		// Build a Persistent Message Publisher
+		persistentPublisher, err := messagingService.CreatePersistentMessagePublisherBuilder().Build()
+		if err != nil {
+			panic(err)
+		}
+
+		// Trace every publish from the call until its publish receipt
+		tracedPublisher := tracing.NewPersistentPublisher(persistentPublisher)
+		receipts := lifecycle.TrackReceipts(tracedPublisher, nil)
+		runner.Manage(tracedPublisher)
+		runner.Drain(receipts)
+
+		publisher = tracedPublisher
+		publishMessage = func(startingContext context.Context, outMessage message.OutboundMessage, msgSeqNum int) error {
+			// the traced publisher starts the publish span, only the baggage is carried over in the message
+			otel.GetTextMapPropagator().Inject(startingContext, solpropagation.NewOutboundMessageCarrier(outMessage))
+			return receipts.Publish(outMessage, topic, nil, nil)
+		}
+	} else {
+		//  Build a Direct Message Publisher
+		directPublisher, err := messagingService.CreateDirectMessagePublisherBuilder().Build()
+		if err != nil {
+			panic(err)
+		}
+		runner.Manage(directPublisher)
+
+		publisher = directPublisher
+		publishMessage = func(startingContext context.Context, outMessage message.OutboundMessage, msgSeqNum int) error {
+			// inject trace context into Solace message via the OutboundMessageCarrier
+			HowToInjectTraceContextInSolaceMessage(startingContext, outMessage, msgSeqNum, PublishTopicName)
+			return directPublisher.Publish(outMessage, topic)
+		}
+	}
+
+	// Serve /readyz from the service and publisher state, on -health-addr when given
+	healthChecks, err := health.Enable()
+	if err != nil {
+		panic(err)
+	}
+	healthChecks.AddService("messaging-service", messagingService)
+	healthChecks.AddPublisher("publisher", publisher)
+
+	// Start the Message Publisher
+	if err := publisher.Start(); err != nil {
+		panic(err)
+	}
+
+	fmt.Println("Publisher running? ", publisher.IsRunning())
+
+	fmt.Println("\n===Interrupt (CTR+C) to stop publishing===")
+
 	fmt.Printf("Publishing on: %s, please ensure queue has matching subscription.\n", topic.GetName())
 
-	// Run forever until an interrupt signal is received
-	go func() {
-		// uncomment this line; if using the persistentPublisher
-		// for persistentPublisher.IsReady() && msgSeqNum <= 0 {
+	msgSeqNum := 0 // set to zero
 
-		// comment out this line; if using the persistentPublisher
-		for directPublisher.IsReady() && msgSeqNum <= 0 {
+	// Run forever until an interrupt signal is received
+	runner.Go(func(ctx context.Context) error {
+		for publisher.IsReady() && msgSeqNum <= 0 {
 			msgSeqNum++
 			outMessage, err := messageBuilder.BuildWithStringPayload(messageBody + " --> " + strconv.Itoa(msgSeqNum))
 			if err != nil {
-				panic(err)
+				return err
 			}
 
 			// get the initial context
 			startingContext := GetInitialContext(true) // pass true to include baggage in context
 
-			// inject trace context into Solace message via the OutboundMessageCarrier
-			HowToInjectTraceContextInSolaceMessage(startingContext, outMessage, msgSeqNum, PublishTopicName)
-
 			// Publish to the given topic
-			// comment out this line; if using the persistentPublisher
-			publishErr := directPublisher.Publish(outMessage, resource.TopicOf(PublishTopicName))
-
-			// uncomment this line; if using the persistentPublisher
-			// publishErr := persistentPublisher.Publish(outMessage, resource.TopicOf(PublishTopicName), nil, nil)
-
-			if publishErr != nil {
-				panic(publishErr)
+			if publishErr := publishMessage(startingContext, outMessage, msgSeqNum); publishErr != nil {
+				return publishErr
 			}
 
-			time.Sleep(1 * time.Second)
+			if !lifecycle.Sleep(ctx, 1*time.Second) {
+				return nil
+			}
 			msgSeqNum++
 		}
-	}()
+		return nil
+	})
 
-	// Handle OS interrupts
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
+	// Block until an OS interrupt signal is received, then shut down
+	exitCode := runner.Run(context.Background())
+	healthChecks.Close()
 
-	// Block until an OS interrupt signal is received.
-	<-c
+	fmt.Println("\nPublisher Terminated? ", publisher.IsTerminated())
 
-	// uncomment this block; if using the persistentPublisher
-	// Terminate the Persistent Publisher
-	// persistentPublisher.Terminate(1 * time.Second)
-	// fmt.Println("\nPersistent Publisher Terminated? ", persistentPublisher.IsTerminated())
+	fmt.Println("Messaging Service Disconnected? ", !messagingService.IsConnected())
 
-	// comment out this block; if using the persistentPublisher
-	// Terminate the Direct Publisher
-	directPublisher.Terminate(1 * time.Second)
-	fmt.Println("\nDirect Publisher Terminated? ", directPublisher.IsTerminated())
+	// Export the spans still buffered by a batch span processor
+	if err := traceProvider.Shutdown(context.Background()); err != nil {
+		fmt.Println("Trace export failed: ", err)
+	}
 
-	// Disconnect the Message Service
-	messagingService.Disconnect()
-	fmt.Println("Messaging Service Disconnected? ", !messagingService.IsConnected())
+	os.Exit(exitCode)
 }
@@ -2,21 +2,18 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"os"
-	"os/signal"
 	"time"
 
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/baggage"
-	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
-	"go.opentelemetry.io/otel/propagation"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
 	"go.opentelemetry.io/otel/trace"
-	"solace.dev/go/messaging"
-	"solace.dev/go/messaging/pkg/solace/config"
+	"solace.dev/go/messaging/pkg/solace"
 	"solace.dev/go/messaging/pkg/solace/message"
 	"solace.dev/go/messaging/pkg/solace/resource"
 
@@ -24,35 +21,27 @@ import (
 	solpropagation "solace.dev/go/messaging-trace/opentelemetry"
 	"solace.dev/go/messaging-trace/opentelemetry/carrier"
 	sol_otel_logging "solace.dev/go/messaging-trace/opentelemetry/logging"
+
+	"SolaceSamples.com/PubSub+Go/internal/bootstrap"
+	"SolaceSamples.com/PubSub+Go/internal/health"
+	"SolaceSamples.com/PubSub+Go/internal/lifecycle"
+	"SolaceSamples.com/PubSub+Go/internal/tracing"
 )
 
-func getEnv(key, def string) string {
-	if val, ok := os.LookupEnv(key); ok {
-		return val
+// init function for tracer provider and propagator setup
+// The exporter, span processor and sampler are taken from the OTEL_* environment variables,
+// see internal/tracing; the default pretty prints every span on stdout as it ends
+func InitTracing() *sdktrace.TracerProvider {
+	// Register the TraceContext and Baggage propagators and the TraceProvider globally; Solace supports only the TraceContext format
+	tracingConfig, err := tracing.FromEnv()
+	if err != nil {
+		panic(err)
 	}
-	return def
-}
-
-// init function for propagator setup
-func InitTracing() {
-	// common init
-	tcPropagation := propagation.TraceContext{}
-	bagPropagation := propagation.Baggage{}
-	// Register the TraceContext propagator globally; Solace supports only this format
-	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(tcPropagation, bagPropagation))
-	// otel.SetTextMapPropagator(propagation.TraceContext{}) // - optional if you only need to propagate trace context and not Baggage
-
-	// You can use any other valid exporter here
-	exporter, _ := stdouttrace.New(stdouttrace.WithPrettyPrint()) // use a stdOut trace exporter
-
-	batchspanProcessor := sdktrace.NewSimpleSpanProcessor(exporter) // You should use batch span processor in production environment
-	traceProvider := sdktrace.NewTracerProvider(
-		sdktrace.WithSampler(sdktrace.AlwaysSample()),
-		sdktrace.WithSpanProcessor(batchspanProcessor),
-	)
-
-	// Register the TraceProvider globally;
-	otel.SetTracerProvider(traceProvider)
+	traceProvider, err := tracing.Setup(context.Background(), tracingConfig)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("Tracing: ", tracingConfig)
 
 	// enable logging on the solace PubSub+ OTel integration component
 	sol_otel_logging.SetLogLevel(sol_otel_logging.LogLevelInfo)
@@ -60,6 +49,7 @@ func InitTracing() {
 	// more otel api globals can be configured
 	// ...
 
+	return traceProvider
 }
 
 // GetReceivedContext - retrieve the current context from the inbound message carrier
@@ -141,29 +131,43 @@ func MessageHandler(message message.InboundMessage) {
 	fmt.Printf("Message Dump:\n================\n%s \n", message)
 }
 
+// PersistentMessageHandler - Message Handler for the traced persistent receiver
+// The receiver has already started the receive span, the acknowledgement is recorded on it
+func PersistentMessageHandler(persistentReceiver solace.PersistentMessageReceiver, message message.InboundMessage) {
+	var messageBody string
+
+	if payload, ok := message.GetPayloadAsString(); ok {
+		messageBody = payload
+	} else if payload, ok := message.GetPayloadAsBytes(); ok {
+		messageBody = string(payload)
+	}
+
+	fmt.Printf("Received Message Body: %s (redelivered: %t)\n", messageBody, message.IsRedelivered())
+
+	if err := persistentReceiver.Ack(message); err != nil {
+		fmt.Println("Message Acknowledgement Error: ", err)
+	}
+}
+
 // TopicPrefix - Define Topic Prefix
 const TopicPrefix = "solace/samples/otel-tracing"
 
+// Receive from a queue instead of a direct subscription, parsed together with the broker flags
+var queueName = flag.String("queue", "", "durable queue to receive persistent messages from, the topic is subscribed on it")
+
 func main() {
 	// setting up defaults
-	InitTracing()
-
-	// Configuration parameters
-	brokerConfig := config.ServicePropertyMap{
-		config.TransportLayerPropertyHost:                getEnv("SOLACE_HOST", "tcp://localhost:55555,tcp://localhost:55554"),
-		config.ServicePropertyVPNName:                    getEnv("SOLACE_VPN", "default"),
-		config.AuthenticationPropertySchemeBasicPassword: getEnv("SOLACE_PASSWORD", "default"),
-		config.AuthenticationPropertySchemeBasicUserName: getEnv("SOLACE_USERNAME", "default"),
-	}
-
-	messagingService, err := messaging.NewMessagingServiceBuilder().FromConfigurationProvider(brokerConfig).Build()
+	traceProvider := InitTracing()
 
+	// Load the broker settings from flags, environment, profile file or defaults
+	brokerConfig, err := bootstrap.Load()
 	if err != nil {
 		panic(err)
 	}
 
-	// Connect to the messaging serice
-	if err := messagingService.Connect(); err != nil {
+	// Connect to the messaging service
+	messagingService, err := bootstrap.Connect(brokerConfig)
+	if err != nil {
 		panic(err)
 	}
 
@@ -173,42 +177,82 @@ func main() {
 		panic(err)
 	}
 
-	//  Build a Direct Message Receiver
-	directReceiver, err := messagingService.CreateDirectMessageReceiverBuilder().
-		WithSubscriptions(resource.TopicSubscriptionOf(TopicPrefix)).
-		Build()
+	// Terminate the receiver and disconnect on SIGINT or SIGTERM
+	runner := lifecycle.NewRunner(messagingService, lifecycle.WithGracePeriod(1*time.Second))
 
+	// Serve /readyz from the service and receiver state, and /healthz from a watchdog
+	// that catches a handler stuck for longer than 30 seconds, on -health-addr when given
+	healthChecks, err := health.Enable()
 	if err != nil {
 		panic(err)
 	}
-
-	// Start Direct Message Receiver
-	if err := directReceiver.Start(); err != nil {
-		panic(err)
+	healthChecks.AddService("messaging-service", messagingService)
+	handlerWatchdog := healthChecks.Watchdog("message-handler", 30*time.Second)
+
+	var receiver solace.MessageReceiver
+	if *queueName != "" {
+		// Build a Persistent Message Receiver bound to the queue, acknowledging each message once it was handled
+		persistentReceiver, err := messagingService.CreatePersistentMessageReceiverBuilder().
+			WithMessageClientAcknowledgement().
+			WithSubscriptions(resource.TopicSubscriptionOf(TopicPrefix)).
+			Build(resource.QueueDurableExclusive(*queueName))
+		if err != nil {
+			panic(err)
+		}
+
+		// Trace every message in a receive span that records redelivery and the acknowledgement
+		tracedReceiver := tracing.NewPersistentReceiver(persistentReceiver)
+		if err := tracedReceiver.Start(); err != nil {
+			panic(err)
+		}
+		fmt.Printf("Persistent Receiver bound to queue %s running? %t\n", *queueName, tracedReceiver.IsRunning())
+
+		if regErr := tracedReceiver.ReceiveAsync(handlerWatchdog.Handler(func(message message.InboundMessage) {
+			PersistentMessageHandler(tracedReceiver, message)
+		})); regErr != nil {
+			panic(regErr)
+		}
+		receiver = tracedReceiver
+		runner.Manage(tracedReceiver)
+	} else {
+		//  Build a Direct Message Receiver
+		directReceiver, err := messagingService.CreateDirectMessageReceiverBuilder().
+			WithSubscriptions(resource.TopicSubscriptionOf(TopicPrefix)).
+			Build()
+
+		if err != nil {
+			panic(err)
+		}
+
+		// Start Direct Message Receiver
+		if err := directReceiver.Start(); err != nil {
+			panic(err)
+		}
+
+		fmt.Println("Direct Receiver running? ", directReceiver.IsRunning())
+
+		if regErr := directReceiver.ReceiveAsync(handlerWatchdog.Handler(MessageHandler)); regErr != nil {
+			panic(regErr)
+		}
+		receiver = directReceiver
+		runner.Manage(directReceiver)
 	}
 
-	fmt.Println("Direct Receiver running? ", directReceiver.IsRunning())
-
-	if regErr := directReceiver.ReceiveAsync(MessageHandler); regErr != nil {
-		panic(regErr)
-	}
+	healthChecks.AddReceiver("receiver", receiver)
 
 	fmt.Println("\n===Interrupt (CTR+C) to handle graceful termination of the receiver===")
 
-	// Run forever until an interrupt signal is received
-	// Handle interrupts
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
-
-	// Block until a signal is received.
-	<-c
-
-	// Terminate the Persistent Receiver
-	directReceiver.Terminate(1 * time.Second)
-	fmt.Println("\nDirect Receiver Terminated? ", directReceiver.IsTerminated())
-
-	// Disconnect the Message Service
-	messagingService.Disconnect()
+	// Block until an OS interrupt signal is received, then terminate the
+	// Message Receiver and disconnect the Message Service
+	exitCode := runner.Run(context.Background())
+	healthChecks.Close()
+	fmt.Println("\nReceiver Terminated? ", receiver.IsTerminated())
 	fmt.Println("Messaging Service Disconnected? ", !messagingService.IsConnected())
 
+	// Export the spans still buffered by a batch span processor
+	if err := traceProvider.Shutdown(context.Background()); err != nil {
+		fmt.Println("Trace export failed: ", err)
+	}
+
+	os.Exit(exitCode)
 }
@@ -1,47 +1,51 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
-	"os/signal"
 	"time"
 
-	"solace.dev/go/messaging"
-	"solace.dev/go/messaging/pkg/solace/config"
+	"solace.dev/go/messaging/pkg/solace"
 	"solace.dev/go/messaging/pkg/solace/resource"
-)
 
-// getEnv function
-func getEnv(key, def string) string {
-	if val, ok := os.LookupEnv(key); ok {
-		return val
-	}
-	return def
-}
+	"SolaceSamples.com/PubSub+Go/internal/bootstrap"
+	"SolaceSamples.com/PubSub+Go/internal/codec"
+	"SolaceSamples.com/PubSub+Go/internal/health"
+	"SolaceSamples.com/PubSub+Go/internal/lifecycle"
+	"SolaceSamples.com/PubSub+Go/internal/metrics"
+	"SolaceSamples.com/PubSub+Go/internal/samples"
+	"SolaceSamples.com/PubSub+Go/internal/tracing"
+)
 
 func main() {
 	// logging.SetLogLevel(logging.LogLevelInfo)
 
-	// Configuration parameters
-	brokerConfig := config.ServicePropertyMap{
-		config.TransportLayerPropertyHost:                getEnv("SOLACE_HOST", "tcp://localhost:55555,tcp://localhost:55554"),
-		config.ServicePropertyVPNName:                    getEnv("SOLACE_VPN", "default"),
-		config.AuthenticationPropertySchemeBasicPassword: getEnv("SOLACE_PASSWORD", "default"),
-		config.AuthenticationPropertySchemeBasicUserName: getEnv("SOLACE_USERNAME", "default"),
+	// Load the broker settings from flags, environment, profile file or defaults
+	brokerConfig, err := bootstrap.Load()
+	if err != nil {
+		panic(err)
 	}
 
-	messagingService, err := messaging.NewMessagingServiceBuilder().FromConfigurationProvider(brokerConfig).Build()
-
+	// Connect to the messaging service
+	messagingService, err := bootstrap.Connect(brokerConfig)
 	if err != nil {
 		panic(err)
 	}
 
-	// Connect to the messaging serice
-	if err := messagingService.Connect(); err != nil {
+	fmt.Println("Connected to the broker? ", messagingService.IsConnected())
+
+	// Count messages and connection events, served as Prometheus metrics on -metrics-addr when given
+	sampleMetrics, err := metrics.Enable(messagingService)
+	if err != nil {
 		panic(err)
 	}
 
-	fmt.Println("Connected to the broker? ", messagingService.IsConnected())
+	// Trace requests and replies when OTEL_TRACES_EXPORTER is set, see internal/tracing
+	shutdownTracing, err := tracing.Enable(context.Background())
+	if err != nil {
+		panic(err)
+	}
 
 	if err != nil {
 		panic(err)
@@ -58,6 +62,22 @@ func main() {
 	if builderErr != nil {
 		panic(builderErr)
 	}
+	requestReplyReceiver = sampleMetrics.RequestReplyReceiver(requestReplyReceiver)
+	requestReplyReceiver = tracing.NewRequestReplyReceiver(requestReplyReceiver)
+
+	// Serve /readyz from the service and receiver state, and /healthz from a watchdog
+	// that catches the receive loop stuck for longer than 30 seconds, on -health-addr when given
+	healthChecks, err := health.Enable()
+	if err != nil {
+		panic(err)
+	}
+	healthChecks.AddService("messaging-service", messagingService)
+	healthChecks.AddReceiver("request-reply-receiver", requestReplyReceiver)
+	receiveWatchdog := healthChecks.Watchdog("receive-loop", 30*time.Second)
+
+	// Terminate the receiver and disconnect once the receive loop has stopped
+	runner := lifecycle.NewRunner(messagingService, lifecycle.WithGracePeriod(2*time.Second))
+	runner.Manage(requestReplyReceiver)
 
 	// Start Request-Reply Message Receiver
 	if startErr := requestReplyReceiver.Start(); startErr != nil {
@@ -70,48 +90,45 @@ func main() {
 
 	fmt.Println("Message Topic Subscription: ", topicSubscription.GetName())
 
-	// have receiver push request messages to request message handler
-	// Run in a seperate Go routine
 	var receivedMsgCounter = 0 // counter for the received messages
 
 	// Run forever until an interrupt signal is received
-	for requestReplyReceiver.IsRunning() {
-		// The ReceiveMessage() function waits until the specified timeout to receive a message or waits
-		// forever if timeout value is negative. If a timeout occurs, a solace.TimeoutError is returned.
-		// Reference: https://pkg.go.dev/solace.dev/go/messaging@v1.6.1/pkg/solace#RequestReplyMessageReceiver
-		message, replier, regErr := requestReplyReceiver.ReceiveMessage(-1)
-		if regErr != nil {
-			panic(regErr)
-		}
+	runner.Go(func(ctx context.Context) error {
+		for ctx.Err() == nil && requestReplyReceiver.IsRunning() {
+			receiveWatchdog.Beat()
+			// The ReceiveMessage() function waits until the specified timeout to receive a message or waits
+			// forever if timeout value is negative. If a timeout occurs, a solace.TimeoutError is returned.
+			// Waiting a second at most lets the loop notice the interrupt.
+			// Reference: https://pkg.go.dev/solace.dev/go/messaging@v1.6.1/pkg/solace#RequestReplyMessageReceiver
+			message, replier, regErr := requestReplyReceiver.ReceiveMessage(1 * time.Second)
+			if _, ok := regErr.(*solace.TimeoutError); ok {
+				continue
+			} else if regErr != nil {
+				return regErr
+			}
 
-		receivedMsgCounter++
-		fmt.Printf("Received message: %d\n", receivedMsgCounter)
+			receivedMsgCounter++
+			fmt.Printf("Received message: %d\n", receivedMsgCounter)
 
-		var messageBody string
+			//  Prepare outbound message properties
+			messageBuilder := messagingService.MessageBuilder().
+				WithProperty("application", "samples").
+				WithProperty("language", "go")
 
-		if payload, ok := message.GetPayloadAsString(); ok {
-			messageBody = payload
-		} else if payload, ok := message.GetPayloadAsBytes(); ok {
-			messageBody = string(payload)
-		}
+			if replier == nil { // the replier is only set when received message is request message that has to be replied to
+				// messages received on the topic subscription without a repliable destination will return a nil replier
+				fmt.Printf("Received message: %d on topic %s that was not a request message\n", receivedMsgCounter, topicSubscription.GetName())
+				continue
+			}
 
-		fmt.Printf("Received Request Message Body %s \n", messageBody)
-		// fmt.Printf("Request Message Dump %s \n", message)
-
-		//  Prepare outbound message payload and body
-		replyMessageBody := "Hello from Go Request-Reply Receiver Replier Sample"
-		messageBuilder := messagingService.MessageBuilder().
-			WithProperty("application", "samples").
-			WithProperty("language", "go")
-
-		if replier == nil { // the replier is only set when received message is request message that has to be replied to
-			// messages received on the topic subscription without a repliable destination will return a nil replier
-			fmt.Printf("Received message: %d on topic %s that was not a request message\n", receivedMsgCounter, topicSubscription.GetName())
-		} else {
-			// build reply message
-			replyMsg, replyMsgBuildErr := messageBuilder.BuildWithStringPayload(replyMessageBody + "\nReply from: " + messageBody)
-			if replyMsgBuildErr != nil {
-				panic(replyMsgBuildErr)
+			// JSON requests, e.g. from the blocking requestor started with -json, get a JSON reply,
+			// any other request a text reply
+			fmt.Printf("Received Request Message Body %s \n", codec.Text(message))
+			// fmt.Printf("Request Message Dump %s \n", message)
+			replyMsg, err := samples.Reply(messageBuilder, message)
+			if err != nil {
+				fmt.Println("Invalid request: ", err)
+				continue
 			}
 			// send reply msg
 			// note replier are unique to inbound message provided on the callback with the replier
@@ -121,21 +138,21 @@ func main() {
 				fmt.Println("Got error on send reply, is there a network issue? Error: ", replyErr)
 			}
 		}
-	}
-
-	// Run forever until an interrupt signal is received
-	// Handle interrupts
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
 
-	// Block until a signal is received.
-	<-c
+		return nil
+	})
 
-	// Terminate the Request-Reply Receiver
-	requestReplyReceiver.Terminate(1 * time.Second)
+	// Block until an OS interrupt signal is received, then terminate the
+	// Request-Reply Receiver and disconnect the Message Service
+	exitCode := runner.Run(context.Background())
+	healthChecks.Close()
 	fmt.Println("\nRequest-Reply Receiver Terminated? ", requestReplyReceiver.IsTerminated())
-
-	// Disconnect the Message Service
-	messagingService.Disconnect()
 	fmt.Println("Messaging Service Disconnected? ", !messagingService.IsConnected())
+
+	// Export the spans still buffered by a batch span processor
+	if err := shutdownTracing(context.Background()); err != nil {
+		fmt.Println("Trace export failed: ", err)
+	}
+
+	os.Exit(exitCode)
 }
@@ -1,49 +1,52 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
-	"os/signal"
 	"time"
 
-	"solace.dev/go/messaging"
 	"solace.dev/go/messaging/pkg/solace"
-	"solace.dev/go/messaging/pkg/solace/config"
 	"solace.dev/go/messaging/pkg/solace/message"
 	"solace.dev/go/messaging/pkg/solace/resource"
-)
 
-// getEnv function
-func getEnv(key, def string) string {
-	if val, ok := os.LookupEnv(key); ok {
-		return val
-	}
-	return def
-}
+	"SolaceSamples.com/PubSub+Go/internal/bootstrap"
+	"SolaceSamples.com/PubSub+Go/internal/codec"
+	"SolaceSamples.com/PubSub+Go/internal/health"
+	"SolaceSamples.com/PubSub+Go/internal/lifecycle"
+	"SolaceSamples.com/PubSub+Go/internal/metrics"
+	"SolaceSamples.com/PubSub+Go/internal/samples"
+	"SolaceSamples.com/PubSub+Go/internal/tracing"
+)
 
 func main() {
 	// logging.SetLogLevel(logging.LogLevelInfo)
 
-	// Configuration parameters
-	brokerConfig := config.ServicePropertyMap{
-		config.TransportLayerPropertyHost:                getEnv("SOLACE_HOST", "tcp://localhost:55555,tcp://localhost:55554"),
-		config.ServicePropertyVPNName:                    getEnv("SOLACE_VPN", "default"),
-		config.AuthenticationPropertySchemeBasicPassword: getEnv("SOLACE_PASSWORD", "default"),
-		config.AuthenticationPropertySchemeBasicUserName: getEnv("SOLACE_USERNAME", "default"),
+	// Load the broker settings from flags, environment, profile file or defaults
+	brokerConfig, err := bootstrap.Load()
+	if err != nil {
+		panic(err)
 	}
 
-	messagingService, err := messaging.NewMessagingServiceBuilder().FromConfigurationProvider(brokerConfig).Build()
-
+	// Connect to the messaging service
+	messagingService, err := bootstrap.Connect(brokerConfig)
 	if err != nil {
 		panic(err)
 	}
 
-	// Connect to the messaging serice
-	if err := messagingService.Connect(); err != nil {
+	fmt.Println("Connected to the broker? ", messagingService.IsConnected())
+
+	// Count messages and connection events, served as Prometheus metrics on -metrics-addr when given
+	sampleMetrics, err := metrics.Enable(messagingService)
+	if err != nil {
 		panic(err)
 	}
 
-	fmt.Println("Connected to the broker? ", messagingService.IsConnected())
+	// Trace requests and replies when OTEL_TRACES_EXPORTER is set, see internal/tracing
+	shutdownTracing, err := tracing.Enable(context.Background())
+	if err != nil {
+		panic(err)
+	}
 
 	if err != nil {
 		panic(err)
@@ -60,6 +63,22 @@ func main() {
 	if builderErr != nil {
 		panic(builderErr)
 	}
+	requestReplyReceiver = sampleMetrics.RequestReplyReceiver(requestReplyReceiver)
+	requestReplyReceiver = tracing.NewRequestReplyReceiver(requestReplyReceiver)
+
+	// Serve /readyz from the service and receiver state, and /healthz from a watchdog
+	// that catches a request handler stuck for longer than 30 seconds, on -health-addr when given
+	healthChecks, err := health.Enable()
+	if err != nil {
+		panic(err)
+	}
+	healthChecks.AddService("messaging-service", messagingService)
+	healthChecks.AddReceiver("request-reply-receiver", requestReplyReceiver)
+	handlerWatchdog := healthChecks.Watchdog("request-handler", 30*time.Second)
+
+	// Terminate the receiver and disconnect on SIGINT or SIGTERM
+	runner := lifecycle.NewRunner(messagingService, lifecycle.WithGracePeriod(1*time.Second))
+	runner.Manage(requestReplyReceiver)
 
 	// Start Request-Reply Message Receiver
 	if startErr := requestReplyReceiver.Start(); startErr != nil {
@@ -78,19 +97,10 @@ func main() {
 		receivedMsgCounter++
 		fmt.Printf("Received message: %d\n", receivedMsgCounter)
 
-		var messageBody string
-
-		if payload, ok := message.GetPayloadAsString(); ok {
-			messageBody = payload
-		} else if payload, ok := message.GetPayloadAsBytes(); ok {
-			messageBody = string(payload)
-		}
-
-		fmt.Printf("Received Request Message Body %s \n", messageBody)
+		fmt.Printf("Received Request Message Body %s \n", codec.Text(message))
 		// fmt.Printf("Request Message Dump %s \n", message)
 
-		//  Prepare outbound message payload and body
-		replyMessageBody := "Hello from Go Request-Reply Receiver Replier Sample"
+		//  Prepare outbound message properties
 		messageBuilder := messagingService.MessageBuilder().
 			WithProperty("application", "samples").
 			WithProperty("language", "go")
@@ -100,10 +110,11 @@ func main() {
 			fmt.Printf("Received message: %d on topic %s that was not a request message\n", receivedMsgCounter, topicSubscription.GetName())
 			return
 		}
-		// build reply message
-		replyMsg, replyMsgBuildErr := messageBuilder.BuildWithStringPayload(replyMessageBody + "\nReply from: " + messageBody)
+		// build reply message, a JSON reply to a JSON request and a text reply otherwise
+		replyMsg, replyMsgBuildErr := samples.Reply(messageBuilder, message)
 		if replyMsgBuildErr != nil {
-			panic(replyMsgBuildErr)
+			fmt.Println("Invalid request: ", replyMsgBuildErr)
+			return
 		}
 		// send reply msg
 		// note replier are unique to inbound message provided on the callback with the replier
@@ -115,23 +126,24 @@ func main() {
 	}
 
 	// have receiver push request messages to request message handler
-	if regErr := requestReplyReceiver.ReceiveAsync(MessageHandler); regErr != nil {
+	if regErr := requestReplyReceiver.ReceiveAsync(func(message message.InboundMessage, replier solace.Replier) {
+		defer handlerWatchdog.Begin()()
+		MessageHandler(message, replier)
+	}); regErr != nil {
 		panic(regErr)
 	}
 
-	// Run forever until an interrupt signal is received
-	// Handle interrupts
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
-
-	// Block until a signal is received.
-	<-c
-
-	// Terminate the Request-Reply Receiver
-	requestReplyReceiver.Terminate(1 * time.Second)
+	// Block until an OS interrupt signal is received, then terminate the
+	// Request-Reply Receiver and disconnect the Message Service
+	exitCode := runner.Run(context.Background())
+	healthChecks.Close()
 	fmt.Println("\nRequest-Reply Receiver Terminated? ", requestReplyReceiver.IsTerminated())
-
-	// Disconnect the Message Service
-	messagingService.Disconnect()
 	fmt.Println("Messaging Service Disconnected? ", !messagingService.IsConnected())
+
+	// Export the spans still buffered by a batch span processor
+	if err := shutdownTracing(context.Background()); err != nil {
+		fmt.Println("Trace export failed: ", err)
+	}
+
+	os.Exit(exitCode)
 }
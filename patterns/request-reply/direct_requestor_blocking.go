@@ -1,55 +1,66 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"os"
-	"os/signal"
 	"strconv"
 	"time"
 
-	"solace.dev/go/messaging"
 	"solace.dev/go/messaging/pkg/solace"
 	"solace.dev/go/messaging/pkg/solace/config"
 	"solace.dev/go/messaging/pkg/solace/resource"
+
+	"SolaceSamples.com/PubSub+Go/internal/bootstrap"
+	"SolaceSamples.com/PubSub+Go/internal/codec"
+	"SolaceSamples.com/PubSub+Go/internal/health"
+	"SolaceSamples.com/PubSub+Go/internal/lifecycle"
+	"SolaceSamples.com/PubSub+Go/internal/metrics"
+	"SolaceSamples.com/PubSub+Go/internal/samples"
+	"SolaceSamples.com/PubSub+Go/internal/tracing"
 )
 
-// getEnv function
-func getEnv(key, def string) string {
-	if val, ok := os.LookupEnv(key); ok {
-		return val
-	}
-	return def
-}
+// Publish JSON requests, parsed together with the broker flags
+var publishJSON = flag.Bool("json", false, "send a JSON encoded GreetingRequest and decode the GreetingReply")
 
 func main() {
 	// logging.SetLogLevel(logging.LogLevelInfo)
 
-	// Configuration parameters
-	brokerConfig := config.ServicePropertyMap{
-		config.TransportLayerPropertyHost:                getEnv("SOLACE_HOST", "tcp://localhost:55555,tcp://localhost:55554"),
-		config.ServicePropertyVPNName:                    getEnv("SOLACE_VPN", "default"),
-		config.AuthenticationPropertySchemeBasicPassword: getEnv("SOLACE_PASSWORD", "default"),
-		config.AuthenticationPropertySchemeBasicUserName: getEnv("SOLACE_USERNAME", "default"),
+	// Load the broker settings from flags, environment, profile file or defaults
+	brokerConfig, err := bootstrap.Load()
+	if err != nil {
+		panic(err)
 	}
 
-	messagingService, err := messaging.NewMessagingServiceBuilder().FromConfigurationProvider(brokerConfig).Build()
-
+	// Connect to the messaging service
+	messagingService, err := bootstrap.Connect(brokerConfig)
 	if err != nil {
 		panic(err)
 	}
 
-	// Connect to the messaging serice
-	if err := messagingService.Connect(); err != nil {
+	fmt.Println("Connected to the broker? ", messagingService.IsConnected())
+
+	// Count messages and connection events, served as Prometheus metrics on -metrics-addr when given
+	sampleMetrics, err := metrics.Enable(messagingService)
+	if err != nil {
 		panic(err)
 	}
 
-	fmt.Println("Connected to the broker? ", messagingService.IsConnected())
+	// Trace requests and replies when OTEL_TRACES_EXPORTER is set, see internal/tracing
+	shutdownTracing, err := tracing.Enable(context.Background())
+	if err != nil {
+		panic(err)
+	}
 
 	// Build a Request-Reply Message Publisher
 	requestReplyPublisher, builderErr := messagingService.RequestReply().CreateRequestReplyMessagePublisherBuilder().Build()
 	if builderErr != nil {
 		panic(builderErr)
 	}
+	requestReplyPublisher = sampleMetrics.RequestReplyPublisher(requestReplyPublisher)
+	requestReplyPublisher = tracing.NewRequestReplyPublisher(requestReplyPublisher)
 
 	// Start Request-Reply Message Publisher
 	if startErr := requestReplyPublisher.Start(); startErr != nil {
@@ -77,56 +88,94 @@ func main() {
 	topic := resource.TopicOf("solace/samples/go/direct/request")
 	fmt.Printf("Publishing on: %s, please ensure queue has matching subscription.\n", topic.GetName())
 
-	// Run forever until an interrupt signal is received
-	for requestReplyPublisher.IsReady() {
-		msgSeqNum++
-		message, err := messageBuilder.BuildWithStringPayload(messageBody + " --> " + strconv.Itoa(msgSeqNum))
-		if err != nil {
-			panic(err)
-		}
-
-		fmt.Printf("Publishing message with sequence number: %d on topic: %s\n", msgSeqNum, topic.GetName())
-		// fmt.Printf("Publishing message: %s\n", message)
-
-		// Publish to the given topic
-		// Block until reply message is received
-		replyTimeout := 5 * time.Second
-		// The PublishAwaitResponse() function waits until the specified replyTimeout to receive a published message's reply or waits
-		// indefinitely if replyTimeout value is negative.
-		// Reference: https://pkg.go.dev/solace.dev/go/messaging@v1.6.1/pkg/solace#RequestReplyMessagePublisher
-		messageReply, publishErr := requestReplyPublisher.PublishAwaitResponse(message, topic, replyTimeout, config.MessagePropertyMap{
-			config.MessagePropertyCorrelationID: fmt.Sprint(msgSeqNum),
-		})
-
-		if publishErr == nil { // Good, a reply was received
-			messageReplyPayload, _ := messageReply.GetPayloadAsString()
-			fmt.Printf("The reply inbound payload: %s\n", messageReplyPayload)
-		} else if terr, ok := publishErr.(*solace.TimeoutError); ok { // Not good, a timeout occurred and no reply was received
-			// message should be nil
-			// This handles the situation that the requester application did not receive a reply for the published message within the specified timeout.
-			// This would be a good location for implementing resiliency or retry mechanisms.
-			fmt.Printf("The reply timed out. Error: \" %s\"\n", terr)
-		} else { // async error occurred.
-			panic(publishErr)
-		}
+	// Block until reply message is received
+	replyTimeout := 5 * time.Second
 
-		fmt.Printf("Published message with sequence number: %d on topic: %s\n", msgSeqNum, topic.GetName())
-		// fmt.Printf("Published message: %s\n", message)
-		time.Sleep(1 * time.Second) // wait for a second between published message
+	// Serve /readyz from the service and publisher state, and /healthz from a watchdog
+	// that catches the request loop stuck for longer than 30 seconds, on -health-addr when given
+	healthChecks, err := health.Enable()
+	if err != nil {
+		panic(err)
 	}
+	healthChecks.AddService("messaging-service", messagingService)
+	healthChecks.AddPublisher("request-reply-publisher", requestReplyPublisher)
+	requestWatchdog := healthChecks.Watchdog("request-loop", 30*time.Second)
 
-	// Handle OS interrupts
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
+	// Terminate the publisher and disconnect once the request loop has stopped,
+	// which may take up to a reply timeout
+	runner := lifecycle.NewRunner(messagingService, lifecycle.WithGracePeriod(replyTimeout+1*time.Second))
+	runner.Manage(requestReplyPublisher)
 
-	// Block until an OS interrupt signal is received.
-	<-c
+	// Run forever until an interrupt signal is received
+	runner.Go(func(ctx context.Context) error {
+		for ctx.Err() == nil && requestReplyPublisher.IsReady() {
+			requestWatchdog.Beat()
+			msgSeqNum++
+			fmt.Printf("Publishing message with sequence number: %d on topic: %s\n", msgSeqNum, topic.GetName())
+
+			// Publish to the given topic
+			var publishErr error
+			if *publishJSON {
+				// Encode the request and decode the reply as JSON
+				var reply samples.GreetingReply
+				reply, publishErr = codec.Request[samples.GreetingReply](requestReplyPublisher, messageBuilder, topic, samples.GreetingRequest{Text: messageBody, Sequence: msgSeqNum}, replyTimeout)
+				if publishErr == nil {
+					fmt.Printf("The JSON reply: %+v\n", reply)
+				}
+			} else {
+				message, err := messageBuilder.BuildWithStringPayload(messageBody + " --> " + strconv.Itoa(msgSeqNum))
+				if err != nil {
+					return err
+				}
+				// fmt.Printf("Publishing message: %s\n", message)
+
+				// The PublishAwaitResponse() function waits until the specified replyTimeout to receive a published message's reply or waits
+				// indefinitely if replyTimeout value is negative.
+				// Reference: https://pkg.go.dev/solace.dev/go/messaging@v1.6.1/pkg/solace#RequestReplyMessagePublisher
+				messageReply, err := requestReplyPublisher.PublishAwaitResponse(message, topic, replyTimeout, config.MessagePropertyMap{
+					config.MessagePropertyCorrelationID: fmt.Sprint(msgSeqNum),
+				})
+				if err == nil { // Good, a reply was received
+					fmt.Printf("The reply inbound payload: %s\n", codec.Text(messageReply))
+				}
+				publishErr = err
+			}
+
+			var decodeErr *codec.DecodeError
+			if errors.As(publishErr, &decodeErr) { // a reply was received, but not the expected JSON
+				fmt.Println("The reply could not be decoded: ", decodeErr)
+			} else if terr, ok := publishErr.(*solace.TimeoutError); ok { // Not good, a timeout occurred and no reply was received
+				// message should be nil
+				// This handles the situation that the requester application did not receive a reply for the published message within the specified timeout.
+				// This would be a good location for implementing resiliency or retry mechanisms.
+				fmt.Printf("The reply timed out. Error: \" %s\"\n", terr)
+			} else if publishErr != nil { // async error occurred.
+				return publishErr
+			}
+
+			fmt.Printf("Published message with sequence number: %d on topic: %s\n", msgSeqNum, topic.GetName())
+			// fmt.Printf("Published message: %s\n", message)
+
+			// wait for a second between published message, or stop on interrupt
+			select {
+			case <-ctx.Done():
+			case <-time.After(1 * time.Second):
+			}
+		}
+		return nil
+	})
+
+	// Block until an OS interrupt signal is received, then terminate the
+	// Request-Reply Publisher and disconnect the Message Service
+	exitCode := runner.Run(context.Background())
+	healthChecks.Close()
+	fmt.Println("Request-Reply Publisher Terminated? ", requestReplyPublisher.IsTerminated())
+	fmt.Println("Messaging Service Disconnected? ", !messagingService.IsConnected())
 
-	// Terminate the Request-Reply Receiver
-	requestReplyPublisher.Terminate(1 * time.Second)
-	fmt.Println("\nRequest-Reply Publisher Terminated? ", requestReplyPublisher.IsTerminated())
+	// Export the spans still buffered by a batch span processor
+	if err := shutdownTracing(context.Background()); err != nil {
+		fmt.Println("Trace export failed: ", err)
+	}
 
-	// Disconnect the Message Service
-	messagingService.Disconnect()
-	fmt.Println("Messaging Service Disconnected? ", !messagingService.IsConnected())
+	os.Exit(exitCode)
 }
@@ -1,26 +1,23 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
-	"os/signal"
 	"strconv"
 	"time"
 
-	"solace.dev/go/messaging"
 	"solace.dev/go/messaging/pkg/solace"
 	"solace.dev/go/messaging/pkg/solace/config"
 	"solace.dev/go/messaging/pkg/solace/message"
 	"solace.dev/go/messaging/pkg/solace/resource"
-)
 
-// getEnv function
-func getEnv(key, def string) string {
-	if val, ok := os.LookupEnv(key); ok {
-		return val
-	}
-	return def
-}
+	"SolaceSamples.com/PubSub+Go/internal/bootstrap"
+	"SolaceSamples.com/PubSub+Go/internal/health"
+	"SolaceSamples.com/PubSub+Go/internal/lifecycle"
+	"SolaceSamples.com/PubSub+Go/internal/metrics"
+	"SolaceSamples.com/PubSub+Go/internal/tracing"
+)
 
 // requester reply handler function for reply message, this should also include basic error handling
 func ReplyMessageHandler(message message.InboundMessage, userContext interface{}, err error) {
@@ -40,32 +37,57 @@ func ReplyMessageHandler(message message.InboundMessage, userContext interface{}
 func main() {
 	// logging.SetLogLevel(logging.LogLevelInfo)
 
-	// Configuration parameters
-	brokerConfig := config.ServicePropertyMap{
-		config.TransportLayerPropertyHost:                getEnv("SOLACE_HOST", "tcp://localhost:55555,tcp://localhost:55554"),
-		config.ServicePropertyVPNName:                    getEnv("SOLACE_VPN", "default"),
-		config.AuthenticationPropertySchemeBasicPassword: getEnv("SOLACE_PASSWORD", "default"),
-		config.AuthenticationPropertySchemeBasicUserName: getEnv("SOLACE_USERNAME", "default"),
+	// Load the broker settings from flags, environment, profile file or defaults
+	brokerConfig, err := bootstrap.Load()
+	if err != nil {
+		panic(err)
 	}
 
-	messagingService, err := messaging.NewMessagingServiceBuilder().FromConfigurationProvider(brokerConfig).Build()
-
+	// Connect to the messaging service
+	messagingService, err := bootstrap.Connect(brokerConfig)
 	if err != nil {
 		panic(err)
 	}
 
-	// Connect to the messaging serice
-	if err := messagingService.Connect(); err != nil {
+	fmt.Println("Connected to the broker? ", messagingService.IsConnected())
+
+	// Count messages and connection events, served as Prometheus metrics on -metrics-addr when given
+	sampleMetrics, err := metrics.Enable(messagingService)
+	if err != nil {
 		panic(err)
 	}
 
-	fmt.Println("Connected to the broker? ", messagingService.IsConnected())
+	// Trace requests and replies when OTEL_TRACES_EXPORTER is set, see internal/tracing
+	shutdownTracing, err := tracing.Enable(context.Background())
+	if err != nil {
+		panic(err)
+	}
 
 	// Build a Request-Reply Message Publisher
 	requestReplyPublisher, builderErr := messagingService.RequestReply().CreateRequestReplyMessagePublisherBuilder().Build()
 	if builderErr != nil {
 		panic(builderErr)
 	}
+	requestReplyPublisher = sampleMetrics.RequestReplyPublisher(requestReplyPublisher)
+	requestReplyPublisher = tracing.NewRequestReplyPublisher(requestReplyPublisher)
+
+	// Wait this long for the reply to a request
+	replyTimeout := 5 * time.Second
+
+	// Serve /readyz from the service and publisher state, and /healthz from a watchdog
+	// that catches the request loop stuck for longer than 30 seconds, on -health-addr when given
+	healthChecks, err := health.Enable()
+	if err != nil {
+		panic(err)
+	}
+	healthChecks.AddService("messaging-service", messagingService)
+	healthChecks.AddPublisher("request-reply-publisher", requestReplyPublisher)
+	requestWatchdog := healthChecks.Watchdog("request-loop", 30*time.Second)
+
+	// Terminate the publisher and disconnect once the request loop has stopped,
+	// the outstanding requests may take up to a reply timeout
+	runner := lifecycle.NewRunner(messagingService, lifecycle.WithGracePeriod(replyTimeout+1*time.Second))
+	runner.Manage(requestReplyPublisher)
 
 	// Start Request-Reply Message Publisher
 	if startErr := requestReplyPublisher.Start(); startErr != nil {
@@ -95,47 +117,48 @@ func main() {
 	fmt.Printf("Publishing on: %s, please ensure queue has matching subscription.\n", topic.GetName())
 
 	// Run forever until an interrupt signal is received
-	for requestReplyPublisher.IsReady() {
-		msgSeqNum++
-		message, err := messageBuilder.BuildWithStringPayload(messageBody + " --> " + strconv.Itoa(msgSeqNum))
-		if err != nil {
-			panic(err)
+	runner.Go(func(ctx context.Context) error {
+		for ctx.Err() == nil && requestReplyPublisher.IsReady() {
+			requestWatchdog.Beat()
+			msgSeqNum++
+			message, err := messageBuilder.BuildWithStringPayload(messageBody + " --> " + strconv.Itoa(msgSeqNum))
+			if err != nil {
+				return err
+			}
+
+			// Publish to the given topic
+			publishErr := requestReplyPublisher.Publish(message, ReplyMessageHandler, topic, replyTimeout, config.MessagePropertyMap{
+				config.MessagePropertyCorrelationID: fmt.Sprint(msgSeqNum),
+			}, nil /* usercontext */)
+			// // Publish string message to topic
+			// stringMessage := messageBody + " --> " + strconv.Itoa(msgSeqNum)
+			// publishErr := requestReplyPublisher.PublishString(stringMessage, ReplyMessageHandler, topic, replyTimeout, nil /* usercontext */)
+			// // Publish large Byte message to topic
+			// largeByteArray := make([]byte, 16384)
+			// publishErr := requestReplyPublisher.PublishBytes(largeByteArray, ReplyMessageHandler, topic, replyTimeout, nil /* usercontext */)
+
+			if publishErr != nil {
+				return publishErr
+			}
+
+			fmt.Printf("Published message with sequence number: %d on topic: %s\n", msgSeqNum, topic.GetName())
+			// fmt.Printf("Published message: %s\n", message)
+			lifecycle.Sleep(ctx, 1*time.Second) // wait for a second between published message, or stop on interrupt
 		}
+		return nil
+	})
 
-		replyTimeout := 5 * time.Second
-
-		// Publish to the given topic
-		publishErr := requestReplyPublisher.Publish(message, ReplyMessageHandler, topic, replyTimeout, config.MessagePropertyMap{
-			config.MessagePropertyCorrelationID: fmt.Sprint(msgSeqNum),
-		}, nil /* usercontext */)
-		// // Publish string message to topic
-		// stringMessage := messageBody + " --> " + strconv.Itoa(msgSeqNum)
-		// publishErr := requestReplyPublisher.PublishString(stringMessage, ReplyMessageHandler, topic, replyTimeout, nil /* usercontext */)
-		// // Publish large Byte message to topic
-		// largeByteArray := make([]byte, 16384)
-		// publishErr := requestReplyPublisher.PublishBytes(largeByteArray, ReplyMessageHandler, topic, replyTimeout, nil /* usercontext */)
-
-		if publishErr != nil {
-			panic(publishErr)
-		}
+	// Block until an OS interrupt signal is received, then terminate the
+	// Request-Reply Publisher and disconnect the Message Service
+	exitCode := runner.Run(context.Background())
+	healthChecks.Close()
+	fmt.Println("\nRequest-Reply Publisher Terminated? ", requestReplyPublisher.IsTerminated())
+	fmt.Println("Messaging Service Disconnected? ", !messagingService.IsConnected())
 
-		fmt.Printf("Published message with sequence number: %d on topic: %s\n", msgSeqNum, topic.GetName())
-		// fmt.Printf("Published message: %s\n", message)
-		time.Sleep(1 * time.Second) // wait for a second between published message
+	// Export the spans still buffered by a batch span processor
+	if err := shutdownTracing(context.Background()); err != nil {
+		fmt.Println("Trace export failed: ", err)
 	}
 
-	// Handle OS interrupts
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
-
-	// Block until an OS interrupt signal is received.
-	<-c
-
-	// Terminate the Request-Reply Receiver
-	requestReplyPublisher.Terminate(1 * time.Second)
-	fmt.Println("\nRequest-Reply Publisher Terminated? ", requestReplyPublisher.IsTerminated())
-
-	// Disconnect the Message Service
-	messagingService.Disconnect()
-	fmt.Println("Messaging Service Disconnected? ", !messagingService.IsConnected())
+	os.Exit(exitCode)
 }